@@ -0,0 +1,12 @@
+// Package assets builds the configuration files that the API cannot run without into the binary
+package assets
+
+import (
+	_ "embed"
+)
+
+// PenaltyDetails is the copy of penalty_details.yml built into the binary. It is used until the penalty details are
+// loaded from the file deployed with the API.
+//
+//go:embed penalty_details.yml
+var PenaltyDetails []byte
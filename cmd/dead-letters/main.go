@@ -0,0 +1,160 @@
+//coverage:ignore file
+
+// Command dead-letters lists, inspects and replays the penalty payments processing messages that were dead-lettered
+// because they could not be processed, using the admin endpoints of the penalty payment API.
+//
+// Usage:
+//
+//	dead-letters [flags] list [-status quarantined|replayed] [-limit n]
+//	dead-letters [flags] inspect [-value-out file] <id>
+//	dead-letters [flags] replay <id>
+//
+// The API key must have elevated privileges and is read from the PENALTY_PAYMENT_API_KEY environment variable unless
+// the -api-key flag is given.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api/common/deadletter"
+)
+
+const adminPath = "/penalty-payment-api/admin/dead-letters"
+
+type client struct {
+	apiURL string
+	apiKey string
+	http   *http.Client
+}
+
+func main() {
+	apiURL := flag.String("api-url", "http://localhost:8080", "base URL of the penalty payment API")
+	apiKey := flag.String("api-key", os.Getenv("PENALTY_PAYMENT_API_KEY"), "API key with elevated privileges")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	c := &client{apiURL: *apiURL, apiKey: *apiKey, http: &http.Client{Timeout: 30 * time.Second}}
+
+	var err error
+	switch command, args := flag.Arg(0), flag.Args()[1:]; command {
+	case "list":
+		err = c.list(args)
+	case "inspect":
+		err = c.inspect(args)
+	case "replay":
+		err = c.replay(args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] list|inspect|replay [args]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// list prints a line for each dead-lettered message
+func (c *client) list(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	status := flags.String("status", "", "only list messages with the status, quarantined or replayed")
+	limit := flags.Int("limit", 50, "maximum number of messages to list")
+	_ = flags.Parse(args)
+
+	query := url.Values{"limit": {strconv.Itoa(*limit)}}
+	if *status != "" {
+		query.Set("status", *status)
+	}
+
+	var messages []deadletter.Message
+	if err := c.do(http.MethodGet, adminPath+"?"+query.Encode(), &messages); err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n", m.ID, m.DeadLetteredAt.Format(time.RFC3339), m.Status, m.Reason, m.Error)
+	}
+	return nil
+}
+
+// inspect prints a dead-lettered message as JSON, optionally writing its original bytes to a file
+func (c *client) inspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	valueOut := flags.String("value-out", "", "file to write the original message bytes to")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("inspect needs the id of a dead-lettered message")
+	}
+
+	var message deadletter.Message
+	if err := c.do(http.MethodGet, adminPath+"/"+url.PathEscape(flags.Arg(0)), &message); err != nil {
+		return err
+	}
+
+	if *valueOut != "" {
+		if err := os.WriteFile(*valueOut, message.Value, 0o600); err != nil {
+			return fmt.Errorf("error writing message bytes: %w", err)
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(message)
+}
+
+// replay publishes a dead-lettered message to the penalty payments processing topic again
+func (c *client) replay(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("replay needs the id of a dead-lettered message")
+	}
+
+	var message deadletter.Message
+	if err := c.do(http.MethodPost, adminPath+"/"+url.PathEscape(args[0])+"/replay", &message); err != nil {
+		return err
+	}
+
+	fmt.Printf("replayed %s\n", message.ID)
+	return nil
+}
+
+func (c *client) do(method, path string, out interface{}) error {
+	req, err := http.NewRequest(method, c.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.apiKey, "")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s failed with status [%d]: %s", method, path, resp.StatusCode, body)
+	}
+
+	return json.Unmarshal(body, out)
+}
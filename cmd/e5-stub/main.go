@@ -0,0 +1,66 @@
+//coverage:ignore file
+
+// Command e5-stub serves a stand in for the E5 AR Transactions and Payments API from scenario files, so that the
+// penalty payment API can be run locally and tested without access to E5. Point E5_API_URL at the stub to use it.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api/testutils/e5stub"
+)
+
+func main() {
+	bindAddr := flag.String("bind-addr", ":8081", "address the stub listens on")
+	scenarios := flag.String("scenarios", "cmd/e5-stub/scenarios", "scenario file, or directory of scenario files")
+	flag.Parse()
+
+	log.Namespace = "e5-stub"
+
+	scenario, err := e5stub.LoadScenarios(*scenarios)
+	if err != nil {
+		log.Error(fmt.Errorf("error loading scenarios: %s. Exiting", err), nil)
+		os.Exit(1)
+	}
+
+	stub, err := e5stub.NewServer(scenario)
+	if err != nil {
+		log.Error(fmt.Errorf("error loading scenarios: %s. Exiting", err), nil)
+		os.Exit(1)
+	}
+
+	h := &http.Server{
+		Addr:    *bindAddr,
+		Handler: stub,
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		log.Info("starting E5 stub...", log.Data{"port": *bindAddr, "scenarios": *scenarios})
+		err := h.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error(err)
+			os.Exit(1)
+		}
+	}()
+
+	<-stop
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Shutdown(shutdownCtx); err != nil {
+		log.Error(fmt.Errorf("failed to shutdown E5 stub gracefully: [%v]", err))
+	}
+}
@@ -0,0 +1,116 @@
+//coverage:ignore file
+
+// Command recover-payments runs the recovery job of the penalty payment API, which marks as paid the payable
+// resources that are still pending although one of their payment sessions was paid, e.g. because the payments API
+// never called back. The service runs the job itself on a schedule, so this is for running it on demand.
+//
+// Usage:
+//
+//	recover-payments [flags] [-dry-run] [-min-age duration] [-session payable_ref=payment_id ...]
+//
+// The API key must have elevated privileges and is read from the PENALTY_PAYMENT_API_KEY environment variable unless
+// the -api-key flag is given. The command exits with status 1 if any payable resource could not be checked or marked
+// as paid.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/recovery"
+)
+
+const recoveryPath = "/penalty-payment-api/admin/recovery"
+
+// sessions collects the -session flags into payment session IDs by payable ref
+type sessions map[string][]string
+
+func (s sessions) String() string {
+	return fmt.Sprint(map[string][]string(s))
+}
+
+func (s sessions) Set(value string) error {
+	payableRef, paymentID, ok := strings.Cut(value, "=")
+	if !ok || payableRef == "" || paymentID == "" {
+		return fmt.Errorf("session must be payable_ref=payment_id")
+	}
+	s[payableRef] = append(s[payableRef], paymentID)
+	return nil
+}
+
+func main() {
+	apiURL := flag.String("api-url", "http://localhost:8080", "base URL of the penalty payment API")
+	apiKey := flag.String("api-key", os.Getenv("PENALTY_PAYMENT_API_KEY"), "API key with elevated privileges")
+	dryRun := flag.Bool("dry-run", false, "report the payable resources that would be marked as paid without marking them")
+	minAge := flag.String("min-age", "", "how long a payable resource must have been pending, defaults to RECOVERY_MIN_AGE of the API")
+	paymentSessions := sessions{}
+	flag.Var(paymentSessions, "session", "payment session to check for a payable resource as payable_ref=payment_id, may be repeated")
+	flag.Parse()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"dry_run":          *dryRun,
+		"min_age":          *minAge,
+		"payment_sessions": paymentSessions,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	report, err := run(&http.Client{Timeout: 5 * time.Minute}, *apiURL, *apiKey, body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, resource := range report.Resources {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", resource.CustomerCode, resource.PayableRef, resource.Result,
+			resource.PaymentID, resource.PaymentStatus, resource.Error)
+		if resource.Result == recovery.Failed {
+			failed++
+		}
+	}
+	fmt.Printf("checked %d payable resources created before %s, recovered %d, failed %d (dry run: %t)\n",
+		report.Checked, report.CreatedBefore.Format(time.RFC3339), report.Recovered, failed, report.DryRun)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func run(client *http.Client, apiURL, apiKey string, body []byte) (*recovery.Report, error) {
+	req, err := http.NewRequest(http.MethodPost, apiURL+recoveryPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(apiKey, "")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("POST %s failed with status [%d]: %s", recoveryPath, resp.StatusCode, respBody)
+	}
+
+	var report recovery.Report
+	if err = json.Unmarshal(respBody, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
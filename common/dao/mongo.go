@@ -3,6 +3,7 @@ package dao
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -11,9 +12,12 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api-core/constants"
 	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/deadletter"
 	"github.com/companieshouse/penalty-payment-api/common/e5"
 	"github.com/companieshouse/penalty-payment-api/common/interfaces"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
 )
 
 // mongoClientProviderImpl is the concrete implementation of MongoClientProvider
@@ -70,6 +74,10 @@ func (m *MongoCollectionWrapper) InsertOne(ctx context.Context, document interfa
 	return m.collection.InsertOne(ctx, document, opts...)
 }
 
+func (m *MongoCollectionWrapper) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return m.collection.Find(ctx, filter, opts...)
+}
+
 func (m *MongoCollectionWrapper) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
 	return m.collection.FindOne(ctx, filter, opts...)
 }
@@ -85,9 +93,32 @@ func (m *MongoCollectionWrapper) DeleteOne(ctx context.Context, filter interface
 // MongoPayableResourceService is an implementation of the PayableResourceDaoService interface using
 // MongoDB as the backend driver.
 type MongoPayableResourceService struct {
-	mongoClientProvider interfaces.MongoClientProvider
-	db                  interfaces.MongoDatabaseInterface
-	CollectionName      string
+	mongoClientProvider  interfaces.MongoClientProvider
+	db                   interfaces.MongoDatabaseInterface
+	DatabaseName         string
+	CollectionName       string
+	OutboxCollectionName string
+}
+
+// runTransaction runs fn in a transaction so that the writes it makes with the context it is given are committed
+// together or not at all. Transactions need MongoDB to be running as a replica set.
+var runTransaction = func(mongoClientProvider interfaces.MongoClientProvider, fn func(ctx context.Context) error) error {
+	session, err := mongoClientProvider.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// createIndex creates the index on the collection unless it already exists
+var createIndex = func(mongoClientProvider interfaces.MongoClientProvider, database, collection string, index mongo.IndexModel) error {
+	_, err := mongoClientProvider.Database(database).Collection(collection).Indexes().CreateOne(context.Background(), index)
+	return err
 }
 
 // MongoAccountPenaltiesService is an implementation of the AccountPenaltiesDaoService interface using
@@ -243,6 +274,57 @@ func (m *MongoAccountPenaltiesService) UpdateAccountPenaltyAsPaid(customerCode s
 	return nil
 }
 
+// UpdateAccountPenaltyAsPartPaid will reduce the outstanding amount of a penalty in account_penalties database
+// collection by the amount paid, if the amount paid is less than the outstanding amount. It returns false if the
+// penalty was not updated because the amount paid pays off the penalty.
+func (m *MongoAccountPenaltiesService) UpdateAccountPenaltyAsPartPaid(customerCode string, companyCode string, penaltyRef string,
+	amountPaid float64, requestId string) (bool, error) {
+	logContext := log.Data{
+		"customer_code": customerCode,
+		"company_code":  companyCode,
+		"penalty_ref":   penaltyRef,
+		"amount_paid":   amountPaid,
+	}
+	log.InfoC(requestId, "updating penalty as part paid in account_penalties collection", logContext)
+
+	filter := bson.M{
+		"customer_code": customerCode,
+		"company_code":  companyCode,
+		"data": bson.M{"$elemMatch": bson.M{
+			"transaction_reference": penaltyRef,
+			"outstanding_amount":    bson.M{"$gt": amountPaid},
+		}},
+	}
+
+	closedAt := time.Now().Truncate(time.Millisecond)
+
+	update := bson.D{
+		{
+			"$inc", bson.M{"data.$.outstanding_amount": -amountPaid},
+		},
+		{
+			"$set", bson.M{"closed_at": closedAt},
+		},
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, logContext)
+		return false, err
+	}
+
+	if result.ModifiedCount == 0 {
+		log.InfoC(requestId, "penalty not updated as part paid in account_penalties collection as the amount paid pays it off", logContext)
+		return false, nil
+	}
+
+	log.InfoC(requestId, "successfully updated penalty as part paid in account_penalties collection", logContext)
+
+	return true, nil
+}
+
 // UpdateAccountPenalties updates the created_at, closed_at and data fields of an existing document
 func (m *MongoAccountPenaltiesService) UpdateAccountPenalties(dao *models.AccountPenaltiesDao, requestId string) error {
 	log.InfoC(requestId, "updating existing document in account_penalties collection", log.Data{
@@ -291,26 +373,38 @@ func (m *MongoAccountPenaltiesService) UpdateAccountPenalties(dao *models.Accoun
 	return nil
 }
 
-// SaveE5Error will update the resource by flagging an error in e5 for a particular action
-func (m *MongoPayableResourceService) SaveE5Error(customerCode, payableRef, requestId string, action e5.Action) error {
+// SaveE5Error will update the resource by flagging an error in e5 for a particular action, along with the E5 status
+// and message code when E5 rejected the request
+func (m *MongoPayableResourceService) SaveE5Error(customerCode, payableRef, requestId string, action e5.Action, e5Err error) error {
 	dao, err := m.GetPayableResource(customerCode, payableRef, requestId)
 	if err != nil {
 		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
 		return err
 	}
 
+	var statusCode int
+	var apiErr *e5.APIError
+	if errors.As(e5Err, &apiErr) {
+		statusCode = apiErr.StatusCode
+	}
+	messageCode := e5.MessageCode(e5Err)
+
 	filter := bson.M{"_id": dao.ID}
 	update := bson.D{
 		{
 			Key: "$set", Value: bson.D{
 				{Key: "e5_command_error", Value: string(action)},
+				{Key: "e5_command_error_status", Value: statusCode},
+				{Key: "e5_command_error_message_code", Value: messageCode},
 			},
 		},
 	}
 
 	collection := m.db.Collection(m.CollectionName)
 
-	log.DebugC(requestId, "updating e5 command error in mongo document", log.Data{"_id": dao.ID, "customer_code": dao.CustomerCode, "payable_ref": dao.PayableRef, "e5_command_error": action})
+	log.DebugC(requestId, "updating e5 command error in mongo document", log.Data{"_id": dao.ID, "customer_code": dao.CustomerCode,
+		"payable_ref": dao.PayableRef, "e5_command_error": action, "e5_command_error_status": statusCode,
+		"e5_command_error_message_code": messageCode})
 
 	_, err = collection.UpdateOne(context.Background(), filter, update)
 	if err != nil {
@@ -321,6 +415,414 @@ func (m *MongoPayableResourceService) SaveE5Error(customerCode, payableRef, requ
 	return nil
 }
 
+// SaveE5Compensation will update the resource with the outcome of the timeout or reject issued to E5 to unlock the
+// customer account after a payment failed part way through. A successful compensation closes the payment in E5, so the
+// E5 progress is replaced with the time of the compensation and the payment is created again if it is processed again.
+func (m *MongoPayableResourceService) SaveE5Compensation(customerCode, payableRef, requestId string, action e5.Action, compensationErr error) error {
+	dao, err := m.GetPayableResource(customerCode, payableRef, requestId)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return err
+	}
+
+	var compensationError string
+	if compensationErr != nil {
+		compensationError = compensationErr.Error()
+	}
+
+	compensatedAt := time.Now().Truncate(time.Millisecond)
+	fields := bson.D{
+		{Key: "e5_compensation_action", Value: string(action)},
+		{Key: "e5_compensation_succeeded", Value: compensationErr == nil},
+		{Key: "e5_compensation_error", Value: compensationError},
+		{Key: "e5_compensation_message_code", Value: e5.MessageCode(compensationErr)},
+		{Key: "e5_compensation_at", Value: compensatedAt},
+	}
+	if compensationErr == nil {
+		fields = append(fields, bson.E{Key: "e5_progress", Value: e5.PaymentProgress{CompensatedAt: &compensatedAt}})
+	}
+
+	filter := bson.M{"_id": dao.ID}
+	update := bson.D{{Key: "$set", Value: fields}}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	log.DebugC(requestId, "updating e5 compensation in mongo document", log.Data{"_id": dao.ID, "customer_code": dao.CustomerCode,
+		"payable_ref": dao.PayableRef, "e5_compensation_action": action, "e5_compensation_succeeded": compensationErr == nil})
+
+	_, err = collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"_id": dao.ID, "customer_code": dao.CustomerCode, "payable_ref": dao.PayableRef})
+		return err
+	}
+
+	return nil
+}
+
+// e5ProgressFields maps each step of marking a payment as paid in E5 to the field its completion time is stored in
+var e5ProgressFields = map[e5.Action]string{
+	e5.CreateAction:    "e5_progress.created_at",
+	e5.AuthoriseAction: "e5_progress.authorised_at",
+	e5.ConfirmAction:   "e5_progress.confirmed_at",
+}
+
+// SaveE5Progress will update the resource with the time a step of marking the payment as paid in E5 succeeded
+func (m *MongoPayableResourceService) SaveE5Progress(customerCode, payableRef, requestId string, action e5.Action) error {
+	field, ok := e5ProgressFields[action]
+	if !ok {
+		return fmt.Errorf("no progress recorded for E5 action [%s]", action)
+	}
+
+	filter := bson.M{"payable_ref": payableRef, "customer_code": customerCode}
+	update := bson.D{
+		{
+			Key: "$set", Value: bson.D{
+				{Key: field, Value: time.Now().Truncate(time.Millisecond)},
+			},
+		},
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	log.DebugC(requestId, "updating e5 progress in mongo document", log.Data{"customer_code": customerCode, "payable_ref": payableRef, "e5_action": action})
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return err
+	}
+	if result != nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return err
+	}
+
+	return nil
+}
+
+// GetE5Progress gets the steps of marking the payment as paid in E5 that have succeeded
+func (m *MongoPayableResourceService) GetE5Progress(customerCode, payableRef, requestId string) (*e5.PaymentProgress, error) {
+	var resource struct {
+		E5Progress e5.PaymentProgress `bson:"e5_progress"`
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+	dbResource := collection.FindOne(context.Background(), bson.M{"payable_ref": payableRef, "customer_code": customerCode},
+		options.FindOne().SetProjection(bson.M{"e5_progress": 1}))
+
+	err := dbResource.Err()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.DebugC(requestId, "no payable resource found", log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+			return nil, err
+		}
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return nil, err
+	}
+
+	err = dbResource.Decode(&resource)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return nil, err
+	}
+
+	return &resource.E5Progress, nil
+}
+
+// RecordPaymentSession adds the payment session ID to those recorded for the resource, once however many times it is
+// recorded, and records when a payment session was last recorded so that an abandoned resource can still be expired
+func (m *MongoPayableResourceService) RecordPaymentSession(customerCode, payableRef, paymentID, requestId string) error {
+	filter := bson.M{"payable_ref": payableRef, "customer_code": customerCode}
+	update := bson.M{
+		"$addToSet": bson.M{"payment_sessions": paymentID},
+		"$set":      bson.M{"payment_session_recorded_at": time.Now().UTC()},
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	log.DebugC(requestId, "recording payment session in mongo document", log.Data{"customer_code": customerCode,
+		"payable_ref": payableRef, "payment_id": paymentID})
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef, "payment_id": paymentID})
+		return err
+	}
+	if result != nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef, "payment_id": paymentID})
+		return err
+	}
+
+	return nil
+}
+
+// RecordUnidentifiedPaymentSession records when a payment session was last started to pay the resource without its ID
+// being given. The recovery job cannot check a session it does not know, so a resource with one is never expired.
+func (m *MongoPayableResourceService) RecordUnidentifiedPaymentSession(customerCode, payableRef, requestId string) error {
+	filter := bson.M{"payable_ref": payableRef, "customer_code": customerCode}
+	update := bson.M{"$set": bson.M{"unidentified_payment_session_at": time.Now().UTC()}}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	log.DebugC(requestId, "recording unidentified payment session in mongo document", log.Data{"customer_code": customerCode,
+		"payable_ref": payableRef})
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return err
+	}
+	if result != nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return err
+	}
+
+	return nil
+}
+
+// GetPaymentSessions gets the IDs of the payment sessions recorded for the resource, in the order they were recorded
+func (m *MongoPayableResourceService) GetPaymentSessions(customerCode, payableRef, requestId string) ([]string, error) {
+	var resource struct {
+		PaymentSessions []string `bson:"payment_sessions"`
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+	dbResource := collection.FindOne(context.Background(), bson.M{"payable_ref": payableRef, "customer_code": customerCode},
+		options.FindOne().SetProjection(bson.M{"payment_sessions": 1}))
+
+	err := dbResource.Err()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.DebugC(requestId, "no payable resource found", log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+			return nil, err
+		}
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return nil, err
+	}
+
+	err = dbResource.Decode(&resource)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return nil, err
+	}
+
+	return resource.PaymentSessions, nil
+}
+
+// SaveFailedMarkAsPaidSteps will update the resource with the steps of marking it as paid that failed e.g. email or
+// finance, replacing those saved before, so that a retried payment only runs those steps again
+func (m *MongoPayableResourceService) SaveFailedMarkAsPaidSteps(customerCode, payableRef string, steps []string, requestId string) error {
+	filter := bson.M{"payable_ref": payableRef, "customer_code": customerCode}
+	update := bson.M{"$set": bson.M{"failed_mark_as_paid_steps": steps}}
+	if len(steps) == 0 {
+		update = bson.M{"$unset": bson.M{"failed_mark_as_paid_steps": ""}}
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	log.DebugC(requestId, "updating failed mark as paid steps in mongo document", log.Data{"customer_code": customerCode,
+		"payable_ref": payableRef, "failed_steps": steps})
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return err
+	}
+	if result != nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return err
+	}
+
+	return nil
+}
+
+// GetFailedMarkAsPaidSteps gets the steps of marking the resource as paid that were saved as failed. A resource with no
+// failed steps saved, including one paid before they were saved, has none.
+func (m *MongoPayableResourceService) GetFailedMarkAsPaidSteps(customerCode, payableRef, requestId string) ([]string, error) {
+	var resource struct {
+		FailedSteps []string `bson:"failed_mark_as_paid_steps"`
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+	dbResource := collection.FindOne(context.Background(), bson.M{"payable_ref": payableRef, "customer_code": customerCode},
+		options.FindOne().SetProjection(bson.M{"failed_mark_as_paid_steps": 1}))
+
+	err := dbResource.Err()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.DebugC(requestId, "no payable resource found", log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+			return nil, err
+		}
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return nil, err
+	}
+
+	err = dbResource.Decode(&resource)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return nil, err
+	}
+
+	return resource.FailedSteps, nil
+}
+
+// AddOutboxMessages inserts the messages into the outbox. A message that is already in the outbox is skipped, as the ID
+// of a message is derived from its payable resource and kind, so the same message is never published twice.
+func (m *MongoPayableResourceService) AddOutboxMessages(messages []outbox.Message, requestId string) error {
+	collection := m.db.Collection(m.OutboxCollectionName)
+
+	for i := range messages {
+		logContext := log.Data{"outbox_id": messages[i].ID, "kind": messages[i].Kind, "payable_ref": messages[i].PayableRef}
+
+		log.DebugC(requestId, "adding message to outbox", logContext)
+		_, err := collection.InsertOne(context.Background(), messages[i])
+		if mongo.IsDuplicateKeyError(err) {
+			log.InfoC(requestId, "message is already in the outbox", logContext)
+			continue
+		}
+		if err != nil {
+			log.ErrorC(requestId, err, logContext)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetStalePendingPayableResources finds the oldest resources created between createdAfter and createdBefore that are
+// still pending payment
+func (m *MongoPayableResourceService) GetStalePendingPayableResources(createdAfter, createdBefore time.Time, limit int, requestId string) ([]models.PayableResourceDao, error) {
+	filter := bson.M{
+		"data.payment.status": constants.Pending.String(),
+		"data.created_at":     bson.M{"$gt": createdAfter, "$lt": createdBefore},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "data.created_at", Value: 1}}).SetLimit(int64(limit))
+
+	collection := m.db.Collection(m.CollectionName)
+
+	ctx := context.Background()
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"created_after": createdAfter, "created_before": createdBefore})
+		return nil, err
+	}
+
+	resources := []models.PayableResourceDao{}
+	if err = cursor.All(ctx, &resources); err != nil {
+		log.ErrorC(requestId, err, log.Data{"created_after": createdAfter, "created_before": createdBefore})
+		return nil, err
+	}
+
+	return resources, nil
+}
+
+// GetExpirablePayableResources finds the oldest resources created between createdAfter and createdBefore that are
+// still pending payment, have no payment session recorded since createdBefore and never had an unidentified payment
+// session, which are the only resources that can be expired
+func (m *MongoPayableResourceService) GetExpirablePayableResources(createdAfter, createdBefore time.Time, limit int, requestId string) ([]models.PayableResourceDao, error) {
+	filter := bson.M{
+		"data.payment.status":             constants.Pending.String(),
+		"data.created_at":                 bson.M{"$gt": createdAfter, "$lt": createdBefore},
+		"$or":                             noPaymentSessionSince(createdBefore),
+		"unidentified_payment_session_at": bson.M{"$exists": false},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "data.created_at", Value: 1}}).SetLimit(int64(limit))
+
+	collection := m.db.Collection(m.CollectionName)
+
+	ctx := context.Background()
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"created_after": createdAfter, "created_before": createdBefore})
+		return nil, err
+	}
+
+	resources := []models.PayableResourceDao{}
+	if err = cursor.All(ctx, &resources); err != nil {
+		log.ErrorC(requestId, err, log.Data{"created_after": createdAfter, "created_before": createdBefore})
+		return nil, err
+	}
+
+	return resources, nil
+}
+
+// ExpirePayableResource sets the payment status of the resource to expired, recording when it expired so that the TTL
+// index deletes it later. A resource that has been paid, or has had a payment session recorded since
+// sessionsRecordedBefore that the recovery job may yet find was paid, is not expired. Nor is one that had an
+// unidentified payment session, as the recovery job cannot check whether it was paid.
+func (m *MongoPayableResourceService) ExpirePayableResource(customerCode, payableRef string, expiredAt, sessionsRecordedBefore time.Time, requestId string) (bool, error) {
+	filter := bson.M{
+		"payable_ref":                     payableRef,
+		"customer_code":                   customerCode,
+		"data.payment.status":             constants.Pending.String(),
+		"$or":                             noPaymentSessionSince(sessionsRecordedBefore),
+		"unidentified_payment_session_at": bson.M{"$exists": false},
+	}
+	update := bson.M{"$set": bson.M{"data.payment.status": PaymentStatusExpired, "data.expired_at": expiredAt}}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return false, err
+	}
+
+	return result != nil && result.ModifiedCount > 0, nil
+}
+
+// noPaymentSessionSince matches the resources that have had no payment session recorded, or had the last one
+// recorded before the time, so that a resource whose payment sessions were abandoned can still be expired
+func noPaymentSessionSince(recordedBefore time.Time) bson.A {
+	return bson.A{
+		bson.M{"payment_sessions": bson.M{"$exists": false}},
+		bson.M{"payment_session_recorded_at": bson.M{"$lt": recordedBefore}},
+	}
+}
+
+// CancelPayableResource sets the payment status of the resource to cancelled, recording when it was cancelled. A
+// resource that has been paid or has expired is not cancelled.
+func (m *MongoPayableResourceService) CancelPayableResource(customerCode, payableRef string, cancelledAt time.Time, requestId string) (bool, error) {
+	filter := bson.M{
+		"payable_ref":         payableRef,
+		"customer_code":       customerCode,
+		"data.payment.status": constants.Pending.String(),
+	}
+	update := bson.M{"$set": bson.M{"data.payment.status": PaymentStatusCancelled, "data.cancelled_at": cancelledAt}}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"customer_code": customerCode, "payable_ref": payableRef})
+		return false, err
+	}
+
+	return result != nil && result.ModifiedCount > 0, nil
+}
+
+// EnsureExpiredTTLIndex creates the TTL index on the expiry time of the resources, so that mongo deletes expired
+// resources ttl after they expired. The index only covers expired resources as no others have an expiry time. Once
+// the index exists, changing ttl needs the index to be changed with collMod.
+func (m *MongoPayableResourceService) EnsureExpiredTTLIndex(ttl time.Duration) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "data.expired_at", Value: 1}},
+		Options: options.Index().SetName("expired_at_ttl").SetExpireAfterSeconds(int32(ttl.Seconds())),
+	}
+
+	err := createIndex(m.mongoClientProvider, m.DatabaseName, m.CollectionName, index)
+	if err != nil {
+		log.Error(fmt.Errorf("error creating expired payable resources TTL index: [%v]", err), log.Data{"ttl": ttl.String()})
+		return err
+	}
+
+	log.Info("expired payable resources TTL index created", log.Data{"ttl": ttl.String()})
+	return nil
+}
+
 // CreatePayableResource will store the payable request into the database
 func (m *MongoPayableResourceService) CreatePayableResource(dao *models.PayableResourceDao, requestId string) error {
 
@@ -363,9 +865,12 @@ func (m *MongoPayableResourceService) GetPayableResource(customerCode, payableRe
 	return &resource, nil
 }
 
-// UpdatePaymentDetails will save the document back to Mongo
-func (m *MongoPayableResourceService) UpdatePaymentDetails(dao *models.PayableResourceDao, requestId string) error {
-	filter := bson.M{"_id": dao.ID}
+// UpdatePaymentDetails will save the document back to Mongo. Any messages are inserted into the outbox in the same
+// transaction, so the payment is never recorded without the messages that tell the rest of the system about it. The
+// update only matches a resource that is not yet paid, expired or cancelled, so two requests marking the same resource
+// as paid cannot both record a payment, and a resource expired or cancelled since it was read is not paid.
+func (m *MongoPayableResourceService) UpdatePaymentDetails(dao *models.PayableResourceDao, messages []outbox.Message, requestId string) error {
+	filter := bson.M{"_id": dao.ID, "data.payment.status": bson.M{"$nin": bson.A{constants.Paid.String(), PaymentStatusExpired, PaymentStatusCancelled}}}
 
 	update := bson.D{
 		{
@@ -378,17 +883,52 @@ func (m *MongoPayableResourceService) UpdatePaymentDetails(dao *models.PayableRe
 		},
 	}
 
-	collection := m.db.Collection(m.CollectionName)
+	logContext := log.Data{"_id": dao.ID, "customer_code": dao.CustomerCode, "payable_ref": dao.PayableRef}
 
-	log.DebugC(requestId, "updating payment details in mongo document", log.Data{"_id": dao.ID, "customer_code": dao.CustomerCode, "payable_ref": dao.PayableRef})
+	updatePayment := func(ctx context.Context) error {
+		collection := m.db.Collection(m.CollectionName)
 
-	_, err := collection.UpdateOne(context.Background(), filter, update)
+		log.DebugC(requestId, "updating payment details in mongo document", logContext)
+
+		result, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+		if result != nil && result.MatchedCount == 0 {
+			return ErrPaymentAlreadyRecorded
+		}
+		return nil
+	}
+
+	var err error
+	if len(messages) == 0 {
+		err = updatePayment(context.Background())
+	} else {
+		err = runTransaction(m.mongoClientProvider, func(ctx context.Context) error {
+			if err := updatePayment(ctx); err != nil {
+				return err
+			}
+
+			outboxCollection := m.db.Collection(m.OutboxCollectionName)
+			for i := range messages {
+				log.DebugC(requestId, "adding message to outbox", logContext, log.Data{"outbox_id": messages[i].ID, "kind": messages[i].Kind})
+				if _, err := outboxCollection.InsertOne(ctx, messages[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if errors.Is(err, ErrPaymentAlreadyRecorded) {
+		log.InfoC(requestId, "payment details not updated as the payable resource is already paid", logContext)
+		return err
+	}
 	if err != nil {
-		log.ErrorC(requestId, err, log.Data{"_id": dao.ID, "customer_code": dao.CustomerCode, "payable_ref": dao.PayableRef})
+		log.ErrorC(requestId, err, logContext)
 		return err
 	}
 
-	log.DebugC(requestId, "updated payment details in mongo document", log.Data{"_id": dao.ID, "customer_code": dao.CustomerCode, "payable_ref": dao.PayableRef})
+	log.DebugC(requestId, "updated payment details in mongo document", logContext, log.Data{"outbox_messages": len(messages)})
 
 	return nil
 }
@@ -405,3 +945,296 @@ func (m *MongoPayableResourceService) Shutdown() {
 		log.Info("disconnected from mongodb successfully")
 	}
 }
+
+// processed message statuses recorded in the processed messages collection
+const (
+	messageProcessing = "processing"
+	messageProcessed  = "processed"
+)
+
+// MongoProcessedMessageService is an implementation of the ProcessedMessageDaoService interface using
+// MongoDB as the backend driver.
+type MongoProcessedMessageService struct {
+	db             interfaces.MongoDatabaseInterface
+	CollectionName string
+}
+
+// ClaimMessage upserts the processed message document for the E5 payment ID unless it has already been marked as
+// processed. A processed document does not match the filter, so the upsert tries to insert a second document with
+// the same _id and fails with a duplicate key error, which makes checking for a processed message and claiming it a
+// single atomic operation. The claim is not exclusive: a processing document matches the filter, so a message that
+// was claimed but not processed, e.g. because the service stopped part way through or its processing failed and it is
+// being retried, is claimed again, and so is one delivered twice at the same time. Deliveries processing the same
+// message at once rely on the E5 progress to skip the steps already completed and on E5 rejecting a second create
+// with the same PUON.
+func (m *MongoProcessedMessageService) ClaimMessage(e5PaymentID, requestId string) (bool, error) {
+	now := time.Now().Truncate(time.Millisecond)
+	filter := bson.M{"_id": e5PaymentID, "status": bson.M{"$ne": messageProcessed}}
+	update := bson.M{
+		"$set":         bson.M{"status": messageProcessing, "claimed_at": now},
+		"$setOnInsert": bson.M{"created_at": now},
+		"$inc":         bson.M{"deliveries": 1},
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	_, err := collection.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			log.DebugC(requestId, "penalty payment message already processed", log.Data{"e5_payment_id": e5PaymentID})
+			return false, nil
+		}
+		log.ErrorC(requestId, err, log.Data{"e5_payment_id": e5PaymentID})
+		return false, err
+	}
+
+	return true, nil
+}
+
+// MarkMessageProcessed updates the processed message document for the E5 payment ID as processed
+func (m *MongoProcessedMessageService) MarkMessageProcessed(e5PaymentID, requestId string) error {
+	filter := bson.M{"_id": e5PaymentID}
+	update := bson.M{
+		"$set": bson.M{"status": messageProcessed, "processed_at": time.Now().Truncate(time.Millisecond)},
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"e5_payment_id": e5PaymentID})
+		return err
+	}
+	if result != nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+		log.ErrorC(requestId, err, log.Data{"e5_payment_id": e5PaymentID})
+		return err
+	}
+
+	return nil
+}
+
+// MongoDeadLetterService is an implementation of the DeadLetterDaoService interface using
+// MongoDB as the backend driver.
+type MongoDeadLetterService struct {
+	db             interfaces.MongoDatabaseInterface
+	CollectionName string
+}
+
+// SaveDeadLetter inserts the dead-lettered message. setOnInsert is used with upsert so that a message dead-lettered
+// again after it was redelivered does not overwrite the status of the one already quarantined.
+func (m *MongoDeadLetterService) SaveDeadLetter(message *deadletter.Message, requestId string) error {
+	logContext := log.Data{"dead_letter_id": message.ID, "reason": message.Reason}
+	log.InfoC(requestId, "saving dead-lettered penalty payment message", logContext)
+
+	filter := bson.M{"_id": message.ID}
+	update := bson.M{"$setOnInsert": message}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	_, err := collection.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.ErrorC(requestId, err, logContext)
+		return err
+	}
+
+	return nil
+}
+
+// GetDeadLetters finds the most recently dead-lettered messages, all of them if status is empty
+func (m *MongoDeadLetterService) GetDeadLetters(status deadletter.Status, limit int, requestId string) ([]deadletter.Message, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "dead_lettered_at", Value: -1}}).SetLimit(int64(limit))
+
+	collection := m.db.Collection(m.CollectionName)
+
+	ctx := context.Background()
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"status": status})
+		return nil, err
+	}
+
+	messages := []deadletter.Message{}
+	if err = cursor.All(ctx, &messages); err != nil {
+		log.ErrorC(requestId, err, log.Data{"status": status})
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// GetDeadLetter finds a single dead-lettered message
+func (m *MongoDeadLetterService) GetDeadLetter(id, requestId string) (*deadletter.Message, error) {
+	var message deadletter.Message
+
+	collection := m.db.Collection(m.CollectionName)
+	dbResource := collection.FindOne(context.Background(), bson.M{"_id": id})
+
+	err := dbResource.Err()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.DebugC(requestId, "no dead-lettered message found", log.Data{"dead_letter_id": id})
+			return nil, nil
+		}
+		log.ErrorC(requestId, err, log.Data{"dead_letter_id": id})
+		return nil, err
+	}
+
+	err = dbResource.Decode(&message)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"dead_letter_id": id})
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// MarkDeadLetterReplayed updates the dead-lettered message as replayed
+func (m *MongoDeadLetterService) MarkDeadLetterReplayed(id, requestId string) error {
+	filter := bson.M{"_id": id}
+	update := bson.M{
+		"$set": bson.M{"status": deadletter.Replayed, "replayed_at": time.Now().UTC().Truncate(time.Millisecond)},
+		"$inc": bson.M{"replay_count": 1},
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"dead_letter_id": id})
+		return err
+	}
+	if result != nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+		log.ErrorC(requestId, err, log.Data{"dead_letter_id": id})
+		return err
+	}
+
+	return nil
+}
+
+// MongoOutboxService is an implementation of the OutboxDaoService interface using
+// MongoDB as the backend driver.
+type MongoOutboxService struct {
+	mongoClientProvider interfaces.MongoClientProvider
+	db                  interfaces.MongoDatabaseInterface
+	DatabaseName        string
+	CollectionName      string
+}
+
+// EnsurePublishedTTLIndex creates the TTL index on the publish time of the messages, so that mongo deletes published
+// messages ttl after they were published. The index only covers published messages as no others have a publish time.
+// Once the index exists, changing ttl needs the index to be changed with collMod.
+func (m *MongoOutboxService) EnsurePublishedTTLIndex(ttl time.Duration) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "published_at", Value: 1}},
+		Options: options.Index().SetName("published_at_ttl").SetExpireAfterSeconds(int32(ttl.Seconds())),
+	}
+
+	err := createIndex(m.mongoClientProvider, m.DatabaseName, m.CollectionName, index)
+	if err != nil {
+		log.Error(fmt.Errorf("error creating published outbox messages TTL index: [%v]", err), log.Data{"ttl": ttl.String()})
+		return err
+	}
+
+	log.Info("published outbox messages TTL index created", log.Data{"ttl": ttl.String()})
+	return nil
+}
+
+// GetPendingOutboxMessages finds the pending messages that are due to be published, the longest waiting first
+func (m *MongoOutboxService) GetPendingOutboxMessages(now time.Time, limit int, requestId string) ([]outbox.Message, error) {
+	filter := bson.M{"status": outbox.Pending, "next_attempt_at": bson.M{"$lte": now}}
+	opts := options.Find().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).SetLimit(int64(limit))
+
+	collection := m.db.Collection(m.CollectionName)
+
+	ctx := context.Background()
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.ErrorC(requestId, err)
+		return nil, err
+	}
+
+	messages := []outbox.Message{}
+	if err = cursor.All(ctx, &messages); err != nil {
+		log.ErrorC(requestId, err)
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// ClaimOutboxMessage moves the next attempt of the pending message to leaseUntil, but only if it is still due. Only one
+// instance of the service can move it, so only one publishes it, and if that instance stops before the message is
+// marked as published it is published again once the lease has passed.
+func (m *MongoOutboxService) ClaimOutboxMessage(id string, now, leaseUntil time.Time, requestId string) (bool, error) {
+	filter := bson.M{"_id": id, "status": outbox.Pending, "next_attempt_at": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"next_attempt_at": leaseUntil}}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"outbox_id": id})
+		return false, err
+	}
+
+	return result != nil && result.MatchedCount == 1, nil
+}
+
+// MarkOutboxMessagePublished updates the message as published
+func (m *MongoOutboxService) MarkOutboxMessagePublished(id, requestId string) error {
+	filter := bson.M{"_id": id}
+	update := bson.M{
+		"$set": bson.M{"status": outbox.Published, "published_at": time.Now().UTC().Truncate(time.Millisecond)},
+		"$inc": bson.M{"attempts": 1},
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"outbox_id": id})
+		return err
+	}
+	if result != nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+		log.ErrorC(requestId, err, log.Data{"outbox_id": id})
+		return err
+	}
+
+	return nil
+}
+
+// MarkOutboxMessageFailed updates the message with the error it failed to publish with and when to try it again
+func (m *MongoOutboxService) MarkOutboxMessageFailed(id string, publishErr error, nextAttemptAt time.Time, requestId string) error {
+	var lastError string
+	if publishErr != nil {
+		lastError = publishErr.Error()
+	}
+
+	filter := bson.M{"_id": id}
+	update := bson.M{
+		"$set": bson.M{"last_error": lastError, "next_attempt_at": nextAttemptAt},
+		"$inc": bson.M{"attempts": 1},
+	}
+
+	collection := m.db.Collection(m.CollectionName)
+
+	result, err := collection.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"outbox_id": id})
+		return err
+	}
+	if result != nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+		log.ErrorC(requestId, err, log.Data{"outbox_id": id})
+		return err
+	}
+
+	return nil
+}
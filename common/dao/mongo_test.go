@@ -1,14 +1,19 @@
 package dao
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/deadletter"
 	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/interfaces"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
 	"github.com/companieshouse/penalty-payment-api/mocks"
 	"github.com/golang/mock/gomock"
 
@@ -163,6 +168,59 @@ func TestUnitMongo_UpdateAccountPenaltyAsPaid(t *testing.T) {
 	})
 }
 
+func TestUnitMongo_UpdateAccountPenaltyAsPartPaid(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForAccountPenaltiesService(t)
+
+	defer ctrl.Finish()
+
+	Convey("update account penalty as part paid should return", t, func() {
+		mockDatabase.EXPECT().Collection("account_penalties").Return(mockCollection)
+
+		Convey("true when account penalty updated", func() {
+
+			result := mongo.UpdateResult{
+				ModifiedCount: 1,
+			}
+
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&result, nil)
+
+			updated, err := svc.UpdateAccountPenaltyAsPartPaid(customerCode, companyCode, penaltyRef, 100, "")
+
+			So(updated, ShouldBeTrue)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("false when the amount paid pays off the account penalty", func() {
+
+			result := mongo.UpdateResult{
+				ModifiedCount: 0,
+			}
+
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&result, nil)
+
+			updated, err := svc.UpdateAccountPenaltyAsPartPaid(customerCode, companyCode, penaltyRef, 150, "")
+
+			So(updated, ShouldBeFalse)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("error when account penalty not updated due to DB error", func() {
+
+			result := mongo.UpdateResult{
+				ModifiedCount: 0,
+			}
+
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&result, errors.New("error updating as part paid"))
+
+			updated, err := svc.UpdateAccountPenaltyAsPartPaid(customerCode, companyCode, penaltyRef, 100, "")
+
+			So(updated, ShouldBeFalse)
+			So(err, ShouldNotBeNil)
+		})
+
+	})
+}
+
 func TestUnitMongo_UpdateAccountPenalties(t *testing.T) {
 	ctrl, svc, mockCollection, mockDatabase, dao := setUpForAccountPenaltiesService(t)
 
@@ -246,13 +304,16 @@ func TestUnitMongo_UpdatePaymentDetails(t *testing.T) {
 
 	defer ctrl.Finish()
 
+	originalRunTransaction := runTransaction
+	defer func() { runTransaction = originalRunTransaction }()
+
 	Convey("create payment details should return", t, func() {
 		mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
 
 		Convey("success when updating payable resource", func() {
 			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil)
 
-			err := svc.UpdatePaymentDetails(dao, "")
+			err := svc.UpdatePaymentDetails(dao, nil, "")
 
 			So(err, ShouldBeNil)
 		})
@@ -260,11 +321,60 @@ func TestUnitMongo_UpdatePaymentDetails(t *testing.T) {
 		Convey("error when getting payable resource", func() {
 			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("not found"))
 
-			err := svc.UpdatePaymentDetails(dao, "")
+			err := svc.UpdatePaymentDetails(dao, nil, "")
 
 			So(err, ShouldNotBeNil)
 		})
 
+		Convey("error when the payable resource has been paid since it was read", func() {
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{}, nil)
+
+			err := svc.UpdatePaymentDetails(dao, nil, "")
+
+			So(err, ShouldEqual, ErrPaymentAlreadyRecorded)
+		})
+
+		Convey("with outbox messages", func() {
+			transactions := 0
+			runTransaction = func(_ interfaces.MongoClientProvider, fn func(ctx context.Context) error) error {
+				transactions++
+				return fn(context.Background())
+			}
+			messages := []outbox.Message{
+				*outbox.NewMessage(outbox.EmailSend, customerCode, payableRef, "email-send", []byte{0x01}),
+				*outbox.NewMessage(outbox.PenaltyPaymentsProcessing, customerCode, payableRef, "penalty-payments-processing", []byte{0x02}),
+			}
+
+			Convey("success when the payable resource is updated and the messages are added in one transaction", func() {
+				mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil)
+				mockDatabase.EXPECT().Collection("outbox").Return(mockCollection)
+				mockCollection.EXPECT().InsertOne(gomock.Any(), messages[0]).Return(nil, nil)
+				mockCollection.EXPECT().InsertOne(gomock.Any(), messages[1]).Return(nil, nil)
+
+				err := svc.UpdatePaymentDetails(dao, messages, "")
+
+				So(err, ShouldBeNil)
+				So(transactions, ShouldEqual, 1)
+			})
+
+			Convey("error when a message cannot be added to the outbox", func() {
+				mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil)
+				mockDatabase.EXPECT().Collection("outbox").Return(mockCollection)
+				mockCollection.EXPECT().InsertOne(gomock.Any(), messages[0]).Return(nil, mongo.ErrClientDisconnected)
+
+				err := svc.UpdatePaymentDetails(dao, messages, "")
+
+				So(err, ShouldEqual, mongo.ErrClientDisconnected)
+			})
+
+			Convey("error when the payable resource cannot be updated and no messages are added", func() {
+				mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+				err := svc.UpdatePaymentDetails(dao, messages, "")
+
+				So(err, ShouldEqual, mongo.ErrClientDisconnected)
+			})
+		})
 	})
 
 }
@@ -336,7 +446,7 @@ func TestUnitMongo_SaveE5Error(t *testing.T) {
 			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
 			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil)
 
-			err := svc.SaveE5Error(customerCode, penaltyRef, "", e5.CreateAction)
+			err := svc.SaveE5Error(customerCode, penaltyRef, "", e5.CreateAction, e5.ErrE5InternalServer)
 
 			So(err, ShouldBeNil)
 		})
@@ -347,7 +457,7 @@ func TestUnitMongo_SaveE5Error(t *testing.T) {
 			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
 			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
 
-			err := svc.SaveE5Error(customerCode, penaltyRef, "", e5.CreateAction)
+			err := svc.SaveE5Error(customerCode, penaltyRef, "", e5.CreateAction, e5.ErrE5InternalServer)
 
 			So(err, ShouldNotBeNil)
 		})
@@ -364,7 +474,7 @@ func TestUnitMongo_SaveE5Error(t *testing.T) {
 			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
 			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrInvalidIndexValue)
 
-			err := svc.SaveE5Error(customerCode, penaltyRef, "", e5.CreateAction)
+			err := svc.SaveE5Error(customerCode, penaltyRef, "", e5.CreateAction, e5.ErrE5InternalServer)
 
 			So(err, ShouldNotBeNil)
 		})
@@ -372,52 +482,1096 @@ func TestUnitMongo_SaveE5Error(t *testing.T) {
 	})
 }
 
-func TestUnitMongo_PayableResourceService_Shutdown(t *testing.T) {
-	ctrl := gomock.NewController(t)
+func TestUnitMongo_SaveE5Compensation(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
+
 	defer ctrl.Finish()
 
-	mockClient := &mongo.Client{}
-	mockDB := &mocks.MockMongoDatabaseInterface{}
+	Convey("save e5 compensation should return", t, func() {
 
-	Convey("Payable resource service shutdown - disconnected from mongodb successfully", t, func() {
-		mockMongoClientProvider := mocks.NewMockMongoClientProvider(ctrl)
-		mockMongoClientProvider.EXPECT().Client().Return(mockClient)
+		Convey("success when E5 compensation saved", func() {
+			// called twice as this method calls GetPayableResource
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection).Times(2)
+
+			result := mongo.NewSingleResultFromDocument(bson.M{
+				"customer_code": customerCode,
+				"payable_ref":   payableRef,
+			}, nil, nil)
+
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+			var update bson.D
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ interface{}, _ interface{}, u interface{}, _ ...interface{}) (*mongo.UpdateResult, error) {
+					update = u.(bson.D)
+					return nil, nil
+				})
+
+			err := svc.SaveE5Compensation(customerCode, penaltyRef, "", e5.TimeoutAction, nil)
+
+			So(err, ShouldBeNil)
+			Convey("and the E5 progress is replaced with the time of the compensation", func() {
+				fields := update.Map()["$set"].(bson.D).Map()
+				progress := fields["e5_progress"].(e5.PaymentProgress)
+				So(progress.CompensatedAt, ShouldNotBeNil)
+				So(progress.Completed(e5.CreateAction), ShouldBeFalse)
+				So(*progress.CompensatedAt, ShouldEqual, fields["e5_compensation_at"])
+			})
+		})
+
+		Convey("error when payable resource cannot be found", func() {
+			result := mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+
+			err := svc.SaveE5Compensation(customerCode, penaltyRef, "", e5.TimeoutAction, nil)
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("error when updating e5 compensation in mongo document", func() {
+			// called twice as this method calls GetPayableResource
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection).Times(2)
+
+			result := mongo.NewSingleResultFromDocument(bson.M{
+				"customer_code": customerCode,
+				"payable_ref":   payableRef,
+			}, nil, nil)
+
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+			var update bson.D
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ interface{}, _ interface{}, u interface{}, _ ...interface{}) (*mongo.UpdateResult, error) {
+					update = u.(bson.D)
+					return nil, mongo.ErrInvalidIndexValue
+				})
+
+			err := svc.SaveE5Compensation(customerCode, penaltyRef, "", e5.RejectAction, e5.ErrE5InternalServer)
+
+			So(err, ShouldNotBeNil)
+			Convey("and the E5 progress of a failed compensation is kept", func() {
+				So(update.Map()["$set"].(bson.D).Map(), ShouldNotContainKey, "e5_progress")
+			})
+		})
 
-		m := &MongoPayableResourceService{
-			mongoClientProvider: mockMongoClientProvider,
-			db:                  mockDB,
-			CollectionName:      "payable_resources",
-		}
-		m.Shutdown()
 	})
 }
 
-func setUpForAccountPenaltiesService(t *testing.T) (*gomock.Controller, MongoAccountPenaltiesService,
-	*mocks.MockMongoCollectionInterface, *mocks.MockMongoDatabaseInterface, *models.AccountPenaltiesDao) {
-	ctrl := gomock.NewController(t)
+func TestUnitMongo_SaveE5Progress(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
 
-	mockCollection := mocks.NewMockMongoCollectionInterface(ctrl)
-	mockDatabase := mocks.NewMockMongoDatabaseInterface(ctrl)
-	dao := &models.AccountPenaltiesDao{}
+	defer ctrl.Finish()
 
-	svc := MongoAccountPenaltiesService{
-		db:             mockDatabase,
-		CollectionName: "account_penalties",
-	}
-	return ctrl, svc, mockCollection, mockDatabase, dao
+	Convey("save e5 progress should return", t, func() {
+
+		Convey("success when E5 progress saved", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{MatchedCount: 1}, nil)
+
+			err := svc.SaveE5Progress(customerCode, payableRef, "", e5.AuthoriseAction)
+
+			So(err, ShouldBeNil)
+		})
+
+		Convey("error when payable resource cannot be found", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{}, nil)
+
+			err := svc.SaveE5Progress(customerCode, payableRef, "", e5.AuthoriseAction)
+
+			So(err, ShouldEqual, mongo.ErrNoDocuments)
+		})
+
+		Convey("error when updating e5 progress in mongo document", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrInvalidIndexValue)
+
+			err := svc.SaveE5Progress(customerCode, payableRef, "", e5.ConfirmAction)
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("error when the action has no progress", func() {
+			err := svc.SaveE5Progress(customerCode, payableRef, "", e5.TimeoutAction)
+
+			So(err, ShouldNotBeNil)
+		})
+	})
 }
 
-func setUpForPayableResourceService(t *testing.T) (*gomock.Controller, MongoPayableResourceService,
-	*mocks.MockMongoCollectionInterface, *mocks.MockMongoDatabaseInterface, *models.PayableResourceDao) {
-	ctrl := gomock.NewController(t)
+func TestUnitMongo_GetE5Progress(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
 
-	mockCollection := mocks.NewMockMongoCollectionInterface(ctrl)
-	mockDatabase := mocks.NewMockMongoDatabaseInterface(ctrl)
-	dao := &models.PayableResourceDao{}
+	defer ctrl.Finish()
 
-	svc := MongoPayableResourceService{
-		db:             mockDatabase,
-		CollectionName: "payable_resources",
-	}
-	return ctrl, svc, mockCollection, mockDatabase, dao
+	Convey("get e5 progress should return", t, func() {
+
+		Convey("the steps that have succeeded", func() {
+			createdAt := time.Now().UTC().Truncate(time.Millisecond)
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			result := mongo.NewSingleResultFromDocument(bson.M{
+				"e5_progress": bson.M{"created_at": createdAt},
+			}, nil, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+
+			progress, err := svc.GetE5Progress(customerCode, payableRef, "")
+
+			So(err, ShouldBeNil)
+			So(progress.Completed(e5.CreateAction), ShouldBeTrue)
+			So(progress.Completed(e5.AuthoriseAction), ShouldBeFalse)
+			So(progress.CreatedAt.Equal(createdAt), ShouldBeTrue)
+		})
+
+		Convey("no steps for a payable resource without progress", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			result := mongo.NewSingleResultFromDocument(bson.M{"payable_ref": payableRef}, nil, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+
+			progress, err := svc.GetE5Progress(customerCode, payableRef, "")
+
+			So(err, ShouldBeNil)
+			So(progress.Completed(e5.CreateAction), ShouldBeFalse)
+		})
+
+		Convey("error when payable resource cannot be found", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			result := mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+
+			progress, err := svc.GetE5Progress(customerCode, payableRef, "")
+
+			So(progress, ShouldBeNil)
+			So(err, ShouldEqual, mongo.ErrNoDocuments)
+		})
+	})
+}
+
+func TestUnitMongo_RecordPaymentSession(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
+
+	defer ctrl.Finish()
+
+	Convey("record payment session should return", t, func() {
+
+		Convey("success when the payment session is recorded", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			var update bson.M
+			mockCollection.EXPECT().UpdateOne(gomock.Any(),
+				bson.M{"payable_ref": payableRef, "customer_code": customerCode}, gomock.Any()).
+				DoAndReturn(func(_ interface{}, _ interface{}, u interface{}, _ ...interface{}) (*mongo.UpdateResult, error) {
+					update = u.(bson.M)
+					return &mongo.UpdateResult{MatchedCount: 1}, nil
+				})
+
+			err := svc.RecordPaymentSession(customerCode, payableRef, "P123", "")
+
+			So(err, ShouldBeNil)
+			So(update["$addToSet"], ShouldResemble, bson.M{"payment_sessions": "P123"})
+			So(update["$set"].(bson.M)["payment_session_recorded_at"], ShouldHappenWithin, time.Minute, time.Now().UTC())
+		})
+
+		Convey("error when payable resource cannot be found", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{}, nil)
+
+			err := svc.RecordPaymentSession(customerCode, payableRef, "P123", "")
+
+			So(err, ShouldEqual, mongo.ErrNoDocuments)
+		})
+
+		Convey("error when updating the mongo document", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			err := svc.RecordPaymentSession(customerCode, payableRef, "P123", "")
+
+			So(err, ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_RecordUnidentifiedPaymentSession(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
+
+	defer ctrl.Finish()
+
+	Convey("record unidentified payment session should return", t, func() {
+
+		Convey("success when the unidentified payment session is recorded", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			var update bson.M
+			mockCollection.EXPECT().UpdateOne(gomock.Any(),
+				bson.M{"payable_ref": payableRef, "customer_code": customerCode}, gomock.Any()).
+				DoAndReturn(func(_ interface{}, _ interface{}, u interface{}, _ ...interface{}) (*mongo.UpdateResult, error) {
+					update = u.(bson.M)
+					return &mongo.UpdateResult{MatchedCount: 1}, nil
+				})
+
+			err := svc.RecordUnidentifiedPaymentSession(customerCode, payableRef, "")
+
+			So(err, ShouldBeNil)
+			So(update["$set"].(bson.M)["unidentified_payment_session_at"], ShouldHappenWithin, time.Minute, time.Now().UTC())
+		})
+
+		Convey("error when payable resource cannot be found", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{}, nil)
+
+			err := svc.RecordUnidentifiedPaymentSession(customerCode, payableRef, "")
+
+			So(err, ShouldEqual, mongo.ErrNoDocuments)
+		})
+
+		Convey("error when updating the mongo document", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			err := svc.RecordUnidentifiedPaymentSession(customerCode, payableRef, "")
+
+			So(err, ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_GetPaymentSessions(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
+
+	defer ctrl.Finish()
+
+	Convey("get payment sessions should return", t, func() {
+
+		Convey("the payment sessions recorded", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			result := mongo.NewSingleResultFromDocument(bson.M{"payment_sessions": bson.A{"P123", "P456"}}, nil, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+
+			sessions, err := svc.GetPaymentSessions(customerCode, payableRef, "")
+
+			So(err, ShouldBeNil)
+			So(sessions, ShouldResemble, []string{"P123", "P456"})
+		})
+
+		Convey("no payment sessions for a payable resource without any", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			result := mongo.NewSingleResultFromDocument(bson.M{"payable_ref": payableRef}, nil, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+
+			sessions, err := svc.GetPaymentSessions(customerCode, payableRef, "")
+
+			So(err, ShouldBeNil)
+			So(sessions, ShouldBeEmpty)
+		})
+
+		Convey("error when payable resource cannot be found", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			result := mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+
+			sessions, err := svc.GetPaymentSessions(customerCode, payableRef, "")
+
+			So(sessions, ShouldBeNil)
+			So(err, ShouldEqual, mongo.ErrNoDocuments)
+		})
+	})
+}
+
+func TestUnitMongo_SaveFailedMarkAsPaidSteps(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
+
+	defer ctrl.Finish()
+
+	filter := bson.M{"payable_ref": payableRef, "customer_code": customerCode}
+
+	Convey("save failed mark as paid steps should return", t, func() {
+		mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+
+		Convey("success when the failed steps are saved", func() {
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), filter,
+				bson.M{"$set": bson.M{"failed_mark_as_paid_steps": []string{"email"}}}).Return(&mongo.UpdateResult{MatchedCount: 1}, nil)
+
+			So(svc.SaveFailedMarkAsPaidSteps(customerCode, payableRef, []string{"email"}, ""), ShouldBeNil)
+		})
+
+		Convey("success when the failed steps are cleared", func() {
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), filter,
+				bson.M{"$unset": bson.M{"failed_mark_as_paid_steps": ""}}).Return(&mongo.UpdateResult{MatchedCount: 1}, nil)
+
+			So(svc.SaveFailedMarkAsPaidSteps(customerCode, payableRef, nil, ""), ShouldBeNil)
+		})
+
+		Convey("error when payable resource cannot be found", func() {
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{}, nil)
+
+			So(svc.SaveFailedMarkAsPaidSteps(customerCode, payableRef, []string{"email"}, ""), ShouldEqual, mongo.ErrNoDocuments)
+		})
+
+		Convey("error when updating the mongo document", func() {
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			So(svc.SaveFailedMarkAsPaidSteps(customerCode, payableRef, []string{"email"}, ""), ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_GetFailedMarkAsPaidSteps(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
+
+	defer ctrl.Finish()
+
+	Convey("get failed mark as paid steps should return", t, func() {
+		mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+
+		Convey("the failed steps saved", func() {
+			result := mongo.NewSingleResultFromDocument(bson.M{"failed_mark_as_paid_steps": bson.A{"email", "finance"}}, nil, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+
+			steps, err := svc.GetFailedMarkAsPaidSteps(customerCode, payableRef, "")
+
+			So(err, ShouldBeNil)
+			So(steps, ShouldResemble, []string{"email", "finance"})
+		})
+
+		Convey("no failed steps when none were saved", func() {
+			result := mongo.NewSingleResultFromDocument(bson.M{"payable_ref": payableRef}, nil, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+
+			steps, err := svc.GetFailedMarkAsPaidSteps(customerCode, payableRef, "")
+
+			So(err, ShouldBeNil)
+			So(steps, ShouldBeEmpty)
+		})
+
+		Convey("error when payable resource cannot be found", func() {
+			result := mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(result)
+
+			steps, err := svc.GetFailedMarkAsPaidSteps(customerCode, payableRef, "")
+
+			So(steps, ShouldBeNil)
+			So(err, ShouldEqual, mongo.ErrNoDocuments)
+		})
+	})
+}
+
+func TestUnitMongo_AddOutboxMessages(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
+
+	defer ctrl.Finish()
+
+	messages := []outbox.Message{
+		*outbox.NewMessage(outbox.EmailSend, customerCode, payableRef, "email-send", []byte{0x01}),
+		*outbox.NewMessage(outbox.PenaltyPaymentsProcessing, customerCode, payableRef, "penalty-payments-processing", []byte{0x02}),
+	}
+
+	Convey("add outbox messages should return", t, func() {
+		mockDatabase.EXPECT().Collection("outbox").Return(mockCollection)
+
+		Convey("success when the messages are added", func() {
+			mockCollection.EXPECT().InsertOne(gomock.Any(), messages[0]).Return(nil, nil)
+			mockCollection.EXPECT().InsertOne(gomock.Any(), messages[1]).Return(nil, nil)
+
+			So(svc.AddOutboxMessages(messages, ""), ShouldBeNil)
+		})
+
+		Convey("success when a message is already in the outbox", func() {
+			mockCollection.EXPECT().InsertOne(gomock.Any(), messages[0]).
+				Return(nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}})
+			mockCollection.EXPECT().InsertOne(gomock.Any(), messages[1]).Return(nil, nil)
+
+			So(svc.AddOutboxMessages(messages, ""), ShouldBeNil)
+		})
+
+		Convey("error when a message cannot be added", func() {
+			mockCollection.EXPECT().InsertOne(gomock.Any(), messages[0]).Return(nil, mongo.ErrClientDisconnected)
+
+			So(svc.AddOutboxMessages(messages, ""), ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_GetStalePendingPayableResources(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
+
+	defer ctrl.Finish()
+
+	createdAfter := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	createdBefore := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	filter := bson.M{
+		"data.payment.status": "pending",
+		"data.created_at":     bson.M{"$gt": createdAfter, "$lt": createdBefore},
+	}
+
+	Convey("get stale pending payable resources should return", t, func() {
+
+		Convey("the pending payable resources created between the times", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			cursor, _ := mongo.NewCursorFromDocuments([]interface{}{
+				bson.M{"customer_code": customerCode, "payable_ref": payableRef, "data": bson.M{"payment": bson.M{"status": "pending"}}},
+			}, nil, nil)
+			mockCollection.EXPECT().Find(gomock.Any(), filter, gomock.Any()).Return(cursor, nil)
+
+			resources, err := svc.GetStalePendingPayableResources(createdAfter, createdBefore, 10, "")
+
+			So(err, ShouldBeNil)
+			So(resources, ShouldHaveLength, 1)
+			So(resources[0].PayableRef, ShouldEqual, payableRef)
+		})
+
+		Convey("error when the find fails", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().Find(gomock.Any(), filter, gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			resources, err := svc.GetStalePendingPayableResources(createdAfter, createdBefore, 10, "")
+
+			So(resources, ShouldBeNil)
+			So(err, ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_GetExpirablePayableResources(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
+
+	defer ctrl.Finish()
+
+	createdAfter := time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)
+	createdBefore := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	filter := bson.M{
+		"data.payment.status": "pending",
+		"data.created_at":     bson.M{"$gt": createdAfter, "$lt": createdBefore},
+		"$or": bson.A{
+			bson.M{"payment_sessions": bson.M{"$exists": false}},
+			bson.M{"payment_session_recorded_at": bson.M{"$lt": createdBefore}},
+		},
+		"unidentified_payment_session_at": bson.M{"$exists": false},
+	}
+
+	Convey("get expirable payable resources should return", t, func() {
+
+		Convey("the pending payable resources without a recent or unidentified payment session created between the times", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			cursor, _ := mongo.NewCursorFromDocuments([]interface{}{
+				bson.M{"customer_code": customerCode, "payable_ref": payableRef, "data": bson.M{"payment": bson.M{"status": "pending"}}},
+			}, nil, nil)
+			mockCollection.EXPECT().Find(gomock.Any(), filter, gomock.Any()).Return(cursor, nil)
+
+			resources, err := svc.GetExpirablePayableResources(createdAfter, createdBefore, 10, "")
+
+			So(err, ShouldBeNil)
+			So(resources, ShouldHaveLength, 1)
+			So(resources[0].PayableRef, ShouldEqual, payableRef)
+		})
+
+		Convey("error when the find fails", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().Find(gomock.Any(), filter, gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			resources, err := svc.GetExpirablePayableResources(createdAfter, createdBefore, 10, "")
+
+			So(resources, ShouldBeNil)
+			So(err, ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_ExpirePayableResource(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
+
+	defer ctrl.Finish()
+
+	expiredAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	sessionsRecordedBefore := expiredAt.Add(-24 * time.Hour)
+	filter := bson.M{
+		"payable_ref":         payableRef,
+		"customer_code":       customerCode,
+		"data.payment.status": "pending",
+		"$or": bson.A{
+			bson.M{"payment_sessions": bson.M{"$exists": false}},
+			bson.M{"payment_session_recorded_at": bson.M{"$lt": sessionsRecordedBefore}},
+		},
+		"unidentified_payment_session_at": bson.M{"$exists": false},
+	}
+	update := bson.M{"$set": bson.M{"data.payment.status": "expired", "data.expired_at": expiredAt}}
+
+	Convey("expire payable resource should return", t, func() {
+
+		Convey("true when the pending payable resource is expired", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), filter, update).Return(&mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil)
+
+			expired, err := svc.ExpirePayableResource(customerCode, payableRef, expiredAt, sessionsRecordedBefore, "")
+
+			So(err, ShouldBeNil)
+			So(expired, ShouldBeTrue)
+		})
+
+		Convey("false when the payable resource has been paid or has a recent or unidentified payment session", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), filter, update).Return(&mongo.UpdateResult{}, nil)
+
+			expired, err := svc.ExpirePayableResource(customerCode, payableRef, expiredAt, sessionsRecordedBefore, "")
+
+			So(err, ShouldBeNil)
+			So(expired, ShouldBeFalse)
+		})
+
+		Convey("error when updating the mongo document", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), filter, update).Return(nil, mongo.ErrClientDisconnected)
+
+			expired, err := svc.ExpirePayableResource(customerCode, payableRef, expiredAt, sessionsRecordedBefore, "")
+
+			So(expired, ShouldBeFalse)
+			So(err, ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_CancelPayableResource(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase, _ := setUpForPayableResourceService(t)
+
+	defer ctrl.Finish()
+
+	cancelledAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	filter := bson.M{
+		"payable_ref":         payableRef,
+		"customer_code":       customerCode,
+		"data.payment.status": "pending",
+	}
+	update := bson.M{"$set": bson.M{"data.payment.status": "cancelled", "data.cancelled_at": cancelledAt}}
+
+	Convey("cancel payable resource should return", t, func() {
+
+		Convey("true when the pending payable resource is cancelled", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), filter, update).Return(&mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil)
+
+			cancelled, err := svc.CancelPayableResource(customerCode, payableRef, cancelledAt, "")
+
+			So(err, ShouldBeNil)
+			So(cancelled, ShouldBeTrue)
+		})
+
+		Convey("false when the payable resource is no longer pending", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), filter, update).Return(&mongo.UpdateResult{}, nil)
+
+			cancelled, err := svc.CancelPayableResource(customerCode, payableRef, cancelledAt, "")
+
+			So(err, ShouldBeNil)
+			So(cancelled, ShouldBeFalse)
+		})
+
+		Convey("error when updating the mongo document", func() {
+			mockDatabase.EXPECT().Collection("payable_resources").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), filter, update).Return(nil, mongo.ErrClientDisconnected)
+
+			cancelled, err := svc.CancelPayableResource(customerCode, payableRef, cancelledAt, "")
+
+			So(cancelled, ShouldBeFalse)
+			So(err, ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_EnsureExpiredTTLIndex(t *testing.T) {
+	ctrl, svc, _, _, _ := setUpForPayableResourceService(t)
+
+	defer ctrl.Finish()
+
+	originalCreateIndex := createIndex
+	defer func() { createIndex = originalCreateIndex }()
+
+	svc.DatabaseName = "financial_penalties"
+
+	Convey("ensure expired TTL index should", t, func() {
+		var created []mongo.IndexModel
+		createIndex = func(_ interfaces.MongoClientProvider, database, collection string, index mongo.IndexModel) error {
+			So(database, ShouldEqual, "financial_penalties")
+			So(collection, ShouldEqual, "payable_resources")
+			created = append(created, index)
+			return nil
+		}
+
+		Convey("create a TTL index on the expiry time of the payable resources", func() {
+			err := svc.EnsureExpiredTTLIndex(30 * 24 * time.Hour)
+
+			So(err, ShouldBeNil)
+			So(created, ShouldHaveLength, 1)
+			So(created[0].Keys, ShouldResemble, bson.D{{Key: "data.expired_at", Value: 1}})
+			So(*created[0].Options.ExpireAfterSeconds, ShouldEqual, 30*24*60*60)
+		})
+
+		Convey("return the error if the index cannot be created", func() {
+			createIndex = func(_ interfaces.MongoClientProvider, _, _ string, _ mongo.IndexModel) error {
+				return mongo.ErrClientDisconnected
+			}
+
+			So(svc.EnsureExpiredTTLIndex(time.Hour), ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_EnsurePublishedTTLIndex(t *testing.T) {
+	ctrl, svc, _, _ := setUpForOutboxService(t)
+
+	defer ctrl.Finish()
+
+	originalCreateIndex := createIndex
+	defer func() { createIndex = originalCreateIndex }()
+
+	svc.DatabaseName = "financial_penalties"
+
+	Convey("ensure published TTL index should", t, func() {
+		var created []mongo.IndexModel
+		createIndex = func(_ interfaces.MongoClientProvider, database, collection string, index mongo.IndexModel) error {
+			So(database, ShouldEqual, "financial_penalties")
+			So(collection, ShouldEqual, "outbox")
+			created = append(created, index)
+			return nil
+		}
+
+		Convey("create a TTL index on the publish time of the outbox messages", func() {
+			err := svc.EnsurePublishedTTLIndex(7 * 24 * time.Hour)
+
+			So(err, ShouldBeNil)
+			So(created, ShouldHaveLength, 1)
+			So(created[0].Keys, ShouldResemble, bson.D{{Key: "published_at", Value: 1}})
+			So(*created[0].Options.ExpireAfterSeconds, ShouldEqual, 7*24*60*60)
+		})
+
+		Convey("return the error if the index cannot be created", func() {
+			createIndex = func(_ interfaces.MongoClientProvider, _, _ string, _ mongo.IndexModel) error {
+				return mongo.ErrClientDisconnected
+			}
+
+			So(svc.EnsurePublishedTTLIndex(time.Hour), ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_ClaimMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCollection := mocks.NewMockMongoCollectionInterface(ctrl)
+	mockDatabase := mocks.NewMockMongoDatabaseInterface(ctrl)
+	svc := MongoProcessedMessageService{db: mockDatabase, CollectionName: "processed_penalty_payments"}
+
+	Convey("claim message should return", t, func() {
+
+		Convey("true when the message has not been processed", func() {
+			mockDatabase.EXPECT().Collection("processed_penalty_payments").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{UpsertedCount: 1}, nil)
+
+			claimed, err := svc.ClaimMessage("XKIYLUq1pRVuiLNA", "")
+
+			So(err, ShouldBeNil)
+			So(claimed, ShouldBeTrue)
+		})
+
+		Convey("true when the message was claimed but has not been processed", func() {
+			var filter bson.M
+			mockDatabase.EXPECT().Collection("processed_penalty_payments").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ interface{}, f interface{}, _ interface{}, _ ...interface{}) (*mongo.UpdateResult, error) {
+					filter = f.(bson.M)
+					return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+				})
+
+			claimed, err := svc.ClaimMessage("XKIYLUq1pRVuiLNA", "")
+
+			So(err, ShouldBeNil)
+			So(claimed, ShouldBeTrue)
+			// only a processed document is excluded, so a processing one is claimed again whenever it was claimed
+			So(filter, ShouldResemble, bson.M{"_id": "XKIYLUq1pRVuiLNA", "status": bson.M{"$ne": "processed"}})
+		})
+
+		Convey("false when the message has already been processed", func() {
+			mockDatabase.EXPECT().Collection("processed_penalty_payments").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+				Return(nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}})
+
+			claimed, err := svc.ClaimMessage("XKIYLUq1pRVuiLNA", "")
+
+			So(err, ShouldBeNil)
+			So(claimed, ShouldBeFalse)
+		})
+
+		Convey("error when the claim fails", func() {
+			mockDatabase.EXPECT().Collection("processed_penalty_payments").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			claimed, err := svc.ClaimMessage("XKIYLUq1pRVuiLNA", "")
+
+			So(err, ShouldEqual, mongo.ErrClientDisconnected)
+			So(claimed, ShouldBeFalse)
+		})
+	})
+}
+
+func TestUnitMongo_MarkMessageProcessed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockCollection := mocks.NewMockMongoCollectionInterface(ctrl)
+	mockDatabase := mocks.NewMockMongoDatabaseInterface(ctrl)
+	svc := MongoProcessedMessageService{db: mockDatabase, CollectionName: "processed_penalty_payments"}
+
+	Convey("mark message processed should return", t, func() {
+
+		Convey("success when the message is marked as processed", func() {
+			mockDatabase.EXPECT().Collection("processed_penalty_payments").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{MatchedCount: 1}, nil)
+
+			So(svc.MarkMessageProcessed("XKIYLUq1pRVuiLNA", ""), ShouldBeNil)
+		})
+
+		Convey("error when the message was not claimed", func() {
+			mockDatabase.EXPECT().Collection("processed_penalty_payments").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{}, nil)
+
+			So(svc.MarkMessageProcessed("XKIYLUq1pRVuiLNA", ""), ShouldEqual, mongo.ErrNoDocuments)
+		})
+
+		Convey("error when the update fails", func() {
+			mockDatabase.EXPECT().Collection("processed_penalty_payments").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			So(svc.MarkMessageProcessed("XKIYLUq1pRVuiLNA", ""), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUnitMongo_SaveDeadLetter(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase := setUpForDeadLetterService(t)
+
+	defer ctrl.Finish()
+
+	Convey("save dead letter should return", t, func() {
+		message := &deadletter.Message{ID: "penalty-payments-processing-0-42", Reason: deadletter.Undecodable}
+
+		Convey("success when the message is quarantined", func() {
+			mockDatabase.EXPECT().Collection("dead_letters").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), bson.M{"_id": message.ID}, gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{UpsertedCount: 1}, nil)
+
+			So(svc.SaveDeadLetter(message, ""), ShouldBeNil)
+		})
+
+		Convey("error when the message cannot be saved", func() {
+			mockDatabase.EXPECT().Collection("dead_letters").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			So(svc.SaveDeadLetter(message, ""), ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_GetDeadLetters(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase := setUpForDeadLetterService(t)
+
+	defer ctrl.Finish()
+
+	Convey("get dead letters should return", t, func() {
+
+		Convey("the dead-lettered messages with the status", func() {
+			mockDatabase.EXPECT().Collection("dead_letters").Return(mockCollection)
+			cursor, _ := mongo.NewCursorFromDocuments([]interface{}{
+				bson.M{"_id": "penalty-payments-processing-0-42", "status": "quarantined", "value": []byte{0x01}},
+			}, nil, nil)
+			mockCollection.EXPECT().Find(gomock.Any(), bson.M{"status": deadletter.Quarantined}, gomock.Any()).Return(cursor, nil)
+
+			messages, err := svc.GetDeadLetters(deadletter.Quarantined, 10, "")
+
+			So(err, ShouldBeNil)
+			So(messages, ShouldHaveLength, 1)
+			So(messages[0].ID, ShouldEqual, "penalty-payments-processing-0-42")
+			So(messages[0].Value, ShouldResemble, []byte{0x01})
+		})
+
+		Convey("error when the find fails", func() {
+			mockDatabase.EXPECT().Collection("dead_letters").Return(mockCollection)
+			mockCollection.EXPECT().Find(gomock.Any(), bson.M{}, gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			messages, err := svc.GetDeadLetters("", 10, "")
+
+			So(messages, ShouldBeNil)
+			So(err, ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_GetDeadLetter(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase := setUpForDeadLetterService(t)
+
+	defer ctrl.Finish()
+
+	Convey("get dead letter should return", t, func() {
+
+		Convey("the dead-lettered message", func() {
+			mockDatabase.EXPECT().Collection("dead_letters").Return(mockCollection)
+			result := mongo.NewSingleResultFromDocument(bson.M{"_id": "penalty-payments-processing-0-42", "error": "EOF"}, nil, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any()).Return(result)
+
+			message, err := svc.GetDeadLetter("penalty-payments-processing-0-42", "")
+
+			So(err, ShouldBeNil)
+			So(message.Error, ShouldEqual, "EOF")
+		})
+
+		Convey("nil when the message cannot be found", func() {
+			mockDatabase.EXPECT().Collection("dead_letters").Return(mockCollection)
+			result := mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any()).Return(result)
+
+			message, err := svc.GetDeadLetter("penalty-payments-processing-0-42", "")
+
+			So(err, ShouldBeNil)
+			So(message, ShouldBeNil)
+		})
+
+		Convey("error when the find fails", func() {
+			mockDatabase.EXPECT().Collection("dead_letters").Return(mockCollection)
+			result := mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrClientDisconnected, nil)
+			mockCollection.EXPECT().FindOne(gomock.Any(), gomock.Any()).Return(result)
+
+			message, err := svc.GetDeadLetter("penalty-payments-processing-0-42", "")
+
+			So(err, ShouldEqual, mongo.ErrClientDisconnected)
+			So(message, ShouldBeNil)
+		})
+	})
+}
+
+func TestUnitMongo_MarkDeadLetterReplayed(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase := setUpForDeadLetterService(t)
+
+	defer ctrl.Finish()
+
+	Convey("mark dead letter replayed should return", t, func() {
+
+		Convey("success when the message is marked as replayed", func() {
+			mockDatabase.EXPECT().Collection("dead_letters").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{MatchedCount: 1}, nil)
+
+			So(svc.MarkDeadLetterReplayed("penalty-payments-processing-0-42", ""), ShouldBeNil)
+		})
+
+		Convey("error when the message cannot be found", func() {
+			mockDatabase.EXPECT().Collection("dead_letters").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{}, nil)
+
+			So(svc.MarkDeadLetterReplayed("penalty-payments-processing-0-42", ""), ShouldEqual, mongo.ErrNoDocuments)
+		})
+
+		Convey("error when the update fails", func() {
+			mockDatabase.EXPECT().Collection("dead_letters").Return(mockCollection)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			So(svc.MarkDeadLetterReplayed("penalty-payments-processing-0-42", ""), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUnitMongo_GetPendingOutboxMessages(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase := setUpForOutboxService(t)
+
+	defer ctrl.Finish()
+
+	now := time.Now()
+
+	Convey("get pending outbox messages should return", t, func() {
+		mockDatabase.EXPECT().Collection("outbox").Return(mockCollection)
+
+		Convey("the pending messages that are due", func() {
+			cursor, _ := mongo.NewCursorFromDocuments([]interface{}{
+				bson.M{"_id": "XP123-email-send", "kind": "email-send", "status": "pending", "value": []byte{0x01}},
+			}, nil, nil)
+			mockCollection.EXPECT().Find(gomock.Any(), bson.M{"status": outbox.Pending, "next_attempt_at": bson.M{"$lte": now}}, gomock.Any()).Return(cursor, nil)
+
+			messages, err := svc.GetPendingOutboxMessages(now, 10, "")
+
+			So(err, ShouldBeNil)
+			So(messages, ShouldHaveLength, 1)
+			So(messages[0].Kind, ShouldEqual, outbox.EmailSend)
+			So(messages[0].Value, ShouldResemble, []byte{0x01})
+		})
+
+		Convey("error when the find fails", func() {
+			mockCollection.EXPECT().Find(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			messages, err := svc.GetPendingOutboxMessages(now, 10, "")
+
+			So(messages, ShouldBeNil)
+			So(err, ShouldEqual, mongo.ErrClientDisconnected)
+		})
+	})
+}
+
+func TestUnitMongo_ClaimOutboxMessage(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase := setUpForOutboxService(t)
+
+	defer ctrl.Finish()
+
+	now := time.Now()
+
+	Convey("claim outbox message should return", t, func() {
+		mockDatabase.EXPECT().Collection("outbox").Return(mockCollection)
+
+		Convey("true when the message is still due", func() {
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{MatchedCount: 1}, nil)
+
+			claimed, err := svc.ClaimOutboxMessage("XP123-email-send", now, now.Add(time.Minute), "")
+
+			So(err, ShouldBeNil)
+			So(claimed, ShouldBeTrue)
+		})
+
+		Convey("false when the message has been claimed or published already", func() {
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{}, nil)
+
+			claimed, err := svc.ClaimOutboxMessage("XP123-email-send", now, now.Add(time.Minute), "")
+
+			So(err, ShouldBeNil)
+			So(claimed, ShouldBeFalse)
+		})
+
+		Convey("error when the update fails", func() {
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			claimed, err := svc.ClaimOutboxMessage("XP123-email-send", now, now.Add(time.Minute), "")
+
+			So(err, ShouldNotBeNil)
+			So(claimed, ShouldBeFalse)
+		})
+	})
+}
+
+func TestUnitMongo_MarkOutboxMessagePublished(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase := setUpForOutboxService(t)
+
+	defer ctrl.Finish()
+
+	Convey("mark outbox message published should return", t, func() {
+		mockDatabase.EXPECT().Collection("outbox").Return(mockCollection)
+
+		Convey("success when the message is marked as published", func() {
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{MatchedCount: 1}, nil)
+
+			So(svc.MarkOutboxMessagePublished("XP123-email-send", ""), ShouldBeNil)
+		})
+
+		Convey("error when the message cannot be found", func() {
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(&mongo.UpdateResult{}, nil)
+
+			So(svc.MarkOutboxMessagePublished("XP123-email-send", ""), ShouldEqual, mongo.ErrNoDocuments)
+		})
+	})
+}
+
+func TestUnitMongo_MarkOutboxMessageFailed(t *testing.T) {
+	ctrl, svc, mockCollection, mockDatabase := setUpForOutboxService(t)
+
+	defer ctrl.Finish()
+
+	Convey("mark outbox message failed should return", t, func() {
+		mockDatabase.EXPECT().Collection("outbox").Return(mockCollection)
+
+		Convey("success when the error and next attempt are recorded", func() {
+			nextAttemptAt := time.Now().Add(time.Minute)
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), bson.M{"_id": "XP123-email-send"}, bson.M{
+				"$set": bson.M{"last_error": "kafka: client has run out of available brokers", "next_attempt_at": nextAttemptAt},
+				"$inc": bson.M{"attempts": 1},
+			}).Return(&mongo.UpdateResult{MatchedCount: 1}, nil)
+
+			err := svc.MarkOutboxMessageFailed("XP123-email-send", errors.New("kafka: client has run out of available brokers"), nextAttemptAt, "")
+
+			So(err, ShouldBeNil)
+		})
+
+		Convey("error when the update fails", func() {
+			mockCollection.EXPECT().UpdateOne(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, mongo.ErrClientDisconnected)
+
+			So(svc.MarkOutboxMessageFailed("XP123-email-send", nil, time.Now(), ""), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUnitMongo_PayableResourceService_Shutdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockClient := &mongo.Client{}
+	mockDB := &mocks.MockMongoDatabaseInterface{}
+
+	Convey("Payable resource service shutdown - disconnected from mongodb successfully", t, func() {
+		mockMongoClientProvider := mocks.NewMockMongoClientProvider(ctrl)
+		mockMongoClientProvider.EXPECT().Client().Return(mockClient)
+
+		m := &MongoPayableResourceService{
+			mongoClientProvider: mockMongoClientProvider,
+			db:                  mockDB,
+			CollectionName:      "payable_resources",
+		}
+		m.Shutdown()
+	})
+}
+
+func setUpForAccountPenaltiesService(t *testing.T) (*gomock.Controller, MongoAccountPenaltiesService,
+	*mocks.MockMongoCollectionInterface, *mocks.MockMongoDatabaseInterface, *models.AccountPenaltiesDao) {
+	ctrl := gomock.NewController(t)
+
+	mockCollection := mocks.NewMockMongoCollectionInterface(ctrl)
+	mockDatabase := mocks.NewMockMongoDatabaseInterface(ctrl)
+	dao := &models.AccountPenaltiesDao{}
+
+	svc := MongoAccountPenaltiesService{
+		db:             mockDatabase,
+		CollectionName: "account_penalties",
+	}
+	return ctrl, svc, mockCollection, mockDatabase, dao
+}
+
+func setUpForPayableResourceService(t *testing.T) (*gomock.Controller, MongoPayableResourceService,
+	*mocks.MockMongoCollectionInterface, *mocks.MockMongoDatabaseInterface, *models.PayableResourceDao) {
+	ctrl := gomock.NewController(t)
+
+	mockCollection := mocks.NewMockMongoCollectionInterface(ctrl)
+	mockDatabase := mocks.NewMockMongoDatabaseInterface(ctrl)
+	dao := &models.PayableResourceDao{}
+
+	svc := MongoPayableResourceService{
+		db:                   mockDatabase,
+		CollectionName:       "payable_resources",
+		OutboxCollectionName: "outbox",
+	}
+	return ctrl, svc, mockCollection, mockDatabase, dao
+}
+
+func setUpForDeadLetterService(t *testing.T) (*gomock.Controller, MongoDeadLetterService,
+	*mocks.MockMongoCollectionInterface, *mocks.MockMongoDatabaseInterface) {
+	ctrl := gomock.NewController(t)
+
+	mockCollection := mocks.NewMockMongoCollectionInterface(ctrl)
+	mockDatabase := mocks.NewMockMongoDatabaseInterface(ctrl)
+
+	svc := MongoDeadLetterService{
+		db:             mockDatabase,
+		CollectionName: "dead_letters",
+	}
+	return ctrl, svc, mockCollection, mockDatabase
+}
+
+func setUpForOutboxService(t *testing.T) (*gomock.Controller, MongoOutboxService,
+	*mocks.MockMongoCollectionInterface, *mocks.MockMongoDatabaseInterface) {
+	ctrl := gomock.NewController(t)
+
+	mockCollection := mocks.NewMockMongoCollectionInterface(ctrl)
+	mockDatabase := mocks.NewMockMongoDatabaseInterface(ctrl)
+
+	svc := MongoOutboxService{
+		db:             mockDatabase,
+		CollectionName: "outbox",
+	}
+	return ctrl, svc, mockCollection, mockDatabase
 }
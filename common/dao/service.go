@@ -1,22 +1,79 @@
 package dao
 
 import (
+	"errors"
+	"time"
+
 	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/deadletter"
 	"github.com/companieshouse/penalty-payment-api/common/e5"
 	"github.com/companieshouse/penalty-payment-api/common/interfaces"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
 	"github.com/companieshouse/penalty-payment-api/config"
 )
 
+// ErrPaymentAlreadyRecorded is returned when payment details are saved for a payable resource that has been marked as
+// paid since it was read
+var ErrPaymentAlreadyRecorded = errors.New("payment details have already been recorded for the payable resource")
+
+// PaymentStatusExpired is the payment status of a payable resource that was not paid within the lifetime of its
+// penalty type, so can no longer be paid
+const PaymentStatusExpired = "expired"
+
+// PaymentStatusCancelled is the payment status of a payable resource that was cancelled by its creator before it was
+// paid, so can no longer be paid
+const PaymentStatusCancelled = "cancelled"
+
 // PayableResourceDaoService interface declares how to interact with the persistence layer regardless of underlying technology
 type PayableResourceDaoService interface {
 	// CreatePayableResource will persist a newly created resource
 	CreatePayableResource(dao *models.PayableResourceDao, requestId string) error
 	// GetPayableResource will find a single payable resource with the given customerCode and payableRef
 	GetPayableResource(customerCode, payableRef string, requestId string) (*models.PayableResourceDao, error)
-	// UpdatePaymentDetails will update the resource with changed values
-	UpdatePaymentDetails(dao *models.PayableResourceDao, requestId string) error
-	// SaveE5Error stored which command to E5 failed e.g. create, authorise or confirm
-	SaveE5Error(customerCode, payableRef string, requestId string, action e5.Action) error
+	// UpdatePaymentDetails will update the resource with changed values and put the messages in the outbox, either
+	// both or neither. It returns ErrPaymentAlreadyRecorded if the resource has already been marked as paid, has
+	// expired or has been cancelled.
+	UpdatePaymentDetails(dao *models.PayableResourceDao, messages []outbox.Message, requestId string) error
+	// SaveE5Error stored which command to E5 failed e.g. create, authorise or confirm, and the E5 status and message
+	// code of the failure
+	SaveE5Error(customerCode, payableRef string, requestId string, action e5.Action, e5Err error) error
+	// SaveE5Compensation stores the outcome of the timeout or reject sent to E5 after a payment failed part way through,
+	// clearing the E5 progress if it succeeded as the payment is then closed in E5
+	SaveE5Compensation(customerCode, payableRef string, requestId string, action e5.Action, compensationErr error) error
+	// SaveE5Progress stores the time a step of marking the payment as paid in E5 succeeded
+	SaveE5Progress(customerCode, payableRef string, requestId string, action e5.Action) error
+	// GetE5Progress finds the steps of marking the payment as paid in E5 that have succeeded
+	GetE5Progress(customerCode, payableRef string, requestId string) (*e5.PaymentProgress, error)
+	// RecordPaymentSession stores the ID of a payment session that was started to pay the resource, so that a payment
+	// can be recovered if the payments API never calls back to mark the resource as paid
+	RecordPaymentSession(customerCode, payableRef, paymentID string, requestId string) error
+	// RecordUnidentifiedPaymentSession stores that a payment session was started to pay the resource without its ID being
+	// given, so that the resource is not expired while the recovery job cannot check whether it was paid
+	RecordUnidentifiedPaymentSession(customerCode, payableRef string, requestId string) error
+	// GetPaymentSessions finds the IDs of the payment sessions recorded for the resource
+	GetPaymentSessions(customerCode, payableRef string, requestId string) ([]string, error)
+	// SaveFailedMarkAsPaidSteps stores the steps of marking the resource as paid that failed, replacing those stored
+	// before
+	SaveFailedMarkAsPaidSteps(customerCode, payableRef string, steps []string, requestId string) error
+	// GetFailedMarkAsPaidSteps finds the steps of marking the resource as paid that were stored as failed
+	GetFailedMarkAsPaidSteps(customerCode, payableRef string, requestId string) ([]string, error)
+	// AddOutboxMessages puts the messages in the outbox, skipping any that are already there
+	AddOutboxMessages(messages []outbox.Message, requestId string) error
+	// GetStalePendingPayableResources finds up to limit resources that were created after createdAfter and before
+	// createdBefore and are still pending payment, oldest first
+	GetStalePendingPayableResources(createdAfter, createdBefore time.Time, limit int, requestId string) ([]models.PayableResourceDao, error)
+	// GetExpirablePayableResources finds up to limit resources that were created after createdAfter and before
+	// createdBefore, are still pending payment and have had no payment session recorded since createdBefore, oldest
+	// first
+	GetExpirablePayableResources(createdAfter, createdBefore time.Time, limit int, requestId string) ([]models.PayableResourceDao, error)
+	// ExpirePayableResource marks the resource as expired if it is still pending payment and no payment session has
+	// been recorded for it since sessionsRecordedBefore. It returns false if the resource was not expired.
+	ExpirePayableResource(customerCode, payableRef string, expiredAt, sessionsRecordedBefore time.Time, requestId string) (bool, error)
+	// CancelPayableResource marks the resource as cancelled if it is still pending payment. It returns false if the
+	// resource was not cancelled.
+	CancelPayableResource(customerCode, payableRef string, cancelledAt time.Time, requestId string) (bool, error)
+	// EnsureExpiredTTLIndex creates the index that deletes expired resources once they have been expired for ttl
+	EnsureExpiredTTLIndex(ttl time.Duration) error
 	// Shutdown can be called to clean up any open resources that the service may be holding on to.
 	Shutdown()
 }
@@ -25,9 +82,11 @@ type PayableResourceDaoService interface {
 // All details about its implementation and the database driver will be hidden from outside of this package
 func NewPayableResourcesDaoService(mongoClientProvider interfaces.MongoClientProvider, cfg *config.Config) PayableResourceDaoService {
 	return &MongoPayableResourceService{
-		mongoClientProvider: mongoClientProvider,
-		db:                  &MongoDatabaseWrapper{db: mongoClientProvider.Database(cfg.Database)},
-		CollectionName:      cfg.PayableResourcesCollection,
+		mongoClientProvider:  mongoClientProvider,
+		db:                   &MongoDatabaseWrapper{db: mongoClientProvider.Database(cfg.Database)},
+		DatabaseName:         cfg.Database,
+		CollectionName:       cfg.PayableResourcesCollection,
+		OutboxCollectionName: cfg.OutboxCollection,
 	}
 }
 
@@ -40,6 +99,9 @@ type AccountPenaltiesDaoService interface {
 	GetAccountPenalties(customerCode string, companyCode string, requestId string) (*models.AccountPenaltiesDao, error)
 	// UpdateAccountPenaltyAsPaid will update a transactions as paid for a given customerCode, companyCode and penaltyRef
 	UpdateAccountPenaltyAsPaid(customerCode string, companyCode string, penaltyRef string, requestId string) error
+	// UpdateAccountPenaltyAsPartPaid will reduce the outstanding amount of a transaction by the amount paid for a given
+	// customerCode, companyCode and penaltyRef, unless the amount paid pays off the transaction
+	UpdateAccountPenaltyAsPartPaid(customerCode string, companyCode string, penaltyRef string, amountPaid float64, requestId string) (bool, error)
 	// UpdateAccountPenalties will update the created_at, closed_at and data fields of an existing document
 	UpdateAccountPenalties(dao *models.AccountPenaltiesDao, requestId string) error
 }
@@ -53,3 +115,72 @@ func NewAccountPenaltiesDaoService(mongoClientProvider interfaces.MongoClientPro
 		CollectionName:      cfg.AccountPenaltiesCollection,
 	}
 }
+
+// ProcessedMessageDaoService interface declares how to record the penalty payment messages that have been processed,
+// so that messages redelivered by Kafka can be detected regardless of underlying technology
+type ProcessedMessageDaoService interface {
+	// ClaimMessage records that processing of the message for the E5 payment ID has started. It returns false if the
+	// message has already been processed. It does not stop the message being claimed again before it is marked as
+	// processed, so it does not guard against two deliveries of the message being processed at the same time.
+	ClaimMessage(e5PaymentID string, requestId string) (bool, error)
+	// MarkMessageProcessed records that the message for the E5 payment ID has been processed
+	MarkMessageProcessed(e5PaymentID string, requestId string) error
+}
+
+// NewProcessedMessagesDaoService will create a new instance of the ProcessedMessageDaoService interface.
+// All details about its implementation and the database driver will be hidden from outside of this package
+func NewProcessedMessagesDaoService(mongoClientProvider interfaces.MongoClientProvider, cfg *config.Config) ProcessedMessageDaoService {
+	return &MongoProcessedMessageService{
+		db:             &MongoDatabaseWrapper{db: mongoClientProvider.Database(cfg.Database)},
+		CollectionName: cfg.ProcessedMessagesCollection,
+	}
+}
+
+// DeadLetterDaoService interface declares how to quarantine the penalty payment messages that could not be processed
+// regardless of underlying technology
+type DeadLetterDaoService interface {
+	// SaveDeadLetter will persist a dead-lettered message unless it has already been quarantined
+	SaveDeadLetter(message *deadletter.Message, requestId string) error
+	// GetDeadLetters will find the most recently dead-lettered messages, optionally only those with the given status
+	GetDeadLetters(status deadletter.Status, limit int, requestId string) ([]deadletter.Message, error)
+	// GetDeadLetter will find a single dead-lettered message with the given id, or nil if there is no such message
+	GetDeadLetter(id string, requestId string) (*deadletter.Message, error)
+	// MarkDeadLetterReplayed will record that the dead-lettered message has been replayed
+	MarkDeadLetterReplayed(id string, requestId string) error
+}
+
+// NewDeadLettersDaoService will create a new instance of the DeadLetterDaoService interface.
+// All details about its implementation and the database driver will be hidden from outside of this package
+func NewDeadLettersDaoService(mongoClientProvider interfaces.MongoClientProvider, cfg *config.Config) DeadLetterDaoService {
+	return &MongoDeadLetterService{
+		db:             &MongoDatabaseWrapper{db: mongoClientProvider.Database(cfg.Database)},
+		CollectionName: cfg.DeadLettersCollection,
+	}
+}
+
+// OutboxDaoService interface declares how to read and update the Kafka messages waiting in the outbox to be published
+// regardless of underlying technology
+type OutboxDaoService interface {
+	// GetPendingOutboxMessages will find the pending messages that are due to be published, oldest first
+	GetPendingOutboxMessages(now time.Time, limit int, requestId string) ([]outbox.Message, error)
+	// ClaimOutboxMessage will stop the pending message being published by anything else until leaseUntil. It returns
+	// false if the message has already been published or claimed.
+	ClaimOutboxMessage(id string, now, leaseUntil time.Time, requestId string) (bool, error)
+	// MarkOutboxMessagePublished will record that the message has been published
+	MarkOutboxMessagePublished(id string, requestId string) error
+	// MarkOutboxMessageFailed will record that publishing the message failed and when it should next be tried
+	MarkOutboxMessageFailed(id string, publishErr error, nextAttemptAt time.Time, requestId string) error
+	// EnsurePublishedTTLIndex creates the index that deletes published messages once they have been published for ttl
+	EnsurePublishedTTLIndex(ttl time.Duration) error
+}
+
+// NewOutboxDaoService will create a new instance of the OutboxDaoService interface.
+// All details about its implementation and the database driver will be hidden from outside of this package
+func NewOutboxDaoService(mongoClientProvider interfaces.MongoClientProvider, cfg *config.Config) OutboxDaoService {
+	return &MongoOutboxService{
+		mongoClientProvider: mongoClientProvider,
+		db:                  &MongoDatabaseWrapper{db: mongoClientProvider.Database(cfg.Database)},
+		DatabaseName:        cfg.Database,
+		CollectionName:      cfg.OutboxCollection,
+	}
+}
@@ -48,4 +48,46 @@ func TestUnitService(t *testing.T) {
 		apDaoService := NewAccountPenaltiesDaoService(mockMongoClientProvider, cfg)
 		So(apDaoService, ShouldNotBeNil)
 	})
+
+	Convey("successful creation of new processed messages dao service", t, func() {
+		mockMongoClientProvider := mocks.NewMockMongoClientProvider(ctrl)
+		mockMongoClientProvider.EXPECT().Database("test").Return(mockDatabase)
+
+		cfg := &config.Config{
+			MongoDBURL:                  dbUrl,
+			Database:                    db,
+			ProcessedMessagesCollection: "processed_penalty_payments",
+		}
+
+		pmDaoService := NewProcessedMessagesDaoService(mockMongoClientProvider, cfg)
+		So(pmDaoService, ShouldNotBeNil)
+	})
+
+	Convey("successful creation of new dead letters dao service", t, func() {
+		mockMongoClientProvider := mocks.NewMockMongoClientProvider(ctrl)
+		mockMongoClientProvider.EXPECT().Database("test").Return(mockDatabase)
+
+		cfg := &config.Config{
+			MongoDBURL:            dbUrl,
+			Database:              db,
+			DeadLettersCollection: "penalty_payments_dead_letters",
+		}
+
+		dlDaoService := NewDeadLettersDaoService(mockMongoClientProvider, cfg)
+		So(dlDaoService, ShouldNotBeNil)
+	})
+
+	Convey("successful creation of new outbox dao service", t, func() {
+		mockMongoClientProvider := mocks.NewMockMongoClientProvider(ctrl)
+		mockMongoClientProvider.EXPECT().Database("test").Return(mockDatabase)
+
+		cfg := &config.Config{
+			MongoDBURL:       dbUrl,
+			Database:         db,
+			OutboxCollection: "penalty_payments_outbox",
+		}
+
+		outboxDaoService := NewOutboxDaoService(mockMongoClientProvider, cfg)
+		So(outboxDaoService, ShouldNotBeNil)
+	})
 }
@@ -0,0 +1,136 @@
+// Package deadletter holds the penalty payments processing messages that could not be processed, so that they can be
+// inspected and replayed once the cause has been fixed.
+package deadletter
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// Reason is why a message was dead-lettered
+type Reason string
+
+const (
+	// Undecodable messages could not be unmarshalled with the penalty payments processing schema
+	Undecodable Reason = "undecodable"
+	// RetriesExhausted messages still failed when they were processed for the last time from the retry topic
+	RetriesExhausted Reason = "retries_exhausted"
+	// PayableResourceNotFound messages are for a payable resource that does not exist, so they fail however many times
+	// they are retried
+	PayableResourceNotFound Reason = "payable_resource_not_found"
+)
+
+// Status is where a dead-lettered message is in its lifecycle
+type Status string
+
+const (
+	// Quarantined messages are waiting to be replayed
+	Quarantined Status = "quarantined"
+	// Replayed messages have been published to the penalty payments processing topic again
+	Replayed Status = "replayed"
+)
+
+// headers added to the original headers of a message published to the dead-letter topic
+const (
+	ReasonHeader            = "dead_letter_reason"
+	ErrorHeader             = "dead_letter_error"
+	OriginalTopicHeader     = "dead_letter_original_topic"
+	OriginalPartitionHeader = "dead_letter_original_partition"
+	OriginalOffsetHeader    = "dead_letter_original_offset"
+	DeadLetteredAtHeader    = "dead_letter_timestamp"
+	ReplayedFromHeader      = "replayed_from_dead_letter"
+)
+
+// Message is a dead-lettered message with the original key, value and headers it was consumed with
+type Message struct {
+	ID             string            `bson:"_id"                   json:"id"`
+	Topic          string            `bson:"topic"                 json:"topic"`
+	Partition      int32             `bson:"partition"             json:"partition"`
+	Offset         int64             `bson:"offset"                json:"offset"`
+	Key            []byte            `bson:"key,omitempty"         json:"key,omitempty"`
+	Value          []byte            `bson:"value"                 json:"value"`
+	Headers        map[string]string `bson:"headers,omitempty"     json:"headers,omitempty"`
+	Reason         Reason            `bson:"reason"                json:"reason"`
+	Error          string            `bson:"error"                 json:"error"`
+	Status         Status            `bson:"status"                json:"status"`
+	DeadLetteredAt time.Time         `bson:"dead_lettered_at"      json:"dead_lettered_at"`
+	ReplayedAt     *time.Time        `bson:"replayed_at,omitempty" json:"replayed_at,omitempty"`
+	ReplayCount    int               `bson:"replay_count"          json:"replay_count"`
+}
+
+// NewMessage builds the dead-lettered message for a consumed message. The ID is derived from where the message was
+// consumed from, so dead-lettering a redelivered message again does not quarantine it twice.
+func NewMessage(message *sarama.ConsumerMessage, reason Reason, err error) *Message {
+	headers := make(map[string]string, len(message.Headers))
+	for _, header := range message.Headers {
+		if header != nil {
+			headers[string(header.Key)] = string(header.Value)
+		}
+	}
+
+	var errText string
+	if err != nil {
+		errText = err.Error()
+	}
+
+	return &Message{
+		ID:             fmt.Sprintf("%s-%d-%d", message.Topic, message.Partition, message.Offset),
+		Topic:          message.Topic,
+		Partition:      message.Partition,
+		Offset:         message.Offset,
+		Key:            message.Key,
+		Value:          message.Value,
+		Headers:        headers,
+		Reason:         reason,
+		Error:          errText,
+		Status:         Quarantined,
+		DeadLetteredAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+}
+
+// DeadLetterProducerMessage builds the message published to the dead-letter topic. It carries the original key,
+// bytes and headers, and headers describing why and where from it was dead-lettered.
+func (m *Message) DeadLetterProducerMessage(topic string) *sarama.ProducerMessage {
+	headers := m.recordHeaders()
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(ReasonHeader), Value: []byte(m.Reason)},
+		sarama.RecordHeader{Key: []byte(ErrorHeader), Value: []byte(m.Error)},
+		sarama.RecordHeader{Key: []byte(OriginalTopicHeader), Value: []byte(m.Topic)},
+		sarama.RecordHeader{Key: []byte(OriginalPartitionHeader), Value: []byte(strconv.Itoa(int(m.Partition)))},
+		sarama.RecordHeader{Key: []byte(OriginalOffsetHeader), Value: []byte(strconv.FormatInt(m.Offset, 10))},
+		sarama.RecordHeader{Key: []byte(DeadLetteredAtHeader), Value: []byte(m.DeadLetteredAt.Format(time.RFC3339))},
+	)
+
+	return m.producerMessage(topic, headers)
+}
+
+// ReplayProducerMessage builds the message published to replay the dead-lettered message. It carries the original
+// key, bytes and headers, and a header with the ID of the dead-lettered message.
+func (m *Message) ReplayProducerMessage(topic string) *sarama.ProducerMessage {
+	headers := append(m.recordHeaders(), sarama.RecordHeader{Key: []byte(ReplayedFromHeader), Value: []byte(m.ID)})
+
+	return m.producerMessage(topic, headers)
+}
+
+func (m *Message) recordHeaders() []sarama.RecordHeader {
+	headers := make([]sarama.RecordHeader, 0, len(m.Headers)+6)
+	for key, value := range m.Headers {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	}
+	return headers
+}
+
+func (m *Message) producerMessage(topic string, headers []sarama.RecordHeader) *sarama.ProducerMessage {
+	message := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(m.Value),
+		Headers: headers,
+	}
+	if len(m.Key) > 0 {
+		message.Key = sarama.ByteEncoder(m.Key)
+	}
+	return message
+}
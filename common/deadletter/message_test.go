@@ -0,0 +1,69 @@
+package deadletter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func headerValues(headers []sarama.RecordHeader) map[string]string {
+	values := map[string]string{}
+	for _, header := range headers {
+		values[string(header.Key)] = string(header.Value)
+	}
+	return values
+}
+
+func TestUnitMessage(t *testing.T) {
+	Convey("Given a message that could not be decoded", t, func() {
+		consumed := &sarama.ConsumerMessage{
+			Topic:     "penalty-payments-processing",
+			Partition: 2,
+			Offset:    42,
+			Key:       []byte("XP1"),
+			Value:     []byte{0x00, 0x01, 0x02},
+			Headers:   []*sarama.RecordHeader{{Key: []byte("trace_id"), Value: []byte("abc")}},
+		}
+
+		message := NewMessage(consumed, Undecodable, errors.New("End of file reached"))
+
+		Convey("Then it is quarantined with the original bytes, error and headers", func() {
+			So(message.ID, ShouldEqual, "penalty-payments-processing-2-42")
+			So(message.Value, ShouldResemble, consumed.Value)
+			So(message.Error, ShouldEqual, "End of file reached")
+			So(message.Headers, ShouldResemble, map[string]string{"trace_id": "abc"})
+			So(message.Status, ShouldEqual, Quarantined)
+		})
+
+		Convey("Then the dead-letter topic message describes why it was dead-lettered", func() {
+			produced := message.DeadLetterProducerMessage("penalty-payments-processing-dead-letter")
+
+			So(produced.Topic, ShouldEqual, "penalty-payments-processing-dead-letter")
+			So(produced.Value, ShouldResemble, sarama.ByteEncoder(consumed.Value))
+			So(produced.Key, ShouldResemble, sarama.ByteEncoder("XP1"))
+
+			headers := headerValues(produced.Headers)
+			So(headers["trace_id"], ShouldEqual, "abc")
+			So(headers[ReasonHeader], ShouldEqual, "undecodable")
+			So(headers[ErrorHeader], ShouldEqual, "End of file reached")
+			So(headers[OriginalTopicHeader], ShouldEqual, "penalty-payments-processing")
+			So(headers[OriginalPartitionHeader], ShouldEqual, "2")
+			So(headers[OriginalOffsetHeader], ShouldEqual, "42")
+		})
+
+		Convey("Then the replayed message carries the original bytes and headers", func() {
+			produced := message.ReplayProducerMessage("penalty-payments-processing")
+
+			So(produced.Topic, ShouldEqual, "penalty-payments-processing")
+			So(produced.Value, ShouldResemble, sarama.ByteEncoder(consumed.Value))
+
+			headers := headerValues(produced.Headers)
+			So(headers, ShouldResemble, map[string]string{
+				"trace_id":         "abc",
+				ReplayedFromHeader: "penalty-payments-processing-2-42",
+			})
+		})
+	})
+}
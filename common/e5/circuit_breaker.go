@@ -0,0 +1,212 @@
+package e5
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+)
+
+// ErrCircuitOpen is returned without calling E5 while the circuit breaker is open
+var ErrCircuitOpen = errors.New("E5 is unavailable, circuit breaker is open")
+
+const (
+	// DefaultFailureThreshold is the number of consecutive failures that open the circuit when none is configured
+	DefaultFailureThreshold = 5
+	// DefaultOpenDuration is how long the circuit stays open before a trial request is allowed when none is configured
+	DefaultOpenDuration = 30 * time.Second
+)
+
+// CircuitBreakerConfig holds the settings of the E5 circuit breaker
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that open the circuit
+	FailureThreshold int
+	// OpenDuration is how long the circuit stays open before a single trial request is let through
+	OpenDuration time.Duration
+}
+
+// CircuitBreaker wraps an E5 client and stops calling E5 once it has failed repeatedly, so that callers fail fast
+// instead of waiting on an E5 that is down. The circuit opens after FailureThreshold consecutive internal server or
+// transport errors. Once OpenDuration has passed a single trial request is allowed through; if it succeeds the circuit
+// closes, otherwise it opens again. Any 5xx response counts as a failure, including a 502 or 503 from a gateway in
+// front of E5.
+type CircuitBreaker struct {
+	client           ClientInterface
+	failureThreshold int
+	openDuration     time.Duration
+	now              func() time.Time
+
+	mtx           sync.Mutex
+	failures      int
+	openUntil     time.Time
+	trialInFlight bool
+}
+
+// NewCircuitBreaker will construct a circuit breaker around the E5 client. Any zero settings are replaced with the
+// defaults.
+func NewCircuitBreaker(client ClientInterface, cfg CircuitBreakerConfig) *CircuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = DefaultFailureThreshold
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = DefaultOpenDuration
+	}
+
+	return &CircuitBreaker{
+		client:           client,
+		failureThreshold: cfg.FailureThreshold,
+		openDuration:     cfg.OpenDuration,
+		now:              time.Now,
+	}
+}
+
+// State reports whether the circuit is open and, if so, the time at which E5 is expected to be retried
+func (b *CircuitBreaker) State() (open bool, retryAt time.Time) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.openUntil.IsZero() {
+		return false, time.Time{}
+	}
+
+	retryAt = b.openUntil
+	if now := b.now(); retryAt.Before(now) {
+		// a trial request is due, so recovery is expected imminently
+		retryAt = now
+	}
+
+	return true, retryAt
+}
+
+// GetTransactions will return a list of transactions for a company unless the circuit is open
+func (b *CircuitBreaker) GetTransactions(ctx context.Context, input *GetTransactionsInput, requestId string) (*GetTransactionsResponse, error) {
+	if err := b.allow(requestId); err != nil {
+		return nil, err
+	}
+	resp, err := b.client.GetTransactions(ctx, input, requestId)
+	b.record(err, requestId)
+	return resp, err
+}
+
+// GetCompanyTransactions will read the transactions within a company code a page at a time unless the circuit is open
+func (b *CircuitBreaker) GetCompanyTransactions(ctx context.Context, input *GetCompanyTransactionsInput, handlePage TransactionsPageHandler,
+	requestId string) error {
+	return b.do(requestId, func() error {
+		return b.client.GetCompanyTransactions(ctx, input, handlePage, requestId)
+	})
+}
+
+// CreatePayment will create a new payment session in E5 unless the circuit is open
+func (b *CircuitBreaker) CreatePayment(ctx context.Context, input *CreatePaymentInput, requestId string) error {
+	return b.do(requestId, func() error {
+		return b.client.CreatePayment(ctx, input, requestId)
+	})
+}
+
+// AuthorisePayment will mark the payment as authorised in E5 unless the circuit is open
+func (b *CircuitBreaker) AuthorisePayment(ctx context.Context, input *AuthorisePaymentInput, requestId string) error {
+	return b.do(requestId, func() error {
+		return b.client.AuthorisePayment(ctx, input, requestId)
+	})
+}
+
+// ConfirmPayment allocates the money in E5 unless the circuit is open
+func (b *CircuitBreaker) ConfirmPayment(ctx context.Context, input *PaymentActionInput, requestId string) error {
+	return b.do(requestId, func() error {
+		return b.client.ConfirmPayment(ctx, input, requestId)
+	})
+}
+
+// TimeoutPayment will unlock the customer account in E5 unless the circuit is open
+func (b *CircuitBreaker) TimeoutPayment(ctx context.Context, input *PaymentActionInput, requestId string) error {
+	return b.do(requestId, func() error {
+		return b.client.TimeoutPayment(ctx, input, requestId)
+	})
+}
+
+// RejectPayment will mark a payment as rejected in E5 unless the circuit is open
+func (b *CircuitBreaker) RejectPayment(ctx context.Context, input *PaymentActionInput, requestId string) error {
+	return b.do(requestId, func() error {
+		return b.client.RejectPayment(ctx, input, requestId)
+	})
+}
+
+func (b *CircuitBreaker) do(requestId string, fn func() error) error {
+	if err := b.allow(requestId); err != nil {
+		return err
+	}
+	err := fn()
+	b.record(err, requestId)
+	return err
+}
+
+// allow returns ErrCircuitOpen if the request must not be sent to E5
+func (b *CircuitBreaker) allow(requestId string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if b.now().Before(b.openUntil) || b.trialInFlight {
+		log.InfoC(requestId, "E5 circuit breaker is open, not sending request", log.Data{"retry_at": b.openUntil})
+		return ErrCircuitOpen
+	}
+
+	log.InfoC(requestId, "E5 circuit breaker is half open, sending trial request")
+	b.trialInFlight = true
+	return nil
+}
+
+// record updates the state of the circuit with the outcome of a request to E5
+func (b *CircuitBreaker) record(err error, requestId string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	trial := b.trialInFlight
+	b.trialInFlight = false
+
+	switch {
+	case isE5Failure(err):
+		b.failures++
+		if trial || b.failures >= b.failureThreshold {
+			b.openUntil = b.now().Add(b.openDuration)
+			log.ErrorC(requestId, ErrCircuitOpen, log.Data{
+				"consecutive_failures": b.failures,
+				"retry_at":             b.openUntil,
+				"error":                err.Error(),
+			})
+		}
+	case isE5Response(err):
+		if !b.openUntil.IsZero() {
+			log.InfoC(requestId, "E5 circuit breaker closed")
+		}
+		b.failures = 0
+		b.openUntil = time.Time{}
+	}
+}
+
+// isE5Failure reports whether the error means that E5 is failing, as opposed to rejecting the request
+func isE5Failure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrE5InternalServer) || isServerError(err) {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// isE5Response reports whether E5 responded to the request, which shows that it is available. It is only checked
+// once the error is known not to be a failure, so a 5xx that unwraps to ErrUnexpectedServerError is not counted.
+func isE5Response(err error) bool {
+	return err == nil ||
+		errors.Is(err, ErrE5BadRequest) ||
+		errors.Is(err, ErrE5NotFound) ||
+		errors.Is(err, ErrUnexpectedServerError) ||
+		errors.Is(err, ErrFailedToReadBody)
+}
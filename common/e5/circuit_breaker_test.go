@@ -0,0 +1,176 @@
+package e5
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type stubClient struct {
+	err   error
+	calls int
+}
+
+func (s *stubClient) GetTransactions(_ context.Context, _ *GetTransactionsInput, _ string) (*GetTransactionsResponse, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &GetTransactionsResponse{}, nil
+}
+
+func (s *stubClient) GetCompanyTransactions(_ context.Context, _ *GetCompanyTransactionsInput, _ TransactionsPageHandler, _ string) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubClient) CreatePayment(_ context.Context, _ *CreatePaymentInput, _ string) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubClient) AuthorisePayment(_ context.Context, _ *AuthorisePaymentInput, _ string) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubClient) ConfirmPayment(_ context.Context, _ *PaymentActionInput, _ string) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubClient) TimeoutPayment(_ context.Context, _ *PaymentActionInput, _ string) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubClient) RejectPayment(_ context.Context, _ *PaymentActionInput, _ string) error {
+	s.calls++
+	return s.err
+}
+
+var _ ClientInterface = (*CircuitBreaker)(nil)
+
+func TestUnitCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	transportErr := &url.Error{Op: "Post", URL: "https://e5", Err: errors.New("connection refused")}
+
+	Convey("Given a circuit breaker around E5", t, func() {
+		client := &stubClient{}
+		now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+		breaker := NewCircuitBreaker(client, CircuitBreakerConfig{FailureThreshold: 3, OpenDuration: time.Minute})
+		breaker.now = func() time.Time { return now }
+
+		Convey("When E5 fails fewer times than the threshold then the circuit stays closed", func() {
+			client.err = ErrE5InternalServer
+			for i := 0; i < 2; i++ {
+				So(breaker.ConfirmPayment(ctx, &PaymentActionInput{}, requestId), ShouldEqual, ErrE5InternalServer)
+			}
+
+			open, _ := breaker.State()
+			So(open, ShouldBeFalse)
+		})
+
+		Convey("When E5 rejects requests then the circuit stays closed", func() {
+			client.err = ErrE5BadRequest
+			for i := 0; i < 5; i++ {
+				So(breaker.CreatePayment(ctx, &CreatePaymentInput{}, requestId), ShouldEqual, ErrE5BadRequest)
+			}
+
+			open, _ := breaker.State()
+			So(open, ShouldBeFalse)
+		})
+
+		Convey("When a gateway in front of E5 returns server errors then the circuit opens", func() {
+			client.err = &APIError{StatusCode: http.StatusServiceUnavailable}
+			for i := 0; i < 3; i++ {
+				So(breaker.ConfirmPayment(ctx, &PaymentActionInput{}, requestId), ShouldNotBeNil)
+			}
+
+			open, _ := breaker.State()
+			So(open, ShouldBeTrue)
+		})
+
+		Convey("When E5 returns an unexpected client error then the circuit stays closed", func() {
+			client.err = &APIError{StatusCode: http.StatusForbidden}
+			for i := 0; i < 5; i++ {
+				So(breaker.ConfirmPayment(ctx, &PaymentActionInput{}, requestId), ShouldNotBeNil)
+			}
+
+			open, _ := breaker.State()
+			So(open, ShouldBeFalse)
+		})
+
+		Convey("When a success follows failures then the failure count is reset", func() {
+			client.err = ErrE5InternalServer
+			So(breaker.ConfirmPayment(ctx, &PaymentActionInput{}, requestId), ShouldNotBeNil)
+			So(breaker.ConfirmPayment(ctx, &PaymentActionInput{}, requestId), ShouldNotBeNil)
+			client.err = nil
+			So(breaker.ConfirmPayment(ctx, &PaymentActionInput{}, requestId), ShouldBeNil)
+			client.err = ErrE5InternalServer
+			So(breaker.ConfirmPayment(ctx, &PaymentActionInput{}, requestId), ShouldNotBeNil)
+
+			open, _ := breaker.State()
+			So(open, ShouldBeFalse)
+		})
+
+		Convey("When E5 fails repeatedly", func() {
+			client.err = transportErr
+			for i := 0; i < 3; i++ {
+				_, err := breaker.GetTransactions(ctx, &GetTransactionsInput{}, requestId)
+				So(err, ShouldEqual, transportErr)
+			}
+
+			Convey("Then the circuit opens with the time E5 will be retried", func() {
+				open, retryAt := breaker.State()
+				So(open, ShouldBeTrue)
+				So(retryAt, ShouldEqual, now.Add(time.Minute))
+			})
+
+			Convey("Then requests fail fast without calling E5", func() {
+				calls := client.calls
+				err := breaker.AuthorisePayment(ctx, &AuthorisePaymentInput{}, requestId)
+
+				So(err, ShouldEqual, ErrCircuitOpen)
+				So(client.calls, ShouldEqual, calls)
+			})
+
+			Convey("And the open duration passes and the trial request succeeds then the circuit closes", func() {
+				now = now.Add(time.Minute)
+				client.err = nil
+
+				_, err := breaker.GetTransactions(ctx, &GetTransactionsInput{}, requestId)
+
+				So(err, ShouldBeNil)
+				open, _ := breaker.State()
+				So(open, ShouldBeFalse)
+			})
+
+			Convey("And the open duration passes and the trial request fails then the circuit opens again", func() {
+				now = now.Add(time.Minute)
+
+				_, err := breaker.GetTransactions(ctx, &GetTransactionsInput{}, requestId)
+
+				So(err, ShouldEqual, transportErr)
+				open, retryAt := breaker.State()
+				So(open, ShouldBeTrue)
+				So(retryAt, ShouldEqual, now.Add(time.Minute))
+			})
+
+			Convey("And the request is cancelled by the caller then the circuit is unchanged", func() {
+				now = now.Add(time.Minute)
+				client.err = &url.Error{Op: "Post", URL: "https://e5", Err: context.Canceled}
+
+				So(breaker.RejectPayment(ctx, &PaymentActionInput{}, requestId), ShouldNotBeNil)
+
+				open, _ := breaker.State()
+				So(open, ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -2,11 +2,14 @@ package e5
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"gopkg.in/go-playground/validator.v9"
 
@@ -25,6 +28,19 @@ var (
 	// ErrUnexpectedServerError represents anything other than a 400, 404 or 500 - which would be something not
 	// documented in their API
 	ErrUnexpectedServerError = errors.New("unexpected server error")
+	// ErrTooManyTransactionPages is returned rather than reading pages without end when E5 reports more pages of
+	// transactions than can be read in one request
+	ErrTooManyTransactionPages = errors.New("too many pages of transactions")
+)
+
+// maxTransactionPages is the most pages of transactions that are read from E5 in one request
+var maxTransactionPages = 1000
+
+const (
+	// DefaultTransactionsFromDate is used when no from date is supplied so that all transactions are returned
+	DefaultTransactionsFromDate = "1990-01-01"
+
+	transactionDateLayout = "2006-01-02"
 )
 
 // Action is the type that describes a payment call to E5
@@ -43,24 +59,31 @@ const (
 	RejectAction Action = "reject"
 )
 
+// TransactionsPageHandler is called with each page of transactions as it is read from E5. An error returned by the
+// handler stops any later pages from being read.
+type TransactionsPageHandler func(page *GetTransactionsResponse) error
+
 // ClientInterface interface declares the Client finance system operations for AR Transactions and Payments
 type ClientInterface interface {
-	GetTransactions(input *GetTransactionsInput, requestId string) (*GetTransactionsResponse, error)
-	CreatePayment(input *CreatePaymentInput, requestId string) error
-	AuthorisePayment(input *AuthorisePaymentInput, requestId string) error
-	ConfirmPayment(input *PaymentActionInput, requestId string) error
-	TimeoutPayment(input *PaymentActionInput, requestId string) error
-	RejectPayment(input *PaymentActionInput, requestId string) error
+	GetTransactions(ctx context.Context, input *GetTransactionsInput, requestId string) (*GetTransactionsResponse, error)
+	GetCompanyTransactions(ctx context.Context, input *GetCompanyTransactionsInput, handlePage TransactionsPageHandler, requestId string) error
+	CreatePayment(ctx context.Context, input *CreatePaymentInput, requestId string) error
+	AuthorisePayment(ctx context.Context, input *AuthorisePaymentInput, requestId string) error
+	ConfirmPayment(ctx context.Context, input *PaymentActionInput, requestId string) error
+	TimeoutPayment(ctx context.Context, input *PaymentActionInput, requestId string) error
+	RejectPayment(ctx context.Context, input *PaymentActionInput, requestId string) error
 }
 
 // Client interacts with the Client finance system
 type Client struct {
 	E5Username string
 	E5BaseURL  string
+	HTTPClient *http.Client
 }
 
-// GetTransactions will return a list of transactions for a company
-func (c *Client) GetTransactions(input *GetTransactionsInput, requestId string) (*GetTransactionsResponse, error) {
+// GetTransactions will return a list of transactions for a company. Every page from the input page number onwards is
+// read from E5 and the transactions merged into a single response.
+func (c *Client) GetTransactions(ctx context.Context, input *GetTransactionsInput, requestId string) (*GetTransactionsResponse, error) {
 	err := c.validateInput(input)
 	if err != nil {
 		return nil, err
@@ -68,14 +91,155 @@ func (c *Client) GetTransactions(input *GetTransactionsInput, requestId string)
 
 	logContext := log.Data{"customer_code": input.CustomerCode}
 
+	qp, err := transactionsQueryParameters(input.CompanyCode, input.FromDate, input.ToDate, input.TransactionType,
+		input.TransactionSubType)
+	if err != nil {
+		log.ErrorC(requestId, err, logContext)
+		return nil, err
+	}
+	if input.LedgerCode != "" {
+		qp["ledgerCode"] = input.LedgerCode
+	}
+
 	path := fmt.Sprintf("/arTransactions/%s", input.CustomerCode)
+
+	return c.getAllTransactionPages(ctx, path, qp, input.PageNumber, logContext, requestId)
+}
+
+// GetCompanyTransactions will read the transactions of every customer within a company code, passing each page to
+// handlePage as it is read so that the transactions of a large company code are not held in memory
+func (c *Client) GetCompanyTransactions(ctx context.Context, input *GetCompanyTransactionsInput, handlePage TransactionsPageHandler,
+	requestId string) error {
+	err := c.validateInput(input)
+	if err != nil {
+		return err
+	}
+
+	logContext := log.Data{
+		"company_code": input.CompanyCode,
+		"from_date":    input.FromDate,
+		"to_date":      input.ToDate,
+	}
+
+	qp, err := transactionsQueryParameters(input.CompanyCode, input.FromDate, input.ToDate, input.TransactionType,
+		input.TransactionSubType)
+	if err != nil {
+		log.ErrorC(requestId, err, logContext)
+		return err
+	}
+
+	return c.readTransactionPages(ctx, "/arTransactions", qp, input.PageNumber, handlePage, logContext, requestId)
+}
+
+// transactionsQueryParameters builds the query parameters shared by the transaction endpoints, defaulting the from
+// date so that the full transaction history is returned
+func transactionsQueryParameters(companyCode, fromDate, toDate, transactionType, transactionSubType string) (map[string]string, error) {
+	if fromDate == "" {
+		fromDate = DefaultTransactionsFromDate
+	}
+	if _, err := time.Parse(transactionDateLayout, fromDate); err != nil {
+		return nil, fmt.Errorf("invalid from date [%s]: %v", fromDate, err)
+	}
+
 	qp := map[string]string{
-		"companyCode": input.CompanyCode,
-		"fromDate":    "1990-01-01",
+		"companyCode": companyCode,
+		"fromDate":    fromDate,
+	}
+
+	if toDate != "" {
+		if _, err := time.Parse(transactionDateLayout, toDate); err != nil {
+			return nil, fmt.Errorf("invalid to date [%s]: %v", toDate, err)
+		}
+		qp["toDate"] = toDate
+	}
+	if transactionType != "" {
+		qp["transactionType"] = transactionType
+	}
+	if transactionSubType != "" {
+		qp["transactionSubType"] = transactionSubType
+	}
+
+	return qp, nil
+}
+
+// getAllTransactionPages reads every page of transactions from the start page until the last page reported by E5 and
+// merges them into a single response
+func (c *Client) getAllTransactionPages(ctx context.Context, path string, queryParameters map[string]string, startPage int,
+	logContext log.Data, requestId string) (*GetTransactionsResponse, error) {
+	out := &GetTransactionsResponse{
+		Page:         Page{},
+		Transactions: []Transaction{},
+	}
+
+	err := c.readTransactionPages(ctx, path, queryParameters, startPage, func(page *GetTransactionsResponse) error {
+		out.Transactions = append(out.Transactions, page.Transactions...)
+		out.Page = page.Page
+		return nil
+	}, logContext, requestId)
+	if err != nil {
+		return nil, err
+	}
+
+	out.Page.Size = len(out.Transactions)
+
+	return out, nil
+}
+
+// readTransactionPages reads every page of transactions from the start page until the last page reported by E5,
+// passing each page to handlePage. The pages are counted here rather than trusting the page number that E5 reports,
+// so that a stale page number cannot keep the loop going.
+func (c *Client) readTransactionPages(ctx context.Context, path string, queryParameters map[string]string, startPage int,
+	handlePage TransactionsPageHandler, logContext log.Data, requestId string) error {
+	pagesRead := 0
+	var lastPage Page
+
+	for pageNumber := startPage; ; pageNumber++ {
+		if pagesRead >= maxTransactionPages {
+			err := fmt.Errorf("%w: more than %d pages from page %d", ErrTooManyTransactionPages, maxTransactionPages, startPage)
+			log.ErrorC(requestId, err, logContext)
+			return err
+		}
+
+		page, err := c.getTransactionsPage(ctx, path, queryParameters, pageNumber, logContext, requestId)
+		if err != nil {
+			return err
+		}
+		pagesRead++
+		lastPage = page.Page
+
+		if err = handlePage(page); err != nil {
+			return err
+		}
+
+		// stop when E5 reports no more pages, or returns an empty page to guard against an inconsistent page count
+		if pageNumber+1 >= page.Page.TotalPages || len(page.Transactions) == 0 {
+			break
+		}
+	}
+
+	log.DebugC(requestId, "read all pages of transactions from E5", logContext, log.Data{
+		"start_page":     startPage,
+		"pages_read":     pagesRead,
+		"total_pages":    lastPage.TotalPages,
+		"total_elements": lastPage.TotalElements,
+	})
+
+	return nil
+}
+
+// getTransactionsPage reads a single page of transactions from E5
+func (c *Client) getTransactionsPage(ctx context.Context, path string, queryParameters map[string]string, pageNumber int,
+	logContext log.Data, requestId string) (*GetTransactionsResponse, error) {
+	qp := make(map[string]string, len(queryParameters)+1)
+	for k, v := range queryParameters {
+		qp[k] = v
+	}
+	if pageNumber > 0 {
+		qp["pageNumber"] = strconv.Itoa(pageNumber)
 	}
 
 	// make the http request to E5
-	resp, err := c.sendRequest(http.MethodGet, path, nil, qp, requestId)
+	resp, err := c.sendRequest(ctx, http.MethodGet, path, nil, qp, requestId)
 
 	// deal with any http transport errors
 	if err != nil {
@@ -114,7 +278,7 @@ func (c *Client) GetTransactions(input *GetTransactionsInput, requestId string)
 
 // CreatePayment will create a new payment session in Client. This will lock the account in Client so no other modifications can
 // happen until it is released by a confirm call or manually released in the Client portal.
-func (c *Client) CreatePayment(input *CreatePaymentInput, requestId string) error {
+func (c *Client) CreatePayment(ctx context.Context, input *CreatePaymentInput, requestId string) error {
 	logContext := log.Data{
 		"customer_code":  input.CustomerCode,
 		"company_code":   input.CompanyCode,
@@ -125,6 +289,7 @@ func (c *Client) CreatePayment(input *CreatePaymentInput, requestId string) erro
 	}
 
 	return c.doPaymentRequest(
+		ctx,
 		input,
 		"/arTransactions/payment",
 		logContext,
@@ -136,7 +301,7 @@ func (c *Client) CreatePayment(input *CreatePaymentInput, requestId string) erro
 
 // AuthorisePayment will mark the payment as been authorised by the payment provider, but the money has not yet reached
 // use yet. The customer account will remain locked.
-func (c *Client) AuthorisePayment(input *AuthorisePaymentInput, requestId string) error {
+func (c *Client) AuthorisePayment(ctx context.Context, input *AuthorisePaymentInput, requestId string) error {
 	logContext := log.Data{
 		"company_code":   input.CompanyCode,
 		"payment_action": AuthoriseAction,
@@ -144,6 +309,7 @@ func (c *Client) AuthorisePayment(input *AuthorisePaymentInput, requestId string
 	}
 
 	return c.doPaymentRequest(
+		ctx,
 		input,
 		"/arTransactions/payment/authorise",
 		logContext,
@@ -155,6 +321,7 @@ func (c *Client) AuthorisePayment(input *AuthorisePaymentInput, requestId string
 
 // doPaymentRequest is a wrapper for the create and authorise endpoints
 func (c *Client) doPaymentRequest(
+	ctx context.Context,
 	input interface{},
 	path string,
 	logContext log.Data,
@@ -177,7 +344,7 @@ func (c *Client) doPaymentRequest(
 		"input": input,
 		"path":  path,
 	})
-	resp, err := c.sendRequest(http.MethodPost, path, bytes.NewReader(body), nil, requestId)
+	resp, err := c.sendRequest(ctx, http.MethodPost, path, bytes.NewReader(body), nil, requestId)
 
 	// err here will be an http transport error rather than 4xx or 5xx responses
 	if err != nil {
@@ -195,22 +362,22 @@ func (c *Client) doPaymentRequest(
 }
 
 // ConfirmPayment allocates the money in Client and unlocks the customer account
-func (c *Client) ConfirmPayment(input *PaymentActionInput, requestId string) error {
-	return c.doPaymentAction(ConfirmAction, input, requestId)
+func (c *Client) ConfirmPayment(ctx context.Context, input *PaymentActionInput, requestId string) error {
+	return c.doPaymentAction(ctx, ConfirmAction, input, requestId)
 }
 
 // TimeoutPayment will unlock the customer account
-func (c *Client) TimeoutPayment(input *PaymentActionInput, requestId string) error {
-	return c.doPaymentAction(TimeoutAction, input, requestId)
+func (c *Client) TimeoutPayment(ctx context.Context, input *PaymentActionInput, requestId string) error {
+	return c.doPaymentAction(ctx, TimeoutAction, input, requestId)
 }
 
 // RejectPayment will mark a payment as rejected and unlock the account.
-func (c *Client) RejectPayment(input *PaymentActionInput, requestId string) error {
-	return c.doPaymentAction(RejectAction, input, requestId)
+func (c *Client) RejectPayment(ctx context.Context, input *PaymentActionInput, requestId string) error {
+	return c.doPaymentAction(ctx, RejectAction, input, requestId)
 }
 
 // doPaymentAction is a wrapper for the confirm, reject and timeout endpoints
-func (c *Client) doPaymentAction(action Action, input *PaymentActionInput, requestId string) error {
+func (c *Client) doPaymentAction(ctx context.Context, action Action, input *PaymentActionInput, requestId string) error {
 	err := c.validateInput(input)
 	if err != nil {
 		return err
@@ -236,7 +403,7 @@ func (c *Client) doPaymentAction(action Action, input *PaymentActionInput, reque
 		"input": input,
 		"path":  path,
 	})
-	resp, err := c.sendRequest(http.MethodPost, path, bytes.NewReader(body), nil, requestId)
+	resp, err := c.sendRequest(ctx, http.MethodPost, path, bytes.NewReader(body), nil, requestId)
 
 	// err here will be a http transport error rather than 4xx or 5xx responses
 	if err != nil {
@@ -269,36 +436,29 @@ func (c *Client) checkResponseForError(r *http.Response, requestId string) error
 
 	if err != nil {
 		log.ErrorC(requestId, err, logContext)
-		return ErrFailedToReadBody
+		return unreadableErrorResponse(r.StatusCode)
 	}
 
 	err = json.Unmarshal(b, e)
 	if err != nil {
 		log.ErrorC(requestId, err, logContext)
-		return ErrFailedToReadBody
+		return unreadableErrorResponse(r.StatusCode)
 	}
 
+	apiErr := newAPIError(r.StatusCode, e)
+
 	d := log.Data{
 		"http_status":   e.Code,
 		"status":        e.Status,
 		"message":       e.Message,
 		"message_code":  e.MessageCode,
 		"debug_message": e.DebugMessage,
-		"errors":        e.SubErrorMap(),
+		"errors":        apiErr.SubErrorMap(),
 	}
 
 	log.ErrorC(requestId, errors.New("error response from E5"), d)
 
-	switch r.StatusCode {
-	case http.StatusBadRequest:
-		return ErrE5BadRequest
-	case http.StatusNotFound:
-		return ErrE5NotFound
-	case http.StatusInternalServerError:
-		return ErrE5InternalServer
-	default:
-		return ErrUnexpectedServerError
-	}
+	return apiErr
 }
 
 func (c *Client) validateInput(i interface{}) error {
@@ -306,10 +466,11 @@ func (c *Client) validateInput(i interface{}) error {
 	return v.Struct(i)
 }
 
-// sendRequest will make a http request and unmarshal the response body into a struct
-func (c *Client) sendRequest(method, path string, body io.Reader, queryParameters map[string]string, requestId string) (*http.Response, error) {
+// sendRequest will make a http request and unmarshal the response body into a struct. The request is abandoned if
+// the context is cancelled or its deadline passes before E5 responds.
+func (c *Client) sendRequest(ctx context.Context, method, path string, body io.Reader, queryParameters map[string]string, requestId string) (*http.Response, error) {
 	url := fmt.Sprintf("%s%s", c.E5BaseURL, path)
-	req, err := http.NewRequest(method, url, body)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 
 	logContext := log.Data{"request_method": method, "path": path}
 	if err != nil {
@@ -328,7 +489,7 @@ func (c *Client) sendRequest(method, path string, body io.Reader, queryParameter
 
 	req.URL.RawQuery = qp.Encode()
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient().Do(req)
 	// any errors here are due to transport errors, not 4xx/5xx responses
 	if err != nil {
 		log.ErrorC(requestId, err, logContext)
@@ -338,11 +499,21 @@ func (c *Client) sendRequest(method, path string, body io.Reader, queryParameter
 	return resp, err
 }
 
-// NewClient will construct a new E5 client service struct that can be used to interact with the Client finance system
-func NewClient(username, baseURL string) ClientInterface {
+// httpClient returns the http client used to call E5, falling back to the default client when none is configured
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+// NewClient will construct a new E5 client service struct that can be used to interact with the Client finance system.
+// The http client should be shared between E5 clients so that connections to E5 are reused - see NewHTTPClient.
+func NewClient(username, baseURL string, httpClient *http.Client) ClientInterface {
 	return &Client{
 		E5Username: username,
 		E5BaseURL:  baseURL,
+		HTTPClient: httpClient,
 	}
 }
 
@@ -352,3 +523,13 @@ func closeResponseBody(resp *http.Response, logContext log.Data) {
 		log.Error(err, logContext)
 	}
 }
+
+// unreadableErrorResponse returns the error for an error response whose body cannot be read. A 5xx is still returned
+// as an APIError for its status code, e.g. the HTML page of a 502 from a gateway in front of E5, so that it is treated
+// as E5 failing rather than as a response that E5 sent.
+func unreadableErrorResponse(statusCode int) error {
+	if statusCode >= http.StatusInternalServerError {
+		return &APIError{StatusCode: statusCode}
+	}
+	return ErrFailedToReadBody
+}
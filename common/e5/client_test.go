@@ -1,12 +1,17 @@
 package e5
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"gopkg.in/go-playground/validator.v9"
 
-	"github.com/companieshouse/penalty-payment-api/common/utils"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	"github.com/jarcoal/httpmock"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -24,7 +29,7 @@ func hasFieldError(field, tag string, errs validator.ValidationErrors) bool {
 }
 
 func getE5Client() ClientInterface {
-	return NewClient("foo", "https://e5")
+	return NewClient("foo", "https://e5", nil)
 }
 
 var requestId = "123456abc"
@@ -42,7 +47,7 @@ func TestUnitClient_CreatePayment(t *testing.T) {
 
 	Convey("creating a payment", t, func() {
 		input := &CreatePaymentInput{
-			CompanyCode:  utils.LateFilingPenaltyCompanyCode,
+			CompanyCode:  testutils.LateFilingPenaltyCompanyCode,
 			CustomerCode: "1000024",
 			PaymentID:    "1234",
 			TotalValue:   100,
@@ -84,7 +89,7 @@ func TestUnitClient_CreatePayment(t *testing.T) {
 					responder, _ := httpmock.NewJsonResponder(testCase.statusCode, nil)
 					httpmock.RegisterResponder(http.MethodPost, url, responder)
 
-					err := e5.CreatePayment(input, requestId)
+					err := e5.CreatePayment(context.Background(), input, requestId)
 
 					So(err, ShouldBeNil)
 				} else {
@@ -92,7 +97,7 @@ func TestUnitClient_CreatePayment(t *testing.T) {
 					responder, _ := httpmock.NewJsonResponder(testCase.statusCode, httpErr)
 					httpmock.RegisterResponder(http.MethodPost, url, responder)
 
-					err := e5.CreatePayment(input, requestId)
+					err := e5.CreatePayment(context.Background(), input, requestId)
 
 					So(err, ShouldBeError, testCase.err)
 				}
@@ -166,7 +171,7 @@ func getTestE5Transactions(response string, statusCode int) (*GetTransactionsRes
 	responder := httpmock.NewStringResponder(statusCode, response)
 	httpmock.RegisterResponder(http.MethodGet, url, responder)
 
-	return e5.GetTransactions(transactionInput, requestId)
+	return e5.GetTransactions(context.Background(), transactionInput, requestId)
 }
 
 func TestUnitClient_GetTransactions(t *testing.T) {
@@ -194,12 +199,252 @@ func TestUnitClient_GetTransactions(t *testing.T) {
 			r, err := getTestE5Transactions(e5ValidationError, http.StatusBadRequest)
 
 			So(r, ShouldBeNil)
-			So(err, ShouldBeError, ErrE5BadRequest)
+			So(errors.Is(err, ErrE5BadRequest), ShouldBeTrue)
+
+			var apiErr *APIError
+			So(errors.As(err, &apiErr), ShouldBeTrue)
+			So(apiErr.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(apiErr.Message, ShouldEqual, "Constraint Validation error")
+			So(apiErr.SubErrors, ShouldHaveLength, 1)
+			So(apiErr.SubErrors[0].Field, ShouldEqual, "companyCode")
 		})
 
 	})
 }
 
+var e5FirstPageResponse = `
+{
+  "page" : {
+    "size" : 1,
+    "totalElements" : 2,
+    "totalPages" : 2,
+    "number" : 0
+  },
+  "data" : [ {
+    "companyCode" : "LP",
+    "customerCode" : "10000024",
+    "transactionReference" : "00378420",
+    "transactionType" : "1",
+    "transactionSubType" : "EU"
+  }]
+}
+`
+
+var e5SecondPageResponse = `
+{
+  "page" : {
+    "size" : 1,
+    "totalElements" : 2,
+    "totalPages" : 2,
+    "number" : 1
+  },
+  "data" : [ {
+    "companyCode" : "LP",
+    "customerCode" : "10000024",
+    "transactionReference" : "00378421",
+    "transactionType" : "1",
+    "transactionSubType" : "EJ"
+  }]
+}
+`
+
+func TestUnitClient_GetTransactionsPagination(t *testing.T) {
+	e5 := getE5Client()
+	firstPageURL := "https://e5/arTransactions/10000024?ADV_userName=foo&companyCode=LP&fromDate=1990-01-01"
+	secondPageURL := "https://e5/arTransactions/10000024?ADV_userName=foo&companyCode=LP&fromDate=1990-01-01&pageNumber=1"
+
+	Convey("getting transactions that span several pages", t, func() {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		httpmock.RegisterResponder(http.MethodGet, firstPageURL, httpmock.NewStringResponder(http.StatusOK, e5FirstPageResponse))
+		httpmock.RegisterResponder(http.MethodGet, secondPageURL, httpmock.NewStringResponder(http.StatusOK, e5SecondPageResponse))
+
+		Convey("every page is read and the transactions merged", func() {
+			r, err := e5.GetTransactions(context.Background(), &GetTransactionsInput{CustomerCode: "10000024", CompanyCode: "LP"}, requestId)
+
+			So(err, ShouldBeNil)
+			So(r.Transactions, ShouldHaveLength, 2)
+			So(r.Transactions[0].TransactionReference, ShouldEqual, "00378420")
+			So(r.Transactions[1].TransactionReference, ShouldEqual, "00378421")
+			So(r.Page.TotalElements, ShouldEqual, 2)
+			So(r.Page.Size, ShouldEqual, 2)
+			So(httpmock.GetTotalCallCount(), ShouldEqual, 2)
+		})
+
+		Convey("reading starts from the requested page number", func() {
+			r, err := e5.GetTransactions(context.Background(), &GetTransactionsInput{CustomerCode: "10000024", CompanyCode: "LP", PageNumber: 1}, requestId)
+
+			So(err, ShouldBeNil)
+			So(r.Transactions, ShouldHaveLength, 1)
+			So(r.Transactions[0].TransactionReference, ShouldEqual, "00378421")
+			So(httpmock.GetTotalCallCount(), ShouldEqual, 1)
+		})
+
+		Convey("an error on a later page fails the whole request", func() {
+			httpmock.RegisterResponder(http.MethodGet, secondPageURL, httpmock.NewStringResponder(http.StatusInternalServerError, e5ValidationError))
+
+			r, err := e5.GetTransactions(context.Background(), &GetTransactionsInput{CustomerCode: "10000024", CompanyCode: "LP"}, requestId)
+
+			So(r, ShouldBeNil)
+			So(errors.Is(err, ErrE5InternalServer), ShouldBeTrue)
+		})
+
+		Convey("the pages are counted when E5 reports page 0 every time", func() {
+			stalePageResponse := strings.Replace(e5FirstPageResponse, `"totalPages" : 2`, `"totalPages" : 3`, 1)
+			httpmock.RegisterResponder(http.MethodGet, firstPageURL, httpmock.NewStringResponder(http.StatusOK, stalePageResponse))
+			httpmock.RegisterResponder(http.MethodGet, secondPageURL, httpmock.NewStringResponder(http.StatusOK, stalePageResponse))
+			httpmock.RegisterResponder(http.MethodGet, firstPageURL+"&pageNumber=2", httpmock.NewStringResponder(http.StatusOK, stalePageResponse))
+
+			r, err := e5.GetTransactions(context.Background(), &GetTransactionsInput{CustomerCode: "10000024", CompanyCode: "LP"}, requestId)
+
+			So(err, ShouldBeNil)
+			So(r.Transactions, ShouldHaveLength, 3)
+			So(httpmock.GetTotalCallCount(), ShouldEqual, 3)
+		})
+
+		Convey("no more than the maximum number of pages are read", func() {
+			defaultMaxTransactionPages := maxTransactionPages
+			maxTransactionPages = 2
+			defer func() { maxTransactionPages = defaultMaxTransactionPages }()
+
+			manyPagesResponse := strings.Replace(e5FirstPageResponse, `"totalPages" : 2`, `"totalPages" : 1000000`, 1)
+			httpmock.RegisterResponder(http.MethodGet, firstPageURL, httpmock.NewStringResponder(http.StatusOK, manyPagesResponse))
+			httpmock.RegisterResponder(http.MethodGet, secondPageURL, httpmock.NewStringResponder(http.StatusOK, manyPagesResponse))
+
+			r, err := e5.GetTransactions(context.Background(), &GetTransactionsInput{CustomerCode: "10000024", CompanyCode: "LP"}, requestId)
+
+			So(r, ShouldBeNil)
+			So(errors.Is(err, ErrTooManyTransactionPages), ShouldBeTrue)
+			So(httpmock.GetTotalCallCount(), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestUnitClient_GetTransactionsFilters(t *testing.T) {
+	e5 := getE5Client()
+
+	Convey("getting transactions with filters", t, func() {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		Convey("the filters are sent to E5 as query parameters", func() {
+			url := "https://e5/arTransactions/10000024?ADV_userName=foo&companyCode=LP&fromDate=2024-01-01&ledgerCode=EW" +
+				"&toDate=2024-12-31&transactionSubType=EU&transactionType=1"
+			httpmock.RegisterResponder(http.MethodGet, url, httpmock.NewStringResponder(http.StatusOK, e5TransactionResponse))
+
+			r, err := e5.GetTransactions(context.Background(), &GetTransactionsInput{
+				CustomerCode:       "10000024",
+				CompanyCode:        "LP",
+				FromDate:           "2024-01-01",
+				ToDate:             "2024-12-31",
+				LedgerCode:         "EW",
+				TransactionType:    "1",
+				TransactionSubType: "EU",
+			}, requestId)
+
+			So(err, ShouldBeNil)
+			So(r.Transactions, ShouldHaveLength, 1)
+		})
+
+		Convey("an invalid date is rejected before calling E5", func() {
+			r, err := e5.GetTransactions(context.Background(), &GetTransactionsInput{CustomerCode: "10000024", CompanyCode: "LP", ToDate: "31/12/2024"}, requestId)
+
+			So(r, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(httpmock.GetTotalCallCount(), ShouldEqual, 0)
+		})
+
+		Convey("a transaction subtype requires a transaction type", func() {
+			_, err := e5.GetTransactions(context.Background(), &GetTransactionsInput{CustomerCode: "10000024", CompanyCode: "LP", TransactionSubType: "EU"}, requestId)
+
+			So(err, ShouldNotBeNil)
+			So(hasFieldError("TransactionType", "required_with", err.(validator.ValidationErrors)), ShouldBeTrue)
+		})
+	})
+}
+
+func TestUnitClient_GetCompanyTransactions(t *testing.T) {
+	e5 := getE5Client()
+
+	Convey("getting the transactions of every customer within a company", t, func() {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		var pages []*GetTransactionsResponse
+		collectPages := func(page *GetTransactionsResponse) error {
+			pages = append(pages, page)
+			return nil
+		}
+
+		Convey("from date is required", func() {
+			err := e5.GetCompanyTransactions(context.Background(), &GetCompanyTransactionsInput{CompanyCode: "LP"}, collectPages, requestId)
+
+			So(err, ShouldNotBeNil)
+			So(hasFieldError("FromDate", "required", err.(validator.ValidationErrors)), ShouldBeTrue)
+			So(pages, ShouldBeEmpty)
+		})
+
+		Convey("the date window and type filters are sent to E5", func() {
+			url := "https://e5/arTransactions?ADV_userName=foo&companyCode=LP&fromDate=2025-01-01&toDate=2025-01-02&transactionType=1"
+			httpmock.RegisterResponder(http.MethodGet, url, httpmock.NewStringResponder(http.StatusOK, e5TransactionResponse))
+
+			err := e5.GetCompanyTransactions(context.Background(), &GetCompanyTransactionsInput{
+				CompanyCode:     "LP",
+				FromDate:        "2025-01-01",
+				ToDate:          "2025-01-02",
+				TransactionType: "1",
+			}, collectPages, requestId)
+
+			So(err, ShouldBeNil)
+			So(pages, ShouldHaveLength, 1)
+			So(pages[0].Transactions, ShouldHaveLength, 1)
+		})
+
+		Convey("each page is passed on as it is read", func() {
+			firstPageURL := "https://e5/arTransactions?ADV_userName=foo&companyCode=LP&fromDate=2025-01-01"
+			httpmock.RegisterResponder(http.MethodGet, firstPageURL, httpmock.NewStringResponder(http.StatusOK, e5FirstPageResponse))
+			httpmock.RegisterResponder(http.MethodGet, firstPageURL+"&pageNumber=1", httpmock.NewStringResponder(http.StatusOK, e5SecondPageResponse))
+
+			err := e5.GetCompanyTransactions(context.Background(), &GetCompanyTransactionsInput{CompanyCode: "LP", FromDate: "2025-01-01"},
+				func(page *GetTransactionsResponse) error {
+					// the second page has not been read when the first is passed on
+					So(httpmock.GetTotalCallCount(), ShouldEqual, len(pages)+1)
+					return collectPages(page)
+				}, requestId)
+
+			So(err, ShouldBeNil)
+			So(pages, ShouldHaveLength, 2)
+			So(pages[0].Transactions[0].TransactionReference, ShouldEqual, "00378420")
+			So(pages[1].Transactions[0].TransactionReference, ShouldEqual, "00378421")
+		})
+
+		Convey("an error handling a page stops later pages being read", func() {
+			firstPageURL := "https://e5/arTransactions?ADV_userName=foo&companyCode=LP&fromDate=2025-01-01"
+			httpmock.RegisterResponder(http.MethodGet, firstPageURL, httpmock.NewStringResponder(http.StatusOK, e5FirstPageResponse))
+			handleErr := errors.New("client went away")
+
+			err := e5.GetCompanyTransactions(context.Background(), &GetCompanyTransactionsInput{CompanyCode: "LP", FromDate: "2025-01-01"},
+				func(page *GetTransactionsResponse) error {
+					return handleErr
+				}, requestId)
+
+			So(err, ShouldEqual, handleErr)
+			So(httpmock.GetTotalCallCount(), ShouldEqual, 1)
+		})
+
+		Convey("an unknown company code returns an error", func() {
+			url := "https://e5/arTransactions?ADV_userName=foo&companyCode=XX&fromDate=2025-01-01"
+			httpmock.RegisterResponder(http.MethodGet, url, httpmock.NewStringResponder(http.StatusNotFound, e5ValidationError))
+
+			err := e5.GetCompanyTransactions(context.Background(), &GetCompanyTransactionsInput{CompanyCode: "XX", FromDate: "2025-01-01"}, collectPages, requestId)
+
+			So(errors.Is(err, ErrE5NotFound), ShouldBeTrue)
+			So(pages, ShouldBeEmpty)
+		})
+	})
+}
+
 func getAuthoriseConfirmTestCases() []testCase {
 	return []testCase{
 		{
@@ -226,6 +471,12 @@ func getAuthoriseConfirmTestCases() []testCase {
 			payload:    e5ValidationError,
 			err:        ErrUnexpectedServerError,
 		},
+		{
+			name:       "502 error from a gateway in front of E5",
+			statusCode: http.StatusBadGateway,
+			payload:    "<html><body>Bad Gateway</body></html>",
+			err:        ErrUnexpectedServerError,
+		},
 		{
 			name:       "successful request",
 			statusCode: http.StatusOK,
@@ -241,7 +492,7 @@ func TestUnitClient_AuthorisePayment(t *testing.T) {
 	Convey("email, paymentId are required parameters", t, func() {
 		input := &AuthorisePaymentInput{}
 
-		err := e5.AuthorisePayment(input, requestId)
+		err := e5.AuthorisePayment(context.Background(), input, requestId)
 
 		So(err, ShouldNotBeNil)
 
@@ -263,7 +514,7 @@ func TestUnitClient_AuthorisePayment(t *testing.T) {
 			responder := httpmock.NewStringResponder(testCase.statusCode, testCase.payload)
 			httpmock.RegisterResponder(http.MethodPost, url, responder)
 
-			err := e5.AuthorisePayment(&AuthorisePaymentInput{PaymentID: "123", Email: "test@example.com", CompanyCode: "LP"}, requestId)
+			err := e5.AuthorisePayment(context.Background(), &AuthorisePaymentInput{PaymentID: "123", Email: "test@example.com", CompanyCode: "LP"}, requestId)
 
 			if testCase.err == nil {
 				So(err, ShouldBeNil)
@@ -278,7 +529,7 @@ func TestUnitClient_Confirm(t *testing.T) {
 	e5 := getE5Client()
 
 	Convey("paymentId is required", t, func() {
-		err := e5.ConfirmPayment(&PaymentActionInput{}, "")
+		err := e5.ConfirmPayment(context.Background(), &PaymentActionInput{}, "")
 
 		errors := err.(validator.ValidationErrors)
 
@@ -296,7 +547,7 @@ func TestUnitClient_Confirm(t *testing.T) {
 			responder := httpmock.NewStringResponder(testCase.statusCode, testCase.payload)
 			httpmock.RegisterResponder(http.MethodPost, url, responder)
 
-			err := e5.ConfirmPayment(&PaymentActionInput{PaymentID: "123", CompanyCode: "LP"}, requestId)
+			err := e5.ConfirmPayment(context.Background(), &PaymentActionInput{PaymentID: "123", CompanyCode: "LP"}, requestId)
 
 			if testCase.err == nil {
 				So(err, ShouldBeNil)
@@ -306,3 +557,35 @@ func TestUnitClient_Confirm(t *testing.T) {
 		})
 	}
 }
+
+func TestUnitClient_ContextCancelled(t *testing.T) {
+	Convey("Given E5 is slow to respond", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		defer server.Close()
+
+		e5 := NewClient("foo", server.URL, server.Client())
+
+		Convey("When the context deadline passes then the request is abandoned", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			err := e5.ConfirmPayment(ctx, &PaymentActionInput{PaymentID: "123", CompanyCode: "LP"}, requestId)
+
+			So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		})
+
+		Convey("When the context is cancelled then the request is not sent", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := e5.GetTransactions(ctx, &GetTransactionsInput{CustomerCode: "10000024", CompanyCode: "LP"}, requestId)
+
+			So(errors.Is(err, context.Canceled), ShouldBeTrue)
+		})
+	})
+}
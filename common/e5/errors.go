@@ -0,0 +1,124 @@
+package e5
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// APIError is an error response returned by E5. It matches the sentinel error for its status code with errors.Is, so
+// callers that only care about the status can carry on using ErrE5BadRequest, ErrE5NotFound, ErrE5InternalServer and
+// ErrUnexpectedServerError, while callers that need to know why E5 rejected a request can inspect the message code.
+type APIError struct {
+	StatusCode   int
+	Status       string
+	Timestamp    string
+	MessageCode  string
+	Message      string
+	DebugMessage string
+	SubErrors    []SubError
+}
+
+// SubError is a field level validation error returned by E5
+type SubError struct {
+	Object        string `json:"object"`
+	Field         string `json:"field"`
+	RejectedValue string `json:"rejectedValue"`
+	Message       string `json:"message"`
+}
+
+// newAPIError builds the APIError from an E5 error response body
+func newAPIError(statusCode int, e *apiErrorResponse) *APIError {
+	return &APIError{
+		StatusCode:   statusCode,
+		Status:       e.Status,
+		Timestamp:    e.Timestamp,
+		MessageCode:  e.MessageCode,
+		Message:      e.Message,
+		DebugMessage: e.DebugMessage,
+		SubErrors:    e.SubErrors,
+	}
+}
+
+// Unwrap returns the sentinel error for the status code
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return ErrE5BadRequest
+	case http.StatusNotFound:
+		return ErrE5NotFound
+	case http.StatusInternalServerError:
+		return ErrE5InternalServer
+	default:
+		return ErrUnexpectedServerError
+	}
+}
+
+// Error implements error
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s: status [%d]", e.Unwrap(), e.StatusCode)
+	if e.MessageCode != "" {
+		msg += fmt.Sprintf(", message code [%s]", e.MessageCode)
+	}
+	if e.Message != "" {
+		msg += fmt.Sprintf(", message [%s]", e.Message)
+	}
+	return msg
+}
+
+// isServerError reports whether the error is an E5 error response with a 5xx status code
+func isServerError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode >= http.StatusInternalServerError
+}
+
+// SubErrorMap converts the sub errors into a map for logging
+func (e *APIError) SubErrorMap() []map[string]string {
+	subErrors := make([]map[string]string, 0, len(e.SubErrors))
+
+	for _, sub := range e.SubErrors {
+		subErrors = append(subErrors, map[string]string{
+			"field":          sub.Field,
+			"rejected_value": sub.RejectedValue,
+			"message":        sub.Message,
+		})
+	}
+
+	return subErrors
+}
+
+// MessageCode returns the E5 message code of the error, or an empty string if the error is not an E5 error response
+// or E5 did not return a message code
+func MessageCode(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.MessageCode
+	}
+	return ""
+}
+
+// IsRetryable reports whether a failed request to E5 may succeed if it is sent again. Server errors and transport
+// errors are retryable. Errors that E5 rejected the request are not, unless their message code is one of the
+// retryable message codes, e.g. the customer account being locked by another payment.
+func IsRetryable(err error, retryableMessageCodes map[string]bool) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if isServerError(apiErr) {
+			return true
+		}
+		return apiErr.MessageCode != "" && retryableMessageCodes[apiErr.MessageCode]
+	}
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return false
+	}
+
+	return !errors.Is(err, ErrE5BadRequest) && !errors.Is(err, ErrE5NotFound)
+}
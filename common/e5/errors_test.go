@@ -0,0 +1,97 @@
+package e5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"gopkg.in/go-playground/validator.v9"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const e5AccountLockedError = `
+{
+  "httpStatusCode" : 400,
+  "status" : "BAD_REQUEST",
+  "timestamp" : "2019-07-07T18:40:07Z",
+  "messageCode" : "BL101",
+  "message" : "Account is locked",
+  "debugMessage" : "Account locked by payment XP1",
+  "subErrors" : [ ]
+}
+`
+
+func TestUnitAPIError(t *testing.T) {
+	Convey("Given E5 rejects a payment", t, func() {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		httpmock.RegisterResponder(http.MethodPost, "https://e5/arTransactions/payment?ADV_userName=foo",
+			httpmock.NewStringResponder(http.StatusBadRequest, e5AccountLockedError))
+
+		err := getE5Client().CreatePayment(context.Background(), &CreatePaymentInput{
+			CompanyCode:  "LP",
+			CustomerCode: "10000024",
+			PaymentID:    "XP2",
+			TotalValue:   150,
+			Transactions: []*CreatePaymentTransaction{{TransactionReference: "A1000001", Value: 150}},
+		}, requestId)
+
+		Convey("Then the error carries the E5 error details", func() {
+			var apiErr *APIError
+			So(errors.As(err, &apiErr), ShouldBeTrue)
+			So(apiErr.StatusCode, ShouldEqual, http.StatusBadRequest)
+			So(apiErr.MessageCode, ShouldEqual, "BL101")
+			So(apiErr.Message, ShouldEqual, "Account is locked")
+			So(apiErr.DebugMessage, ShouldEqual, "Account locked by payment XP1")
+			So(err.Error(), ShouldEqual, "failed request to E5: status [400], message code [BL101], message [Account is locked]")
+		})
+
+		Convey("Then the error still matches the status sentinel", func() {
+			So(errors.Is(err, ErrE5BadRequest), ShouldBeTrue)
+			So(errors.Is(err, ErrE5InternalServer), ShouldBeFalse)
+		})
+
+		Convey("Then the message code can be read from a wrapped error", func() {
+			So(MessageCode(fmt.Errorf("create payment failed: %w", err)), ShouldEqual, "BL101")
+			So(MessageCode(errors.New("connection refused")), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given each E5 error status", t, func() {
+		So(errors.Is(&APIError{StatusCode: http.StatusNotFound}, ErrE5NotFound), ShouldBeTrue)
+		So(errors.Is(&APIError{StatusCode: http.StatusInternalServerError}, ErrE5InternalServer), ShouldBeTrue)
+		So(errors.Is(&APIError{StatusCode: http.StatusBadGateway}, ErrUnexpectedServerError), ShouldBeTrue)
+	})
+}
+
+func TestUnitIsRetryable(t *testing.T) {
+	Convey("Given the message codes that are retryable", t, func() {
+		retryableMessageCodes := map[string]bool{"BL101": true}
+
+		Convey("Then server and transport errors are retryable", func() {
+			So(IsRetryable(&APIError{StatusCode: http.StatusInternalServerError}, retryableMessageCodes), ShouldBeTrue)
+			So(IsRetryable(&APIError{StatusCode: http.StatusBadGateway}, retryableMessageCodes), ShouldBeTrue)
+			So(IsRetryable(errors.New("connection refused"), retryableMessageCodes), ShouldBeTrue)
+			So(IsRetryable(ErrCircuitOpen, retryableMessageCodes), ShouldBeTrue)
+		})
+
+		Convey("Then a rejected request is only retryable with a retryable message code", func() {
+			So(IsRetryable(&APIError{StatusCode: http.StatusBadRequest, MessageCode: "BL101"}, retryableMessageCodes), ShouldBeTrue)
+			So(IsRetryable(&APIError{StatusCode: http.StatusBadRequest, MessageCode: "BL102"}, retryableMessageCodes), ShouldBeFalse)
+			So(IsRetryable(&APIError{StatusCode: http.StatusBadRequest}, retryableMessageCodes), ShouldBeFalse)
+			So(IsRetryable(&APIError{StatusCode: http.StatusNotFound}, retryableMessageCodes), ShouldBeFalse)
+			So(IsRetryable(ErrE5BadRequest, retryableMessageCodes), ShouldBeFalse)
+		})
+
+		Convey("Then invalid input is not retryable", func() {
+			err := validator.New().Struct(&PaymentActionInput{})
+			So(IsRetryable(err, retryableMessageCodes), ShouldBeFalse)
+			So(IsRetryable(nil, retryableMessageCodes), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,75 @@
+package e5
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// DefaultConnectTimeout is the time allowed to establish a connection to E5 when none is configured
+	DefaultConnectTimeout = 5 * time.Second
+	// DefaultReadTimeout is the time allowed for a whole request to E5 when none is configured
+	DefaultReadTimeout = 30 * time.Second
+	// DefaultMaxIdleConnsPerHost is the number of keep-alive connections to E5 kept open when none is configured
+	DefaultMaxIdleConnsPerHost = 10
+
+	idleConnTimeout = 90 * time.Second
+)
+
+// HTTPClientConfig holds the transport settings used when calling E5
+type HTTPClientConfig struct {
+	// ConnectTimeout limits the time taken to dial E5 and complete the TLS handshake
+	ConnectTimeout time.Duration
+	// ReadTimeout limits the time taken by a whole request, including reading the response body
+	ReadTimeout time.Duration
+	// MaxIdleConnsPerHost is the size of the keep-alive connection pool
+	MaxIdleConnsPerHost int
+	// ProxyURL is the proxy E5 requests are sent through. The proxy environment variables are used when it is empty.
+	ProxyURL string
+}
+
+// NewHTTPClient will construct a http client for calling E5 with the supplied timeouts, connection pool size and
+// proxy. Any zero values are replaced with the defaults.
+func NewHTTPClient(cfg HTTPClientConfig) (*http.Client, error) {
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = DefaultConnectTimeout
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = DefaultReadTimeout
+	}
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid E5 proxy URL [%s]", cfg.ProxyURL)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   cfg.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.ConnectTimeout,
+		ResponseHeaderTimeout: cfg.ReadTimeout,
+		MaxIdleConns:          cfg.MaxIdleConnsPerHost,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.ReadTimeout,
+	}, nil
+}
@@ -0,0 +1,57 @@
+package e5
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitNewHTTPClient(t *testing.T) {
+	Convey("Given no transport settings", t, func() {
+		client, err := NewHTTPClient(HTTPClientConfig{})
+
+		Convey("Then the defaults are applied", func() {
+			So(err, ShouldBeNil)
+			So(client.Timeout, ShouldEqual, DefaultReadTimeout)
+
+			transport := client.Transport.(*http.Transport)
+			So(transport.MaxIdleConnsPerHost, ShouldEqual, DefaultMaxIdleConnsPerHost)
+			So(transport.TLSHandshakeTimeout, ShouldEqual, DefaultConnectTimeout)
+			So(transport.ResponseHeaderTimeout, ShouldEqual, DefaultReadTimeout)
+		})
+	})
+
+	Convey("Given transport settings and a proxy", t, func() {
+		client, err := NewHTTPClient(HTTPClientConfig{
+			ConnectTimeout:      2 * time.Second,
+			ReadTimeout:         10 * time.Second,
+			MaxIdleConnsPerHost: 25,
+			ProxyURL:            "http://proxy:3128",
+		})
+
+		Convey("Then the settings are applied to the client", func() {
+			So(err, ShouldBeNil)
+			So(client.Timeout, ShouldEqual, 10*time.Second)
+
+			transport := client.Transport.(*http.Transport)
+			So(transport.MaxIdleConnsPerHost, ShouldEqual, 25)
+			So(transport.TLSHandshakeTimeout, ShouldEqual, 2*time.Second)
+
+			req, _ := http.NewRequest(http.MethodGet, "https://e5/arTransactions", nil)
+			proxyURL, err := transport.Proxy(req)
+			So(err, ShouldBeNil)
+			So(proxyURL.String(), ShouldEqual, "http://proxy:3128")
+		})
+	})
+
+	Convey("Given an invalid proxy", t, func() {
+		client, err := NewHTTPClient(HTTPClientConfig{ProxyURL: "not a proxy"})
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldNotBeNil)
+			So(client, ShouldBeNil)
+		})
+	})
+}
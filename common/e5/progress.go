@@ -0,0 +1,40 @@
+package e5
+
+import "time"
+
+// PaymentProgress records when each step of marking a payment as paid in E5 succeeded, so that a payment that is
+// processed again can resume at the first step that has not completed. CompensatedAt is when a payment that failed part
+// way through was last timed out or rejected in E5, which closes it.
+type PaymentProgress struct {
+	CreatedAt     *time.Time `bson:"created_at,omitempty"     json:"created_at,omitempty"`
+	AuthorisedAt  *time.Time `bson:"authorised_at,omitempty"  json:"authorised_at,omitempty"`
+	ConfirmedAt   *time.Time `bson:"confirmed_at,omitempty"   json:"confirmed_at,omitempty"`
+	CompensatedAt *time.Time `bson:"compensated_at,omitempty" json:"compensated_at,omitempty"`
+}
+
+// Completed reports whether the step has succeeded in E5. A step that succeeded before the payment was compensated has
+// not completed, as the payment it applied to is closed and must be created again.
+func (p *PaymentProgress) Completed(action Action) bool {
+	if p == nil {
+		return false
+	}
+
+	var completedAt *time.Time
+	switch action {
+	case CreateAction:
+		completedAt = p.CreatedAt
+	case AuthoriseAction:
+		completedAt = p.AuthorisedAt
+	case ConfirmAction:
+		completedAt = p.ConfirmedAt
+	}
+	if completedAt == nil {
+		return false
+	}
+	return p.CompensatedAt == nil || completedAt.After(*p.CompensatedAt)
+}
+
+// Compensated reports whether the payment was timed out or rejected in E5 and has not been created again since
+func (p *PaymentProgress) Compensated() bool {
+	return p != nil && p.CompensatedAt != nil && !p.Completed(CreateAction)
+}
@@ -0,0 +1,51 @@
+package e5
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitPaymentProgress_Completed(t *testing.T) {
+	createdAt := time.Now().Add(-3 * time.Minute)
+	authorisedAt := time.Now().Add(-2 * time.Minute)
+	compensatedAt := time.Now().Add(-time.Minute)
+	recreatedAt := time.Now()
+
+	Convey("Given no progress", t, func() {
+		var progress *PaymentProgress
+
+		Convey("Then no step has completed", func() {
+			So(progress.Completed(CreateAction), ShouldBeFalse)
+			So(progress.Completed(ConfirmAction), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a payment that has been created and authorised", t, func() {
+		progress := &PaymentProgress{CreatedAt: &createdAt, AuthorisedAt: &authorisedAt}
+
+		Convey("Then only those steps have completed", func() {
+			So(progress.Completed(CreateAction), ShouldBeTrue)
+			So(progress.Completed(AuthoriseAction), ShouldBeTrue)
+			So(progress.Completed(ConfirmAction), ShouldBeFalse)
+			So(progress.Completed(TimeoutAction), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a payment that was compensated after it was created and authorised", t, func() {
+		progress := &PaymentProgress{CreatedAt: &createdAt, AuthorisedAt: &authorisedAt, CompensatedAt: &compensatedAt}
+
+		Convey("Then no step has completed, as the payment is closed in E5", func() {
+			So(progress.Completed(CreateAction), ShouldBeFalse)
+			So(progress.Completed(AuthoriseAction), ShouldBeFalse)
+		})
+
+		Convey("Then a step that succeeded again after the compensation has completed", func() {
+			progress.CreatedAt = &recreatedAt
+
+			So(progress.Completed(CreateAction), ShouldBeTrue)
+			So(progress.Completed(AuthoriseAction), ShouldBeFalse)
+		})
+	})
+}
@@ -1,10 +1,27 @@
 package e5
 
-// GetTransactionsInput is the struct used to query transactions by customer code
+// GetTransactionsInput is the struct used to query transactions by customer code. PageNumber is the zero based page
+// to start reading from, every page after it is read and merged into the response.
 type GetTransactionsInput struct {
-	CompanyCode  string `validate:"required"`
-	CustomerCode string `validate:"required"`
-	PageNumber   int
+	CompanyCode        string `validate:"required"`
+	CustomerCode       string `validate:"required"`
+	PageNumber         int    `validate:"min=0"`
+	FromDate           string
+	ToDate             string
+	LedgerCode         string
+	TransactionType    string `validate:"required_with=TransactionSubType"`
+	TransactionSubType string
+}
+
+// GetCompanyTransactionsInput is the struct used to query every customer's transactions within a company code.
+// PageNumber is the zero based page to start reading from.
+type GetCompanyTransactionsInput struct {
+	CompanyCode        string `validate:"required"`
+	FromDate           string `validate:"required"`
+	ToDate             string
+	TransactionType    string `validate:"required_with=TransactionSubType"`
+	TransactionSubType string
+	PageNumber         int `validate:"min=0"`
 }
 
 // GetTransactionsResponse returns the output of a get request for company transactions
@@ -79,31 +96,11 @@ type PaymentActionResponse struct {
 
 // e5ApiErrorResponse is the generic struct used to unmarshal the body of responses that have errored
 type apiErrorResponse struct {
-	Code         int    `json:"httpStatusCode"`
-	Status       string `json:"status"`
-	Timestamp    string `json:"timestamp"`
-	MessageCode  string `json:"messageCode,omitempty"`
-	Message      string `json:"message"`
-	DebugMessage string `json:"debugMessage"`
-	SubErrors    []struct {
-		Object        string `json:"object"`
-		Field         string `json:"field"`
-		RejectedValue string `json:"rejectedValue"`
-		Message       string `json:"message"`
-	} `json:"subErrors,omitempty"`
-}
-
-// SubErrorMap converts the sub error struct into a map
-func (e *apiErrorResponse) SubErrorMap() []map[string]string {
-	subErrors := make([]map[string]string, 0, len(e.SubErrors))
-
-	for _, sub := range e.SubErrors {
-		subErrors = append(subErrors, map[string]string{
-			"field":          sub.Field,
-			"rejected_value": sub.RejectedValue,
-			"message":        sub.Message,
-		})
-	}
-
-	return subErrors
+	Code         int        `json:"httpStatusCode"`
+	Status       string     `json:"status"`
+	Timestamp    string     `json:"timestamp"`
+	MessageCode  string     `json:"messageCode,omitempty"`
+	Message      string     `json:"message"`
+	DebugMessage string     `json:"debugMessage"`
+	SubErrors    []SubError `json:"subErrors,omitempty"`
 }
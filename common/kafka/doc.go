@@ -0,0 +1,2 @@
+// Package kafka holds the Kafka producers and Avro schemas shared across the service
+package kafka
@@ -0,0 +1,355 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/avro/schema"
+	"github.com/companieshouse/chs.go/kafka/producer"
+	"github.com/companieshouse/chs.go/log"
+)
+
+const (
+	// DefaultReconnectInterval is how often producers that could not connect, or that were dropped after failing, are
+	// reconnected in the background
+	DefaultReconnectInterval = 30 * time.Second
+	// schemaRetryInterval is how long an embedded schema is used after the schema registry was unavailable before the
+	// schema registry is tried again
+	schemaRetryInterval = time.Minute
+)
+
+// embeddedSchemaFallbacks counts the times an embedded schema was used because the schema registry was unavailable
+var embeddedSchemaFallbacks = expvar.NewInt("kafka_embedded_schema_fallbacks")
+
+var producerManager *ProducerManager
+var producerManagerMtx sync.Mutex
+
+// GetProducerManager returns the producer manager shared across the service, so that every email, payment processing
+// and resilience message for a set of brokers goes through the same producer
+func GetProducerManager() *ProducerManager {
+	producerManagerMtx.Lock()
+	defer producerManagerMtx.Unlock()
+
+	if producerManager == nil {
+		producerManager = NewProducerManager()
+	}
+	return producerManager
+}
+
+// ProducerHealth is the state of the producer for a set of brokers
+type ProducerHealth struct {
+	BrokerAddrs []string  `json:"broker_addrs"`
+	Healthy     bool      `json:"healthy"`
+	Error       string    `json:"error,omitempty"`
+	Since       time.Time `json:"since"`
+}
+
+// ProducerManager keeps one long-lived producer for each set of brokers and caches the Avro schemas fetched from the
+// schema registry. A producer that fails to connect, or fails with an error that means its connection to the brokers
+// is gone, is replaced the next time it is asked for or when Reconnect runs. A schema that cannot be fetched from the
+// schema registry falls back to the embedded copy registered for it.
+type ProducerManager struct {
+	newProducer func(brokerAddrs []string) (*producer.Producer, error)
+	getSchema   func(url, schemaName string) (string, error)
+	now         func() time.Time
+
+	mtx       sync.Mutex
+	producers map[string]*managedProducer
+	retired   []*producer.Producer
+	schemas   map[string]cachedSchema
+	embedded  map[string]EmbeddedSchema
+}
+
+type cachedSchema struct {
+	definition string
+	embedded   bool
+	cachedAt   time.Time
+}
+
+type managedProducer struct {
+	brokerAddrs []string
+	producer    *producer.Producer
+	err         error
+	since       time.Time
+}
+
+// NewProducerManager will construct a producer manager with no producers. Producers are created when they are first
+// asked for, or up front with Preload.
+func NewProducerManager() *ProducerManager {
+	return &ProducerManager{
+		newProducer: func(brokerAddrs []string) (*producer.Producer, error) {
+			return producer.New(&producer.Config{Acks: &producer.WaitForAll, BrokerAddrs: brokerAddrs})
+		},
+		getSchema: schema.Get,
+		now:       time.Now,
+		producers: map[string]*managedProducer{},
+		schemas:   map[string]cachedSchema{},
+		embedded: map[string]EmbeddedSchema{
+			EmailSendSchema.Name:                 EmailSendSchema,
+			PenaltyPaymentsProcessingSchema.Name: PenaltyPaymentsProcessingSchema,
+		},
+	}
+}
+
+// UseEmbeddedSchema registers the embedded schema as the fallback for the schema name, for topics that are not named
+// after their schema
+func (m *ProducerManager) UseEmbeddedSchema(schemaName string, embedded EmbeddedSchema) {
+	if schemaName == "" {
+		return
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.embedded[schemaName] = embedded
+}
+
+// Producer returns the producer for the brokers, connecting it if there is no healthy producer for them yet
+func (m *ProducerManager) Producer(brokerAddrs []string) (*producer.Producer, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	key := brokersKey(brokerAddrs)
+	managed, ok := m.producers[key]
+	if !ok {
+		managed = &managedProducer{brokerAddrs: brokerAddrs, since: m.now()}
+		m.producers[key] = managed
+	}
+	if managed.producer != nil {
+		return managed.producer, nil
+	}
+
+	kafkaProducer, err := m.newProducer(brokerAddrs)
+	if err != nil {
+		m.setState(managed, err)
+		return nil, err
+	}
+
+	log.Info("Kafka producer connected", log.Data{"broker_addrs": brokerAddrs})
+	managed.producer = kafkaProducer
+	m.setState(managed, nil)
+	return kafkaProducer, nil
+}
+
+// Schema returns the Avro schema from the registry, fetching it only the first time it is asked for. If the schema
+// registry is unavailable the embedded copy of the schema is returned instead, and the schema registry is tried
+// again once schemaRetryInterval has passed.
+func (m *ProducerManager) Schema(url, schemaName string) (string, error) {
+	key := url + "/" + schemaName
+
+	m.mtx.Lock()
+	cached, ok := m.schemas[key]
+	embedded, hasEmbedded := m.embedded[schemaName]
+	m.mtx.Unlock()
+	if ok && (!cached.embedded || m.now().Before(cached.cachedAt.Add(schemaRetryInterval))) {
+		return cached.definition, nil
+	}
+
+	definition, err := m.getSchema(url, schemaName)
+	if err != nil {
+		if !hasEmbedded {
+			return "", err
+		}
+		log.Info("schema registry unavailable, using embedded schema", log.Data{
+			"schema_name":    schemaName,
+			"schema_version": embedded.Version,
+			"error":          err.Error(),
+		})
+		embeddedSchemaFallbacks.Add(1)
+		definition = embedded.Definition
+	}
+
+	m.mtx.Lock()
+	m.schemas[key] = cachedSchema{definition: definition, embedded: err != nil, cachedAt: m.now()}
+	m.mtx.Unlock()
+	return definition, nil
+}
+
+// CheckSchemas compares the embedded copies of the schemas with the schemas in the registry and returns the names of
+// those that differ, logging a warning for each. The embedded copies are only used while the schema registry is
+// unavailable, so a difference means they need updating to the latest registry version before they are next needed.
+func (m *ProducerManager) CheckSchemas(url string, schemaNames ...string) []string {
+	var drifted []string
+	for _, schemaName := range schemaNames {
+		m.mtx.Lock()
+		embedded, ok := m.embedded[schemaName]
+		m.mtx.Unlock()
+		if !ok {
+			continue
+		}
+
+		// the schema is fetched through the cache, so checking it at startup also saves the first message fetching it
+		definition, err := m.Schema(url, schemaName)
+		m.mtx.Lock()
+		cached := m.schemas[url+"/"+schemaName]
+		m.mtx.Unlock()
+
+		logContext := log.Data{"schema_name": schemaName, "embedded_schema": embedded.Name, "embedded_version": embedded.Version}
+		if err != nil || cached.embedded {
+			log.Info("embedded schema not checked because the schema registry is unavailable", logContext)
+			continue
+		}
+
+		if !sameSchema(definition, embedded.Definition) {
+			log.Info("WARNING: embedded schema differs from the schema registry, update the embedded copy", logContext)
+			drifted = append(drifted, schemaName)
+		}
+	}
+
+	return drifted
+}
+
+// ReportFailure records that sending with the producer for the brokers failed. If the error means the producer has
+// lost its connection to the brokers it is dropped and reported as unhealthy, so that the next message reconnects
+// instead of failing the same way. Other errors, such as a message being too large, leave the producer in place.
+func (m *ProducerManager) ReportFailure(brokerAddrs []string, err error) {
+	if !isConnectionError(err) {
+		return
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	managed, ok := m.producers[brokersKey(brokerAddrs)]
+	if !ok || managed.producer == nil {
+		return
+	}
+
+	log.Info("Kafka producer dropped after connection failure", log.Data{"broker_addrs": brokerAddrs, "error": err.Error()})
+	m.setState(managed, err)
+	// components such as the resilience handler may still hold the producer, so it is only closed with the manager
+	m.retired = append(m.retired, managed.producer)
+	managed.producer = nil
+}
+
+// Preload connects the producers for each set of brokers and fetches the schemas, so that the first requests do not
+// pay for them. Failures are logged rather than returned because the producers are reconnected later.
+func (m *ProducerManager) Preload(brokerAddrSets [][]string, schemaRegistryURL string, schemaNames ...string) {
+	for _, brokerAddrs := range brokerAddrSets {
+		if len(brokerAddrs) == 0 {
+			continue
+		}
+		if _, err := m.Producer(brokerAddrs); err != nil {
+			log.Error(fmt.Errorf("error connecting Kafka producer: [%v]", err), log.Data{"broker_addrs": brokerAddrs})
+		}
+	}
+
+	for _, schemaName := range schemaNames {
+		if schemaName == "" {
+			continue
+		}
+		if _, err := m.Schema(schemaRegistryURL, schemaName); err != nil {
+			log.Error(fmt.Errorf("error getting schema from schema registry: [%v]", err), log.Data{"schema_name": schemaName})
+		}
+	}
+}
+
+// Reconnect reconnects the producers that could not connect or were dropped, every interval until the context is done
+func (m *ProducerManager) Reconnect(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReconnectInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, brokerAddrs := range m.disconnected() {
+				if _, err := m.Producer(brokerAddrs); err != nil {
+					log.Error(fmt.Errorf("error reconnecting Kafka producer: [%v]", err), log.Data{"broker_addrs": brokerAddrs})
+				}
+			}
+		}
+	}
+}
+
+// Health returns the state of the producer for each set of brokers
+func (m *ProducerManager) Health() []ProducerHealth {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	health := make([]ProducerHealth, 0, len(m.producers))
+	for _, managed := range m.producers {
+		producerHealth := ProducerHealth{
+			BrokerAddrs: managed.brokerAddrs,
+			Healthy:     managed.producer != nil && managed.err == nil,
+			Since:       managed.since,
+		}
+		if managed.err != nil {
+			producerHealth.Error = managed.err.Error()
+		}
+		health = append(health, producerHealth)
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return brokersKey(health[i].BrokerAddrs) < brokersKey(health[j].BrokerAddrs)
+	})
+	return health
+}
+
+// Close closes every producer, including those dropped after failing. It should be called once nothing is sending.
+func (m *ProducerManager) Close() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	toClose := m.retired
+	for _, managed := range m.producers {
+		if managed.producer != nil {
+			toClose = append(toClose, managed.producer)
+		}
+	}
+
+	for _, kafkaProducer := range toClose {
+		if kafkaProducer.SyncProducer == nil {
+			continue
+		}
+		if err := kafkaProducer.SyncProducer.Close(); err != nil {
+			log.Error(fmt.Errorf("error closing Kafka producer: [%v]", err))
+		}
+	}
+
+	m.producers = map[string]*managedProducer{}
+	m.retired = nil
+}
+
+func (m *ProducerManager) disconnected() [][]string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	var brokerAddrSets [][]string
+	for _, managed := range m.producers {
+		if managed.producer == nil {
+			brokerAddrSets = append(brokerAddrSets, managed.brokerAddrs)
+		}
+	}
+	return brokerAddrSets
+}
+
+// setState records whether the producer is healthy, keeping the time it last changed
+func (m *ProducerManager) setState(managed *managedProducer, err error) {
+	if (managed.err == nil) != (err == nil) {
+		managed.since = m.now()
+	}
+	managed.err = err
+}
+
+// brokersKey identifies a set of brokers regardless of the order they are configured in
+func brokersKey(brokerAddrs []string) string {
+	sorted := append([]string(nil), brokerAddrs...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func isConnectionError(err error) bool {
+	return errors.Is(err, sarama.ErrOutOfBrokers) || errors.Is(err, sarama.ErrClosedClient) ||
+		errors.Is(err, sarama.ErrNotConnected) || errors.Is(err, sarama.ErrShuttingDown)
+}
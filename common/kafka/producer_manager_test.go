@@ -0,0 +1,265 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/companieshouse/chs.go/kafka/producer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestProducerManager(t *testing.T, connectErrs ...error) (*ProducerManager, *int) {
+	connects := 0
+	m := NewProducerManager()
+	m.newProducer = func(brokerAddrs []string) (*producer.Producer, error) {
+		connects++
+		if len(connectErrs) > 0 {
+			err := connectErrs[0]
+			connectErrs = connectErrs[1:]
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &producer.Producer{SyncProducer: mocks.NewSyncProducer(t, nil)}, nil
+	}
+	return m, &connects
+}
+
+func TestUnitProducerManager_Producer(t *testing.T) {
+	Convey("Given producers are asked for", t, func() {
+		Convey("When the same brokers are asked for again then the producer is reused", func() {
+			m, connects := newTestProducerManager(t)
+
+			first, err := m.Producer([]string{"kafka1:9092", "kafka2:9092"})
+			So(err, ShouldBeNil)
+			second, err := m.Producer([]string{"kafka2:9092", "kafka1:9092"})
+			So(err, ShouldBeNil)
+
+			So(second, ShouldEqual, first)
+			So(*connects, ShouldEqual, 1)
+			So(m.Health(), ShouldHaveLength, 1)
+			So(m.Health()[0].Healthy, ShouldBeTrue)
+		})
+
+		Convey("When different brokers are asked for then each has its own producer", func() {
+			m, connects := newTestProducerManager(t)
+
+			kafka, _ := m.Producer([]string{"kafka:9092"})
+			kafka3, _ := m.Producer([]string{"kafka3:9092"})
+
+			So(kafka3, ShouldNotEqual, kafka)
+			So(*connects, ShouldEqual, 2)
+		})
+
+		Convey("When the producer cannot connect then it is unhealthy until it connects", func() {
+			m, connects := newTestProducerManager(t, sarama.ErrOutOfBrokers)
+
+			_, err := m.Producer([]string{"kafka:9092"})
+			So(err, ShouldEqual, sarama.ErrOutOfBrokers)
+			So(m.Health()[0].Healthy, ShouldBeFalse)
+			So(m.Health()[0].Error, ShouldEqual, sarama.ErrOutOfBrokers.Error())
+
+			_, err = m.Producer([]string{"kafka:9092"})
+			So(err, ShouldBeNil)
+			So(*connects, ShouldEqual, 2)
+			So(m.Health()[0].Healthy, ShouldBeTrue)
+			So(m.Health()[0].Error, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestUnitProducerManager_ReportFailure(t *testing.T) {
+	Convey("Given sending with a shared producer fails", t, func() {
+		brokerAddrs := []string{"kafka:9092"}
+
+		Convey("When the producer has lost its connection then it is reconnected for the next message", func() {
+			m, connects := newTestProducerManager(t)
+			first, _ := m.Producer(brokerAddrs)
+
+			m.ReportFailure(brokerAddrs, sarama.ErrOutOfBrokers)
+			So(m.Health()[0].Healthy, ShouldBeFalse)
+
+			second, err := m.Producer(brokerAddrs)
+			So(err, ShouldBeNil)
+			So(second, ShouldNotEqual, first)
+			So(*connects, ShouldEqual, 2)
+			So(m.retired, ShouldHaveLength, 1)
+		})
+
+		Convey("When the message was rejected then the producer is kept", func() {
+			m, connects := newTestProducerManager(t)
+			first, _ := m.Producer(brokerAddrs)
+
+			m.ReportFailure(brokerAddrs, sarama.ErrMessageSizeTooLarge)
+
+			second, _ := m.Producer(brokerAddrs)
+			So(second, ShouldEqual, first)
+			So(*connects, ShouldEqual, 1)
+			So(m.Health()[0].Healthy, ShouldBeTrue)
+		})
+	})
+}
+
+func TestUnitProducerManager_Schema(t *testing.T) {
+	Convey("Given schemas are asked for", t, func() {
+		m := NewProducerManager()
+		fetches := 0
+		m.getSchema = func(url, schemaName string) (string, error) {
+			fetches++
+			if fetches == 1 {
+				return "", errors.New("schema registry unavailable")
+			}
+			return "schema", nil
+		}
+
+		_, err := m.Schema("http://schema.registry", "account-penalties")
+		So(err, ShouldNotBeNil)
+
+		for i := 0; i < 2; i++ {
+			definition, err := m.Schema("http://schema.registry", "account-penalties")
+			So(err, ShouldBeNil)
+			So(definition, ShouldEqual, "schema")
+		}
+		So(fetches, ShouldEqual, 2)
+	})
+}
+
+func TestUnitProducerManager_Reconnect(t *testing.T) {
+	Convey("Given a producer could not connect at startup", t, func() {
+		m, connects := newTestProducerManager(t, sarama.ErrOutOfBrokers)
+		m.Preload([][]string{{"kafka:9092"}, {}}, "", "")
+		So(m.Health()[0].Healthy, ShouldBeFalse)
+
+		Convey("When reconnect runs then the producer is connected in the background", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				m.Reconnect(ctx, 5*time.Millisecond)
+				close(done)
+			}()
+
+			So(func() bool {
+				for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+					if m.Health()[0].Healthy {
+						return true
+					}
+				}
+				return false
+			}(), ShouldBeTrue)
+
+			cancel()
+			<-done
+			So(*connects, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestUnitProducerManager_Close(t *testing.T) {
+	Convey("Given the service is stopping", t, func() {
+		m, _ := newTestProducerManager(t)
+		brokerAddrs := []string{"kafka:9092"}
+		_, _ = m.Producer(brokerAddrs)
+		m.ReportFailure(brokerAddrs, sarama.ErrClosedClient)
+		_, _ = m.Producer(brokerAddrs)
+
+		Convey("When the manager is closed then every producer is closed and forgotten", func() {
+			m.Close()
+
+			So(m.Health(), ShouldBeEmpty)
+			So(m.retired, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestUnitProducerManager_SchemaFallback(t *testing.T) {
+	Convey("Given the schema registry is unavailable", t, func() {
+		now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+		m := NewProducerManager()
+		m.now = func() time.Time { return now }
+		registryUp := false
+		fetches := 0
+		m.getSchema = func(url, schemaName string) (string, error) {
+			fetches++
+			if !registryUp {
+				return "", errors.New("connection refused")
+			}
+			return "registry schema", nil
+		}
+
+		Convey("When a schema embedded in the binary is asked for then the embedded copy is used", func() {
+			definition, err := m.Schema("http://schema.registry", "penalty-payments-processing")
+
+			So(err, ShouldBeNil)
+			So(definition, ShouldEqual, PenaltyPaymentsProcessingSchema.Definition)
+
+			Convey("And the schema registry is not tried again until the retry interval has passed", func() {
+				registryUp = true
+				definition, _ = m.Schema("http://schema.registry", "penalty-payments-processing")
+				So(definition, ShouldEqual, PenaltyPaymentsProcessingSchema.Definition)
+				So(fetches, ShouldEqual, 1)
+
+				now = now.Add(schemaRetryInterval)
+				definition, _ = m.Schema("http://schema.registry", "penalty-payments-processing")
+				So(definition, ShouldEqual, "registry schema")
+				So(fetches, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When an embedded schema is registered for a topic then it is used for that topic", func() {
+			m.UseEmbeddedSchema("dev-email-send", EmailSendSchema)
+
+			definition, err := m.Schema("http://schema.registry", "dev-email-send")
+
+			So(err, ShouldBeNil)
+			So(definition, ShouldEqual, EmailSendSchema.Definition)
+		})
+
+		Convey("When a schema that is not embedded is asked for then an error is returned", func() {
+			_, err := m.Schema("http://schema.registry", "unknown")
+
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUnitProducerManager_CheckSchemas(t *testing.T) {
+	Convey("Given the embedded schemas are checked against the schema registry", t, func() {
+		m := NewProducerManager()
+		registry := map[string]string{
+			// the same schema formatted differently is not a difference
+			"email-send":                  `{"namespace":"email","type":"record","name":"email_send","fields":[{"name":"app_id","type":"string"},{"name":"message_id","type":"string"},{"name":"message_type","type":"string"},{"name":"data","type":"string"},{"name":"email_address","type":"string"},{"name":"created_at","type":"string"}]}`,
+			"penalty-payments-processing": `{"type":"record","name":"PenaltyPaymentsProcessing","fields":[{"name":"payable_ref","type":"string"}]}`,
+		}
+		m.getSchema = func(url, schemaName string) (string, error) {
+			if definition, ok := registry[schemaName]; ok {
+				return definition, nil
+			}
+			return "", errors.New("connection refused")
+		}
+
+		Convey("When a registry schema has moved on then it is reported as drifted", func() {
+			drifted := m.CheckSchemas("http://schema.registry", "email-send", "penalty-payments-processing", "not-embedded")
+
+			So(drifted, ShouldResemble, []string{"penalty-payments-processing"})
+		})
+
+		Convey("When the schema registry is unavailable then nothing is reported", func() {
+			m.UseEmbeddedSchema("dev-email-send", EmailSendSchema)
+
+			So(m.CheckSchemas("http://schema.registry", "dev-email-send"), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestUnitEmbeddedSchemas(t *testing.T) {
+	Convey("The embedded schemas are built into the binary", t, func() {
+		for _, embedded := range []EmbeddedSchema{EmailSendSchema, PenaltyPaymentsProcessingSchema} {
+			So(embedded.Version, ShouldBeGreaterThan, 0)
+			So(embedded.Definition, ShouldContainSubstring, `"type": "record"`)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package kafka
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+//go:embed schemas/*.avsc
+var schemaFiles embed.FS
+
+// EmbeddedSchema is a versioned copy of a schema in the schema registry that is built into the binary, so that
+// messages can still be produced and consumed while the schema registry is unavailable
+type EmbeddedSchema struct {
+	Name       string
+	Version    int
+	Definition string
+}
+
+var (
+	// EmailSendSchema is the schema of the messages sent to the email-send topic
+	EmailSendSchema = mustLoadEmbeddedSchema("email-send", 1)
+	// PenaltyPaymentsProcessingSchema is the schema of the messages sent to the penalty-payments-processing topic
+	PenaltyPaymentsProcessingSchema = mustLoadEmbeddedSchema("penalty-payments-processing", 1)
+)
+
+// mustLoadEmbeddedSchema reads the schema from schemas/<name>.v<version>.avsc. It panics if the file is missing or is
+// not valid JSON, which can only happen if the binary was built with a broken schema.
+func mustLoadEmbeddedSchema(name string, version int) EmbeddedSchema {
+	definition, err := schemaFiles.ReadFile(fmt.Sprintf("schemas/%s.v%d.avsc", name, version))
+	if err != nil {
+		panic(fmt.Errorf("error reading embedded schema %s version %d: [%v]", name, version, err))
+	}
+	if !json.Valid(definition) {
+		panic(fmt.Errorf("embedded schema %s version %d is not valid JSON", name, version))
+	}
+
+	return EmbeddedSchema{Name: name, Version: version, Definition: string(definition)}
+}
+
+// sameSchema reports whether two schema definitions are the same once formatting is ignored
+func sameSchema(a, b string) bool {
+	var schemaA, schemaB interface{}
+	if json.Unmarshal([]byte(a), &schemaA) != nil || json.Unmarshal([]byte(b), &schemaB) != nil {
+		return a == b
+	}
+	return reflect.DeepEqual(schemaA, schemaB)
+}
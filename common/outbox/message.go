@@ -0,0 +1,60 @@
+// Package outbox holds the Kafka messages that are written to the database in the same transaction as the change that
+// caused them, so that they are published by the outbox relay even if Kafka is unavailable when the change is made.
+package outbox
+
+import (
+	"time"
+)
+
+// Kind is what a message in the outbox is for, which decides the brokers it is published to
+type Kind string
+
+const (
+	// EmailSend messages ask the email sender to send the payment confirmation email
+	EmailSend Kind = "email-send"
+	// PenaltyPaymentsProcessing messages ask the penalty payments consumer to mark the penalty as paid in E5
+	PenaltyPaymentsProcessing Kind = "penalty-payments-processing"
+)
+
+// Status is where a message in the outbox is in its lifecycle
+type Status string
+
+const (
+	// Pending messages are waiting to be published
+	Pending Status = "pending"
+	// Published messages have been acknowledged by Kafka
+	Published Status = "published"
+)
+
+// Message is an Avro encoded Kafka message waiting in the outbox to be published to its topic
+type Message struct {
+	ID            string     `bson:"_id"                    json:"id"`
+	Kind          Kind       `bson:"kind"                   json:"kind"`
+	Topic         string     `bson:"topic"                  json:"topic"`
+	Value         []byte     `bson:"value"                  json:"value"`
+	CustomerCode  string     `bson:"customer_code"          json:"customer_code"`
+	PayableRef    string     `bson:"payable_ref"            json:"payable_ref"`
+	Status        Status     `bson:"status"                 json:"status"`
+	Attempts      int        `bson:"attempts"               json:"attempts"`
+	NextAttemptAt time.Time  `bson:"next_attempt_at"        json:"next_attempt_at"`
+	LastError     string     `bson:"last_error,omitempty"   json:"last_error,omitempty"`
+	CreatedAt     time.Time  `bson:"created_at"             json:"created_at"`
+	PublishedAt   *time.Time `bson:"published_at,omitempty" json:"published_at,omitempty"`
+}
+
+// NewMessage builds a pending message that is ready to be published straight away. The ID is derived from the payable
+// resource and the kind of message, so a payable resource can only ever put one message of each kind in the outbox.
+func NewMessage(kind Kind, customerCode, payableRef, topic string, value []byte) *Message {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return &Message{
+		ID:            payableRef + "-" + string(kind),
+		Kind:          kind,
+		Topic:         topic,
+		Value:         value,
+		CustomerCode:  customerCode,
+		PayableRef:    payableRef,
+		Status:        Pending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
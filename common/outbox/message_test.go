@@ -0,0 +1,30 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitNewMessage(t *testing.T) {
+	Convey("Given a message for a payable resource", t, func() {
+		before := time.Now().UTC().Truncate(time.Millisecond)
+
+		message := NewMessage(EmailSend, "12345678", "XP123", "email-send", []byte{0x01})
+
+		Convey("Then it is pending and can be published straight away", func() {
+			So(message.Status, ShouldEqual, Pending)
+			So(message.Attempts, ShouldEqual, 0)
+			So(message.NextAttemptAt, ShouldHappenOnOrAfter, before)
+			So(message.NextAttemptAt, ShouldEqual, message.CreatedAt)
+			So(message.PublishedAt, ShouldBeNil)
+		})
+
+		Convey("Then its id is derived from the payable resource and kind", func() {
+			So(message.ID, ShouldEqual, "XP123-email-send")
+			So(NewMessage(PenaltyPaymentsProcessing, "12345678", "XP123", "penalty-payments-processing", nil).ID,
+				ShouldEqual, "XP123-penalty-payments-processing")
+		})
+	})
+}
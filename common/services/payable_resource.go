@@ -4,20 +4,36 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api-core/validators"
 	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/penalty_payments/transformers"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var (
-	// ErrAlreadyPaid represents when the penalty payable resource is already paid
+	// ErrAlreadyPaid represents when the penalty payable resource is already paid with a different payment reference
 	ErrAlreadyPaid = errors.New("the Penalty has already been paid")
+	// ErrAlreadyPaidWithReference represents when the penalty payable resource is already paid with the same payment
+	// reference, e.g. because the payment callback was retried
+	ErrAlreadyPaidWithReference = errors.New("the Penalty has already been paid with this payment reference")
 	// ErrPenaltyNotFound represents when the payable resource does not exist in the db
 	ErrPenaltyNotFound = errors.New("the Penalty does not exist")
+	// ErrPayableResourceExpired represents when the payable resource was not paid within its lifetime so can no longer
+	// be paid
+	ErrPayableResourceExpired = errors.New("the payable resource has expired")
+	// ErrPayableResourceCancelled represents when the payable resource was cancelled by its creator so can no longer
+	// be paid
+	ErrPayableResourceCancelled = errors.New("the payable resource has been cancelled")
+	// ErrE5PaymentStarted represents when a payment has been created in E5 for the payable resource, so it can no
+	// longer be cancelled
+	ErrE5PaymentStarted = errors.New("a payment has been created in E5 for the payable resource")
 )
 
 // PayableResourceService contains the DAO for db access
@@ -44,8 +60,10 @@ func (s *PayableResourceService) GetPayableResource(req *http.Request, customerC
 	return payableResource, Success, nil
 }
 
-// UpdateAsPaid will update the resource as paid and persist the changes in the database
-func (s *PayableResourceService) UpdateAsPaid(resource models.PayableResource, payment validators.PaymentInformation, requestId string) error {
+// UpdateAsPaid will update the resource as paid and persist the changes in the database, along with the messages to
+// publish about the payment
+func (s *PayableResourceService) UpdateAsPaid(resource models.PayableResource, payment validators.PaymentInformation,
+	messages []outbox.Message, requestId string) error {
 	model, err := s.DAO.GetPayableResource(resource.CustomerCode, resource.PayableRef, requestId)
 	if err != nil {
 		err = fmt.Errorf("error getting payable resource from db: [%v]", err)
@@ -58,13 +76,10 @@ func (s *PayableResourceService) UpdateAsPaid(resource models.PayableResource, p
 
 	// check if this resource has already been paid
 	if model.IsPaid() {
-		err = errors.New("this penalty has already been paid")
-		log.ErrorC(requestId, err, log.Data{
-			"payable_ref":   model.PayableRef,
-			"customer_code": model.CustomerCode,
-			"payment_id":    model.Data.Payment.Reference,
-		})
-		return ErrAlreadyPaid
+		return alreadyPaid(model, payment, requestId)
+	}
+	if notPayable(model) {
+		return notPayableError(model, payment, requestId)
 	}
 
 	model.Data.Payment.Reference = payment.Reference
@@ -72,5 +87,125 @@ func (s *PayableResourceService) UpdateAsPaid(resource models.PayableResource, p
 	model.Data.Payment.PaidAt = &payment.CompletedAt
 	model.Data.Payment.Amount = payment.Amount
 
-	return s.DAO.UpdatePaymentDetails(model, requestId)
+	err = s.DAO.UpdatePaymentDetails(model, messages, requestId)
+	if !errors.Is(err, dao.ErrPaymentAlreadyRecorded) {
+		return err
+	}
+
+	// another request marked the resource as paid after it was read, so check which payment it was marked with
+	model, err = s.DAO.GetPayableResource(resource.CustomerCode, resource.PayableRef, requestId)
+	if err != nil {
+		err = fmt.Errorf("error getting payable resource from db: [%v]", err)
+		log.ErrorC(requestId, err, log.Data{
+			"payable_ref":   resource.PayableRef,
+			"customer_code": resource.CustomerCode,
+		})
+		return err
+	}
+	if notPayable(model) {
+		return notPayableError(model, payment, requestId)
+	}
+	return alreadyPaid(model, payment, requestId)
+}
+
+// Cancel will mark the pending resource as cancelled so that it can no longer be paid. It returns ErrAlreadyPaid if
+// the resource has been paid and ErrPayableResourceExpired if it has expired. Cancelling a resource that has already
+// been cancelled succeeds. The E5 payment is only created once UpdateAsPaid has marked the resource as paid, but a
+// resource with a payment created in E5 that has not been compensated is never cancelled, and ErrE5PaymentStarted is
+// returned, so that the payment is not left open in E5 for a resource that can no longer be paid.
+func (s *PayableResourceService) Cancel(resource models.PayableResource, cancelledAt time.Time, requestId string) error {
+	logContext := log.Data{
+		"payable_ref":   resource.PayableRef,
+		"customer_code": resource.CustomerCode,
+	}
+
+	progress, err := s.DAO.GetE5Progress(resource.CustomerCode, resource.PayableRef, requestId)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrPenaltyNotFound
+		}
+		err = fmt.Errorf("error getting E5 progress of payable resource from db: [%v]", err)
+		log.ErrorC(requestId, err, logContext)
+		return err
+	}
+	if progress.Completed(e5.CreateAction) {
+		log.InfoC(requestId, "payable resource has a payment created in E5 so cannot be cancelled", logContext,
+			log.Data{"e5_progress": progress})
+		return ErrE5PaymentStarted
+	}
+
+	cancelled, err := s.DAO.CancelPayableResource(resource.CustomerCode, resource.PayableRef, cancelledAt, requestId)
+	if err != nil {
+		err = fmt.Errorf("error cancelling payable resource in db: [%v]", err)
+		log.ErrorC(requestId, err, logContext)
+		return err
+	}
+	if cancelled {
+		return nil
+	}
+
+	// the resource is no longer pending, so check what it became
+	model, err := s.DAO.GetPayableResource(resource.CustomerCode, resource.PayableRef, requestId)
+	if err != nil {
+		err = fmt.Errorf("error getting payable resource from db: [%v]", err)
+		log.ErrorC(requestId, err, logContext)
+		return err
+	}
+	if model == nil {
+		return ErrPenaltyNotFound
+	}
+
+	switch {
+	case model.IsPaid():
+		return ErrAlreadyPaid
+	case model.Data.Payment.Status == dao.PaymentStatusExpired:
+		return ErrPayableResourceExpired
+	case model.Data.Payment.Status == dao.PaymentStatusCancelled:
+		log.InfoC(requestId, "payable resource has already been cancelled", logContext)
+		return nil
+	default:
+		err = fmt.Errorf("payable resource with payment status [%s] cannot be cancelled", model.Data.Payment.Status)
+		log.ErrorC(requestId, err, logContext)
+		return err
+	}
+}
+
+// notPayable returns true if the resource has expired or been cancelled, so can no longer be paid
+func notPayable(model *models.PayableResourceDao) bool {
+	return model.Data.Payment.Status == dao.PaymentStatusExpired || model.Data.Payment.Status == dao.PaymentStatusCancelled
+}
+
+// notPayableError logs and returns ErrPayableResourceExpired or ErrPayableResourceCancelled for a payment made for a
+// resource that can no longer be paid
+func notPayableError(model *models.PayableResourceDao, payment validators.PaymentInformation, requestId string) error {
+	err := ErrPayableResourceExpired
+	if model.Data.Payment.Status == dao.PaymentStatusCancelled {
+		err = ErrPayableResourceCancelled
+	}
+
+	log.ErrorC(requestId, fmt.Errorf("payment received for a payable resource that can no longer be paid: [%v]", err), log.Data{
+		"payable_ref":       model.PayableRef,
+		"customer_code":     model.CustomerCode,
+		"payment_reference": payment.Reference,
+	})
+	return err
+}
+
+// alreadyPaid returns ErrAlreadyPaidWithReference if the paid resource was paid with the payment, otherwise
+// ErrAlreadyPaid
+func alreadyPaid(model *models.PayableResourceDao, payment validators.PaymentInformation, requestId string) error {
+	logContext := log.Data{
+		"payable_ref":       model.PayableRef,
+		"customer_code":     model.CustomerCode,
+		"payment_id":        model.Data.Payment.Reference,
+		"payment_reference": payment.Reference,
+	}
+
+	if model.Data.Payment.Reference == payment.Reference {
+		log.InfoC(requestId, "this penalty has already been paid with this payment reference", logContext)
+		return ErrAlreadyPaidWithReference
+	}
+
+	log.ErrorC(requestId, errors.New("this penalty has already been paid with a different payment reference"), logContext)
+	return ErrAlreadyPaid
 }
@@ -12,11 +12,15 @@ import (
 	"github.com/companieshouse/penalty-payment-api-core/constants"
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api-core/validators"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/mocks"
 	"github.com/golang/mock/gomock"
 	"github.com/jarcoal/httpmock"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var requestId = "123abc5789"
@@ -182,7 +186,7 @@ func TestUnitPayableResourceService_UpdateAsPaid(t *testing.T) {
 		Convey("Payable resource must exist", func() {
 			mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(nil, errors.New("not found"))
 
-			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), validators.PaymentInformation{}, requestId)
+			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), validators.PaymentInformation{}, nil, requestId)
 
 			So(err, ShouldBeError, ErrPenaltyNotFound)
 		})
@@ -191,19 +195,91 @@ func TestUnitPayableResourceService_UpdateAsPaid(t *testing.T) {
 			payableResourceDao := buildTestPayableResourceDao(1, customerCode, validPayableRef, "paid")
 			mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(payableResourceDao, nil)
 
-			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), validators.PaymentInformation{Status: constants.Paid.String()}, requestId)
+			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), validators.PaymentInformation{Status: constants.Paid.String()}, nil, requestId)
 
 			So(err, ShouldBeError, ErrAlreadyPaid)
 		})
 
-		Convey("payment details are saved to db", func() {
+		Convey("Penalty payable resource already paid with the same payment reference is reported", func() {
+			payableResourceDao := buildTestPayableResourceDao(1, customerCode, validPayableRef, "paid")
+			payableResourceDao.Data.Payment.Reference = "123"
+			mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(payableResourceDao, nil)
+
+			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), buildPaymentInformation(), nil, requestId)
+
+			So(err, ShouldBeError, ErrAlreadyPaidWithReference)
+		})
+
+		Convey("Penalty payable resource paid by another request after it was read is reported", func() {
+			payableResourceDao := buildTestPayableResourceDao(1, customerCode, validPayableRef, "pending")
+			paidPayableResourceDao := buildTestPayableResourceDao(1, customerCode, validPayableRef, "paid")
+			paidPayableResourceDao.Data.Payment.Reference = "456"
+			gomock.InOrder(
+				mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(payableResourceDao, nil),
+				mockPrDaoSvc.EXPECT().UpdatePaymentDetails(payableResourceDao, nil, requestId).Return(dao.ErrPaymentAlreadyRecorded),
+				mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(paidPayableResourceDao, nil),
+			)
+
+			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), buildPaymentInformation(), nil, requestId)
+
+			So(err, ShouldBeError, ErrAlreadyPaid)
+		})
+
+		Convey("Expired payable resource must not be paid", func() {
+			payableResourceDao := buildTestPayableResourceDao(1, customerCode, validPayableRef, dao.PaymentStatusExpired)
+			mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(payableResourceDao, nil)
+
+			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), buildPaymentInformation(), nil, requestId)
+
+			So(err, ShouldBeError, ErrPayableResourceExpired)
+		})
+
+		Convey("Penalty payable resource expired after it was read is reported", func() {
+			payableResourceDao := buildTestPayableResourceDao(1, customerCode, validPayableRef, "pending")
+			expiredPayableResourceDao := buildTestPayableResourceDao(1, customerCode, validPayableRef, dao.PaymentStatusExpired)
+			gomock.InOrder(
+				mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(payableResourceDao, nil),
+				mockPrDaoSvc.EXPECT().UpdatePaymentDetails(payableResourceDao, nil, requestId).Return(dao.ErrPaymentAlreadyRecorded),
+				mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(expiredPayableResourceDao, nil),
+			)
+
+			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), buildPaymentInformation(), nil, requestId)
+
+			So(err, ShouldBeError, ErrPayableResourceExpired)
+		})
+
+		Convey("Cancelled payable resource must not be paid", func() {
+			payableResourceDao := buildTestPayableResourceDao(1, customerCode, validPayableRef, dao.PaymentStatusCancelled)
+			mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(payableResourceDao, nil)
+
+			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), buildPaymentInformation(), nil, requestId)
+
+			So(err, ShouldBeError, ErrPayableResourceCancelled)
+		})
+
+		Convey("Penalty payable resource cancelled after it was read is reported", func() {
+			payableResourceDao := buildTestPayableResourceDao(1, customerCode, validPayableRef, "pending")
+			cancelledPayableResourceDao := buildTestPayableResourceDao(1, customerCode, validPayableRef, dao.PaymentStatusCancelled)
+			gomock.InOrder(
+				mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(payableResourceDao, nil),
+				mockPrDaoSvc.EXPECT().UpdatePaymentDetails(payableResourceDao, nil, requestId).Return(dao.ErrPaymentAlreadyRecorded),
+				mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(cancelledPayableResourceDao, nil),
+			)
+
+			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), buildPaymentInformation(), nil, requestId)
+
+			So(err, ShouldBeError, ErrPayableResourceCancelled)
+		})
+
+		Convey("payment details are saved to db with the outbox messages", func() {
 			payableResourceDao := buildTestPayableResourceDao(1, customerCode, validPayableRef, "pending")
+			messages := []outbox.Message{*outbox.NewMessage(outbox.EmailSend, customerCode, validPayableRef, "email-send", []byte{0x01})}
 			mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).Return(payableResourceDao, nil)
-			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(payableResourceDao, requestId).Times(1)
+			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(payableResourceDao, messages, requestId).Times(1)
 
 			paymentResponse := buildPaymentInformation()
 
-			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), paymentResponse, requestId)
+			err := mockPayableResourceSvc.UpdateAsPaid(buildEmptyPayableResource(), paymentResponse, messages, requestId)
 
 			So(err, ShouldBeNil)
 			So(payableResourceDao.Data.Payment.Status, ShouldEqual, paymentResponse.Status)
@@ -213,3 +289,107 @@ func TestUnitPayableResourceService_UpdateAsPaid(t *testing.T) {
 		})
 	})
 }
+
+func TestUnitPayableResourceService_Cancel(t *testing.T) {
+	Convey("PayableResourceService.Cancel", t, func() {
+		mockCtrl, mockPrDaoSvc, mockPayableResourceSvc := setup(t)
+
+		defer mockCtrl.Finish()
+
+		cancelledAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+		noE5Progress := func() {
+			mockPrDaoSvc.EXPECT().GetE5Progress(customerCode, validPayableRef, requestId).Return(&e5.PaymentProgress{}, nil)
+		}
+
+		Convey("Pending payable resource is cancelled", func() {
+			noE5Progress()
+			mockPrDaoSvc.EXPECT().CancelPayableResource(customerCode, validPayableRef, cancelledAt, requestId).Return(true, nil)
+
+			err := mockPayableResourceSvc.Cancel(buildEmptyPayableResource(), cancelledAt, requestId)
+
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Payable resource already cancelled is not an error", func() {
+			noE5Progress()
+			mockPrDaoSvc.EXPECT().CancelPayableResource(customerCode, validPayableRef, cancelledAt, requestId).Return(false, nil)
+			mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).
+				Return(buildTestPayableResourceDao(1, customerCode, validPayableRef, dao.PaymentStatusCancelled), nil)
+
+			err := mockPayableResourceSvc.Cancel(buildEmptyPayableResource(), cancelledAt, requestId)
+
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Paid payable resource cannot be cancelled", func() {
+			noE5Progress()
+			mockPrDaoSvc.EXPECT().CancelPayableResource(customerCode, validPayableRef, cancelledAt, requestId).Return(false, nil)
+			mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).
+				Return(buildTestPayableResourceDao(1, customerCode, validPayableRef, "paid"), nil)
+
+			err := mockPayableResourceSvc.Cancel(buildEmptyPayableResource(), cancelledAt, requestId)
+
+			So(err, ShouldBeError, ErrAlreadyPaid)
+		})
+
+		Convey("Expired payable resource cannot be cancelled", func() {
+			noE5Progress()
+			mockPrDaoSvc.EXPECT().CancelPayableResource(customerCode, validPayableRef, cancelledAt, requestId).Return(false, nil)
+			mockPrDaoSvc.EXPECT().GetPayableResource(customerCode, validPayableRef, requestId).
+				Return(buildTestPayableResourceDao(1, customerCode, validPayableRef, dao.PaymentStatusExpired), nil)
+
+			err := mockPayableResourceSvc.Cancel(buildEmptyPayableResource(), cancelledAt, requestId)
+
+			So(err, ShouldBeError, ErrPayableResourceExpired)
+		})
+
+		Convey("Payable resource with a payment created in E5 is not cancelled", func() {
+			createdAt := cancelledAt.Add(-time.Minute)
+			mockPrDaoSvc.EXPECT().GetE5Progress(customerCode, validPayableRef, requestId).
+				Return(&e5.PaymentProgress{CreatedAt: &createdAt}, nil)
+
+			err := mockPayableResourceSvc.Cancel(buildEmptyPayableResource(), cancelledAt, requestId)
+
+			So(err, ShouldBeError, ErrE5PaymentStarted)
+		})
+
+		Convey("Payable resource with a compensated E5 payment is cancelled", func() {
+			createdAt := cancelledAt.Add(-time.Hour)
+			compensatedAt := cancelledAt.Add(-time.Minute)
+			mockPrDaoSvc.EXPECT().GetE5Progress(customerCode, validPayableRef, requestId).
+				Return(&e5.PaymentProgress{CreatedAt: &createdAt, CompensatedAt: &compensatedAt}, nil)
+			mockPrDaoSvc.EXPECT().CancelPayableResource(customerCode, validPayableRef, cancelledAt, requestId).Return(true, nil)
+
+			err := mockPayableResourceSvc.Cancel(buildEmptyPayableResource(), cancelledAt, requestId)
+
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Payable resource not found getting E5 progress", func() {
+			mockPrDaoSvc.EXPECT().GetE5Progress(customerCode, validPayableRef, requestId).Return(nil, mongo.ErrNoDocuments)
+
+			err := mockPayableResourceSvc.Cancel(buildEmptyPayableResource(), cancelledAt, requestId)
+
+			So(err, ShouldBeError, ErrPenaltyNotFound)
+		})
+
+		Convey("Error getting E5 progress from db", func() {
+			mockPrDaoSvc.EXPECT().GetE5Progress(customerCode, validPayableRef, requestId).
+				Return(nil, errors.New("server selection timeout"))
+
+			err := mockPayableResourceSvc.Cancel(buildEmptyPayableResource(), cancelledAt, requestId)
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Error cancelling payable resource in db", func() {
+			noE5Progress()
+			mockPrDaoSvc.EXPECT().CancelPayableResource(customerCode, validPayableRef, cancelledAt, requestId).
+				Return(false, errors.New("server selection timeout"))
+
+			err := mockPayableResourceSvc.Cancel(buildEmptyPayableResource(), cancelledAt, requestId)
+
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
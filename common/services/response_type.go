@@ -18,6 +18,9 @@ const (
 
 	// Success response
 	Success
+
+	// Unavailable response
+	Unavailable
 )
 
 var vals = [...]string{
@@ -26,6 +29,7 @@ var vals = [...]string{
 	"forbidden",
 	"not-found",
 	"success",
+	"unavailable",
 }
 
 // String representation of `ResponseType`
@@ -17,6 +17,7 @@ func TestUnitResponseType(t *testing.T) {
 			{input: Forbidden, expected: "forbidden"},
 			{input: NotFound, expected: "not-found"},
 			{input: Success, expected: "success"},
+			{input: Unavailable, expected: "unavailable"},
 		}
 		Convey("When String is called", func() {
 			for _, testCase := range testCases {
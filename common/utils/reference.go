@@ -12,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/config"
 )
 
 // GenerateReferenceNumber produces a random reference number in the format of [A-Z]{2}[0-9]{8}
@@ -56,59 +57,27 @@ func GetCustomerCodeFromVars(vars map[string]string) (string, error) {
 
 // GetCompanyCode gets the company code from the penalty reference type
 func GetCompanyCode(penaltyRefType string) (string, error) {
-	switch penaltyRefType {
-	case LateFilingPenaltyRefType:
-		return LateFilingPenaltyCompanyCode, nil
-	case SanctionsPenaltyRefType:
-		return SanctionsCompanyCode, nil
-	case SanctionsRoePenaltyRefType:
-		return SanctionsCompanyCode, nil
-	default:
-		return "", fmt.Errorf("invalid penalty reference type supplied")
+	penaltyTypes, err := config.GetPenaltyTypes()
+	if err != nil {
+		return "", err
 	}
+	return penaltyTypes.CompanyCode(penaltyRefType)
 }
 
 // GetCompanyCodeFromTransaction determines the penalty type by the penaltyReference which is held in
 // the first element of the transactions under the property TransactionID that is pulled back
 func GetCompanyCodeFromTransaction(transactions []models.TransactionItem) (string, error) {
-	penaltyPrefix, err := getPrefix(transactions)
+	penaltyRefType, err := GetPenaltyRefTypeFromTransaction(transactions)
 	if err != nil {
 		return "", err
 	}
 
-	switch penaltyPrefix {
-	case "A":
-		return LateFilingPenaltyCompanyCode, nil
-	case "P":
-		return SanctionsCompanyCode, nil
-	case "U":
-		return SanctionsCompanyCode, nil
-	default:
-		return "", fmt.Errorf("error converting penalty reference")
-	}
+	return GetCompanyCode(penaltyRefType)
 }
 
 // GetPenaltyRefTypeFromTransaction determines the penalty reference type by the penaltyReference
 // which is held in the first element of the transactions under the property TransactionID that is pulled back
 func GetPenaltyRefTypeFromTransaction(transactions []models.TransactionItem) (string, error) {
-	penaltyPrefix, err := getPrefix(transactions)
-	if err != nil {
-		return "", err
-	}
-
-	switch penaltyPrefix {
-	case "A":
-		return LateFilingPenaltyRefType, nil
-	case "P":
-		return SanctionsPenaltyRefType, nil
-	case "U":
-		return SanctionsRoePenaltyRefType, nil
-	default:
-		return "", fmt.Errorf("error converting penalty reference")
-	}
-}
-
-func getPrefix(transactions []models.TransactionItem) (string, error) {
 	if len(transactions) == 0 {
 		return "", errors.New("no transactions found")
 	}
@@ -119,13 +88,9 @@ func getPrefix(transactions []models.TransactionItem) (string, error) {
 		return "", errors.New("no penalty reference found")
 	}
 
-	return penaltyReference[0:1], nil
+	penaltyTypes, err := config.GetPenaltyTypes()
+	if err != nil {
+		return "", err
+	}
+	return penaltyTypes.RefTypeFromReference(penaltyReference)
 }
-
-const (
-	LateFilingPenaltyCompanyCode = "LP"
-	SanctionsCompanyCode         = "C1"
-	LateFilingPenaltyRefType     = "LATE_FILING"
-	SanctionsPenaltyRefType      = "SANCTIONS"
-	SanctionsRoePenaltyRefType   = "SANCTIONS_ROE"
-)
@@ -5,6 +5,7 @@ import (
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
 
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -98,19 +99,19 @@ func TestUnitGetCompanyCode(t *testing.T) {
 		}{
 			{
 				name:          "Late Filing",
-				input:         LateFilingPenaltyRefType,
-				expectedCode:  LateFilingPenaltyCompanyCode,
+				input:         testutils.LateFilingPenaltyRefType,
+				expectedCode:  testutils.LateFilingPenaltyCompanyCode,
 				expectedError: false,
 			},
 			{
 				name:         "Sanctions",
-				input:        SanctionsPenaltyRefType,
-				expectedCode: SanctionsCompanyCode,
+				input:        testutils.SanctionsPenaltyRefType,
+				expectedCode: testutils.SanctionsCompanyCode,
 			},
 			{
 				name:         "Sanctions ROE",
-				input:        SanctionsRoePenaltyRefType,
-				expectedCode: SanctionsCompanyCode,
+				input:        testutils.SanctionsRoePenaltyRefType,
+				expectedCode: testutils.SanctionsCompanyCode,
 			},
 			{
 				name:          "Error invalid penalty reference",
@@ -153,7 +154,7 @@ func TestUnitGetCompanyCodeFromTransaction(t *testing.T) {
 						PenaltyRef: "A1000007",
 					},
 				},
-				expectedCode:  LateFilingPenaltyCompanyCode,
+				expectedCode:  testutils.LateFilingPenaltyCompanyCode,
 				expectedError: false,
 			},
 			{
@@ -239,7 +240,7 @@ func TestUnitGetPenaltyRefTypeFromTransaction(t *testing.T) {
 						PenaltyRef: "A1000007",
 					},
 				},
-				expectedPenaltyRefType: LateFilingPenaltyRefType,
+				expectedPenaltyRefType: testutils.LateFilingPenaltyRefType,
 			},
 			{
 				name: "Sanctions",
@@ -250,7 +251,7 @@ func TestUnitGetPenaltyRefTypeFromTransaction(t *testing.T) {
 						PenaltyRef: "P1000007",
 					},
 				},
-				expectedPenaltyRefType: SanctionsPenaltyRefType,
+				expectedPenaltyRefType: testutils.SanctionsPenaltyRefType,
 			},
 			{
 				name: "Sanctions ROE",
@@ -261,7 +262,7 @@ func TestUnitGetPenaltyRefTypeFromTransaction(t *testing.T) {
 						PenaltyRef: "U1000007",
 					},
 				},
-				expectedPenaltyRefType: SanctionsRoePenaltyRefType,
+				expectedPenaltyRefType: testutils.SanctionsRoePenaltyRefType,
 			},
 			{
 				name: "Error unknown penalty reference",
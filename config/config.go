@@ -2,6 +2,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"sync"
 	"time"
@@ -20,16 +21,29 @@ type Config struct {
 	BindAddr                               string       `env:"BIND_ADDR"                                    flag:"bind-addr"                                flagDesc:"Bind address"`
 	E5APIURL                               string       `env:"E5_API_URL"                                   flag:"e5-api-url"                               flagDesc:"Base URL for the E5 API"`
 	E5Username                             string       `env:"E5_USERNAME"                                  flag:"e5-username"                              flagDesc:"Username for the E5 API" json:"-"`
+	E5ConnectTimeout                       string       `env:"E5_CONNECT_TIMEOUT"                           flag:"e5-connect-timeout"                       flagDesc:"Timeout for connecting to the E5 API"`
+	E5ReadTimeout                          string       `env:"E5_READ_TIMEOUT"                              flag:"e5-read-timeout"                          flagDesc:"Timeout for a request to the E5 API"`
+	E5MaxIdleConnsPerHost                  int          `env:"E5_MAX_IDLE_CONNS_PER_HOST"                   flag:"e5-max-idle-conns-per-host"               flagDesc:"Number of keep-alive connections kept open to the E5 API"`
+	E5ProxyURL                             string       `env:"E5_PROXY_URL"                                 flag:"e5-proxy-url"                             flagDesc:"Proxy URL for the E5 API"`
+	E5CircuitBreakerFailureThreshold       int          `env:"E5_CIRCUIT_BREAKER_FAILURE_THRESHOLD"         flag:"e5-circuit-breaker-failure-threshold"     flagDesc:"Consecutive E5 failures before the circuit breaker opens"`
+	E5CircuitBreakerOpenDuration           string       `env:"E5_CIRCUIT_BREAKER_OPEN_DURATION"             flag:"e5-circuit-breaker-open-duration"         flagDesc:"How long the E5 circuit breaker stays open before retrying E5"`
+	E5RetryableMessageCodes                string       `env:"E5_RETRYABLE_MESSAGE_CODES"                   flag:"e5-retryable-message-codes"               flagDesc:"E5 message codes of rejected requests that may succeed if retried"`
+	E5CompensationAction                   string       `env:"E5_COMPENSATION_ACTION"                       flag:"e5-compensation-action"                   flagDesc:"E5 action used to unlock an account after a payment fails part way through, timeout or reject"`
+	E5CompensationDisabledPenaltyTypes     string       `env:"E5_COMPENSATION_DISABLED_PENALTY_TYPES"       flag:"e5-compensation-disabled-penalty-types"   flagDesc:"Penalty reference types to leave locked in E5 after a payment fails part way through"`
 	MongoDBURL                             string       `env:"MONGODB_URL"                                  flag:"mongodb-url"                              flagDesc:"MongoDB server URL" json:"-"`
 	Database                               string       `env:"PPS_MONGODB_DATABASE"                         flag:"mongodb-database"                         flagDesc:"MongoDB database for data"`
 	PayableResourcesCollection             string       `env:"PPS_MONGODB_PAYABLE_RESOURCES_COLLECTION"     flag:"mongodb-payable-resources-collection"     flagDesc:"The name of the mongodb payable resources collection"`
 	AccountPenaltiesCollection             string       `env:"PPS_MONGODB_ACCOUNT_PENALTIES_COLLECTION"     flag:"mongodb-account-penalties-collection"     flagDesc:"The name of the mongodb account penalties collection"`
+	ProcessedMessagesCollection            string       `env:"PPS_MONGODB_PROCESSED_MESSAGES_COLLECTION"    flag:"mongodb-processed-messages-collection"    flagDesc:"The name of the mongodb collection of processed penalty payment messages"`
+	DeadLettersCollection                  string       `env:"PPS_MONGODB_DEAD_LETTERS_COLLECTION"          flag:"mongodb-dead-letters-collection"          flagDesc:"The name of the mongodb collection of dead-lettered penalty payment messages"`
+	OutboxCollection                       string       `env:"PPS_MONGODB_OUTBOX_COLLECTION"                flag:"mongodb-outbox-collection"                flagDesc:"The name of the mongodb collection of Kafka messages waiting to be published"`
 	AccountPenaltiesTTL                    string       `env:"PPS_ACCOUNT_PENALTIES_TTL"                    flag:"account-penalties-ttl"                    flagDesc:"The time to live for account penalties cache entry"`
 	BrokerAddr                             []string     `env:"KAFKA_BROKER_ADDR"                            flag:"broker-addr"                              flagDesc:"Kafka broker address"`
 	Kafka3BrokerAddr                       []string     `env:"KAFKA3_BROKER_ADDR"                           flag:"kafka3-broker-addr"                       flagDesc:"Kafka3 broker address"`
 	SchemaRegistryURL                      string       `env:"SCHEMA_REGISTRY_URL"                          flag:"schema-registry-url"                      flagDesc:"Schema registry url"`
 	EmailSendTopic                         string       `env:"EMAIL_SEND_TOPIC"                             flag:"email-send-topic"                         flagDesc:"Kafka topic to send emails"`
 	PenaltyPaymentsProcessingTopic         string       `env:"PENALTY_PAYMENTS_PROCESSING_TOPIC"            flag:"penalty-payments-processing-topic"        flagDesc:"Penalty payments processing topic"`
+	PenaltyPaymentsDeadLetterTopic         string       `env:"PENALTY_PAYMENTS_DEAD_LETTER_TOPIC"           flag:"penalty-payments-dead-letter-topic"       flagDesc:"Topic for penalty payments processing messages that cannot be processed"`
 	PenaltyPaymentsProcessingMaxRetries    string       `env:"PENALTY_PAYMENTS_PROCESSING_MAX_RETRIES"      flag:"penalty-payments-processing-max-retries"  flagDesc:"Penalty payments processing max retry attempts for transient errors"`
 	PenaltyPaymentsProcessingRetryDelay    string       `env:"PENALTY_PAYMENTS_PROCESSING_RETRY_DELAY"      flag:"penalty-payments-processing-retry-delay"  flagDesc:"Penalty payments processing retry delay for transient errors"`
 	PenaltyPaymentsProcessingRetryMaxDelay string       `env:"PENALTY_PAYMENTS_PROCESSING_RETRY_MAX_DELAY"  flag:"penalty-payments-processing-max-delay"    flagDesc:"Penalty payments processing max delay for a retry attempt for transient errors"`
@@ -45,6 +59,15 @@ type Config struct {
 	WeeklyMaintenanceDay                   time.Weekday `env:"WEEKLY_MAINTENANCE_DAY"                       flag:"weekly-maintenance-day"                   flagDesc:"The day on which Weekly E5 maintenance takes place"`
 	PlannedMaintenanceStart                string       `env:"PLANNED_MAINTENANCE_START_TIME"               flag:"planned-maintenance-start-time"           flagDesc:"The time of the day at which Planned E5 maintenance starts"`
 	PlannedMaintenanceEnd                  string       `env:"PLANNED_MAINTENANCE_END_TIME"                 flag:"planned-maintenance-end-time"             flagDesc:"The time of the day at which Planned E5 maintenance ends"`
+	ShutdownTimeout                        string       `env:"SHUTDOWN_TIMEOUT"                             flag:"shutdown-timeout"                         flagDesc:"How long to wait for consumers and in-flight payments to finish when stopping"`
+	OutboxRelayInterval                    string       `env:"OUTBOX_RELAY_INTERVAL"                        flag:"outbox-relay-interval"                    flagDesc:"How often the outbox is checked for Kafka messages waiting to be published"`
+	RecoveryMinAge                         string       `env:"RECOVERY_MIN_AGE"                             flag:"recovery-min-age"                         flagDesc:"How long a payable resource is pending before the recovery job checks its payment sessions"`
+	RecoveryInterval                       string       `env:"RECOVERY_INTERVAL"                            flag:"recovery-interval"                        flagDesc:"How often the recovery job runs in the service"`
+	RecoveryAPIKey                         string       `env:"RECOVERY_API_KEY"                             flag:"recovery-api-key"                         flagDesc:"API key with elevated privileges the recovery job calls the payments API with" json:"-"`
+	PayableResourceLifetime                string       `env:"PAYABLE_RESOURCE_LIFETIME"                    flag:"payable-resource-lifetime"                flagDesc:"How long a payable resource can be paid for if its penalty type does not set a lifetime"`
+	ExpirySweepInterval                    string       `env:"EXPIRY_SWEEP_INTERVAL"                        flag:"expiry-sweep-interval"                    flagDesc:"How often payable resources that have outlived their lifetime are expired"`
+	ExpiredPayableResourcesTTL             string       `env:"PPS_EXPIRED_PAYABLE_RESOURCES_TTL"            flag:"expired-payable-resources-ttl"            flagDesc:"How long expired payable resources are kept before they are deleted"`
+	PublishedOutboxMessagesTTL             string       `env:"PPS_PUBLISHED_OUTBOX_MESSAGES_TTL"            flag:"published-outbox-messages-ttl"            flagDesc:"How long published outbox messages are kept before they are deleted"`
 }
 
 // Namespace implements service.Config Namespace.
@@ -52,21 +75,212 @@ func (c *Config) Namespace() string {
 	return "penalty-payment-api"
 }
 
-// PenaltyDetailsMap defines the struct to hold the map of penalty details.
+// DeadLetterTopic returns the topic that penalty payments processing messages which cannot be processed are published
+// to, which defaults to the penalty payments processing topic with a -dead-letter suffix
+func (c *Config) DeadLetterTopic() string {
+	if c.PenaltyPaymentsDeadLetterTopic != "" {
+		return c.PenaltyPaymentsDeadLetterTopic
+	}
+	return c.PenaltyPaymentsProcessingTopic + "-dead-letter"
+}
+
+// DefaultShutdownTimeout is how long the service waits for consumers and in-flight payments to finish when stopping
+// if SHUTDOWN_TIMEOUT is not set or is invalid
+const DefaultShutdownTimeout = 30 * time.Second
+
+// GetShutdownTimeout returns how long the service waits for consumers and in-flight payments to finish when stopping
+func (c *Config) GetShutdownTimeout() time.Duration {
+	timeout, err := time.ParseDuration(c.ShutdownTimeout)
+	if err != nil || timeout <= 0 {
+		return DefaultShutdownTimeout
+	}
+	return timeout
+}
+
+// DefaultOutboxRelayInterval is how often the outbox is checked for Kafka messages waiting to be published if
+// OUTBOX_RELAY_INTERVAL is not set or is invalid
+const DefaultOutboxRelayInterval = 5 * time.Second
+
+// GetOutboxRelayInterval returns how often the outbox is checked for Kafka messages waiting to be published
+func (c *Config) GetOutboxRelayInterval() time.Duration {
+	interval, err := time.ParseDuration(c.OutboxRelayInterval)
+	if err != nil || interval <= 0 {
+		return DefaultOutboxRelayInterval
+	}
+	return interval
+}
+
+// DefaultRecoveryMinAge is how long a payable resource is pending before the recovery job checks its payment sessions
+// if RECOVERY_MIN_AGE is not set or is invalid
+const DefaultRecoveryMinAge = time.Hour
+
+// GetRecoveryMinAge returns how long a payable resource is pending before the recovery job checks its payment sessions
+func (c *Config) GetRecoveryMinAge() time.Duration {
+	minAge, err := time.ParseDuration(c.RecoveryMinAge)
+	if err != nil || minAge <= 0 {
+		return DefaultRecoveryMinAge
+	}
+	return minAge
+}
+
+// DefaultRecoveryInterval is how often the recovery job runs in the service if RECOVERY_INTERVAL is not set or is
+// invalid
+const DefaultRecoveryInterval = 15 * time.Minute
+
+// GetRecoveryInterval returns how often the recovery job runs in the service
+func (c *Config) GetRecoveryInterval() time.Duration {
+	interval, err := time.ParseDuration(c.RecoveryInterval)
+	if err != nil || interval <= 0 {
+		return DefaultRecoveryInterval
+	}
+	return interval
+}
+
+// DefaultPayableResourceLifetime is how long a payable resource can be paid for if neither its penalty type nor
+// PAYABLE_RESOURCE_LIFETIME set a valid lifetime
+const DefaultPayableResourceLifetime = 24 * time.Hour
+
+// GetPayableResourceLifetime returns how long a payable resource can be paid for if its penalty type does not set a
+// lifetime
+func (c *Config) GetPayableResourceLifetime() time.Duration {
+	lifetime, err := time.ParseDuration(c.PayableResourceLifetime)
+	if err != nil || lifetime <= 0 {
+		return DefaultPayableResourceLifetime
+	}
+	return lifetime
+}
+
+// DefaultExpirySweepInterval is how often payable resources that have outlived their lifetime are expired if
+// EXPIRY_SWEEP_INTERVAL is not set or is invalid
+const DefaultExpirySweepInterval = 10 * time.Minute
+
+// GetExpirySweepInterval returns how often payable resources that have outlived their lifetime are expired
+func (c *Config) GetExpirySweepInterval() time.Duration {
+	interval, err := time.ParseDuration(c.ExpirySweepInterval)
+	if err != nil || interval <= 0 {
+		return DefaultExpirySweepInterval
+	}
+	return interval
+}
+
+// DefaultExpiredPayableResourcesTTL is how long expired payable resources are kept before they are deleted if
+// PPS_EXPIRED_PAYABLE_RESOURCES_TTL is not set or is invalid
+const DefaultExpiredPayableResourcesTTL = 90 * 24 * time.Hour
+
+// GetExpiredPayableResourcesTTL returns how long expired payable resources are kept before they are deleted
+func (c *Config) GetExpiredPayableResourcesTTL() time.Duration {
+	ttl, err := time.ParseDuration(c.ExpiredPayableResourcesTTL)
+	if err != nil || ttl <= 0 {
+		return DefaultExpiredPayableResourcesTTL
+	}
+	return ttl
+}
+
+// DefaultPublishedOutboxMessagesTTL is how long published outbox messages are kept before they are deleted if
+// PPS_PUBLISHED_OUTBOX_MESSAGES_TTL is not set or is invalid
+const DefaultPublishedOutboxMessagesTTL = 7 * 24 * time.Hour
+
+// GetPublishedOutboxMessagesTTL returns how long published outbox messages are kept before they are deleted
+func (c *Config) GetPublishedOutboxMessagesTTL() time.Duration {
+	ttl, err := time.ParseDuration(c.PublishedOutboxMessagesTTL)
+	if err != nil || ttl <= 0 {
+		return DefaultPublishedOutboxMessagesTTL
+	}
+	return ttl
+}
+
+// PenaltyDetailsMap defines the struct to hold the map of penalty details. DefaultRefType is the penalty reference type
+// of requests that do not give one.
 type PenaltyDetailsMap struct {
-	Name    string                    `yaml:"name"`
-	Details map[string]PenaltyDetails `yaml:"details"`
+	Name           string                    `yaml:"name"`
+	DefaultRefType string                    `yaml:"default_ref_type"`
+	Details        map[string]PenaltyDetails `yaml:"details"`
 }
 
-// PenaltyDetails defines the struct to hold the penalty details.
+// PenaltyDetails defines the struct to hold the penalty details. CompanyCode, ReferencePrefix, ReferenceRegex,
+// OpenDunningStatuses, OpenAccountStatuses, Reason and TransactionSubTypes define the penalty type in the
+// PenaltyTypeRegistry. TransactionSubTypes maps the E5 invoice subtypes the penalty type is issued under to the reason
+// shown for each, and Reason is shown for a penalty type that lists no subtypes. EnabledFrom and EnabledTo are the
+// RFC 3339 times between which the penalty type is enabled, and either can be left empty for no bound.
 type PenaltyDetails struct {
-	Description        string `yaml:"Description"`
-	DescriptionId      string `yaml:"DescriptionId"`
-	ClassOfPayment     string `yaml:"ClassOfPayment"`
-	ResourceKind       string `yaml:"ResourceKind"`
-	ProductType        string `yaml:"ProductType"`
-	EmailReceivedAppId string `yaml:"EmailReceivedAppId"`
-	EmailMsgType       string `yaml:"EmailMsgType"`
+	CompanyCode             string            `yaml:"CompanyCode"`
+	ReferencePrefix         string            `yaml:"ReferencePrefix"`
+	ReferenceRegex          string            `yaml:"ReferenceRegex"`
+	OpenDunningStatuses     []string          `yaml:"OpenDunningStatuses"`
+	OpenAccountStatuses     []string          `yaml:"OpenAccountStatuses"`
+	Reason                  string            `yaml:"Reason"`
+	TransactionSubTypes     map[string]string `yaml:"TransactionSubTypes"`
+	Description             string            `yaml:"Description"`
+	DescriptionId           string            `yaml:"DescriptionId"`
+	ClassOfPayment          string            `yaml:"ClassOfPayment"`
+	ResourceKind            string            `yaml:"ResourceKind"`
+	ProductType             string            `yaml:"ProductType"`
+	EmailReceivedAppId      string            `yaml:"EmailReceivedAppId"`
+	EmailMsgType            string            `yaml:"EmailMsgType"`
+	PayableResourceLifetime string            `yaml:"PayableResourceLifetime"`
+	PartPayment             PartPaymentPolicy `yaml:"PartPayment"`
+	EnabledFrom             string            `yaml:"EnabledFrom"`
+	EnabledTo               string            `yaml:"EnabledTo"`
+}
+
+// PartPaymentPolicy defines whether less than the outstanding amount of a penalty can be paid, and the bounds of the
+// amount that can be paid. A MaximumAmount of 0 sets no bound other than the outstanding amount.
+type PartPaymentPolicy struct {
+	Allowed       bool    `yaml:"Allowed"`
+	MinimumAmount float64 `yaml:"MinimumAmount"`
+	MaximumAmount float64 `yaml:"MaximumAmount"`
+}
+
+// AllowsPartPayment checks whether amount can be paid off the outstanding amount as a part payment
+func (p PartPaymentPolicy) AllowsPartPayment(amount, outstanding float64) bool {
+	if !p.Allowed || amount <= 0 || amount >= outstanding {
+		return false
+	}
+	if amount < p.MinimumAmount {
+		return false
+	}
+	return p.MaximumAmount <= 0 || amount <= p.MaximumAmount
+}
+
+// GetPayableResourceLifetime returns how long a payable resource for the penalty type can be paid for, or
+// defaultLifetime if the penalty type does not set a valid lifetime
+func (d PenaltyDetails) GetPayableResourceLifetime(defaultLifetime time.Duration) time.Duration {
+	lifetime, err := time.ParseDuration(d.PayableResourceLifetime)
+	if err != nil || lifetime <= 0 {
+		return defaultLifetime
+	}
+	return lifetime
+}
+
+// GetEnabledWindow parses the times between which the penalty type is enabled. A bound that is not set is nil.
+func (d PenaltyDetails) GetEnabledWindow() (enabledFrom *time.Time, enabledTo *time.Time, err error) {
+	if d.EnabledFrom != "" {
+		from, err := time.Parse(time.RFC3339, d.EnabledFrom)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid EnabledFrom: [%v]", err)
+		}
+		enabledFrom = &from
+	}
+	if d.EnabledTo != "" {
+		to, err := time.Parse(time.RFC3339, d.EnabledTo)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid EnabledTo: [%v]", err)
+		}
+		enabledTo = &to
+	}
+	if enabledFrom != nil && enabledTo != nil && !enabledFrom.Before(*enabledTo) {
+		return nil, nil, fmt.Errorf("EnabledFrom is not before EnabledTo")
+	}
+	return enabledFrom, enabledTo, nil
+}
+
+// IsEnabledAt checks whether the time is within the times between which the penalty type is enabled
+func (d PenaltyDetails) IsEnabledAt(at time.Time) bool {
+	enabledFrom, enabledTo, err := d.GetEnabledWindow()
+	if err != nil {
+		return false
+	}
+	return (enabledFrom == nil || !at.Before(*enabledFrom)) && (enabledTo == nil || at.Before(*enabledTo))
 }
 
 // Get returns a pointer to a Config instance
@@ -8,7 +8,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -210,6 +209,9 @@ name: penalty details
 details:
   LATE_FILING:
     EmailReceivedAppId: "penalty-payment-api.penalty_payment_received_email"
+    PartPayment:
+      Allowed: true
+      MinimumAmount: 10
 `)
 			tmpFile, err := os.CreateTemp("", "config_*.yaml")
 			if err != nil {
@@ -229,7 +231,8 @@ details:
 			Convey("Then the penalty details should be returned", func() {
 				So(err, ShouldBeNil)
 				So(penaltyDetailsMap.Name, ShouldEqual, "penalty details")
-				So(penaltyDetailsMap.Details[utils.LateFilingPenaltyRefType].EmailReceivedAppId, ShouldEqual, "penalty-payment-api.penalty_payment_received_email")
+				So(penaltyDetailsMap.Details["LATE_FILING"].EmailReceivedAppId, ShouldEqual, "penalty-payment-api.penalty_payment_received_email")
+				So(penaltyDetailsMap.Details["LATE_FILING"].PartPayment, ShouldResemble, PartPaymentPolicy{Allowed: true, MinimumAmount: 10})
 			})
 		})
 	})
@@ -331,3 +334,181 @@ func TestUnitGet(t *testing.T) {
 		})
 	})
 }
+
+func TestUnitDeadLetterTopic(t *testing.T) {
+	Convey("Dead letter topic defaults to the processing topic with a suffix", t, func() {
+		cfg := &Config{PenaltyPaymentsProcessingTopic: "penalty-payments-processing"}
+		So(cfg.DeadLetterTopic(), ShouldEqual, "penalty-payments-processing-dead-letter")
+
+		cfg.PenaltyPaymentsDeadLetterTopic = "penalty-payments-quarantine"
+		So(cfg.DeadLetterTopic(), ShouldEqual, "penalty-payments-quarantine")
+	})
+}
+
+func TestUnitGetShutdownTimeout(t *testing.T) {
+	Convey("Shutdown timeout defaults when it is not set or is invalid", t, func() {
+		cfg := &Config{}
+		So(cfg.GetShutdownTimeout(), ShouldEqual, DefaultShutdownTimeout)
+
+		cfg.ShutdownTimeout = "soon"
+		So(cfg.GetShutdownTimeout(), ShouldEqual, DefaultShutdownTimeout)
+
+		cfg.ShutdownTimeout = "45s"
+		So(cfg.GetShutdownTimeout(), ShouldEqual, 45*time.Second)
+	})
+}
+
+func TestUnitGetOutboxRelayInterval(t *testing.T) {
+	Convey("Outbox relay interval defaults when it is not set or is invalid", t, func() {
+		cfg := &Config{}
+		So(cfg.GetOutboxRelayInterval(), ShouldEqual, DefaultOutboxRelayInterval)
+
+		cfg.OutboxRelayInterval = "-1s"
+		So(cfg.GetOutboxRelayInterval(), ShouldEqual, DefaultOutboxRelayInterval)
+
+		cfg.OutboxRelayInterval = "2s"
+		So(cfg.GetOutboxRelayInterval(), ShouldEqual, 2*time.Second)
+	})
+}
+
+func TestUnitGetRecoveryMinAge(t *testing.T) {
+	Convey("Recovery min age defaults when it is not set or is invalid", t, func() {
+		cfg := &Config{}
+		So(cfg.GetRecoveryMinAge(), ShouldEqual, DefaultRecoveryMinAge)
+
+		cfg.RecoveryMinAge = "later"
+		So(cfg.GetRecoveryMinAge(), ShouldEqual, DefaultRecoveryMinAge)
+
+		cfg.RecoveryMinAge = "30m"
+		So(cfg.GetRecoveryMinAge(), ShouldEqual, 30*time.Minute)
+	})
+}
+
+func TestUnitGetRecoveryInterval(t *testing.T) {
+	Convey("Recovery interval defaults when it is not set or is invalid", t, func() {
+		cfg := &Config{}
+		So(cfg.GetRecoveryInterval(), ShouldEqual, DefaultRecoveryInterval)
+
+		cfg.RecoveryInterval = "often"
+		So(cfg.GetRecoveryInterval(), ShouldEqual, DefaultRecoveryInterval)
+
+		cfg.RecoveryInterval = "5m"
+		So(cfg.GetRecoveryInterval(), ShouldEqual, 5*time.Minute)
+	})
+}
+
+func TestUnitGetPayableResourceLifetime(t *testing.T) {
+	Convey("Payable resource lifetime defaults when it is not set or is invalid", t, func() {
+		cfg := &Config{}
+		So(cfg.GetPayableResourceLifetime(), ShouldEqual, DefaultPayableResourceLifetime)
+
+		cfg.PayableResourceLifetime = "0s"
+		So(cfg.GetPayableResourceLifetime(), ShouldEqual, DefaultPayableResourceLifetime)
+
+		cfg.PayableResourceLifetime = "48h"
+		So(cfg.GetPayableResourceLifetime(), ShouldEqual, 48*time.Hour)
+	})
+
+	Convey("A penalty type's lifetime overrides the default lifetime", t, func() {
+		So(PenaltyDetails{}.GetPayableResourceLifetime(time.Hour), ShouldEqual, time.Hour)
+		So(PenaltyDetails{PayableResourceLifetime: "never"}.GetPayableResourceLifetime(time.Hour), ShouldEqual, time.Hour)
+		So(PenaltyDetails{PayableResourceLifetime: "72h"}.GetPayableResourceLifetime(time.Hour), ShouldEqual, 72*time.Hour)
+	})
+}
+
+func TestUnitGetEnabledWindow(t *testing.T) {
+	Convey("A penalty type without an enabled window is always enabled", t, func() {
+		enabledFrom, enabledTo, err := PenaltyDetails{}.GetEnabledWindow()
+		So(err, ShouldBeNil)
+		So(enabledFrom, ShouldBeNil)
+		So(enabledTo, ShouldBeNil)
+		So(PenaltyDetails{}.IsEnabledAt(time.Now()), ShouldBeTrue)
+	})
+
+	Convey("A penalty type is only enabled within its enabled window", t, func() {
+		details := PenaltyDetails{EnabledFrom: "2025-04-01T00:00:00Z", EnabledTo: "2026-04-01T00:00:00Z"}
+
+		enabledFrom, enabledTo, err := details.GetEnabledWindow()
+		So(err, ShouldBeNil)
+		So(*enabledFrom, ShouldEqual, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC))
+		So(*enabledTo, ShouldEqual, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+
+		So(details.IsEnabledAt(time.Date(2025, 3, 31, 23, 59, 59, 0, time.UTC)), ShouldBeFalse)
+		So(details.IsEnabledAt(time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)), ShouldBeTrue)
+		So(details.IsEnabledAt(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)), ShouldBeFalse)
+	})
+
+	Convey("An enabled window that is not valid is an error and is never enabled", t, func() {
+		testCases := []PenaltyDetails{
+			{EnabledFrom: "2025-04-01"},
+			{EnabledTo: "tomorrow"},
+			{EnabledFrom: "2026-04-01T00:00:00Z", EnabledTo: "2025-04-01T00:00:00Z"},
+		}
+		for _, details := range testCases {
+			_, _, err := details.GetEnabledWindow()
+			So(err, ShouldNotBeNil)
+			So(details.IsEnabledAt(time.Now()), ShouldBeFalse)
+		}
+	})
+}
+
+func TestUnitAllowsPartPayment(t *testing.T) {
+	Convey("A part payment is only allowed within the bounds of the policy", t, func() {
+		policy := PartPaymentPolicy{Allowed: true, MinimumAmount: 10, MaximumAmount: 500}
+
+		So(policy.AllowsPartPayment(100, 750), ShouldBeTrue)
+		So(policy.AllowsPartPayment(10, 750), ShouldBeTrue)
+		So(policy.AllowsPartPayment(500, 750), ShouldBeTrue)
+		So(policy.AllowsPartPayment(9.99, 750), ShouldBeFalse)
+		So(policy.AllowsPartPayment(500.01, 750), ShouldBeFalse)
+		So(policy.AllowsPartPayment(750, 750), ShouldBeFalse)
+		So(policy.AllowsPartPayment(0, 750), ShouldBeFalse)
+	})
+
+	Convey("Without a maximum any amount less than the outstanding amount is allowed", t, func() {
+		So(PartPaymentPolicy{Allowed: true}.AllowsPartPayment(749.99, 750), ShouldBeTrue)
+	})
+
+	Convey("A part payment is not allowed unless the policy allows it", t, func() {
+		So(PartPaymentPolicy{MinimumAmount: 10}.AllowsPartPayment(100, 750), ShouldBeFalse)
+	})
+}
+
+func TestUnitGetExpirySweepInterval(t *testing.T) {
+	Convey("Expiry sweep interval defaults when it is not set or is invalid", t, func() {
+		cfg := &Config{}
+		So(cfg.GetExpirySweepInterval(), ShouldEqual, DefaultExpirySweepInterval)
+
+		cfg.ExpirySweepInterval = "often"
+		So(cfg.GetExpirySweepInterval(), ShouldEqual, DefaultExpirySweepInterval)
+
+		cfg.ExpirySweepInterval = "1m"
+		So(cfg.GetExpirySweepInterval(), ShouldEqual, time.Minute)
+	})
+}
+
+func TestUnitGetExpiredPayableResourcesTTL(t *testing.T) {
+	Convey("Expired payable resources TTL defaults when it is not set or is invalid", t, func() {
+		cfg := &Config{}
+		So(cfg.GetExpiredPayableResourcesTTL(), ShouldEqual, DefaultExpiredPayableResourcesTTL)
+
+		cfg.ExpiredPayableResourcesTTL = "-24h"
+		So(cfg.GetExpiredPayableResourcesTTL(), ShouldEqual, DefaultExpiredPayableResourcesTTL)
+
+		cfg.ExpiredPayableResourcesTTL = "720h"
+		So(cfg.GetExpiredPayableResourcesTTL(), ShouldEqual, 720*time.Hour)
+	})
+}
+
+func TestUnitGetPublishedOutboxMessagesTTL(t *testing.T) {
+	Convey("Published outbox messages TTL defaults when it is not set or is invalid", t, func() {
+		cfg := &Config{}
+		So(cfg.GetPublishedOutboxMessagesTTL(), ShouldEqual, DefaultPublishedOutboxMessagesTTL)
+
+		cfg.PublishedOutboxMessagesTTL = "0s"
+		So(cfg.GetPublishedOutboxMessagesTTL(), ShouldEqual, DefaultPublishedOutboxMessagesTTL)
+
+		cfg.PublishedOutboxMessagesTTL = "24h"
+		So(cfg.GetPublishedOutboxMessagesTTL(), ShouldEqual, 24*time.Hour)
+	})
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// PayableStatusRules defines the struct to hold the rules that the payable status of a transaction is got from. The
+// rules are evaluated in order and the status of the first rule whose conditions all hold is used, or DefaultStatus if
+// no rule holds. InstalmentTransaction is the E5 type and subtype of an instalment of an instalment plan, which the
+// is_instalment and earliest_unpaid_instalment conditions check for.
+type PayableStatusRules struct {
+	Version               int                          `yaml:"version"`
+	DefaultStatus         string                       `yaml:"default_status"`
+	InstalmentTransaction *RelatedTransactionCondition `yaml:"instalment_transaction"`
+	Rules                 []PayableStatusRule          `yaml:"rules"`
+}
+
+// PayableStatusRule defines the struct to hold a payable status rule
+type PayableStatusRule struct {
+	Name   string                  `yaml:"name"`
+	Status string                  `yaml:"status"`
+	When   PayableStatusConditions `yaml:"when"`
+}
+
+// PayableStatusConditions defines the struct to hold the conditions of a payable status rule. A condition that is not
+// set always holds.
+type PayableStatusConditions struct {
+	// TransactionType is the type the transaction is listed with, penalty or other
+	TransactionType string `yaml:"transaction_type"`
+	// SubTypeDisabled is whether the subtype of the transaction is in DISABLED_PENALTY_TRANSACTION_SUBTYPES
+	SubTypeDisabled *bool `yaml:"subtype_disabled"`
+	// IsPaid is whether the transaction is paid
+	IsPaid *bool `yaml:"is_paid"`
+	// PaidToday is whether the account penalties of the customer were last paid today
+	PaidToday *bool `yaml:"paid_today"`
+	// PaymentAllocated is whether E5 has allocated the payment of the transaction, i.e. its outstanding amount is 0
+	PaymentAllocated *bool `yaml:"payment_allocated"`
+	// HasOutstandingAmount is whether the outstanding amount of the transaction is more than 0
+	HasOutstandingAmount *bool `yaml:"has_outstanding_amount"`
+	// CompanyCodes are the E5 company codes, one of which the transaction must have
+	CompanyCodes []string `yaml:"company_codes"`
+	// DunningStatuses are the E5 dunning statuses, one of which the transaction must have
+	DunningStatuses []string `yaml:"dunning_statuses"`
+	// AccountStatuses are the E5 account statuses, one of which the transaction must have
+	AccountStatuses []string `yaml:"account_statuses"`
+	// RelatedTransaction is the E5 type and subtype of a transaction that the customer must have with the made up date
+	// of the transaction, e.g. an instalment plan
+	RelatedTransaction *RelatedTransactionCondition `yaml:"related_transaction"`
+	// UnpaidCostDunningStatuses are the E5 dunning statuses, one of which an unpaid cost of the penalty must have
+	UnpaidCostDunningStatuses []string `yaml:"unpaid_cost_dunning_statuses"`
+	// OpenForPenaltyType is whether the dunning and account statuses of the penalty are ones that its penalty type can
+	// be paid with in the PenaltyTypeRegistry
+	OpenForPenaltyType *bool `yaml:"open_for_penalty_type"`
+	// IsInstalment is whether the transaction has the E5 type and subtype of an instalment in the instalment_transaction
+	// of the rules
+	IsInstalment *bool `yaml:"is_instalment"`
+	// EarliestUnpaidInstalment is whether no other unpaid instalment with the made up date of the transaction is due
+	// before it
+	EarliestUnpaidInstalment *bool `yaml:"earliest_unpaid_instalment"`
+	// PenaltyStatuses are the payable statuses, one of which the penalty of a cost must have. The penalty of a cost is
+	// the only penalty with its made up date, so the condition does not hold if there is none or more than one.
+	PenaltyStatuses []string `yaml:"penalty_statuses"`
+}
+
+// RelatedTransactionCondition defines the struct to hold the E5 transaction type and subtype of a related transaction
+// or of an instalment
+type RelatedTransactionCondition struct {
+	TransactionType    string `yaml:"transaction_type"`
+	TransactionSubType string `yaml:"transaction_subtype"`
+}
+
+// LoadPayableStatusRules loads the payable status rules. Fields that are not known are rejected, so that a mistyped
+// condition is not ignored.
+func LoadPayableStatusRules(fileName string) (*PayableStatusRules, error) {
+	yamlFile, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var payableStatusRules PayableStatusRules
+
+	err = yaml.UnmarshalStrict(yamlFile, &payableStatusRules)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payableStatusRules, nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeTmpPayableStatusRules(t *testing.T, testYaml string) string {
+	tmpFile, err := os.CreateTemp("", "payable_status_rules_*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create tmp file: %v", err)
+	}
+	if _, err := tmpFile.Write([]byte(testYaml)); err != nil {
+		t.Fatalf("Failed to write tmp file: %v", err)
+	}
+	return tmpFile.Name()
+}
+
+func TestUnitLoadPayableStatusRules(t *testing.T) {
+	Convey("Given the main method tries to load the payable status rules yaml file", t, func() {
+		Convey("When the file does not exist", func() {
+			rules, err := LoadPayableStatusRules("status_rules.yml")
+
+			Convey("Then an error should be returned", func() {
+				So(err.Error(), ShouldEqual, "open status_rules.yml: no such file or directory")
+				So(rules, ShouldBeNil)
+			})
+		})
+
+		Convey("When a rule has a condition that is not known", func() {
+			fileName := writeTmpPayableStatusRules(t, `
+version: 1
+default_status: CLOSED
+rules:
+  - name: with a debt collecting agency
+    status: CLOSED
+    when:
+      dunning_status: ["DCA"]
+`)
+			defer os.Remove(fileName)
+
+			rules, err := LoadPayableStatusRules(fileName)
+
+			Convey("Then an error should be returned", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "field dunning_status not found")
+				So(rules, ShouldBeNil)
+			})
+		})
+
+		Convey("When the yaml file exists and is in the correct format", func() {
+			fileName := writeTmpPayableStatusRules(t, `
+version: 1
+default_status: CLOSED
+instalment_transaction:
+  transaction_type: "1"
+  transaction_subtype: "I1"
+rules:
+  - name: instalment plan
+    status: CLOSED_INSTALMENT_PLAN
+    when:
+      related_transaction:
+        transaction_type: "P"
+        transaction_subtype: "00"
+  - name: open late filing penalty
+    status: OPEN
+    when:
+      is_paid: false
+      company_codes: ["LP"]
+      dunning_statuses: ["PEN1", "PEN2"]
+  - name: unpaid cost of an open penalty
+    status: OPEN_WITH_PENALTY
+    when:
+      transaction_type: other
+      is_instalment: false
+      penalty_statuses: ["OPEN"]
+`)
+			defer os.Remove(fileName)
+
+			rules, err := LoadPayableStatusRules(fileName)
+
+			Convey("Then the rules should be returned in order", func() {
+				So(err, ShouldBeNil)
+				So(rules.Version, ShouldEqual, 1)
+				So(rules.DefaultStatus, ShouldEqual, "CLOSED")
+				So(*rules.InstalmentTransaction, ShouldResemble,
+					RelatedTransactionCondition{TransactionType: "1", TransactionSubType: "I1"})
+				So(rules.Rules, ShouldHaveLength, 3)
+				So(rules.Rules[0].Name, ShouldEqual, "instalment plan")
+				So(*rules.Rules[0].When.RelatedTransaction, ShouldResemble,
+					RelatedTransactionCondition{TransactionType: "P", TransactionSubType: "00"})
+				So(rules.Rules[1].Status, ShouldEqual, "OPEN")
+				So(rules.Rules[1].When.IsPaid, ShouldNotBeNil)
+				So(*rules.Rules[1].When.IsPaid, ShouldBeFalse)
+				So(rules.Rules[1].When.PaidToday, ShouldBeNil)
+				So(rules.Rules[1].When.CompanyCodes, ShouldResemble, []string{"LP"})
+				So(rules.Rules[1].When.DunningStatuses, ShouldResemble, []string{"PEN1", "PEN2"})
+				So(rules.Rules[2].When.TransactionType, ShouldEqual, "other")
+				So(*rules.Rules[2].When.IsInstalment, ShouldBeFalse)
+				So(rules.Rules[2].When.EarliestUnpaidInstalment, ShouldBeNil)
+				So(rules.Rules[2].When.PenaltyStatuses, ShouldResemble, []string{"OPEN"})
+			})
+		})
+	})
+}
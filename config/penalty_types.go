@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/companieshouse/penalty-payment-api/assets"
+)
+
+// PenaltyTypeRegistry looks up the penalty reference types configured in the penalty details, so that a penalty type
+// can be onboarded without code changes
+type PenaltyTypeRegistry struct {
+	refTypes         []string
+	defaultRefType   string
+	details          map[string]PenaltyDetails
+	referenceRegexes map[string]*regexp.Regexp
+}
+
+var (
+	penaltyTypes    *PenaltyTypeRegistry
+	penaltyTypesMtx sync.Mutex
+)
+
+// NewPenaltyTypeRegistry builds the registry of the penalty types in the penalty details. Every penalty type must have
+// a company code and a reference prefix, and no reference prefix can start another.
+func NewPenaltyTypeRegistry(penaltyDetailsMap *PenaltyDetailsMap) (*PenaltyTypeRegistry, error) {
+	registry := &PenaltyTypeRegistry{
+		details:          map[string]PenaltyDetails{},
+		referenceRegexes: map[string]*regexp.Regexp{},
+	}
+
+	for penaltyRefType, details := range penaltyDetailsMap.Details {
+		if details.CompanyCode == "" {
+			return nil, fmt.Errorf("penalty reference type %s has no company code", penaltyRefType)
+		}
+		if details.ReferencePrefix == "" {
+			return nil, fmt.Errorf("penalty reference type %s has no reference prefix", penaltyRefType)
+		}
+		if details.ReferenceRegex != "" {
+			referenceRegex, err := regexp.Compile(details.ReferenceRegex)
+			if err != nil {
+				return nil, fmt.Errorf("penalty reference type %s has an invalid reference regex: [%v]", penaltyRefType, err)
+			}
+			registry.referenceRegexes[penaltyRefType] = referenceRegex
+		}
+		if _, _, err := details.GetEnabledWindow(); err != nil {
+			return nil, fmt.Errorf("penalty reference type %s has an invalid enabled window: %v", penaltyRefType, err)
+		}
+
+		registry.refTypes = append(registry.refTypes, penaltyRefType)
+		registry.details[penaltyRefType] = details
+	}
+	sort.Strings(registry.refTypes)
+
+	if penaltyDetailsMap.DefaultRefType != "" {
+		if _, ok := registry.details[penaltyDetailsMap.DefaultRefType]; !ok {
+			return nil, fmt.Errorf("default penalty reference type %s is not a penalty reference type", penaltyDetailsMap.DefaultRefType)
+		}
+		registry.defaultRefType = penaltyDetailsMap.DefaultRefType
+	}
+
+	for _, penaltyRefType := range registry.refTypes {
+		for _, otherRefType := range registry.refTypes {
+			if penaltyRefType != otherRefType &&
+				strings.HasPrefix(registry.details[penaltyRefType].ReferencePrefix, registry.details[otherRefType].ReferencePrefix) {
+				return nil, fmt.Errorf("penalty reference types %s and %s have clashing reference prefixes", penaltyRefType, otherRefType)
+			}
+		}
+	}
+
+	return registry, nil
+}
+
+// RegisterPenaltyTypes replaces the registry of penalty types with the penalty types in the penalty details
+func RegisterPenaltyTypes(penaltyDetailsMap *PenaltyDetailsMap) error {
+	registry, err := NewPenaltyTypeRegistry(penaltyDetailsMap)
+	if err != nil {
+		return err
+	}
+
+	penaltyTypesMtx.Lock()
+	defer penaltyTypesMtx.Unlock()
+	penaltyTypes = registry
+	return nil
+}
+
+// GetPenaltyTypes returns the registry of penalty types. Until penalty types are registered it holds the penalty
+// types in the penalty details built into the binary, which are loaded the first time the registry is needed.
+func GetPenaltyTypes() (*PenaltyTypeRegistry, error) {
+	penaltyTypesMtx.Lock()
+	defer penaltyTypesMtx.Unlock()
+
+	if penaltyTypes != nil {
+		return penaltyTypes, nil
+	}
+
+	registry, err := loadEmbeddedPenaltyTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	penaltyTypes = registry
+	return penaltyTypes, nil
+}
+
+// loadEmbeddedPenaltyTypes builds the registry from the penalty details built into the binary
+func loadEmbeddedPenaltyTypes() (*PenaltyTypeRegistry, error) {
+	var penaltyDetailsMap PenaltyDetailsMap
+	if err := yaml.Unmarshal(assets.PenaltyDetails, &penaltyDetailsMap); err != nil {
+		return nil, fmt.Errorf("error reading embedded penalty details: [%v]", err)
+	}
+
+	registry, err := NewPenaltyTypeRegistry(&penaltyDetailsMap)
+	if err != nil {
+		return nil, fmt.Errorf("embedded penalty details are not valid: [%v]", err)
+	}
+	return registry, nil
+}
+
+// DefaultRefType returns the penalty reference type of requests that do not give one
+func (r *PenaltyTypeRegistry) DefaultRefType() (string, error) {
+	if r.defaultRefType == "" {
+		return "", fmt.Errorf("no default penalty reference type configured")
+	}
+	return r.defaultRefType, nil
+}
+
+// RefTypes lists every penalty reference type in alphabetical order
+func (r *PenaltyTypeRegistry) RefTypes() []string {
+	return append([]string(nil), r.refTypes...)
+}
+
+// CompanyCode gets the E5 company code of the penalty reference type
+func (r *PenaltyTypeRegistry) CompanyCode(penaltyRefType string) (string, error) {
+	details, ok := r.details[penaltyRefType]
+	if !ok {
+		return "", fmt.Errorf("invalid penalty reference type supplied")
+	}
+	return details.CompanyCode, nil
+}
+
+// ReferencePrefix gets the prefix of the penalty references issued for the penalty reference type
+func (r *PenaltyTypeRegistry) ReferencePrefix(penaltyRefType string) (string, error) {
+	details, ok := r.details[penaltyRefType]
+	if !ok {
+		return "", fmt.Errorf("invalid penalty reference type supplied")
+	}
+	return details.ReferencePrefix, nil
+}
+
+// ReferenceRegex gets the regex that penalty references of the penalty reference type match. A penalty type that sets
+// no reference regex matches every penalty reference that starts with its reference prefix.
+func (r *PenaltyTypeRegistry) ReferenceRegex(penaltyRefType string) (string, error) {
+	details, ok := r.details[penaltyRefType]
+	if !ok {
+		return "", fmt.Errorf("invalid penalty reference type supplied")
+	}
+	if details.ReferenceRegex != "" {
+		return details.ReferenceRegex, nil
+	}
+	return "^" + regexp.QuoteMeta(details.ReferencePrefix), nil
+}
+
+// RefTypeFromReference gets the penalty reference type of a penalty reference from its prefix. If the penalty type
+// sets a reference regex the penalty reference must match it too.
+func (r *PenaltyTypeRegistry) RefTypeFromReference(penaltyRef string) (string, error) {
+	for _, penaltyRefType := range r.refTypes {
+		if !strings.HasPrefix(penaltyRef, r.details[penaltyRefType].ReferencePrefix) {
+			continue
+		}
+		if referenceRegex, ok := r.referenceRegexes[penaltyRefType]; ok && !referenceRegex.MatchString(penaltyRef) {
+			continue
+		}
+		return penaltyRefType, nil
+	}
+	return "", fmt.Errorf("error converting penalty reference")
+}
+
+// IsCompanyCode checks whether the company code is the E5 company code of a penalty reference type
+func (r *PenaltyTypeRegistry) IsCompanyCode(companyCode string) bool {
+	for _, details := range r.details {
+		if details.CompanyCode == companyCode {
+			return true
+		}
+	}
+	return false
+}
+
+// IssuedUnder checks whether penalties of the penalty reference type are issued under the E5 invoice subtype. A
+// penalty type that lists no subtypes is issued under every subtype not listed by another penalty type.
+func (r *PenaltyTypeRegistry) IssuedUnder(penaltyRefType, transactionSubType string) bool {
+	details, ok := r.details[penaltyRefType]
+	if !ok {
+		return false
+	}
+	if len(details.TransactionSubTypes) > 0 {
+		_, listed := details.TransactionSubTypes[transactionSubType]
+		return listed
+	}
+	for _, otherDetails := range r.details {
+		if _, listed := otherDetails.TransactionSubTypes[transactionSubType]; listed {
+			return false
+		}
+	}
+	return true
+}
+
+// Reason gets the reason shown for a penalty of the E5 company code and invoice subtype, or an empty string if no
+// penalty type sets one
+func (r *PenaltyTypeRegistry) Reason(companyCode, transactionSubType string) string {
+	for _, penaltyRefType := range r.refTypes {
+		details := r.details[penaltyRefType]
+		if details.CompanyCode == companyCode && details.TransactionSubTypes[transactionSubType] != "" {
+			return details.TransactionSubTypes[transactionSubType]
+		}
+	}
+	for _, penaltyRefType := range r.refTypes {
+		details := r.details[penaltyRefType]
+		if details.CompanyCode == companyCode && len(details.TransactionSubTypes) == 0 && details.Reason != "" {
+			return details.Reason
+		}
+	}
+	return ""
+}
+
+// IsOpen checks whether a penalty of the E5 company code with the dunning and account statuses can be paid
+func (r *PenaltyTypeRegistry) IsOpen(companyCode, dunningStatus, accountStatus string) bool {
+	dunningStatus = strings.TrimSpace(dunningStatus)
+	for _, penaltyRefType := range r.refTypes {
+		details := r.details[penaltyRefType]
+		if details.CompanyCode == companyCode &&
+			contains(details.OpenDunningStatuses, dunningStatus) && contains(details.OpenAccountStatuses, accountStatus) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
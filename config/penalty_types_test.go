@@ -0,0 +1,225 @@
+package config
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitEmbeddedPenaltyTypes(t *testing.T) {
+	Convey("Given the penalty types built into the binary", t, func() {
+		registry, err := GetPenaltyTypes()
+		So(err, ShouldBeNil)
+
+		Convey("Then every penalty reference type is registered", func() {
+			So(registry.RefTypes(), ShouldResemble, []string{"LATE_FILING", "SANCTIONS", "SANCTIONS_ROE"})
+		})
+
+		Convey("Then late filing is the default penalty reference type", func() {
+			refType, err := registry.DefaultRefType()
+			So(err, ShouldBeNil)
+			So(refType, ShouldEqual, "LATE_FILING")
+		})
+
+		Convey("Then the company code and reference prefix of each penalty reference type is configured", func() {
+			for refType, want := range map[string][2]string{
+				"LATE_FILING":   {"LP", "A"},
+				"SANCTIONS":     {"C1", "P"},
+				"SANCTIONS_ROE": {"C1", "U"},
+			} {
+				companyCode, err := registry.CompanyCode(refType)
+				So(err, ShouldBeNil)
+				So(companyCode, ShouldEqual, want[0])
+
+				prefix, err := registry.ReferencePrefix(refType)
+				So(err, ShouldBeNil)
+				So(prefix, ShouldEqual, want[1])
+			}
+		})
+
+		Convey("Then the reason of each penalty is configured", func() {
+			So(registry.Reason("LP", "EJ"), ShouldEqual, "Late filing of accounts")
+			So(registry.Reason("C1", "S1"), ShouldEqual, "Failure to file a confirmation statement")
+			So(registry.Reason("C1", "S3"), ShouldEqual,
+				"Failure to file a confirmation statement and identity verification statements for all directors")
+			So(registry.Reason("C1", "A2"), ShouldEqual, "Failure to update the Register of Overseas Entities")
+			So(registry.Reason("C1", "S2"), ShouldBeEmpty)
+		})
+
+		Convey("Then the statuses a penalty can be paid with are configured", func() {
+			So(registry.IsOpen("LP", "PEN3        ", "WDR"), ShouldBeTrue)
+			So(registry.IsOpen("C1", "PEN2        ", "CHS"), ShouldBeTrue)
+			So(registry.IsOpen("C1", "PEN3        ", "CHS"), ShouldBeFalse)
+			So(registry.IsOpen("C1", "PEN1        ", "WDR"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestUnitNewPenaltyTypeRegistry(t *testing.T) {
+	newSanction := PenaltyDetails{
+		CompanyCode:         "C1",
+		ReferencePrefix:     "V",
+		ReferenceRegex:      "^V[0-9]{7}$",
+		OpenDunningStatuses: []string{"PEN1"},
+		OpenAccountStatuses: []string{"CHS"},
+		TransactionSubTypes: map[string]string{"S4": "Failure to file a new statement"},
+	}
+	lateFiling := PenaltyDetails{
+		CompanyCode:         "LP",
+		ReferencePrefix:     "A",
+		OpenDunningStatuses: []string{"PEN1"},
+		OpenAccountStatuses: []string{"CHS"},
+		Reason:              "Late filing of accounts",
+	}
+
+	Convey("Given a penalty type is onboarded in the penalty details", t, func() {
+		registry, err := NewPenaltyTypeRegistry(&PenaltyDetailsMap{Details: map[string]PenaltyDetails{
+			"LATE_FILING":  lateFiling,
+			"NEW_SANCTION": newSanction,
+		}})
+		So(err, ShouldBeNil)
+
+		Convey("Then its penalty references are resolved to it if they match its reference regex", func() {
+			refType, err := registry.RefTypeFromReference("V1234567")
+			So(err, ShouldBeNil)
+			So(refType, ShouldEqual, "NEW_SANCTION")
+
+			_, err = registry.RefTypeFromReference("V123")
+			So(err, ShouldNotBeNil)
+
+			_, err = registry.RefTypeFromReference("Q1234567")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then it is issued under its own subtypes only", func() {
+			So(registry.IssuedUnder("NEW_SANCTION", "S4"), ShouldBeTrue)
+			So(registry.IssuedUnder("NEW_SANCTION", "EJ"), ShouldBeFalse)
+			So(registry.IssuedUnder("LATE_FILING", "EJ"), ShouldBeTrue)
+			So(registry.IssuedUnder("LATE_FILING", "S4"), ShouldBeFalse)
+			So(registry.IssuedUnder("UNKNOWN", "EJ"), ShouldBeFalse)
+		})
+
+		Convey("Then its company code, reason and open statuses are used", func() {
+			So(registry.IsCompanyCode("C1"), ShouldBeTrue)
+			So(registry.IsCompanyCode("ZZ"), ShouldBeFalse)
+			So(registry.Reason("C1", "S4"), ShouldEqual, "Failure to file a new statement")
+			So(registry.IsOpen("C1", "PEN1", "CHS"), ShouldBeTrue)
+		})
+
+		Convey("Then an unknown penalty reference type is an error", func() {
+			_, err := registry.CompanyCode("UNKNOWN")
+			So(err, ShouldNotBeNil)
+			_, err = registry.ReferencePrefix("UNKNOWN")
+			So(err, ShouldNotBeNil)
+			_, err = registry.ReferenceRegex("UNKNOWN")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then there is no default penalty reference type unless one is configured", func() {
+			_, err := registry.DefaultRefType()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Then its reference regex is the one configured, or matches its reference prefix", func() {
+			referenceRegex, err := registry.ReferenceRegex("NEW_SANCTION")
+			So(err, ShouldBeNil)
+			So(referenceRegex, ShouldEqual, "^V[0-9]{7}$")
+
+			referenceRegex, err = registry.ReferenceRegex("LATE_FILING")
+			So(err, ShouldBeNil)
+			So(referenceRegex, ShouldEqual, "^A")
+		})
+	})
+
+	Convey("Given the penalty details are not valid", t, func() {
+		testCases := []struct {
+			name    string
+			details map[string]PenaltyDetails
+		}{
+			{name: "When a penalty type has no company code", details: map[string]PenaltyDetails{
+				"NEW_SANCTION": {ReferencePrefix: "V"},
+			}},
+			{name: "When a penalty type has no reference prefix", details: map[string]PenaltyDetails{
+				"NEW_SANCTION": {CompanyCode: "C1"},
+			}},
+			{name: "When a penalty type has an invalid reference regex", details: map[string]PenaltyDetails{
+				"NEW_SANCTION": {CompanyCode: "C1", ReferencePrefix: "V", ReferenceRegex: "["},
+			}},
+			{name: "When a penalty type has an invalid enabled window", details: map[string]PenaltyDetails{
+				"NEW_SANCTION": {CompanyCode: "C1", ReferencePrefix: "V", EnabledFrom: "2025-01-01"},
+			}},
+			{name: "When the reference prefixes of two penalty types clash", details: map[string]PenaltyDetails{
+				"LATE_FILING":  lateFiling,
+				"NEW_SANCTION": {CompanyCode: "C1", ReferencePrefix: "AB"},
+			}},
+		}
+
+		for _, tc := range testCases {
+			Convey(tc.name+" then an error is returned", func() {
+				registry, err := NewPenaltyTypeRegistry(&PenaltyDetailsMap{Details: tc.details})
+
+				So(err, ShouldNotBeNil)
+				So(registry, ShouldBeNil)
+			})
+		}
+
+		Convey("When the default penalty reference type is not a penalty type then an error is returned", func() {
+			registry, err := NewPenaltyTypeRegistry(&PenaltyDetailsMap{
+				DefaultRefType: "SANCTIONS",
+				Details:        map[string]PenaltyDetails{"LATE_FILING": lateFiling},
+			})
+
+			So(err, ShouldNotBeNil)
+			So(registry, ShouldBeNil)
+		})
+	})
+}
+
+func TestUnitGetPenaltyTypes(t *testing.T) {
+	Convey("Given no penalty types are registered", t, func() {
+		original, err := GetPenaltyTypes()
+		So(err, ShouldBeNil)
+		defer func() { penaltyTypes = original }()
+		penaltyTypes = nil
+
+		Convey("Then the penalty types built into the binary are loaded once", func() {
+			registry, err := GetPenaltyTypes()
+			So(err, ShouldBeNil)
+			So(registry.RefTypes(), ShouldResemble, []string{"LATE_FILING", "SANCTIONS", "SANCTIONS_ROE"})
+
+			again, err := GetPenaltyTypes()
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, registry)
+		})
+	})
+}
+
+func TestUnitRegisterPenaltyTypes(t *testing.T) {
+	Convey("Given penalty types are registered", t, func() {
+		original, err := GetPenaltyTypes()
+		So(err, ShouldBeNil)
+		defer func() { penaltyTypes = original }()
+
+		Convey("When the penalty details are valid then they replace the registered penalty types", func() {
+			err := RegisterPenaltyTypes(&PenaltyDetailsMap{Details: map[string]PenaltyDetails{
+				"NEW_SANCTION": {CompanyCode: "C1", ReferencePrefix: "V"},
+			}})
+
+			So(err, ShouldBeNil)
+			registry, err := GetPenaltyTypes()
+			So(err, ShouldBeNil)
+			So(registry.RefTypes(), ShouldResemble, []string{"NEW_SANCTION"})
+		})
+
+		Convey("When the penalty details are not valid then the registered penalty types are kept", func() {
+			err := RegisterPenaltyTypes(&PenaltyDetailsMap{Details: map[string]PenaltyDetails{
+				"NEW_SANCTION": {CompanyCode: "C1"},
+			}})
+
+			So(err, ShouldNotBeNil)
+			registry, err := GetPenaltyTypes()
+			So(err, ShouldBeNil)
+			So(registry, ShouldEqual, original)
+		})
+	})
+}
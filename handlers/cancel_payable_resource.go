@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api-core/constants"
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/services"
+	"github.com/companieshouse/penalty-payment-api/common/utils"
+	"github.com/companieshouse/penalty-payment-api/config"
+)
+
+// CancelPayableResourceHandler will cancel a payable resource that has not been paid, so that it can no longer be
+// paid. Cancelling a resource that has already been cancelled succeeds. A resource with a payment created in E5 is not
+// cancelled, so that the payment is not left open in E5.
+func CancelPayableResourceHandler(payableResourceService *services.PayableResourceService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := log.Context(r)
+		log.InfoC(requestId, "start DELETE payable resource request")
+
+		// get the payable resource out of the context. authorisation is already handled in the interceptor
+		resource, ok := r.Context().Value(config.PayableResource).(*models.PayableResource)
+		if !ok {
+			log.ErrorC(requestId, fmt.Errorf("no payable resource in context. check PayableAuthenticationInterceptor is installed"))
+			m := models.NewMessageResponse("no payable request present in request context")
+			utils.WriteJSONWithStatus(w, r, m, http.StatusBadRequest)
+			return
+		}
+		logContext := log.Data{"customer_code": resource.CustomerCode, "payable_ref": resource.PayableRef}
+		log.DebugC(requestId, "got payable resource from context", log.Data{"payable_resource": resource})
+
+		switch resource.Payment.Status {
+		case constants.Paid.String():
+			writeCannotCancelPaid(w, r, logContext)
+			return
+		case dao.PaymentStatusCancelled:
+			log.InfoC(requestId, "payable resource has already been cancelled, nothing to do", logContext)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case dao.PaymentStatusExpired:
+			writeNotPayable(w, r, resource, services.ErrPayableResourceExpired)
+			return
+		}
+
+		log.InfoC(requestId, "cancelling payable resource", logContext)
+		err := payableResourceService.Cancel(*resource, time.Now(), requestId)
+		switch {
+		case errors.Is(err, services.ErrAlreadyPaid):
+			// the resource was paid after it was read
+			writeCannotCancelPaid(w, r, logContext)
+			return
+		case errors.Is(err, services.ErrE5PaymentStarted):
+			log.ErrorC(requestId, err, logContext)
+			m := models.NewMessageResponse("the payable resource is being paid so cannot be cancelled")
+			utils.WriteJSONWithStatus(w, r, m, http.StatusConflict)
+			return
+		case errors.Is(err, services.ErrPayableResourceExpired):
+			// the resource expired after it was read
+			writeNotPayable(w, r, resource, err)
+			return
+		case err != nil:
+			log.ErrorC(requestId, err, logContext)
+			m := models.NewMessageResponse("there was a problem cancelling the payable resource")
+			utils.WriteJSONWithStatus(w, r, m, http.StatusInternalServerError)
+			return
+		}
+
+		log.InfoC(requestId, "DELETE payable resource request completed successfully", logContext)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// writeCannotCancelPaid responds that the payable resource cannot be cancelled as it has been paid
+func writeCannotCancelPaid(w http.ResponseWriter, r *http.Request, logContext log.Data) {
+	log.InfoC(log.Context(r), "payable resource has been paid so cannot be cancelled", logContext)
+	m := models.NewMessageResponse("the payable resource has already been paid so cannot be cancelled")
+	utils.WriteJSONWithStatus(w, r, m, http.StatusConflict)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api-core/constants"
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/services"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func serveCancelPayableResourceHandler(ctx context.Context, daoSvc dao.PayableResourceDaoService) (*httptest.ResponseRecorder, *models.ResponseResource) {
+	payableResourceService := &services.PayableResourceService{DAO: daoSvc}
+
+	req := httptest.NewRequest(http.MethodDelete, "/company/10000024/penalties/payable/123", nil).WithContext(ctx)
+	res := httptest.NewRecorder()
+	CancelPayableResourceHandler(payableResourceService).ServeHTTP(res, req)
+
+	var body *models.ResponseResource
+	_ = json.Unmarshal(res.Body.Bytes(), &body)
+	return res, body
+}
+
+func TestUnitCancelPayableResourceHandler(t *testing.T) {
+	Convey("CancelPayableResourceHandler tests", t, func() {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
+
+		model := buildMockedPayableResource(true, 150)
+		model.Payment.Status = constants.Pending.String()
+		withResource := func() context.Context {
+			return context.WithValue(context.Background(), config.PayableResource, model)
+		}
+
+		Convey("payable resource must be in context", func() {
+			res, body := serveCancelPayableResourceHandler(context.Background(), mockPrDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusBadRequest)
+			So(body.Message, ShouldEqual, "no payable request present in request context")
+		})
+
+		Convey("paid payable resource cannot be cancelled", func() {
+			model.Payment.Status = constants.Paid.String()
+
+			res, body := serveCancelPayableResourceHandler(withResource(), mockPrDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusConflict)
+			So(body.Message, ShouldEqual, "the payable resource has already been paid so cannot be cancelled")
+		})
+
+		Convey("cancelled payable resource is not cancelled again", func() {
+			model.Payment.Status = dao.PaymentStatusCancelled
+
+			res, _ := serveCancelPayableResourceHandler(withResource(), mockPrDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusNoContent)
+		})
+
+		Convey("expired payable resource cannot be cancelled", func() {
+			model.Payment.Status = dao.PaymentStatusExpired
+
+			res, body := serveCancelPayableResourceHandler(withResource(), mockPrDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusGone)
+			So(body.Message, ShouldEqual, "the payable resource has expired and can no longer be paid, create a new payable resource to pay the penalty")
+		})
+
+		noE5Progress := func() {
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(&e5.PaymentProgress{}, nil)
+		}
+
+		Convey("pending payable resource is cancelled", func() {
+			noE5Progress()
+			mockPrDaoSvc.EXPECT().CancelPayableResource("10000024", "123", gomock.Any(), "").Return(true, nil)
+
+			res, _ := serveCancelPayableResourceHandler(withResource(), mockPrDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusNoContent)
+		})
+
+		Convey("payable resource with a payment created in E5 cannot be cancelled", func() {
+			createdAt := time.Now()
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(&e5.PaymentProgress{CreatedAt: &createdAt}, nil)
+
+			res, body := serveCancelPayableResourceHandler(withResource(), mockPrDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusConflict)
+			So(body.Message, ShouldEqual, "the payable resource is being paid so cannot be cancelled")
+		})
+
+		Convey("payable resource paid since it was read cannot be cancelled", func() {
+			noE5Progress()
+			mockPrDaoSvc.EXPECT().CancelPayableResource("10000024", "123", gomock.Any(), "").Return(false, nil)
+			mockPrDaoSvc.EXPECT().GetPayableResource("10000024", "123", "").Return(&models.PayableResourceDao{
+				Data: models.PayableResourceDataDao{Payment: models.PaymentDao{Status: constants.Paid.String()}},
+			}, nil)
+
+			res, body := serveCancelPayableResourceHandler(withResource(), mockPrDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusConflict)
+			So(body.Message, ShouldEqual, "the payable resource has already been paid so cannot be cancelled")
+		})
+
+		Convey("error cancelling payable resource in db", func() {
+			noE5Progress()
+			mockPrDaoSvc.EXPECT().CancelPayableResource("10000024", "123", gomock.Any(), "").Return(false, errors.New("server selection timeout"))
+
+			res, body := serveCancelPayableResourceHandler(withResource(), mockPrDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusInternalServerError)
+			So(body.Message, ShouldEqual, "there was a problem cancelling the payable resource")
+		})
+	})
+}
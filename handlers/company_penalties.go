@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/services"
+	"github.com/companieshouse/penalty-payment-api/common/utils"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+	"github.com/gorilla/mux"
+)
+
+const companyPenaltiesDateLayout = "2006-01-02"
+
+var companyPenalties = api.CompanyPenalties
+
+// HandleGetCompanyPenalties streams the penalties of every customer within a company code that E5 reports as created
+// or updated in the requested date window. It is only available to internal API keys with elevated privileges.
+func HandleGetCompanyPenalties(e5Client e5.ClientInterface, allowedTransactionsMap *models.AllowedTransactionMap) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		requestId := log.Context(req)
+		log.InfoC(requestId, "start GET company penalties request")
+
+		penaltyTypes, err := config.GetPenaltyTypes()
+		if err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error getting penalty types: %v", err))
+			m := models.NewMessageResponse("there was a problem handling your request")
+			utils.WriteJSONWithStatus(w, req, m, http.StatusInternalServerError)
+			return
+		}
+
+		params, err := getCompanyPenaltiesParams(req, penaltyTypes)
+		if err != nil {
+			log.ErrorC(requestId, err)
+			m := models.NewMessageResponse(err.Error())
+			utils.WriteJSONWithStatus(w, req, m, http.StatusBadRequest)
+			return
+		}
+		params.Context = req.Context()
+		params.AllowedTransactionsMap = allowedTransactionsMap
+		params.RequestId = requestId
+
+		writer := &companyPenaltiesWriter{w: w}
+		responseType, err := companyPenalties(e5Client, params, writer.write)
+		if err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error getting company penalties: %v", err))
+			if writer.started {
+				// the status has already been sent, so the connection is aborted before the penalties array is
+				// ended, for the client to see that the response is incomplete rather than read it as every penalty
+				panic(http.ErrAbortHandler)
+			}
+			switch responseType {
+			case services.InvalidData:
+				m := models.NewMessageResponse("failed to read finance transactions")
+				utils.WriteJSONWithStatus(w, req, m, http.StatusBadRequest)
+			case services.Unavailable:
+				m := models.NewMessageResponse("the finance system is currently unavailable")
+				utils.WriteJSONWithStatus(w, req, m, http.StatusServiceUnavailable)
+			default:
+				m := models.NewMessageResponse("there was a problem communicating with the finance backend")
+				utils.WriteJSONWithStatus(w, req, m, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if err = writer.close(); err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error writing response: %v", err))
+			return
+		}
+
+		log.InfoC(requestId, "GET company penalties request completed successfully", log.Data{
+			"company_code":    params.CompanyCode,
+			"penalties_count": writer.count,
+		})
+	}
+}
+
+// getCompanyPenaltiesParams reads and validates the company code path variable and the date window and filter
+// query parameters
+func getCompanyPenaltiesParams(req *http.Request, penaltyTypes *config.PenaltyTypeRegistry) (types.CompanyPenaltiesParams, error) {
+	companyCode := strings.ToUpper(mux.Vars(req)["company_code"])
+	if !penaltyTypes.IsCompanyCode(companyCode) {
+		return types.CompanyPenaltiesParams{}, fmt.Errorf("invalid company code supplied")
+	}
+
+	query := req.URL.Query()
+	fromDate := query.Get("from_date")
+	from, err := time.Parse(companyPenaltiesDateLayout, fromDate)
+	if err != nil {
+		return types.CompanyPenaltiesParams{}, fmt.Errorf("from_date must be supplied in the format YYYY-MM-DD")
+	}
+
+	toDate := query.Get("to_date")
+	if toDate != "" {
+		to, err := time.Parse(companyPenaltiesDateLayout, toDate)
+		if err != nil {
+			return types.CompanyPenaltiesParams{}, fmt.Errorf("to_date must be in the format YYYY-MM-DD")
+		}
+		if to.Before(from) {
+			return types.CompanyPenaltiesParams{}, fmt.Errorf("to_date must not be before from_date")
+		}
+	}
+
+	transactionType := query.Get("transaction_type")
+	transactionSubType := query.Get("transaction_sub_type")
+	if transactionSubType != "" && transactionType == "" {
+		return types.CompanyPenaltiesParams{}, fmt.Errorf("transaction_type must be supplied with transaction_sub_type")
+	}
+
+	var isPaid *bool
+	if isPaidParam := query.Get("is_paid"); isPaidParam != "" {
+		paid, err := strconv.ParseBool(isPaidParam)
+		if err != nil {
+			return types.CompanyPenaltiesParams{}, fmt.Errorf("is_paid must be true or false")
+		}
+		isPaid = &paid
+	}
+
+	return types.CompanyPenaltiesParams{
+		CompanyCode:        companyCode,
+		FromDate:           fromDate,
+		ToDate:             toDate,
+		TransactionType:    transactionType,
+		TransactionSubType: transactionSubType,
+		IsPaid:             isPaid,
+	}, nil
+}
+
+// companyPenaltiesWriter writes the penalties as a JSON array as each page is read from E5, flushing after each page
+// so that the penalties of large company codes are not buffered before the response starts. The status is only sent
+// once the first page has been read, so a request that E5 fails from the start still gets an error status.
+type companyPenaltiesWriter struct {
+	w       http.ResponseWriter
+	encoder *json.Encoder
+	started bool
+	count   int
+}
+
+func (cw *companyPenaltiesWriter) start() error {
+	cw.w.Header().Set("Content-Type", "application/json")
+	cw.w.WriteHeader(http.StatusOK)
+	cw.encoder = json.NewEncoder(cw.w)
+	cw.started = true
+	_, err := cw.w.Write([]byte("["))
+	return err
+}
+
+func (cw *companyPenaltiesWriter) write(penalties []types.CompanyPenalty) error {
+	if !cw.started {
+		if err := cw.start(); err != nil {
+			return err
+		}
+	}
+	for _, penalty := range penalties {
+		if cw.count > 0 {
+			if _, err := cw.w.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		if err := cw.encoder.Encode(penalty); err != nil {
+			return err
+		}
+		cw.count++
+	}
+	if flusher, canFlush := cw.w.(http.Flusher); canFlush {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// close ends the penalties array, starting the response first if E5 returned no pages
+func (cw *companyPenaltiesWriter) close() error {
+	if !cw.started {
+		if err := cw.start(); err != nil {
+			return err
+		}
+	}
+	_, err := cw.w.Write([]byte("]"))
+	return err
+}
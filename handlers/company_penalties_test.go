@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/services"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func serveCompanyPenalties(companyCode, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/penalty-payment-api/admin/penalties/"+companyCode+query, nil)
+	req = mux.SetURLVars(req, map[string]string{"company_code": companyCode})
+	rr := httptest.NewRecorder()
+	HandleGetCompanyPenalties(nil, &models.AllowedTransactionMap{}).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestUnitHandleGetCompanyPenalties(t *testing.T) {
+	Convey("Given a request to get the penalties within a company code", t, func() {
+		var gotParams types.CompanyPenaltiesParams
+		companyPenalties = func(client e5.ClientInterface, params types.CompanyPenaltiesParams,
+			handlePenalties api.CompanyPenaltiesHandler) (services.ResponseType, error) {
+			gotParams = params
+			if err := handlePenalties([]types.CompanyPenalty{{CustomerCode: "10000024", TransactionReference: "A0000001", IsPaid: true}}); err != nil {
+				return services.Error, err
+			}
+			if err := handlePenalties([]types.CompanyPenalty{}); err != nil {
+				return services.Error, err
+			}
+			if err := handlePenalties([]types.CompanyPenalty{{CustomerCode: "10000025", TransactionReference: "A0000002"}}); err != nil {
+				return services.Error, err
+			}
+			return services.Success, nil
+		}
+
+		Convey("When the request is valid then the penalties of every page are returned", func() {
+			rr := serveCompanyPenalties("lp", "?from_date=2025-01-01&to_date=2025-01-02&is_paid=true")
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(rr.Flushed, ShouldBeTrue)
+			var body []types.CompanyPenalty
+			So(json.Unmarshal(rr.Body.Bytes(), &body), ShouldBeNil)
+			So(body, ShouldHaveLength, 2)
+			So(body[0].TransactionReference, ShouldEqual, "A0000001")
+			So(body[1].TransactionReference, ShouldEqual, "A0000002")
+			So(gotParams.CompanyCode, ShouldEqual, "LP")
+			So(gotParams.FromDate, ShouldEqual, "2025-01-01")
+			So(gotParams.ToDate, ShouldEqual, "2025-01-02")
+			So(*gotParams.IsPaid, ShouldBeTrue)
+		})
+
+		Convey("When E5 has no transactions then an empty array is returned", func() {
+			companyPenalties = func(client e5.ClientInterface, params types.CompanyPenaltiesParams,
+				handlePenalties api.CompanyPenaltiesHandler) (services.ResponseType, error) {
+				return services.Success, nil
+			}
+
+			rr := serveCompanyPenalties("LP", "?from_date=2025-01-01")
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(rr.Body.String(), ShouldEqual, "[]")
+		})
+
+		Convey("When E5 fails after the first page then the connection is aborted before the array is ended", func() {
+			companyPenalties = func(client e5.ClientInterface, params types.CompanyPenaltiesParams,
+				handlePenalties api.CompanyPenaltiesHandler) (services.ResponseType, error) {
+				if err := handlePenalties([]types.CompanyPenalty{{CustomerCode: "10000024", TransactionReference: "A0000001"}}); err != nil {
+					return services.Error, err
+				}
+				return services.Error, errors.New("error")
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/penalty-payment-api/admin/penalties/LP?from_date=2025-01-01", nil)
+			req = mux.SetURLVars(req, map[string]string{"company_code": "LP"})
+			rr := httptest.NewRecorder()
+
+			So(func() { HandleGetCompanyPenalties(nil, &models.AllowedTransactionMap{}).ServeHTTP(rr, req) },
+				ShouldPanicWith, http.ErrAbortHandler)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			var body []types.CompanyPenalty
+			So(json.Unmarshal(rr.Body.Bytes(), &body), ShouldNotBeNil)
+		})
+
+		testCases := []struct {
+			name        string
+			companyCode string
+			query       string
+		}{
+			{name: "unknown company code", companyCode: "XX", query: "?from_date=2025-01-01"},
+			{name: "missing from date", companyCode: "LP", query: ""},
+			{name: "invalid to date", companyCode: "LP", query: "?from_date=2025-01-01&to_date=02/01/2025"},
+			{name: "to date before from date", companyCode: "LP", query: "?from_date=2025-01-02&to_date=2025-01-01"},
+			{name: "subtype without type", companyCode: "C1", query: "?from_date=2025-01-01&transaction_sub_type=S1"},
+			{name: "invalid is paid", companyCode: "C1", query: "?from_date=2025-01-01&is_paid=maybe"},
+		}
+		for _, tc := range testCases {
+			Convey("When the request has an "+tc.name+" then bad request is returned", func() {
+				rr := serveCompanyPenalties(tc.companyCode, tc.query)
+
+				So(rr.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		}
+
+		Convey("When E5 rejects the request then bad request is returned", func() {
+			companyPenalties = func(client e5.ClientInterface, params types.CompanyPenaltiesParams,
+				handlePenalties api.CompanyPenaltiesHandler) (services.ResponseType, error) {
+				return services.InvalidData, errors.New("bad request")
+			}
+
+			rr := serveCompanyPenalties("LP", "?from_date=2025-01-01")
+
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When E5 fails then internal server error is returned", func() {
+			companyPenalties = func(client e5.ClientInterface, params types.CompanyPenaltiesParams,
+				handlePenalties api.CompanyPenaltiesHandler) (services.ResponseType, error) {
+				return services.Error, errors.New("error")
+			}
+
+			rr := serveCompanyPenalties("LP", "?from_date=2025-01-01")
+
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/companieshouse/chs.go/authentication"
@@ -20,9 +22,15 @@ import (
 
 var payablePenalty = api.PayablePenalty
 
+var (
+	errDuplicatePenalty  = errors.New("a penalty can only be paid once in a payable resource")
+	errMixedPenaltyTypes = errors.New("penalties of different types must be paid separately")
+)
+
 // CreatePayableResourceHandler takes a http requests and creates a new payable resource
 func CreatePayableResourceHandler(prDaoSvc dao.PayableResourceDaoService, apDaoSvc dao.AccountPenaltiesDaoService,
-	penaltyDetailsMap *config.PenaltyDetailsMap, allowedTransactionMap *models.AllowedTransactionMap) http.Handler {
+	penaltyDetailsMap *config.PenaltyDetailsMap, allowedTransactionMap *models.AllowedTransactionMap,
+	payableStatusProvider types.PayableStatusProvider) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestId := log.Context(r)
 		log.InfoC(requestId, "start POST payable resource request")
@@ -35,6 +43,22 @@ func CreatePayableResourceHandler(prDaoSvc dao.PayableResourceDaoService, apDaoS
 			return
 		}
 
+		penaltyTypes, err := config.GetPenaltyTypes()
+		if err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error getting penalty types: %v", err))
+			utils.WriteJSONWithStatus(w, r, models.NewMessageResponse("there was a problem handling your request"), http.StatusInternalServerError)
+			return
+		}
+
+		if err = checkPenaltiesCanBePaidTogether(request.Transactions, penaltyTypes); err != nil {
+			log.ErrorC(requestId, fmt.Errorf("invalid request: %v", err))
+			utils.WriteJSONWithStatus(w, r, models.NewMessageResponse(err.Error()), http.StatusBadRequest)
+			return
+		}
+		// the penalty type is taken from the first transaction, so a transaction with a penalty reference is put first.
+		// The types given in the request are not relied on until the transactions have been matched in E5.
+		orderPenaltyReferencesFirst(request.Transactions, penaltyTypes)
+
 		authUserDetails, companyCode, penaltyRefType, failedValidation := extractRequestData(w, r, request)
 		if failedValidation {
 			log.ErrorC(requestId, errors.New("error extracting request data"))
@@ -49,6 +73,7 @@ func CreatePayableResourceHandler(prDaoSvc dao.PayableResourceDaoService, apDaoS
 
 		// Create validation context
 		validationCtx := validationContext{
+			Context:                r.Context(),
 			PenaltyRefType:         penaltyRefType,
 			CustomerCode:           customerCode,
 			CompanyCode:            companyCode,
@@ -56,19 +81,28 @@ func CreatePayableResourceHandler(prDaoSvc dao.PayableResourceDaoService, apDaoS
 			AccountPenaltiesDao:    apDaoSvc,
 			PenaltyDetailsMap:      penaltyDetailsMap,
 			AllowedTransactionsMap: allowedTransactionMap,
+			PayableStatusProvider:  payableStatusProvider,
 		}
 
 		payablePenalties, err := validateTransactions(request.Transactions, validationCtx)
 		if err != nil {
-			log.ErrorC(requestId, errors.New("invalid request - failed matching against e5"))
+			log.ErrorC(requestId, fmt.Errorf("invalid request - failed matching against e5: %v", err))
 			utils.WriteJSONWithStatus(w, r, models.NewMessageResponse("one or more of the transactions you want to pay for do not exist or are not payable at this time"), http.StatusBadRequest)
 			return
 		}
 
+		// the penalties and costs are ordered and checked again with the types matched in E5
+		transformers.SortTransactions(payablePenalties)
+		if err = checkPenaltiesCanBePaidTogether(payablePenalties, penaltyTypes); err != nil {
+			log.ErrorC(requestId, fmt.Errorf("invalid request - matched transactions cannot be paid together: %v", err))
+			utils.WriteJSONWithStatus(w, r, models.NewMessageResponse(err.Error()), http.StatusBadRequest)
+			return
+		}
+
 		// Replace request transactions with payable penalties to include updated values in the request
 		request.Transactions = payablePenalties
 
-		err = utils.GetValidator().Validate(request)
+		err = validateRequest(request)
 
 		if err != nil {
 			log.ErrorC(requestId, errors.New("invalid request - failed validation"))
@@ -95,6 +129,67 @@ func CreatePayableResourceHandler(prDaoSvc dao.PayableResourceDaoService, apDaoS
 	})
 }
 
+// checkPenaltiesCanBePaidTogether returns an error if a penalty is included more than once, or if the penalties are of
+// different types, as they are paid to different company codes in E5. Costs paid together with a penalty are matched to
+// it in E5 so their references are not checked for a type.
+func checkPenaltiesCanBePaidTogether(transactions []models.TransactionItem, penaltyTypes *config.PenaltyTypeRegistry) error {
+	penaltyRefs := map[string]bool{}
+	var penaltyRefType string
+	for _, transaction := range transactions {
+		// a missing penalty ref is reported by the request validation
+		if transaction.PenaltyRef == "" {
+			continue
+		}
+		if penaltyRefs[transaction.PenaltyRef] {
+			return errDuplicatePenalty
+		}
+		penaltyRefs[transaction.PenaltyRef] = true
+
+		if transaction.Type == types.Other.String() {
+			continue
+		}
+		transactionPenaltyRefType, err := penaltyTypes.RefTypeFromReference(transaction.PenaltyRef)
+		if err != nil {
+			// an unknown penalty type is reported when the company code is resolved
+			continue
+		}
+		if penaltyRefType == "" {
+			penaltyRefType = transactionPenaltyRefType
+		} else if transactionPenaltyRefType != penaltyRefType {
+			return errMixedPenaltyTypes
+		}
+	}
+	return nil
+}
+
+// orderPenaltyReferencesFirst moves the transactions with a reference of a known penalty type before the others,
+// keeping their order otherwise
+func orderPenaltyReferencesFirst(transactions []models.TransactionItem, penaltyTypes *config.PenaltyTypeRegistry) {
+	isPenaltyReference := func(transaction models.TransactionItem) bool {
+		_, err := penaltyTypes.RefTypeFromReference(transaction.PenaltyRef)
+		return err == nil
+	}
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return isPenaltyReference(transactions[i]) && !isPenaltyReference(transactions[j])
+	})
+}
+
+// validateRequest validates the request with each of its transactions in turn, as the request model only allows one
+// transaction to be validated at a time
+func validateRequest(request models.PayableRequest) error {
+	if len(request.Transactions) == 0 {
+		return utils.GetValidator().Validate(request)
+	}
+	for _, transaction := range request.Transactions {
+		single := request
+		single.Transactions = []models.TransactionItem{transaction}
+		if err := utils.GetValidator().Validate(single); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // decodeRequest decodes the request body into PayableRequest struct
 func decodeRequest(r *http.Request) (models.PayableRequest, error) {
 	var request models.PayableRequest
@@ -133,6 +228,7 @@ func extractRequestData(w http.ResponseWriter, r *http.Request, request models.P
 
 // validationContext holds related config and context needed for transaction validation
 type validationContext struct {
+	Context                context.Context
 	PenaltyRefType         string
 	CustomerCode           string
 	CompanyCode            string
@@ -140,20 +236,25 @@ type validationContext struct {
 	AccountPenaltiesDao    dao.AccountPenaltiesDaoService
 	PenaltyDetailsMap      *config.PenaltyDetailsMap
 	AllowedTransactionsMap *models.AllowedTransactionMap
+	PayableStatusProvider  types.PayableStatusProvider
 }
 
-// validateTransactions ensures the transactions are valid payable penalties that exist in E5
+// validateTransactions ensures the transactions are valid payable penalties that exist in E5, and that a penalty is
+// paid together with its unpaid costs
 func validateTransactions(transactions []models.TransactionItem, validationCtx validationContext) ([]models.TransactionItem, error) {
 	var payablePenalties []models.TransactionItem
 	for _, transaction := range transactions {
 		params := types.PayablePenaltyParams{
+			Context:                    validationCtx.Context,
 			PenaltyRefType:             validationCtx.PenaltyRefType,
 			CustomerCode:               validationCtx.CustomerCode,
 			CompanyCode:                validationCtx.CompanyCode,
 			PenaltyDetailsMap:          validationCtx.PenaltyDetailsMap,
 			Transaction:                transaction,
+			Transactions:               transactions,
 			AllowedTransactionsMap:     validationCtx.AllowedTransactionsMap,
 			AccountPenaltiesDaoService: validationCtx.AccountPenaltiesDao,
+			PayableStatusProvider:      validationCtx.PayableStatusProvider,
 			RequestId:                  validationCtx.RequestID,
 		}
 		payablePenalty, err := payablePenalty(params)
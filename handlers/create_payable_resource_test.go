@@ -13,10 +13,10 @@ import (
 	"github.com/companieshouse/chs.go/authentication"
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api/common/dao"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	"github.com/golang/mock/gomock"
 	"github.com/jarcoal/httpmock"
 	"github.com/pkg/errors"
@@ -31,7 +31,7 @@ func createPayableResourceHandlerTestSetup(t *testing.T) (*gomock.Controller, *m
 	cfg, _ := config.Get()
 	cfg.E5APIURL = "https://e5"
 	cfg.E5Username = "SYSTEM"
-	url := "https://e5/arTransactions/10000024?ADV_userName=SYSTEM&companyCode=" + utils.LateFilingPenaltyCompanyCode + "&fromDate=1990-01-01"
+	url := "https://e5/arTransactions/10000024?ADV_userName=SYSTEM&companyCode=" + testutils.LateFilingPenaltyCompanyCode + "&fromDate=1990-01-01"
 
 	httpmock.Activate()
 	mockCtrl := gomock.NewController(t)
@@ -41,14 +41,15 @@ func createPayableResourceHandlerTestSetup(t *testing.T) (*gomock.Controller, *m
 	return mockCtrl, mockPrDaoSvc, mockApDaoSvc, url, nil
 }
 
-func serveCreatePayableResourceHandler(body []byte, payableResourceService dao.PayableResourceDaoService, apDaoSvc dao.AccountPenaltiesDaoService,
+func serveCreatePayableResourceHandler(t, body []byte, payableResourceService dao.PayableResourceDaoService, apDaoSvc dao.AccountPenaltiesDaoService,
 	withAuthUserDetails bool, customerCode string) *httptest.ResponseRecorder {
 	template := "/company/%s/penalties/payable"
 	path := fmt.Sprintf(template, customerCode)
 	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
 	res := httptest.NewRecorder()
 
-	handler := CreatePayableResourceHandler(payableResourceService, apDaoSvc, penaltyDetailsMap, allowedTransactionsMap)
+	handler := CreatePayableResourceHandler(payableResourceService, apDaoSvc, penaltyDetailsMap, allowedTransactionsMap,
+		newTestPayableStatusProvider(t))
 	handler.ServeHTTP(res, req.WithContext(testContext(withAuthUserDetails, customerCode)))
 
 	return res
@@ -202,6 +203,87 @@ var e5ResponseMultipleTx = `
   ]
 }
 `
+var e5ResponseInstalmentPlan = `
+{
+  "page": {
+    "size": 4,
+    "totalElements": 4,
+    "totalPages": 1,
+    "number": 0
+  },
+  "data": [
+    {
+      "companyCode": "LP",
+      "ledgerCode": "EW",
+      "customerCode": "10000024",
+      "transactionReference": "A3784631",
+      "transactionDate": "2025-05-02",
+      "madeUpDate": "2024-12-31",
+      "amount": 900,
+      "outstandingAmount": 0,
+      "isPaid": true,
+      "transactionType": "1",
+      "transactionSubType": "EU",
+      "typeDescription": "Penalty Ltd Wel & Eng <=1m     LTDWA    ",
+      "dueDate": "2025-05-02",
+      "accountStatus": "CHS",
+      "dunningStatus": "IPEN1       "
+    },
+    {
+      "companyCode": "LP",
+      "ledgerCode": "EW",
+      "customerCode": "10000024",
+      "transactionReference": "A3784631",
+      "transactionDate": "2025-07-23",
+      "madeUpDate": "2024-12-31",
+      "amount": 900,
+      "outstandingAmount": 0,
+      "isPaid": true,
+      "transactionType": "P",
+      "transactionSubType": "00",
+      "typeDescription": "Instalment Plan                         ",
+      "dueDate": "2025-07-23",
+      "accountStatus": "CHS",
+      "dunningStatus": "IPEN1       "
+    },
+    {
+      "companyCode": "LP",
+      "ledgerCode": "EW",
+      "customerCode": "10000024",
+      "transactionReference": "A3784631-001",
+      "transactionDate": "2025-07-23",
+      "madeUpDate": "2024-12-31",
+      "amount": 300,
+      "outstandingAmount": 0,
+      "isPaid": true,
+      "transactionType": "1",
+      "transactionSubType": "I1",
+      "typeDescription": "Instalment                              ",
+      "dueDate": "2025-08-26",
+      "accountStatus": "CHS",
+      "dunningStatus": "IPEN1       "
+    },
+    {
+      "companyCode": "LP",
+      "ledgerCode": "EW",
+      "customerCode": "10000024",
+      "transactionReference": "A3784631-002",
+      "transactionDate": "2025-07-23",
+      "madeUpDate": "2024-12-31",
+      "amount": 300,
+      "outstandingAmount": 300,
+      "isPaid": false,
+      "transactionType": "1",
+      "transactionSubType": "I1",
+      "typeDescription": "Instalment                              ",
+      "dueDate": "2025-09-26",
+      "accountStatus": "CHS",
+      "dunningStatus": "IPEN1       "
+    }
+  ]
+}
+`
+
 var customerCode = "10000024"
 var penaltyRef1 = "A1234567"
 var penaltyRef2 = "A0378421"
@@ -244,7 +326,7 @@ func TestUnitCreatePayableResourceHandler(t *testing.T) {
 		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, e5ResponseLateFiling))
 
 		body := buildRequestBody("", true, false, []string{})
-		res := serveCreatePayableResourceHandler(body, mocks.NewMockPayableResourceDaoService(mockCtrl),
+		res := serveCreatePayableResourceHandler(t, body, mocks.NewMockPayableResourceDaoService(mockCtrl),
 			mocks.NewMockAccountPenaltiesDaoService(mockCtrl), true, customerCode)
 
 		So(res.Code, ShouldEqual, http.StatusBadRequest)
@@ -254,7 +336,7 @@ func TestUnitCreatePayableResourceHandler(t *testing.T) {
 		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, e5ResponseLateFiling))
 
 		body := buildRequestBody("", false, true, []string{})
-		res := serveCreatePayableResourceHandler(body, mocks.NewMockPayableResourceDaoService(mockCtrl),
+		res := serveCreatePayableResourceHandler(t, body, mocks.NewMockPayableResourceDaoService(mockCtrl),
 			mocks.NewMockAccountPenaltiesDaoService(mockCtrl), false, customerCode)
 
 		So(res.Code, ShouldEqual, http.StatusBadRequest)
@@ -264,7 +346,7 @@ func TestUnitCreatePayableResourceHandler(t *testing.T) {
 		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, e5ResponseLateFiling))
 
 		body := buildRequestBody("", false, true, []string{})
-		res := serveCreatePayableResourceHandler(body, mocks.NewMockPayableResourceDaoService(mockCtrl),
+		res := serveCreatePayableResourceHandler(t, body, mocks.NewMockPayableResourceDaoService(mockCtrl),
 			mocks.NewMockAccountPenaltiesDaoService(mockCtrl), true, "")
 
 		So(res.Code, ShouldEqual, http.StatusBadRequest)
@@ -278,52 +360,84 @@ func TestUnitCreatePayableResourceHandler(t *testing.T) {
 		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, e5ResponseLateFiling))
 
 		body := buildRequestBody("", false, true, []string{})
-		res := serveCreatePayableResourceHandler(body, mocks.NewMockPayableResourceDaoService(mockCtrl),
+		res := serveCreatePayableResourceHandler(t, body, mocks.NewMockPayableResourceDaoService(mockCtrl),
 			mocks.NewMockAccountPenaltiesDaoService(mockCtrl), true, "")
 
 		So(res.Code, ShouldEqual, http.StatusBadRequest)
 	})
 
 	Convey("Must need at least one transaction", t, func() {
-		setGetCompanyCodeFromTransactionMock(utils.LateFilingPenaltyCompanyCode)
+		setGetCompanyCodeFromTransactionMock(testutils.LateFilingPenaltyCompanyCode)
 
 		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, e5ResponseLateFiling))
 
 		body := buildRequestBody(customerCode, false, false, []string{})
-		res := serveCreatePayableResourceHandler(body, mocks.NewMockPayableResourceDaoService(mockCtrl),
+		res := serveCreatePayableResourceHandler(t, body, mocks.NewMockPayableResourceDaoService(mockCtrl),
 			mocks.NewMockAccountPenaltiesDaoService(mockCtrl), true, customerCode)
 
 		So(res.Code, ShouldEqual, http.StatusBadRequest)
 	})
 
-	Convey("Only allowed 1 transaction in a resource", t, func() {
-		setGetCompanyCodeFromTransactionMock(utils.LateFilingPenaltyCompanyCode)
+	Convey("Several penalties can be paid in one resource", t, func() {
+		setGetCompanyCodeFromTransactionMock(testutils.LateFilingPenaltyCompanyCode)
 
 		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, e5ResponseMultipleTx))
 
 		// as there are two transaction, the Times is 2 here, possible enhancement to remove this duplicate call
-		mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, utils.LateFilingPenaltyCompanyCode, "").Return(nil, nil).Times(2)
+		mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, testutils.LateFilingPenaltyCompanyCode, "").Return(nil, nil).Times(2)
 		mockApDaoSvc.EXPECT().CreateAccountPenalties(gomock.Any(), "").Return(nil).Times(2)
 
+		var created *models.PayableResourceDao
+		mockPrDaoSvc.EXPECT().CreatePayableResource(gomock.Any(), "").DoAndReturn(
+			func(dao *models.PayableResourceDao, _ string) error {
+				created = dao
+				return nil
+			})
+
 		body := buildRequestBody(customerCode, false, false, []string{penaltyRef1, penaltyRef2})
 
-		res := serveCreatePayableResourceHandler(body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
+		res := serveCreatePayableResourceHandler(t, body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
+
+		So(res.Code, ShouldEqual, http.StatusCreated)
+		So(created.Data.Transactions, ShouldHaveLength, 2)
+		So(created.Data.Transactions, ShouldContainKey, penaltyRef1)
+		So(created.Data.Transactions, ShouldContainKey, penaltyRef2)
+	})
+
+	Convey("A penalty cannot be included twice in a resource", t, func() {
+		setGetCompanyCodeFromTransactionMock(testutils.LateFilingPenaltyCompanyCode)
+
+		body := buildRequestBody(customerCode, false, false, []string{penaltyRef1, penaltyRef1})
+
+		res := serveCreatePayableResourceHandler(t, body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
 
 		So(res.Code, ShouldEqual, http.StatusBadRequest)
+		So(res.Body.String(), ShouldContainSubstring, errDuplicatePenalty.Error())
+	})
+
+	Convey("Penalties of different types cannot be paid together", t, func() {
+		setGetCompanyCodeFromTransactionMock(testutils.LateFilingPenaltyCompanyCode)
+
+		body := buildRequestBody(customerCode, false, false, []string{penaltyRef1, "P1234567"})
+
+		res := serveCreatePayableResourceHandler(t, body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
+
+		So(res.Code, ShouldEqual, http.StatusBadRequest)
+		So(res.Body.String(), ShouldContainSubstring, errMixedPenaltyTypes.Error())
 	})
 
 	Convey("internal server error when failing to create payable resource", t, func() {
-		setGetCompanyCodeFromTransactionMock(utils.LateFilingPenaltyCompanyCode)
+		setGetCompanyCodeFromTransactionMock(testutils.LateFilingPenaltyCompanyCode)
 
 		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, e5ResponseLateFiling))
 
 		mockPrDaoSvc.EXPECT().CreatePayableResource(gomock.Any(), "").Return(errors.New("any error"))
-		mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, utils.LateFilingPenaltyCompanyCode, "").Return(nil, nil)
+		mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, testutils.LateFilingPenaltyCompanyCode, "").Return(nil, nil)
 		mockApDaoSvc.EXPECT().CreateAccountPenalties(gomock.Any(), "").Return(nil)
 
 		body := buildRequestBody(customerCode, false, false, []string{penaltyRef1})
 
-		res := serveCreatePayableResourceHandler(body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
+		res := serveCreatePayableResourceHandler(t, body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
 
 		So(res.Code, ShouldEqual, http.StatusInternalServerError)
 	})
@@ -338,26 +452,26 @@ func TestUnitCreatePayableResourceHandler(t *testing.T) {
 		}{
 			{
 				name:        "Late Filing",
-				companyCode: utils.LateFilingPenaltyCompanyCode,
+				companyCode: testutils.LateFilingPenaltyCompanyCode,
 				penaltyRef:  "A1234567",
 				urlE5: "https://e5/arTransactions/10000024?ADV_userName=SYSTEM&companyCode=" +
-					utils.LateFilingPenaltyCompanyCode + "&fromDate=1990-01-01",
+					testutils.LateFilingPenaltyCompanyCode + "&fromDate=1990-01-01",
 				e5Response: e5ResponseLateFiling,
 			},
 			{
 				name:        "Sanctions",
-				companyCode: utils.SanctionsCompanyCode,
+				companyCode: testutils.SanctionsCompanyCode,
 				penaltyRef:  "P1234567",
 				urlE5: "https://e5/arTransactions/10000024?ADV_userName=SYSTEM&companyCode=" +
-					utils.SanctionsCompanyCode + "&fromDate=1990-01-01",
+					testutils.SanctionsCompanyCode + "&fromDate=1990-01-01",
 				e5Response: e5ResponseSanctions,
 			},
 			{
 				name:        "Sanctions ROE",
-				companyCode: utils.SanctionsCompanyCode,
+				companyCode: testutils.SanctionsCompanyCode,
 				penaltyRef:  "U1234567",
 				urlE5: "https://e5/arTransactions/10000024?ADV_userName=SYSTEM&companyCode=" +
-					utils.SanctionsCompanyCode + "&fromDate=1990-01-01",
+					testutils.SanctionsCompanyCode + "&fromDate=1990-01-01",
 				e5Response: e5ResponseSanctionsRoe,
 			},
 		}
@@ -374,13 +488,54 @@ func TestUnitCreatePayableResourceHandler(t *testing.T) {
 
 				body := buildRequestBody(customerCode, false, false, []string{tc.penaltyRef})
 
-				res := serveCreatePayableResourceHandler(body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
+				res := serveCreatePayableResourceHandler(t, body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
 
 				So(res.Code, ShouldEqual, http.StatusCreated)
 				So(res.Header().Get("Content-Type"), ShouldEqual, "application/json")
 			})
 		}
 	})
+
+	instalment := models.TransactionItem{PenaltyRef: "A3784631-002", Amount: 300, MadeUpDate: "2024-12-31", Type: "other"}
+	body, _ := json.Marshal(models.PayableRequest{
+		CustomerCode: customerCode,
+		Transactions: []models.TransactionItem{instalment},
+	})
+
+	Convey("The next instalment due of an instalment plan can be paid on its own", t, func() {
+		setGetCompanyCodeFromTransactionMock(testutils.LateFilingPenaltyCompanyCode)
+
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, e5ResponseInstalmentPlan))
+
+		mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, testutils.LateFilingPenaltyCompanyCode, "").Return(nil, nil)
+		mockApDaoSvc.EXPECT().CreateAccountPenalties(gomock.Any(), "").Return(nil)
+		var created *models.PayableResourceDao
+		mockPrDaoSvc.EXPECT().CreatePayableResource(gomock.Any(), "").DoAndReturn(
+			func(dao *models.PayableResourceDao, _ string) error {
+				created = dao
+				return nil
+			})
+
+		res := serveCreatePayableResourceHandler(t, body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
+
+		So(res.Code, ShouldEqual, http.StatusCreated)
+		So(created.Data.Transactions, ShouldHaveLength, 1)
+		So(created.Data.Transactions["A3784631-002"].Amount, ShouldEqual, 300)
+		So(created.Data.Transactions["A3784631-002"].Type, ShouldEqual, "other")
+	})
+
+	Convey("An instalment cannot be paid when the instalment transaction type and subtype are not configured", t, func() {
+		setGetCompanyCodeFromTransactionMock(testutils.LateFilingPenaltyCompanyCode)
+
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, e5ResponseInstalmentPlan))
+
+		mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, testutils.LateFilingPenaltyCompanyCode, "").Return(nil, nil)
+		mockApDaoSvc.EXPECT().CreateAccountPenalties(gomock.Any(), "").Return(nil)
+
+		res := serveCreatePayableResourceHandler(t, body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
+
+		So(res.Code, ShouldEqual, http.StatusBadRequest)
+	})
 }
 
 func TestUnitCreatePayableResourceHandler_MockedPayablePenalty(t *testing.T) {
@@ -393,7 +548,7 @@ func TestUnitCreatePayableResourceHandler_MockedPayablePenalty(t *testing.T) {
 	defer mockCtrl.Finish()
 
 	Convey("Error getting account penalties", t, func() {
-		setGetCompanyCodeFromTransactionMock(utils.LateFilingPenaltyCompanyCode)
+		setGetCompanyCodeFromTransactionMock(testutils.LateFilingPenaltyCompanyCode)
 
 		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(200, e5ResponseMultipleTx))
 
@@ -403,10 +558,70 @@ func TestUnitCreatePayableResourceHandler_MockedPayablePenalty(t *testing.T) {
 
 		body := buildRequestBody(customerCode, false, false, []string{penaltyRef1, penaltyRef2})
 
-		res := serveCreatePayableResourceHandler(body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
+		res := serveCreatePayableResourceHandler(t, body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
 
 		So(res.Code, ShouldEqual, http.StatusBadRequest)
 	})
+
+	Convey("A penalty is validated together with the costs paid with it", t, func() {
+		setGetCompanyCodeFromTransactionMock(testutils.LateFilingPenaltyCompanyCode)
+
+		var validated []types.PayablePenaltyParams
+		payablePenalty = func(params types.PayablePenaltyParams) (*models.TransactionItem, error) {
+			validated = append(validated, params)
+			matched := params.Transaction
+			return &matched, nil
+		}
+		mockPrDaoSvc.EXPECT().CreatePayableResource(gomock.Any(), "").Return(nil)
+
+		cost := models.TransactionItem{PenaltyRef: "FC1", Amount: 80, MadeUpDate: "2017-02-28", Type: "other"}
+		penalty := models.TransactionItem{PenaltyRef: penaltyRef1, Amount: 150, MadeUpDate: "2017-02-28", Type: "penalty"}
+		body, _ := json.Marshal(models.PayableRequest{
+			CustomerCode: customerCode,
+			Transactions: []models.TransactionItem{cost, penalty},
+		})
+
+		res := serveCreatePayableResourceHandler(t, body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
+
+		So(res.Code, ShouldEqual, http.StatusCreated)
+		So(validated, ShouldHaveLength, 2)
+		So(validated[0].Transaction.PenaltyRef, ShouldEqual, penaltyRef1)
+		So(validated[1].Transaction.PenaltyRef, ShouldEqual, "FC1")
+		So(validated[0].Transactions, ShouldResemble, []models.TransactionItem{penalty, cost})
+	})
+
+	Convey("The payable resource is ordered by the types matched in E5 rather than those in the request", t, func() {
+		setGetCompanyCodeFromTransactionMock(testutils.LateFilingPenaltyCompanyCode)
+
+		payablePenalty = func(params types.PayablePenaltyParams) (*models.TransactionItem, error) {
+			matched := params.Transaction
+			matched.Type = "penalty"
+			if matched.PenaltyRef == "FC1" {
+				matched.Type = "other"
+			}
+			return &matched, nil
+		}
+		var created *models.PayableResourceDao
+		mockPrDaoSvc.EXPECT().CreatePayableResource(gomock.Any(), "").DoAndReturn(func(model *models.PayableResourceDao, _ string) error {
+			created = model
+			return nil
+		})
+
+		// the cost is given as a penalty and the penalty as a cost
+		cost := models.TransactionItem{PenaltyRef: "FC1", Amount: 80, MadeUpDate: "2017-02-28", Type: "penalty"}
+		penalty := models.TransactionItem{PenaltyRef: penaltyRef1, Amount: 150, MadeUpDate: "2017-02-28", Type: "other"}
+		body, _ := json.Marshal(models.PayableRequest{
+			CustomerCode: customerCode,
+			Transactions: []models.TransactionItem{cost, penalty},
+		})
+
+		res := serveCreatePayableResourceHandler(t, body, mockPrDaoSvc, mockApDaoSvc, true, customerCode)
+
+		So(res.Code, ShouldEqual, http.StatusCreated)
+		So(created.Data.Transactions[penaltyRef1].Type, ShouldEqual, "penalty")
+		So(created.Data.Transactions["FC1"].Type, ShouldEqual, "other")
+		So(created.Data.Links.ResumeJourney, ShouldContainSubstring, "/penalty/"+penaltyRef1+"/")
+	})
 }
 
 func setGetCompanyCodeFromTransactionMock(companyCode string) {
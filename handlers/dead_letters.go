@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/deadletter"
+	"github.com/companieshouse/penalty-payment-api/common/services"
+	"github.com/companieshouse/penalty-payment-api/common/utils"
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/service"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultDeadLettersLimit = 50
+	maxDeadLettersLimit     = 500
+)
+
+var replayDeadLetter = service.ReplayDeadLetter
+
+// HandleGetDeadLetters lists the most recently dead-lettered penalty payment messages, optionally filtered by status.
+// It is only available to internal API keys with elevated privileges.
+func HandleGetDeadLetters(dlDaoSvc dao.DeadLetterDaoService) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		requestId := log.Context(req)
+		log.InfoC(requestId, "start GET dead letters request")
+
+		query := req.URL.Query()
+		status := deadletter.Status(query.Get("status"))
+		if status != "" && status != deadletter.Quarantined && status != deadletter.Replayed {
+			m := models.NewMessageResponse("status must be quarantined or replayed")
+			utils.WriteJSONWithStatus(w, req, m, http.StatusBadRequest)
+			return
+		}
+
+		limit := defaultDeadLettersLimit
+		if limitParam := query.Get("limit"); limitParam != "" {
+			var err error
+			limit, err = strconv.Atoi(limitParam)
+			if err != nil || limit < 1 || limit > maxDeadLettersLimit {
+				m := models.NewMessageResponse(fmt.Sprintf("limit must be between 1 and %d", maxDeadLettersLimit))
+				utils.WriteJSONWithStatus(w, req, m, http.StatusBadRequest)
+				return
+			}
+		}
+
+		messages, err := dlDaoSvc.GetDeadLetters(status, limit, requestId)
+		if err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error getting dead letters: %v", err))
+			m := models.NewMessageResponse("there was a problem handling your request")
+			utils.WriteJSONWithStatus(w, req, m, http.StatusInternalServerError)
+			return
+		}
+
+		utils.WriteJSON(w, req, messages)
+
+		log.InfoC(requestId, "GET dead letters request completed successfully", log.Data{
+			"status":             status,
+			"limit":              limit,
+			"dead_letters_count": len(messages),
+		})
+	}
+}
+
+// HandleGetDeadLetter returns a dead-lettered penalty payment message with its original bytes, error and headers
+func HandleGetDeadLetter(dlDaoSvc dao.DeadLetterDaoService) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		requestId := log.Context(req)
+		id := mux.Vars(req)["dead_letter_id"]
+		log.InfoC(requestId, "start GET dead letter request", log.Data{"dead_letter_id": id})
+
+		message, err := dlDaoSvc.GetDeadLetter(id, requestId)
+		if err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error getting dead letter: %v", err), log.Data{"dead_letter_id": id})
+			m := models.NewMessageResponse("there was a problem handling your request")
+			utils.WriteJSONWithStatus(w, req, m, http.StatusInternalServerError)
+			return
+		}
+		if message == nil {
+			m := models.NewMessageResponse("dead letter not found")
+			utils.WriteJSONWithStatus(w, req, m, http.StatusNotFound)
+			return
+		}
+
+		utils.WriteJSON(w, req, message)
+	}
+}
+
+// HandleReplayDeadLetter publishes a dead-lettered penalty payment message to the penalty payments processing topic
+// again, once whatever stopped it being processed has been fixed
+func HandleReplayDeadLetter(dlDaoSvc dao.DeadLetterDaoService) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		requestId := log.Context(req)
+		id := mux.Vars(req)["dead_letter_id"]
+		log.InfoC(requestId, "start POST replay dead letter request", log.Data{"dead_letter_id": id})
+
+		message, responseType, err := replayDeadLetter(dlDaoSvc, id, requestId)
+		switch responseType {
+		case services.Success:
+			utils.WriteJSON(w, req, message)
+			log.InfoC(requestId, "POST replay dead letter request completed successfully", log.Data{"dead_letter_id": id})
+		case services.NotFound:
+			m := models.NewMessageResponse("dead letter not found")
+			utils.WriteJSONWithStatus(w, req, m, http.StatusNotFound)
+		default:
+			log.ErrorC(requestId, fmt.Errorf("error replaying dead letter: %v", err), log.Data{"dead_letter_id": id})
+			m := models.NewMessageResponse("there was a problem handling your request")
+			utils.WriteJSONWithStatus(w, req, m, http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/deadletter"
+	"github.com/companieshouse/penalty-payment-api/common/services"
+	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const deadLetterID = "penalty-payments-processing-0-42"
+
+func serveDeadLetter(handler http.HandlerFunc, method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req = mux.SetURLVars(req, map[string]string{"dead_letter_id": deadLetterID})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestUnitHandleGetDeadLetters(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given a request to list dead letters", t, func() {
+		mockDlDaoSvc := mocks.NewMockDeadLetterDaoService(mockCtrl)
+
+		Convey("When the request is valid then the dead letters are returned", func() {
+			mockDlDaoSvc.EXPECT().GetDeadLetters(deadletter.Quarantined, 10, gomock.Any()).
+				Return([]deadletter.Message{{ID: deadLetterID, Value: []byte{0x01}}}, nil)
+
+			rr := serveDeadLetter(HandleGetDeadLetters(mockDlDaoSvc), http.MethodGet, "/penalty-payment-api/admin/dead-letters?status=quarantined&limit=10")
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			var body []deadletter.Message
+			So(json.Unmarshal(rr.Body.Bytes(), &body), ShouldBeNil)
+			So(body, ShouldHaveLength, 1)
+			So(body[0].Value, ShouldResemble, []byte{0x01})
+		})
+
+		Convey("When no limit is given then the default limit is used", func() {
+			mockDlDaoSvc.EXPECT().GetDeadLetters(deadletter.Status(""), defaultDeadLettersLimit, gomock.Any()).Return([]deadletter.Message{}, nil)
+
+			rr := serveDeadLetter(HandleGetDeadLetters(mockDlDaoSvc), http.MethodGet, "/penalty-payment-api/admin/dead-letters")
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("When the status is unknown then bad request is returned", func() {
+			rr := serveDeadLetter(HandleGetDeadLetters(mockDlDaoSvc), http.MethodGet, "/penalty-payment-api/admin/dead-letters?status=deleted")
+
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When the limit is invalid then bad request is returned", func() {
+			rr := serveDeadLetter(HandleGetDeadLetters(mockDlDaoSvc), http.MethodGet, "/penalty-payment-api/admin/dead-letters?limit=1000")
+
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When the dead letters cannot be read then internal server error is returned", func() {
+			mockDlDaoSvc.EXPECT().GetDeadLetters(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("server selection timeout"))
+
+			rr := serveDeadLetter(HandleGetDeadLetters(mockDlDaoSvc), http.MethodGet, "/penalty-payment-api/admin/dead-letters")
+
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+}
+
+func TestUnitHandleGetDeadLetter(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given a request to inspect a dead letter", t, func() {
+		mockDlDaoSvc := mocks.NewMockDeadLetterDaoService(mockCtrl)
+		path := "/penalty-payment-api/admin/dead-letters/" + deadLetterID
+
+		Convey("When it exists then it is returned with its error and headers", func() {
+			mockDlDaoSvc.EXPECT().GetDeadLetter(deadLetterID, gomock.Any()).Return(&deadletter.Message{
+				ID:      deadLetterID,
+				Error:   "End of file reached",
+				Headers: map[string]string{"trace_id": "abc"},
+			}, nil)
+
+			rr := serveDeadLetter(HandleGetDeadLetter(mockDlDaoSvc), http.MethodGet, path)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			var body deadletter.Message
+			So(json.Unmarshal(rr.Body.Bytes(), &body), ShouldBeNil)
+			So(body.Error, ShouldEqual, "End of file reached")
+			So(body.Headers["trace_id"], ShouldEqual, "abc")
+		})
+
+		Convey("When it does not exist then not found is returned", func() {
+			mockDlDaoSvc.EXPECT().GetDeadLetter(deadLetterID, gomock.Any()).Return(nil, nil)
+
+			rr := serveDeadLetter(HandleGetDeadLetter(mockDlDaoSvc), http.MethodGet, path)
+
+			So(rr.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When it cannot be read then internal server error is returned", func() {
+			mockDlDaoSvc.EXPECT().GetDeadLetter(deadLetterID, gomock.Any()).Return(nil, errors.New("server selection timeout"))
+
+			rr := serveDeadLetter(HandleGetDeadLetter(mockDlDaoSvc), http.MethodGet, path)
+
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+}
+
+func TestUnitHandleReplayDeadLetter(t *testing.T) {
+	Convey("Given a request to replay a dead letter", t, func() {
+		path := "/penalty-payment-api/admin/dead-letters/" + deadLetterID + "/replay"
+		testCases := []struct {
+			name         string
+			responseType services.ResponseType
+			err          error
+			status       int
+		}{
+			{name: "is replayed", responseType: services.Success, status: http.StatusOK},
+			{name: "does not exist", responseType: services.NotFound, status: http.StatusNotFound},
+			{name: "cannot be published", responseType: services.Error, err: errors.New("out of brokers"), status: http.StatusInternalServerError},
+		}
+		for _, tc := range testCases {
+			Convey("When it "+tc.name, func() {
+				replayDeadLetter = func(dlDaoSvc dao.DeadLetterDaoService, id, requestId string) (*deadletter.Message, services.ResponseType, error) {
+					return &deadletter.Message{ID: id}, tc.responseType, tc.err
+				}
+
+				rr := serveDeadLetter(HandleReplayDeadLetter(nil), http.MethodPost, path)
+
+				So(rr.Code, ShouldEqual, tc.status)
+			})
+		}
+	})
+}
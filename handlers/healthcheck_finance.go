@@ -10,7 +10,10 @@ import (
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
 )
 
-// HandleHealthCheckFinanceSystem checks whether the e5 system is available to take requests
+var checkFinanceSystemCircuit = api.CheckFinanceSystemCircuit
+
+// HandleHealthCheckFinanceSystem checks whether the e5 system is available to take requests, either because of
+// scheduled maintenance or because requests to E5 are failing fast after repeated failures
 func HandleHealthCheckFinanceSystem(w http.ResponseWriter, r *http.Request) {
 	requestId := log.Context(r)
 
@@ -30,6 +33,14 @@ func HandleHealthCheckFinanceSystem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	systemAvailableTime, systemUnavailable = checkFinanceSystemCircuit()
+	if systemUnavailable {
+		m := models.NewMessageTimeResponse("UNHEALTHY - FINANCE SYSTEM UNAVAILABLE", systemAvailableTime)
+		utils.WriteJSONWithStatus(w, r, m, http.StatusServiceUnavailable)
+		log.InfoC(requestId, "E5 circuit breaker is open", log.Data{"retry_at": systemAvailableTime})
+		return
+	}
+
 	m := models.NewMessageResponse("HEALTHY")
 	utils.WriteJSON(w, r, m)
 }
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -105,3 +106,30 @@ func healthCheckFinanceTestConfigSetup(cfg *config.Config, now time.Time, weekly
 		cfg.PlannedMaintenanceEnd = "invalid"
 	}
 }
+
+func TestUnitHandleHealthCheckFinance_CircuitOpen(t *testing.T) {
+	Convey("Given requests to E5 are failing fast after repeated failures", t, func() {
+		cfg, _ := config.Get()
+		cfg.WeeklyMaintenanceStartTime = ""
+		cfg.WeeklyMaintenanceEndTime = ""
+		cfg.PlannedMaintenanceStart = ""
+		cfg.PlannedMaintenanceEnd = ""
+
+		retryAt := time.Now().Add(time.Minute)
+		checkFinanceSystemCircuit = func() (time.Time, bool) {
+			return retryAt, true
+		}
+		defer func() { checkFinanceSystemCircuit = api.CheckFinanceSystemCircuit }()
+
+		Convey("When I make a request to the healthcheck_finance endpoint", func() {
+			req, _ := http.NewRequest("GET", "/penalty-payment-api/healthcheck/finance-system", nil)
+			w := httptest.NewRecorder()
+			HandleHealthCheckFinanceSystem(w, req)
+
+			Convey("Then the finance system is unhealthy with the estimated recovery time", func() {
+				So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(w.Body.String(), ShouldStartWith, `{"message":"UNHEALTHY - FINANCE SYSTEM UNAVAILABLE","maintenance_end_time":`)
+			})
+		})
+	})
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api/common/kafka"
+	"github.com/companieshouse/penalty-payment-api/common/utils"
+)
+
+var getProducerHealth = kafka.GetProducerManager().Health
+
+// kafkaHealthResponse is the state of each of the shared Kafka producers
+type kafkaHealthResponse struct {
+	Message   string                 `json:"message"`
+	Producers []kafka.ProducerHealth `json:"producers"`
+}
+
+// HandleHealthCheckKafka checks whether the shared Kafka producers are connected to their brokers, so that emails and
+// penalty payment messages can be published
+func HandleHealthCheckKafka(w http.ResponseWriter, r *http.Request) {
+	requestId := log.Context(r)
+
+	producers := getProducerHealth()
+	for _, producer := range producers {
+		if !producer.Healthy {
+			m := kafkaHealthResponse{Message: "UNHEALTHY - KAFKA UNAVAILABLE", Producers: producers}
+			utils.WriteJSONWithStatus(w, r, m, http.StatusServiceUnavailable)
+			log.InfoC(requestId, "Kafka producer is not connected", log.Data{"producer": producer})
+			return
+		}
+	}
+
+	utils.WriteJSON(w, r, kafkaHealthResponse{Message: "HEALTHY", Producers: producers})
+}
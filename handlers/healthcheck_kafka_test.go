@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/penalty-payment-api/common/kafka"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitHandleHealthCheckKafka(t *testing.T) {
+	Convey("Given the Kafka producers are checked", t, func() {
+		originalGetProducerHealth := getProducerHealth
+		defer func() {
+			getProducerHealth = originalGetProducerHealth
+		}()
+
+		testCases := []struct {
+			name           string
+			producers      []kafka.ProducerHealth
+			status         int
+			bodyStartsWith string
+		}{
+			{
+				name:           "all connected",
+				producers:      []kafka.ProducerHealth{{BrokerAddrs: []string{"kafka:9092"}, Healthy: true}},
+				status:         http.StatusOK,
+				bodyStartsWith: `{"message":"HEALTHY"`,
+			},
+			{
+				name: "one not connected",
+				producers: []kafka.ProducerHealth{
+					{BrokerAddrs: []string{"kafka:9092"}, Healthy: true},
+					{BrokerAddrs: []string{"kafka3:9092"}, Error: "kafka: client has run out of available brokers to talk to"},
+				},
+				status:         http.StatusServiceUnavailable,
+				bodyStartsWith: `{"message":"UNHEALTHY - KAFKA UNAVAILABLE"`,
+			},
+		}
+
+		for _, tc := range testCases {
+			Convey("When the producers are "+tc.name, func() {
+				getProducerHealth = func() []kafka.ProducerHealth {
+					return tc.producers
+				}
+
+				req := httptest.NewRequest(http.MethodGet, "/penalty-payment-api/healthcheck/kafka", nil)
+				rr := httptest.NewRecorder()
+				HandleHealthCheckKafka(rr, req)
+
+				So(rr.Code, ShouldEqual, tc.status)
+				So(rr.Body.String(), ShouldStartWith, tc.bodyStartsWith)
+			})
+		}
+	})
+}
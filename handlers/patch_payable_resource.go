@@ -1,34 +1,114 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"sync"
 
 	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api-core/constants"
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api-core/validators"
 	"github.com/companieshouse/penalty-payment-api/common/dao"
 	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
 	"github.com/companieshouse/penalty-payment-api/common/services"
 	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/recovery"
 	"github.com/companieshouse/penalty-payment-api/penalty_payments/service"
 )
 
 var (
-	handleSendEmailKafkaMessage         = service.SendEmailKafkaMessage
-	handlePaymentProcessingKafkaMessage = service.PaymentProcessingKafkaMessage
-	wg                                  sync.WaitGroup
-	getConfig                           = config.Get
+	getEmailOutboxMessage             = service.EmailOutboxMessage
+	getPaymentProcessingOutboxMessage = service.PaymentProcessingOutboxMessage
+	getConfig                         = config.Get
 )
 
+const (
+	stepSucceeded = "succeeded"
+	stepFailed    = "failed"
+	stepSkipped   = "skipped"
+
+	// emailStep and financeStep are the steps saved as failed on the payable resource, so that a retried payment
+	// only runs them again
+	emailStep   = "email"
+	financeStep = "finance"
+)
+
+var (
+	// errPaymentCancelled is returned when the payment was cancelled, so the resource is left as it is
+	errPaymentCancelled = errors.New("the payment was cancelled")
+	// errInvalidPayment is returned when the payment does not pay the resource e.g. its amount is different
+	errInvalidPayment = errors.New("the payment does not pay the payable resource")
+)
+
+// stepOutcome is the outcome of one step of marking a payable resource as paid
+type stepOutcome struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func failedStep(reason string) stepOutcome {
+	return stepOutcome{Status: stepFailed, Error: reason}
+}
+
+// markAsPaidOutcomes is the outcome of each step of marking a payable resource as paid for a single request. The
+// email and finance steps succeed once their message is in the outbox, or once E5 has been updated when payments
+// processing is disabled.
+type markAsPaidOutcomes struct {
+	Database stepOutcome `json:"db"`
+	Email    stepOutcome `json:"email"`
+	Finance  stepOutcome `json:"finance"`
+}
+
+// markAsPaidResponse is returned when a step of marking a payable resource as paid failed
+type markAsPaidResponse struct {
+	Message  string             `json:"message"`
+	Outcomes markAsPaidOutcomes `json:"outcomes"`
+}
+
+func newMarkAsPaidOutcomes() *markAsPaidOutcomes {
+	skipped := stepOutcome{Status: stepSkipped}
+	return &markAsPaidOutcomes{Database: skipped, Email: skipped, Finance: skipped}
+}
+
+// recorded marks the database step as succeeded, along with the steps whose messages were put in the outbox with it
+func (o *markAsPaidOutcomes) recorded() {
+	o.Database = stepOutcome{Status: stepSucceeded}
+	if o.Email.Status != stepFailed {
+		o.Email = stepOutcome{Status: stepSucceeded}
+	}
+	if o.Finance.Status != stepFailed {
+		o.Finance = stepOutcome{Status: stepSucceeded}
+	}
+}
+
+func (o *markAsPaidOutcomes) failed() bool {
+	return o.Database.Status == stepFailed || o.Email.Status == stepFailed || o.Finance.Status == stepFailed
+}
+
+// failedSteps lists the steps after the database step that failed
+func (o *markAsPaidOutcomes) failedSteps() []string {
+	var steps []string
+	if o.Email.Status == stepFailed {
+		steps = append(steps, emailStep)
+	}
+	if o.Finance.Status == stepFailed {
+		steps = append(steps, financeStep)
+	}
+	return steps
+}
+
 // PayResourceHandler will update the resource to mark it as paid and also tell the finance system that the
-// transaction(s) associated with it are paid.
+// transaction(s) associated with it are paid. If any step fails the response lists the outcome of each step.
 func PayResourceHandler(payableResourceService *services.PayableResourceService, e5Client e5.ClientInterface, penaltyPaymentDetails *config.PenaltyDetailsMap,
-	allowedTransactionsMap *models.AllowedTransactionMap, apDaoSvc dao.AccountPenaltiesDaoService) http.Handler {
+	allowedTransactionsMap *models.AllowedTransactionMap, apDaoSvc dao.AccountPenaltiesDaoService,
+	payableStatusProvider types.PayableStatusProvider) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestId := log.Context(r)
 		log.InfoC(requestId, "start PATCH payable resource request")
@@ -45,6 +125,11 @@ func PayResourceHandler(payableResourceService *services.PayableResourceService,
 		logContext := log.Data{"payable_resource": resource}
 		log.DebugC(requestId, "got payable resource from context", logContext)
 
+		if err := notPayableError(resource); err != nil {
+			writeNotPayable(w, r, resource, err)
+			return
+		}
+
 		// 2. validate the request and check the payment reference against the payment api to validate that it has
 		// actually been paid
 		log.InfoC(requestId, "validating request", logContext)
@@ -76,9 +161,9 @@ func PayResourceHandler(payableResourceService *services.PayableResourceService,
 			return
 		}
 
-		log.InfoC(requestId, "checking if payment was cancelled",
-			log.Data{"payment_ref": request.Reference, "payable_ref": resource.PayableRef, "payment_status": payment.Status})
-		if payment.IsCancelled() {
+		outcomes, err := markAsPaid(resource, payment, r, payableResourceService, e5Client, penaltyPaymentDetails,
+			allowedTransactionsMap, apDaoSvc, payableStatusProvider)
+		if errors.Is(err, errPaymentCancelled) {
 			log.InfoC(requestId, "the payment was cancelled", log.Data{
 				"payment_ref":    request.Reference,
 				"payable_ref":    resource.PayableRef,
@@ -87,44 +172,146 @@ func PayResourceHandler(payableResourceService *services.PayableResourceService,
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-
-		log.InfoC(requestId, "validating payment", log.Data{"payable_ref": resource.PayableRef, "external_payment_id": payment.ExternalPaymentID})
-		err = validators.New().ValidateForPayment(*resource, *payment)
-		if err != nil {
-			log.ErrorC(requestId, err)
+		if errors.Is(err, errInvalidPayment) {
 			m := models.NewMessageResponse("there was a problem validating this payment")
 			utils.WriteJSONWithStatus(w, r, m, http.StatusBadRequest)
 			return
 		}
-		log.DebugC(requestId, "payment is valid", log.Data{"payment": payment})
+		if errors.Is(err, services.ErrAlreadyPaid) || errors.Is(err, services.ErrAlreadyPaidWithReference) {
+			writeAlreadyPaid(w, r, resource, payment, err)
+			return
+		}
+		if errors.Is(err, services.ErrPayableResourceExpired) || errors.Is(err, services.ErrPayableResourceCancelled) {
+			writeNotPayable(w, r, resource, err)
+			return
+		}
+		if outcomes.failed() {
+			writeMarkAsPaidOutcomes(w, r, outcomes)
+			return
+		}
 
-		wg.Add(3)
+		log.InfoC(requestId, "PATCH payable resource request completed successfully", log.Data{"customer_code": resource.CustomerCode})
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// MarkResourceAsPaid returns the mark-as-paid logic of the PATCH payable resource handler for the recovery job, which
+// marks a resource as paid with a paid payment it found itself rather than one the payments API called back with
+func MarkResourceAsPaid(payableResourceService *services.PayableResourceService, e5Client e5.ClientInterface,
+	penaltyPaymentDetails *config.PenaltyDetailsMap, allowedTransactionsMap *models.AllowedTransactionMap,
+	apDaoSvc dao.AccountPenaltiesDaoService, payableStatusProvider types.PayableStatusProvider) recovery.MarkAsPaid {
+	return func(r *http.Request, resource *models.PayableResource, payment *validators.PaymentInformation) error {
+		outcomes, err := markAsPaid(resource, payment, r, payableResourceService, e5Client, penaltyPaymentDetails,
+			allowedTransactionsMap, apDaoSvc, payableStatusProvider)
+		if errors.Is(err, services.ErrAlreadyPaidWithReference) {
+			// another request marked the resource as paid with the same payment after it was read
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if outcomes.failed() {
+			return fmt.Errorf("the payable resource could not be fully marked as paid: [%+v]", *outcomes)
+		}
+		return nil
+	}
+}
 
-		log.InfoC(requestId, "sending confirmation email", log.Data{"customer_code": resource.CustomerCode, "payable_ref": resource.PayableRef})
-		go sendConfirmationEmail(resource, payment, r, w, penaltyPaymentDetails, allowedTransactionsMap, apDaoSvc)
-		log.InfoC(requestId, "updating payable resource as paid", log.Data{"customer_code": resource.CustomerCode, "payable_ref": resource.PayableRef})
-		go updateAsPaidInDatabase(resource, payment, payableResourceService, requestId, w)
+// markAsPaid marks the resource as paid with the payment, recording the payment session, putting the confirmation email
+// and, if payments processing is enabled, the penalty payments processing message in the outbox, updating E5 if it is
+// not and marking the penalties as paid in the account penalties cache. It returns the outcome of each step, or an
+// error if the resource cannot be paid with the payment: services.ErrAlreadyPaid if it has been paid with a different
+// payment, services.ErrAlreadyPaidWithReference if it was paid with the payment after it was read, the not payable
+// errors of the services package, errPaymentCancelled or errInvalidPayment.
+func markAsPaid(resource *models.PayableResource, payment *validators.PaymentInformation, r *http.Request,
+	payableResourceService *services.PayableResourceService, e5Client e5.ClientInterface,
+	penaltyPaymentDetails *config.PenaltyDetailsMap, allowedTransactionsMap *models.AllowedTransactionMap,
+	apDaoSvc dao.AccountPenaltiesDaoService, payableStatusProvider types.PayableStatusProvider) (*markAsPaidOutcomes, error) {
+	requestId := log.Context(r)
+	logContext := log.Data{"payable_resource": resource}
 
-		if paymentsProcessingEnabled(requestId) {
-			log.InfoC(requestId, "payments processing feature enabled")
-			go addPaymentsProcessingMsgToTopic(resource, payment, requestId, w)
-		} else {
-			log.InfoC(requestId, "payments processing feature disabled")
-			log.InfoC(requestId, "updating penalty as paid in E5", log.Data{"customer_code": resource.CustomerCode, "payable_ref": resource.PayableRef})
-			go updateIssuer(payableResourceService, e5Client, resource, payment, requestId, w)
+	// payments-api retries its callbacks until one succeeds, so a resource already paid with this payment is not
+	// paid again. Only a step that was saved as failed when it was paid is run again before the retry is reported
+	// as a success.
+	if resource.Payment.Status == constants.Paid.String() {
+		if resource.Payment.Reference != payment.Reference {
+			return nil, services.ErrAlreadyPaid
 		}
 
-		wg.Wait()
+		return finishMarkAsPaid(resource, payment, r, payableResourceService, e5Client, penaltyPaymentDetails,
+			allowedTransactionsMap, apDaoSvc, payableStatusProvider), nil
+	}
+
+	log.InfoC(requestId, "checking if payment was cancelled",
+		log.Data{"payment_ref": payment.PaymentID, "payable_ref": resource.PayableRef, "payment_status": payment.Status})
+	if payment.IsCancelled() {
+		return nil, errPaymentCancelled
+	}
 
-		// need to wait to mark the penalty as paid until the go routines above execute as the email
-		// sender relies on the state of the penalty in the DB i.e. not paid yet
-		log.InfoC(requestId, "updating account penalty cache record as paid", log.Data{"customer_code": resource.CustomerCode, "payable_ref": resource.PayableRef})
-		updateAccountPenaltyAsPaid(resource, apDaoSvc, requestId)
+	log.InfoC(requestId, "validating payment", log.Data{"payable_ref": resource.PayableRef, "external_payment_id": payment.ExternalPaymentID})
+	err := validators.New().ValidateForPayment(*resource, *payment)
+	if err != nil {
+		log.ErrorC(requestId, err)
+		return nil, fmt.Errorf("%w: [%v]", errInvalidPayment, err)
+	}
+	log.DebugC(requestId, "payment is valid", log.Data{"payment": payment})
 
-		log.InfoC(requestId, "PATCH payable resource request completed successfully", log.Data{"customer_code": resource.CustomerCode})
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNoContent) // This will not be set if status has already been set
-	})
+	// the payment session is recorded first so that the recovery job can still mark the resource as paid if any
+	// of the following steps fail
+	err = payableResourceService.DAO.RecordPaymentSession(resource.CustomerCode, resource.PayableRef, payment.PaymentID, requestId)
+	if err != nil {
+		log.ErrorC(requestId, fmt.Errorf("error recording payment session: [%v]", err), logContext)
+	}
+
+	// the messages are put in the outbox in the same transaction that marks the resource as paid, so they are
+	// published by the outbox relay even if Kafka is unavailable now. Each step is run in turn for this request
+	// and its outcome recorded, so a single response reports every step that failed.
+	processingEnabled := paymentsProcessingEnabled(requestId)
+	outcomes := newMarkAsPaidOutcomes()
+	messages := prepareOutboxMessages(resource, payment, r, penaltyPaymentDetails, allowedTransactionsMap, apDaoSvc,
+		payableStatusProvider, processingEnabled, outcomes)
+
+	log.InfoC(requestId, "updating payable resource as paid", log.Data{"customer_code": resource.CustomerCode, "payable_ref": resource.PayableRef})
+	err = updateAsPaidInDatabase(resource, payment, payableResourceService, messages, requestId)
+	if errors.Is(err, services.ErrAlreadyPaid) || errors.Is(err, services.ErrAlreadyPaidWithReference) {
+		// another request marked the resource as paid after it was read, so the messages were not put in the outbox
+		return nil, err
+	}
+	if errors.Is(err, services.ErrPayableResourceExpired) || errors.Is(err, services.ErrPayableResourceCancelled) {
+		// the resource expired or was cancelled after it was read
+		return nil, err
+	}
+	if err != nil {
+		log.ErrorC(requestId, err, log.Data{"payable_ref": resource.PayableRef, "payment_reference": payment.Reference})
+		outcomes.Database = failedStep("the payment could not be recorded against the payable resource")
+		return outcomes, nil
+	}
+	outcomes.recorded()
+
+	if processingEnabled {
+		log.InfoC(requestId, "payments processing feature enabled")
+	} else {
+		log.InfoC(requestId, "payments processing feature disabled")
+		log.InfoC(requestId, "updating penalty as paid in E5", log.Data{"customer_code": resource.CustomerCode, "payable_ref": resource.PayableRef})
+		outcomes.Finance = updateIssuer(r.Context(), payableResourceService, e5Client, resource, payment, requestId)
+	}
+
+	// the penalty is marked as paid last as the email is prepared from the state of the penalty in the DB i.e. not
+	// paid yet
+	log.InfoC(requestId, "updating account penalty cache record as paid", log.Data{"customer_code": resource.CustomerCode, "payable_ref": resource.PayableRef})
+	updateAccountPenaltyAsPaid(resource, apDaoSvc, requestId)
+
+	if outcomes.failed() {
+		saveFailedSteps(resource, payableResourceService, outcomes, requestId)
+	}
+	return outcomes, nil
+}
+
+// writeMarkAsPaidOutcomes responds with the outcome of each step of marking the resource as paid, when one of them failed
+func writeMarkAsPaidOutcomes(w http.ResponseWriter, r *http.Request, outcomes *markAsPaidOutcomes) {
+	log.InfoC(log.Context(r), "PATCH payable resource request completed with failed steps", log.Data{"outcomes": outcomes})
+	m := markAsPaidResponse{Message: "the payable resource could not be fully marked as paid", Outcomes: *outcomes}
+	utils.WriteJSONWithStatus(w, r, m, http.StatusInternalServerError)
 }
 
 func paymentsProcessingEnabled(requestId string) bool {
@@ -137,86 +324,224 @@ func paymentsProcessingEnabled(requestId string) bool {
 	return cfg.FeatureFlagPaymentsProcessingEnabled
 }
 
-func sendConfirmationEmail(resource *models.PayableResource, payment *validators.PaymentInformation, r *http.Request, w http.ResponseWriter,
-	penaltyPaymentDetails *config.PenaltyDetailsMap, allowedTransactionsMap *models.AllowedTransactionMap, apDaoSvc dao.AccountPenaltiesDaoService) {
-
+// prepareOutboxMessages prepares the confirmation email and, if payments processing is enabled, the penalty payments
+// processing message, recording a failed outcome for each that could not be prepared. The payment is still recorded
+// with the messages that could be.
+func prepareOutboxMessages(resource *models.PayableResource, payment *validators.PaymentInformation, r *http.Request,
+	penaltyPaymentDetails *config.PenaltyDetailsMap, allowedTransactionsMap *models.AllowedTransactionMap,
+	apDaoSvc dao.AccountPenaltiesDaoService, payableStatusProvider types.PayableStatusProvider, processingEnabled bool,
+	outcomes *markAsPaidOutcomes) []outbox.Message {
+	requestId := log.Context(r)
 	logContext := log.Data{
 		"payable_ref":       resource.PayableRef,
 		"payment_reference": payment.Reference,
 		"customer_code":     resource.CustomerCode,
-		"email_address":     resource.CreatedBy.Email,
 	}
 
-	// Send confirmation email
-	defer wg.Done()
-	err := handleSendEmailKafkaMessage(*resource, r, penaltyPaymentDetails, allowedTransactionsMap, apDaoSvc)
+	var messages []outbox.Message
+
+	if emailMessage := prepareEmailMessage(resource, r, penaltyPaymentDetails, allowedTransactionsMap, apDaoSvc,
+		payableStatusProvider, outcomes, logContext); emailMessage != nil {
+		messages = append(messages, *emailMessage)
+	}
+
+	if processingEnabled {
+		if processingMessage := prepareProcessingMessage(resource, payment, requestId, outcomes, logContext); processingMessage != nil {
+			messages = append(messages, *processingMessage)
+		}
+	}
+
+	return messages
+}
+
+// prepareEmailMessage prepares the confirmation email, recording a failed outcome if it could not be prepared
+func prepareEmailMessage(resource *models.PayableResource, r *http.Request, penaltyPaymentDetails *config.PenaltyDetailsMap,
+	allowedTransactionsMap *models.AllowedTransactionMap, apDaoSvc dao.AccountPenaltiesDaoService,
+	payableStatusProvider types.PayableStatusProvider, outcomes *markAsPaidOutcomes, logContext log.Data) *outbox.Message {
+	log.InfoC(log.Context(r), "preparing confirmation email", logContext, log.Data{"email_address": resource.CreatedBy.Email})
+	emailMessage, err := getEmailOutboxMessage(*resource, r, penaltyPaymentDetails, allowedTransactionsMap, apDaoSvc, payableStatusProvider)
 	if err != nil {
 		log.ErrorR(r, err, logContext)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		outcomes.Email = failedStep("the confirmation email could not be prepared")
+		return nil
+	}
+	return emailMessage
+}
+
+// prepareProcessingMessage prepares the penalty payments processing message, recording a failed outcome if it could
+// not be prepared
+func prepareProcessingMessage(resource *models.PayableResource, payment *validators.PaymentInformation, requestId string,
+	outcomes *markAsPaidOutcomes, logContext log.Data) *outbox.Message {
+	log.DebugC(requestId, "preparing payments processing message", logContext, log.Data{"created_at": resource.CreatedAt})
+	processingMessage, err := getPaymentProcessingOutboxMessage(*resource, payment, requestId)
+	if err != nil {
+		log.ErrorC(requestId, err, logContext)
+		outcomes.Finance = failedStep("the penalty payments processing message could not be prepared")
+		return nil
+	}
+	return processingMessage
+}
+
+// saveFailedSteps saves the steps that failed on the payable resource, so that a retried payment runs them again. A
+// failure to save them is only logged, as the response already reports the steps that failed.
+func saveFailedSteps(resource *models.PayableResource, payableResourceService *services.PayableResourceService,
+	outcomes *markAsPaidOutcomes, requestId string) {
+	err := payableResourceService.DAO.SaveFailedMarkAsPaidSteps(resource.CustomerCode, resource.PayableRef, outcomes.failedSteps(), requestId)
+	if err != nil {
+		log.ErrorC(requestId, fmt.Errorf("error saving failed mark as paid steps: [%v]", err), log.Data{
+			"payable_ref":   resource.PayableRef,
+			"customer_code": resource.CustomerCode,
+		})
+	}
+}
+
+// finishMarkAsPaid runs again the steps of marking the resource as paid that were saved as failed when it was paid with
+// the payment, e.g. because the schema registry or E5 was unavailable. A step is only run again when its failure was
+// saved, so a resource with no failed steps saved, including one paid before they were saved, is left as it is and
+// nothing is sent again. The account penalties cache is not updated again, as it was updated whatever the outcome of
+// the other steps.
+func finishMarkAsPaid(resource *models.PayableResource, payment *validators.PaymentInformation, r *http.Request,
+	payableResourceService *services.PayableResourceService, e5Client e5.ClientInterface,
+	penaltyPaymentDetails *config.PenaltyDetailsMap, allowedTransactionsMap *models.AllowedTransactionMap,
+	apDaoSvc dao.AccountPenaltiesDaoService, payableStatusProvider types.PayableStatusProvider) *markAsPaidOutcomes {
+	requestId := log.Context(r)
+	logContext := log.Data{
+		"payable_ref":       resource.PayableRef,
+		"payment_reference": payment.Reference,
+		"customer_code":     resource.CustomerCode,
+	}
+	log.InfoC(requestId, "payable resource already paid with this payment reference, checking for failed steps", logContext)
+
+	succeeded := stepOutcome{Status: stepSucceeded}
+	outcomes := &markAsPaidOutcomes{Database: succeeded, Email: succeeded, Finance: succeeded}
+
+	failedSteps, err := payableResourceService.DAO.GetFailedMarkAsPaidSteps(resource.CustomerCode, resource.PayableRef, requestId)
+	if err != nil {
+		log.ErrorC(requestId, fmt.Errorf("error getting failed mark as paid steps of payable resource: [%v]", err), logContext)
+		outcomes.Email = failedStep("the payable resource could not be checked for a failed confirmation email")
+		outcomes.Finance = failedStep("the payable resource could not be checked for a failed finance update")
+		return outcomes
+	}
+	if len(failedSteps) == 0 {
+		return outcomes
+	}
+	log.InfoC(requestId, "running failed mark as paid steps again", logContext, log.Data{"failed_steps": failedSteps})
+
+	var messages []outbox.Message
+	for _, step := range failedSteps {
+		switch step {
+		case emailStep:
+			if emailMessage := prepareEmailMessage(resource, r, penaltyPaymentDetails, allowedTransactionsMap, apDaoSvc,
+				payableStatusProvider, outcomes, logContext); emailMessage != nil {
+				messages = append(messages, *emailMessage)
+			}
+		case financeStep:
+			if !paymentsProcessingEnabled(requestId) {
+				outcomes.Finance = finishUpdateIssuer(r.Context(), payableResourceService, e5Client, resource, payment, requestId)
+			} else if processingMessage := prepareProcessingMessage(resource, payment, requestId, outcomes, logContext); processingMessage != nil {
+				messages = append(messages, *processingMessage)
+			}
+		}
 	}
 
-	log.InfoR(r, "Send email kafka message sent", logContext)
+	if len(messages) > 0 {
+		if err = payableResourceService.DAO.AddOutboxMessages(messages, requestId); err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error adding messages to outbox: [%v]", err), logContext)
+			for _, message := range messages {
+				switch message.Kind {
+				case outbox.EmailSend:
+					outcomes.Email = failedStep("the confirmation email could not be put in the outbox")
+				case outbox.PenaltyPaymentsProcessing:
+					outcomes.Finance = failedStep("the penalty payments processing message could not be put in the outbox")
+				}
+			}
+		}
+	}
+
+	saveFailedSteps(resource, payableResourceService, outcomes, requestId)
+	return outcomes
+}
+
+// finishUpdateIssuer marks the penalty as paid in E5 unless E5 has already confirmed the payment
+func finishUpdateIssuer(ctx context.Context, payableResourceService *services.PayableResourceService, e5Client e5.ClientInterface,
+	resource *models.PayableResource, payment *validators.PaymentInformation, requestId string) stepOutcome {
+	progress, err := payableResourceService.DAO.GetE5Progress(resource.CustomerCode, resource.PayableRef, requestId)
+	if err != nil {
+		log.ErrorC(requestId, fmt.Errorf("error getting E5 progress of payable resource: [%v]", err), log.Data{
+			"payable_ref":   resource.PayableRef,
+			"customer_code": resource.CustomerCode,
+		})
+		return failedStep("the penalty could not be marked as paid in the finance system")
+	}
+	if progress.Completed(e5.ConfirmAction) {
+		return stepOutcome{Status: stepSucceeded}
+	}
+
+	log.InfoC(requestId, "updating penalty as paid in E5", log.Data{"customer_code": resource.CustomerCode, "payable_ref": resource.PayableRef})
+	return updateIssuer(ctx, payableResourceService, e5Client, resource, payment, requestId)
 }
 
 func updateAsPaidInDatabase(resource *models.PayableResource, payment *validators.PaymentInformation,
-	payableResourceService *services.PayableResourceService, requestId string, w http.ResponseWriter) {
-	// Update the payable resource in the db
-	defer wg.Done()
-	err := payableResourceService.UpdateAsPaid(*resource, *payment, requestId)
+	payableResourceService *services.PayableResourceService, messages []outbox.Message, requestId string) error {
+	// Update the payable resource in the db and put the messages in the outbox
+	err := payableResourceService.UpdateAsPaid(*resource, *payment, messages, requestId)
 	if err != nil {
-		log.ErrorC(requestId, err, log.Data{"payable_ref": resource.PayableRef, "payment_reference": payment.Reference})
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return err
 	}
 
 	log.InfoC(requestId, "payment resource is now marked as paid in db", log.Data{
-		"payable_ref":   resource.PayableRef,
-		"customer_code": resource.CustomerCode,
+		"payable_ref":     resource.PayableRef,
+		"customer_code":   resource.CustomerCode,
+		"outbox_messages": len(messages),
 	})
+	return nil
+}
+
+// writeAlreadyPaid responds to a request for a resource that is already paid. If it was paid with the same payment the
+// request is a retry, so no content is returned as if it had just been paid. If it was paid with a different payment
+// the request conflicts with the resource.
+func writeAlreadyPaid(w http.ResponseWriter, r *http.Request, resource *models.PayableResource,
+	payment *validators.PaymentInformation, err error) {
+	requestId := log.Context(r)
+	logContext := log.Data{
+		"customer_code":     resource.CustomerCode,
+		"payable_ref":       resource.PayableRef,
+		"payment_reference": payment.Reference,
+	}
+
+	if errors.Is(err, services.ErrAlreadyPaidWithReference) {
+		log.InfoC(requestId, "payable resource already paid with this payment reference, nothing to do", logContext)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	log.ErrorC(requestId, err, logContext)
+	m := models.NewMessageResponse("the payable resource has already been paid with a different payment reference")
+	utils.WriteJSONWithStatus(w, r, m, http.StatusConflict)
 }
 
-func updateIssuer(payableResourceService *services.PayableResourceService, e5Client e5.ClientInterface, resource *models.PayableResource,
-	payment *validators.PaymentInformation, requestId string, w http.ResponseWriter) {
+func updateIssuer(ctx context.Context, payableResourceService *services.PayableResourceService, e5Client e5.ClientInterface, resource *models.PayableResource,
+	payment *validators.PaymentInformation, requestId string) stepOutcome {
 	// Mark the resource as paid in e5
-	defer wg.Done()
-	err := api.UpdateIssuerAccountWithPenaltyPaid(payableResourceService, e5Client, *resource, *payment, requestId)
+	err := api.UpdateIssuerAccountWithPenaltyPaid(ctx, payableResourceService, e5Client, *resource, *payment, requestId)
 	if err != nil {
 		log.ErrorC(requestId, err, log.Data{
 			"payable_ref":   resource.PayableRef,
 			"customer_code": resource.CustomerCode,
 		})
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return failedStep("the penalty could not be marked as paid in the finance system")
 	}
 
 	log.InfoC(requestId, "successfully initiated process to update payment in E5", log.Data{
 		"payable_ref":   resource.PayableRef,
 		"customer_code": resource.CustomerCode,
 	})
+	return stepOutcome{Status: stepSucceeded}
 }
 
-func addPaymentsProcessingMsgToTopic(payableResource *models.PayableResource,
-	payment *validators.PaymentInformation, requestId string, w http.ResponseWriter) {
-	defer wg.Done()
-
-	logContext := log.Data{
-		"created_at":        payableResource.CreatedAt,
-		"customer_code":     payableResource.CustomerCode,
-		"payable_ref":       payableResource.PayableRef,
-		"payment_reference": payment.Reference,
-	}
-	log.DebugC(requestId, "adding payments processing message to topic", logContext)
-	// send the kafka message to the producer
-	err := handlePaymentProcessingKafkaMessage(*payableResource, payment, requestId)
-	if err != nil {
-		log.ErrorC(requestId, err, logContext)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	log.InfoC(requestId, "Payment processing kafka message sent", logContext)
-}
-
+// updateAccountPenaltyAsPaid marks every penalty of the resource as paid in the account penalties cache, or reduces its
+// outstanding amount if only part of it was paid. A penalty that cannot be marked is logged and the others are still
+// marked.
 func updateAccountPenaltyAsPaid(resource *models.PayableResource, svc dao.AccountPenaltiesDaoService, requestId string) {
 	companyCode, err := getCompanyCodeFromTransaction(resource.Transactions)
 	if err != nil {
@@ -224,17 +549,27 @@ func updateAccountPenaltyAsPaid(resource *models.PayableResource, svc dao.Accoun
 			log.Data{"customer_code": resource.CustomerCode, "payable_ref": resource.PayableRef})
 		return
 	}
-	penalty := resource.Transactions[0]
 
-	err = svc.UpdateAccountPenaltyAsPaid(resource.CustomerCode, companyCode, penalty.PenaltyRef, requestId)
-	if err != nil {
-		log.ErrorC(requestId, fmt.Errorf("error updating account penalties collection as paid: [%v]", err),
-			log.Data{"customer_code": resource.CustomerCode, "company_code": companyCode,
-				"penalty_ref": penalty.PenaltyRef, "payable_ref": resource.PayableRef})
-		return
-	}
+	for _, penalty := range resource.Transactions {
+		logContext := log.Data{"customer_code": resource.CustomerCode, "company_code": companyCode,
+			"penalty_ref": penalty.PenaltyRef, "payable_ref": resource.PayableRef}
+
+		partPaid, err := svc.UpdateAccountPenaltyAsPartPaid(resource.CustomerCode, companyCode, penalty.PenaltyRef, penalty.Amount, requestId)
+		if err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error updating account penalties collection as part paid: [%v]", err), logContext)
+			continue
+		}
+		if partPaid {
+			log.InfoC(requestId, "account penalties collection has been updated as part paid", logContext)
+			continue
+		}
 
-	log.InfoC(requestId, "account penalties collection has been updated as paid",
-		log.Data{"customer_code": resource.CustomerCode, "company_code": companyCode,
-			"penalty_ref": penalty.PenaltyRef, "payable_ref": resource.PayableRef})
+		err = svc.UpdateAccountPenaltyAsPaid(resource.CustomerCode, companyCode, penalty.PenaltyRef, requestId)
+		if err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error updating account penalties collection as paid: [%v]", err), logContext)
+			continue
+		}
+
+		log.InfoC(requestId, "account penalties collection has been updated as paid", logContext)
+	}
 }
@@ -18,10 +18,13 @@ import (
 	"github.com/companieshouse/penalty-payment-api-core/validators"
 	"github.com/companieshouse/penalty-payment-api/common/dao"
 	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
 	"github.com/companieshouse/penalty-payment-api/common/services"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/private"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	"github.com/golang/mock/gomock"
 	"github.com/jarcoal/httpmock"
 	. "github.com/smartystreets/goconvey/convey"
@@ -55,6 +58,20 @@ var allowedTransactionsMap = &models.AllowedTransactionMap{
 	},
 }
 
+// newTestPayableStatusProvider gets the payable status of transactions from the payable status rules deployed with
+// the API
+func newTestPayableStatusProvider(t *testing.T) types.PayableStatusProvider {
+	rules, err := config.LoadPayableStatusRules(testutils.PayableStatusRulesFile())
+	if err != nil {
+		t.Fatalf("failed to load payable status rules: %v", err)
+	}
+	provider, err := private.NewRulesPayableStatusProvider(rules)
+	if err != nil {
+		t.Fatalf("failed to evaluate payable status rules: %v", err)
+	}
+	return provider
+}
+
 // reduces the boilerplate code needed to create, dispatch and unmarshal response body
 func dispatchPayResourceHandler(ctx context.Context, t *testing.T, reqBody *models.PatchResourceRequest,
 	daoSvc dao.PayableResourceDaoService, apDaoSvc dao.AccountPenaltiesDaoService) (*httptest.ResponseRecorder, *models.ResponseResource) {
@@ -76,8 +93,8 @@ func dispatchPayResourceHandler(ctx context.Context, t *testing.T, reqBody *mode
 
 	ctx = context.WithValue(ctx, httpsession.ContextKeySession, &session.Session{})
 
-	h := PayResourceHandler(payableResourceService, e5.NewClient("foo", "e5api"),
-		penaltyDetailsMap, allowedTransactionsMap, apDaoSvc)
+	h := PayResourceHandler(payableResourceService, e5.NewClient("foo", "e5api", nil),
+		penaltyDetailsMap, allowedTransactionsMap, apDaoSvc, newTestPayableStatusProvider(t))
 	req := httptest.NewRequest(http.MethodPost, "/", body).WithContext(ctx)
 	res := httptest.NewRecorder()
 
@@ -85,7 +102,7 @@ func dispatchPayResourceHandler(ctx context.Context, t *testing.T, reqBody *mode
 
 	if res.Body.Len() > 0 {
 		var responseBody models.ResponseResource
-		err := json.NewDecoder(res.Body).Decode(&responseBody)
+		err := json.Unmarshal(res.Body.Bytes(), &responseBody)
 		if err != nil {
 			t.Errorf("failed to read response body")
 		}
@@ -96,30 +113,41 @@ func dispatchPayResourceHandler(ctx context.Context, t *testing.T, reqBody *mode
 	return res, nil
 }
 
-// Mock function for erroring when preparing and sending kafka message
-func mockSendEmailKafkaMessageError(_ models.PayableResource, _ *http.Request,
-	_ *config.PenaltyDetailsMap, _ *models.AllowedTransactionMap, _ dao.AccountPenaltiesDaoService) error {
-	return errors.New("error")
+// reads the outcome of each step from the response to a request that failed to mark the resource as paid
+func outcomesOf(t *testing.T, res *httptest.ResponseRecorder) markAsPaidOutcomes {
+	var responseBody markAsPaidResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &responseBody); err != nil {
+		t.Errorf("failed to read response body")
+	}
+	return responseBody.Outcomes
 }
 
-// Mock function for successful preparing and sending of kafka message
-func mockSendEmailKafkaMessage(_ models.PayableResource, _ *http.Request,
-	_ *config.PenaltyDetailsMap, _ *models.AllowedTransactionMap, _ dao.AccountPenaltiesDaoService) error {
-	return nil
+// Mock function for erroring when preparing the email kafka message
+func mockEmailOutboxMessageError(_ models.PayableResource, _ *http.Request,
+	_ *config.PenaltyDetailsMap, _ *models.AllowedTransactionMap, _ dao.AccountPenaltiesDaoService,
+	_ types.PayableStatusProvider) (*outbox.Message, error) {
+	return nil, errors.New("error")
 }
 
-// Mock function for erroring when preparing and sending kafka message
-func mockPaymentsProcessingKafkaMessageError(_ models.PayableResource, _ *validators.PaymentInformation, _ string) error {
-	return errors.New("error")
+// Mock function for successful preparing of the email kafka message
+func mockEmailOutboxMessage(resource models.PayableResource, _ *http.Request,
+	_ *config.PenaltyDetailsMap, _ *models.AllowedTransactionMap, _ dao.AccountPenaltiesDaoService,
+	_ types.PayableStatusProvider) (*outbox.Message, error) {
+	return outbox.NewMessage(outbox.EmailSend, resource.CustomerCode, resource.PayableRef, "email-send", []byte{0x01}), nil
 }
 
-// Mock function for successful preparing and sending of kafka message
-func mockPaymentsProcessingKafkaMessage(_ models.PayableResource, _ *validators.PaymentInformation, _ string) error {
-	return nil
+// Mock function for erroring when preparing the payments processing kafka message
+func mockPaymentProcessingOutboxMessageError(_ models.PayableResource, _ *validators.PaymentInformation, _ string) (*outbox.Message, error) {
+	return nil, errors.New("error")
+}
+
+// Mock function for successful preparing of the payments processing kafka message
+func mockPaymentProcessingOutboxMessage(resource models.PayableResource, _ *validators.PaymentInformation, _ string) (*outbox.Message, error) {
+	return outbox.NewMessage(outbox.PenaltyPaymentsProcessing, resource.CustomerCode, resource.PayableRef, "penalty-payments-processing", []byte{0x02}), nil
 }
 
 func mockedGetCompanyCodeFromTransaction(_ []models.TransactionItem) (string, error) {
-	return utils.LateFilingPenaltyCompanyCode, nil
+	return testutils.LateFilingPenaltyCompanyCode, nil
 }
 
 func mockedGetCompanyCodeFromTransactionError(_ []models.TransactionItem) (string, error) {
@@ -189,8 +217,8 @@ func TestUnitPayResourceHandler(t *testing.T) {
 
 			ctx = context.WithValue(ctx, httpsession.ContextKeySession, &session.Session{})
 
-			h := PayResourceHandler(payableResourceService, e5.NewClient("foo", "e5api"),
-				penaltyDetailsMap, allowedTransactionsMap, nil)
+			h := PayResourceHandler(payableResourceService, e5.NewClient("foo", "e5api", nil),
+				penaltyDetailsMap, allowedTransactionsMap, nil, newTestPayableStatusProvider(t))
 			req := httptest.NewRequest(http.MethodPost, "/", nil).WithContext(ctx)
 			res := httptest.NewRecorder()
 
@@ -278,7 +306,7 @@ func TestUnitPayResourceHandler(t *testing.T) {
 
 		Convey("problem with sending confirmation email", func() {
 			mockedGetCompanyCode := func(penaltyReference string) (string, error) {
-				return utils.LateFilingPenaltyCompanyCode, nil
+				return testutils.LateFilingPenaltyCompanyCode, nil
 			}
 
 			getCompanyCode = mockedGetCompanyCode
@@ -306,29 +334,38 @@ func TestUnitPayResourceHandler(t *testing.T) {
 			dataModel := &models.PayableResourceDao{}
 			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
 			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
-			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, "").Times(1)
-			mockPrDaoSvc.EXPECT().SaveE5Error(customerCode, "123", "", e5.CreateAction).Return(errors.New(""))
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
+			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, gomock.Any(), "").Times(1)
+			mockPrDaoSvc.EXPECT().SaveFailedMarkAsPaidSteps(customerCode, "123", []string{"email", "finance"}, "").Return(nil)
+			mockPrDaoSvc.EXPECT().GetE5Progress(customerCode, "123", "").Return(&e5.PaymentProgress{}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Error(customerCode, "123", "", e5.CreateAction, gomock.Any()).Return(errors.New(""))
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPartPaid(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), "").Return(false, nil)
 			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPaid(gomock.Any(), gomock.Any(), gomock.Any(), "").Return(nil)
 
 			// the payable resource in the request context
 			model := buildMockedPayableResource(true, 0)
 			ctx := context.WithValue(context.Background(), config.PayableResource, model)
 
-			// stub kafka messages
-			handleSendEmailKafkaMessage = mockSendEmailKafkaMessageError
-			handlePaymentProcessingKafkaMessage = mockPaymentsProcessingKafkaMessage
+			// stub outbox messages
+			getEmailOutboxMessage = mockEmailOutboxMessageError
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessage
 
 			reqBody := &models.PatchResourceRequest{Reference: "123"}
 			res, body := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
 
 			So(dataModel.IsPaid(), ShouldBeTrue)
 			So(res.Code, ShouldEqual, http.StatusInternalServerError)
-			So(body, ShouldBeNil)
+			So(body.Message, ShouldEqual, "the payable resource could not be fully marked as paid")
+			So(outcomesOf(t, res), ShouldResemble, markAsPaidOutcomes{
+				Database: stepOutcome{Status: stepSucceeded},
+				Email:    stepOutcome{Status: stepFailed, Error: "the confirmation email could not be prepared"},
+				Finance:  stepOutcome{Status: stepFailed, Error: "the penalty could not be marked as paid in the finance system"},
+			})
 		})
 
 		Convey("problem with adding payments message to topic", func() {
 			mockedGetCompanyCode := func(penaltyReference string) (string, error) {
-				return utils.LateFilingPenaltyCompanyCode, nil
+				return testutils.LateFilingPenaltyCompanyCode, nil
 			}
 
 			getCompanyCode = mockedGetCompanyCode
@@ -356,29 +393,322 @@ func TestUnitPayResourceHandler(t *testing.T) {
 			dataModel := &models.PayableResourceDao{}
 			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
 			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
-			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, "").Times(1)
-			mockPrDaoSvc.EXPECT().SaveE5Error(customerCode, "123", "", e5.CreateAction).Return(errors.New(""))
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
+			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, gomock.Any(), "").Times(1)
+			mockPrDaoSvc.EXPECT().SaveFailedMarkAsPaidSteps(customerCode, "123", []string{"finance"}, "").Return(nil)
+			mockPrDaoSvc.EXPECT().GetE5Progress(customerCode, "123", "").Return(&e5.PaymentProgress{}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Error(customerCode, "123", "", e5.CreateAction, gomock.Any()).Return(errors.New(""))
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPartPaid(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), "").Return(false, nil)
 			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPaid(gomock.Any(), gomock.Any(), gomock.Any(), "").Return(nil)
 
 			// the payable resource in the request context
 			model := buildMockedPayableResource(true, 0)
 			ctx := context.WithValue(context.Background(), config.PayableResource, model)
 
-			// stub kafka messages
-			handleSendEmailKafkaMessage = mockSendEmailKafkaMessage
-			handlePaymentProcessingKafkaMessage = mockPaymentsProcessingKafkaMessageError
+			// stub outbox messages
+			getEmailOutboxMessage = mockEmailOutboxMessage
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessageError
 
 			reqBody := &models.PatchResourceRequest{Reference: "123"}
 			res, body := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
 
 			So(dataModel.IsPaid(), ShouldBeTrue)
 			So(res.Code, ShouldEqual, http.StatusInternalServerError)
-			So(body, ShouldBeNil)
+			So(body.Message, ShouldEqual, "the payable resource could not be fully marked as paid")
+		})
+
+		Convey("problem with preparing payments processing message when payments processing is enabled", func() {
+			getConfig = func() (*config.Config, error) {
+				return &config.Config{FeatureFlagPaymentsProcessingEnabled: true}, nil
+			}
+			defer func() { getConfig = config.Get }()
+
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			// stub the response from the payments api
+			p := buildMockedPaymentResource("paid", "150")
+			responder, _ := httpmock.NewJsonResponder(http.StatusOK, p)
+			httpmock.RegisterResponder(
+				http.MethodGet,
+				companieshouseapi.PaymentsBasePath+"/payments/123",
+				responder,
+			)
+
+			httpmock.RegisterResponder(
+				http.MethodGet,
+				companieshouseapi.PaymentsBasePath+"/private/payments/123/payment-details",
+				httpmock.NewStringResponder(http.StatusOK, "{}"),
+			)
+
+			// stub the mongo lookup, E5 is not called when payments processing is enabled
+			mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(mockCtrl)
+			dataModel := &models.PayableResourceDao{}
+			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
+			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
+			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, gomock.Len(1), "").Times(1)
+			mockPrDaoSvc.EXPECT().SaveFailedMarkAsPaidSteps(customerCode, "123", []string{"finance"}, "").Return(nil)
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPartPaid(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), "").Return(false, nil)
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPaid(gomock.Any(), gomock.Any(), gomock.Any(), "").Return(nil)
+
+			// the payable resource in the request context
+			model := buildMockedPayableResource(true, 150)
+			ctx := context.WithValue(context.Background(), config.PayableResource, model)
+
+			// stub outbox messages
+			getEmailOutboxMessage = mockEmailOutboxMessage
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessageError
+			getCompanyCodeFromTransaction = mockedGetCompanyCodeFromTransaction
+
+			reqBody := &models.PatchResourceRequest{Reference: "123"}
+			res, _ := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusInternalServerError)
+			So(outcomesOf(t, res), ShouldResemble, markAsPaidOutcomes{
+				Database: stepOutcome{Status: stepSucceeded},
+				Email:    stepOutcome{Status: stepSucceeded},
+				Finance:  stepOutcome{Status: stepFailed, Error: "the penalty payments processing message could not be prepared"},
+			})
+		})
+
+		Convey("problem with recording the payment in the database", func() {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			// stub the response from the payments api
+			p := buildMockedPaymentResource("paid", "150")
+			responder, _ := httpmock.NewJsonResponder(http.StatusOK, p)
+			httpmock.RegisterResponder(
+				http.MethodGet,
+				companieshouseapi.PaymentsBasePath+"/payments/123",
+				responder,
+			)
+
+			httpmock.RegisterResponder(
+				http.MethodGet,
+				companieshouseapi.PaymentsBasePath+"/private/payments/123/payment-details",
+				httpmock.NewStringResponder(http.StatusOK, "{}"),
+			)
+
+			// stub the mongo lookup, nothing else is updated once the payment cannot be recorded
+			mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(mockCtrl)
+			dataModel := &models.PayableResourceDao{}
+			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
+			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
+			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, gomock.Any(), "").Return(errors.New("transaction aborted"))
+
+			// the payable resource in the request context
+			model := buildMockedPayableResource(true, 150)
+			ctx := context.WithValue(context.Background(), config.PayableResource, model)
+
+			// stub outbox messages
+			getEmailOutboxMessage = mockEmailOutboxMessage
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessage
+
+			reqBody := &models.PatchResourceRequest{Reference: "123"}
+			res, _ := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusInternalServerError)
+			So(outcomesOf(t, res), ShouldResemble, markAsPaidOutcomes{
+				Database: stepOutcome{Status: stepFailed, Error: "the payment could not be recorded against the payable resource"},
+				Email:    stepOutcome{Status: stepSkipped},
+				Finance:  stepOutcome{Status: stepSkipped},
+			})
+		})
+
+		Convey("payable resource already paid", func() {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			// stub the response from the payments api
+			p := buildMockedPaymentResource("paid", "150")
+			responder, _ := httpmock.NewJsonResponder(http.StatusOK, p)
+			httpmock.RegisterResponder(
+				http.MethodGet,
+				companieshouseapi.PaymentsBasePath+"/payments/123",
+				responder,
+			)
+			httpmock.RegisterResponder(
+				http.MethodGet,
+				companieshouseapi.PaymentsBasePath+"/private/payments/123/payment-details",
+				httpmock.NewStringResponder(http.StatusOK, "{}"),
+			)
+
+			// the payment is not recorded again and the account penalties cache is not updated again
+			mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(mockCtrl)
+			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
+			getEmailOutboxMessage = mockEmailOutboxMessageError
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessageError
+
+			model := buildMockedPayableResource(true, 150)
+			model.Payment.Status = constants.Paid.String()
+			reqBody := &models.PatchResourceRequest{Reference: "123"}
+
+			Convey("with the same payment reference and no failed steps saved then no content is returned and nothing is sent again", func() {
+				model.Payment.Reference = "financial_penalty_123"
+				ctx := context.WithValue(context.Background(), config.PayableResource, model)
+				mockPrDaoSvc.EXPECT().GetFailedMarkAsPaidSteps(customerCode, "123", "").Return(nil, nil)
+
+				res, body := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
+
+				So(res.Code, ShouldEqual, http.StatusNoContent)
+				So(res.Header().Get("Content-Type"), ShouldBeEmpty)
+				So(body, ShouldBeNil)
+			})
+
+			Convey("with the same payment reference and the email saved as failed then it is put in the outbox", func() {
+				model.Payment.Reference = "financial_penalty_123"
+				ctx := context.WithValue(context.Background(), config.PayableResource, model)
+				getEmailOutboxMessage = mockEmailOutboxMessage
+				mockPrDaoSvc.EXPECT().GetFailedMarkAsPaidSteps(customerCode, "123", "").Return([]string{emailStep}, nil)
+				var added []outbox.Message
+				mockPrDaoSvc.EXPECT().AddOutboxMessages(gomock.Any(), "").DoAndReturn(func(messages []outbox.Message, _ string) error {
+					added = messages
+					return nil
+				})
+				mockPrDaoSvc.EXPECT().SaveFailedMarkAsPaidSteps(customerCode, "123", gomock.Nil(), "").Return(nil)
+
+				res, body := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
+
+				So(res.Code, ShouldEqual, http.StatusNoContent)
+				So(body, ShouldBeNil)
+				So(added, ShouldHaveLength, 1)
+				So(added[0].Kind, ShouldEqual, outbox.EmailSend)
+			})
+
+			Convey("with the same payment reference and the finance update saved as failed then E5 is updated again", func() {
+				model.Payment.Reference = "financial_penalty_123"
+				ctx := context.WithValue(context.Background(), config.PayableResource, model)
+				httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment",
+					httpmock.NewStringResponder(http.StatusBadRequest, e5ValidationError))
+				mockPrDaoSvc.EXPECT().GetFailedMarkAsPaidSteps(customerCode, "123", "").Return([]string{financeStep}, nil)
+				mockPrDaoSvc.EXPECT().GetE5Progress(customerCode, "123", "").Return(&e5.PaymentProgress{}, nil).Times(2)
+				mockPrDaoSvc.EXPECT().SaveE5Error(customerCode, "123", "", e5.CreateAction, gomock.Any()).Return(nil)
+				mockPrDaoSvc.EXPECT().SaveFailedMarkAsPaidSteps(customerCode, "123", []string{financeStep}, "").Return(nil)
+
+				res, _ := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
+
+				So(res.Code, ShouldEqual, http.StatusInternalServerError)
+				So(outcomesOf(t, res), ShouldResemble, markAsPaidOutcomes{
+					Database: stepOutcome{Status: stepSucceeded},
+					Email:    stepOutcome{Status: stepSucceeded},
+					Finance:  stepOutcome{Status: stepFailed, Error: "the penalty could not be marked as paid in the finance system"},
+				})
+			})
+
+			Convey("with the same payment reference and the email still cannot be prepared then the failed step is returned", func() {
+				model.Payment.Reference = "financial_penalty_123"
+				ctx := context.WithValue(context.Background(), config.PayableResource, model)
+				mockPrDaoSvc.EXPECT().GetFailedMarkAsPaidSteps(customerCode, "123", "").Return([]string{emailStep}, nil)
+				mockPrDaoSvc.EXPECT().SaveFailedMarkAsPaidSteps(customerCode, "123", []string{emailStep}, "").Return(nil)
+
+				res, _ := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
+
+				So(res.Code, ShouldEqual, http.StatusInternalServerError)
+				So(outcomesOf(t, res).Email, ShouldResemble, stepOutcome{Status: stepFailed, Error: "the confirmation email could not be prepared"})
+				So(outcomesOf(t, res).Finance, ShouldResemble, stepOutcome{Status: stepSucceeded})
+			})
+
+			Convey("with the same payment reference and the failed steps cannot be got then the steps are returned as failed", func() {
+				model.Payment.Reference = "financial_penalty_123"
+				ctx := context.WithValue(context.Background(), config.PayableResource, model)
+				mockPrDaoSvc.EXPECT().GetFailedMarkAsPaidSteps(customerCode, "123", "").Return(nil, errors.New("mongo unavailable"))
+
+				res, _ := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
+
+				So(res.Code, ShouldEqual, http.StatusInternalServerError)
+				So(outcomesOf(t, res).Email.Status, ShouldEqual, stepFailed)
+				So(outcomesOf(t, res).Finance.Status, ShouldEqual, stepFailed)
+			})
+
+			Convey("with a different payment reference then conflict is returned", func() {
+				model.Payment.Reference = "financial_penalty_456"
+				ctx := context.WithValue(context.Background(), config.PayableResource, model)
+
+				res, body := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
+
+				So(res.Code, ShouldEqual, http.StatusConflict)
+				So(body.Message, ShouldEqual, "the payable resource has already been paid with a different payment reference")
+			})
+		})
+
+		Convey("payable resource has expired", func() {
+			model := buildMockedPayableResource(true, 150)
+			model.Payment.Status = dao.PaymentStatusExpired
+			ctx := context.WithValue(context.Background(), config.PayableResource, model)
+			reqBody := &models.PatchResourceRequest{Reference: "123"}
+
+			res, body := dispatchPayResourceHandler(ctx, t, reqBody, nil, nil)
+
+			So(res.Code, ShouldEqual, http.StatusGone)
+			So(body.Message, ShouldEqual, "the payable resource has expired and can no longer be paid, create a new payable resource to pay the penalty")
+		})
+
+		Convey("payable resource has been cancelled", func() {
+			model := buildMockedPayableResource(true, 150)
+			model.Payment.Status = dao.PaymentStatusCancelled
+			ctx := context.WithValue(context.Background(), config.PayableResource, model)
+			reqBody := &models.PatchResourceRequest{Reference: "123"}
+
+			res, body := dispatchPayResourceHandler(ctx, t, reqBody, nil, nil)
+
+			So(res.Code, ShouldEqual, http.StatusGone)
+			So(body.Message, ShouldEqual, "the payable resource has been cancelled and can no longer be paid, create a new payable resource to pay the penalty")
+		})
+
+		Convey("Penalty has expired since the payable resource was read", func() {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			// stub the response from the payments api
+			p := buildMockedPaymentResource("paid", "0")
+			responder, _ := httpmock.NewJsonResponder(http.StatusOK, p)
+			httpmock.RegisterResponder(
+				http.MethodGet,
+				companieshouseapi.PaymentsBasePath+"/payments/123",
+				responder,
+			)
+
+			httpmock.RegisterResponder(
+				http.MethodGet,
+				companieshouseapi.PaymentsBasePath+"/private/payments/123/payment-details",
+				httpmock.NewStringResponder(http.StatusOK, "{}"),
+			)
+
+			// stub the mongo lookup
+			dataModel := &models.PayableResourceDao{
+				Data: models.PayableResourceDataDao{
+					Payment: models.PaymentDao{
+						Status: dao.PaymentStatusExpired,
+					},
+				},
+			}
+
+			mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(mockCtrl)
+			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
+			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
+
+			// the payable resource in the request context
+			model := buildMockedPayableResource(true, 0)
+			ctx := context.WithValue(context.Background(), config.PayableResource, model)
+
+			// stub outbox messages
+			getEmailOutboxMessage = mockEmailOutboxMessage
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessage
+
+			reqBody := &models.PatchResourceRequest{Reference: "123"}
+			res, body := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusGone)
+			So(body.Message, ShouldEqual, "the payable resource has expired and can no longer be paid, create a new payable resource to pay the penalty")
 		})
 
-		Convey("Penalty has already been paid", func() {
+		Convey("Penalty has already been paid with a different payment reference", func() {
 			mockedGetCompanyCode := func(penaltyReference string) (string, error) {
-				return utils.LateFilingPenaltyCompanyCode, nil
+				return testutils.LateFilingPenaltyCompanyCode, nil
 			}
 
 			getCompanyCode = mockedGetCompanyCode
@@ -413,22 +743,76 @@ func TestUnitPayResourceHandler(t *testing.T) {
 			mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(mockCtrl)
 			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
 			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
-			mockPrDaoSvc.EXPECT().SaveE5Error(customerCode, "123", "", e5.CreateAction).Return(errors.New(""))
-			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPaid(gomock.Any(), gomock.Any(), gomock.Any(), "").Return(nil)
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
 
 			// the payable resource in the request context
 			model := buildMockedPayableResource(true, 0)
 			ctx := context.WithValue(context.Background(), config.PayableResource, model)
 
-			// stub kafka messages
-			handleSendEmailKafkaMessage = mockSendEmailKafkaMessage
-			handlePaymentProcessingKafkaMessage = mockPaymentsProcessingKafkaMessage
+			// stub outbox messages
+			getEmailOutboxMessage = mockEmailOutboxMessage
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessage
 
 			reqBody := &models.PatchResourceRequest{Reference: "123"}
 			res, body := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
 
 			So(dataModel.IsPaid(), ShouldBeTrue)
-			So(res.Code, ShouldEqual, http.StatusInternalServerError)
+			So(res.Code, ShouldEqual, http.StatusConflict)
+			So(body.Message, ShouldEqual, "the payable resource has already been paid with a different payment reference")
+		})
+
+		Convey("Penalty has already been paid with the same payment reference by another request", func() {
+			mockedGetCompanyCode := func(penaltyReference string) (string, error) {
+				return testutils.LateFilingPenaltyCompanyCode, nil
+			}
+
+			getCompanyCode = mockedGetCompanyCode
+
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			// stub the response from the payments api
+			p := buildMockedPaymentResource("paid", "0")
+			responder, _ := httpmock.NewJsonResponder(http.StatusOK, p)
+			httpmock.RegisterResponder(
+				http.MethodGet,
+				companieshouseapi.PaymentsBasePath+"/payments/123",
+				responder,
+			)
+
+			httpmock.RegisterResponder(
+				http.MethodGet,
+				companieshouseapi.PaymentsBasePath+"/private/payments/123/payment-details",
+				httpmock.NewStringResponder(http.StatusOK, "{}"),
+			)
+
+			// stub the mongo lookup
+			dataModel := &models.PayableResourceDao{
+				Data: models.PayableResourceDataDao{
+					Payment: models.PaymentDao{
+						Status:    constants.Paid.String(),
+						Reference: "financial_penalty_123",
+					},
+				},
+			}
+
+			mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(mockCtrl)
+			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
+			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
+
+			// the payable resource in the request context
+			model := buildMockedPayableResource(true, 0)
+			ctx := context.WithValue(context.Background(), config.PayableResource, model)
+
+			// stub outbox messages
+			getEmailOutboxMessage = mockEmailOutboxMessage
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessage
+
+			reqBody := &models.PatchResourceRequest{Reference: "123"}
+			res, body := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
+
+			So(res.Code, ShouldEqual, http.StatusNoContent)
 			So(body, ShouldBeNil)
 		})
 
@@ -460,24 +844,32 @@ func TestUnitPayResourceHandler(t *testing.T) {
 			dataModel := &models.PayableResourceDao{}
 			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
 			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
-			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, "").Times(1)
-			mockPrDaoSvc.EXPECT().SaveE5Error(customerCode, "123", "", e5.CreateAction).Return(errors.New(""))
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
+			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, gomock.Any(), "").Times(1)
+			mockPrDaoSvc.EXPECT().SaveFailedMarkAsPaidSteps(customerCode, "123", []string{"finance"}, "").Return(nil)
+			mockPrDaoSvc.EXPECT().GetE5Progress(customerCode, "123", "").Return(&e5.PaymentProgress{}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Error(customerCode, "123", "", e5.CreateAction, gomock.Any()).Return(errors.New(""))
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPartPaid(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), "").Return(false, nil)
 			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPaid(gomock.Any(), gomock.Any(), gomock.Any(), "").Return(nil)
 
 			// the payable resource in the request context
 			model := buildMockedPayableResource(true, 0)
 			ctx := context.WithValue(context.Background(), config.PayableResource, model)
 
-			// stub kafka messages
-			handleSendEmailKafkaMessage = mockSendEmailKafkaMessage
-			handlePaymentProcessingKafkaMessage = mockPaymentsProcessingKafkaMessage
+			// stub outbox messages
+			getEmailOutboxMessage = mockEmailOutboxMessage
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessage
 
 			reqBody := &models.PatchResourceRequest{Reference: "123"}
 			res, body := dispatchPayResourceHandler(ctx, t, reqBody, mockPrDaoSvc, mockApDaoSvc)
 
 			So(dataModel.IsPaid(), ShouldBeTrue)
 			So(res.Code, ShouldEqual, http.StatusInternalServerError)
-			So(body, ShouldBeNil)
+			So(outcomesOf(t, res), ShouldResemble, markAsPaidOutcomes{
+				Database: stepOutcome{Status: stepSucceeded},
+				Email:    stepOutcome{Status: stepSucceeded},
+				Finance:  stepOutcome{Status: stepFailed, Error: "the penalty could not be marked as paid in the finance system"},
+			})
 		})
 
 		Convey("problem with get company code during updateAccountPenaltyAsPaid", func() {
@@ -511,15 +903,16 @@ func TestUnitPayResourceHandler(t *testing.T) {
 			dataModel := &models.PayableResourceDao{}
 			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
 			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
-			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, "").Times(1)
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
+			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, gomock.Any(), "").Times(1)
 
 			// the payable resource in the request context
 			model := buildMockedPayableResource(true, 150)
 			ctx := context.WithValue(context.Background(), config.PayableResource, model)
 
-			// stub kafka messages
-			handleSendEmailKafkaMessage = mockSendEmailKafkaMessage
-			handlePaymentProcessingKafkaMessage = mockPaymentsProcessingKafkaMessage
+			// stub outbox messages
+			getEmailOutboxMessage = mockEmailOutboxMessage
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessage
 			getCompanyCodeFromTransaction = mockedGetCompanyCodeFromTransactionError
 
 			reqBody := &models.PatchResourceRequest{Reference: "123"}
@@ -560,16 +953,18 @@ func TestUnitPayResourceHandler(t *testing.T) {
 			dataModel := &models.PayableResourceDao{}
 			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
 			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
-			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, "").Times(1)
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
+			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, gomock.Any(), "").Times(1)
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPartPaid(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), "").Return(false, nil)
 			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPaid(gomock.Any(), gomock.Any(), gomock.Any(), "").Return(errors.New("error"))
 
 			// the payable resource in the request context
 			model := buildMockedPayableResource(true, 150)
 			ctx := context.WithValue(context.Background(), config.PayableResource, model)
 
-			// stub kafka message
-			handleSendEmailKafkaMessage = mockSendEmailKafkaMessage
-			handlePaymentProcessingKafkaMessage = mockPaymentsProcessingKafkaMessage
+			// stub outbox message
+			getEmailOutboxMessage = mockEmailOutboxMessage
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessage
 			getCompanyCodeFromTransaction = mockedGetCompanyCodeFromTransaction
 
 			reqBody := &models.PatchResourceRequest{Reference: "123"}
@@ -610,16 +1005,18 @@ func TestUnitPayResourceHandler(t *testing.T) {
 			dataModel := &models.PayableResourceDao{}
 			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
 			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
-			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, "").Times(1)
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
+			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, gomock.Any(), "").Times(1)
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPartPaid(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), "").Return(false, nil)
 			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPaid(gomock.Any(), gomock.Any(), gomock.Any(), "").Return(nil)
 
 			// the payable resource in the request context
 			model := buildMockedPayableResource(true, 150)
 			ctx := context.WithValue(context.Background(), config.PayableResource, model)
 
-			// stub kafka message
-			handleSendEmailKafkaMessage = mockSendEmailKafkaMessage
-			handlePaymentProcessingKafkaMessage = mockPaymentsProcessingKafkaMessage
+			// stub outbox message
+			getEmailOutboxMessage = mockEmailOutboxMessage
+			getPaymentProcessingOutboxMessage = mockPaymentProcessingOutboxMessage
 			getCompanyCodeFromTransaction = mockedGetCompanyCodeFromTransaction
 
 			reqBody := &models.PatchResourceRequest{Reference: "123"}
@@ -665,15 +1062,17 @@ func TestUnitPayResourceHandler(t *testing.T) {
 			dataModel := &models.PayableResourceDao{}
 			mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
 			mockPrDaoSvc.EXPECT().GetPayableResource(gomock.Any(), gomock.Any(), "").Return(dataModel, nil)
-			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, "").Times(1)
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(customerCode, "123", "123", "").Return(nil)
+			mockPrDaoSvc.EXPECT().UpdatePaymentDetails(dataModel, gomock.Any(), "").Times(1)
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPartPaid(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), "").Return(false, nil)
 			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPaid(gomock.Any(), gomock.Any(), gomock.Any(), "").Return(nil)
 
 			// the payable resource in the request context
 			model := buildMockedPayableResource(true, 150)
 			ctx := context.WithValue(context.Background(), config.PayableResource, model)
 
-			// stub kafka message
-			handleSendEmailKafkaMessage = mockSendEmailKafkaMessage
+			// stub outbox message
+			getEmailOutboxMessage = mockEmailOutboxMessage
 			getCompanyCodeFromTransaction = mockedGetCompanyCodeFromTransaction
 
 			reqBody := &models.PatchResourceRequest{Reference: "123"}
@@ -726,3 +1125,79 @@ func TestUnitPayResourceHandler(t *testing.T) {
 		})
 	})
 }
+
+func TestUnitUpdateAccountPenaltyAsPaid(t *testing.T) {
+	Convey("Given a payable resource for several penalties is paid", t, func() {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(mockCtrl)
+
+		getCompanyCodeFromTransaction = mockedGetCompanyCodeFromTransaction
+
+		resource := buildMockedPayableResource(false, 0)
+		resource.Transactions = []models.TransactionItem{
+			{PenaltyRef: "A0000001", Amount: 150},
+			{PenaltyRef: "A0000002", Amount: 250},
+		}
+
+		Convey("Then every penalty is marked as paid, even when marking one fails", func() {
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPartPaid(customerCode, testutils.LateFilingPenaltyCompanyCode, "A0000001", 150.0, "").
+				Return(false, nil)
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPaid(customerCode, testutils.LateFilingPenaltyCompanyCode, "A0000001", "").
+				Return(errors.New("error"))
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPartPaid(customerCode, testutils.LateFilingPenaltyCompanyCode, "A0000002", 250.0, "").
+				Return(false, nil)
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPaid(customerCode, testutils.LateFilingPenaltyCompanyCode, "A0000002", "").
+				Return(nil)
+
+			updateAccountPenaltyAsPaid(resource, mockApDaoSvc, "")
+		})
+
+		Convey("Then a penalty that was part paid is not marked as paid", func() {
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPartPaid(customerCode, testutils.LateFilingPenaltyCompanyCode, "A0000001", 150.0, "").
+				Return(true, nil)
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPartPaid(customerCode, testutils.LateFilingPenaltyCompanyCode, "A0000002", 250.0, "").
+				Return(false, nil)
+			mockApDaoSvc.EXPECT().UpdateAccountPenaltyAsPaid(customerCode, testutils.LateFilingPenaltyCompanyCode, "A0000002", "").
+				Return(nil)
+
+			updateAccountPenaltyAsPaid(resource, mockApDaoSvc, "")
+		})
+	})
+}
+
+func TestUnitMarkResourceAsPaid(t *testing.T) {
+	Convey("Given the recovery job marks a payable resource as paid with a paid payment", t, func() {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
+		mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(mockCtrl)
+
+		markAsPaid := MarkResourceAsPaid(&services.PayableResourceService{DAO: mockPrDaoSvc}, e5.NewClient("foo", "e5api", nil),
+			penaltyDetailsMap, allowedTransactionsMap, mockApDaoSvc, newTestPayableStatusProvider(t))
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		resource := buildMockedPayableResource(true, 150)
+		payment := &validators.PaymentInformation{PaymentID: "123", Reference: "financial_penalty_123", Status: "paid", Amount: "150"}
+
+		Convey("When the resource has been paid with the payment since it was read then nothing is run again", func() {
+			resource.Payment.Status = constants.Paid.String()
+			resource.Payment.Reference = "financial_penalty_123"
+			mockPrDaoSvc.EXPECT().GetFailedMarkAsPaidSteps(customerCode, "123", "").Return(nil, nil)
+
+			So(markAsPaid(req, resource, payment), ShouldBeNil)
+		})
+
+		Convey("When the resource has been paid with a different payment then an error is returned", func() {
+			resource.Payment.Status = constants.Paid.String()
+			resource.Payment.Reference = "financial_penalty_456"
+
+			So(errors.Is(markAsPaid(req, resource, payment), services.ErrAlreadyPaid), ShouldBeTrue)
+		})
+
+		Convey("When the payment does not pay the resource then an error is returned", func() {
+			payment.Amount = "5"
+
+			So(errors.Is(markAsPaid(req, resource, payment), errInvalidPayment), ShouldBeTrue)
+		})
+	})
+}
@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/services"
 	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
 )
@@ -29,3 +32,32 @@ func HandleGetPayableResource(w http.ResponseWriter, req *http.Request) {
 
 	log.InfoC(requestId, "GET payable resource request completed successfully")
 }
+
+// notPayableError returns services.ErrPayableResourceExpired if the payable resource was not paid within the lifetime
+// of its penalty type, or services.ErrPayableResourceCancelled if it has been cancelled
+func notPayableError(payableResource *models.PayableResource) error {
+	switch payableResource.Payment.Status {
+	case dao.PaymentStatusExpired:
+		return services.ErrPayableResourceExpired
+	case dao.PaymentStatusCancelled:
+		return services.ErrPayableResourceCancelled
+	default:
+		return nil
+	}
+}
+
+// writeNotPayable responds that the payable resource has expired or been cancelled, so a new one must be created to pay
+// the penalty
+func writeNotPayable(w http.ResponseWriter, req *http.Request, payableResource *models.PayableResource, err error) {
+	reason := "has expired"
+	if errors.Is(err, services.ErrPayableResourceCancelled) {
+		reason = "has been cancelled"
+	}
+
+	log.InfoC(log.Context(req), "payable resource "+reason, log.Data{
+		"customer_code": payableResource.CustomerCode,
+		"payable_ref":   payableResource.PayableRef,
+	})
+	m := models.NewMessageResponse("the payable resource " + reason + " and can no longer be paid, create a new payable resource to pay the penalty")
+	utils.WriteJSONWithStatus(w, req, m, http.StatusGone)
+}
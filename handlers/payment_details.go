@@ -1,17 +1,30 @@
 package handlers
 
 import (
+	"expvar"
 	"fmt"
 	"net/http"
 
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
 	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
 )
 
-// HandleGetPaymentDetails retrieves costs for a supplied company number and reference.
-func HandleGetPaymentDetails(penaltyDetailsMap *config.PenaltyDetailsMap) http.HandlerFunc {
+// paymentSessionHeader carries the ID of the payment session being created when the payment details of a payable
+// resource are got. It is part of the documented contract of the get-payment-details operation in the spec, and is
+// optional, so the caller that creates the payment session must be set up to send it.
+const paymentSessionHeader = "X-Payment-Session-Id"
+
+// paymentDetailsWithoutSession counts the payment details requests that did not say which payment session they were
+// for, so that a caller that does not send the header is noticed before a payment needs recovering
+var paymentDetailsWithoutSession = expvar.NewInt("payment_details_without_payment_session")
+
+// HandleGetPaymentDetails retrieves costs for a supplied company number and reference. The payment session the
+// payments API is creating is recorded against the resource, so that the recovery job can find the payment even if the
+// payments API never calls back to mark the resource as paid.
+func HandleGetPaymentDetails(prDaoService dao.PayableResourceDaoService, penaltyDetailsMap *config.PenaltyDetailsMap) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		requestId := log.Context(req)
 		log.InfoC(requestId, "start GET payment details request")
@@ -27,6 +40,11 @@ func HandleGetPaymentDetails(penaltyDetailsMap *config.PenaltyDetailsMap) http.H
 		}
 		log.DebugC(requestId, "got payable resource", log.Data{"payableResource": payableResource})
 
+		if err := notPayableError(payableResource); err != nil {
+			writeNotPayable(w, req, payableResource, err)
+			return
+		}
+
 		penaltyRefType, err := getPenaltyRefTypeFromTransaction(payableResource.Transactions)
 		if err != nil {
 			log.ErrorC(requestId, err)
@@ -51,6 +69,24 @@ func HandleGetPaymentDetails(penaltyDetailsMap *config.PenaltyDetailsMap) http.H
 			return
 		}
 		log.DebugC(requestId, "got payment details", log.Data{"paymentDetails": paymentDetails})
+
+		if paymentID := req.Header.Get(paymentSessionHeader); paymentID != "" {
+			// a failure is only logged, as it must not stop the payment session being created
+			err = prDaoService.RecordPaymentSession(payableResource.CustomerCode, payableResource.PayableRef, paymentID, requestId)
+			if err != nil {
+				log.ErrorC(requestId, fmt.Errorf("error recording payment session: [%v]", err), logContext)
+			}
+		} else {
+			// the session is still recorded when the payments API calls back, but cannot be recovered if it never does,
+			// so the resource is kept from being expired
+			paymentDetailsWithoutSession.Add(1)
+			log.InfoC(requestId, "no payment session given, it can only be recovered once the payments API calls back", logContext,
+				log.Data{"header": paymentSessionHeader})
+			err = prDaoService.RecordUnidentifiedPaymentSession(payableResource.CustomerCode, payableResource.PayableRef, requestId)
+			if err != nil {
+				log.ErrorC(requestId, fmt.Errorf("error recording unidentified payment session: [%v]", err), logContext)
+			}
+		}
 		utils.WriteJSON(w, req, paymentDetails)
 
 		log.InfoC(requestId, "GET payment details request completed successfully")
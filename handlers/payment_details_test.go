@@ -8,13 +8,22 @@ import (
 	"testing"
 	"time"
 
+	"github.com/companieshouse/api-sdk-go/companieshouseapi"
+	"github.com/companieshouse/go-session-handler/httpsession"
+	"github.com/companieshouse/go-session-handler/session"
 	"github.com/companieshouse/penalty-payment-api-core/models"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
+	"github.com/companieshouse/penalty-payment-api-core/validators"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
 	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/recovery"
+	"github.com/companieshouse/penalty-payment-api/testutils"
+	"github.com/golang/mock/gomock"
+	"github.com/jarcoal/httpmock"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-func serveGetPaymentDetailsHandler(payableResource *models.PayableResource) *httptest.ResponseRecorder {
+func serveGetPaymentDetailsHandler(t *testing.T, payableResource *models.PayableResource) *httptest.ResponseRecorder {
 	path := "/company/12345/penalties/payable/321"
 	req := httptest.NewRequest(http.MethodGet, path, nil)
 	res := httptest.NewRecorder()
@@ -24,8 +33,24 @@ func serveGetPaymentDetailsHandler(payableResource *models.PayableResource) *htt
 		req = req.WithContext(ctx)
 	}
 
+	// the request does not give a payment session, so one is recorded as unidentified when the details are returned
+	mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(gomock.NewController(t))
+	mockPrDaoSvc.EXPECT().RecordUnidentifiedPaymentSession(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
 	penaltyDetailsMap := &config.PenaltyDetailsMap{}
-	HandleGetPaymentDetails(penaltyDetailsMap).ServeHTTP(res, req)
+	HandleGetPaymentDetails(mockPrDaoSvc, penaltyDetailsMap).ServeHTTP(res, req)
+
+	return res
+}
+
+func serveGetPaymentDetailsHandlerForPaymentSession(prDaoService dao.PayableResourceDaoService,
+	payableResource *models.PayableResource, paymentID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, payableResource.Links.Payment, nil)
+	req.Header.Set(paymentSessionHeader, paymentID)
+	req = req.WithContext(context.WithValue(req.Context(), config.PayableResource, payableResource))
+	res := httptest.NewRecorder()
+
+	HandleGetPaymentDetails(prDaoService, &config.PenaltyDetailsMap{}).ServeHTTP(res, req)
 
 	return res
 }
@@ -71,9 +96,9 @@ func generateTestPayableResource(withTransaction bool, penaltyRef string) models
 
 func TestUnitHandleGetPaymentDetails(t *testing.T) {
 	Convey("No payable resource in request context", t, func() {
-		setGetPenaltyRefTypeFromTransactionMock(utils.LateFilingPenaltyRefType)
+		setGetPenaltyRefTypeFromTransactionMock(testutils.LateFilingPenaltyRefType)
 
-		res := serveGetPaymentDetailsHandler(nil)
+		res := serveGetPaymentDetailsHandler(t, nil)
 		So(res.Code, ShouldEqual, http.StatusBadRequest)
 	})
 
@@ -85,16 +110,36 @@ func TestUnitHandleGetPaymentDetails(t *testing.T) {
 
 		payable := generateTestPayableResource(false, "")
 
-		res := serveGetPaymentDetailsHandler(&payable)
+		res := serveGetPaymentDetailsHandler(t, &payable)
 		So(res.Code, ShouldEqual, http.StatusBadRequest)
 	})
 
+	Convey("Payable resource has expired", t, func() {
+		setGetPenaltyRefTypeFromTransactionMock(testutils.LateFilingPenaltyRefType)
+
+		payable := generateTestPayableResource(true, "A1234567")
+		payable.Payment.Status = dao.PaymentStatusExpired
+
+		res := serveGetPaymentDetailsHandler(t, &payable)
+		So(res.Code, ShouldEqual, http.StatusGone)
+	})
+
+	Convey("Payable resource has been cancelled", t, func() {
+		setGetPenaltyRefTypeFromTransactionMock(testutils.LateFilingPenaltyRefType)
+
+		payable := generateTestPayableResource(true, "A1234567")
+		payable.Payment.Status = dao.PaymentStatusCancelled
+
+		res := serveGetPaymentDetailsHandler(t, &payable)
+		So(res.Code, ShouldEqual, http.StatusGone)
+	})
+
 	Convey("Payment PenaltyDetails not found due to no costs", t, func() {
-		setGetPenaltyRefTypeFromTransactionMock(utils.SanctionsPenaltyRefType)
+		setGetPenaltyRefTypeFromTransactionMock(testutils.SanctionsPenaltyRefType)
 
 		payable := generateTestPayableResource(false, "")
 
-		res := serveGetPaymentDetailsHandler(&payable)
+		res := serveGetPaymentDetailsHandler(t, &payable)
 		So(res.Code, ShouldEqual, http.StatusNotFound)
 	})
 
@@ -107,20 +152,20 @@ func TestUnitHandleGetPaymentDetails(t *testing.T) {
 		}{
 			{
 				name:           "Late Filing",
-				companyCode:    utils.LateFilingPenaltyCompanyCode,
-				penaltyRefType: utils.LateFilingPenaltyRefType,
+				companyCode:    testutils.LateFilingPenaltyCompanyCode,
+				penaltyRefType: testutils.LateFilingPenaltyRefType,
 				penaltyRef:     "A1234567",
 			},
 			{
 				name:           "Sanctions",
-				companyCode:    utils.SanctionsCompanyCode,
-				penaltyRefType: utils.SanctionsPenaltyRefType,
+				companyCode:    testutils.SanctionsCompanyCode,
+				penaltyRefType: testutils.SanctionsPenaltyRefType,
 				penaltyRef:     "P1234567",
 			},
 			{
 				name:           "Sanctions ROE",
-				companyCode:    utils.SanctionsCompanyCode,
-				penaltyRefType: utils.SanctionsRoePenaltyRefType,
+				companyCode:    testutils.SanctionsCompanyCode,
+				penaltyRefType: testutils.SanctionsRoePenaltyRefType,
 				penaltyRef:     "U1234567",
 			},
 		}
@@ -131,9 +176,98 @@ func TestUnitHandleGetPaymentDetails(t *testing.T) {
 
 				payable := generateTestPayableResource(true, tc.penaltyRef)
 
-				res := serveGetPaymentDetailsHandler(&payable)
+				res := serveGetPaymentDetailsHandler(t, &payable)
 				So(res.Code, ShouldEqual, http.StatusOK)
 			})
 		}
 	})
 }
+
+func TestUnitHandleGetPaymentDetails_PaymentSession(t *testing.T) {
+	Convey("Given the payments API gets the payment details to create a payment session", t, func() {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+		setGetPenaltyRefTypeFromTransactionMock(testutils.LateFilingPenaltyRefType)
+		mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
+		payable := generateTestPayableResource(true, "A1234567")
+
+		Convey("When the payment session cannot be recorded then the payment details are still returned", func() {
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(payable.CustomerCode, payable.PayableRef, "P123", gomock.Any()).
+				Return(errors.New("server selection timeout"))
+
+			res := serveGetPaymentDetailsHandlerForPaymentSession(mockPrDaoSvc, &payable, "P123")
+
+			So(res.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("When the payments API never calls back then the recovery job marks the resource as paid with the session", func() {
+			var recorded []string
+			mockPrDaoSvc.EXPECT().RecordPaymentSession(payable.CustomerCode, payable.PayableRef, "P123", gomock.Any()).
+				DoAndReturn(func(_, _, paymentID, _ string) error {
+					recorded = append(recorded, paymentID)
+					return nil
+				})
+
+			res := serveGetPaymentDetailsHandlerForPaymentSession(mockPrDaoSvc, &payable, "P123")
+			So(res.Code, ShouldEqual, http.StatusOK)
+
+			// the payment session is paid, but the callback to mark the resource as paid never arrives
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+			responder, _ := httpmock.NewJsonResponder(http.StatusOK, buildMockedPaymentResource("paid", "5"))
+			httpmock.RegisterResponder(http.MethodGet, companieshouseapi.PaymentsBasePath+"/payments/P123", responder)
+			httpmock.RegisterResponder(http.MethodGet, companieshouseapi.PaymentsBasePath+"/private/payments/P123/payment-details",
+				httpmock.NewStringResponder(http.StatusOK, "{}"))
+
+			createdAt := time.Now().Add(-2 * time.Hour)
+			pending := models.PayableResourceDao{
+				CustomerCode: payable.CustomerCode,
+				PayableRef:   payable.PayableRef,
+				Data: models.PayableResourceDataDao{
+					CreatedAt: &createdAt,
+					Links:     models.PayableResourceLinksDao{Payment: payable.Links.Payment},
+				},
+			}
+			mockPrDaoSvc.EXPECT().GetStalePendingPayableResources(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+				Return([]models.PayableResourceDao{pending}, nil)
+			mockPrDaoSvc.EXPECT().GetPaymentSessions(payable.CustomerCode, payable.PayableRef, gomock.Any()).
+				DoAndReturn(func(_, _, _ string) ([]string, error) {
+					return recorded, nil
+				})
+
+			var markedAsPaidWith []string
+			markAsPaid := func(_ *http.Request, _ *models.PayableResource, payment *validators.PaymentInformation) error {
+				markedAsPaidWith = append(markedAsPaidWith, payment.PaymentID)
+				return nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/penalty-payment-api/admin/recovery", nil)
+			req = req.WithContext(context.WithValue(req.Context(), httpsession.ContextKeySession, &session.Session{}))
+			report, err := recovery.NewJob(mockPrDaoSvc, markAsPaid, time.Hour).Run(req, recovery.Options{})
+
+			So(err, ShouldBeNil)
+			So(report.Recovered, ShouldEqual, 1)
+			So(report.Resources[0].Result, ShouldEqual, recovery.Recovered)
+			So(markedAsPaidWith, ShouldResemble, []string{"P123"})
+		})
+
+		Convey("When no payment session is given then it is recorded as unidentified and the request is counted", func() {
+			before := paymentDetailsWithoutSession.Value()
+			mockPrDaoSvc.EXPECT().RecordUnidentifiedPaymentSession(payable.CustomerCode, payable.PayableRef, gomock.Any()).Return(nil)
+
+			res := serveGetPaymentDetailsHandlerForPaymentSession(mockPrDaoSvc, &payable, "")
+
+			So(res.Code, ShouldEqual, http.StatusOK)
+			So(paymentDetailsWithoutSession.Value(), ShouldEqual, before+1)
+		})
+
+		Convey("When the unidentified payment session cannot be recorded then the payment details are still returned", func() {
+			mockPrDaoSvc.EXPECT().RecordUnidentifiedPaymentSession(payable.CustomerCode, payable.PayableRef, gomock.Any()).
+				Return(errors.New("server selection timeout"))
+
+			res := serveGetPaymentDetailsHandlerForPaymentSession(mockPrDaoSvc, &payable, "")
+
+			So(res.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
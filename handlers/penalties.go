@@ -20,7 +20,7 @@ var accountPenalties = api.AccountPenalties
 
 // HandleGetPenalties retrieves the penalty details for the supplied customer code from e5
 func HandleGetPenalties(apDaoSvc dao.AccountPenaltiesDaoService, penaltyDetailsMap *config.PenaltyDetailsMap,
-	allowedTransactionsMap *models.AllowedTransactionMap) http.HandlerFunc {
+	allowedTransactionsMap *models.AllowedTransactionMap, payableStatusProvider types.PayableStatusProvider) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		requestId := log.Context(req)
 		log.InfoC(requestId, "start GET penalties request")
@@ -31,7 +31,13 @@ func HandleGetPenalties(apDaoSvc dao.AccountPenaltiesDaoService, penaltyDetailsM
 		vars := mux.Vars(req)
 		// the penalty reference type is needed further on in the generate_transaction_list to get
 		// the ResourceKind from the penalty_details.yaml
-		penaltyRefType := GetPenaltyRefType(vars["penalty_reference_type"])
+		penaltyRefType, err := GetPenaltyRefType(vars["penalty_reference_type"])
+		if err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error getting default penalty reference type: %v", err))
+			m := models.NewMessageResponse("there was a problem handling your request")
+			utils.WriteJSONWithStatus(w, req, m, http.StatusInternalServerError)
+			return
+		}
 		companyCode, err := getCompanyCode(penaltyRefType)
 
 		if err != nil {
@@ -43,12 +49,14 @@ func HandleGetPenalties(apDaoSvc dao.AccountPenaltiesDaoService, penaltyDetailsM
 
 		// Call service layer to handle request to E5
 		params := types.AccountPenaltiesParams{
+			Context:                    req.Context(),
 			PenaltyRefType:             penaltyRefType,
 			CustomerCode:               customerCode,
 			CompanyCode:                companyCode,
 			PenaltyDetailsMap:          penaltyDetailsMap,
 			AllowedTransactionsMap:     allowedTransactionsMap,
 			AccountPenaltiesDaoService: apDaoSvc,
+			PayableStatusProvider:      payableStatusProvider,
 			RequestId:                  requestId,
 		}
 		transactionListResponse, responseType, err := accountPenalties(params)
@@ -60,6 +68,10 @@ func HandleGetPenalties(apDaoSvc dao.AccountPenaltiesDaoService, penaltyDetailsM
 				m := models.NewMessageResponse("failed to read finance transactions")
 				utils.WriteJSONWithStatus(w, req, m, http.StatusBadRequest)
 				return
+			case services.Unavailable:
+				m := models.NewMessageResponse("the finance system is currently unavailable")
+				utils.WriteJSONWithStatus(w, req, m, http.StatusServiceUnavailable)
+				return
 			default:
 				m := models.NewMessageResponse("there was a problem communicating with the finance backend")
 				utils.WriteJSONWithStatus(w, req, m, http.StatusInternalServerError)
@@ -81,11 +93,16 @@ func HandleGetPenalties(apDaoSvc dao.AccountPenaltiesDaoService, penaltyDetailsM
 
 // GetPenaltyRefType gets the penalty reference type from the url vars
 // If no penalty reference type is supplied then the request is coming in on the old url
-// so defaulting to LateFiling until agreement is made to update other services calling the api
-func GetPenaltyRefType(penaltyRefType string) string {
-	if len(penaltyRefType) == 0 {
-		return utils.LateFilingPenaltyRefType
-	} else {
-		return penaltyRefType
+// so defaulting to the default penalty reference type of the registry until agreement is made to update other
+// services calling the api
+func GetPenaltyRefType(penaltyRefType string) (string, error) {
+	if len(penaltyRefType) > 0 {
+		return penaltyRefType, nil
+	}
+
+	penaltyTypes, err := config.GetPenaltyTypes()
+	if err != nil {
+		return "", err
 	}
+	return penaltyTypes.DefaultRefType()
 }
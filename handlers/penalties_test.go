@@ -9,9 +9,9 @@ import (
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api/common/services"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -39,11 +39,14 @@ func TestUnitHandleGetPenalties(t *testing.T) {
 			if customerCode == "INTERNAL_SERVER_ERROR" {
 				return nil, services.NotFound, errors.New("error getting penalties")
 			}
+			if customerCode == "UNAVAILABLE" {
+				return nil, services.Unavailable, errors.New("error getting penalties")
+			}
 			return nil, services.Success, nil
 		}
 
 		mockedGetCompanyCode := func(penaltyRefType string) (string, error) {
-			return utils.LateFilingPenaltyCompanyCode, nil
+			return testutils.LateFilingPenaltyCompanyCode, nil
 		}
 
 		testCases := []struct {
@@ -53,6 +56,7 @@ func TestUnitHandleGetPenalties(t *testing.T) {
 			{companyCode: "NI123546", response: http.StatusOK},
 			{companyCode: "INVALID_DATA", response: http.StatusBadRequest},
 			{companyCode: "INTERNAL_SERVER_ERROR", response: http.StatusInternalServerError},
+			{companyCode: "UNAVAILABLE", response: http.StatusServiceUnavailable},
 		}
 
 		getCompanyCode = mockedGetCompanyCode
@@ -63,7 +67,7 @@ func TestUnitHandleGetPenalties(t *testing.T) {
 			req := buildGetPenaltiesRequest(tc.companyCode)
 			rr := httptest.NewRecorder()
 
-			handler := HandleGetPenalties(nil, penaltyDetailsMap, allowedTransactionsMap)
+			handler := HandleGetPenalties(nil, penaltyDetailsMap, allowedTransactionsMap, newTestPayableStatusProvider(t))
 			handler.ServeHTTP(rr, req)
 
 			So(rr.Code, ShouldEqual, tc.response)
@@ -77,7 +81,7 @@ func TestUnitHandleGetPenalties(t *testing.T) {
 		rr := httptest.NewRecorder()
 		req := buildGetPenaltiesRequest("NI123546")
 
-		handler := HandleGetPenalties(nil, penaltyDetailsMap, allowedTransactionsMap)
+		handler := HandleGetPenalties(nil, penaltyDetailsMap, allowedTransactionsMap, newTestPayableStatusProvider(t))
 		handler.ServeHTTP(rr, req)
 
 		So(rr.Code, ShouldEqual, http.StatusBadRequest)
@@ -94,29 +98,30 @@ func TestUnitHandleGetPenaltyRefType(t *testing.T) {
 			{
 				name:                   "Empty",
 				input:                  "",
-				expectedPenaltyRefType: utils.LateFilingPenaltyRefType,
+				expectedPenaltyRefType: testutils.LateFilingPenaltyRefType,
 			},
 			{
 				name:                   "Late Filing",
-				input:                  utils.LateFilingPenaltyRefType,
-				expectedPenaltyRefType: utils.LateFilingPenaltyRefType,
+				input:                  testutils.LateFilingPenaltyRefType,
+				expectedPenaltyRefType: testutils.LateFilingPenaltyRefType,
 			},
 			{
 				name:                   "Sanctions",
-				input:                  utils.SanctionsPenaltyRefType,
-				expectedPenaltyRefType: utils.SanctionsPenaltyRefType,
+				input:                  testutils.SanctionsPenaltyRefType,
+				expectedPenaltyRefType: testutils.SanctionsPenaltyRefType,
 			},
 			{
 				name:                   "Sanctions ROE",
-				input:                  utils.SanctionsRoePenaltyRefType,
-				expectedPenaltyRefType: utils.SanctionsRoePenaltyRefType,
+				input:                  testutils.SanctionsRoePenaltyRefType,
+				expectedPenaltyRefType: testutils.SanctionsRoePenaltyRefType,
 			},
 		}
 
 		for _, tc := range testCases {
 			Convey(tc.name, func() {
-				penaltyRefType := GetPenaltyRefType(tc.input)
+				penaltyRefType, err := GetPenaltyRefType(tc.input)
 				Convey(tc.expectedPenaltyRefType, func() {
+					So(err, ShouldBeNil)
 					So(penaltyRefType, ShouldEqual, tc.expectedPenaltyRefType)
 				})
 			})
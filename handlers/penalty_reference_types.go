@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/utils"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
+)
+
+var penaltyReferenceTypes = api.PenaltyReferenceTypes
+
+// HandleGetPenaltyReferenceTypes lists the penalty reference types supported by the API
+func HandleGetPenaltyReferenceTypes(penaltyDetailsMap *config.PenaltyDetailsMap,
+	allowedTransactionsMap *models.AllowedTransactionMap) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		requestId := log.Context(req)
+		log.InfoC(requestId, "start GET penalty reference types request")
+
+		penaltyRefTypes, err := penaltyReferenceTypes(penaltyDetailsMap, allowedTransactionsMap, requestId)
+		if err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error getting penalty reference types: [%v]", err))
+			m := models.NewMessageResponse("there was a problem accessing configuration data")
+			utils.WriteJSONWithStatus(w, req, m, http.StatusInternalServerError)
+			return
+		}
+
+		utils.WriteJSON(w, req, penaltyRefTypes)
+
+		log.InfoC(requestId, "GET penalty reference types request completed successfully")
+	}
+}
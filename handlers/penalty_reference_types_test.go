@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+	"github.com/companieshouse/penalty-payment-api/testutils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitHandleGetPenaltyReferenceTypes(t *testing.T) {
+	Convey("Given a request to get penalty reference types", t, func() {
+		req := httptest.NewRequest(http.MethodGet, "/penalty-payment-api/penalty-reference-types", nil)
+		rr := httptest.NewRecorder()
+		defer func() { penaltyReferenceTypes = api.PenaltyReferenceTypes }()
+
+		Convey("When the penalty reference types are built successfully", func() {
+			expected := []types.PenaltyReferenceType{
+				{
+					ReferenceType:       testutils.LateFilingPenaltyRefType,
+					Description:         "Late Filing Penalty",
+					CompanyCode:         testutils.LateFilingPenaltyCompanyCode,
+					ReferenceStartsWith: testutils.LateFilingPenaltyReferencePrefix,
+					ResourceKind:        "late-filing-penalty#late-filing-penalty",
+					Enabled:             true,
+				},
+			}
+			penaltyReferenceTypes = func(penaltyDetailsMap *config.PenaltyDetailsMap,
+				allowedTransactionsMap *models.AllowedTransactionMap, requestId string) ([]types.PenaltyReferenceType, error) {
+				return expected, nil
+			}
+
+			HandleGetPenaltyReferenceTypes(&config.PenaltyDetailsMap{}, &models.AllowedTransactionMap{}).ServeHTTP(rr, req)
+
+			Convey("Then the penalty reference types are returned", func() {
+				So(rr.Code, ShouldEqual, http.StatusOK)
+				var body []types.PenaltyReferenceType
+				So(json.Unmarshal(rr.Body.Bytes(), &body), ShouldBeNil)
+				So(body, ShouldResemble, expected)
+			})
+		})
+
+		Convey("When building the penalty reference types fails", func() {
+			penaltyReferenceTypes = func(penaltyDetailsMap *config.PenaltyDetailsMap,
+				allowedTransactionsMap *models.AllowedTransactionMap, requestId string) ([]types.PenaltyReferenceType, error) {
+				return nil, errors.New("error getting config")
+			}
+
+			HandleGetPenaltyReferenceTypes(&config.PenaltyDetailsMap{}, &models.AllowedTransactionMap{}).ServeHTTP(rr, req)
+
+			Convey("Then an internal server error is returned", func() {
+				So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+	})
+}
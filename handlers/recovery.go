@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/utils"
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/recovery"
+)
+
+// recoveryRequest is the optional body of a request to run the recovery job
+type recoveryRequest struct {
+	DryRun          bool                `json:"dry_run"`
+	MinAge          string              `json:"min_age"`
+	PaymentSessions map[string][]string `json:"payment_sessions"`
+}
+
+// RecoveryJob runs the recovery job, so that it can be stubbed in tests
+type RecoveryJob interface {
+	Run(req *http.Request, opts recovery.Options) (*recovery.Report, error)
+}
+
+// HandleRecoverPayableResources runs the recovery job, marking as paid the payable resources that have been pending
+// for longer than the minimum age but have a paid payment session, and returns a report of what it found. It is only
+// available to internal API keys with elevated privileges, and is for running the job on demand.
+func HandleRecoverPayableResources(job RecoveryJob) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		requestId := log.Context(req)
+		log.InfoC(requestId, "start POST recovery request")
+
+		var request recoveryRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			log.ErrorC(requestId, err)
+			m := models.NewMessageResponse("there was a problem reading the request body")
+			utils.WriteJSONWithStatus(w, req, m, http.StatusBadRequest)
+			return
+		}
+
+		opts := recovery.Options{DryRun: request.DryRun, PaymentSessions: request.PaymentSessions}
+		if request.MinAge != "" {
+			minAge, err := time.ParseDuration(request.MinAge)
+			if err != nil || minAge <= 0 {
+				m := models.NewMessageResponse("min_age must be a positive duration e.g. 1h")
+				utils.WriteJSONWithStatus(w, req, m, http.StatusBadRequest)
+				return
+			}
+			opts.MinAge = minAge
+		}
+
+		report, err := job.Run(req, opts)
+		if err != nil {
+			log.ErrorC(requestId, fmt.Errorf("error running recovery job: %v", err))
+			m := models.NewMessageResponse("there was a problem handling your request")
+			utils.WriteJSONWithStatus(w, req, m, http.StatusInternalServerError)
+			return
+		}
+
+		utils.WriteJSON(w, req, report)
+
+		log.InfoC(requestId, "POST recovery request completed successfully", log.Data{
+			"dry_run":   report.DryRun,
+			"checked":   report.Checked,
+			"recovered": report.Recovered,
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/recovery"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recoveryJobStub records the options the recovery job was run with
+type recoveryJobStub struct {
+	report *recovery.Report
+	err    error
+	opts   []recovery.Options
+}
+
+func (s *recoveryJobStub) Run(_ *http.Request, opts recovery.Options) (*recovery.Report, error) {
+	s.opts = append(s.opts, opts)
+	return s.report, s.err
+}
+
+func serveRecovery(job RecoveryJob, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/penalty-payment-api/admin/recovery", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	HandleRecoverPayableResources(job).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestUnitHandleRecoverPayableResources(t *testing.T) {
+	Convey("Given a request to run the recovery job", t, func() {
+		job := &recoveryJobStub{report: &recovery.Report{
+			Checked:   1,
+			Recovered: 1,
+			Resources: []recovery.ResourceReport{{PayableRef: "XP1", Result: recovery.Recovered}},
+		}}
+
+		Convey("When there is no body then the job is run with the defaults and the report returned", func() {
+			rr := serveRecovery(job, "")
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(job.opts, ShouldResemble, []recovery.Options{{}})
+			var report recovery.Report
+			So(json.Unmarshal(rr.Body.Bytes(), &report), ShouldBeNil)
+			So(report.Recovered, ShouldEqual, 1)
+			So(report.Resources[0].Result, ShouldEqual, recovery.Recovered)
+		})
+
+		Convey("When options are given then the job is run with them", func() {
+			rr := serveRecovery(job, `{"dry_run": true, "min_age": "24h", "payment_sessions": {"XP1": ["P123"]}}`)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(job.opts, ShouldResemble, []recovery.Options{{
+				DryRun:          true,
+				MinAge:          24 * time.Hour,
+				PaymentSessions: map[string][]string{"XP1": {"P123"}},
+			}})
+		})
+
+		Convey("When the min age is invalid then bad request is returned", func() {
+			rr := serveRecovery(job, `{"min_age": "-1h"}`)
+
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+			So(job.opts, ShouldBeEmpty)
+		})
+
+		Convey("When the body is invalid then bad request is returned", func() {
+			rr := serveRecovery(job, `{"dry_run": "yes"}`)
+
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+			So(job.opts, ShouldBeEmpty)
+		})
+
+		Convey("When the job fails then internal server error is returned", func() {
+			job.report = nil
+			job.err = errors.New("server selection timeout")
+
+			rr := serveRecovery(job, "")
+
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/companieshouse/chs.go/authentication"
@@ -10,18 +11,22 @@ import (
 	"github.com/companieshouse/penalty-payment-api/common/e5"
 	"github.com/companieshouse/penalty-payment-api/common/services"
 	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 	"github.com/companieshouse/penalty-payment-api/middleware"
 	"github.com/companieshouse/penalty-payment-api/penalty_payments/interceptors"
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/recovery"
 	"github.com/companieshouse/penalty-payment-api/penalty_payments/service"
 	"github.com/gorilla/mux"
 )
 
 var payableResourceService *services.PayableResourceService
 
-// Register defines the route mappings for the main router and it's subrouters
+// Register defines the route mappings for the main router and it's subrouters. It returns the recovery job, which
+// marks resources as paid through the mark-as-paid handler, so that it can also be run on a schedule.
 func Register(mainRouter *mux.Router, cfg *config.Config, prDaoService dao.PayableResourceDaoService,
-	apDaoService dao.AccountPenaltiesDaoService, penaltyDetailsMap *config.PenaltyDetailsMap,
-	allowedTransactionsMap *models.AllowedTransactionMap) {
+	apDaoService dao.AccountPenaltiesDaoService, dlDaoService dao.DeadLetterDaoService, penaltyDetailsMap *config.PenaltyDetailsMap,
+	allowedTransactionsMap *models.AllowedTransactionMap, e5Client e5.ClientInterface,
+	payableStatusProvider types.PayableStatusProvider) *recovery.Job {
 
 	payableResourceService = &services.PayableResourceService{
 		Config: cfg,
@@ -43,8 +48,6 @@ func Register(mainRouter *mux.Router, cfg *config.Config, prDaoService dao.Payab
 		},
 	}
 
-	e5Client := e5.NewClient(cfg.E5Username, cfg.E5APIURL)
-
 	userAuthInterceptor := &authentication.UserAuthenticationInterceptor{
 		AllowAPIKeyUser:                true,
 		RequireElevatedAPIKeyPrivilege: true,
@@ -52,11 +55,27 @@ func Register(mainRouter *mux.Router, cfg *config.Config, prDaoService dao.Payab
 
 	mainRouter.HandleFunc("/penalty-payment-api/healthcheck", healthCheck).Methods(http.MethodGet).Name("healthcheck")
 	mainRouter.HandleFunc("/penalty-payment-api/healthcheck/finance-system", HandleHealthCheckFinanceSystem).Methods(http.MethodGet).Name("healthcheck-finance-system")
+	mainRouter.HandleFunc("/penalty-payment-api/healthcheck/kafka", HandleHealthCheckKafka).Methods(http.MethodGet).Name("healthcheck-kafka")
+	mainRouter.HandleFunc("/penalty-payment-api/penalty-reference-types", HandleGetPenaltyReferenceTypes(penaltyDetailsMap, allowedTransactionsMap)).Methods(http.MethodGet).Name("get-penalty-ref-types")
+
+	// the recovery job marks resources as paid with the same logic as the payments API callback
+	markAsPaid := MarkResourceAsPaid(payableResourceService, e5Client, penaltyDetailsMap, allowedTransactionsMap, apDaoService, payableStatusProvider)
+	recoveryJob := recovery.NewJob(prDaoService, markAsPaid, cfg.GetRecoveryMinAge())
+
+	// internal endpoints only available to API keys with elevated privileges
+	adminRouter := mainRouter.PathPrefix("/penalty-payment-api/admin").Subrouter()
+	adminRouter.Handle("/metrics", expvar.Handler()).Methods(http.MethodGet).Name("metrics")
+	adminRouter.HandleFunc("/penalties/{company_code}", HandleGetCompanyPenalties(e5Client, allowedTransactionsMap)).Methods(http.MethodGet).Name("get-company-penalties")
+	adminRouter.HandleFunc("/dead-letters", HandleGetDeadLetters(dlDaoService)).Methods(http.MethodGet).Name("get-dead-letters")
+	adminRouter.HandleFunc("/dead-letters/{dead_letter_id}", HandleGetDeadLetter(dlDaoService)).Methods(http.MethodGet).Name("get-dead-letter")
+	adminRouter.HandleFunc("/dead-letters/{dead_letter_id}/replay", HandleReplayDeadLetter(dlDaoService)).Methods(http.MethodPost).Name("replay-dead-letter")
+	adminRouter.HandleFunc("/recovery", HandleRecoverPayableResources(recoveryJob)).Methods(http.MethodPost).Name("recover-payable-resources")
+	adminRouter.Use(userAuthInterceptor.UserAuthenticationIntercept, authentication.ElevatedPrivilegesInterceptor)
 
 	appRouter := mainRouter.PathPrefix("/company/{customer_code}").Subrouter()
-	appRouter.HandleFunc("/penalties/late-filing", HandleGetPenalties(apDaoService, penaltyDetailsMap, allowedTransactionsMap)).Methods(http.MethodGet).Name("get-penalties-legacy")
-	appRouter.HandleFunc("/penalties/{penalty_reference_type}", HandleGetPenalties(apDaoService, penaltyDetailsMap, allowedTransactionsMap)).Methods(http.MethodGet).Name("get-penalties")
-	appRouter.Handle("/penalties/payable", CreatePayableResourceHandler(prDaoService, apDaoService, penaltyDetailsMap, allowedTransactionsMap)).Methods(http.MethodPost).Name("create-payable")
+	appRouter.HandleFunc("/penalties/late-filing", HandleGetPenalties(apDaoService, penaltyDetailsMap, allowedTransactionsMap, payableStatusProvider)).Methods(http.MethodGet).Name("get-penalties-legacy")
+	appRouter.HandleFunc("/penalties/{penalty_reference_type}", HandleGetPenalties(apDaoService, penaltyDetailsMap, allowedTransactionsMap, payableStatusProvider)).Methods(http.MethodGet).Name("get-penalties")
+	appRouter.Handle("/penalties/payable", CreatePayableResourceHandler(prDaoService, apDaoService, penaltyDetailsMap, allowedTransactionsMap, payableStatusProvider)).Methods(http.MethodPost).Name("create-payable")
 	appRouter.Use(
 		oauth2OnlyInterceptor.OAuth2OnlyAuthenticationIntercept,
 		userAuthInterceptor.UserAuthenticationIntercept,
@@ -67,17 +86,20 @@ func Register(mainRouter *mux.Router, cfg *config.Config, prDaoService dao.Payab
 	// PayableAuthenticationInterceptor
 	existingPayableRouter := appRouter.PathPrefix("/penalties/payable/{payable_ref}").Subrouter()
 	existingPayableRouter.HandleFunc("", HandleGetPayableResource).Name("get-payable").Methods(http.MethodGet)
-	existingPayableRouter.HandleFunc("/payment", HandleGetPaymentDetails(penaltyDetailsMap)).Methods(http.MethodGet).Name("get-payment-details")
+	existingPayableRouter.Handle("", CancelPayableResourceHandler(payableResourceService)).Methods(http.MethodDelete).Name("cancel-payable")
+	existingPayableRouter.HandleFunc("/payment", HandleGetPaymentDetails(prDaoService, penaltyDetailsMap)).Methods(http.MethodGet).Name("get-payment-details")
 	existingPayableRouter.Use(payableAuthInterceptor.PayableAuthenticationIntercept)
 
 	// separate router for the patch request so that we can apply the interceptor to it without interfering with
 	// other routes
 	payResourceRouter := appRouter.PathPrefix("/penalties/payable/{payable_ref}/payment").Methods(http.MethodPatch).Subrouter()
 	payResourceRouter.Use(payableAuthInterceptor.PayableAuthenticationIntercept, authentication.ElevatedPrivilegesInterceptor)
-	payResourceRouter.Handle("", PayResourceHandler(payableResourceService, e5Client, penaltyDetailsMap, allowedTransactionsMap, apDaoService)).Name("mark-as-paid")
+	payResourceRouter.Handle("", PayResourceHandler(payableResourceService, e5Client, penaltyDetailsMap, allowedTransactionsMap, apDaoService, payableStatusProvider)).Name("mark-as-paid")
 
 	// Set middleware across all routers and sub routers
 	mainRouter.Use(log.Handler)
+
+	return recoveryJob
 }
 
 func healthCheck(w http.ResponseWriter, _ *http.Request) {
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/companieshouse/penalty-payment-api/common/e5"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/mocks"
 	"github.com/golang/mock/gomock"
@@ -22,28 +23,69 @@ func TestUnitRegisterRoutes(t *testing.T) {
 
 		mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
 		mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(mockCtrl)
-		Register(router, &config.Config{}, mockPrDaoSvc, mockApDaoSvc, penaltyDetailsMap, allowedTransactionsMap)
+		mockDlDaoSvc := mocks.NewMockDeadLetterDaoService(mockCtrl)
+		Register(router, &config.Config{}, mockPrDaoSvc, mockApDaoSvc, mockDlDaoSvc, penaltyDetailsMap, allowedTransactionsMap, &e5.Client{}, newTestPayableStatusProvider(t))
 
 		healthCheckPath, _ := router.GetRoute("healthcheck").GetPathTemplate()
 		healthFinanceCheckPath, _ := router.GetRoute("healthcheck-finance-system").GetPathTemplate()
+		healthKafkaCheckPath, _ := router.GetRoute("healthcheck-kafka").GetPathTemplate()
+		metricsPath, _ := router.GetRoute("metrics").GetPathTemplate()
+		getPenaltyRefTypesPath, _ := router.GetRoute("get-penalty-ref-types").GetPathTemplate()
+		getCompanyPenaltiesPath, _ := router.GetRoute("get-company-penalties").GetPathTemplate()
+		getDeadLettersPath, _ := router.GetRoute("get-dead-letters").GetPathTemplate()
+		getDeadLetterPath, _ := router.GetRoute("get-dead-letter").GetPathTemplate()
+		replayDeadLetterPath, _ := router.GetRoute("replay-dead-letter").GetPathTemplate()
+		recoverPayableResourcesPath, _ := router.GetRoute("recover-payable-resources").GetPathTemplate()
 		getPenaltiesPath, _ := router.GetRoute("get-penalties").GetPathTemplate()
 		getPenaltiesOriginalPath, _ := router.GetRoute("get-penalties-legacy").GetPathTemplate()
 		createPayablePath, _ := router.GetRoute("create-payable").GetPathTemplate()
 		getPayablePath, _ := router.GetRoute("get-payable").GetPathTemplate()
+		cancelPayablePath, _ := router.GetRoute("cancel-payable").GetPathTemplate()
 		getPaymentDetailsPath, _ := router.GetRoute("get-payment-details").GetPathTemplate()
 		markAsPaidPath, _ := router.GetRoute("mark-as-paid").GetPathTemplate()
 
 		So(healthCheckPath, ShouldEqual, "/penalty-payment-api/healthcheck")
 		So(healthFinanceCheckPath, ShouldEqual, "/penalty-payment-api/healthcheck/finance-system")
+		So(healthKafkaCheckPath, ShouldEqual, "/penalty-payment-api/healthcheck/kafka")
+		So(metricsPath, ShouldEqual, "/penalty-payment-api/admin/metrics")
+		So(getPenaltyRefTypesPath, ShouldEqual, "/penalty-payment-api/penalty-reference-types")
+		So(getCompanyPenaltiesPath, ShouldEqual, "/penalty-payment-api/admin/penalties/{company_code}")
+		So(getDeadLettersPath, ShouldEqual, "/penalty-payment-api/admin/dead-letters")
+		So(getDeadLetterPath, ShouldEqual, "/penalty-payment-api/admin/dead-letters/{dead_letter_id}")
+		So(replayDeadLetterPath, ShouldEqual, "/penalty-payment-api/admin/dead-letters/{dead_letter_id}/replay")
+		So(recoverPayableResourcesPath, ShouldEqual, "/penalty-payment-api/admin/recovery")
 		So(getPenaltiesPath, ShouldEqual, "/company/{customer_code}/penalties/{penalty_reference_type}")
 		So(getPenaltiesOriginalPath, ShouldEqual, "/company/{customer_code}/penalties/late-filing")
 		So(createPayablePath, ShouldEqual, "/company/{customer_code}/penalties/payable")
 		So(getPayablePath, ShouldEqual, "/company/{customer_code}/penalties/payable/{payable_ref}")
+		So(cancelPayablePath, ShouldEqual, "/company/{customer_code}/penalties/payable/{payable_ref}")
 		So(getPaymentDetailsPath, ShouldEqual, "/company/{customer_code}/penalties/payable/{payable_ref}/payment")
 		So(markAsPaidPath, ShouldEqual, "/company/{customer_code}/penalties/payable/{payable_ref}/payment")
 	})
 }
 
+func TestUnitRegisterRoutes_MetricsNeedAuthentication(t *testing.T) {
+	Convey("Given the metrics are requested without an identity", t, func() {
+		penaltyDetailsMap = &config.PenaltyDetailsMap{}
+		router := mux.NewRouter()
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+
+		Register(router, &config.Config{}, mocks.NewMockPayableResourceDaoService(mockCtrl),
+			mocks.NewMockAccountPenaltiesDaoService(mockCtrl), mocks.NewMockDeadLetterDaoService(mockCtrl),
+			penaltyDetailsMap, allowedTransactionsMap, &e5.Client{}, newTestPayableStatusProvider(t))
+
+		req := httptest.NewRequest(http.MethodGet, "/penalty-payment-api/admin/metrics", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		Convey("Then the request is unauthorised", func() {
+			So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			So(w.Body.String(), ShouldNotContainSubstring, "memstats")
+		})
+	})
+}
+
 func TestUnitGetHealthCheck(t *testing.T) {
 	Convey("Get HealthCheck", t, func() {
 		req := httptest.NewRequest("GET", "/healthcheck", nil)
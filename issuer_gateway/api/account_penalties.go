@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -14,8 +16,8 @@ import (
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 )
 
-var getTransactions = func(customerCode string, companyCode string, client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
-	return client.GetTransactions(&e5.GetTransactionsInput{CustomerCode: customerCode, CompanyCode: companyCode}, requestId)
+var getTransactions = func(ctx context.Context, customerCode string, companyCode string, client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
+	return client.GetTransactions(ctx, &e5.GetTransactionsInput{CustomerCode: customerCode, CompanyCode: companyCode}, requestId)
 }
 var getConfig = config.Get
 var generateTransactionList = private.GenerateTransactionListFromAccountPenalties
@@ -32,6 +34,10 @@ func AccountPenalties(params types.AccountPenaltiesParams) (*models.TransactionL
 	apDaoSvc := params.AccountPenaltiesDaoService
 	allowedTransactionsMap := params.AllowedTransactionsMap
 	requestId := params.RequestId
+	ctx := params.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
 	cfg, err := getConfig()
 	if err != nil {
@@ -46,12 +52,15 @@ func AccountPenalties(params types.AccountPenaltiesParams) (*models.TransactionL
 
 	if accountPenalties == nil {
 		log.InfoC(requestId, "account penalties not found in cache, getting account penalties from E5 transactions", companyInfoLogData)
-		accountPenalties, err = getAccountPenaltiesFromE5Transactions(customerCode, companyCode, cfg, apDaoSvc, false, requestId)
+		accountPenalties, err = getAccountPenaltiesFromE5Transactions(ctx, customerCode, companyCode, cfg, apDaoSvc, false, requestId)
 	} else if isStale(accountPenalties, cfg, requestId) {
 		log.InfoC(requestId, "account penalties cache record is stale, getting account penalties from E5 transactions", companyInfoLogData)
-		accountPenalties, err = getAccountPenaltiesFromE5Transactions(customerCode, companyCode, cfg, apDaoSvc, true, requestId)
+		accountPenalties, err = getAccountPenaltiesFromE5Transactions(ctx, customerCode, companyCode, cfg, apDaoSvc, true, requestId)
 	}
 	if err != nil {
+		if errors.Is(err, e5.ErrCircuitOpen) {
+			return nil, services.Unavailable, err
+		}
 		return nil, services.Error, err
 	}
 
@@ -59,7 +68,7 @@ func AccountPenalties(params types.AccountPenaltiesParams) (*models.TransactionL
 	// payable "penalty" types or non-payable "other" types
 	transactionListItemEnrichmentProviders := private.TransactionListItemEnrichmentProviders{
 		ReasonProvider:        &private.DefaultReasonProvider{},
-		PayableStatusProvider: &private.DefaultPayableStatusProvider{},
+		PayableStatusProvider: params.PayableStatusProvider,
 	}
 	generatedTransactionListFromAccountPenalties, err :=
 		generateTransactionList(accountPenalties, penaltyRefType, penaltyDetailsMap, allowedTransactionsMap, cfg, requestId, transactionListItemEnrichmentProviders)
@@ -95,15 +104,18 @@ func updateAccountPenaltiesEntry(customerCode string, companyCode string, e5Resp
 	return &accountPenalties
 }
 
-func getTransactionListFromE5(customerCode string, companyCode string, cfg *config.Config, requestId string) (*e5.GetTransactionsResponse, error) {
-	client := e5.NewClient(cfg.E5Username, cfg.E5APIURL)
-	e5Response, err := getTransactions(customerCode, companyCode, client, requestId)
+func getTransactionListFromE5(ctx context.Context, customerCode string, companyCode string, cfg *config.Config, requestId string) (*e5.GetTransactionsResponse, error) {
+	client, err := GetE5Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+	e5Response, err := getTransactions(ctx, customerCode, companyCode, client, requestId)
 	return e5Response, err
 }
 
-func getAccountPenaltiesFromE5Transactions(
+func getAccountPenaltiesFromE5Transactions(ctx context.Context,
 	customerCode string, companyCode string, cfg *config.Config, apDaoSvc dao.AccountPenaltiesDaoService, cacheRecordExists bool, requestId string) (*models.AccountPenaltiesDao, error) {
-	e5Response, err := getTransactionListFromE5(customerCode, companyCode, cfg, requestId)
+	e5Response, err := getTransactionListFromE5(ctx, customerCode, companyCode, cfg, requestId)
 	logData := log.Data{"customer_code": customerCode, "company_code": companyCode}
 	if err != nil {
 		log.ErrorC(requestId, fmt.Errorf("error getting transaction list: [%v]", err))
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -8,16 +9,16 @@ import (
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api/common/e5"
 	"github.com/companieshouse/penalty-payment-api/common/services"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/private"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-var penaltyRefType = utils.LateFilingPenaltyRefType
+var penaltyRefType = testutils.LateFilingPenaltyRefType
 var customerCode = "12345678"
 var companyCode = "LP"
 var penaltyDetailsMap = &config.PenaltyDetailsMap{
@@ -170,6 +171,7 @@ func TestUnitAccountPenalties(t *testing.T) {
 		CompanyCode:            companyCode,
 		PenaltyDetailsMap:      penaltyDetailsMap,
 		AllowedTransactionsMap: allowedTransactionMap,
+		PayableStatusProvider:  newTestPayableStatusProvider(t),
 		RequestId:              "",
 	}
 	defer ctrl.Finish()
@@ -188,7 +190,7 @@ func TestUnitAccountPenalties(t *testing.T) {
 		mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, companyCode, "").Return(nil, nil)
 		mockApDaoSvc.EXPECT().CreateAccountPenalties(gomock.Any(), "").Return(nil)
 
-		mockedGetTransactions := func(customerCode string, companyCode string,
+		mockedGetTransactions := func(ctx context.Context, customerCode string, companyCode string,
 			client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
 			return &e5TransactionsResponse, nil
 		}
@@ -204,13 +206,13 @@ func TestUnitAccountPenalties(t *testing.T) {
 
 		assertTransactionListItem(listResponse.Items[0], "A0000001", false, false,
 			"2020-07-21", "2018-06-30", "2020-07-21",
-			3000, 3000, "penalty", "Late filing of accounts", "CLOSED")
+			3000, 3000, "penalty", "Late filing of accounts", "OPEN")
 		assertTransactionListItem(listResponse.Items[1], "CF1", false, false,
 			"2021-04-09", "2018-06-30", "2021-04-09",
-			105, 105, "other", "", "CLOSED")
+			105, 105, "other", "", "OPEN_WITH_PENALTY")
 		assertTransactionListItem(listResponse.Items[2], "FC1", false, false,
 			"2021-04-09", "2018-06-30", "2021-04-09",
-			80, 80, "other", "", "CLOSED")
+			80, 80, "other", "", "OPEN_WITH_PENALTY")
 		assertTransactionListItem(listResponse.Items[3], "A0000002", true, false,
 			"2021-08-10", "2019-06-30", "2021-08-10",
 			3000, 0, "penalty", "Late filing of accounts", "CLOSED")
@@ -227,7 +229,7 @@ func TestUnitAccountPenalties(t *testing.T) {
 		mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, companyCode, "").Return(nil, nil)
 		mockApDaoSvc.EXPECT().CreateAccountPenalties(gomock.Any(), "").Return(errors.New("error creating account penalties"))
 
-		mockedGetTransactions := func(customerCode string, companyCode string,
+		mockedGetTransactions := func(ctx context.Context, customerCode string, companyCode string,
 			client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
 			return &e5TransactionsResponse, nil
 		}
@@ -278,7 +280,7 @@ func TestUnitAccountPenalties(t *testing.T) {
 		mockPenaltiesService.EXPECT().GetAccountPenalties(customerCode, companyCode, "").Return(&accountPenalties, nil)
 		mockPenaltiesService.EXPECT().UpdateAccountPenalties(gomock.Any(), "").Return(errors.New("error updating account penalties"))
 
-		getTransactions = func(customerCode string, companyCode string,
+		getTransactions = func(ctx context.Context, customerCode string, companyCode string,
 			client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
 			return &transactionsResponse, nil
 		}
@@ -299,7 +301,7 @@ func TestUnitAccountPenalties(t *testing.T) {
 		mockPenaltiesService.EXPECT().GetAccountPenalties(customerCode, companyCode, "").Return(&accountPenalties, nil)
 		mockPenaltiesService.EXPECT().UpdateAccountPenalties(gomock.Any(), "").Return(nil)
 
-		getTransactions = func(customerCode string, companyCode string,
+		getTransactions = func(ctx context.Context, customerCode string, companyCode string,
 			client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
 			return &transactionsResponse, nil
 		}
@@ -322,7 +324,7 @@ func TestUnitAccountPenalties(t *testing.T) {
 		mockPenaltiesService.EXPECT().GetAccountPenalties(customerCode, companyCode, "").Return(&accountPenalties, nil)
 		mockPenaltiesService.EXPECT().UpdateAccountPenalties(gomock.Any(), "").Return(nil)
 
-		getTransactions = func(customerCode string, companyCode string,
+		getTransactions = func(ctx context.Context, customerCode string, companyCode string,
 			client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
 			return &transactionsResponse, nil
 		}
@@ -352,7 +354,7 @@ func TestUnitAccountPenalties(t *testing.T) {
 		mockPenaltiesService.EXPECT().UpdateAccountPenalties(gomock.Any(), "").Return(nil).MaxTimes(0)
 		mockPenaltiesService.EXPECT().CreateAccountPenalties(gomock.Any(), "").Return(nil).MaxTimes(0)
 
-		getTransactions = func(customerCode string, companyCode string,
+		getTransactions = func(ctx context.Context, customerCode string, companyCode string,
 			client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
 			return &transactionsResponse, nil
 		}
@@ -370,7 +372,7 @@ func TestUnitAccountPenalties(t *testing.T) {
 		mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, companyCode, "").Return(nil, nil)
 
 		errGettingTransactions := errors.New("error getting transactions")
-		mockedGetTransactions := func(customerCode string, companyCode string, client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
+		mockedGetTransactions := func(ctx context.Context, customerCode string, companyCode string, client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
 			return &e5.GetTransactionsResponse{}, errGettingTransactions
 		}
 
@@ -383,6 +385,22 @@ func TestUnitAccountPenalties(t *testing.T) {
 		So(responseType, ShouldEqual, services.Error)
 	})
 
+	Convey("unavailable when requests to E5 are failing fast", t, func() {
+		mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(ctrl)
+		mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, companyCode, "").Return(nil, nil)
+
+		getTransactions = func(ctx context.Context, customerCode string, companyCode string,
+			client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
+			return nil, e5.ErrCircuitOpen
+		}
+
+		params.AccountPenaltiesDaoService = mockApDaoSvc
+		listResponse, responseType, err := AccountPenalties(params)
+		So(err, ShouldEqual, e5.ErrCircuitOpen)
+		So(listResponse, ShouldBeNil)
+		So(responseType, ShouldEqual, services.Unavailable)
+	})
+
 	Convey("error when generating transaction list fails", t, func() {
 		mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(ctrl)
 		mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, companyCode, "").Return(nil, nil)
@@ -390,7 +408,7 @@ func TestUnitAccountPenalties(t *testing.T) {
 
 		errGeneratingTransactionList := errors.New("error generating transaction list from account penalties: [error generating etag]")
 		payableTransactionList := models.TransactionListResponse{}
-		mockedGetTransactions := func(customerCode string, companyCode string,
+		mockedGetTransactions := func(ctx context.Context, customerCode string, companyCode string,
 			client e5.ClientInterface, requestId string) (*e5.GetTransactionsResponse, error) {
 			return &e5TransactionsResponse, nil
 		}
@@ -515,3 +533,63 @@ func createData(isPaid bool, isStale bool) (models.AccountPenaltiesDao, e5.GetTr
 
 	return accountPenalties, getTransactionsResponse
 }
+
+// newTestPayableStatusProvider gets the payable status of transactions from the payable status rules deployed with
+// the API
+func newTestPayableStatusProvider(t *testing.T) types.PayableStatusProvider {
+	rules, err := config.LoadPayableStatusRules(testutils.PayableStatusRulesFile())
+	if err != nil {
+		t.Fatalf("failed to load payable status rules: %v", err)
+	}
+	provider, err := private.NewRulesPayableStatusProvider(rules)
+	if err != nil {
+		t.Fatalf("failed to evaluate payable status rules: %v", err)
+	}
+	return provider
+}
+
+func TestUnitAccountPenalties_PayableStatusProvider(t *testing.T) {
+	cfg, _ := config.Get()
+	cfg.AccountPenaltiesTTL = "24h"
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	isPaid := true
+	closedWhenPaidRules := config.PayableStatusRules{
+		Version:       private.PayableStatusRulesVersion,
+		DefaultStatus: private.ClosedPayableStatus,
+		Rules: []config.PayableStatusRule{
+			{
+				Name:   "paid",
+				Status: private.ClosedPayableStatus,
+				When:   config.PayableStatusConditions{IsPaid: &isPaid},
+			},
+		},
+	}
+
+	Convey("Given the payable status provider in the params", t, func() {
+		provider, err := private.NewRulesPayableStatusProvider(&closedWhenPaidRules)
+		So(err, ShouldBeNil)
+
+		Convey("Then the payable status of the account penalties is got from it", func() {
+			accountPenalties, _ := createData(false, false)
+
+			mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(ctrl)
+			mockApDaoSvc.EXPECT().GetAccountPenalties(customerCode, companyCode, "").Return(&accountPenalties, nil)
+
+			listResponse, responseType, err := AccountPenalties(types.AccountPenaltiesParams{
+				PenaltyRefType:             penaltyRefType,
+				CustomerCode:               customerCode,
+				CompanyCode:                companyCode,
+				PenaltyDetailsMap:          penaltyDetailsMap,
+				AllowedTransactionsMap:     allowedTransactionMap,
+				AccountPenaltiesDaoService: mockApDaoSvc,
+				PayableStatusProvider:      provider,
+			})
+
+			So(err, ShouldBeNil)
+			So(responseType, ShouldEqual, services.Success)
+			So(listResponse.Items[0].PayableStatus, ShouldEqual, private.ClosedPayableStatus)
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 
@@ -18,7 +19,8 @@ var getCompanyCodeFromTransaction = utils.GetCompanyCodeFromTransaction
 // UpdateIssuerAccountWithPenaltyPaid will update the transactions in E5 as paid.
 // resource - is the payable resource from the db representing the penalty(ies)
 // payment - is the information about the payment session
-func UpdateIssuerAccountWithPenaltyPaid(payableResourceService *services.PayableResourceService,
+// The E5 requests are abandoned if ctx is cancelled, e.g. when the client of the incoming request goes away.
+func UpdateIssuerAccountWithPenaltyPaid(ctx context.Context, payableResourceService *services.PayableResourceService,
 	client e5.ClientInterface, resource models.PayableResource, payment validators.PaymentInformation, requestId string) error {
 	log.DebugC(requestId, "converting payment amount from string to float", log.Data{"amount": payment.Amount})
 	amountPaid, err := strconv.ParseFloat(payment.Amount, 32)
@@ -27,13 +29,16 @@ func UpdateIssuerAccountWithPenaltyPaid(payableResourceService *services.Payable
 		return err
 	}
 
+	// every penalty of the resource is paid by the one E5 payment
 	var transactions []*e5.CreatePaymentTransaction
+	var penaltyRefs []string
 
 	for _, t := range resource.Transactions {
 		transactions = append(transactions, &e5.CreatePaymentTransaction{
 			TransactionReference: t.PenaltyRef,
 			Value:                t.Amount,
 		})
+		penaltyRefs = append(penaltyRefs, t.PenaltyRef)
 	}
 
 	// this will be used for the PUON value in E5. it is referred to as paymentId in their spec. X is prefixed to it
@@ -41,7 +46,7 @@ func UpdateIssuerAccountWithPenaltyPaid(payableResourceService *services.Payable
 	// ones that begin with 'LP' which signify penalties that have been paid outside the digital service.
 	paymentID := "X" + payment.PaymentID
 
-	log.DebugC(requestId, "getting company code from transaction", log.Data{"transaction": transactions[0]})
+	log.DebugC(requestId, "getting company code from transaction", log.Data{"penalty_refs": penaltyRefs})
 	companyCode, err := getCompanyCodeFromTransaction(resource.Transactions)
 
 	if err != nil {
@@ -50,67 +55,108 @@ func UpdateIssuerAccountWithPenaltyPaid(payableResourceService *services.Payable
 	}
 
 	// three http requests are needed to mark a transactions as paid. The process is 1) create the payment, 2) authorise
-	// the payments and finally 3) confirm the payment. if anyone of these fails, the company account will be locked in
-	// E5. Finance have confirmed that it is better to keep these locked as a cleanup process will happen naturally in
-	// the working day.
+	// the payments and finally 3) confirm the payment. if the authorise or confirm fails, the company account will be
+	// locked in E5. Unless the compensation policy times out or rejects the payment to unlock it, the account is kept
+	// locked as a cleanup process will happen naturally in the working day.
+	penaltyRefType, _ := utils.GetPenaltyRefTypeFromTransaction(resource.Transactions)
+	compensateFailure := func(failedAction e5.Action) {
+		compensate(ctx, GetCompensationPolicy(payableResourceService.Config), client, payableResourceService.DAO, failedPayment{
+			companyCode:    companyCode,
+			customerCode:   resource.CustomerCode,
+			payableRef:     resource.PayableRef,
+			e5PaymentID:    paymentID,
+			penaltyRefType: penaltyRefType,
+			failedAction:   failedAction,
+		}, requestId)
+	}
+
 	logData := log.Data{
 		"company_code":  companyCode,
 		"customer_code": resource.CustomerCode,
-		"penalty_ref":   transactions[0].TransactionReference,
+		"penalty_refs":  penaltyRefs,
 		"payable_ref":   resource.PayableRef,
 		"payment_id":    payment.PaymentID,
 		"e5_puon":       paymentID,
 		"total_value":   amountPaid,
 	}
-	log.DebugC(requestId, "creating payment in E5", logData)
-	err = client.CreatePayment(&e5.CreatePaymentInput{
-		CompanyCode:  companyCode,
-		CustomerCode: resource.CustomerCode,
-		PaymentID:    paymentID,
-		TotalValue:   amountPaid,
-		Transactions: transactions,
-	}, "")
-
+	// a payment that is marked as paid again resumes at the first step that has not succeeded, as E5 rejects a second
+	// create or authorise with the same PUON. A payment that was compensated is closed in E5, so the steps before the
+	// compensation do not count and it is created again.
+	progress, err := payableResourceService.DAO.GetE5Progress(resource.CustomerCode, resource.PayableRef, requestId)
 	if err != nil {
-		if svcErr := RecordIssuerCommandError(payableResourceService, resource, e5.CreateAction, requestId); svcErr != nil {
-			log.ErrorC(requestId, svcErr, log.Data{"payment_id": payment.PaymentID, "payable_ref": resource.PayableRef})
-			return err
-		}
-		private.LogE5Error("failed to create payment in E5", err, resource, payment, requestId)
+		log.ErrorC(requestId, fmt.Errorf("error getting E5 progress of payment: [%v]", err), logData)
 		return err
 	}
 
-	log.DebugC(requestId, "authorising payment in E5", logData)
-	err = client.AuthorisePayment(&e5.AuthorisePaymentInput{
-		CompanyCode:   companyCode,
-		PaymentID:     paymentID,
-		CardReference: payment.ExternalPaymentID,
-		CardType:      payment.CardType,
-		Email:         payment.CreatedBy,
-	}, "")
-
-	if err != nil {
-		if svcErr := RecordIssuerCommandError(payableResourceService, resource, e5.AuthoriseAction, requestId); svcErr != nil {
-			log.ErrorC(requestId, svcErr, log.Data{"payment_id": payment.PaymentID, "payable_ref": resource.PayableRef})
+	if progress.Compensated() {
+		log.InfoC(requestId, "creating payment again as it was compensated", logData, log.Data{"compensated_at": progress.CompensatedAt})
+	}
+	if progress.Completed(e5.CreateAction) {
+		log.InfoC(requestId, "skipping create payment as it has already succeeded", logData)
+	} else {
+		log.DebugC(requestId, "creating payment in E5", logData)
+		err = client.CreatePayment(ctx, &e5.CreatePaymentInput{
+			CompanyCode:  companyCode,
+			CustomerCode: resource.CustomerCode,
+			PaymentID:    paymentID,
+			TotalValue:   amountPaid,
+			Transactions: transactions,
+		}, "")
+
+		if err != nil {
+			if svcErr := RecordIssuerCommandError(payableResourceService, resource, e5.CreateAction, err, requestId); svcErr != nil {
+				log.ErrorC(requestId, svcErr, log.Data{"payment_id": payment.PaymentID, "payable_ref": resource.PayableRef})
+				return err
+			}
+			private.LogE5Error("failed to create payment in E5", err, resource, payment, requestId)
 			return err
 		}
-		private.LogE5Error("failed to authorise payment in E5", err, resource, payment, requestId)
-		return err
+		recordIssuerCommandProgress(payableResourceService, resource, e5.CreateAction, requestId)
 	}
 
-	log.DebugC(requestId, "confirming payment in E5", logData)
-	err = client.ConfirmPayment(&e5.PaymentActionInput{
-		CompanyCode: companyCode,
-		PaymentID:   paymentID,
-	}, requestId)
+	if progress.Completed(e5.AuthoriseAction) {
+		log.InfoC(requestId, "skipping authorise payment as it has already succeeded", logData)
+	} else {
+		log.DebugC(requestId, "authorising payment in E5", logData)
+		err = client.AuthorisePayment(ctx, &e5.AuthorisePaymentInput{
+			CompanyCode:   companyCode,
+			PaymentID:     paymentID,
+			CardReference: payment.ExternalPaymentID,
+			CardType:      payment.CardType,
+			Email:         payment.CreatedBy,
+		}, "")
+
+		if err != nil {
+			compensateFailure(e5.AuthoriseAction)
+			if svcErr := RecordIssuerCommandError(payableResourceService, resource, e5.AuthoriseAction, err, requestId); svcErr != nil {
+				log.ErrorC(requestId, svcErr, log.Data{"payment_id": payment.PaymentID, "payable_ref": resource.PayableRef})
+				return err
+			}
+			private.LogE5Error("failed to authorise payment in E5", err, resource, payment, requestId)
+			return err
+		}
+		recordIssuerCommandProgress(payableResourceService, resource, e5.AuthoriseAction, requestId)
+	}
 
-	if err != nil {
-		if svcErr := RecordIssuerCommandError(payableResourceService, resource, e5.ConfirmAction, requestId); svcErr != nil {
-			log.ErrorC(requestId, svcErr, log.Data{"payment_id": payment.PaymentID, "payable_ref": resource.PayableRef})
+	if progress.Completed(e5.ConfirmAction) {
+		log.InfoC(requestId, "skipping confirm payment as it has already succeeded", logData)
+	} else {
+		log.DebugC(requestId, "confirming payment in E5", logData)
+		err = client.ConfirmPayment(ctx, &e5.PaymentActionInput{
+			CompanyCode: companyCode,
+			PaymentID:   paymentID,
+		}, requestId)
+
+		if err != nil {
+			compensateFailure(e5.ConfirmAction)
+			if svcErr := RecordIssuerCommandError(payableResourceService, resource, e5.ConfirmAction, err, requestId); svcErr != nil {
+				log.ErrorC(requestId, svcErr, log.Data{"payment_id": payment.PaymentID, "payable_ref": resource.PayableRef})
+				return err
+			}
+			private.LogE5Error("failed to confirm payment in E5", err, resource, payment, requestId)
 			return err
 		}
-		private.LogE5Error("failed to confirm payment in E5", err, resource, payment, requestId)
-		return err
+		recordIssuerCommandProgress(payableResourceService, resource, e5.ConfirmAction, requestId)
 	}
 
 	log.InfoC(requestId, "marked penalty transaction(s) as paid in E5", logData)
@@ -118,8 +164,22 @@ func UpdateIssuerAccountWithPenaltyPaid(payableResourceService *services.Payable
 	return nil
 }
 
-// RecordIssuerCommandError will mark the resource as having failed to update E5.
+// RecordIssuerCommandError will mark the resource as having failed to update E5, recording the E5 message code of the
+// failure.
 func RecordIssuerCommandError(payableResourceService *services.PayableResourceService,
-	resource models.PayableResource, action e5.Action, requestId string) error {
-	return payableResourceService.DAO.SaveE5Error(resource.CustomerCode, resource.PayableRef, requestId, action)
+	resource models.PayableResource, action e5.Action, e5Err error, requestId string) error {
+	return payableResourceService.DAO.SaveE5Error(resource.CustomerCode, resource.PayableRef, requestId, action, e5Err)
+}
+
+// recordIssuerCommandProgress records that a step of marking the payment as paid succeeded in E5. A failure to record
+// it is logged rather than failing the payment, as the step has been applied in E5.
+func recordIssuerCommandProgress(payableResourceService *services.PayableResourceService,
+	resource models.PayableResource, action e5.Action, requestId string) {
+	if err := payableResourceService.DAO.SaveE5Progress(resource.CustomerCode, resource.PayableRef, requestId, action); err != nil {
+		log.ErrorC(requestId, fmt.Errorf("error saving E5 progress of payment: [%v]", err), log.Data{
+			"customer_code": resource.CustomerCode,
+			"payable_ref":   resource.PayableRef,
+			"e5_action":     action,
+		})
+	}
 }
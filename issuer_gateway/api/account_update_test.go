@@ -1,18 +1,21 @@
 package api
 
 import (
+	"context"
 	j "encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api-core/validators"
 	"github.com/companieshouse/penalty-payment-api/common/e5"
 	"github.com/companieshouse/penalty-payment-api/common/services"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
+	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	"github.com/golang/mock/gomock"
 	"github.com/jarcoal/httpmock"
 	. "github.com/smartystreets/goconvey/convey"
@@ -81,7 +84,7 @@ func TestUnitUpdateIssuerAccountWithPenaltyPaid(t *testing.T) {
 		r := generatePayableResource(true)
 		p := generatePaymentInformation(false, false)
 
-		err := UpdateIssuerAccountWithPenaltyPaid(payableResourceSvc, c, r, p, "")
+		err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, c, r, p, "")
 		So(err, ShouldNotBeNil)
 	})
 
@@ -94,14 +97,14 @@ func TestUnitUpdateIssuerAccountWithPenaltyPaid(t *testing.T) {
 		p := generatePaymentInformation(true, false)
 		r := generatePayableResource(false)
 
-		err := UpdateIssuerAccountWithPenaltyPaid(payableResourceSvc, c, r, p, "")
+		err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, c, r, p, "")
 
 		So(err, ShouldBeError, "cannot determine company code")
 	})
 
 	Convey("E5 request errors", t, func() {
 		getCompanyCodeFromTransaction = func(transactions []models.TransactionItem) (string, error) {
-			return utils.LateFilingPenaltyCompanyCode, nil
+			return testutils.LateFilingPenaltyCompanyCode, nil
 		}
 
 		Convey("failure in creating a new payment", func() {
@@ -109,15 +112,16 @@ func TestUnitUpdateIssuerAccountWithPenaltyPaid(t *testing.T) {
 			e5Responder := httpmock.NewStringResponder(http.StatusBadRequest, e5ValidationError)
 			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment", e5Responder)
 
-			mockPrDaoSvc.EXPECT().SaveE5Error("10000024", "123", "", e5.CreateAction).Return(errors.New(""))
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(&e5.PaymentProgress{}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Error("10000024", "123", "", e5.CreateAction, gomock.Any()).Return(errors.New(""))
 
 			c := &e5.Client{}
 			p := generatePaymentInformation(true, false)
 			r := generatePayableResource(false)
 
-			err := UpdateIssuerAccountWithPenaltyPaid(payableResourceSvc, c, r, p, "")
+			err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, c, r, p, "")
 
-			So(err, ShouldBeError, e5.ErrE5BadRequest)
+			So(errors.Is(err, e5.ErrE5BadRequest), ShouldBeTrue)
 		})
 
 		Convey("failure in authorising a payment", func() {
@@ -127,15 +131,44 @@ func TestUnitUpdateIssuerAccountWithPenaltyPaid(t *testing.T) {
 			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment", okResponder)
 			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/authorise", e5Responder)
 
-			mockPrDaoSvc.EXPECT().SaveE5Error("10000024", "123", "", e5.AuthoriseAction).Return(errors.New(""))
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(&e5.PaymentProgress{}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.CreateAction).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Error("10000024", "123", "", e5.AuthoriseAction, gomock.Any()).Return(errors.New(""))
 
 			c := &e5.Client{}
 			p := generatePaymentInformation(true, true)
 			r := generatePayableResource(false)
 
-			err := UpdateIssuerAccountWithPenaltyPaid(payableResourceSvc, c, r, p, "")
+			err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, c, r, p, "")
 
-			So(err, ShouldBeError, e5.ErrE5BadRequest)
+			So(errors.Is(err, e5.ErrE5BadRequest), ShouldBeTrue)
+		})
+
+		Convey("failure in authorising a payment times out the payment when compensation is enabled", func() {
+			defer httpmock.Reset()
+			e5Responder := httpmock.NewStringResponder(http.StatusBadRequest, e5ValidationError)
+			okResponder := httpmock.NewBytesResponder(http.StatusOK, nil)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment", okResponder)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/authorise", e5Responder)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/timeout", okResponder)
+
+			compensationSvc := &services.PayableResourceService{
+				DAO:    mockPrDaoSvc,
+				Config: &config.Config{E5CompensationAction: "timeout"},
+			}
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(&e5.PaymentProgress{}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.CreateAction).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Compensation("10000024", "123", "", e5.TimeoutAction, nil).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Error("10000024", "123", "", e5.AuthoriseAction, gomock.Any()).Return(nil)
+
+			c := &e5.Client{}
+			p := generatePaymentInformation(true, true)
+			r := generatePayableResource(false)
+
+			err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), compensationSvc, c, r, p, "")
+
+			So(errors.Is(err, e5.ErrE5BadRequest), ShouldBeTrue)
+			So(httpmock.GetCallCountInfo()["POST /arTransactions/payment/timeout"], ShouldEqual, 1)
 		})
 
 		Convey("failure in confirming a payment", func() {
@@ -146,15 +179,18 @@ func TestUnitUpdateIssuerAccountWithPenaltyPaid(t *testing.T) {
 			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/authorise", okResponder)
 			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/confirm", e5Responder)
 
-			mockPrDaoSvc.EXPECT().SaveE5Error("10000024", "123", "", e5.ConfirmAction).Return(errors.New(""))
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(&e5.PaymentProgress{}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.CreateAction).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.AuthoriseAction).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Error("10000024", "123", "", e5.ConfirmAction, gomock.Any()).Return(errors.New(""))
 
 			c := &e5.Client{}
 			p := generatePaymentInformation(true, true)
 			r := generatePayableResource(false)
 
-			err := UpdateIssuerAccountWithPenaltyPaid(payableResourceSvc, c, r, p, "")
+			err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, c, r, p, "")
 
-			So(err, ShouldBeError, e5.ErrE5BadRequest)
+			So(errors.Is(err, e5.ErrE5BadRequest), ShouldBeTrue)
 		})
 
 		Convey("no errors when all 3 calls to E5 succeed", func() {
@@ -164,11 +200,16 @@ func TestUnitUpdateIssuerAccountWithPenaltyPaid(t *testing.T) {
 			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/authorise", okResponder)
 			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/confirm", okResponder)
 
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(&e5.PaymentProgress{}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.CreateAction).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.AuthoriseAction).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.ConfirmAction).Return(nil)
+
 			c := &e5.Client{}
 			p := generatePaymentInformation(true, true)
 			r := generatePayableResource(false)
 
-			err := UpdateIssuerAccountWithPenaltyPaid(payableResourceSvc, c, r, p, "")
+			err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, c, r, p, "")
 
 			So(err, ShouldBeNil)
 		})
@@ -203,13 +244,193 @@ func TestUnitUpdateIssuerAccountWithPenaltyPaid(t *testing.T) {
 			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/authorise", paymentIDResponder)
 			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/confirm", paymentIDResponder)
 
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(&e5.PaymentProgress{}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.CreateAction).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.AuthoriseAction).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.ConfirmAction).Return(nil)
+
+			c := &e5.Client{}
+			p := generatePaymentInformation(true, true)
+			r := generatePayableResource(false)
+
+			err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, c, r, p, "")
+			So(err, ShouldBeNil)
+
+		})
+
+		Convey("a failure to record the progress of a step does not fail the payment", func() {
+			defer httpmock.Reset()
+			okResponder := httpmock.NewBytesResponder(http.StatusOK, nil)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment", okResponder)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/authorise", okResponder)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/confirm", okResponder)
+
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(&e5.PaymentProgress{}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", gomock.Any()).Return(errors.New("mongo unavailable")).Times(3)
+
+			c := &e5.Client{}
+			p := generatePaymentInformation(true, true)
+			r := generatePayableResource(false)
+
+			err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, c, r, p, "")
+
+			So(err, ShouldBeNil)
+		})
+
+		Convey("the steps that have already succeeded are skipped", func() {
+			defer httpmock.Reset()
+			okResponder := httpmock.NewBytesResponder(http.StatusOK, nil)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment", okResponder)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/authorise", okResponder)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/confirm", okResponder)
+
+			createdAt := time.Now()
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(&e5.PaymentProgress{CreatedAt: &createdAt}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.AuthoriseAction).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.ConfirmAction).Return(nil)
+
 			c := &e5.Client{}
 			p := generatePaymentInformation(true, true)
 			r := generatePayableResource(false)
 
-			err := UpdateIssuerAccountWithPenaltyPaid(payableResourceSvc, c, r, p, "")
+			err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, c, r, p, "")
+
 			So(err, ShouldBeNil)
+			callCounts := httpmock.GetCallCountInfo()
+			So(callCounts["POST /arTransactions/payment"], ShouldEqual, 0)
+			So(callCounts["POST /arTransactions/payment/authorise"], ShouldEqual, 1)
+			So(callCounts["POST /arTransactions/payment/confirm"], ShouldEqual, 1)
+		})
+
+		Convey("a payment that was compensated is created again rather than resumed", func() {
+			defer httpmock.Reset()
+			okResponder := httpmock.NewBytesResponder(http.StatusOK, nil)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment", okResponder)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/authorise", okResponder)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/confirm", okResponder)
+
+			createdAt := time.Now().Add(-2 * time.Minute)
+			compensatedAt := time.Now().Add(-time.Minute)
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(&e5.PaymentProgress{
+				CreatedAt: &createdAt, AuthorisedAt: &createdAt, CompensatedAt: &compensatedAt,
+			}, nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.CreateAction).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.AuthoriseAction).Return(nil)
+			mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", e5.ConfirmAction).Return(nil)
+
+			c := &e5.Client{}
+			p := generatePaymentInformation(true, true)
+			r := generatePayableResource(false)
+
+			err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, c, r, p, "")
+
+			So(err, ShouldBeNil)
+			callCounts := httpmock.GetCallCountInfo()
+			So(callCounts["POST /arTransactions/payment"], ShouldEqual, 1)
+			So(callCounts["POST /arTransactions/payment/authorise"], ShouldEqual, 1)
+			So(callCounts["POST /arTransactions/payment/confirm"], ShouldEqual, 1)
+		})
+
+		Convey("no request is sent to E5 when the progress cannot be read", func() {
+			defer httpmock.Reset()
+			okResponder := httpmock.NewBytesResponder(http.StatusOK, nil)
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment", okResponder)
+
+			mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").Return(nil, errors.New("mongo unavailable"))
+
+			c := &e5.Client{}
+			p := generatePaymentInformation(true, true)
+			r := generatePayableResource(false)
+
+			err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, c, r, p, "")
+
+			So(err, ShouldBeError, "mongo unavailable")
+			So(httpmock.GetTotalCallCount(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestUnitUpdateIssuerAccountWithPenaltyPaid_AfterCompensatedProcessing(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockPrDaoSvc := mocks.NewMockPayableResourceDaoService(mockCtrl)
+	payableResourceSvc := &services.PayableResourceService{
+		DAO:    mockPrDaoSvc,
+		Config: &config.Config{E5CompensationAction: "timeout"},
+	}
+
+	httpmock.Activate()
+
+	defer httpmock.DeactivateAndReset()
+	defer mockCtrl.Finish()
+
+	Convey("Given a penalty payment message whose authorise failed and was compensated", t, func() {
+		getCompanyCodeFromTransaction = func(transactions []models.TransactionItem) (string, error) {
+			return testutils.LateFilingPenaltyCompanyCode, nil
+		}
+		okResponder := httpmock.NewBytesResponder(http.StatusOK, nil)
+		httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment", okResponder)
+		httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/authorise",
+			httpmock.NewStringResponder(http.StatusBadRequest, e5ValidationError))
+		httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/timeout", okResponder)
+		httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/confirm", okResponder)
+
+		// the progress is shared by both requests, as it is stored on the payable resource
+		progress := &e5.PaymentProgress{}
+		mockPrDaoSvc.EXPECT().GetE5Progress("10000024", "123", "").DoAndReturn(
+			func(_, _, _ string) (*e5.PaymentProgress, error) {
+				saved := *progress
+				return &saved, nil
+			}).Times(2)
+		mockPrDaoSvc.EXPECT().SaveE5Progress("10000024", "123", "", gomock.Any()).DoAndReturn(
+			func(_, _, _ string, action e5.Action) error {
+				now := time.Now()
+				switch action {
+				case e5.CreateAction:
+					progress.CreatedAt = &now
+				case e5.AuthoriseAction:
+					progress.AuthorisedAt = &now
+				case e5.ConfirmAction:
+					progress.ConfirmedAt = &now
+				}
+				return nil
+			}).AnyTimes()
+		mockPrDaoSvc.EXPECT().SaveE5Compensation("10000024", "123", "", e5.TimeoutAction, nil).DoAndReturn(
+			func(_, _, _ string, _ e5.Action, _ error) error {
+				compensatedAt := time.Now()
+				*progress = e5.PaymentProgress{CompensatedAt: &compensatedAt}
+				return nil
+			})
+		mockPrDaoSvc.EXPECT().SaveE5Error("10000024", "123", "", e5.AuthoriseAction, gomock.Any()).Return(nil)
+
+		message := newPenaltyPayment(1)
+		message.CompanyCode = testutils.LateFilingPenaltyCompanyCode
+		message.CustomerCode = "10000024"
+		message.PayableRef = "123"
+		processingCfg := *cfg
+		processingCfg.E5CompensationAction = "timeout"
+		processingCfg.PenaltyPaymentsProcessingMaxRetries = "1"
+		financePayment := PenaltyFinancePayment{E5Client: &e5.Client{}, PayableResourceDaoService: mockPrDaoSvc}
+
+		err := financePayment.ProcessFinancialPenaltyPayment(context.Background(), message, "X"+message.PaymentID, &processingCfg, false)
+		So(err, ShouldBeNil)
+		So(progress.Compensated(), ShouldBeTrue)
+
+		Convey("When the payment is marked as paid in E5 again and E5 accepts it", func() {
+			httpmock.RegisterResponder(http.MethodPost, "/arTransactions/payment/authorise", okResponder)
 
+			err := UpdateIssuerAccountWithPenaltyPaid(context.Background(), payableResourceSvc, &e5.Client{},
+				generatePayableResource(false), generatePaymentInformation(true, true), "")
+
+			Convey("Then it is created again rather than resumed against the compensated payment", func() {
+				So(err, ShouldBeNil)
+				So(progress.Compensated(), ShouldBeFalse)
+				So(progress.Completed(e5.ConfirmAction), ShouldBeTrue)
+				callCounts := httpmock.GetCallCountInfo()
+				So(callCounts["POST /arTransactions/payment"], ShouldEqual, 2)
+				So(callCounts["POST /arTransactions/payment/authorise"], ShouldEqual, 2)
+				So(callCounts["POST /arTransactions/payment/timeout"], ShouldEqual, 1)
+				So(callCounts["POST /arTransactions/payment/confirm"], ShouldEqual, 1)
+			})
 		})
 	})
 }
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/services"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+var getCompanyTransactions = func(ctx context.Context, input *e5.GetCompanyTransactionsInput, client e5.ClientInterface,
+	handlePage e5.TransactionsPageHandler, requestId string) error {
+	return client.GetCompanyTransactions(ctx, input, handlePage, requestId)
+}
+
+// CompanyPenaltiesHandler is called with the penalties in each page of transactions as the page is read from E5
+type CompanyPenaltiesHandler func(penalties []types.CompanyPenalty) error
+
+// CompanyPenalties gets the AR transactions of every customer within a company code for the date window from E5 a page
+// at a time and passes handlePenalties only those that are payable penalty types, optionally filtered on whether they
+// have been paid
+func CompanyPenalties(client e5.ClientInterface, params types.CompanyPenaltiesParams, handlePenalties CompanyPenaltiesHandler) (services.ResponseType, error) {
+	requestId := params.RequestId
+	logContext := log.Data{
+		"company_code": params.CompanyCode,
+		"from_date":    params.FromDate,
+		"to_date":      params.ToDate,
+	}
+
+	transactionsCount := 0
+	penaltiesCount := 0
+
+	log.InfoC(requestId, "getting company transactions from E5", logContext)
+	err := getCompanyTransactions(params.Context, &e5.GetCompanyTransactionsInput{
+		CompanyCode:        params.CompanyCode,
+		FromDate:           params.FromDate,
+		ToDate:             params.ToDate,
+		TransactionType:    params.TransactionType,
+		TransactionSubType: params.TransactionSubType,
+	}, client, func(page *e5.GetTransactionsResponse) error {
+		penalties := getCompanyPenalties(page.Transactions, params)
+		transactionsCount += len(page.Transactions)
+		penaltiesCount += len(penalties)
+		return handlePenalties(penalties)
+	}, requestId)
+	if err != nil {
+		err = fmt.Errorf("error getting company transactions from E5: [%w]", err)
+		log.ErrorC(requestId, err, logContext)
+		var validationErrs validator.ValidationErrors
+		if errors.As(err, &validationErrs) || errors.Is(err, e5.ErrE5BadRequest) {
+			return services.InvalidData, err
+		}
+		if errors.Is(err, e5.ErrCircuitOpen) {
+			return services.Unavailable, err
+		}
+		return services.Error, err
+	}
+
+	log.InfoC(requestId, "completed company penalties request", logContext, log.Data{
+		"transactions_count": transactionsCount,
+		"penalties_count":    penaltiesCount,
+	})
+
+	return services.Success, nil
+}
+
+// getCompanyPenalties keeps the transactions that are payable penalty types, optionally filtered on whether they have
+// been paid
+func getCompanyPenalties(transactions []e5.Transaction, params types.CompanyPenaltiesParams) []types.CompanyPenalty {
+	penalties := make([]types.CompanyPenalty, 0, len(transactions))
+	for _, transaction := range transactions {
+		if !params.AllowedTransactionsMap.Types[transaction.TransactionType][transaction.TransactionSubType] {
+			continue
+		}
+		if params.IsPaid != nil && transaction.IsPaid != *params.IsPaid {
+			continue
+		}
+		penalties = append(penalties, types.CompanyPenalty{
+			CompanyCode:          transaction.CompanyCode,
+			LedgerCode:           transaction.LedgerCode,
+			CustomerCode:         transaction.CustomerCode,
+			TransactionReference: transaction.TransactionReference,
+			TransactionDate:      transaction.TransactionDate,
+			MadeUpDate:           transaction.MadeUpDate,
+			Amount:               transaction.Amount,
+			OutstandingAmount:    transaction.OutstandingAmount,
+			IsPaid:               transaction.IsPaid,
+			TransactionType:      transaction.TransactionType,
+			TransactionSubType:   transaction.TransactionSubType,
+			TypeDescription:      transaction.TypeDescription,
+			DueDate:              transaction.DueDate,
+			AccountStatus:        transaction.AccountStatus,
+			DunningStatus:        transaction.DunningStatus,
+		})
+	}
+	return penalties
+}
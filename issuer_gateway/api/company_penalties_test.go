@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/services"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitCompanyPenalties(t *testing.T) {
+	companyPenaltiesAllowedTransactions := &models.AllowedTransactionMap{
+		Types: map[string]map[string]bool{
+			"1": {"EU": true, "EJ": false},
+		},
+	}
+	params := types.CompanyPenaltiesParams{
+		CompanyCode:            "LP",
+		FromDate:               "2025-01-01",
+		ToDate:                 "2025-01-02",
+		AllowedTransactionsMap: companyPenaltiesAllowedTransactions,
+	}
+	companyTransactionPages := []*e5.GetTransactionsResponse{
+		{
+			Transactions: []e5.Transaction{
+				{CustomerCode: "10000024", TransactionReference: "A0000001", TransactionType: "1", TransactionSubType: "EU", IsPaid: true},
+				{CustomerCode: "10000025", TransactionReference: "00000003", TransactionType: "2", TransactionSubType: "90", IsPaid: true},
+				{CustomerCode: "10000026", TransactionReference: "A0000004", TransactionType: "1", TransactionSubType: "EJ", IsPaid: true},
+			},
+		},
+		{
+			Transactions: []e5.Transaction{
+				{CustomerCode: "10000025", TransactionReference: "A0000002", TransactionType: "1", TransactionSubType: "EU", IsPaid: false},
+			},
+		},
+	}
+	readCompanyTransactionPages := func(ctx context.Context, input *e5.GetCompanyTransactionsInput, client e5.ClientInterface,
+		handlePage e5.TransactionsPageHandler, requestId string) error {
+		for _, page := range companyTransactionPages {
+			if err := handlePage(page); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	Convey("Given a request for the penalties within a company code", t, func() {
+		var handledPenalties [][]types.CompanyPenalty
+		collectPenalties := func(penalties []types.CompanyPenalty) error {
+			handledPenalties = append(handledPenalties, penalties)
+			return nil
+		}
+
+		Convey("When E5 returns transactions then only the allowed penalties are passed on a page at a time", func() {
+			var gotInput *e5.GetCompanyTransactionsInput
+			getCompanyTransactions = func(ctx context.Context, input *e5.GetCompanyTransactionsInput, client e5.ClientInterface,
+				handlePage e5.TransactionsPageHandler, requestId string) error {
+				gotInput = input
+				return readCompanyTransactionPages(ctx, input, client, handlePage, requestId)
+			}
+
+			responseType, err := CompanyPenalties(nil, params, collectPenalties)
+
+			So(err, ShouldBeNil)
+			So(responseType, ShouldEqual, services.Success)
+			So(handledPenalties, ShouldHaveLength, 2)
+			So(handledPenalties[0], ShouldHaveLength, 1)
+			So(handledPenalties[0][0].TransactionReference, ShouldEqual, "A0000001")
+			So(handledPenalties[1], ShouldHaveLength, 1)
+			So(handledPenalties[1][0].TransactionReference, ShouldEqual, "A0000002")
+			So(gotInput.CompanyCode, ShouldEqual, "LP")
+			So(gotInput.FromDate, ShouldEqual, "2025-01-01")
+			So(gotInput.ToDate, ShouldEqual, "2025-01-02")
+		})
+
+		Convey("When filtering on paid penalties then unpaid penalties are removed", func() {
+			getCompanyTransactions = readCompanyTransactionPages
+			isPaid := true
+			paidParams := params
+			paidParams.IsPaid = &isPaid
+
+			_, err := CompanyPenalties(nil, paidParams, collectPenalties)
+
+			So(err, ShouldBeNil)
+			So(handledPenalties, ShouldHaveLength, 2)
+			So(handledPenalties[0], ShouldHaveLength, 1)
+			So(handledPenalties[0][0].TransactionReference, ShouldEqual, "A0000001")
+			So(handledPenalties[1], ShouldBeEmpty)
+		})
+
+		Convey("When the penalties cannot be handled then no more pages are read", func() {
+			getCompanyTransactions = readCompanyTransactionPages
+
+			responseType, err := CompanyPenalties(nil, params, func(penalties []types.CompanyPenalty) error {
+				handledPenalties = append(handledPenalties, penalties)
+				return errors.New("client went away")
+			})
+
+			So(err, ShouldNotBeNil)
+			So(responseType, ShouldEqual, services.Error)
+			So(handledPenalties, ShouldHaveLength, 1)
+		})
+
+		Convey("When E5 rejects the request then invalid data is returned", func() {
+			getCompanyTransactions = func(ctx context.Context, input *e5.GetCompanyTransactionsInput, client e5.ClientInterface,
+				handlePage e5.TransactionsPageHandler, requestId string) error {
+				return e5.ErrE5BadRequest
+			}
+
+			responseType, err := CompanyPenalties(nil, params, collectPenalties)
+
+			So(err, ShouldNotBeNil)
+			So(handledPenalties, ShouldBeEmpty)
+			So(responseType, ShouldEqual, services.InvalidData)
+		})
+
+		Convey("When E5 fails then an error is returned", func() {
+			getCompanyTransactions = func(ctx context.Context, input *e5.GetCompanyTransactionsInput, client e5.ClientInterface,
+				handlePage e5.TransactionsPageHandler, requestId string) error {
+				return errors.New("transport error")
+			}
+
+			responseType, err := CompanyPenalties(nil, params, collectPenalties)
+
+			So(err, ShouldNotBeNil)
+			So(handledPenalties, ShouldBeEmpty)
+			So(responseType, ShouldEqual, services.Error)
+		})
+	})
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/config"
+)
+
+// CompensationPolicy decides how a customer account left locked in E5 by a payment that failed part way through is
+// unlocked. A disabled policy leaves the account locked for finance to clean up.
+type CompensationPolicy struct {
+	// Action is the E5 action used to unlock the account, either e5.TimeoutAction or e5.RejectAction, or empty when
+	// compensation is disabled
+	Action e5.Action
+	// DisabledPenaltyRefTypes are the penalty reference types whose accounts are left locked
+	DisabledPenaltyRefTypes map[string]bool
+}
+
+// GetCompensationPolicy returns the compensation policy configured for the service
+func GetCompensationPolicy(cfg *config.Config) CompensationPolicy {
+	policy := CompensationPolicy{DisabledPenaltyRefTypes: map[string]bool{}}
+	if cfg == nil {
+		return policy
+	}
+
+	switch action := e5.Action(strings.TrimSpace(cfg.E5CompensationAction)); action {
+	case "":
+	case e5.TimeoutAction, e5.RejectAction:
+		policy.Action = action
+	default:
+		log.Error(fmt.Errorf("invalid E5 compensation action [%s], compensation is disabled", cfg.E5CompensationAction))
+	}
+
+	for _, penaltyRefType := range strings.Split(strings.ReplaceAll(cfg.E5CompensationDisabledPenaltyTypes, " ", ""), ",") {
+		if penaltyRefType != "" {
+			policy.DisabledPenaltyRefTypes[penaltyRefType] = true
+		}
+	}
+
+	return policy
+}
+
+// compensationAction returns the action that unlocks the account after failedAction failed for a penalty of the
+// penalty reference type, or false if the account should be left as it is. The account is only locked once the
+// payment has been created, so there is nothing to compensate when the create fails.
+func (p CompensationPolicy) compensationAction(penaltyRefType string, failedAction e5.Action) (e5.Action, bool) {
+	if p.Action == "" || p.DisabledPenaltyRefTypes[penaltyRefType] {
+		return "", false
+	}
+	if failedAction != e5.AuthoriseAction && failedAction != e5.ConfirmAction {
+		return "", false
+	}
+	return p.Action, true
+}
+
+// failedPayment identifies a payment that failed part way through in E5
+type failedPayment struct {
+	companyCode    string
+	customerCode   string
+	payableRef     string
+	e5PaymentID    string
+	penaltyRefType string
+	failedAction   e5.Action
+}
+
+// compensate times out or rejects a payment that failed part way through so that the customer account is unlocked
+// in E5, and records the outcome on the payable resource. It is sent even if ctx has been cancelled, as the account
+// would otherwise stay locked. Recording a successful compensation clears the E5 progress, so a payment processed
+// again is created again rather than resumed against the closed E5 payment.
+func compensate(ctx context.Context, policy CompensationPolicy, client e5.ClientInterface,
+	payableResourceDaoService dao.PayableResourceDaoService, payment failedPayment, requestId string) {
+	action, ok := policy.compensationAction(payment.penaltyRefType, payment.failedAction)
+	if !ok {
+		return
+	}
+
+	logContext := log.Data{
+		"company_code":        payment.companyCode,
+		"customer_code":       payment.customerCode,
+		"payable_ref":         payment.payableRef,
+		"e5_payment_id":       payment.e5PaymentID,
+		"e5_action":           payment.failedAction,
+		"compensation_action": action,
+	}
+	log.InfoC(requestId, "compensating failed E5 payment", logContext)
+
+	input := &e5.PaymentActionInput{
+		CompanyCode: payment.companyCode,
+		PaymentID:   payment.e5PaymentID,
+	}
+
+	var err error
+	ctx = context.WithoutCancel(ctx)
+	if action == e5.RejectAction {
+		err = client.RejectPayment(ctx, input, requestId)
+	} else {
+		err = client.TimeoutPayment(ctx, input, requestId)
+	}
+
+	if err != nil {
+		log.ErrorC(requestId, fmt.Errorf("error compensating failed E5 payment: [%v]", err), logContext)
+	} else {
+		log.InfoC(requestId, "compensated failed E5 payment", logContext)
+	}
+
+	if svcErr := payableResourceDaoService.SaveE5Compensation(payment.customerCode, payment.payableRef, requestId, action, err); svcErr != nil {
+		log.ErrorC(requestId, svcErr, logContext)
+	}
+}
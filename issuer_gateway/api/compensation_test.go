@@ -0,0 +1,59 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/testutils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitGetCompensationPolicy(t *testing.T) {
+	Convey("Given compensation is not configured", t, func() {
+		policy := GetCompensationPolicy(&config.Config{})
+
+		Convey("Then accounts are left locked", func() {
+			_, ok := policy.compensationAction(testutils.LateFilingPenaltyRefType, e5.AuthoriseAction)
+			So(ok, ShouldBeFalse)
+			So(GetCompensationPolicy(nil).Action, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given an invalid compensation action", t, func() {
+		policy := GetCompensationPolicy(&config.Config{E5CompensationAction: "confirm"})
+
+		Convey("Then compensation is disabled", func() {
+			So(policy.Action, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given compensation by timeout disabled for sanctions", t, func() {
+		policy := GetCompensationPolicy(&config.Config{
+			E5CompensationAction:               "timeout",
+			E5CompensationDisabledPenaltyTypes: "SANCTIONS, SANCTIONS_ROE",
+		})
+
+		Convey("Then late filing payments that fail after they are created are timed out", func() {
+			action, ok := policy.compensationAction(testutils.LateFilingPenaltyRefType, e5.AuthoriseAction)
+			So(ok, ShouldBeTrue)
+			So(action, ShouldEqual, e5.TimeoutAction)
+
+			action, ok = policy.compensationAction(testutils.LateFilingPenaltyRefType, e5.ConfirmAction)
+			So(ok, ShouldBeTrue)
+			So(action, ShouldEqual, e5.TimeoutAction)
+		})
+
+		Convey("Then payments that fail to be created are not compensated", func() {
+			_, ok := policy.compensationAction(testutils.LateFilingPenaltyRefType, e5.CreateAction)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Then sanctions payments are left locked", func() {
+			_, ok := policy.compensationAction(testutils.SanctionsPenaltyRefType, e5.AuthoriseAction)
+			So(ok, ShouldBeFalse)
+			_, ok = policy.compensationAction(testutils.SanctionsRoePenaltyRefType, e5.ConfirmAction)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
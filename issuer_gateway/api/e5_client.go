@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/config"
+)
+
+var e5Client *e5.CircuitBreaker
+var e5ClientMtx sync.Mutex
+
+// GetE5Client returns the E5 client shared across the service, so that every caller reuses the same pool of
+// keep-alive connections to E5 and the same circuit breaker
+func GetE5Client(cfg *config.Config) (e5.ClientInterface, error) {
+	client, err := getCircuitBreaker(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// getCircuitBreaker creates the shared E5 client with its circuit breaker the first time it is called
+func getCircuitBreaker(cfg *config.Config) (*e5.CircuitBreaker, error) {
+	e5ClientMtx.Lock()
+	defer e5ClientMtx.Unlock()
+
+	if e5Client != nil {
+		return e5Client, nil
+	}
+
+	httpClient, err := e5.NewHTTPClient(e5.HTTPClientConfig{
+		ConnectTimeout:      getE5Duration(cfg.E5ConnectTimeout, e5.DefaultConnectTimeout),
+		ReadTimeout:         getE5Duration(cfg.E5ReadTimeout, e5.DefaultReadTimeout),
+		MaxIdleConnsPerHost: cfg.E5MaxIdleConnsPerHost,
+		ProxyURL:            cfg.E5ProxyURL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating E5 http client: [%v]", err)
+	}
+
+	e5Client = e5.NewCircuitBreaker(e5.NewClient(cfg.E5Username, cfg.E5APIURL, httpClient), e5.CircuitBreakerConfig{
+		FailureThreshold: cfg.E5CircuitBreakerFailureThreshold,
+		OpenDuration:     getE5Duration(cfg.E5CircuitBreakerOpenDuration, e5.DefaultOpenDuration),
+	})
+
+	return e5Client, nil
+}
+
+// CheckFinanceSystemCircuit reports whether requests to E5 are failing fast because E5 has been failing, and the
+// estimated time at which E5 will be retried. The shared E5 client is created if no request has created it yet, so
+// that the circuit is always read from the breaker that requests go through. E5 is reported unavailable if the client
+// cannot be created.
+func CheckFinanceSystemCircuit() (systemAvailableTime time.Time, systemUnavailable bool) {
+	cfg, err := config.Get()
+	if err != nil {
+		log.Error(fmt.Errorf("error getting config to check the E5 circuit breaker: [%v]", err))
+		return time.Time{}, true
+	}
+
+	client, err := getCircuitBreaker(cfg)
+	if err != nil {
+		log.Error(fmt.Errorf("error getting the E5 client to check its circuit breaker: [%v]", err))
+		return time.Time{}, true
+	}
+
+	systemUnavailable, systemAvailableTime = client.State()
+	return systemAvailableTime, systemUnavailable
+}
+
+func getE5Duration(durationString string, defaultDuration time.Duration) time.Duration {
+	if durationString == "" {
+		return defaultDuration
+	}
+
+	duration, err := time.ParseDuration(durationString)
+	if err != nil {
+		log.Error(fmt.Errorf("error parsing E5 duration [%s], applying default of %s: %v", durationString, defaultDuration, err))
+		return defaultDuration
+	}
+
+	return duration
+}
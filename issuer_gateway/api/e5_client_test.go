@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitGetE5Client(t *testing.T) {
+	Convey("Given E5 transport config", t, func() {
+		e5Client = nil
+		defer func() { e5Client = nil }()
+
+		cfg := &config.Config{
+			E5Username:            "foo",
+			E5APIURL:              "https://e5",
+			E5ConnectTimeout:      "2s",
+			E5ReadTimeout:         "10s",
+			E5MaxIdleConnsPerHost: 20,
+		}
+
+		Convey("Then the same client is shared by every caller", func() {
+			client, err := GetE5Client(cfg)
+			So(err, ShouldBeNil)
+			otherClient, err := GetE5Client(cfg)
+			So(err, ShouldBeNil)
+
+			So(client, ShouldEqual, otherClient)
+		})
+	})
+
+	Convey("Given an invalid E5 proxy", t, func() {
+		e5Client = nil
+		defer func() { e5Client = nil }()
+
+		client, err := GetE5Client(&config.Config{E5ProxyURL: "not a proxy"})
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldNotBeNil)
+			So(client, ShouldBeNil)
+		})
+	})
+}
+
+func TestUnitCheckFinanceSystemCircuit(t *testing.T) {
+	Convey("Given no E5 client has been created", t, func() {
+		e5Client = nil
+		defer func() { e5Client = nil }()
+
+		_, systemUnavailable := CheckFinanceSystemCircuit()
+
+		Convey("Then the client is created and the finance system is reported available", func() {
+			So(systemUnavailable, ShouldBeFalse)
+			So(e5Client, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given E5 has failed repeatedly", t, func() {
+		mockClient := &mockE5Client{}
+		mockClient.On("ConfirmPayment", &e5.PaymentActionInput{}).
+			Return(&url.Error{Op: "Post", URL: "https://e5", Err: errors.New("connection refused")})
+		e5Client = e5.NewCircuitBreaker(mockClient, e5.CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Minute})
+		defer func() { e5Client = nil }()
+
+		err := e5Client.ConfirmPayment(context.Background(), &e5.PaymentActionInput{}, "")
+		So(err, ShouldNotBeNil)
+
+		Convey("Then the finance system is reported unavailable until E5 is retried", func() {
+			systemAvailableTime, systemUnavailable := CheckFinanceSystemCircuit()
+
+			So(systemUnavailable, ShouldBeTrue)
+			So(systemAvailableTime, ShouldHappenAfter, time.Now())
+		})
+	})
+}
+
+func TestUnitGetE5Duration(t *testing.T) {
+	Convey("Given E5 durations in config", t, func() {
+		So(getE5Duration("", 5*time.Second), ShouldEqual, 5*time.Second)
+		So(getE5Duration("1m", 5*time.Second), ShouldEqual, time.Minute)
+		So(getE5Duration("invalid", 5*time.Second), ShouldEqual, 5*time.Second)
+	})
+}
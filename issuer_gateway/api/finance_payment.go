@@ -1,7 +1,11 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/avast/retry-go"
@@ -9,12 +13,18 @@ import (
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api/common/dao"
 	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// ErrPayableResourceNotFound is returned when the payable resource of a penalty payment message does not exist, which
+// processing the message again will not change
+var ErrPayableResourceNotFound = errors.New("payable resource of financial penalty payment not found")
+
 // FinancePayment interface declares the processing handler for the consumer
 type FinancePayment interface {
-	ProcessFinancialPenaltyPayment(penaltyPayment models.PenaltyPaymentsProcessing, e5PaymentID string,
+	ProcessFinancialPenaltyPayment(ctx context.Context, penaltyPayment models.PenaltyPaymentsProcessing, e5PaymentID string,
 		cfg *config.Config, isRetry bool) error
 }
 
@@ -26,10 +36,11 @@ type PenaltyFinancePayment struct {
 
 // ProcessFinancialPenaltyPayment will update the transactions in E5 as paid.
 // Three http requests are needed to mark a transactions as paid. The process is 1) create the payment, 2) authorise
-// the payments and finally 3) confirm the payment. If any one of these fails, the company account will be locked in
-// E5. Finance have confirmed that it is better to keep these locked as a cleanup process will happen naturally in
-// the working day.
-func (p PenaltyFinancePayment) ProcessFinancialPenaltyPayment(penaltyPayment models.PenaltyPaymentsProcessing,
+// the payments and finally 3) confirm the payment. If the authorise or confirm fails, the company account will be
+// locked in E5. Unless the compensation policy times out or rejects the payment to unlock it, the account is kept
+// locked as a cleanup process will happen naturally in the working day. Retries stop as soon as the context is
+// cancelled, e.g. when the consumer is shutting down.
+func (p PenaltyFinancePayment) ProcessFinancialPenaltyPayment(ctx context.Context, penaltyPayment models.PenaltyPaymentsProcessing,
 	e5PaymentID string, cfg *config.Config, isRetry bool) error {
 	logContext := log.Data{
 		"customer_code":           penaltyPayment.CustomerCode,
@@ -46,60 +57,143 @@ func (p PenaltyFinancePayment) ProcessFinancialPenaltyPayment(penaltyPayment mod
 		return nil
 	}
 
-	var err error
-
-	err = withRetry(cfg, e5.CreateAction, func() error {
-		return createPayment(penaltyPayment, p.E5Client, e5PaymentID)
-	})
+	// a payment processed again from the retry topic resumes at the first step that has not succeeded, as E5 rejects
+	// a second create or authorise with the same PUON. A payment that was compensated is closed in E5, so the steps
+	// before the compensation do not count and it is created again.
+	progress, err := p.PayableResourceDaoService.GetE5Progress(penaltyPayment.CustomerCode, penaltyPayment.PayableRef, "")
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		log.Error(ErrPayableResourceNotFound, logContext)
+		return ErrPayableResourceNotFound
+	}
 	if err != nil {
-		if penaltyPayment.Attempt < int32(cfg.ConsumerRetryMaxAttempts) {
-			return err // put it on the retry topic
+		log.Error(fmt.Errorf("error getting E5 progress of financial penalty payment: [%v]", err), logContext)
+		return err
+	}
+
+	if progress.Compensated() {
+		log.Info("Creating payment again as it was compensated", logContext, log.Data{"compensated_at": progress.CompensatedAt})
+	}
+	if progress.Completed(e5.CreateAction) {
+		log.Info("Skipping create payment as it has already succeeded", logContext, log.Data{"created_at": progress.CreatedAt})
+	} else {
+		err = withRetry(ctx, cfg, e5.CreateAction, func() error {
+			return createPayment(ctx, penaltyPayment, p.E5Client, e5PaymentID)
+		})
+		if err != nil {
+			// only put it on the retry topic if E5 may accept it later, e.g. not when a transaction reference is invalid
+			if penaltyPayment.Attempt < int32(cfg.ConsumerRetryMaxAttempts) &&
+				e5.IsRetryable(lastError(err), getRetryableMessageCodes(cfg)) {
+				return err // put it on the retry topic
+			}
+			saveE5Error(penaltyPayment, p.PayableResourceDaoService, err, e5PaymentID, e5.CreateAction)
+			return nil // don't put it on the retry topic
 		}
-		saveE5Error(penaltyPayment, p.PayableResourceDaoService, err, e5PaymentID, e5.CreateAction)
-		return nil // don't put it on the retry topic
+		saveE5Progress(penaltyPayment, p.PayableResourceDaoService, e5.CreateAction, logContext)
 	}
 
-	err = withRetry(cfg, e5.AuthoriseAction, func() error {
-		return authorisePayment(penaltyPayment, p.E5Client, e5PaymentID)
-	})
-	if err != nil {
-		saveE5Error(penaltyPayment, p.PayableResourceDaoService, err, e5PaymentID, e5.AuthoriseAction)
-		return nil // don't put it on the retry topic
+	if progress.Completed(e5.AuthoriseAction) {
+		log.Info("Skipping authorise payment as it has already succeeded", logContext, log.Data{"authorised_at": progress.AuthorisedAt})
+	} else {
+		err = withRetry(ctx, cfg, e5.AuthoriseAction, func() error {
+			return authorisePayment(ctx, penaltyPayment, p.E5Client, e5PaymentID)
+		})
+		if err != nil {
+			p.compensate(ctx, cfg, penaltyPayment, e5PaymentID, e5.AuthoriseAction)
+			saveE5Error(penaltyPayment, p.PayableResourceDaoService, err, e5PaymentID, e5.AuthoriseAction)
+			return nil // don't put it on the retry topic
+		}
+		saveE5Progress(penaltyPayment, p.PayableResourceDaoService, e5.AuthoriseAction, logContext)
 	}
 
-	err = withRetry(cfg, e5.ConfirmAction, func() error {
-		return confirmPayment(penaltyPayment, p.E5Client, e5PaymentID)
-	})
-	if err != nil {
-		saveE5Error(penaltyPayment, p.PayableResourceDaoService, err, e5PaymentID, e5.ConfirmAction)
-		return nil // don't put it on the retry topic
+	if progress.Completed(e5.ConfirmAction) {
+		log.Info("Skipping confirm payment as it has already succeeded", logContext, log.Data{"confirmed_at": progress.ConfirmedAt})
+	} else {
+		err = withRetry(ctx, cfg, e5.ConfirmAction, func() error {
+			return confirmPayment(ctx, penaltyPayment, p.E5Client, e5PaymentID)
+		})
+		if err != nil {
+			p.compensate(ctx, cfg, penaltyPayment, e5PaymentID, e5.ConfirmAction)
+			saveE5Error(penaltyPayment, p.PayableResourceDaoService, err, e5PaymentID, e5.ConfirmAction)
+			return nil // don't put it on the retry topic
+		}
+		saveE5Progress(penaltyPayment, p.PayableResourceDaoService, e5.ConfirmAction, logContext)
 	}
 
 	log.Info("Financial penalty payment processing successful", logContext)
 	return nil
 }
 
+// compensate applies the compensation policy to a payment that failed after it was created in E5
+func (p PenaltyFinancePayment) compensate(ctx context.Context, cfg *config.Config,
+	penaltyPayment models.PenaltyPaymentsProcessing, e5PaymentID string, failedAction e5.Action) {
+	var penaltyRefType string
+	if len(penaltyPayment.TransactionPayments) > 0 {
+		penaltyRefType, _ = utils.GetPenaltyRefTypeFromTransaction([]models.TransactionItem{
+			{PenaltyRef: penaltyPayment.TransactionPayments[0].TransactionReference},
+		})
+	}
+
+	compensate(ctx, GetCompensationPolicy(cfg), p.E5Client, p.PayableResourceDaoService, failedPayment{
+		companyCode:    penaltyPayment.CompanyCode,
+		customerCode:   penaltyPayment.CustomerCode,
+		payableRef:     penaltyPayment.PayableRef,
+		e5PaymentID:    e5PaymentID,
+		penaltyRefType: penaltyRefType,
+		failedAction:   failedAction,
+	}, "")
+}
+
 func isAfter24Hours(createdAt string) bool {
 	parsed, _ := time.Parse(time.RFC3339, createdAt)
 	return time.Now().After(parsed.Add(24 * time.Hour))
 }
 
-func withRetry(cfg *config.Config, action e5.Action, fn func() error) error {
+func withRetry(ctx context.Context, cfg *config.Config, action e5.Action, fn func() error) error {
 	attempts := getMaxRetryAttempts(cfg)
 	delay := getDelay(cfg)
 	maxDelay := getMaxDelay(cfg)
+	retryableMessageCodes := getRetryableMessageCodes(cfg)
 
 	return retry.Do(
 		fn,
 		retry.Attempts(attempts),
 		retry.Delay(delay),
 		retry.MaxDelay(maxDelay),
+		retry.Context(ctx),
+		retry.RetryIf(func(err error) bool {
+			return e5.IsRetryable(err, retryableMessageCodes)
+		}),
 		retry.OnRetry(func(n uint, err error) {
-			log.Info("Penalty payment processing retry attempt failed: " + string(action))
+			log.Info("Penalty payment processing retry attempt failed: "+string(action), log.Data{
+				"message_code": e5.MessageCode(err),
+			})
 		}),
 	)
 }
 
+// lastError returns the error of the final attempt from the errors of every attempt returned by retry.Do
+func lastError(err error) error {
+	if retryErrs, ok := err.(retry.Error); ok {
+		for i := len(retryErrs) - 1; i >= 0; i-- {
+			if retryErrs[i] != nil {
+				return retryErrs[i]
+			}
+		}
+	}
+	return err
+}
+
+// getRetryableMessageCodes returns the E5 message codes of rejected requests that may succeed if retried
+func getRetryableMessageCodes(cfg *config.Config) map[string]bool {
+	messageCodes := map[string]bool{}
+	for _, messageCode := range strings.Split(strings.ReplaceAll(cfg.E5RetryableMessageCodes, " ", ""), ",") {
+		if messageCode != "" {
+			messageCodes[messageCode] = true
+		}
+	}
+	return messageCodes
+}
+
 func getMaxRetryAttempts(cfg *config.Config) uint {
 	var attemptsStr = cfg.PenaltyPaymentsProcessingMaxRetries
 	var attempts = uint(3)
@@ -127,7 +221,7 @@ func getMaxDelay(cfg *config.Config) time.Duration {
 	return maxDelay
 }
 
-func createPayment(penaltyPayment models.PenaltyPaymentsProcessing, client e5.ClientInterface,
+func createPayment(ctx context.Context, penaltyPayment models.PenaltyPaymentsProcessing, client e5.ClientInterface,
 	e5PaymentID string) (err error) {
 	var e5Transactions []*e5.CreatePaymentTransaction
 
@@ -137,7 +231,7 @@ func createPayment(penaltyPayment models.PenaltyPaymentsProcessing, client e5.Cl
 			Value:                t.Value,
 		})
 	}
-	err = client.CreatePayment(&e5.CreatePaymentInput{
+	err = client.CreatePayment(ctx, &e5.CreatePaymentInput{
 		CompanyCode:  penaltyPayment.CompanyCode,
 		CustomerCode: penaltyPayment.CustomerCode,
 		PaymentID:    e5PaymentID,
@@ -150,9 +244,9 @@ func createPayment(penaltyPayment models.PenaltyPaymentsProcessing, client e5.Cl
 	return nil
 }
 
-func authorisePayment(penaltyPayment models.PenaltyPaymentsProcessing, client e5.ClientInterface,
+func authorisePayment(ctx context.Context, penaltyPayment models.PenaltyPaymentsProcessing, client e5.ClientInterface,
 	e5PaymentID string) (err error) {
-	err = client.AuthorisePayment(&e5.AuthorisePaymentInput{
+	err = client.AuthorisePayment(ctx, &e5.AuthorisePaymentInput{
 		CompanyCode:   penaltyPayment.CompanyCode,
 		PaymentID:     e5PaymentID,
 		CardReference: penaltyPayment.ExternalPaymentID,
@@ -165,9 +259,9 @@ func authorisePayment(penaltyPayment models.PenaltyPaymentsProcessing, client e5
 	return nil
 }
 
-func confirmPayment(penaltyPayment models.PenaltyPaymentsProcessing, client e5.ClientInterface,
+func confirmPayment(ctx context.Context, penaltyPayment models.PenaltyPaymentsProcessing, client e5.ClientInterface,
 	e5PaymentID string) (err error) {
-	err = client.ConfirmPayment(&e5.PaymentActionInput{
+	err = client.ConfirmPayment(ctx, &e5.PaymentActionInput{
 		CompanyCode: penaltyPayment.CompanyCode,
 		PaymentID:   e5PaymentID,
 	}, "")
@@ -177,17 +271,28 @@ func confirmPayment(penaltyPayment models.PenaltyPaymentsProcessing, client e5.C
 	return nil
 }
 
+// saveE5Progress records that a step succeeded. A failure to record it is logged rather than failing the payment, as
+// the step has been applied in E5 and would only be repeated if the payment is processed again.
+func saveE5Progress(penaltyPayment models.PenaltyPaymentsProcessing, payableResourceDaoService dao.PayableResourceDaoService,
+	e5Action e5.Action, logContext log.Data) {
+	if err := payableResourceDaoService.SaveE5Progress(penaltyPayment.CustomerCode, penaltyPayment.PayableRef, "", e5Action); err != nil {
+		log.Error(fmt.Errorf("error saving E5 progress of financial penalty payment: [%v]", err), logContext, log.Data{"e5_action": e5Action})
+	}
+}
+
 func saveE5Error(penaltyPayment models.PenaltyPaymentsProcessing, payableResourceDaoService dao.PayableResourceDaoService,
 	e5PaymentError error, e5PaymentID string, e5Action e5.Action) {
+	e5Err := lastError(e5PaymentError)
 	logContext := log.Data{
-		"customer_code": penaltyPayment.CustomerCode,
-		"company_code":  penaltyPayment.CompanyCode,
-		"payable_ref":   penaltyPayment.PayableRef,
-		"e5_payment_id": e5PaymentID,
-		"e5_action":     e5Action,
+		"customer_code":   penaltyPayment.CustomerCode,
+		"company_code":    penaltyPayment.CompanyCode,
+		"payable_ref":     penaltyPayment.PayableRef,
+		"e5_payment_id":   e5PaymentID,
+		"e5_action":       e5Action,
+		"e5_message_code": e5.MessageCode(e5Err),
 	}
 	log.Error(e5PaymentError, logContext)
-	if svcErr := payableResourceDaoService.SaveE5Error(penaltyPayment.CustomerCode, penaltyPayment.PayableRef, "", e5Action); svcErr != nil {
+	if svcErr := payableResourceDaoService.SaveE5Error(penaltyPayment.CustomerCode, penaltyPayment.PayableRef, "", e5Action, e5Err); svcErr != nil {
 		log.Error(svcErr, logContext)
 	}
 }
@@ -1,15 +1,19 @@
 package api
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"testing"
 	"time"
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
 	"github.com/companieshouse/penalty-payment-api/config"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var (
@@ -34,30 +38,33 @@ type mockE5Client struct {
 	mock.Mock
 }
 
-func (m *mockE5Client) GetTransactions(input *e5.GetTransactionsInput, _ string) (*e5.GetTransactionsResponse, error) {
+func (m *mockE5Client) GetTransactions(_ context.Context, input *e5.GetTransactionsInput, _ string) (*e5.GetTransactionsResponse, error) {
 	m.Called(input)
 	return nil, errors.New("get transactions not used")
 }
 
-func (m *mockE5Client) TimeoutPayment(input *e5.PaymentActionInput, _ string) error {
+func (m *mockE5Client) GetCompanyTransactions(_ context.Context, input *e5.GetCompanyTransactionsInput, _ e5.TransactionsPageHandler, _ string) error {
 	m.Called(input)
-	return errors.New("timeout payment not used")
+	return errors.New("get company transactions not used")
 }
 
-func (m *mockE5Client) RejectPayment(input *e5.PaymentActionInput, _ string) error {
-	m.Called(input)
-	return errors.New("reject payment not used")
+func (m *mockE5Client) TimeoutPayment(_ context.Context, input *e5.PaymentActionInput, _ string) error {
+	return m.Called(input).Error(0)
+}
+
+func (m *mockE5Client) RejectPayment(_ context.Context, input *e5.PaymentActionInput, _ string) error {
+	return m.Called(input).Error(0)
 }
 
-func (m *mockE5Client) CreatePayment(input *e5.CreatePaymentInput, _ string) error {
+func (m *mockE5Client) CreatePayment(_ context.Context, input *e5.CreatePaymentInput, _ string) error {
 	return m.Called(input).Error(0)
 }
 
-func (m *mockE5Client) AuthorisePayment(input *e5.AuthorisePaymentInput, _ string) error {
+func (m *mockE5Client) AuthorisePayment(_ context.Context, input *e5.AuthorisePaymentInput, _ string) error {
 	return m.Called(input).Error(0)
 }
 
-func (m *mockE5Client) ConfirmPayment(input *e5.PaymentActionInput, _ string) error {
+func (m *mockE5Client) ConfirmPayment(_ context.Context, input *e5.PaymentActionInput, _ string) error {
 	return m.Called(input).Error(0)
 }
 
@@ -77,7 +84,7 @@ func (m *mockDAO) GetPayableResource(customerCode, payableRef, _ string) (*model
 	return nil, errors.New("get payable resource not used")
 }
 
-func (m *mockDAO) UpdatePaymentDetails(dao *models.PayableResourceDao, _ string) error {
+func (m *mockDAO) UpdatePaymentDetails(dao *models.PayableResourceDao, _ []outbox.Message, _ string) error {
 	m.Called(dao)
 	return errors.New("update payment details not used")
 }
@@ -86,10 +93,68 @@ func (m *mockDAO) Shutdown() {
 	panic("shutdown not used")
 }
 
-func (m *mockDAO) SaveE5Error(customerCode, payableRef, _ string, action e5.Action) error {
+func (m *mockDAO) SaveE5Error(customerCode, payableRef, _ string, action e5.Action, e5Err error) error {
+	return m.Called(customerCode, payableRef, action, e5.MessageCode(e5Err)).Error(0)
+}
+
+func (m *mockDAO) SaveE5Compensation(customerCode, payableRef, _ string, action e5.Action, compensationErr error) error {
+	return m.Called(customerCode, payableRef, action, compensationErr).Error(0)
+}
+
+func (m *mockDAO) SaveE5Progress(customerCode, payableRef, _ string, action e5.Action) error {
 	return m.Called(customerCode, payableRef, action).Error(0)
 }
 
+func (m *mockDAO) GetE5Progress(customerCode, payableRef, _ string) (*e5.PaymentProgress, error) {
+	args := m.Called(customerCode, payableRef)
+	progress, _ := args.Get(0).(*e5.PaymentProgress)
+	return progress, args.Error(1)
+}
+
+func (m *mockDAO) RecordPaymentSession(_, _, _, _ string) error {
+	panic("record payment session not used")
+}
+
+func (m *mockDAO) RecordUnidentifiedPaymentSession(_, _, _ string) error {
+	panic("record unidentified payment session not used")
+}
+
+func (m *mockDAO) GetPaymentSessions(_, _, _ string) ([]string, error) {
+	panic("get payment sessions not used")
+}
+
+func (m *mockDAO) SaveFailedMarkAsPaidSteps(_, _ string, _ []string, _ string) error {
+	panic("save failed mark as paid steps not used")
+}
+
+func (m *mockDAO) GetFailedMarkAsPaidSteps(_, _, _ string) ([]string, error) {
+	panic("get failed mark as paid steps not used")
+}
+
+func (m *mockDAO) AddOutboxMessages(_ []outbox.Message, _ string) error {
+	panic("add outbox messages not used")
+}
+
+func (m *mockDAO) GetStalePendingPayableResources(_, _ time.Time, _ int, _ string) ([]models.PayableResourceDao, error) {
+	panic("get stale pending payable resources not used")
+}
+
+func (m *mockDAO) GetExpirablePayableResources(_, _ time.Time, _ int, _ string) ([]models.PayableResourceDao, error) {
+	panic("get expirable payable resources not used")
+}
+
+func (m *mockDAO) ExpirePayableResource(_, _ string, _, _ time.Time, _ string) (bool, error) {
+	panic("expire payable resource not used")
+}
+
+func (m *mockDAO) CancelPayableResource(_, _ string, _ time.Time, _ string) (bool, error) {
+	panic("cancel payable resource not used")
+}
+
+func (m *mockDAO) EnsureExpiredTTLIndex(_ time.Duration) error {
+	panic("ensure expired TTL index not used")
+}
+
 func TestUnitProcessFinancialPenaltyPayment_IsAfter24Hours(t *testing.T) {
 	Convey("Process financial penalty payment is after 24 hours", t, func() {
 		// Given
@@ -100,7 +165,7 @@ func TestUnitProcessFinancialPenaltyPayment_IsAfter24Hours(t *testing.T) {
 		}
 
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPaymentToSkip, e5PaymentID, cfg, false)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPaymentToSkip, e5PaymentID, cfg, false)
 
 		// Then
 		So(err, ShouldBeNil)
@@ -119,7 +184,7 @@ func TestUnitProcessFinancialPenaltyPayment_Success(t *testing.T) {
 		e5Client.On("ConfirmPayment", mock.Anything).Return(nil)
 
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment, e5PaymentID, cfg, false)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, cfg, false)
 
 		// Then
 		So(err, ShouldBeNil)
@@ -128,6 +193,36 @@ func TestUnitProcessFinancialPenaltyPayment_Success(t *testing.T) {
 	})
 }
 
+func TestUnitCreatePayment(t *testing.T) {
+	Convey("The payment is allocated across a penalty and the costs paid together with it", t, func() {
+		e5Client := &mockE5Client{}
+		payment := newPenaltyPayment(1)
+		payment.CompanyCode = "LP"
+		payment.TotalValue = 3185
+		payment.TransactionPayments = []models.TransactionPayment{
+			{TransactionReference: "A0000001", Value: 3000},
+			{TransactionReference: "CF1", Value: 105},
+			{TransactionReference: "FC1", Value: 80},
+		}
+		e5Client.On("CreatePayment", &e5.CreatePaymentInput{
+			CompanyCode:  "LP",
+			CustomerCode: "OE123456",
+			PaymentID:    e5PaymentID,
+			TotalValue:   3185,
+			Transactions: []*e5.CreatePaymentTransaction{
+				{TransactionReference: "A0000001", Value: 3000},
+				{TransactionReference: "CF1", Value: 105},
+				{TransactionReference: "FC1", Value: 80},
+			},
+		}).Return(nil).Once()
+
+		err := createPayment(context.Background(), payment, e5Client, e5PaymentID)
+
+		So(err, ShouldBeNil)
+		e5Client.AssertExpectations(t)
+	})
+}
+
 func TestUnitProcessFinancialPenaltyPayment_CreatePaymentFails(t *testing.T) {
 	Convey("Process financial penalty payment create payment fails", t, func() {
 		// Given
@@ -136,12 +231,12 @@ func TestUnitProcessFinancialPenaltyPayment_CreatePaymentFails(t *testing.T) {
 		e5Client.On("CreatePayment", mock.Anything).Return(errors.New("create payment in E5 failed"))
 
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment, e5PaymentID, cfg, false)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, cfg, false)
 
 		// Then
 		So(err, ShouldBeError, errors.New("All attempts fail:\n#1: create payment in E5 failed\n#2: create payment in E5 failed\n#3: create payment in E5 failed"))
 		e5Client.AssertExpectations(t)
-		DAO.AssertNotCalled(t, "SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.CreateAction)
+		DAO.AssertNotCalled(t, "SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.CreateAction, "")
 	})
 }
 
@@ -152,10 +247,10 @@ func TestUnitProcessFinancialPenaltyPayment_AuthorisePaymentFails(t *testing.T)
 
 		e5Client.On("CreatePayment", mock.Anything).Return(nil)
 		e5Client.On("AuthorisePayment", mock.Anything).Return(errors.New("authorise payment in E5 failed"))
-		DAO.On("SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.AuthoriseAction).Return(nil)
+		DAO.On("SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.AuthoriseAction, "").Return(nil)
 
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment, e5PaymentID, cfg, false)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, cfg, false)
 
 		// Then
 		So(err, ShouldBeNil)
@@ -172,10 +267,10 @@ func TestUnitProcessFinancialPenaltyPayment_ConfirmPaymentFails(t *testing.T) {
 		e5Client.On("CreatePayment", mock.Anything).Return(nil)
 		e5Client.On("AuthorisePayment", mock.Anything).Return(nil)
 		e5Client.On("ConfirmPayment", mock.Anything).Return(errors.New("confirm payment in E5 failed"))
-		DAO.On("SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.ConfirmAction).Return(nil)
+		DAO.On("SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.ConfirmAction, "").Return(nil)
 
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment, e5PaymentID, cfg, false)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, cfg, false)
 
 		// Then
 		So(err, ShouldBeNil)
@@ -184,6 +279,271 @@ func TestUnitProcessFinancialPenaltyPayment_ConfirmPaymentFails(t *testing.T) {
 	})
 }
 
+func TestUnitProcessFinancialPenaltyPayment_E5RejectsPayment(t *testing.T) {
+	retryCfg := *cfg
+	retryCfg.E5RetryableMessageCodes = "BL101, BL103"
+
+	Convey("Process financial penalty payment create payment rejected by E5", t, func() {
+		// Given
+		e5Client, DAO, handler := financePaymentTestSetup()
+
+		e5Client.On("CreatePayment", mock.Anything).
+			Return(&e5.APIError{StatusCode: http.StatusBadRequest, MessageCode: "BL102", Message: "Invalid transaction reference"}).Once()
+		DAO.On("SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.CreateAction, "BL102").Return(nil)
+
+		// When
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, &retryCfg, false)
+
+		// Then it is not retried or put on the retry topic
+		So(err, ShouldBeNil)
+		e5Client.AssertNumberOfCalls(t, "CreatePayment", 1)
+		DAO.AssertExpectations(t)
+	})
+
+	Convey("Process financial penalty payment create payment rejected by E5 with a retryable message code", t, func() {
+		// Given
+		e5Client, DAO, handler := financePaymentTestSetup()
+
+		e5Client.On("CreatePayment", mock.Anything).
+			Return(&e5.APIError{StatusCode: http.StatusBadRequest, MessageCode: "BL101", Message: "Account is locked"})
+
+		// When
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, &retryCfg, false)
+
+		// Then it is retried and put on the retry topic
+		So(err, ShouldNotBeNil)
+		So(e5.MessageCode(lastError(err)), ShouldEqual, "BL101")
+		e5Client.AssertNumberOfCalls(t, "CreatePayment", 3)
+		DAO.AssertNotCalled(t, "SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.CreateAction, "BL101")
+	})
+
+	Convey("Process financial penalty payment authorise payment rejected by E5", t, func() {
+		// Given
+		e5Client, DAO, handler := financePaymentTestSetup()
+
+		e5Client.On("CreatePayment", mock.Anything).Return(nil)
+		e5Client.On("AuthorisePayment", mock.Anything).
+			Return(&e5.APIError{StatusCode: http.StatusBadRequest, MessageCode: "BL104"}).Once()
+		DAO.On("SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.AuthoriseAction, "BL104").Return(nil)
+
+		// When
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, &retryCfg, false)
+
+		// Then the message code is saved
+		So(err, ShouldBeNil)
+		e5Client.AssertExpectations(t)
+		DAO.AssertExpectations(t)
+	})
+}
+
+func TestUnitProcessFinancialPenaltyPayment_Compensation(t *testing.T) {
+	compensationCfg := *cfg
+	compensationCfg.E5CompensationAction = "timeout"
+	paymentAction := &e5.PaymentActionInput{CompanyCode: penaltyPayment.CompanyCode, PaymentID: e5PaymentID}
+
+	Convey("Process financial penalty payment authorise payment fails with compensation enabled", t, func() {
+		// Given
+		e5Client, DAO, handler := financePaymentTestSetup()
+
+		e5Client.On("CreatePayment", mock.Anything).Return(nil)
+		e5Client.On("AuthorisePayment", mock.Anything).Return(errors.New("authorise payment in E5 failed"))
+		e5Client.On("TimeoutPayment", paymentAction).Return(nil).Once()
+		DAO.On("SaveE5Compensation", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.TimeoutAction, nil).Return(nil)
+		DAO.On("SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.AuthoriseAction, "").Return(nil)
+
+		// When
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, &compensationCfg, false)
+
+		// Then the payment is timed out to unlock the account
+		So(err, ShouldBeNil)
+		e5Client.AssertExpectations(t)
+		DAO.AssertExpectations(t)
+	})
+
+	Convey("Process financial penalty payment confirm payment fails and the compensation fails", t, func() {
+		// Given
+		rejectCfg := compensationCfg
+		rejectCfg.E5CompensationAction = "reject"
+		e5Client, DAO, handler := financePaymentTestSetup()
+		rejectErr := errors.New("reject payment in E5 failed")
+
+		e5Client.On("CreatePayment", mock.Anything).Return(nil)
+		e5Client.On("AuthorisePayment", mock.Anything).Return(nil)
+		e5Client.On("ConfirmPayment", mock.Anything).Return(errors.New("confirm payment in E5 failed"))
+		e5Client.On("RejectPayment", paymentAction).Return(rejectErr).Once()
+		DAO.On("SaveE5Compensation", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.RejectAction, rejectErr).Return(nil)
+		DAO.On("SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.ConfirmAction, "").Return(nil)
+
+		// When
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, &rejectCfg, false)
+
+		// Then the failed compensation is recorded
+		So(err, ShouldBeNil)
+		e5Client.AssertExpectations(t)
+		DAO.AssertExpectations(t)
+	})
+
+	Convey("Process financial penalty payment authorise payment fails with compensation disabled for the penalty type", t, func() {
+		// Given
+		disabledCfg := compensationCfg
+		disabledCfg.E5CompensationDisabledPenaltyTypes = "LATE_FILING, SANCTIONS_ROE"
+		e5Client, DAO, handler := financePaymentTestSetup()
+
+		e5Client.On("CreatePayment", mock.Anything).Return(nil)
+		e5Client.On("AuthorisePayment", mock.Anything).Return(errors.New("authorise payment in E5 failed"))
+		DAO.On("SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.AuthoriseAction, "").Return(nil)
+
+		// When
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, &disabledCfg, false)
+
+		// Then the account is left locked
+		So(err, ShouldBeNil)
+		e5Client.AssertNotCalled(t, "TimeoutPayment", mock.Anything)
+		DAO.AssertNotCalled(t, "SaveE5Compensation", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	Convey("Process financial penalty payment retry creates the payment again after it was compensated", t, func() {
+		// Given a payment whose authorise fails and is timed out in E5
+		progress := &e5.PaymentProgress{}
+		e5Client, DAO, handler := financePaymentTestSetupWithProgress(progress)
+
+		DAO.On("SaveE5Progress", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, mock.Anything).
+			Run(func(args mock.Arguments) {
+				now := time.Now()
+				switch args.Get(2).(e5.Action) {
+				case e5.CreateAction:
+					progress.CreatedAt = &now
+				case e5.AuthoriseAction:
+					progress.AuthorisedAt = &now
+				case e5.ConfirmAction:
+					progress.ConfirmedAt = &now
+				}
+			}).Return(nil)
+		DAO.On("SaveE5Compensation", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.TimeoutAction, nil).
+			Run(func(args mock.Arguments) {
+				compensatedAt := time.Now()
+				*progress = e5.PaymentProgress{CompensatedAt: &compensatedAt}
+			}).Return(nil)
+		DAO.On("SaveE5Error", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.AuthoriseAction, "").Return(nil)
+		e5Client.On("CreatePayment", mock.Anything).Return(nil).Twice()
+		e5Client.On("AuthorisePayment", mock.Anything).Return(errors.New("authorise payment in E5 failed")).Once()
+		e5Client.On("TimeoutPayment", paymentAction).Return(nil).Once()
+
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, &compensationCfg, false)
+		So(err, ShouldBeNil)
+		So(progress.Completed(e5.CreateAction), ShouldBeFalse)
+
+		// When it is processed again and E5 accepts it
+		e5Client.On("AuthorisePayment", mock.Anything).Return(nil).Once()
+		e5Client.On("ConfirmPayment", mock.Anything).Return(nil).Once()
+		err = handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment2, e5PaymentID, &compensationCfg, true)
+
+		// Then the payment is created, authorised and confirmed again rather than resumed against the closed payment
+		So(err, ShouldBeNil)
+		So(progress.Completed(e5.ConfirmAction), ShouldBeTrue)
+		e5Client.AssertNumberOfCalls(t, "CreatePayment", 2)
+		e5Client.AssertNumberOfCalls(t, "AuthorisePayment", 2)
+		e5Client.AssertExpectations(t)
+	})
+}
+
+func TestUnitProcessFinancialPenaltyPayment_Progress(t *testing.T) {
+	Convey("Process financial penalty payment records the progress of each step", t, func() {
+		// Given
+		e5Client, DAO, handler := financePaymentTestSetupWithProgress(&e5.PaymentProgress{})
+
+		e5Client.On("CreatePayment", mock.Anything).Return(nil)
+		e5Client.On("AuthorisePayment", mock.Anything).Return(nil)
+		e5Client.On("ConfirmPayment", mock.Anything).Return(nil)
+		DAO.On("SaveE5Progress", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.CreateAction).Return(nil).Once()
+		DAO.On("SaveE5Progress", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.AuthoriseAction).Return(nil).Once()
+		DAO.On("SaveE5Progress", penaltyPayment.CustomerCode, penaltyPayment.PayableRef, e5.ConfirmAction).
+			Return(errors.New("failed to save progress")).Once()
+
+		// When
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, cfg, false)
+
+		// Then a failure to save the progress does not fail the payment
+		So(err, ShouldBeNil)
+		e5Client.AssertExpectations(t)
+		DAO.AssertExpectations(t)
+	})
+
+	Convey("Process financial penalty payment retry resumes after the steps that succeeded", t, func() {
+		// Given
+		createdAt := time.Now().Add(-time.Minute)
+		e5Client, DAO, handler := financePaymentTestSetupWithProgress(&e5.PaymentProgress{CreatedAt: &createdAt, AuthorisedAt: &createdAt})
+
+		e5Client.On("ConfirmPayment", mock.Anything).Return(nil)
+		DAO.On("SaveE5Progress", penaltyPayment2.CustomerCode, penaltyPayment2.PayableRef, e5.ConfirmAction).Return(nil).Once()
+
+		// When
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment2, e5PaymentID, cfg, true)
+
+		// Then
+		So(err, ShouldBeNil)
+		e5Client.AssertNotCalled(t, "CreatePayment", mock.Anything)
+		e5Client.AssertNotCalled(t, "AuthorisePayment", mock.Anything)
+		e5Client.AssertExpectations(t)
+		DAO.AssertExpectations(t)
+	})
+
+	Convey("Process financial penalty payment retry of a confirmed payment does nothing", t, func() {
+		// Given
+		createdAt := time.Now().Add(-time.Minute)
+		e5Client, DAO, handler := financePaymentTestSetupWithProgress(&e5.PaymentProgress{
+			CreatedAt: &createdAt, AuthorisedAt: &createdAt, ConfirmedAt: &createdAt,
+		})
+
+		// When
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment2, e5PaymentID, cfg, true)
+
+		// Then
+		So(err, ShouldBeNil)
+		e5Client.AssertNotCalled(t, "ConfirmPayment", mock.Anything)
+		DAO.AssertNotCalled(t, "SaveE5Progress", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	Convey("Process financial penalty payment fails when the progress cannot be read", t, func() {
+		// Given
+		e5Client := new(mockE5Client)
+		DAO := new(mockDAO)
+		handler := &PenaltyFinancePayment{E5Client: e5Client, PayableResourceDaoService: DAO}
+		DAO.On("GetE5Progress", penaltyPayment.CustomerCode, penaltyPayment.PayableRef).Return(nil, errors.New("mongo unavailable"))
+
+		// When
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, cfg, false)
+
+		// Then it is put on the retry topic
+		So(err, ShouldNotBeNil)
+		So(errors.Is(err, ErrPayableResourceNotFound), ShouldBeFalse)
+		e5Client.AssertNotCalled(t, "CreatePayment", mock.Anything)
+	})
+
+	Convey("Process financial penalty payment fails when the payable resource does not exist", t, func() {
+		// Given
+		e5Client := new(mockE5Client)
+		DAO := new(mockDAO)
+		handler := &PenaltyFinancePayment{E5Client: e5Client, PayableResourceDaoService: DAO}
+		DAO.On("GetE5Progress", penaltyPayment.CustomerCode, penaltyPayment.PayableRef).Return(nil, mongo.ErrNoDocuments)
+
+		// When
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment, e5PaymentID, cfg, false)
+
+		// Then it is dead-lettered rather than retried
+		So(errors.Is(err, ErrPayableResourceNotFound), ShouldBeTrue)
+		e5Client.AssertNotCalled(t, "CreatePayment", mock.Anything)
+	})
+}
+
+func TestUnitGetRetryableMessageCodes(t *testing.T) {
+	Convey("Given retryable message codes in config", t, func() {
+		So(getRetryableMessageCodes(&config.Config{}), ShouldBeEmpty)
+		So(getRetryableMessageCodes(&config.Config{E5RetryableMessageCodes: "BL101, BL103,"}), ShouldResemble,
+			map[string]bool{"BL101": true, "BL103": true})
+	})
+}
+
 func TestUnitProcessFinancialPenaltyPayment_Retry_Success(t *testing.T) {
 	// Given
 	e5Client, DAO, handler := financePaymentTestSetup()
@@ -194,7 +554,7 @@ func TestUnitProcessFinancialPenaltyPayment_Retry_Success(t *testing.T) {
 
 	Convey("Process financial penalty payment retry success with Attempt = 2", t, func() {
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment2, e5PaymentID, cfg, true)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment2, e5PaymentID, cfg, true)
 
 		// Then
 		So(err, ShouldBeNil)
@@ -204,7 +564,7 @@ func TestUnitProcessFinancialPenaltyPayment_Retry_Success(t *testing.T) {
 
 	Convey("Process financial penalty payment retry success with Attempt = 3", t, func() {
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment3, e5PaymentID, cfg, true)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment3, e5PaymentID, cfg, true)
 
 		// Then
 		So(err, ShouldBeNil)
@@ -221,19 +581,19 @@ func TestUnitProcessFinancialPenaltyPayment_Retry_CreatePaymentFails(t *testing.
 
 	Convey("Process financial penalty payment retry create payment fails with Attempt = 2", t, func() {
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment2, e5PaymentID, cfg, true)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment2, e5PaymentID, cfg, true)
 
 		// Then
 		So(err, ShouldBeError, errors.New("All attempts fail:\n#1: create payment in E5 failed\n#2: create payment in E5 failed\n#3: create payment in E5 failed"))
 		e5Client.AssertExpectations(t)
-		DAO.AssertNotCalled(t, "SaveE5Error", penaltyPayment2.CustomerCode, penaltyPayment2.PayableRef, e5.CreateAction)
+		DAO.AssertNotCalled(t, "SaveE5Error", penaltyPayment2.CustomerCode, penaltyPayment2.PayableRef, e5.CreateAction, "")
 	})
 
 	Convey("Process financial penalty payment retry create payment fails with Attempt = 3", t, func() {
-		DAO.On("SaveE5Error", penaltyPayment3.CustomerCode, penaltyPayment3.PayableRef, e5.CreateAction).Return(nil)
+		DAO.On("SaveE5Error", penaltyPayment3.CustomerCode, penaltyPayment3.PayableRef, e5.CreateAction, "").Return(nil)
 
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment3, e5PaymentID, cfg, true)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment3, e5PaymentID, cfg, true)
 
 		// Then
 		So(err, ShouldBeNil)
@@ -250,10 +610,10 @@ func TestUnitProcessFinancialPenaltyPayment_Retry_AuthorisePaymentFails(t *testi
 	e5Client.On("AuthorisePayment", mock.Anything).Return(errors.New("authorise payment in E5 failed"))
 
 	Convey("Process financial penalty payment retry authorise payment fails with Attempt = 2", t, func() {
-		DAO.On("SaveE5Error", penaltyPayment2.CustomerCode, penaltyPayment2.PayableRef, e5.AuthoriseAction).Return(nil)
+		DAO.On("SaveE5Error", penaltyPayment2.CustomerCode, penaltyPayment2.PayableRef, e5.AuthoriseAction, "").Return(nil)
 
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment2, e5PaymentID, cfg, true)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment2, e5PaymentID, cfg, true)
 
 		// Then
 		So(err, ShouldBeNil)
@@ -262,10 +622,10 @@ func TestUnitProcessFinancialPenaltyPayment_Retry_AuthorisePaymentFails(t *testi
 	})
 
 	Convey("Process financial penalty payment retry authorise payment fails with Attempt = 3", t, func() {
-		DAO.On("SaveE5Error", penaltyPayment3.CustomerCode, penaltyPayment3.PayableRef, e5.AuthoriseAction).Return(nil)
+		DAO.On("SaveE5Error", penaltyPayment3.CustomerCode, penaltyPayment3.PayableRef, e5.AuthoriseAction, "").Return(nil)
 
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment3, e5PaymentID, cfg, true)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment3, e5PaymentID, cfg, true)
 
 		// Then
 		So(err, ShouldBeNil)
@@ -283,10 +643,10 @@ func TestUnitProcessFinancialPenaltyPayment_Retry_ConfirmPaymentFails(t *testing
 	e5Client.On("ConfirmPayment", mock.Anything).Return(errors.New("confirm payment in E5 failed"))
 
 	Convey("Process financial penalty payment retry confirm payment fails with Attempt = 2", t, func() {
-		DAO.On("SaveE5Error", penaltyPayment2.CustomerCode, penaltyPayment2.PayableRef, e5.ConfirmAction).Return(nil)
+		DAO.On("SaveE5Error", penaltyPayment2.CustomerCode, penaltyPayment2.PayableRef, e5.ConfirmAction, "").Return(nil)
 
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment2, e5PaymentID, cfg, true)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment2, e5PaymentID, cfg, true)
 
 		// Then
 		So(err, ShouldBeNil)
@@ -295,10 +655,10 @@ func TestUnitProcessFinancialPenaltyPayment_Retry_ConfirmPaymentFails(t *testing
 	})
 
 	Convey("Process financial penalty payment retry confirm payment fails with Attempt = 3", t, func() {
-		DAO.On("SaveE5Error", penaltyPayment3.CustomerCode, penaltyPayment3.PayableRef, e5.ConfirmAction).Return(nil)
+		DAO.On("SaveE5Error", penaltyPayment3.CustomerCode, penaltyPayment3.PayableRef, e5.ConfirmAction, "").Return(nil)
 
 		// When
-		err := handler.ProcessFinancialPenaltyPayment(penaltyPayment3, e5PaymentID, cfg, true)
+		err := handler.ProcessFinancialPenaltyPayment(context.Background(), penaltyPayment3, e5PaymentID, cfg, true)
 
 		// Then
 		So(err, ShouldBeNil)
@@ -308,8 +668,15 @@ func TestUnitProcessFinancialPenaltyPayment_Retry_ConfirmPaymentFails(t *testing
 }
 
 func financePaymentTestSetup() (*mockE5Client, *mockDAO, *PenaltyFinancePayment) {
+	e5Client, DAO, handler := financePaymentTestSetupWithProgress(&e5.PaymentProgress{})
+	DAO.On("SaveE5Progress", mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+	return e5Client, DAO, handler
+}
+
+func financePaymentTestSetupWithProgress(progress *e5.PaymentProgress) (*mockE5Client, *mockDAO, *PenaltyFinancePayment) {
 	e5Client := new(mockE5Client)
 	DAO := new(mockDAO)
+	DAO.On("GetE5Progress", mock.Anything, mock.Anything).Return(progress, nil).Maybe()
 	handler := &PenaltyFinancePayment{
 		E5Client:                  e5Client,
 		PayableResourceDaoService: DAO,
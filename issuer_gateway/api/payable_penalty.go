@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/private"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 )
@@ -21,12 +22,14 @@ func PayablePenalty(params types.PayablePenaltyParams) (*models.TransactionItem,
 	requestId := params.RequestId
 
 	accountPenaltiesParams := types.AccountPenaltiesParams{
+		Context:                    params.Context,
 		PenaltyRefType:             penaltyRefType,
 		CustomerCode:               customerCode,
 		CompanyCode:                companyCode,
 		PenaltyDetailsMap:          penaltyDetailsMap,
 		AllowedTransactionsMap:     allowedTransactionsMap,
 		AccountPenaltiesDaoService: apDaoSvc,
+		PayableStatusProvider:      params.PayableStatusProvider,
 		RequestId:                  requestId,
 	}
 	response, _, err := getAccountPenalties(accountPenaltiesParams)
@@ -42,7 +45,17 @@ func PayablePenalty(params types.PayablePenaltyParams) (*models.TransactionItem,
 		"company_code":           companyCode,
 	})
 
-	return getMatchingPenalty(response.Items, transaction, customerCode, requestId)
+	transactionsPaidTogether := params.Transactions
+	if len(transactionsPaidTogether) == 0 {
+		transactionsPaidTogether = []models.TransactionItem{transaction}
+	}
+
+	var partPaymentPolicy config.PartPaymentPolicy
+	if penaltyDetailsMap != nil {
+		partPaymentPolicy = penaltyDetailsMap.Details[penaltyRefType].PartPayment
+	}
+
+	return getMatchingPenalty(response.Items, transaction, transactionsPaidTogether, partPaymentPolicy, customerCode, requestId)
 }
 
 func getUnpaidPenaltyCount(transactionListItems []models.TransactionListItem) int {
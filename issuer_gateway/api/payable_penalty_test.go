@@ -8,10 +8,10 @@ import (
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api/common/dao"
 	"github.com/companieshouse/penalty-payment-api/common/services"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -57,8 +57,8 @@ func accountPenaltiesResponse(unpaidPenaltyCount int) *models.TransactionListRes
 
 func generateParams(daoService dao.AccountPenaltiesDaoService, transaction models.TransactionItem) types.PayablePenaltyParams {
 	return types.PayablePenaltyParams{
-		PenaltyRefType:    utils.LateFilingPenaltyRefType,
-		CompanyCode:       utils.LateFilingPenaltyCompanyCode,
+		PenaltyRefType:    testutils.LateFilingPenaltyRefType,
+		CompanyCode:       testutils.LateFilingPenaltyCompanyCode,
 		CustomerCode:      "10000024",
 		PenaltyDetailsMap: &config.PenaltyDetailsMap{},
 		AllowedTransactionsMap: &models.AllowedTransactionMap{
@@ -95,6 +95,21 @@ func TestUnitPayablePenalty(t *testing.T) {
 		So(err, ShouldEqual, accountPenaltiesErr)
 	})
 
+	Convey("the payable status provider is passed on to get the account penalties", t, func() {
+		var gotProvider types.PayableStatusProvider
+		getAccountPenalties = func(params types.AccountPenaltiesParams) (*models.TransactionListResponse, services.ResponseType, error) {
+			gotProvider = params.PayableStatusProvider
+			return nil, services.Error, errors.New("failed to fetch account penalties")
+		}
+
+		params := generateParams(mockApDaoSvc, models.TransactionItem{PenaltyRef: "121"})
+		params.PayableStatusProvider = newTestPayableStatusProvider(t)
+		_, err := PayablePenalty(params)
+
+		So(err, ShouldNotBeNil)
+		So(gotProvider, ShouldEqual, params.PayableStatusProvider)
+	})
+
 	Convey("payable penalty is successfully returned for multiple unpaid penalties", t, func() {
 		getAccountPenalties = func(params types.AccountPenaltiesParams) (*models.TransactionListResponse, services.ResponseType, error) {
 			return accountPenaltiesResponse(2), services.Success, nil
@@ -128,14 +143,33 @@ func TestUnitPayablePenalty(t *testing.T) {
 			IsDCA:      false,
 			IsPaid:     false,
 		}
-		getMatchingPenalty = func(referenceTransactions []models.TransactionListItem, transactionToMatch models.TransactionItem, companyNumber, requestId string) (*models.TransactionItem, error) {
+		var gotPaidTogether []models.TransactionItem
+		getMatchingPenalty = func(referenceTransactions []models.TransactionListItem, transactionToMatch models.TransactionItem,
+			transactionsPaidTogether []models.TransactionItem, partPaymentPolicy config.PartPaymentPolicy,
+			companyNumber, requestId string) (*models.TransactionItem, error) {
+			gotPaidTogether = transactionsPaidTogether
 			return wantPayablePenalty, nil
 		}
 
 		transaction := models.TransactionItem{PenaltyRef: "121"}
-		gotPayablePenalty, err := PayablePenalty(generateParams(mockApDaoSvc, transaction))
 
-		So(gotPayablePenalty, ShouldResemble, wantPayablePenalty)
-		So(err, ShouldBeNil)
+		Convey("when the penalty is paid on its own", func() {
+			gotPayablePenalty, err := PayablePenalty(generateParams(mockApDaoSvc, transaction))
+
+			So(gotPayablePenalty, ShouldResemble, wantPayablePenalty)
+			So(err, ShouldBeNil)
+			So(gotPaidTogether, ShouldResemble, []models.TransactionItem{transaction})
+		})
+
+		Convey("when the penalty is paid together with its costs", func() {
+			params := generateParams(mockApDaoSvc, transaction)
+			params.Transactions = []models.TransactionItem{transaction, {PenaltyRef: "FC1", Amount: 80}}
+
+			gotPayablePenalty, err := PayablePenalty(params)
+
+			So(gotPayablePenalty, ShouldResemble, wantPayablePenalty)
+			So(err, ShouldBeNil)
+			So(gotPaidTogether, ShouldResemble, params.Transactions)
+		})
 	})
 }
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/private"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+)
+
+// PenaltyReferenceTypes builds the list of penalty reference types supported by the API from the penalty details
+// and allowed transactions configuration
+func PenaltyReferenceTypes(penaltyDetailsMap *config.PenaltyDetailsMap, allowedTransactionsMap *models.AllowedTransactionMap,
+	requestId string) ([]types.PenaltyReferenceType, error) {
+	cfg, err := getConfig()
+	if err != nil {
+		err = fmt.Errorf("error getting config: [%v]", err)
+		log.ErrorC(requestId, err)
+		return nil, err
+	}
+
+	penaltyTypes, err := config.GetPenaltyTypes()
+	if err != nil {
+		err = fmt.Errorf("error getting penalty types: [%v]", err)
+		log.ErrorC(requestId, err)
+		return nil, err
+	}
+
+	penaltyRefTypes := penaltyTypes.RefTypes()
+	penaltyReferenceTypes := make([]types.PenaltyReferenceType, 0, len(penaltyRefTypes))
+	for _, penaltyRefType := range penaltyRefTypes {
+		companyCode, err := penaltyTypes.CompanyCode(penaltyRefType)
+		if err != nil {
+			return nil, err
+		}
+		prefix, err := penaltyTypes.ReferencePrefix(penaltyRefType)
+		if err != nil {
+			return nil, err
+		}
+		referenceRegex, err := penaltyTypes.ReferenceRegex(penaltyRefType)
+		if err != nil {
+			return nil, err
+		}
+
+		penaltyDetails, hasDetails := penaltyDetailsMap.Details[penaltyRefType]
+		enabledFrom, enabledTo, err := penaltyDetails.GetEnabledWindow()
+		if err != nil {
+			err = fmt.Errorf("error getting enabled window of penalty reference type %s: [%v]", penaltyRefType, err)
+			log.ErrorC(requestId, err)
+			return nil, err
+		}
+		enabled := hasDetails && penaltyDetails.IsEnabledAt(time.Now()) &&
+			hasEnabledTransactionSubType(penaltyRefType, penaltyTypes, allowedTransactionsMap, cfg)
+
+		penaltyReferenceTypes = append(penaltyReferenceTypes, types.PenaltyReferenceType{
+			ReferenceType:       penaltyRefType,
+			Description:         penaltyDetails.Description,
+			CompanyCode:         companyCode,
+			ReferenceStartsWith: prefix,
+			ReferenceRegex:      referenceRegex,
+			Reason:              penaltyDetails.Reason,
+			ResourceKind:        penaltyDetails.ResourceKind,
+			EnabledFrom:         enabledFrom,
+			EnabledTo:           enabledTo,
+			Enabled:             enabled,
+		})
+	}
+
+	log.DebugC(requestId, "penalty reference types", log.Data{"penalty_reference_types": penaltyReferenceTypes})
+
+	return penaltyReferenceTypes, nil
+}
+
+// hasEnabledTransactionSubType checks that at least one invoice subtype of the penalty reference type is allowed and
+// has not been disabled in config
+func hasEnabledTransactionSubType(penaltyRefType string, penaltyTypes *config.PenaltyTypeRegistry,
+	allowedTransactionsMap *models.AllowedTransactionMap, cfg *config.Config) bool {
+	for subType, allowed := range allowedTransactionsMap.Types[private.InvoiceTransactionType] {
+		if !allowed || private.IsTransactionSubTypeDisabled(subType, cfg) {
+			continue
+		}
+		if penaltyTypes.IssuedUnder(penaltyRefType, subType) {
+			return true
+		}
+	}
+	return false
+}
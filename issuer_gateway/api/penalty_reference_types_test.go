@@ -0,0 +1,170 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+	"github.com/companieshouse/penalty-payment-api/testutils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitPenaltyReferenceTypes(t *testing.T) {
+	refTypesPenaltyDetailsMap := &config.PenaltyDetailsMap{
+		Details: map[string]config.PenaltyDetails{
+			testutils.LateFilingPenaltyRefType: {
+				Description:  "Late Filing Penalty",
+				Reason:       "Late filing of accounts",
+				ResourceKind: "late-filing-penalty#late-filing-penalty",
+			},
+			testutils.SanctionsPenaltyRefType: {
+				Description:  "Sanctions Penalty Payment",
+				ResourceKind: "penalty#sanctions",
+			},
+			testutils.SanctionsRoePenaltyRefType: {
+				Description:  "Overseas Entity Penalty Payment",
+				ResourceKind: "penalty#sanctions",
+			},
+		},
+	}
+	refTypesAllowedTransactionsMap := &models.AllowedTransactionMap{
+		Types: map[string]map[string]bool{
+			"1": {
+				"EJ": true,
+				"S1": true,
+				"A2": true,
+			},
+		},
+	}
+
+	Convey("Given the penalty details and allowed transactions", t, func() {
+		Convey("When no subtypes are disabled then every penalty reference type is enabled", func() {
+			getConfig = func() (*config.Config, error) {
+				return &config.Config{}, nil
+			}
+
+			penaltyReferenceTypes, err := PenaltyReferenceTypes(refTypesPenaltyDetailsMap, refTypesAllowedTransactionsMap, "")
+
+			So(err, ShouldBeNil)
+			So(penaltyReferenceTypes, ShouldResemble, []types.PenaltyReferenceType{
+				{
+					ReferenceType:       testutils.LateFilingPenaltyRefType,
+					Description:         "Late Filing Penalty",
+					CompanyCode:         testutils.LateFilingPenaltyCompanyCode,
+					ReferenceStartsWith: testutils.LateFilingPenaltyReferencePrefix,
+					ReferenceRegex:      "^" + testutils.LateFilingPenaltyReferencePrefix,
+					Reason:              "Late filing of accounts",
+					ResourceKind:        "late-filing-penalty#late-filing-penalty",
+					Enabled:             true,
+				},
+				{
+					ReferenceType:       testutils.SanctionsPenaltyRefType,
+					Description:         "Sanctions Penalty Payment",
+					CompanyCode:         testutils.SanctionsCompanyCode,
+					ReferenceStartsWith: testutils.SanctionsPenaltyReferencePrefix,
+					ReferenceRegex:      "^" + testutils.SanctionsPenaltyReferencePrefix,
+					ResourceKind:        "penalty#sanctions",
+					Enabled:             true,
+				},
+				{
+					ReferenceType:       testutils.SanctionsRoePenaltyRefType,
+					Description:         "Overseas Entity Penalty Payment",
+					CompanyCode:         testutils.SanctionsCompanyCode,
+					ReferenceStartsWith: testutils.SanctionsRoePenaltyReferencePrefix,
+					ReferenceRegex:      "^" + testutils.SanctionsRoePenaltyReferencePrefix,
+					ResourceKind:        "penalty#sanctions",
+					Enabled:             true,
+				},
+			})
+		})
+
+		Convey("When the only ROE subtype is disabled then sanctions ROE is not enabled", func() {
+			getConfig = func() (*config.Config, error) {
+				return &config.Config{DisabledPenaltyTransactionSubtypes: "A2"}, nil
+			}
+
+			penaltyReferenceTypes, err := PenaltyReferenceTypes(refTypesPenaltyDetailsMap, refTypesAllowedTransactionsMap, "")
+
+			So(err, ShouldBeNil)
+			So(penaltyReferenceTypes, ShouldHaveLength, 3)
+			So(penaltyReferenceTypes[0].Enabled, ShouldBeTrue)
+			So(penaltyReferenceTypes[1].Enabled, ShouldBeTrue)
+			So(penaltyReferenceTypes[2].Enabled, ShouldBeFalse)
+		})
+
+		Convey("When a penalty reference type has no penalty details then it is not enabled", func() {
+			getConfig = func() (*config.Config, error) {
+				return &config.Config{}, nil
+			}
+			lateFilingOnly := &config.PenaltyDetailsMap{
+				Details: map[string]config.PenaltyDetails{
+					testutils.LateFilingPenaltyRefType: refTypesPenaltyDetailsMap.Details[testutils.LateFilingPenaltyRefType],
+				},
+			}
+
+			penaltyReferenceTypes, err := PenaltyReferenceTypes(lateFilingOnly, refTypesAllowedTransactionsMap, "")
+
+			So(err, ShouldBeNil)
+			So(penaltyReferenceTypes[0].Enabled, ShouldBeTrue)
+			So(penaltyReferenceTypes[1].Enabled, ShouldBeFalse)
+			So(penaltyReferenceTypes[1].CompanyCode, ShouldEqual, testutils.SanctionsCompanyCode)
+			So(penaltyReferenceTypes[2].Enabled, ShouldBeFalse)
+		})
+
+		Convey("When a penalty reference type has an enabled window then it is only enabled within it", func() {
+			getConfig = func() (*config.Config, error) {
+				return &config.Config{}, nil
+			}
+			endedDetails := refTypesPenaltyDetailsMap.Details[testutils.LateFilingPenaltyRefType]
+			endedDetails.EnabledFrom = "2020-01-01T00:00:00Z"
+			endedDetails.EnabledTo = "2021-01-01T00:00:00Z"
+			startedDetails := refTypesPenaltyDetailsMap.Details[testutils.SanctionsPenaltyRefType]
+			startedDetails.EnabledFrom = "2020-01-01T00:00:00Z"
+			windowedPenaltyDetailsMap := &config.PenaltyDetailsMap{
+				Details: map[string]config.PenaltyDetails{
+					testutils.LateFilingPenaltyRefType: endedDetails,
+					testutils.SanctionsPenaltyRefType:  startedDetails,
+				},
+			}
+
+			penaltyReferenceTypes, err := PenaltyReferenceTypes(windowedPenaltyDetailsMap, refTypesAllowedTransactionsMap, "")
+
+			So(err, ShouldBeNil)
+			So(penaltyReferenceTypes[0].Enabled, ShouldBeFalse)
+			So(*penaltyReferenceTypes[0].EnabledFrom, ShouldEqual, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+			So(*penaltyReferenceTypes[0].EnabledTo, ShouldEqual, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+			So(penaltyReferenceTypes[1].Enabled, ShouldBeTrue)
+			So(penaltyReferenceTypes[1].EnabledTo, ShouldBeNil)
+		})
+
+		Convey("When a penalty reference type has an enabled window that is not valid then an error is returned", func() {
+			getConfig = func() (*config.Config, error) {
+				return &config.Config{}, nil
+			}
+			invalidDetails := refTypesPenaltyDetailsMap.Details[testutils.LateFilingPenaltyRefType]
+			invalidDetails.EnabledTo = "never"
+
+			penaltyReferenceTypes, err := PenaltyReferenceTypes(&config.PenaltyDetailsMap{
+				Details: map[string]config.PenaltyDetails{testutils.LateFilingPenaltyRefType: invalidDetails},
+			}, refTypesAllowedTransactionsMap, "")
+
+			So(err, ShouldNotBeNil)
+			So(penaltyReferenceTypes, ShouldBeNil)
+		})
+
+		Convey("When getting config fails then an error is returned", func() {
+			errGettingConfig := errors.New("error getting config")
+			getConfig = func() (*config.Config, error) {
+				return nil, errGettingConfig
+			}
+
+			penaltyReferenceTypes, err := PenaltyReferenceTypes(refTypesPenaltyDetailsMap, refTypesAllowedTransactionsMap, "")
+
+			So(err, ShouldNotBeNil)
+			So(penaltyReferenceTypes, ShouldBeNil)
+		})
+	})
+}
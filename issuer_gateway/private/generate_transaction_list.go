@@ -15,7 +15,7 @@ var etagGenerator = utils.GenerateEtag
 // TransactionListItemEnrichmentProviders is used to enrich the get-penalties response
 type TransactionListItemEnrichmentProviders struct {
 	ReasonProvider        ReasonProvider
-	PayableStatusProvider PayableStatusProvider
+	PayableStatusProvider types.PayableStatusProvider
 }
 
 func GenerateTransactionListFromAccountPenalties(accountPenalties *models.AccountPenaltiesDao, penaltyRefType string, penaltyDetailsMap *config.PenaltyDetailsMap,
@@ -44,10 +44,44 @@ func GenerateTransactionListFromAccountPenalties(accountPenalties *models.Accoun
 
 		payableTransactionList.Items = append(payableTransactionList.Items, transactionListItem)
 	}
+	payableTransactionList.Items = groupCostsWithPenalties(payableTransactionList.Items)
 
 	return &payableTransactionList, nil
 }
 
+// groupCostsWithPenalties lists the unpaid costs that are paid together with a penalty straight after the penalty.
+// The costs are associated with the first open penalty that has the same made up date.
+func groupCostsWithPenalties(transactionListItems []models.TransactionListItem) []models.TransactionListItem {
+	costs := map[string][]models.TransactionListItem{}
+	for _, item := range transactionListItems {
+		if item.PayableStatus == OpenWithPenaltyPayableStatus {
+			costs[item.MadeUpDate] = append(costs[item.MadeUpDate], item)
+		}
+	}
+	if len(costs) == 0 {
+		return transactionListItems
+	}
+
+	var grouped []models.TransactionListItem
+	for _, item := range transactionListItems {
+		if item.PayableStatus == OpenWithPenaltyPayableStatus {
+			continue
+		}
+		grouped = append(grouped, item)
+		if item.Type == types.Penalty.String() && item.PayableStatus == OpenPayableStatus {
+			grouped = append(grouped, costs[item.MadeUpDate]...)
+			delete(costs, item.MadeUpDate)
+		}
+	}
+	// costs without an open penalty in the list are kept, in their original order
+	for _, item := range transactionListItems {
+		if _, ok := costs[item.MadeUpDate]; ok && item.PayableStatus == OpenWithPenaltyPayableStatus {
+			grouped = append(grouped, item)
+		}
+	}
+	return grouped
+}
+
 func buildTransactionListItemFromAccountPenalty(dao *models.AccountPenaltiesDataDao,
 	penaltyDetailsMap *config.PenaltyDetailsMap, penaltyRefType string, transactionType string,
 	reason string, payableStatus string, requestId string) (models.TransactionListItem, error) {
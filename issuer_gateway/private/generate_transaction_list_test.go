@@ -7,8 +7,8 @@ import (
 	"time"
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -37,20 +37,20 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 	pen1DunningStatus := addTrailingSpacesToDunningStatus(PEN1DunningStatus)
 	dcaDunningStatus := addTrailingSpacesToDunningStatus(DCADunningStatus)
 	lfpAccountPenaltiesDao := buildTestUnpaidAccountPenaltiesDao(
-		customerCode, utils.LateFilingPenaltyCompanyCode, euTransactionSubType, pen1DunningStatus, utils.LateFilingPenaltyRefType, false)
-	lfpPenaltyDetailsMap := buildTestPenaltyDetailsMap(utils.LateFilingPenaltyRefType)
-	sanctionsPenaltyDetailsMap := buildTestPenaltyDetailsMap(utils.SanctionsPenaltyRefType)
-	sanctionsRoePenaltyDetailsMap := buildTestPenaltyDetailsMap(utils.SanctionsRoePenaltyRefType)
+		customerCode, testutils.LateFilingPenaltyCompanyCode, euTransactionSubType, pen1DunningStatus, testutils.LateFilingPenaltyRefType, false)
+	lfpPenaltyDetailsMap := buildTestPenaltyDetailsMap(testutils.LateFilingPenaltyRefType)
+	sanctionsPenaltyDetailsMap := buildTestPenaltyDetailsMap(testutils.SanctionsPenaltyRefType)
+	sanctionsRoePenaltyDetailsMap := buildTestPenaltyDetailsMap(testutils.SanctionsRoePenaltyRefType)
 	transactionListItemEnrichmentProviders := TransactionListItemEnrichmentProviders{
 		ReasonProvider:        &DefaultReasonProvider{},
-		PayableStatusProvider: &DefaultPayableStatusProvider{},
+		PayableStatusProvider: newTestPayableStatusProvider(t),
 	}
 
 	Convey("error when first etag generator fails", t, func() {
 		etagGenerator = func() (string, error) {
 			return "", errors.New("error generating etag")
 		}
-		penaltyRefType := utils.LateFilingPenaltyRefType
+		penaltyRefType := testutils.LateFilingPenaltyRefType
 
 		transactionList, err := GenerateTransactionListFromAccountPenalties(
 			lfpAccountPenaltiesDao, penaltyRefType, lfpPenaltyDetailsMap, allowedTransactionMap, &cfg, "", transactionListItemEnrichmentProviders)
@@ -68,7 +68,7 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 			}
 			return etag, nil
 		}
-		penaltyRefType := utils.LateFilingPenaltyRefType
+		penaltyRefType := testutils.LateFilingPenaltyRefType
 
 		transactionList, err := GenerateTransactionListFromAccountPenalties(
 			lfpAccountPenaltiesDao, penaltyRefType, lfpPenaltyDetailsMap, allowedTransactionMap, &cfg, "", transactionListItemEnrichmentProviders)
@@ -81,9 +81,9 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 		etagGenerator = func() (string, error) {
 			return etag, nil
 		}
-		penaltyRefType := utils.LateFilingPenaltyRefType
+		penaltyRefType := testutils.LateFilingPenaltyRefType
 		accountPenaltiesDao := buildTestUnpaidAccountPenaltiesDao(
-			customerCode, utils.LateFilingPenaltyCompanyCode, euTransactionSubType, pen1DunningStatus, penaltyRefType, true)
+			customerCode, testutils.LateFilingPenaltyCompanyCode, euTransactionSubType, pen1DunningStatus, penaltyRefType, true)
 
 		transactionList, err := GenerateTransactionListFromAccountPenalties(
 			accountPenaltiesDao, penaltyRefType, lfpPenaltyDetailsMap, allowedTransactionMap, &cfg, "", transactionListItemEnrichmentProviders)
@@ -105,17 +105,21 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 			OriginalAmount:  250,
 			Outstanding:     250,
 			Type:            "penalty",
-			Reason:          LateFilingPenaltyReason,
-			PayableStatus:   ClosedPayableStatus,
+			Reason:          "Late filing of accounts",
+			PayableStatus:   OpenPayableStatus,
 		}
 		So(transactionListItem, ShouldResemble, expected)
+		unpaidCost := transactionListItems[1]
+		So(unpaidCost.ID, ShouldEqual, "F1")
+		So(unpaidCost.Type, ShouldEqual, "other")
+		So(unpaidCost.PayableStatus, ShouldEqual, OpenWithPenaltyPayableStatus)
 	})
 
 	Convey("penalty list successfully generated from E5 response - penalty type EU", t, func() {
 		etagGenerator = func() (string, error) {
 			return etag, nil
 		}
-		penaltyRefType := utils.LateFilingPenaltyRefType
+		penaltyRefType := testutils.LateFilingPenaltyRefType
 
 		transactionList, err := GenerateTransactionListFromAccountPenalties(
 			lfpAccountPenaltiesDao, penaltyRefType, lfpPenaltyDetailsMap, allowedTransactionMap, &cfg, "", transactionListItemEnrichmentProviders)
@@ -137,7 +141,7 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 			OriginalAmount:  250,
 			Outstanding:     250,
 			Type:            "penalty",
-			Reason:          LateFilingPenaltyReason,
+			Reason:          "Late filing of accounts",
 			PayableStatus:   OpenPayableStatus,
 		}
 		So(transactionListItem, ShouldResemble, expected)
@@ -147,9 +151,9 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 		etagGenerator = func() (string, error) {
 			return etag, nil
 		}
-		penaltyRefType := utils.LateFilingPenaltyRefType
+		penaltyRefType := testutils.LateFilingPenaltyRefType
 		otherAccountPenalties := buildTestUnpaidAccountPenaltiesDao(
-			customerCode, utils.LateFilingPenaltyCompanyCode, otherTransactionSubType, pen1DunningStatus, penaltyRefType, false)
+			customerCode, testutils.LateFilingPenaltyCompanyCode, otherTransactionSubType, pen1DunningStatus, penaltyRefType, false)
 
 		transactionList, err := GenerateTransactionListFromAccountPenalties(
 			otherAccountPenalties, penaltyRefType, lfpPenaltyDetailsMap, allowedTransactionMap, &cfg, "", transactionListItemEnrichmentProviders)
@@ -171,7 +175,7 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 			OriginalAmount:  250,
 			Outstanding:     250,
 			Type:            "other",
-			Reason:          LateFilingPenaltyReason,
+			Reason:          "Late filing of accounts",
 			PayableStatus:   ClosedPayableStatus,
 		}
 		So(transactionListItem, ShouldResemble, expected)
@@ -182,9 +186,9 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 		etagGenerator = func() (string, error) {
 			return etag, nil
 		}
-		penaltyRefType := utils.LateFilingPenaltyRefType
+		penaltyRefType := testutils.LateFilingPenaltyRefType
 		accountPenaltiesDao := buildTestUnpaidAccountPenaltiesDao(
-			customerCode, utils.LateFilingPenaltyCompanyCode, euTransactionSubType, dcaDunningStatus, penaltyRefType, false)
+			customerCode, testutils.LateFilingPenaltyCompanyCode, euTransactionSubType, dcaDunningStatus, penaltyRefType, false)
 
 		transactionList, err := GenerateTransactionListFromAccountPenalties(
 			accountPenaltiesDao, penaltyRefType, lfpPenaltyDetailsMap, allowedTransactionMap, &cfg, "", transactionListItemEnrichmentProviders)
@@ -205,7 +209,7 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 			OriginalAmount:  250,
 			Outstanding:     250,
 			Type:            "penalty",
-			Reason:          LateFilingPenaltyReason,
+			Reason:          "Late filing of accounts",
 			PayableStatus:   ClosedPayableStatus,
 		}
 		So(transactionListItem, ShouldResemble, expected)
@@ -215,9 +219,9 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 		etagGenerator = func() (string, error) {
 			return etag, nil
 		}
-		penaltyRefType := utils.SanctionsPenaltyRefType
+		penaltyRefType := testutils.SanctionsPenaltyRefType
 		accountPenaltiesDao := buildTestUnpaidAccountPenaltiesDao(
-			customerCode, utils.SanctionsCompanyCode, SanctionsConfirmationStatementTransactionSubType, pen1DunningStatus, penaltyRefType, false)
+			customerCode, testutils.SanctionsCompanyCode, SanctionsConfirmationStatementTransactionSubType, pen1DunningStatus, penaltyRefType, false)
 
 		transactionList, err := GenerateTransactionListFromAccountPenalties(
 			accountPenaltiesDao, penaltyRefType, sanctionsPenaltyDetailsMap, allowedTransactionMap, &cfg, "", transactionListItemEnrichmentProviders)
@@ -239,7 +243,7 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 			OriginalAmount:  250,
 			Outstanding:     250,
 			Type:            "penalty",
-			Reason:          SanctionsConfirmationStatementReason,
+			Reason:          "Failure to file a confirmation statement",
 			PayableStatus:   OpenPayableStatus,
 		}
 		So(transactionListItem, ShouldResemble, expected)
@@ -249,9 +253,9 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 		etagGenerator = func() (string, error) {
 			return etag, nil
 		}
-		penaltyRefType := utils.SanctionsRoePenaltyRefType
+		penaltyRefType := testutils.SanctionsRoePenaltyRefType
 		accountPenaltiesDao := buildTestUnpaidAccountPenaltiesDao(
-			overSeasEntityId, utils.SanctionsCompanyCode, SanctionsRoeFailureToUpdateTransactionSubType, pen1DunningStatus, penaltyRefType, false)
+			overSeasEntityId, testutils.SanctionsCompanyCode, SanctionsRoeFailureToUpdateTransactionSubType, pen1DunningStatus, penaltyRefType, false)
 
 		transactionList, err := GenerateTransactionListFromAccountPenalties(
 			accountPenaltiesDao, penaltyRefType, sanctionsRoePenaltyDetailsMap, allowedTransactionMap, &cfg, "", transactionListItemEnrichmentProviders)
@@ -273,7 +277,7 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 			OriginalAmount:  250,
 			Outstanding:     250,
 			Type:            "penalty",
-			Reason:          SanctionsRoeFailureToUpdateReason,
+			Reason:          "Failure to update the Register of Overseas Entities",
 			PayableStatus:   OpenPayableStatus,
 		}
 		So(transactionListItem, ShouldResemble, expected)
@@ -283,9 +287,9 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 		etagGenerator = func() (string, error) {
 			return etag, nil
 		}
-		penaltyRefType := utils.SanctionsPenaltyRefType
+		penaltyRefType := testutils.SanctionsPenaltyRefType
 		accountPenaltiesDao := buildTestUnpaidAccountPenaltiesDao(
-			customerCode, utils.SanctionsCompanyCode, SanctionsConfirmationStatementTransactionSubType, dcaDunningStatus, penaltyRefType, false)
+			customerCode, testutils.SanctionsCompanyCode, SanctionsConfirmationStatementTransactionSubType, dcaDunningStatus, penaltyRefType, false)
 
 		transactionList, err := GenerateTransactionListFromAccountPenalties(
 			accountPenaltiesDao, penaltyRefType, sanctionsPenaltyDetailsMap, allowedTransactionMap, &cfg, "", transactionListItemEnrichmentProviders)
@@ -307,7 +311,7 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 			OriginalAmount:  250,
 			Outstanding:     250,
 			Type:            "penalty",
-			Reason:          SanctionsConfirmationStatementReason,
+			Reason:          "Failure to file a confirmation statement",
 			PayableStatus:   ClosedPayableStatus,
 		}
 		So(transactionListItem, ShouldResemble, expected)
@@ -317,9 +321,9 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 		etagGenerator = func() (string, error) {
 			return etag, nil
 		}
-		penaltyRefType := utils.SanctionsRoePenaltyRefType
+		penaltyRefType := testutils.SanctionsRoePenaltyRefType
 		accountPenaltiesDao := buildTestUnpaidAccountPenaltiesDao(
-			overSeasEntityId, utils.SanctionsCompanyCode, SanctionsRoeFailureToUpdateTransactionSubType, dcaDunningStatus, penaltyRefType, false)
+			overSeasEntityId, testutils.SanctionsCompanyCode, SanctionsRoeFailureToUpdateTransactionSubType, dcaDunningStatus, penaltyRefType, false)
 
 		transactionList, err := GenerateTransactionListFromAccountPenalties(
 			accountPenaltiesDao, penaltyRefType, sanctionsRoePenaltyDetailsMap, allowedTransactionMap, &cfg, "", transactionListItemEnrichmentProviders)
@@ -341,7 +345,7 @@ func TestUnitGenerateTransactionListFromE5Response(t *testing.T) {
 			OriginalAmount:  250,
 			Outstanding:     250,
 			Type:            "penalty",
-			Reason:          SanctionsRoeFailureToUpdateReason,
+			Reason:          "Failure to update the Register of Overseas Entities",
 			PayableStatus:   ClosedPayableStatus,
 		}
 		So(transactionListItem, ShouldResemble, expected)
@@ -399,14 +403,14 @@ func buildTestUnpaidAccountPenaltiesDao(customerCode, companyCode, transactionSu
 		DunningStatus:      dunningStatus,
 	}
 	switch penaltyRefType {
-	case utils.SanctionsPenaltyRefType:
+	case testutils.SanctionsPenaltyRefType:
 		{
 			params.TransactionReference = "P1234567"
 			params.TransactionType = InvoiceTransactionType
 			params.LedgerCode = "E1"
 			params.TypeDescription = "CS01                                    "
 		}
-	case utils.LateFilingPenaltyRefType:
+	case testutils.LateFilingPenaltyRefType:
 		{
 			params.TransactionReference = "A1234567"
 			params.TransactionType = "1"
@@ -443,7 +447,7 @@ func buildTestPenaltyDetailsMap(penaltyRefType string) *config.PenaltyDetailsMap
 		Details: map[string]config.PenaltyDetails{},
 	}
 	switch penaltyRefType {
-	case utils.LateFilingPenaltyRefType:
+	case testutils.LateFilingPenaltyRefType:
 		penaltyDetailsMap.Details[penaltyRefType] = config.PenaltyDetails{
 			Description:        "Late Filing Penalty",
 			DescriptionId:      "late-filing-penalty",
@@ -453,7 +457,7 @@ func buildTestPenaltyDetailsMap(penaltyRefType string) *config.PenaltyDetailsMap
 			EmailReceivedAppId: "penalty-payment-api.penalty_payment_received_email",
 			EmailMsgType:       "penalty_payment_received_email",
 		}
-	case utils.SanctionsPenaltyRefType:
+	case testutils.SanctionsPenaltyRefType:
 		penaltyDetailsMap.Details[penaltyRefType] = config.PenaltyDetails{
 			Description:        "Sanctions Penalty Payment",
 			DescriptionId:      "penalty-sanctions",
@@ -463,7 +467,7 @@ func buildTestPenaltyDetailsMap(penaltyRefType string) *config.PenaltyDetailsMap
 			EmailReceivedAppId: "penalty-payment-api.penalty_payment_received_email",
 			EmailMsgType:       "penalty_payment_received_email",
 		}
-	case utils.SanctionsRoePenaltyRefType:
+	case testutils.SanctionsRoePenaltyRefType:
 		penaltyDetailsMap.Details[penaltyRefType] = config.PenaltyDetails{
 			Description:        "Overseas Entity Penalty Payment",
 			DescriptionId:      "penalty-sanctions",
@@ -477,3 +481,23 @@ func buildTestPenaltyDetailsMap(penaltyRefType string) *config.PenaltyDetailsMap
 
 	return &penaltyDetailsMap
 }
+
+func TestUnitGroupCostsWithPenalties(t *testing.T) {
+	Convey("Unpaid costs are listed straight after the penalty they are paid together with", t, func() {
+		items := []models.TransactionListItem{
+			{ID: "A0000001", Type: "penalty", MadeUpDate: "2018-06-30", PayableStatus: OpenPayableStatus},
+			{ID: "A0000002", Type: "penalty", MadeUpDate: "2019-06-30", PayableStatus: OpenPayableStatus},
+			{ID: "CF1", Type: "other", MadeUpDate: "2018-06-30", PayableStatus: OpenWithPenaltyPayableStatus},
+			{ID: "CF2", Type: "other", MadeUpDate: "2019-06-30", PayableStatus: ClosedPayableStatus},
+			{ID: "FC1", Type: "other", MadeUpDate: "2018-06-30", PayableStatus: OpenWithPenaltyPayableStatus},
+		}
+
+		grouped := groupCostsWithPenalties(items)
+
+		var ids []string
+		for _, item := range grouped {
+			ids = append(ids, item.ID)
+		}
+		So(ids, ShouldResemble, []string{"A0000001", "CF1", "FC1", "A0000002", "CF2"})
+	})
+}
@@ -6,13 +6,15 @@ import (
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api-core/validators"
+	"github.com/companieshouse/penalty-payment-api/common/e5"
 )
 
 func LogE5Error(message string, originalError error, resource models.PayableResource, payment validators.PaymentInformation, requestId string) {
 	log.ErrorC(requestId, errors.New(message), log.Data{
-		"payable_ref": resource.PayableRef,
-		"payment_id":  payment.PaymentID,
-		"amount":      payment.Amount,
-		"error":       originalError,
+		"payable_ref":  resource.PayableRef,
+		"payment_id":   payment.PaymentID,
+		"amount":       payment.Amount,
+		"error":        originalError,
+		"message_code": e5.MessageCode(originalError),
 	})
 }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 )
 
@@ -16,10 +17,18 @@ var (
 	ErrPenaltyIsPaid         = errors.New("this penalty is already paid")
 	ErrPenaltyIsPartPaid     = errors.New("the penalty is already part paid")
 	ErrPenaltyAmountMismatch = errors.New("you can only pay off the full amount of the penalty")
+	ErrCostWithoutPenalty    = errors.New("a cost can only be paid together with its penalty")
+	ErrCostPenaltyAmbiguous  = errors.New("the penalty of the cost cannot be identified")
+	ErrUnpaidCostsNotPaid    = errors.New("the penalty can only be paid together with its unpaid costs")
 )
 
-func MatchPenalty(referenceTransactions []models.TransactionListItem,
-	transactionToMatch models.TransactionItem, customerCode, requestId string) (*models.TransactionItem, error) {
+// MatchPenalty matches a transaction to pay against the E5 transactions of the customer. The transactions paid
+// together with it are checked too, as a penalty with unpaid costs can only be paid together with all of them, and a
+// cost can only be paid together with its penalty. Less than the outstanding amount can only be paid within the
+// bounds of the part payment policy of the penalty type.
+func MatchPenalty(referenceTransactions []models.TransactionListItem, transactionToMatch models.TransactionItem,
+	transactionsPaidTogether []models.TransactionItem, partPaymentPolicy config.PartPaymentPolicy,
+	customerCode, requestId string) (*models.TransactionItem, error) {
 
 	referenceTransactionsMap := mapTransactions(referenceTransactions)
 	transactionInfo := map[string]interface{}{
@@ -35,11 +44,16 @@ func MatchPenalty(referenceTransactions []models.TransactionListItem,
 		return nil, ErrPenaltyDoesNotExist
 	}
 
-	valid, err := validate(matched, transactionInfo, transactionToMatch, requestId)
+	valid, err := validate(matched, transactionInfo, transactionToMatch, partPaymentPolicy, requestId)
 	if valid {
+		if bundleErr := validateBundle(matched, referenceTransactions, transactionsPaidTogether, transactionInfo, requestId); bundleErr != nil {
+			return nil, bundleErr
+		}
+
+		// the amount is the outstanding amount unless part of it is being paid
 		matchedPenalty := models.TransactionItem{
 			PenaltyRef: matched.ID,
-			Amount:     matched.Outstanding,
+			Amount:     transactionToMatch.Amount,
 			Type:       matched.Type,
 			MadeUpDate: matched.MadeUpDate,
 			IsDCA:      matched.IsDCA,
@@ -56,12 +70,12 @@ func MatchPenalty(referenceTransactions []models.TransactionListItem,
 func validate(
 	refTransaction models.TransactionListItem,
 	data map[string]interface{},
-	transactionToMatch models.TransactionItem, requestId string) (bool, []error) {
+	transactionToMatch models.TransactionItem, partPaymentPolicy config.PartPaymentPolicy, requestId string) (bool, []error) {
 
 	var errs []error
 	valid := true
 
-	if refTransaction.IsPartPaid() {
+	if refTransaction.IsPartPaid() && !partPaymentPolicy.Allowed {
 		log.InfoC(requestId, "attempting to pay a penalty that is already part paid", data)
 		valid = false
 		errs = append(errs, ErrPenaltyIsPartPaid)
@@ -71,17 +85,22 @@ func validate(
 		valid = false
 		errs = append(errs, ErrPenaltyIsPaid)
 	}
-	if refTransaction.Type != types.Penalty.String() {
-		log.InfoC(requestId, "disallowing paying for a transaction that is not a penalty", data)
+	if !isOpenForPayment(refTransaction) {
+		data["payable_status"] = refTransaction.PayableStatus
+		log.InfoC(requestId, "disallowing paying for a transaction that is not open for payment", data)
 		valid = false
 		errs = append(errs, ErrPenaltyNotPayable)
 	}
 	if refTransaction.Outstanding != transactionToMatch.Amount {
 		data["attempted_amount"] = fmt.Sprintf("%f", transactionToMatch.Amount)
 		data["outstanding_amount"] = fmt.Sprintf("%f", refTransaction.Outstanding)
-		log.InfoC(requestId, "attempting to pay off partial balance of a penalty", data)
-		valid = false
-		errs = append(errs, ErrPenaltyAmountMismatch)
+		if partPaymentPolicy.AllowsPartPayment(transactionToMatch.Amount, refTransaction.Outstanding) {
+			log.InfoC(requestId, "paying off partial balance of a penalty", data)
+		} else {
+			log.InfoC(requestId, "attempting to pay off partial balance of a penalty", data)
+			valid = false
+			errs = append(errs, ErrPenaltyAmountMismatch)
+		}
 	}
 	if refTransaction.IsDCA {
 		log.InfoC(requestId, "attempting to pay a penalty that is with a debt collecting agency", data)
@@ -92,6 +111,61 @@ func validate(
 	return valid, errs
 }
 
+// isOpenForPayment checks that the payable status of the transaction is the open status of its type, as only an open
+// penalty, a cost open with its penalty or the next instalment due of an instalment plan can be paid
+func isOpenForPayment(refTransaction models.TransactionListItem) bool {
+	if refTransaction.Type == types.Penalty.String() {
+		return refTransaction.PayableStatus == OpenPayableStatus
+	}
+	return refTransaction.PayableStatus == OpenWithPenaltyPayableStatus ||
+		refTransaction.PayableStatus == OpenInstalmentDuePayableStatus
+}
+
+// validateBundle checks that a cost is paid together with its penalty, and that a penalty is paid together with all of
+// its unpaid costs. Costs are associated with their penalty by made up date, so a cost is not matched when more than
+// one penalty has its made up date.
+func validateBundle(refTransaction models.TransactionListItem, referenceTransactions []models.TransactionListItem,
+	transactionsPaidTogether []models.TransactionItem, data map[string]interface{}, requestId string) error {
+	// the next instalment due of an instalment plan can be paid on its own
+	if refTransaction.PayableStatus == OpenInstalmentDuePayableStatus {
+		return nil
+	}
+
+	paidTogether := map[string]bool{}
+	for _, tx := range transactionsPaidTogether {
+		paidTogether[tx.PenaltyRef] = true
+	}
+
+	if refTransaction.Type != types.Penalty.String() {
+		var penaltiesOfCost []models.TransactionListItem
+		for _, tx := range referenceTransactions {
+			if tx.Type == types.Penalty.String() && tx.MadeUpDate == refTransaction.MadeUpDate {
+				penaltiesOfCost = append(penaltiesOfCost, tx)
+			}
+		}
+		if len(penaltiesOfCost) > 1 {
+			log.InfoC(requestId, "disallowing paying for a cost with more than one penalty of its made up date", data)
+			return ErrCostPenaltyAmbiguous
+		}
+		if len(penaltiesOfCost) == 1 && penaltiesOfCost[0].PayableStatus == OpenPayableStatus &&
+			paidTogether[penaltiesOfCost[0].ID] {
+			return nil
+		}
+		log.InfoC(requestId, "disallowing paying for a cost without its penalty", data)
+		return ErrCostWithoutPenalty
+	}
+
+	for _, tx := range referenceTransactions {
+		if tx.PayableStatus == OpenWithPenaltyPayableStatus && tx.MadeUpDate == refTransaction.MadeUpDate &&
+			!paidTogether[tx.ID] {
+			data["unpaid_cost_ref"] = tx.ID
+			log.InfoC(requestId, "disallowing paying for a penalty without its unpaid costs", data)
+			return ErrUnpaidCostsNotPaid
+		}
+	}
+	return nil
+}
+
 func mapTransactions(transactionListItems []models.TransactionListItem) map[string]models.TransactionListItem {
 
 	itemMap := map[string]models.TransactionListItem{}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/config"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -35,23 +36,26 @@ func TestUnitMatchPenalty(t *testing.T) {
 		IsPaid         bool
 		OriginalAmount float64
 		Outstanding    float64
+		PayableStatus  string
 		WantMatched    *models.TransactionItem
 		WantError      error
 	}{
 		{PenaltyRef: "120", Outstanding: 150, Type: "penalty", MadeUpDate: "2017-06-30", Reason: "Failure to file a confirmation statement",
-			OriginalAmount: 150, IsDCA: false, IsPaid: false, WantMatched: nil, WantError: ErrPenaltyDoesNotExist},
+			OriginalAmount: 150, IsDCA: false, IsPaid: false, PayableStatus: OpenPayableStatus, WantMatched: nil, WantError: ErrPenaltyDoesNotExist},
 		{PenaltyRef: "121", Outstanding: 150, Type: "penalty", MadeUpDate: "2017-06-30", Reason: "Failure to file a confirmation statement",
-			OriginalAmount: 200, IsDCA: false, IsPaid: false, WantMatched: nil, WantError: ErrPenaltyIsPartPaid},
+			OriginalAmount: 200, IsDCA: false, IsPaid: false, PayableStatus: OpenPayableStatus, WantMatched: nil, WantError: ErrPenaltyIsPartPaid},
 		{PenaltyRef: "121", Outstanding: 150, Type: "penalty", MadeUpDate: "2017-06-30", Reason: "Failure to file a confirmation statement",
-			OriginalAmount: 150, IsDCA: false, IsPaid: true, WantMatched: nil, WantError: ErrPenaltyIsPaid},
+			OriginalAmount: 150, IsDCA: false, IsPaid: true, PayableStatus: ClosedPayableStatus, WantMatched: nil, WantError: ErrPenaltyIsPaid},
 		{PenaltyRef: "121", Outstanding: 100, Type: "other", MadeUpDate: "2017-06-30", Reason: "Failure to file a confirmation statement",
 			OriginalAmount: 100, IsDCA: false, IsPaid: false, WantMatched: nil, WantError: ErrPenaltyNotPayable},
 		{PenaltyRef: "121", Outstanding: 100, Type: "penalty", MadeUpDate: "2017-06-30", Reason: "Failure to file a confirmation statement",
-			OriginalAmount: 100, IsDCA: false, IsPaid: false, WantMatched: nil, WantError: ErrPenaltyAmountMismatch},
+			OriginalAmount: 100, IsDCA: false, IsPaid: false, PayableStatus: OpenPayableStatus, WantMatched: nil, WantError: ErrPenaltyAmountMismatch},
 		{PenaltyRef: "121", Outstanding: 150, Type: "penalty", MadeUpDate: "2017-06-30", Reason: "Failure to file a confirmation statement",
-			OriginalAmount: 150, IsDCA: true, IsPaid: false, WantMatched: nil, WantError: ErrPenaltyDCA},
+			OriginalAmount: 150, IsDCA: true, IsPaid: false, PayableStatus: OpenPayableStatus, WantMatched: nil, WantError: ErrPenaltyDCA},
 		{PenaltyRef: "121", Outstanding: 150, Type: "penalty", MadeUpDate: "2017-06-30", Reason: "Failure to file a confirmation statement",
-			OriginalAmount: 150, IsDCA: false, IsPaid: false, WantMatched: &matchedPenalty, WantError: nil},
+			OriginalAmount: 150, IsDCA: false, IsPaid: false, PayableStatus: OpenPayableStatus, WantMatched: &matchedPenalty, WantError: nil},
+		{PenaltyRef: "121", Outstanding: 150, Type: "penalty", MadeUpDate: "2017-06-30", Reason: "Failure to file a confirmation statement",
+			OriginalAmount: 150, IsDCA: false, IsPaid: false, PayableStatus: OpenWithPenaltyPayableStatus, WantMatched: nil, WantError: ErrPenaltyNotPayable},
 	}
 
 	Convey("matchPenalty works correctly for different scenarios", t, func() {
@@ -66,12 +70,191 @@ func TestUnitMatchPenalty(t *testing.T) {
 					IsPaid:         testCase.IsPaid,
 					MadeUpDate:     testCase.MadeUpDate,
 					Reason:         testCase.Reason,
+					PayableStatus:  testCase.PayableStatus,
 				},
 			}
-			matched, err := MatchPenalty(refTransactions, transactionsToMatch, companyNumber, "")
+			matched, err := MatchPenalty(refTransactions, transactionsToMatch, []models.TransactionItem{transactionsToMatch}, config.PartPaymentPolicy{}, companyNumber, "")
 
 			So(err, ShouldEqual, testCase.WantError)
 			So(matched, ShouldResemble, testCase.WantMatched)
 		}
 	})
 }
+
+func TestUnitMatchPenaltyWithCosts(t *testing.T) {
+	penalty := models.TransactionItem{PenaltyRef: "A0000001", Amount: 3000}
+	legalCost := models.TransactionItem{PenaltyRef: "CF1", Amount: 105}
+	collectionCost := models.TransactionItem{PenaltyRef: "FC1", Amount: 80}
+
+	refTransactions := []models.TransactionListItem{
+		{ID: "A0000001", Type: "penalty", MadeUpDate: "2018-06-30", OriginalAmount: 3000, Outstanding: 3000,
+			PayableStatus: OpenPayableStatus, Reason: "Late filing of accounts"},
+		{ID: "CF1", Type: "other", MadeUpDate: "2018-06-30", OriginalAmount: 105, Outstanding: 105,
+			PayableStatus: OpenWithPenaltyPayableStatus},
+		{ID: "FC1", Type: "other", MadeUpDate: "2018-06-30", OriginalAmount: 80, Outstanding: 80,
+			PayableStatus: OpenWithPenaltyPayableStatus},
+		{ID: "A0000002", Type: "penalty", MadeUpDate: "2019-06-30", OriginalAmount: 750, Outstanding: 750,
+			PayableStatus: OpenPayableStatus},
+	}
+
+	Convey("Given a penalty has unpaid costs", t, func() {
+		Convey("When the penalty is paid together with all of its costs then the penalty and costs are matched", func() {
+			bundle := []models.TransactionItem{penalty, legalCost, collectionCost}
+
+			matched, err := MatchPenalty(refTransactions, penalty, bundle, config.PartPaymentPolicy{}, "12345678", "")
+			So(err, ShouldBeNil)
+			So(matched.PenaltyRef, ShouldEqual, "A0000001")
+
+			matched, err = MatchPenalty(refTransactions, legalCost, bundle, config.PartPaymentPolicy{}, "12345678", "")
+			So(err, ShouldBeNil)
+			So(matched, ShouldResemble, &models.TransactionItem{PenaltyRef: "CF1", Amount: 105, Type: "other", MadeUpDate: "2018-06-30"})
+		})
+
+		Convey("When the penalty is paid without one of its costs then it is not matched", func() {
+			matched, err := MatchPenalty(refTransactions, penalty, []models.TransactionItem{penalty, legalCost}, config.PartPaymentPolicy{}, "12345678", "")
+
+			So(err, ShouldEqual, ErrUnpaidCostsNotPaid)
+			So(matched, ShouldBeNil)
+		})
+
+		Convey("When a cost is paid without its penalty then it is not matched", func() {
+			matched, err := MatchPenalty(refTransactions, legalCost, []models.TransactionItem{legalCost, collectionCost}, config.PartPaymentPolicy{}, "12345678", "")
+
+			So(err, ShouldEqual, ErrCostWithoutPenalty)
+			So(matched, ShouldBeNil)
+		})
+
+		Convey("When another penalty has the made up date of a cost then the cost is not matched", func() {
+			otherPenalty := models.TransactionListItem{ID: "P0000001", Type: "penalty", MadeUpDate: "2018-06-30",
+				OriginalAmount: 250, Outstanding: 250, PayableStatus: OpenPayableStatus}
+			bundle := []models.TransactionItem{penalty, legalCost, collectionCost}
+
+			matched, err := MatchPenalty(append(refTransactions, otherPenalty), legalCost, bundle, config.PartPaymentPolicy{}, "12345678", "")
+
+			So(err, ShouldEqual, ErrCostPenaltyAmbiguous)
+			So(matched, ShouldBeNil)
+		})
+
+		Convey("When a penalty without costs is paid on its own then it is matched", func() {
+			otherPenalty := models.TransactionItem{PenaltyRef: "A0000002", Amount: 750}
+
+			matched, err := MatchPenalty(refTransactions, otherPenalty, []models.TransactionItem{otherPenalty}, config.PartPaymentPolicy{}, "12345678", "")
+
+			So(err, ShouldBeNil)
+			So(matched.PenaltyRef, ShouldEqual, "A0000002")
+		})
+	})
+}
+
+func TestUnitMatchPenaltyWithPartPayment(t *testing.T) {
+	policy := config.PartPaymentPolicy{Allowed: true, MinimumAmount: 10}
+
+	refTransactions := []models.TransactionListItem{
+		{ID: "A0000001", Type: "penalty", MadeUpDate: "2018-06-30", OriginalAmount: 3000, Outstanding: 3000,
+			PayableStatus: OpenPayableStatus},
+		{ID: "A0000002", Type: "penalty", MadeUpDate: "2019-06-30", OriginalAmount: 750, Outstanding: 500,
+			PayableStatus: OpenPayableStatus},
+		{ID: "I1000001", Type: "other", MadeUpDate: "2018-06-30", OriginalAmount: 250, Outstanding: 250,
+			PayableStatus: OpenInstalmentDuePayableStatus},
+	}
+
+	Convey("Given a penalty type allows part payments", t, func() {
+		Convey("When part of the outstanding amount is paid then the part paid is matched", func() {
+			partPayment := models.TransactionItem{PenaltyRef: "A0000001", Amount: 1000}
+
+			matched, err := MatchPenalty(refTransactions, partPayment, []models.TransactionItem{partPayment}, policy, "12345678", "")
+
+			So(err, ShouldBeNil)
+			So(matched, ShouldResemble, &models.TransactionItem{PenaltyRef: "A0000001", Amount: 1000, Type: "penalty", MadeUpDate: "2018-06-30"})
+		})
+
+		Convey("When less than the minimum amount is paid then it is not matched", func() {
+			partPayment := models.TransactionItem{PenaltyRef: "A0000001", Amount: 5}
+
+			matched, err := MatchPenalty(refTransactions, partPayment, []models.TransactionItem{partPayment}, policy, "12345678", "")
+
+			So(err, ShouldEqual, ErrPenaltyAmountMismatch)
+			So(matched, ShouldBeNil)
+		})
+
+		Convey("When more than the outstanding amount is paid then it is not matched", func() {
+			overPayment := models.TransactionItem{PenaltyRef: "A0000001", Amount: 3500}
+
+			matched, err := MatchPenalty(refTransactions, overPayment, []models.TransactionItem{overPayment}, policy, "12345678", "")
+
+			So(err, ShouldEqual, ErrPenaltyAmountMismatch)
+			So(matched, ShouldBeNil)
+		})
+
+		Convey("When a part paid penalty is paid then it is matched", func() {
+			remainder := models.TransactionItem{PenaltyRef: "A0000002", Amount: 500}
+
+			matched, err := MatchPenalty(refTransactions, remainder, []models.TransactionItem{remainder}, policy, "12345678", "")
+
+			So(err, ShouldBeNil)
+			So(matched.Amount, ShouldEqual, 500)
+		})
+
+		Convey("When the next instalment due is paid on its own then it is matched", func() {
+			instalment := models.TransactionItem{PenaltyRef: "I1000001", Amount: 250}
+
+			matched, err := MatchPenalty(refTransactions, instalment, []models.TransactionItem{instalment}, policy, "12345678", "")
+
+			So(err, ShouldBeNil)
+			So(matched.PenaltyRef, ShouldEqual, "I1000001")
+		})
+	})
+
+	Convey("Given a penalty type allows part payments and a transaction is not open for payment", t, func() {
+		for _, payableStatus := range []string{
+			ClosedInstalmentPlanPayableStatus,
+			ClosedPenStrategyExhaustedPayableStatus,
+			ClosedPendingAllocationPayableStatus,
+		} {
+			Convey("When a part paid penalty that is "+payableStatus+" is paid then it is not matched", func() {
+				closedPenalty := models.TransactionListItem{ID: "A0000003", Type: "penalty", MadeUpDate: "2020-06-30",
+					OriginalAmount: 3000, Outstanding: 2000, PayableStatus: payableStatus}
+				payment := models.TransactionItem{PenaltyRef: "A0000003", Amount: 1000}
+
+				matched, err := MatchPenalty(append(refTransactions, closedPenalty), payment, []models.TransactionItem{payment}, policy, "12345678", "")
+
+				So(err, ShouldEqual, ErrPenaltyNotPayable)
+				So(matched, ShouldBeNil)
+			})
+		}
+
+		Convey("When a cost that is not open with its penalty is paid then it is not matched", func() {
+			closedCost := models.TransactionListItem{ID: "FC1", Type: "other", MadeUpDate: "2018-06-30",
+				OriginalAmount: 80, Outstanding: 80, PayableStatus: ClosedPayableStatus}
+			penalty := models.TransactionItem{PenaltyRef: "A0000001", Amount: 3000}
+			cost := models.TransactionItem{PenaltyRef: "FC1", Amount: 80}
+
+			matched, err := MatchPenalty(append(refTransactions, closedCost), cost, []models.TransactionItem{penalty, cost}, policy, "12345678", "")
+
+			So(err, ShouldEqual, ErrPenaltyNotPayable)
+			So(matched, ShouldBeNil)
+		})
+
+		Convey("When an instalment that is not the next due is paid then it is not matched", func() {
+			laterInstalment := models.TransactionListItem{ID: "I1000002", Type: "other", MadeUpDate: "2018-06-30",
+				OriginalAmount: 250, Outstanding: 250, PayableStatus: ClosedPayableStatus}
+			instalment := models.TransactionItem{PenaltyRef: "I1000002", Amount: 250}
+
+			matched, err := MatchPenalty(append(refTransactions, laterInstalment), instalment, []models.TransactionItem{instalment}, policy, "12345678", "")
+
+			So(err, ShouldEqual, ErrPenaltyNotPayable)
+			So(matched, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a penalty type does not allow part payments", t, func() {
+		Convey("When part of the outstanding amount is paid then it is not matched", func() {
+			partPayment := models.TransactionItem{PenaltyRef: "A0000001", Amount: 1000}
+
+			matched, err := MatchPenalty(refTransactions, partPayment, []models.TransactionItem{partPayment}, config.PartPaymentPolicy{}, "12345678", "")
+
+			So(err, ShouldEqual, ErrPenaltyAmountMismatch)
+			So(matched, ShouldBeNil)
+		})
+	})
+}
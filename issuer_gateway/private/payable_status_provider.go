@@ -5,7 +5,6 @@ import (
 	"time"
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 )
@@ -17,106 +16,73 @@ const (
 	ClosedPendingAllocationPayableStatus    = "CLOSED_PENDING_ALLOCATION"
 	ClosedInstalmentPlanPayableStatus       = "CLOSED_INSTALMENT_PLAN"
 	ClosedPenStrategyExhaustedPayableStatus = "CLOSED_PEN_STRATEGY_EXHAUSTED"
+	// OpenWithPenaltyPayableStatus is the status of an unpaid cost that can only be paid together with its penalty
+	OpenWithPenaltyPayableStatus = "OPEN_WITH_PENALTY"
+	// OpenInstalmentDuePayableStatus is the status of the next instalment due of a penalty on an instalment plan
+	OpenInstalmentDuePayableStatus = "OPEN_INSTALMENT_DUE"
 )
 
-type PayableStatusProvider interface {
-	GetPayableStatus(transactionType string, e5Transaction *models.AccountPenaltiesDataDao, closedAt *time.Time,
-		e5Transactions []models.AccountPenaltiesDataDao, allowedTransactionsMap *models.AllowedTransactionMap, cfg *config.Config) string
+// isInstalmentTransaction checks whether the transaction has the E5 type and subtype of an instalment. No transaction
+// is an instalment if the payable status rules do not give them.
+func isInstalmentTransaction(e5Transaction *models.AccountPenaltiesDataDao, instalment *config.RelatedTransactionCondition) bool {
+	return instalment != nil && e5Transaction.TransactionType == instalment.TransactionType &&
+		e5Transaction.TransactionSubType == instalment.TransactionSubType
 }
 
-type DefaultPayableStatusProvider struct{}
-
-func (provider *DefaultPayableStatusProvider) GetPayableStatus(transactionType string, e5Transaction *models.AccountPenaltiesDataDao, closedAt *time.Time,
-	e5Transactions []models.AccountPenaltiesDataDao, allowedTransactionsMap *models.AllowedTransactionMap, cfg *config.Config) string {
-	if types.Penalty.String() == transactionType {
-		if penaltyTransactionSubTypeDisabled(e5Transaction, cfg) {
-			return DisabledPayableStatus
-		}
-		closedPayableStatus, isClosed := checkClosedPayableStatus(e5Transaction, closedAt, e5Transactions, allowedTransactionsMap)
-		if isClosed {
-			return closedPayableStatus
-		}
-
-		openPayableStatus, isOpen := checkOpenPayableStatus(e5Transaction)
-		if isOpen {
-			return openPayableStatus
-		}
-	}
-
-	return ClosedPayableStatus
-}
-
-func checkClosedPayableStatus(penalty *models.AccountPenaltiesDataDao, closedAt *time.Time,
-	e5Transactions []models.AccountPenaltiesDataDao, allowedTransactionsMap *models.AllowedTransactionMap) (payableStatus string, isClosed bool) {
-	if (penalty.IsPaid && closedAt != nil) &&
-		penaltyPaidToday(closedAt) &&
-		!penaltyPaymentAllocated(penalty) {
-		return ClosedPendingAllocationPayableStatus, true
-	}
-
-	if checkClosedInstalmentPlanPayableStatus(penalty, e5Transactions) {
-		return ClosedInstalmentPlanPayableStatus, true
-	}
-
-	if checkClosedPenStrategyExhaustedPayableStatus(penalty, e5Transactions) {
-		return ClosedPenStrategyExhaustedPayableStatus, true
-	}
-
-	if penalty.IsPaid || penalty.OutstandingAmount <= 0 || checkDunningStatus(penalty, DCADunningStatus) ||
-		len(getUnpaidCosts(penalty, e5Transactions, allowedTransactionsMap)) > 0 {
-		return ClosedPayableStatus, true
-	}
-	return "", false
-}
-
-func checkClosedInstalmentPlanPayableStatus(penalty *models.AccountPenaltiesDataDao, e5Transactions []models.AccountPenaltiesDataDao) bool {
+// isEarliestUnpaidInstalment checks whether no other unpaid instalment with the made up date of the instalment is due
+// before it, as only the next instalment due can be paid
+func isEarliestUnpaidInstalment(instalment *models.AccountPenaltiesDataDao, e5Transactions []models.AccountPenaltiesDataDao,
+	instalmentTransaction *config.RelatedTransactionCondition) bool {
 	for _, e5Transaction := range e5Transactions {
-		if isInstalmentPlanTransaction(penalty, e5Transaction) {
-			return true
+		if isInstalmentTransaction(&e5Transaction, instalmentTransaction) && e5Transaction.MadeUpDate == instalment.MadeUpDate &&
+			!e5Transaction.IsPaid && e5Transaction.OutstandingAmount > 0 && e5Transaction.DueDate < instalment.DueDate {
+			return false
 		}
 	}
-	return false
-}
-
-func isInstalmentPlanTransaction(penalty *models.AccountPenaltiesDataDao, e5Transaction models.AccountPenaltiesDataDao) bool {
-	return e5Transaction.MadeUpDate == penalty.MadeUpDate &&
-		(e5Transaction.TransactionType == "P" && e5Transaction.TransactionSubType == "00")
+	return true
 }
 
-func checkClosedPenStrategyExhaustedPayableStatus(penalty *models.AccountPenaltiesDataDao, e5Transactions []models.AccountPenaltiesDataDao) bool {
+func getUnpaidCosts(penalty *models.AccountPenaltiesDataDao, e5Transactions []models.AccountPenaltiesDataDao,
+	allowedTransactionsMap *models.AllowedTransactionMap, instalment *config.RelatedTransactionCondition) (unpaidCosts []models.AccountPenaltiesDataDao) {
 	for _, e5Transaction := range e5Transactions {
-		if isExhaustedWriteOffTransaction(penalty, e5Transaction) {
-			return true
+		transactionType := getTransactionType(&e5Transaction, allowedTransactionsMap)
+		if (e5Transaction.TransactionReference != penalty.TransactionReference && !e5Transaction.IsPaid) &&
+			(types.Other.String() == transactionType && penalty.MadeUpDate == e5Transaction.MadeUpDate) &&
+			!isInstalmentTransaction(&e5Transaction, instalment) {
+			unpaidCosts = append(unpaidCosts, e5Transaction)
 		}
 	}
-	return false
-}
-
-func isExhaustedWriteOffTransaction(penalty *models.AccountPenaltiesDataDao, e5Transaction models.AccountPenaltiesDataDao) bool {
-	return e5Transaction.MadeUpDate == penalty.MadeUpDate &&
-		(e5Transaction.TransactionType == "4" && e5Transaction.TransactionSubType == "82")
+	return unpaidCosts
 }
 
-func getUnpaidCosts(penalty *models.AccountPenaltiesDataDao, e5Transactions []models.AccountPenaltiesDataDao,
-	allowedTransactionsMap *models.AllowedTransactionMap) (unpaidCosts []models.AccountPenaltiesDataDao) {
+// getPenaltyOfCost gets the penalty that a cost is associated with by made up date. E5 does not link a cost to its
+// penalty, so nil is returned when more than one penalty has the made up date of the cost, as the cost could then be
+// paid together with the wrong penalty.
+func getPenaltyOfCost(cost *models.AccountPenaltiesDataDao, e5Transactions []models.AccountPenaltiesDataDao,
+	allowedTransactionsMap *models.AllowedTransactionMap) *models.AccountPenaltiesDataDao {
+	var penalty *models.AccountPenaltiesDataDao
 	for _, e5Transaction := range e5Transactions {
 		transactionType := getTransactionType(&e5Transaction, allowedTransactionsMap)
-		if (e5Transaction.TransactionReference != penalty.TransactionReference && !e5Transaction.IsPaid) &&
-			(types.Other.String() == transactionType && penalty.MadeUpDate == e5Transaction.MadeUpDate) {
-			unpaidCosts = append(unpaidCosts, e5Transaction)
+		if (e5Transaction.TransactionReference != cost.TransactionReference) &&
+			(types.Penalty.String() == transactionType && cost.MadeUpDate == e5Transaction.MadeUpDate) {
+			if penalty != nil {
+				return nil
+			}
+			penalty = &e5Transaction
 		}
 	}
-	return unpaidCosts
+	return penalty
 }
 
+// checkOpenPayableStatus checks the dunning and account statuses of the penalty against the statuses that the penalty
+// type registry allows a penalty of its company code to be paid with. A penalty is not open if the registry cannot be
+// loaded.
 func checkOpenPayableStatus(penalty *models.AccountPenaltiesDataDao) (payableStatus string, isOpen bool) {
-	if penalty.CompanyCode == utils.LateFilingPenaltyCompanyCode &&
-		(checkDunningStatus(penalty, PEN1DunningStatus) || checkDunningStatus(penalty, PEN2DunningStatus) || checkDunningStatus(penalty, PEN3DunningStatus)) &&
-		(penalty.AccountStatus == CHSAccountStatus || penalty.AccountStatus == DCAAccountStatus || penalty.AccountStatus == HLDAccountStatus || penalty.AccountStatus == WDRAccountStatus) {
-		return OpenPayableStatus, true
-	} else if penalty.CompanyCode == utils.SanctionsCompanyCode &&
-		(checkDunningStatus(penalty, PEN1DunningStatus) || checkDunningStatus(penalty, PEN2DunningStatus)) &&
-		(penalty.AccountStatus == CHSAccountStatus || penalty.AccountStatus == DCAAccountStatus || penalty.AccountStatus == HLDAccountStatus) {
+	penaltyTypes, err := config.GetPenaltyTypes()
+	if err != nil {
+		return "", false
+	}
+	if penaltyTypes.IsOpen(penalty.CompanyCode, penalty.DunningStatus, penalty.AccountStatus) {
 		return OpenPayableStatus, true
 	}
 	return "", false
@@ -140,11 +106,15 @@ func penaltyPaymentAllocated(penalty *models.AccountPenaltiesDataDao) bool {
 }
 
 func penaltyTransactionSubTypeDisabled(penalty *models.AccountPenaltiesDataDao, cfg *config.Config) bool {
+	return IsTransactionSubTypeDisabled(penalty.TransactionSubType, cfg)
+}
+
+// IsTransactionSubTypeDisabled checks whether the transaction subtype is in the configured list of disabled subtypes
+func IsTransactionSubTypeDisabled(transactionSubType string, cfg *config.Config) bool {
 	trimDisabledSubtypes := strings.ReplaceAll(cfg.DisabledPenaltyTransactionSubtypes, " ", "")
 	disabledSubtypes := strings.Split(trimDisabledSubtypes, ",")
-	penaltySubType := penalty.TransactionSubType
 	for _, subType := range disabledSubtypes {
-		if penaltySubType == subType {
+		if transactionSubType == subType {
 			return true
 		}
 	}
@@ -5,12 +5,12 @@ import (
 	"time"
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
-func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
+func TestUnitGetPayableStatus(t *testing.T) {
 
 	Convey("Get open payable status for late filing penalty", t, func() {
 		type args struct {
@@ -123,7 +123,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
 				closedAt := &yesterday
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, closedAt, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -204,7 +204,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
 				closedAt := &yesterday
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, closedAt, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -279,7 +279,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 		for _, tc := range testCases {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, &now, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -361,7 +361,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
 				closedAt := &yesterday
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, closedAt, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -436,7 +436,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 		for _, tc := range testCases {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, &now, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -518,7 +518,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
 				closedAt := &yesterday
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, closedAt, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -593,7 +593,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 		for _, tc := range testCases {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, &now, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -693,7 +693,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
 				closedAt := &yesterday
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, closedAt, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -729,7 +729,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
 				closedAt := &now
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, closedAt, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, ClosedPendingAllocationPayableStatus)
@@ -753,7 +753,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 		}
 
 		// When
-		provider := &DefaultPayableStatusProvider{}
+		provider := newTestPayableStatusProvider(t)
 		got := provider.GetPayableStatus(types.Penalty.String(), &lateFilingPaidPenalty, nil, e5Transactions, allowedTransactionMap, &cfg)
 
 		// Then
@@ -776,7 +776,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 		}
 
 		// When
-		provider := &DefaultPayableStatusProvider{}
+		provider := newTestPayableStatusProvider(t)
 		got := provider.GetPayableStatus(types.Penalty.String(), &lateFilingPaidPenalty, nil, e5Transactions, allowedTransactionMap, &cfg)
 
 		// Then
@@ -791,7 +791,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 		}
 
 		// When
-		provider := &DefaultPayableStatusProvider{}
+		provider := newTestPayableStatusProvider(t)
 		got := provider.GetPayableStatus(types.Penalty.String(), &lateFilingPaidPenalty, nil, e5Transactions, allowedTransactionMap, &cfg)
 
 		// Then
@@ -806,7 +806,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 		}
 
 		// When
-		provider := &DefaultPayableStatusProvider{}
+		provider := newTestPayableStatusProvider(t)
 		got := provider.GetPayableStatus(types.Penalty.String(), &lateFilingPaidPenalty, nil, e5Transactions, allowedTransactionMap, &cfg)
 
 		// Then
@@ -821,12 +821,97 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 
 		closedAt := time.Now()
 		e5Transactions := []models.AccountPenaltiesDataDao{*oldPaidPenalty, *newPaidPenalty}
-		provider := &DefaultPayableStatusProvider{}
+		provider := newTestPayableStatusProvider(t)
 		So(provider.GetPayableStatus(types.Penalty.String(), oldPaidPenalty, &closedAt, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, ClosedPayableStatus)
 		So(provider.GetPayableStatus(types.Penalty.String(), newPaidPenalty, &closedAt, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, ClosedPendingAllocationPayableStatus)
 
 	})
 
+	Convey("Get payable status for Late filing penalty with associated unpaid costs", t, func() {
+		pen1DunningStatus := addTrailingSpacesToDunningStatus(PEN1DunningStatus)
+		penalty := buildLateFilingPenaltyTestAccountPenaltiesDataDao(false, 150, CHSAccountStatus, pen1DunningStatus)
+		unpaidCost := *penalty
+		unpaidCost.TransactionReference = "FC1"
+		unpaidCost.TransactionType = "5"
+		unpaidCost.TransactionSubType = "19"
+		unpaidCost.Amount = 80
+		unpaidCost.OutstandingAmount = 80
+		unpaidCost.DunningStatus = "            "
+		provider := newTestPayableStatusProvider(t)
+
+		Convey("the penalty is open and the cost is open with the penalty", func() {
+			e5Transactions := []models.AccountPenaltiesDataDao{*penalty, unpaidCost}
+
+			So(provider.GetPayableStatus(types.Penalty.String(), penalty, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, OpenPayableStatus)
+			So(provider.GetPayableStatus(types.Other.String(), &unpaidCost, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, OpenWithPenaltyPayableStatus)
+		})
+
+		Convey("the penalty and cost are closed when the cost is with a debt collecting agency", func() {
+			unpaidCost.DunningStatus = addTrailingSpacesToDunningStatus(DCADunningStatus)
+			e5Transactions := []models.AccountPenaltiesDataDao{*penalty, unpaidCost}
+
+			So(provider.GetPayableStatus(types.Penalty.String(), penalty, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, ClosedPayableStatus)
+			So(provider.GetPayableStatus(types.Other.String(), &unpaidCost, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, ClosedPayableStatus)
+		})
+
+		Convey("the cost is closed when the penalty has been paid", func() {
+			penalty.IsPaid = true
+			penalty.OutstandingAmount = 0
+			e5Transactions := []models.AccountPenaltiesDataDao{*penalty, unpaidCost}
+
+			So(provider.GetPayableStatus(types.Other.String(), &unpaidCost, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, ClosedPayableStatus)
+		})
+
+		Convey("the cost is closed when another penalty has the same made up date", func() {
+			otherPenalty := *penalty
+			otherPenalty.TransactionReference = "A0000002"
+			e5Transactions := []models.AccountPenaltiesDataDao{*penalty, otherPenalty, unpaidCost}
+
+			So(provider.GetPayableStatus(types.Other.String(), &unpaidCost, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, ClosedPayableStatus)
+		})
+
+		Convey("a paid cost is closed", func() {
+			unpaidCost.IsPaid = true
+			unpaidCost.OutstandingAmount = 0
+			e5Transactions := []models.AccountPenaltiesDataDao{*penalty, unpaidCost}
+
+			So(provider.GetPayableStatus(types.Other.String(), &unpaidCost, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, ClosedPayableStatus)
+		})
+	})
+
+	Convey("Get payable status for instalments of a Late filing penalty instalment plan", t, func() {
+		firstInstalment := buildInstalmentTransaction("A3784631-001", "2025-07-23", "2024-12-31", 300, "2025-08-26")
+		secondInstalment := buildInstalmentTransaction("A3784631-002", "2025-07-23", "2024-12-31", 300, "2025-09-26")
+		thirdInstalment := buildInstalmentTransaction("A3784631-003", "2025-07-23", "2024-12-31", 300, "2025-10-26")
+		for _, instalment := range []*models.AccountPenaltiesDataDao{&secondInstalment, &thirdInstalment} {
+			instalment.IsPaid = false
+			instalment.OutstandingAmount = 300
+		}
+		instalmentPlan := buildInstalmentPlanTransaction("A3784631", "2025-07-23", "2024-12-31", 900, "2025-07-23")
+		provider := newTestPayableStatusProvider(t)
+
+		Convey("the unpaid instalment with the earliest due date is the next instalment due", func() {
+			e5Transactions := []models.AccountPenaltiesDataDao{firstInstalment, thirdInstalment, secondInstalment, instalmentPlan}
+
+			So(provider.GetPayableStatus(types.Other.String(), &firstInstalment, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, ClosedPayableStatus)
+			So(provider.GetPayableStatus(types.Other.String(), &secondInstalment, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, OpenInstalmentDuePayableStatus)
+			So(provider.GetPayableStatus(types.Other.String(), &thirdInstalment, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, ClosedPayableStatus)
+		})
+
+		Convey("an instalment is closed when it is with a debt collecting agency", func() {
+			secondInstalment.DunningStatus = addTrailingSpacesToDunningStatus(DCADunningStatus)
+			e5Transactions := []models.AccountPenaltiesDataDao{firstInstalment, secondInstalment, thirdInstalment, instalmentPlan}
+
+			So(provider.GetPayableStatus(types.Other.String(), &secondInstalment, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, ClosedPayableStatus)
+		})
+
+		Convey("an instalment is closed when there is no instalment plan", func() {
+			e5Transactions := []models.AccountPenaltiesDataDao{firstInstalment, secondInstalment, thirdInstalment}
+
+			So(provider.GetPayableStatus(types.Other.String(), &secondInstalment, nil, e5Transactions, allowedTransactionMap, &cfg), ShouldEqual, ClosedPayableStatus)
+		})
+	})
+
 	Convey("Get disabled payable status for sanctions - Confirmation Statement", t, func() {
 		type args struct {
 			penalty *models.AccountPenaltiesDataDao
@@ -895,7 +980,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 		for _, tc := range testCases {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, &now, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -971,7 +1056,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 		for _, tc := range testCases {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, &now, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -1047,7 +1132,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 		for _, tc := range testCases {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, &now, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -1081,7 +1166,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 		for _, tc := range testCases {
 			Convey(tc.name, func() {
 				penalty := tc.args.penalty
-				provider := &DefaultPayableStatusProvider{}
+				provider := newTestPayableStatusProvider(t)
 				got := provider.GetPayableStatus(types.Penalty.String(), penalty, &now, []models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &cfg)
 
 				So(got, ShouldEqual, tc.want)
@@ -1092,7 +1177,7 @@ func TestUnitDefaultPayableStatusProvider_GetPayableStatus(t *testing.T) {
 
 func buildLateFilingPenaltyTestAccountPenaltiesDataDao(isPaid bool, outstandingAmount float64, accountStatus, dunningStatus string) *models.AccountPenaltiesDataDao {
 	dataDao := buildTestAccountPenaltiesDataDao(AccountPenaltiesParams{
-		CompanyCode:          utils.LateFilingPenaltyCompanyCode,
+		CompanyCode:          testutils.LateFilingPenaltyCompanyCode,
 		LedgerCode:           "EW",
 		CustomerCode:         "12345678",
 		TransactionReference: "A1234567",
@@ -1111,7 +1196,7 @@ func buildLateFilingPenaltyTestAccountPenaltiesDataDao(isPaid bool, outstandingA
 
 func buildSanctionsConfirmationStatementTestAccountPenaltiesDataDao(isPaid bool, outstandingAmount float64, accountStatus, dunningStatus string) *models.AccountPenaltiesDataDao {
 	dataDao := buildTestAccountPenaltiesDataDao(AccountPenaltiesParams{
-		CompanyCode:          utils.SanctionsCompanyCode,
+		CompanyCode:          testutils.SanctionsCompanyCode,
 		LedgerCode:           "E1",
 		CustomerCode:         "12345678",
 		TransactionReference: "P1234567",
@@ -1150,7 +1235,7 @@ func buildExhaustedWriteOffTransaction(transactionReference string, transactionD
 
 func buildSanctionsFailedToVerifyIdentityTestAccountPenaltiesDataDao(isPaid bool, outstandingAmount float64, accountStatus, dunningStatus string) *models.AccountPenaltiesDataDao {
 	dataDao := buildTestAccountPenaltiesDataDao(AccountPenaltiesParams{
-		CompanyCode:          utils.SanctionsCompanyCode,
+		CompanyCode:          testutils.SanctionsCompanyCode,
 		LedgerCode:           "E1",
 		CustomerCode:         "12345678",
 		TransactionReference: "P2234567",
@@ -1169,7 +1254,7 @@ func buildSanctionsFailedToVerifyIdentityTestAccountPenaltiesDataDao(isPaid bool
 
 func buildSanctionsRoeTestAccountPenaltiesDataDao(isPaid bool, outstandingAmount float64, accountStatus, dunningStatus string) *models.AccountPenaltiesDataDao {
 	dataDao := buildTestAccountPenaltiesDataDao(AccountPenaltiesParams{
-		CompanyCode:          utils.SanctionsCompanyCode,
+		CompanyCode:          testutils.SanctionsCompanyCode,
 		LedgerCode:           "FU",
 		CustomerCode:         "OE123456",
 		TransactionReference: "U1234567",
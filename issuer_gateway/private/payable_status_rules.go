@@ -0,0 +1,216 @@
+package private
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+)
+
+// PayableStatusRulesVersion is the version of the payable status rules that can be evaluated
+const PayableStatusRulesVersion = 1
+
+// payableStatuses are the payable statuses that a payable status rule can give a transaction
+var payableStatuses = map[string]bool{
+	OpenPayableStatus:                       true,
+	DisabledPayableStatus:                   true,
+	ClosedPayableStatus:                     true,
+	ClosedPendingAllocationPayableStatus:    true,
+	ClosedInstalmentPlanPayableStatus:       true,
+	ClosedPenStrategyExhaustedPayableStatus: true,
+	OpenWithPenaltyPayableStatus:            true,
+	OpenInstalmentDuePayableStatus:          true,
+}
+
+// otherPayableStatuses are the payable statuses that only a rule for other transactions can give, as a penalty cannot
+// be paid together with a penalty or be an instalment
+var otherPayableStatuses = map[string]bool{
+	OpenWithPenaltyPayableStatus:   true,
+	OpenInstalmentDuePayableStatus: true,
+}
+
+// RulesPayableStatusProvider gets the payable status of a transaction by evaluating the payable status rules, so that
+// finance policy can be changed without a release. The penalty of a cost is found by made up date in code, as E5 does
+// not link a cost to its penalty, and the penalty_statuses condition gives the status of that penalty from the rules.
+type RulesPayableStatusProvider struct {
+	rules *config.PayableStatusRules
+}
+
+// NewRulesPayableStatusProvider checks that the payable status rules can be evaluated and returns a provider that
+// evaluates them
+func NewRulesPayableStatusProvider(rules *config.PayableStatusRules) (*RulesPayableStatusProvider, error) {
+	if err := validatePayableStatusRules(rules); err != nil {
+		return nil, fmt.Errorf("invalid payable status rules: [%v]", err)
+	}
+	return &RulesPayableStatusProvider{rules: rules}, nil
+}
+
+// GetPayableStatus gets the status of the first rule whose conditions all hold for the transaction
+func (provider *RulesPayableStatusProvider) GetPayableStatus(transactionType string, e5Transaction *models.AccountPenaltiesDataDao, closedAt *time.Time,
+	e5Transactions []models.AccountPenaltiesDataDao, allowedTransactionsMap *models.AllowedTransactionMap, cfg *config.Config) string {
+	for _, rule := range provider.rules.Rules {
+		if provider.conditionsHold(rule.When, transactionType, e5Transaction, closedAt, e5Transactions, allowedTransactionsMap, cfg) {
+			return rule.Status
+		}
+	}
+	return provider.rules.DefaultStatus
+}
+
+func (provider *RulesPayableStatusProvider) conditionsHold(when config.PayableStatusConditions, transactionType string,
+	e5Transaction *models.AccountPenaltiesDataDao, closedAt *time.Time, e5Transactions []models.AccountPenaltiesDataDao,
+	allowedTransactionsMap *models.AllowedTransactionMap, cfg *config.Config) bool {
+	if when.TransactionType != "" && when.TransactionType != transactionType {
+		return false
+	}
+	if when.SubTypeDisabled != nil && *when.SubTypeDisabled != penaltyTransactionSubTypeDisabled(e5Transaction, cfg) {
+		return false
+	}
+	if when.IsPaid != nil && *when.IsPaid != e5Transaction.IsPaid {
+		return false
+	}
+	if when.PaidToday != nil && *when.PaidToday != (closedAt != nil && penaltyPaidToday(closedAt)) {
+		return false
+	}
+	if when.PaymentAllocated != nil && *when.PaymentAllocated != penaltyPaymentAllocated(e5Transaction) {
+		return false
+	}
+	if when.HasOutstandingAmount != nil && *when.HasOutstandingAmount != (e5Transaction.OutstandingAmount > 0) {
+		return false
+	}
+	if len(when.CompanyCodes) > 0 && !containsString(when.CompanyCodes, e5Transaction.CompanyCode) {
+		return false
+	}
+	if len(when.DunningStatuses) > 0 && !containsString(when.DunningStatuses, strings.TrimSpace(e5Transaction.DunningStatus)) {
+		return false
+	}
+	if len(when.AccountStatuses) > 0 && !containsString(when.AccountStatuses, e5Transaction.AccountStatus) {
+		return false
+	}
+	if when.RelatedTransaction != nil && !hasRelatedTransaction(e5Transaction, e5Transactions, *when.RelatedTransaction) {
+		return false
+	}
+	if len(when.UnpaidCostDunningStatuses) > 0 && !unpaidCostWithDunningStatus(e5Transaction, e5Transactions,
+		allowedTransactionsMap, provider.rules.InstalmentTransaction, when.UnpaidCostDunningStatuses) {
+		return false
+	}
+	if when.OpenForPenaltyType != nil {
+		_, isOpen := checkOpenPayableStatus(e5Transaction)
+		if *when.OpenForPenaltyType != isOpen {
+			return false
+		}
+	}
+	if when.IsInstalment != nil && *when.IsInstalment != isInstalmentTransaction(e5Transaction, provider.rules.InstalmentTransaction) {
+		return false
+	}
+	if when.EarliestUnpaidInstalment != nil &&
+		*when.EarliestUnpaidInstalment != isEarliestUnpaidInstalment(e5Transaction, e5Transactions, provider.rules.InstalmentTransaction) {
+		return false
+	}
+	if len(when.PenaltyStatuses) > 0 {
+		penalty := getPenaltyOfCost(e5Transaction, e5Transactions, allowedTransactionsMap)
+		// validation only allows the condition in rules for other transactions, so the penalty is evaluated without it
+		if penalty == nil || !containsString(when.PenaltyStatuses,
+			provider.GetPayableStatus(types.Penalty.String(), penalty, closedAt, e5Transactions, allowedTransactionsMap, cfg)) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasRelatedTransaction checks whether the customer has a transaction of the type and subtype with the made up date of
+// the transaction
+func hasRelatedTransaction(transaction *models.AccountPenaltiesDataDao, e5Transactions []models.AccountPenaltiesDataDao,
+	related config.RelatedTransactionCondition) bool {
+	for _, e5Transaction := range e5Transactions {
+		if e5Transaction.MadeUpDate == transaction.MadeUpDate && e5Transaction.TransactionType == related.TransactionType &&
+			e5Transaction.TransactionSubType == related.TransactionSubType {
+			return true
+		}
+	}
+	return false
+}
+
+func unpaidCostWithDunningStatus(penalty *models.AccountPenaltiesDataDao, e5Transactions []models.AccountPenaltiesDataDao,
+	allowedTransactionsMap *models.AllowedTransactionMap, instalment *config.RelatedTransactionCondition, dunningStatuses []string) bool {
+	for _, unpaidCost := range getUnpaidCosts(penalty, e5Transactions, allowedTransactionsMap, instalment) {
+		if containsString(dunningStatuses, strings.TrimSpace(unpaidCost.DunningStatus)) {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePayableStatusRules(rules *config.PayableStatusRules) error {
+	if rules.Version != PayableStatusRulesVersion {
+		return fmt.Errorf("version %d is not supported, only version %d is", rules.Version, PayableStatusRulesVersion)
+	}
+	if !payableStatuses[rules.DefaultStatus] || otherPayableStatuses[rules.DefaultStatus] {
+		return fmt.Errorf("default status %q is not a payable status of every transaction", rules.DefaultStatus)
+	}
+	if len(rules.Rules) == 0 {
+		return fmt.Errorf("there are no rules")
+	}
+	if rules.InstalmentTransaction != nil &&
+		(rules.InstalmentTransaction.TransactionType == "" || rules.InstalmentTransaction.TransactionSubType == "") {
+		return fmt.Errorf("the instalment transaction has no transaction type or subtype")
+	}
+
+	ruleNames := map[string]bool{}
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i+1)
+		}
+		if ruleNames[rule.Name] {
+			return fmt.Errorf("rule %q is not the only rule with its name", rule.Name)
+		}
+		ruleNames[rule.Name] = true
+
+		if !payableStatuses[rule.Status] {
+			return fmt.Errorf("rule %q has status %q which is not a payable status", rule.Name, rule.Status)
+		}
+		switch rule.When.TransactionType {
+		case "", types.Penalty.String(), types.Other.String():
+		default:
+			return fmt.Errorf("rule %q has transaction type %q which is not %s or %s", rule.Name,
+				rule.When.TransactionType, types.Penalty.String(), types.Other.String())
+		}
+		isOtherRule := rule.When.TransactionType == types.Other.String()
+		if otherPayableStatuses[rule.Status] && !isOtherRule {
+			return fmt.Errorf("rule %q has status %q which is only a payable status of other transactions", rule.Name, rule.Status)
+		}
+		if len(rule.When.PenaltyStatuses) > 0 && !isOtherRule {
+			return fmt.Errorf("rule %q has penalty statuses but is not only for other transactions", rule.Name)
+		}
+		for _, penaltyStatus := range rule.When.PenaltyStatuses {
+			if !payableStatuses[penaltyStatus] || otherPayableStatuses[penaltyStatus] {
+				return fmt.Errorf("rule %q has penalty status %q which is not a payable status of a penalty", rule.Name, penaltyStatus)
+			}
+		}
+		// a rule without conditions would hide every rule after it, the default status is used for that instead
+		if reflect.DeepEqual(rule.When, config.PayableStatusConditions{}) {
+			return fmt.Errorf("rule %q has no conditions", rule.Name)
+		}
+		if rule.When.RelatedTransaction != nil &&
+			(rule.When.RelatedTransaction.TransactionType == "" || rule.When.RelatedTransaction.TransactionSubType == "") {
+			return fmt.Errorf("rule %q has a related transaction without a transaction type and subtype", rule.Name)
+		}
+		// without the instalment transaction no transaction is an instalment, so the rule would never hold as meant
+		if (rule.When.IsInstalment != nil || rule.When.EarliestUnpaidInstalment != nil) && rules.InstalmentTransaction == nil {
+			return fmt.Errorf("rule %q has an instalment condition but there is no instalment transaction", rule.Name)
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
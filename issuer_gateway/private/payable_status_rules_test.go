@@ -0,0 +1,274 @@
+package private
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
+	"github.com/companieshouse/penalty-payment-api/testutils"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func loadTestRulesPayableStatusProvider(fileName string) (*RulesPayableStatusProvider, error) {
+	rules, err := config.LoadPayableStatusRules(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return NewRulesPayableStatusProvider(rules)
+}
+
+// newTestPayableStatusProvider gets the payable status of transactions from the payable status rules deployed with
+// the API
+func newTestPayableStatusProvider(t *testing.T) *RulesPayableStatusProvider {
+	provider, err := loadTestRulesPayableStatusProvider(testutils.PayableStatusRulesFile())
+	if err != nil {
+		t.Fatalf("failed to load payable status rules: %v", err)
+	}
+	return provider
+}
+
+func TestUnitRulesPayableStatusProvider_GetPayableStatus(t *testing.T) {
+	Convey("Given the payable status rules deployed with the API", t, func() {
+		rulesProvider := newTestPayableStatusProvider(t)
+
+		rulesCfg := &config.Config{}
+		pen1DunningStatus := addTrailingSpacesToDunningStatus(PEN1DunningStatus)
+		now := time.Now()
+
+		unpaidPenalty := buildLateFilingPenaltyTestAccountPenaltiesDataDao(false, 150, CHSAccountStatus, pen1DunningStatus)
+		unpaidCost := *unpaidPenalty
+		unpaidCost.TransactionReference = "FC1"
+		unpaidCost.TransactionType = "5"
+		unpaidCost.TransactionSubType = "19"
+		unpaidCost.OutstandingAmount = 80
+		unpaidCost.DunningStatus = "            "
+		unpaidCostWithDCA := unpaidCost
+		unpaidCostWithDCA.DunningStatus = addTrailingSpacesToDunningStatus(DCADunningStatus)
+
+		penaltyWithInstalmentPlan := buildPaidPenaltyTransaction("A3784631", "2025-05-02", "2024-12-31", 3000, "2025-05-02")
+		penaltyWrittenOff := buildPaidPenaltyTransaction("A3137684", "2022-10-05", "2021-10-31", 750, "2022-10-05")
+		instalmentPlan := buildInstalmentPlanTransaction("A3784631", "2025-07-23", "2024-12-31", 3000, "2025-07-23")
+		paidInstalment := buildInstalmentTransaction("A3784631-001", "2025-07-23", "2024-12-31", 1500, "2025-08-23")
+		nextInstalment := buildInstalmentTransaction("A3784631-002", "2025-07-23", "2024-12-31", 1000, "2025-09-23")
+		nextInstalment.IsPaid = false
+		nextInstalment.OutstandingAmount = 1000
+		laterInstalment := nextInstalment
+		laterInstalment.TransactionReference = "A3784631-003"
+		laterInstalment.DueDate = "2025-10-23"
+		nextInstalmentWithDCA := nextInstalment
+		nextInstalmentWithDCA.DunningStatus = addTrailingSpacesToDunningStatus(DCADunningStatus)
+		instalments := []models.AccountPenaltiesDataDao{penaltyWithInstalmentPlan, instalmentPlan, paidInstalment, nextInstalment, laterInstalment}
+		secondPenalty := *unpaidPenalty
+		secondPenalty.TransactionReference = "A0000002"
+
+		testCases := []struct {
+			name            string
+			transactionType string
+			transaction     *models.AccountPenaltiesDataDao
+			closedAt        *time.Time
+			e5Transactions  []models.AccountPenaltiesDataDao
+			cfg             *config.Config
+			want            string
+		}{
+			{
+				name:        "an unpaid late filing penalty",
+				transaction: unpaidPenalty,
+				want:        OpenPayableStatus,
+			},
+			{
+				name: "a late filing penalty in PEN3 of a withdrawn account",
+				transaction: buildLateFilingPenaltyTestAccountPenaltiesDataDao(false, 150, WDRAccountStatus,
+					addTrailingSpacesToDunningStatus(PEN3DunningStatus)),
+				want: OpenPayableStatus,
+			},
+			{
+				name: "a sanctions penalty in PEN2 of an account on hold",
+				transaction: buildSanctionsConfirmationStatementTestAccountPenaltiesDataDao(false, 250, HLDAccountStatus,
+					addTrailingSpacesToDunningStatus(PEN2DunningStatus)),
+				want: OpenPayableStatus,
+			},
+			{
+				name: "a sanctions penalty in PEN3",
+				transaction: buildSanctionsConfirmationStatementTestAccountPenaltiesDataDao(false, 250, CHSAccountStatus,
+					addTrailingSpacesToDunningStatus(PEN3DunningStatus)),
+				want: ClosedPayableStatus,
+			},
+			{
+				name:        "a penalty with a disabled subtype",
+				transaction: buildSanctionsConfirmationStatementTestAccountPenaltiesDataDao(false, 250, CHSAccountStatus, pen1DunningStatus),
+				cfg:         &config.Config{DisabledPenaltyTransactionSubtypes: SanctionsConfirmationStatementTransactionSubType},
+				want:        DisabledPayableStatus,
+			},
+			{
+				name:        "a penalty paid today that E5 has not allocated",
+				transaction: buildLateFilingPenaltyTestAccountPenaltiesDataDao(true, 150, CHSAccountStatus, pen1DunningStatus),
+				closedAt:    &now,
+				want:        ClosedPendingAllocationPayableStatus,
+			},
+			{
+				name:        "a penalty paid today that E5 has allocated",
+				transaction: buildLateFilingPenaltyTestAccountPenaltiesDataDao(true, 0, CHSAccountStatus, pen1DunningStatus),
+				closedAt:    &now,
+				want:        ClosedPayableStatus,
+			},
+			{
+				name:        "a penalty with an instalment plan",
+				transaction: &penaltyWithInstalmentPlan,
+				e5Transactions: []models.AccountPenaltiesDataDao{
+					penaltyWithInstalmentPlan,
+					buildInstalmentPlanTransaction("A3784631", "2025-07-23", "2024-12-31", 3000, "2025-07-23"),
+				},
+				want: ClosedInstalmentPlanPayableStatus,
+			},
+			{
+				name:        "a penalty that has been written off",
+				transaction: &penaltyWrittenOff,
+				e5Transactions: []models.AccountPenaltiesDataDao{
+					buildExhaustedWriteOffTransaction("EXHAUSTED WRITE", "2024-03-20", "2021-10-31", -750, "2024-03-20"),
+				},
+				want: ClosedPenStrategyExhaustedPayableStatus,
+			},
+			{
+				name:        "an unpaid penalty with nothing outstanding",
+				transaction: buildLateFilingPenaltyTestAccountPenaltiesDataDao(false, 0, CHSAccountStatus, pen1DunningStatus),
+				want:        ClosedPayableStatus,
+			},
+			{
+				name: "a penalty with a debt collecting agency",
+				transaction: buildLateFilingPenaltyTestAccountPenaltiesDataDao(false, 150, DCAAccountStatus,
+					addTrailingSpacesToDunningStatus(DCADunningStatus)),
+				want: ClosedPayableStatus,
+			},
+			{
+				name:           "a penalty with an unpaid cost with a debt collecting agency",
+				transaction:    unpaidPenalty,
+				e5Transactions: []models.AccountPenaltiesDataDao{*unpaidPenalty, unpaidCostWithDCA},
+				want:           ClosedPayableStatus,
+			},
+			{
+				name:            "an unpaid cost of an open penalty",
+				transactionType: types.Other.String(),
+				transaction:     &unpaidCost,
+				e5Transactions:  []models.AccountPenaltiesDataDao{*unpaidPenalty, unpaidCost},
+				want:            OpenWithPenaltyPayableStatus,
+			},
+			{
+				name:            "an unpaid cost of a closed penalty",
+				transactionType: types.Other.String(),
+				transaction:     &unpaidCost,
+				e5Transactions:  []models.AccountPenaltiesDataDao{*unpaidPenalty, unpaidCostWithDCA, unpaidCost},
+				want:            ClosedPayableStatus,
+			},
+			{
+				name:            "an unpaid cost with the made up date of two penalties",
+				transactionType: types.Other.String(),
+				transaction:     &unpaidCost,
+				e5Transactions:  []models.AccountPenaltiesDataDao{*unpaidPenalty, secondPenalty, unpaidCost},
+				want:            ClosedPayableStatus,
+			},
+			{
+				name:            "the next instalment due of an instalment plan",
+				transactionType: types.Other.String(),
+				transaction:     &nextInstalment,
+				e5Transactions:  instalments,
+				want:            OpenInstalmentDuePayableStatus,
+			},
+			{
+				name:            "an instalment due after the next instalment due",
+				transactionType: types.Other.String(),
+				transaction:     &laterInstalment,
+				e5Transactions:  instalments,
+				want:            ClosedPayableStatus,
+			},
+			{
+				name:            "a paid instalment",
+				transactionType: types.Other.String(),
+				transaction:     &paidInstalment,
+				e5Transactions:  instalments,
+				want:            ClosedPayableStatus,
+			},
+			{
+				name:            "an instalment with a debt collecting agency",
+				transactionType: types.Other.String(),
+				transaction:     &nextInstalmentWithDCA,
+				e5Transactions:  []models.AccountPenaltiesDataDao{penaltyWithInstalmentPlan, instalmentPlan, nextInstalmentWithDCA},
+				want:            ClosedPayableStatus,
+			},
+		}
+
+		for _, tc := range testCases {
+			Convey("Then the payable status of "+tc.name+" is "+tc.want, func() {
+				transactionType := tc.transactionType
+				if transactionType == "" {
+					transactionType = types.Penalty.String()
+				}
+				e5Transactions := tc.e5Transactions
+				if e5Transactions == nil {
+					e5Transactions = []models.AccountPenaltiesDataDao{*tc.transaction}
+				}
+				cfg := tc.cfg
+				if cfg == nil {
+					cfg = rulesCfg
+				}
+
+				got := rulesProvider.GetPayableStatus(transactionType, tc.transaction, tc.closedAt, e5Transactions, allowedTransactionMap, cfg)
+
+				So(got, ShouldEqual, tc.want)
+			})
+		}
+	})
+
+	Convey("Given payable status rules that close the penalties of accounts on hold", t, func() {
+		rulesProvider, err := loadTestRulesPayableStatusProvider("testdata/payable_status_rules/hold_accounts_closed.yml")
+		So(err, ShouldBeNil)
+		pen1DunningStatus := addTrailingSpacesToDunningStatus(PEN1DunningStatus)
+
+		Convey("Then a penalty of an account on hold is closed", func() {
+			penalty := buildSanctionsConfirmationStatementTestAccountPenaltiesDataDao(false, 250, HLDAccountStatus, pen1DunningStatus)
+
+			got := rulesProvider.GetPayableStatus(types.Penalty.String(), penalty, nil,
+				[]models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &config.Config{})
+
+			So(got, ShouldEqual, ClosedPayableStatus)
+		})
+
+		Convey("Then a penalty of an account not on hold is open", func() {
+			penalty := buildSanctionsConfirmationStatementTestAccountPenaltiesDataDao(false, 250, CHSAccountStatus, pen1DunningStatus)
+
+			got := rulesProvider.GetPayableStatus(types.Penalty.String(), penalty, nil,
+				[]models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &config.Config{})
+
+			So(got, ShouldEqual, OpenPayableStatus)
+		})
+
+		Convey("Then a penalty that no rule holds for has the default status", func() {
+			penalty := buildSanctionsConfirmationStatementTestAccountPenaltiesDataDao(false, 250, CHSAccountStatus,
+				addTrailingSpacesToDunningStatus(PEN3DunningStatus))
+
+			got := rulesProvider.GetPayableStatus(types.Penalty.String(), penalty, nil,
+				[]models.AccountPenaltiesDataDao{*penalty}, allowedTransactionMap, &config.Config{})
+
+			So(got, ShouldEqual, ClosedPayableStatus)
+		})
+	})
+}
+
+func TestUnitNewRulesPayableStatusProvider(t *testing.T) {
+	Convey("Given payable status rules that cannot be evaluated", t, func() {
+		fileNames, err := filepath.Glob("testdata/payable_status_rules/invalid/*.yml")
+		So(err, ShouldBeNil)
+		So(fileNames, ShouldNotBeEmpty)
+
+		for _, fileName := range fileNames {
+			Convey("Then the rules in "+filepath.Base(fileName)+" are rejected", func() {
+				provider, err := loadTestRulesPayableStatusProvider(fileName)
+
+				So(err, ShouldNotBeNil)
+				So(provider, ShouldBeNil)
+			})
+		}
+	})
+}
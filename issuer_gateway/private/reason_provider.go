@@ -2,16 +2,11 @@ package private
 
 import (
 	"github.com/companieshouse/penalty-payment-api-core/models"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
+	"github.com/companieshouse/penalty-payment-api/config"
 )
 
-const (
-	LateFilingPenaltyReason               = "Late filing of accounts"
-	SanctionsConfirmationStatementReason  = "Failure to file a confirmation statement"
-	SanctionsFailedToVerifyIdentityReason = "Failure to file a confirmation statement and identity verification statements for all directors"
-	SanctionsRoeFailureToUpdateReason     = "Failure to update the Register of Overseas Entities"
-	PenaltyReason                         = "Penalty"
-)
+// PenaltyReason is the reason shown for a penalty when its penalty type does not set one
+const PenaltyReason = "Penalty"
 
 type ReasonProvider interface {
 	GetReason(transaction *models.AccountPenaltiesDataDao) string
@@ -19,29 +14,18 @@ type ReasonProvider interface {
 
 type DefaultReasonProvider struct{}
 
+// GetReason gets the reason of a penalty from the penalty type registry, or PenaltyReason if the registry has none or
+// cannot be loaded
 func (provider *DefaultReasonProvider) GetReason(transaction *models.AccountPenaltiesDataDao) string {
 	if transaction.TransactionType == InvoiceTransactionType {
-		switch transaction.CompanyCode {
-		case utils.LateFilingPenaltyCompanyCode:
-			return LateFilingPenaltyReason
-		case utils.SanctionsCompanyCode:
-			return getSanctionsReason(transaction)
-		default:
+		penaltyTypes, err := config.GetPenaltyTypes()
+		if err != nil {
 			return PenaltyReason
 		}
-	}
-	return ""
-}
-
-func getSanctionsReason(transaction *models.AccountPenaltiesDataDao) string {
-	switch transaction.TransactionSubType {
-	case SanctionsConfirmationStatementTransactionSubType:
-		return SanctionsConfirmationStatementReason
-	case SanctionsFailedToVerifyIdentityTransactionSubType:
-		return SanctionsFailedToVerifyIdentityReason
-	case SanctionsRoeFailureToUpdateTransactionSubType:
-		return SanctionsRoeFailureToUpdateReason
-	default:
+		if reason := penaltyTypes.Reason(transaction.CompanyCode, transaction.TransactionSubType); reason != "" {
+			return reason
+		}
 		return PenaltyReason
 	}
+	return ""
 }
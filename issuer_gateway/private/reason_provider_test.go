@@ -4,7 +4,7 @@ import (
 	"testing"
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -21,34 +21,34 @@ func TestUnitDefaultReasonProvider_GetReason(t *testing.T) {
 			{
 				name: "Late filing of accounts",
 				args: args{penalty: &models.AccountPenaltiesDataDao{
-					CompanyCode:        utils.LateFilingPenaltyCompanyCode,
+					CompanyCode:        testutils.LateFilingPenaltyCompanyCode,
 					TransactionType:    InvoiceTransactionType,
 					TransactionSubType: "C1",
 				}},
-				want: LateFilingPenaltyReason,
+				want: "Late filing of accounts",
 			},
 			{
 				name: "Failure to file a confirmation statement",
 				args: args{penalty: &models.AccountPenaltiesDataDao{
-					CompanyCode:        utils.SanctionsCompanyCode,
+					CompanyCode:        testutils.SanctionsCompanyCode,
 					TransactionType:    InvoiceTransactionType,
 					TransactionSubType: SanctionsConfirmationStatementTransactionSubType,
 				}},
-				want: SanctionsConfirmationStatementReason,
+				want: "Failure to file a confirmation statement",
 			},
 			{
 				name: "Failure to file a confirmation statement and identity verification statements for all directors",
 				args: args{penalty: &models.AccountPenaltiesDataDao{
-					CompanyCode:        utils.SanctionsCompanyCode,
+					CompanyCode:        testutils.SanctionsCompanyCode,
 					TransactionType:    InvoiceTransactionType,
 					TransactionSubType: SanctionsFailedToVerifyIdentityTransactionSubType,
 				}},
-				want: SanctionsFailedToVerifyIdentityReason,
+				want: "Failure to file a confirmation statement and identity verification statements for all directors",
 			},
 			{
 				name: "Sanctions Penalty - Unknown",
 				args: args{penalty: &models.AccountPenaltiesDataDao{
-					CompanyCode:        utils.SanctionsCompanyCode,
+					CompanyCode:        testutils.SanctionsCompanyCode,
 					TransactionType:    InvoiceTransactionType,
 					TransactionSubType: "S2",
 				}},
@@ -57,16 +57,16 @@ func TestUnitDefaultReasonProvider_GetReason(t *testing.T) {
 			{
 				name: "Failure to update the Register of Overseas Entities",
 				args: args{penalty: &models.AccountPenaltiesDataDao{
-					CompanyCode:        utils.SanctionsCompanyCode,
+					CompanyCode:        testutils.SanctionsCompanyCode,
 					TransactionType:    InvoiceTransactionType,
 					TransactionSubType: SanctionsRoeFailureToUpdateTransactionSubType,
 				}},
-				want: SanctionsRoeFailureToUpdateReason,
+				want: "Failure to update the Register of Overseas Entities",
 			},
 			{
 				name: "Other Transaction",
 				args: args{penalty: &models.AccountPenaltiesDataDao{
-					CompanyCode:        utils.SanctionsCompanyCode,
+					CompanyCode:        testutils.SanctionsCompanyCode,
 					TransactionType:    "5",
 					TransactionSubType: "02",
 				}},
@@ -0,0 +1,20 @@
+package types
+
+// CompanyPenalty is a penalty transaction for any customer within a company code
+type CompanyPenalty struct {
+	CompanyCode          string  `json:"company_code"`
+	LedgerCode           string  `json:"ledger_code"`
+	CustomerCode         string  `json:"customer_code"`
+	TransactionReference string  `json:"transaction_reference"`
+	TransactionDate      string  `json:"transaction_date"`
+	MadeUpDate           string  `json:"made_up_date"`
+	Amount               float64 `json:"amount"`
+	OutstandingAmount    float64 `json:"outstanding_amount"`
+	IsPaid               bool    `json:"is_paid"`
+	TransactionType      string  `json:"transaction_type"`
+	TransactionSubType   string  `json:"transaction_sub_type"`
+	TypeDescription      string  `json:"type_description"`
+	DueDate              string  `json:"due_date"`
+	AccountStatus        string  `json:"account_status"`
+	DunningStatus        string  `json:"dunning_status"`
+}
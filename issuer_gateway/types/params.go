@@ -1,28 +1,47 @@
 package types
 
 import (
+	"context"
+
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api/common/dao"
 	"github.com/companieshouse/penalty-payment-api/config"
 )
 
 type AccountPenaltiesParams struct {
+	Context                    context.Context
 	PenaltyRefType             string
 	CustomerCode               string
 	CompanyCode                string
 	PenaltyDetailsMap          *config.PenaltyDetailsMap
 	AllowedTransactionsMap     *models.AllowedTransactionMap
 	AccountPenaltiesDaoService dao.AccountPenaltiesDaoService
+	PayableStatusProvider      PayableStatusProvider
 	RequestId                  string
 }
 
 type PayablePenaltyParams struct {
+	Context                    context.Context
 	PenaltyRefType             string
 	CustomerCode               string
 	CompanyCode                string
 	Transaction                models.TransactionItem
+	Transactions               []models.TransactionItem // every transaction paid together with Transaction, including it
 	PenaltyDetailsMap          *config.PenaltyDetailsMap
 	AllowedTransactionsMap     *models.AllowedTransactionMap
 	AccountPenaltiesDaoService dao.AccountPenaltiesDaoService
+	PayableStatusProvider      PayableStatusProvider
 	RequestId                  string
 }
+
+type CompanyPenaltiesParams struct {
+	Context                context.Context
+	CompanyCode            string
+	FromDate               string
+	ToDate                 string
+	TransactionType        string
+	TransactionSubType     string
+	IsPaid                 *bool
+	AllowedTransactionsMap *models.AllowedTransactionMap
+	RequestId              string
+}
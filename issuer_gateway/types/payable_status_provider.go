@@ -0,0 +1,15 @@
+package types
+
+import (
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/config"
+)
+
+// PayableStatusProvider gets the payable status of an E5 transaction of a customer from the transaction, the other
+// transactions of the customer and when the customer last paid
+type PayableStatusProvider interface {
+	GetPayableStatus(transactionType string, e5Transaction *models.AccountPenaltiesDataDao, closedAt *time.Time,
+		e5Transactions []models.AccountPenaltiesDataDao, allowedTransactionsMap *models.AllowedTransactionMap, cfg *config.Config) string
+}
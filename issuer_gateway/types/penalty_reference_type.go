@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+// PenaltyReferenceType describes a penalty reference type supported by the API
+type PenaltyReferenceType struct {
+	ReferenceType       string     `json:"reference_type"`
+	Description         string     `json:"description"`
+	CompanyCode         string     `json:"company_code"`
+	ReferenceStartsWith string     `json:"reference_starts_with"`
+	ReferenceRegex      string     `json:"reference_regex"`
+	Reason              string     `json:"reason,omitempty"`
+	ResourceKind        string     `json:"resource_kind"`
+	EnabledFrom         *time.Time `json:"enabled_from,omitempty"`
+	EnabledTo           *time.Time `json:"enabled_to,omitempty"`
+	Enabled             bool       `json:"enabled"`
+}
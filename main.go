@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -18,10 +19,13 @@ import (
 	"github.com/companieshouse/chs.go/kafka/resilience"
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/penalty-payment-api/common/dao"
-	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/companieshouse/penalty-payment-api/common/kafka"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/handlers"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/private"
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/expiry"
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/relay"
 	"github.com/companieshouse/penalty-payment-api/penalty_payments/supervisor"
 	"github.com/gorilla/mux"
 )
@@ -48,6 +52,9 @@ func main() {
 	}
 	prDaoService := dao.NewPayableResourcesDaoService(mongoClientProvider, cfg)
 	apDaoService := dao.NewAccountPenaltiesDaoService(mongoClientProvider, cfg)
+	pmDaoService := dao.NewProcessedMessagesDaoService(mongoClientProvider, cfg)
+	dlDaoService := dao.NewDeadLettersDaoService(mongoClientProvider, cfg)
+	outboxDaoService := dao.NewOutboxDaoService(mongoClientProvider, cfg)
 
 	penaltyDetailsMap, err := config.LoadPenaltyDetails("assets/penalty_details.yml")
 	if err != nil {
@@ -55,31 +62,120 @@ func main() {
 		return
 	}
 
+	// the penalty types are looked up in the penalty details deployed with the API rather than the copy built in
+	if err = config.RegisterPenaltyTypes(penaltyDetailsMap); err != nil {
+		log.Error(fmt.Errorf(exitErrorFormat, err), nil)
+		return
+	}
+
 	allowedTransactionsMap, err := config.GetAllowedTransactions("assets/penalty_types.yml")
 	if err != nil {
 		log.Error(fmt.Errorf(exitErrorFormat, err), nil)
 		return
 	}
 
-	handlers.Register(mainRouter, cfg, prDaoService, apDaoService, penaltyDetailsMap, allowedTransactionsMap)
+	// the API does not start with payable status rules that cannot be evaluated
+	payableStatusRules, err := config.LoadPayableStatusRules("assets/payable_status_rules.yml")
+	if err != nil {
+		log.Error(fmt.Errorf(exitErrorFormat, err), nil)
+		return
+	}
+	payableStatusProvider, err := private.NewRulesPayableStatusProvider(payableStatusRules)
+	if err != nil {
+		log.Error(fmt.Errorf(exitErrorFormat, err), nil)
+		return
+	}
 
-	if cfg.FeatureFlagPaymentsProcessingEnabled {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+	e5Client, err := api.GetE5Client(cfg)
+	if err != nil {
+		log.Error(fmt.Errorf(exitErrorFormat, err), nil)
+		return
+	}
+
+	// the producers are shared by every request and consumer, so they are connected once up front and reconnected in
+	// the background if the brokers are unavailable
+	producers := kafka.GetProducerManager()
+	producers.UseEmbeddedSchema(cfg.EmailSendTopic, kafka.EmailSendSchema)
+	producers.UseEmbeddedSchema(cfg.PenaltyPaymentsProcessingTopic, kafka.PenaltyPaymentsProcessingSchema)
+	producers.Preload([][]string{cfg.BrokerAddr, cfg.Kafka3BrokerAddr}, cfg.SchemaRegistryURL,
+		cfg.EmailSendTopic, cfg.PenaltyPaymentsProcessingTopic)
+	producers.CheckSchemas(cfg.SchemaRegistryURL, cfg.EmailSendTopic, cfg.PenaltyPaymentsProcessingTopic)
+	producersCtx, cancelProducers := context.WithCancel(context.Background())
+	defer cancelProducers()
+	go producers.Reconnect(producersCtx, kafka.DefaultReconnectInterval)
+
+	// the relay publishes the messages that payments put in the outbox, retrying them while Kafka is unavailable
+	relayCtx, cancelRelay := context.WithCancel(context.Background())
+	defer cancelRelay()
+	var outboxRelay sync.WaitGroup
+	outboxRelay.Add(1)
+	go func() {
+		defer outboxRelay.Done()
+		relay.NewRelay(outboxDaoService, producers, cfg).Run(relayCtx)
+	}()
+
+	// payable resources that are not paid within their lifetime are expired, and deleted by mongo once they have been
+	// expired for the TTL
+	if err = prDaoService.EnsureExpiredTTLIndex(cfg.GetExpiredPayableResourcesTTL()); err != nil {
+		log.Error(fmt.Errorf("expired payable resources will not be deleted: [%v]", err))
+	}
+	// messages published from the outbox are deleted by mongo once they have been published for the TTL
+	if err = outboxDaoService.EnsurePublishedTTLIndex(cfg.GetPublishedOutboxMessagesTTL()); err != nil {
+		log.Error(fmt.Errorf("published outbox messages will not be deleted: [%v]", err))
+	}
+	sweeperCtx, cancelSweeper := context.WithCancel(context.Background())
+	defer cancelSweeper()
+	var expirySweeper sync.WaitGroup
+	expirySweeper.Add(1)
+	go func() {
+		defer expirySweeper.Done()
+		expiry.NewSweeper(prDaoService, penaltyDetailsMap, cfg).Run(sweeperCtx)
+	}()
+
+	recoveryJob := handlers.Register(mainRouter, cfg, prDaoService, apDaoService, dlDaoService, penaltyDetailsMap,
+		allowedTransactionsMap, e5Client, payableStatusProvider)
+
+	// payable resources that were paid but never marked as paid are recovered on a schedule, which needs an API key to
+	// call the payments API with
+	recoveryCtx, cancelRecovery := context.WithCancel(context.Background())
+	defer cancelRecovery()
+	var recoveryWorker sync.WaitGroup
+	if cfg.RecoveryAPIKey != "" {
+		recoveryWorker.Add(1)
+		go func() {
+			defer recoveryWorker.Done()
+			recoveryJob.Schedule(recoveryCtx, cfg.GetRecoveryInterval(), cfg.RecoveryAPIKey)
+		}()
+	} else {
+		log.Info("RECOVERY_API_KEY is not set, the recovery job will only run through the admin endpoint")
+	}
 
+	// cancelling the consumers context stops the consumers once they have finished the message they are processing
+	consumersCtx, cancelConsumers := context.WithCancel(context.Background())
+	defer cancelConsumers()
+	var consumers sync.WaitGroup
+
+	if cfg.FeatureFlagPaymentsProcessingEnabled {
 		// Push the Sarama logs into our custom writer
 		sarama.Logger = gologger.New(&log.Writer{}, "[Sarama] ", gologger.LstdFlags)
 		penaltyFinancePayment := &api.PenaltyFinancePayment{
-			E5Client:                  e5.NewClient(cfg.E5Username, cfg.E5APIURL),
+			E5Client:                  e5Client,
 			PayableResourceDaoService: prDaoService,
 		}
-		go supervisor.SuperviseConsumer(ctx, cfg.ConsumerGroupName, cfg, penaltyFinancePayment, nil)
-
 		retry := &resilience.ServiceRetry{
 			ThrottleRate: time.Duration(cfg.ConsumerRetryThrottleRate) * time.Second,
 			MaxRetries:   cfg.ConsumerRetryMaxAttempts,
 		}
-		go supervisor.SuperviseConsumer(ctx, cfg.ConsumerRetryGroupName, cfg, penaltyFinancePayment, retry)
+
+		consumers.Add(2)
+		go func() {
+			defer consumers.Done()
+			supervisor.SuperviseConsumer(consumersCtx, cfg.ConsumerGroupName, cfg, penaltyFinancePayment, pmDaoService, dlDaoService, nil)
+		}()
+		go func() {
+			defer consumers.Done()
+			supervisor.SuperviseConsumer(consumersCtx, cfg.ConsumerRetryGroupName, cfg, penaltyFinancePayment, pmDaoService, dlDaoService, retry)
+		}()
 	}
 
 	log.Info("Starting " + namespace)
@@ -95,7 +191,7 @@ func main() {
 	// run server in new go routine to allow app shutdown signal wait below
 	go func() {
 		log.Info("starting server...", log.Data{"port": cfg.BindAddr})
-		err = h.ListenAndServe()
+		err := h.ListenAndServe()
 
 		log.Info("server stopping...")
 		if err != nil && !errors.Is(http.ErrServerClosed, err) {
@@ -109,15 +205,65 @@ func main() {
 	<-stop
 
 	log.Info("shutting down server...")
-	prDaoService.Shutdown()
-	timeout := time.Duration(5) * time.Second
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), timeout)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.GetShutdownTimeout())
 	defer shutdownCancel()
 
+	cancelConsumers()
+	cancelRelay()
+	cancelSweeper()
+	cancelRecovery()
+
+	// the server waits for in-flight requests, then the consumers, the outbox relay, the expiry sweeper and the
+	// recovery job are waited for, so that mongo is not disconnected while a payment is still being recorded
 	err = h.Shutdown(shutdownCtx)
 	if err != nil {
 		log.Error(fmt.Errorf("failed to shutdown server gracefully: [%v]", err))
 	} else {
 		log.Info("server shutdown gracefully")
 	}
+
+	if err = waitForWorkers(shutdownCtx, "consumers", &consumers); err != nil {
+		log.Error(err)
+	} else {
+		log.Info("consumers stopped gracefully")
+	}
+
+	if err = waitForWorkers(shutdownCtx, "outbox relay", &outboxRelay); err != nil {
+		log.Error(err)
+	} else {
+		log.Info("outbox relay stopped gracefully")
+	}
+
+	if err = waitForWorkers(shutdownCtx, "expiry sweeper", &expirySweeper); err != nil {
+		log.Error(err)
+	} else {
+		log.Info("expiry sweeper stopped gracefully")
+	}
+
+	if err = waitForWorkers(shutdownCtx, "recovery job", &recoveryWorker); err != nil {
+		log.Error(err)
+	} else {
+		log.Info("recovery job stopped gracefully")
+	}
+
+	cancelProducers()
+	producers.Close()
+	prDaoService.Shutdown()
+}
+
+// waitForWorkers waits for the background workers, such as the supervised consumers, to stop, or until the context is
+// done
+func waitForWorkers(ctx context.Context, name string, workers *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s did not stop gracefully: [%v]", name, ctx.Err())
+	}
 }
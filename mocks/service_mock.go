@@ -6,9 +6,12 @@ package mocks
 
 import (
 	reflect "reflect"
+	time "time"
 
 	models "github.com/companieshouse/penalty-payment-api-core/models"
+	deadletter "github.com/companieshouse/penalty-payment-api/common/deadletter"
 	e5 "github.com/companieshouse/penalty-payment-api/common/e5"
+	outbox "github.com/companieshouse/penalty-payment-api/common/outbox"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -35,6 +38,21 @@ func (m *MockPayableResourceDaoService) EXPECT() *MockPayableResourceDaoServiceM
 	return m.recorder
 }
 
+// CancelPayableResource mocks base method.
+func (m *MockPayableResourceDaoService) CancelPayableResource(customerCode, payableRef string, cancelledAt time.Time, requestId string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelPayableResource", customerCode, payableRef, cancelledAt, requestId)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelPayableResource indicates an expected call of CancelPayableResource.
+func (mr *MockPayableResourceDaoServiceMockRecorder) CancelPayableResource(customerCode, payableRef, cancelledAt, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelPayableResource", reflect.TypeOf((*MockPayableResourceDaoService)(nil).CancelPayableResource), customerCode, payableRef, cancelledAt, requestId)
+}
+
 // CreatePayableResource mocks base method.
 func (m *MockPayableResourceDaoService) CreatePayableResource(dao *models.PayableResourceDao, requestId string) error {
 	m.ctrl.T.Helper()
@@ -49,6 +67,65 @@ func (mr *MockPayableResourceDaoServiceMockRecorder) CreatePayableResource(dao,
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreatePayableResource", reflect.TypeOf((*MockPayableResourceDaoService)(nil).CreatePayableResource), dao, requestId)
 }
 
+// EnsureExpiredTTLIndex mocks base method.
+func (m *MockPayableResourceDaoService) EnsureExpiredTTLIndex(ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnsureExpiredTTLIndex", ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnsureExpiredTTLIndex indicates an expected call of EnsureExpiredTTLIndex.
+func (mr *MockPayableResourceDaoServiceMockRecorder) EnsureExpiredTTLIndex(ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnsureExpiredTTLIndex", reflect.TypeOf((*MockPayableResourceDaoService)(nil).EnsureExpiredTTLIndex), ttl)
+}
+
+// ExpirePayableResource mocks base method.
+func (m *MockPayableResourceDaoService) ExpirePayableResource(customerCode, payableRef string, expiredAt, sessionsRecordedBefore time.Time, requestId string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExpirePayableResource", customerCode, payableRef, expiredAt, sessionsRecordedBefore, requestId)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExpirePayableResource indicates an expected call of ExpirePayableResource.
+func (mr *MockPayableResourceDaoServiceMockRecorder) ExpirePayableResource(customerCode, payableRef, expiredAt, sessionsRecordedBefore, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExpirePayableResource", reflect.TypeOf((*MockPayableResourceDaoService)(nil).ExpirePayableResource), customerCode, payableRef, expiredAt, sessionsRecordedBefore, requestId)
+}
+
+// GetE5Progress mocks base method.
+func (m *MockPayableResourceDaoService) GetE5Progress(customerCode, payableRef, requestId string) (*e5.PaymentProgress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetE5Progress", customerCode, payableRef, requestId)
+	ret0, _ := ret[0].(*e5.PaymentProgress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetE5Progress indicates an expected call of GetE5Progress.
+func (mr *MockPayableResourceDaoServiceMockRecorder) GetE5Progress(customerCode, payableRef, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetE5Progress", reflect.TypeOf((*MockPayableResourceDaoService)(nil).GetE5Progress), customerCode, payableRef, requestId)
+}
+
+// GetExpirablePayableResources mocks base method.
+func (m *MockPayableResourceDaoService) GetExpirablePayableResources(createdAfter, createdBefore time.Time, limit int, requestId string) ([]models.PayableResourceDao, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExpirablePayableResources", createdAfter, createdBefore, limit, requestId)
+	ret0, _ := ret[0].([]models.PayableResourceDao)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExpirablePayableResources indicates an expected call of GetExpirablePayableResources.
+func (mr *MockPayableResourceDaoServiceMockRecorder) GetExpirablePayableResources(createdAfter, createdBefore, limit, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExpirablePayableResources", reflect.TypeOf((*MockPayableResourceDaoService)(nil).GetExpirablePayableResources), createdAfter, createdBefore, limit, requestId)
+}
+
 // GetPayableResource mocks base method.
 func (m *MockPayableResourceDaoService) GetPayableResource(customerCode, payableRef, requestId string) (*models.PayableResourceDao, error) {
 	m.ctrl.T.Helper()
@@ -64,18 +141,147 @@ func (mr *MockPayableResourceDaoServiceMockRecorder) GetPayableResource(customer
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPayableResource", reflect.TypeOf((*MockPayableResourceDaoService)(nil).GetPayableResource), customerCode, payableRef, requestId)
 }
 
+// GetPaymentSessions mocks base method.
+func (m *MockPayableResourceDaoService) GetPaymentSessions(customerCode, payableRef, requestId string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPaymentSessions", customerCode, payableRef, requestId)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPaymentSessions indicates an expected call of GetPaymentSessions.
+func (mr *MockPayableResourceDaoServiceMockRecorder) GetPaymentSessions(customerCode, payableRef, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPaymentSessions", reflect.TypeOf((*MockPayableResourceDaoService)(nil).GetPaymentSessions), customerCode, payableRef, requestId)
+}
+
+// SaveFailedMarkAsPaidSteps mocks base method.
+func (m *MockPayableResourceDaoService) SaveFailedMarkAsPaidSteps(customerCode, payableRef string, steps []string, requestId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveFailedMarkAsPaidSteps", customerCode, payableRef, steps, requestId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveFailedMarkAsPaidSteps indicates an expected call of SaveFailedMarkAsPaidSteps.
+func (mr *MockPayableResourceDaoServiceMockRecorder) SaveFailedMarkAsPaidSteps(customerCode, payableRef, steps, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveFailedMarkAsPaidSteps", reflect.TypeOf((*MockPayableResourceDaoService)(nil).SaveFailedMarkAsPaidSteps), customerCode, payableRef, steps, requestId)
+}
+
+// GetFailedMarkAsPaidSteps mocks base method.
+func (m *MockPayableResourceDaoService) GetFailedMarkAsPaidSteps(customerCode, payableRef, requestId string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetFailedMarkAsPaidSteps", customerCode, payableRef, requestId)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFailedMarkAsPaidSteps indicates an expected call of GetFailedMarkAsPaidSteps.
+func (mr *MockPayableResourceDaoServiceMockRecorder) GetFailedMarkAsPaidSteps(customerCode, payableRef, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFailedMarkAsPaidSteps", reflect.TypeOf((*MockPayableResourceDaoService)(nil).GetFailedMarkAsPaidSteps), customerCode, payableRef, requestId)
+}
+
+// AddOutboxMessages mocks base method.
+func (m *MockPayableResourceDaoService) AddOutboxMessages(messages []outbox.Message, requestId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddOutboxMessages", messages, requestId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddOutboxMessages indicates an expected call of AddOutboxMessages.
+func (mr *MockPayableResourceDaoServiceMockRecorder) AddOutboxMessages(messages, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOutboxMessages", reflect.TypeOf((*MockPayableResourceDaoService)(nil).AddOutboxMessages), messages, requestId)
+}
+
+// GetStalePendingPayableResources mocks base method.
+func (m *MockPayableResourceDaoService) GetStalePendingPayableResources(createdAfter, createdBefore time.Time, limit int, requestId string) ([]models.PayableResourceDao, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetStalePendingPayableResources", createdAfter, createdBefore, limit, requestId)
+	ret0, _ := ret[0].([]models.PayableResourceDao)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetStalePendingPayableResources indicates an expected call of GetStalePendingPayableResources.
+func (mr *MockPayableResourceDaoServiceMockRecorder) GetStalePendingPayableResources(createdAfter, createdBefore, limit, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStalePendingPayableResources", reflect.TypeOf((*MockPayableResourceDaoService)(nil).GetStalePendingPayableResources), createdAfter, createdBefore, limit, requestId)
+}
+
+// RecordPaymentSession mocks base method.
+func (m *MockPayableResourceDaoService) RecordPaymentSession(customerCode, payableRef, paymentID, requestId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordPaymentSession", customerCode, payableRef, paymentID, requestId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecordPaymentSession indicates an expected call of RecordPaymentSession.
+func (mr *MockPayableResourceDaoServiceMockRecorder) RecordPaymentSession(customerCode, payableRef, paymentID, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordPaymentSession", reflect.TypeOf((*MockPayableResourceDaoService)(nil).RecordPaymentSession), customerCode, payableRef, paymentID, requestId)
+}
+
+// RecordUnidentifiedPaymentSession mocks base method.
+func (m *MockPayableResourceDaoService) RecordUnidentifiedPaymentSession(customerCode, payableRef, requestId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordUnidentifiedPaymentSession", customerCode, payableRef, requestId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecordUnidentifiedPaymentSession indicates an expected call of RecordUnidentifiedPaymentSession.
+func (mr *MockPayableResourceDaoServiceMockRecorder) RecordUnidentifiedPaymentSession(customerCode, payableRef, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordUnidentifiedPaymentSession", reflect.TypeOf((*MockPayableResourceDaoService)(nil).RecordUnidentifiedPaymentSession), customerCode, payableRef, requestId)
+}
+
+// SaveE5Compensation mocks base method.
+func (m *MockPayableResourceDaoService) SaveE5Compensation(customerCode, payableRef, requestId string, action e5.Action, compensationErr error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveE5Compensation", customerCode, payableRef, requestId, action, compensationErr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveE5Compensation indicates an expected call of SaveE5Compensation.
+func (mr *MockPayableResourceDaoServiceMockRecorder) SaveE5Compensation(customerCode, payableRef, requestId, action, compensationErr interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveE5Compensation", reflect.TypeOf((*MockPayableResourceDaoService)(nil).SaveE5Compensation), customerCode, payableRef, requestId, action, compensationErr)
+}
+
 // SaveE5Error mocks base method.
-func (m *MockPayableResourceDaoService) SaveE5Error(customerCode, payableRef, requestId string, action e5.Action) error {
+func (m *MockPayableResourceDaoService) SaveE5Error(customerCode, payableRef, requestId string, action e5.Action, e5Err error) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveE5Error", customerCode, payableRef, requestId, action)
+	ret := m.ctrl.Call(m, "SaveE5Error", customerCode, payableRef, requestId, action, e5Err)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SaveE5Error indicates an expected call of SaveE5Error.
-func (mr *MockPayableResourceDaoServiceMockRecorder) SaveE5Error(customerCode, payableRef, requestId, action interface{}) *gomock.Call {
+func (mr *MockPayableResourceDaoServiceMockRecorder) SaveE5Error(customerCode, payableRef, requestId, action, e5Err interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveE5Error", reflect.TypeOf((*MockPayableResourceDaoService)(nil).SaveE5Error), customerCode, payableRef, requestId, action, e5Err)
+}
+
+// SaveE5Progress mocks base method.
+func (m *MockPayableResourceDaoService) SaveE5Progress(customerCode, payableRef, requestId string, action e5.Action) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveE5Progress", customerCode, payableRef, requestId, action)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveE5Progress indicates an expected call of SaveE5Progress.
+func (mr *MockPayableResourceDaoServiceMockRecorder) SaveE5Progress(customerCode, payableRef, requestId, action interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveE5Error", reflect.TypeOf((*MockPayableResourceDaoService)(nil).SaveE5Error), customerCode, payableRef, requestId, action)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveE5Progress", reflect.TypeOf((*MockPayableResourceDaoService)(nil).SaveE5Progress), customerCode, payableRef, requestId, action)
 }
 
 // Shutdown mocks base method.
@@ -91,17 +297,17 @@ func (mr *MockPayableResourceDaoServiceMockRecorder) Shutdown() *gomock.Call {
 }
 
 // UpdatePaymentDetails mocks base method.
-func (m *MockPayableResourceDaoService) UpdatePaymentDetails(dao *models.PayableResourceDao, requestId string) error {
+func (m *MockPayableResourceDaoService) UpdatePaymentDetails(dao *models.PayableResourceDao, messages []outbox.Message, requestId string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdatePaymentDetails", dao, requestId)
+	ret := m.ctrl.Call(m, "UpdatePaymentDetails", dao, messages, requestId)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdatePaymentDetails indicates an expected call of UpdatePaymentDetails.
-func (mr *MockPayableResourceDaoServiceMockRecorder) UpdatePaymentDetails(dao, requestId interface{}) *gomock.Call {
+func (mr *MockPayableResourceDaoServiceMockRecorder) UpdatePaymentDetails(dao, messages, requestId interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePaymentDetails", reflect.TypeOf((*MockPayableResourceDaoService)(nil).UpdatePaymentDetails), dao, requestId)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePaymentDetails", reflect.TypeOf((*MockPayableResourceDaoService)(nil).UpdatePaymentDetails), dao, messages, requestId)
 }
 
 // MockAccountPenaltiesDaoService is a mock of AccountPenaltiesDaoService interface.
@@ -183,3 +389,246 @@ func (mr *MockAccountPenaltiesDaoServiceMockRecorder) UpdateAccountPenaltyAsPaid
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAccountPenaltyAsPaid", reflect.TypeOf((*MockAccountPenaltiesDaoService)(nil).UpdateAccountPenaltyAsPaid), customerCode, companyCode, penaltyRef, requestId)
 }
+
+// UpdateAccountPenaltyAsPartPaid mocks base method.
+func (m *MockAccountPenaltiesDaoService) UpdateAccountPenaltyAsPartPaid(customerCode, companyCode, penaltyRef string, amountPaid float64, requestId string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateAccountPenaltyAsPartPaid", customerCode, companyCode, penaltyRef, amountPaid, requestId)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateAccountPenaltyAsPartPaid indicates an expected call of UpdateAccountPenaltyAsPartPaid.
+func (mr *MockAccountPenaltiesDaoServiceMockRecorder) UpdateAccountPenaltyAsPartPaid(customerCode, companyCode, penaltyRef, amountPaid, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAccountPenaltyAsPartPaid", reflect.TypeOf((*MockAccountPenaltiesDaoService)(nil).UpdateAccountPenaltyAsPartPaid), customerCode, companyCode, penaltyRef, amountPaid, requestId)
+}
+
+// MockProcessedMessageDaoService is a mock of ProcessedMessageDaoService interface.
+type MockProcessedMessageDaoService struct {
+	ctrl     *gomock.Controller
+	recorder *MockProcessedMessageDaoServiceMockRecorder
+}
+
+// MockProcessedMessageDaoServiceMockRecorder is the mock recorder for MockProcessedMessageDaoService.
+type MockProcessedMessageDaoServiceMockRecorder struct {
+	mock *MockProcessedMessageDaoService
+}
+
+// NewMockProcessedMessageDaoService creates a new mock instance.
+func NewMockProcessedMessageDaoService(ctrl *gomock.Controller) *MockProcessedMessageDaoService {
+	mock := &MockProcessedMessageDaoService{ctrl: ctrl}
+	mock.recorder = &MockProcessedMessageDaoServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockProcessedMessageDaoService) EXPECT() *MockProcessedMessageDaoServiceMockRecorder {
+	return m.recorder
+}
+
+// ClaimMessage mocks base method.
+func (m *MockProcessedMessageDaoService) ClaimMessage(e5PaymentID, requestId string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimMessage", e5PaymentID, requestId)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimMessage indicates an expected call of ClaimMessage.
+func (mr *MockProcessedMessageDaoServiceMockRecorder) ClaimMessage(e5PaymentID, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimMessage", reflect.TypeOf((*MockProcessedMessageDaoService)(nil).ClaimMessage), e5PaymentID, requestId)
+}
+
+// MarkMessageProcessed mocks base method.
+func (m *MockProcessedMessageDaoService) MarkMessageProcessed(e5PaymentID, requestId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkMessageProcessed", e5PaymentID, requestId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkMessageProcessed indicates an expected call of MarkMessageProcessed.
+func (mr *MockProcessedMessageDaoServiceMockRecorder) MarkMessageProcessed(e5PaymentID, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkMessageProcessed", reflect.TypeOf((*MockProcessedMessageDaoService)(nil).MarkMessageProcessed), e5PaymentID, requestId)
+}
+
+// MockDeadLetterDaoService is a mock of DeadLetterDaoService interface.
+type MockDeadLetterDaoService struct {
+	ctrl     *gomock.Controller
+	recorder *MockDeadLetterDaoServiceMockRecorder
+}
+
+// MockDeadLetterDaoServiceMockRecorder is the mock recorder for MockDeadLetterDaoService.
+type MockDeadLetterDaoServiceMockRecorder struct {
+	mock *MockDeadLetterDaoService
+}
+
+// NewMockDeadLetterDaoService creates a new mock instance.
+func NewMockDeadLetterDaoService(ctrl *gomock.Controller) *MockDeadLetterDaoService {
+	mock := &MockDeadLetterDaoService{ctrl: ctrl}
+	mock.recorder = &MockDeadLetterDaoServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDeadLetterDaoService) EXPECT() *MockDeadLetterDaoServiceMockRecorder {
+	return m.recorder
+}
+
+// GetDeadLetter mocks base method.
+func (m *MockDeadLetterDaoService) GetDeadLetter(id, requestId string) (*deadletter.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeadLetter", id, requestId)
+	ret0, _ := ret[0].(*deadletter.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeadLetter indicates an expected call of GetDeadLetter.
+func (mr *MockDeadLetterDaoServiceMockRecorder) GetDeadLetter(id, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeadLetter", reflect.TypeOf((*MockDeadLetterDaoService)(nil).GetDeadLetter), id, requestId)
+}
+
+// GetDeadLetters mocks base method.
+func (m *MockDeadLetterDaoService) GetDeadLetters(status deadletter.Status, limit int, requestId string) ([]deadletter.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeadLetters", status, limit, requestId)
+	ret0, _ := ret[0].([]deadletter.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeadLetters indicates an expected call of GetDeadLetters.
+func (mr *MockDeadLetterDaoServiceMockRecorder) GetDeadLetters(status, limit, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeadLetters", reflect.TypeOf((*MockDeadLetterDaoService)(nil).GetDeadLetters), status, limit, requestId)
+}
+
+// MarkDeadLetterReplayed mocks base method.
+func (m *MockDeadLetterDaoService) MarkDeadLetterReplayed(id, requestId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkDeadLetterReplayed", id, requestId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkDeadLetterReplayed indicates an expected call of MarkDeadLetterReplayed.
+func (mr *MockDeadLetterDaoServiceMockRecorder) MarkDeadLetterReplayed(id, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkDeadLetterReplayed", reflect.TypeOf((*MockDeadLetterDaoService)(nil).MarkDeadLetterReplayed), id, requestId)
+}
+
+// SaveDeadLetter mocks base method.
+func (m *MockDeadLetterDaoService) SaveDeadLetter(message *deadletter.Message, requestId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveDeadLetter", message, requestId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveDeadLetter indicates an expected call of SaveDeadLetter.
+func (mr *MockDeadLetterDaoServiceMockRecorder) SaveDeadLetter(message, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveDeadLetter", reflect.TypeOf((*MockDeadLetterDaoService)(nil).SaveDeadLetter), message, requestId)
+}
+
+// MockOutboxDaoService is a mock of OutboxDaoService interface.
+type MockOutboxDaoService struct {
+	ctrl     *gomock.Controller
+	recorder *MockOutboxDaoServiceMockRecorder
+}
+
+// MockOutboxDaoServiceMockRecorder is the mock recorder for MockOutboxDaoService.
+type MockOutboxDaoServiceMockRecorder struct {
+	mock *MockOutboxDaoService
+}
+
+// NewMockOutboxDaoService creates a new mock instance.
+func NewMockOutboxDaoService(ctrl *gomock.Controller) *MockOutboxDaoService {
+	mock := &MockOutboxDaoService{ctrl: ctrl}
+	mock.recorder = &MockOutboxDaoServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockOutboxDaoService) EXPECT() *MockOutboxDaoServiceMockRecorder {
+	return m.recorder
+}
+
+// ClaimOutboxMessage mocks base method.
+func (m *MockOutboxDaoService) ClaimOutboxMessage(id string, now, leaseUntil time.Time, requestId string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimOutboxMessage", id, now, leaseUntil, requestId)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimOutboxMessage indicates an expected call of ClaimOutboxMessage.
+func (mr *MockOutboxDaoServiceMockRecorder) ClaimOutboxMessage(id, now, leaseUntil, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimOutboxMessage", reflect.TypeOf((*MockOutboxDaoService)(nil).ClaimOutboxMessage), id, now, leaseUntil, requestId)
+}
+
+// EnsurePublishedTTLIndex mocks base method.
+func (m *MockOutboxDaoService) EnsurePublishedTTLIndex(ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnsurePublishedTTLIndex", ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnsurePublishedTTLIndex indicates an expected call of EnsurePublishedTTLIndex.
+func (mr *MockOutboxDaoServiceMockRecorder) EnsurePublishedTTLIndex(ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnsurePublishedTTLIndex", reflect.TypeOf((*MockOutboxDaoService)(nil).EnsurePublishedTTLIndex), ttl)
+}
+
+// GetPendingOutboxMessages mocks base method.
+func (m *MockOutboxDaoService) GetPendingOutboxMessages(now time.Time, limit int, requestId string) ([]outbox.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPendingOutboxMessages", now, limit, requestId)
+	ret0, _ := ret[0].([]outbox.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPendingOutboxMessages indicates an expected call of GetPendingOutboxMessages.
+func (mr *MockOutboxDaoServiceMockRecorder) GetPendingOutboxMessages(now, limit, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPendingOutboxMessages", reflect.TypeOf((*MockOutboxDaoService)(nil).GetPendingOutboxMessages), now, limit, requestId)
+}
+
+// MarkOutboxMessageFailed mocks base method.
+func (m *MockOutboxDaoService) MarkOutboxMessageFailed(id string, publishErr error, nextAttemptAt time.Time, requestId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkOutboxMessageFailed", id, publishErr, nextAttemptAt, requestId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkOutboxMessageFailed indicates an expected call of MarkOutboxMessageFailed.
+func (mr *MockOutboxDaoServiceMockRecorder) MarkOutboxMessageFailed(id, publishErr, nextAttemptAt, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOutboxMessageFailed", reflect.TypeOf((*MockOutboxDaoService)(nil).MarkOutboxMessageFailed), id, publishErr, nextAttemptAt, requestId)
+}
+
+// MarkOutboxMessagePublished mocks base method.
+func (m *MockOutboxDaoService) MarkOutboxMessagePublished(id, requestId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkOutboxMessagePublished", id, requestId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkOutboxMessagePublished indicates an expected call of MarkOutboxMessagePublished.
+func (mr *MockOutboxDaoServiceMockRecorder) MarkOutboxMessagePublished(id, requestId interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOutboxMessagePublished", reflect.TypeOf((*MockOutboxDaoService)(nil).MarkOutboxMessagePublished), id, requestId)
+}
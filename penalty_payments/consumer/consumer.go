@@ -1,26 +1,50 @@
 package consumer
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"fmt"
-	"os"
-	"os/signal"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/companieshouse/chs.go/avro"
-	"github.com/companieshouse/chs.go/avro/schema"
 	consumer "github.com/companieshouse/chs.go/kafka/consumer/cluster"
 	"github.com/companieshouse/chs.go/kafka/producer"
 	"github.com/companieshouse/chs.go/kafka/resilience"
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/deadletter"
+	"github.com/companieshouse/penalty-payment-api/common/kafka"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
 )
 
-func Consume(cfg *config.Config, penaltyFinancePayment api.FinancePayment, retry *resilience.ServiceRetry) {
+// duplicateMessages counts the penalty payment messages that were skipped because they had already been processed
+var duplicateMessages = expvar.NewInt("penalty_payments_duplicate_messages")
+
+// handleMessageRetryInterval is the delay before a message whose handling failed is handled again, which doubles with
+// each failure up to handleMessageMaxRetryInterval
+var (
+	handleMessageRetryInterval    = time.Second
+	handleMessageMaxRetryInterval = time.Minute
+)
+
+// Consume reads penalty payment messages from the topic and processes them until the context is cancelled. The
+// message being processed when the context is cancelled is finished and its offset marked before the group consumer
+// is closed, so a shutdown does not leave a payment part way through E5.
+func Consume(ctx context.Context, cfg *config.Config, penaltyFinancePayment api.FinancePayment,
+	processedMessages dao.ProcessedMessageDaoService, deadLetters dao.DeadLetterDaoService, retry *resilience.ServiceRetry) {
 	avroSchema := getAvroSchema(cfg)
 	topic := cfg.PenaltyPaymentsProcessingTopic
-	resilienceHandler := resilience.NewHandler(topic, cfg.Namespace(), retry, getProducer(cfg), avroSchema)
+	kafkaProducer := getProducer(cfg)
+	resilienceHandler := resilience.NewHandler(topic, cfg.Namespace(), retry, kafkaProducer, avroSchema)
+
+	dlq := &deadLetterQueue{topic: cfg.DeadLetterTopic(), dao: deadLetters}
+	if kafkaProducer != nil {
+		dlq.producer = kafkaProducer.SyncProducer
+	}
 
 	consumerGroupName := cfg.ConsumerGroupName
 	isRetry := retry != nil
@@ -49,39 +73,72 @@ func Consume(cfg *config.Config, penaltyFinancePayment api.FinancePayment, retry
 		log.Error(err)
 	}
 
+	// closing the group consumer commits the offsets marked so far and leaves the group, so the partitions are
+	// rebalanced to the other instances straight away
 	defer func(groupConsumer *consumer.GroupConsumer) {
+		log.Info("Closing Kafka3 consumer", log.Data{"group_name": consumerGroupName})
 		err := groupConsumer.Close()
 		if err != nil {
 			log.Error(err)
 		}
 	}(groupConsumer)
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-
+	// a message is always processed to the end once it has been read, so its E5 requests are not abandoned when the
+	// consumer is stopped. The shutdown timeout in main bounds how long that can take.
+	messageCtx := context.WithoutCancel(ctx)
 	messages := groupConsumer.Messages()
 
 	for {
 		select {
-		case <-c:
-			log.Debug("Application terminating...")
+		case <-ctx.Done():
+			log.Info("Consumer context cancelled, stopping consumer", log.Data{"group_name": consumerGroupName})
 			return
 		case message := <-messages:
 			if message != nil {
-				err := handleMessage(avroSchema, message, penaltyFinancePayment, cfg, resilienceHandler, isRetry)
-				if err != nil {
-					log.Error(err)
-				} else {
-					groupConsumer.MarkOffset(message, "")
+				handled := handleMessageUntilHandled(ctx, func() error {
+					return handleMessage(messageCtx, avroSchema, message, penaltyFinancePayment, processedMessages, dlq, cfg,
+						resilienceHandler, isRetry)
+				})
+				if !handled {
+					log.Info("Consumer context cancelled before the message was handled, stopping consumer", log.Data{
+						"group_name": consumerGroupName,
+						"partition":  message.Partition,
+						"offset":     message.Offset,
+					})
+					return
 				}
+				groupConsumer.MarkOffset(message, "")
 			}
 		}
 	}
 
 }
 
-func handleMessage(avroSchema *avro.Schema, message *sarama.ConsumerMessage, financePayment api.FinancePayment,
-	cfg *config.Config, resilience *resilience.Resilience, isRetry bool) error {
+// handleMessageUntilHandled handles the message again after a backoff for as long as handling it fails, e.g. because it
+// could not be dead-lettered or put on the retry topic. Marking a later offset commits every offset before it, so the
+// next message is not read until this one has been handled. It returns false if the consumer is stopped first, in
+// which case the offset is not marked and the message is redelivered.
+func handleMessageUntilHandled(ctx context.Context, handle func() error) bool {
+	interval := handleMessageRetryInterval
+	for {
+		err := handle()
+		if err == nil {
+			return true
+		}
+		log.Error(fmt.Errorf("error handling penalty payment message, handling it again in %s: [%v]", interval, err))
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, handleMessageMaxRetryInterval)
+	}
+}
+
+func handleMessage(ctx context.Context, avroSchema *avro.Schema, message *sarama.ConsumerMessage, financePayment api.FinancePayment,
+	processedMessages dao.ProcessedMessageDaoService, deadLetters *deadLetterQueue, cfg *config.Config,
+	resilience *resilience.Resilience, isRetry bool) error {
 	log.Debug("Received message", log.Data{
 		"message":  message,
 		"is_retry": isRetry,
@@ -89,7 +146,10 @@ func handleMessage(avroSchema *avro.Schema, message *sarama.ConsumerMessage, fin
 	var penaltyPayment models.PenaltyPaymentsProcessing
 	var err = avroSchema.Unmarshal(message.Value, &penaltyPayment)
 	if err != nil {
-		return fmt.Errorf("error parsing the penalty-payments-processing avro encoded data: [%v]", err)
+		// the message will never decode, so it is dead-lettered rather than read again after every restart
+		err = fmt.Errorf("error parsing the penalty-payments-processing avro encoded data: [%v]", err)
+		log.Error(err, log.Data{"topic": message.Topic, "partition": message.Partition, "offset": message.Offset})
+		return deadLetters.deadLetter(message, deadletter.Undecodable, err)
 	}
 
 	// this will be used for the PUON value in E5. it is referred to as paymentId in their spec. X is prefixed to it
@@ -109,18 +169,53 @@ func handleMessage(avroSchema *avro.Schema, message *sarama.ConsumerMessage, fin
 		"Partition": message.Partition,
 		"Offset":    message.Offset,
 	}, logContext)
-	err = financePayment.ProcessFinancialPenaltyPayment(penaltyPayment, e5PaymentID, cfg, isRetry)
+
+	// Kafka delivers messages at least once, e.g. a message is redelivered if the service stops after processing it
+	// but before its offset is committed, so the payment ID is claimed in the processed messages ledger first. The
+	// claim only skips messages already processed, and one still being processed is claimed again.
+	claimed, err := processedMessages.ClaimMessage(e5PaymentID, "")
+	if err != nil {
+		err = fmt.Errorf("error checking if financial penalty payment has been processed: [%v]", err)
+		log.Error(err, logContext)
+		return resilience.HandleError(err, message.Offset, &penaltyPayment)
+	}
+	if !claimed {
+		duplicateMessages.Add(1)
+		log.Info("Skipping financial penalty payment message that has already been processed", log.Data{
+			"topic":     message.Topic,
+			"partition": message.Partition,
+			"offset":    message.Offset,
+		}, logContext)
+		return nil
+	}
+
+	err = financePayment.ProcessFinancialPenaltyPayment(ctx, penaltyPayment, e5PaymentID, cfg, isRetry)
 	if err != nil {
-		err = fmt.Errorf("error processing financial penalty payment: [%v]", err)
+		err = fmt.Errorf("error processing financial penalty payment: [%w]", err)
 		log.Error(err, logContext)
+		if errors.Is(err, api.ErrPayableResourceNotFound) {
+			// retrying will not create the payable resource, so the message is dead-lettered straight away
+			return deadLetters.deadLetter(message, deadletter.PayableResourceNotFound, err)
+		}
+		if isRetry && penaltyPayment.Attempt >= int32(cfg.ConsumerRetryMaxAttempts) {
+			return deadLetters.deadLetter(message, deadletter.RetriesExhausted, err)
+		}
 		return resilience.HandleError(err, message.Offset, &penaltyPayment)
 	}
 
+	// the payment has been processed, so a failure to record it is only logged. A redelivered message would be
+	// processed again, and the E5 steps already completed are skipped.
+	if err = processedMessages.MarkMessageProcessed(e5PaymentID, ""); err != nil {
+		log.Error(fmt.Errorf("error marking financial penalty payment as processed: [%v]", err), logContext)
+	}
+
 	return nil
 }
 
+// getAvroSchema returns the cached penalty payments processing schema, which is the embedded copy while the schema
+// registry is unavailable
 func getAvroSchema(cfg *config.Config) *avro.Schema {
-	kafkaSchema, err := schema.Get(cfg.SchemaRegistryURL, cfg.PenaltyPaymentsProcessingTopic)
+	kafkaSchema, err := kafka.GetProducerManager().Schema(cfg.SchemaRegistryURL, cfg.PenaltyPaymentsProcessingTopic)
 	if err != nil {
 		kafkaSchemaError := fmt.Errorf("error getting penalty-payments-processing schema from schema registry: [%v]", err)
 		panic(kafkaSchemaError)
@@ -131,13 +226,14 @@ func getAvroSchema(cfg *config.Config) *avro.Schema {
 	return avroSchema
 }
 
+// getProducer returns the Kafka3 producer shared with the rest of the service, which is closed by main once the
+// consumers have stopped
 func getProducer(cfg *config.Config) *producer.Producer {
-	kafkaProducerConfig := &producer.Config{Acks: &producer.WaitForAll, BrokerAddrs: cfg.Kafka3BrokerAddr}
-	syncProducer, err := producer.New(kafkaProducerConfig)
+	kafkaProducer, err := kafka.GetProducerManager().Producer(cfg.Kafka3BrokerAddr)
 	if err != nil {
 		log.Error(fmt.Errorf("error initialising Kafka3 producer for resilience: %s", err), log.Data{
-			"producer_config": kafkaProducerConfig,
+			"broker_addrs": cfg.Kafka3BrokerAddr,
 		})
 	}
-	return syncProducer
+	return kafkaProducer
 }
@@ -1,6 +1,7 @@
 package consumer
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -92,10 +93,16 @@ func TestIntegrationConsume(t *testing.T) {
 			close(processed)
 		})
 
+	mockLedger := new(mockProcessedMessages)
+	mockLedger.On("ClaimMessage", e5PaymentID).Return(true, nil)
+	mockLedger.On("MarkMessageProcessed", e5PaymentID).Return(nil).Maybe()
+
 	// Start consumer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	done := make(chan struct{})
 	go func() {
-		Consume(cfg, mockFinancePayment, nil)
+		Consume(ctx, cfg, mockFinancePayment, mockLedger, new(mockDeadLetters), nil)
 		close(done)
 	}()
 
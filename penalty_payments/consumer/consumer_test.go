@@ -1,6 +1,8 @@
 package consumer
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -11,7 +13,9 @@ import (
 	"github.com/companieshouse/chs.go/kafka/producer"
 	"github.com/companieshouse/chs.go/kafka/resilience"
 	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/deadletter"
 	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/mock"
 )
@@ -43,7 +47,45 @@ type mockPenaltyFinancePayment struct {
 	mock.Mock
 }
 
-func (m *mockPenaltyFinancePayment) ProcessFinancialPenaltyPayment(penaltyPayment models.PenaltyPaymentsProcessing,
+type mockProcessedMessages struct {
+	mock.Mock
+}
+
+func (m *mockProcessedMessages) ClaimMessage(e5PaymentID, _ string) (bool, error) {
+	args := m.Called(e5PaymentID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *mockProcessedMessages) MarkMessageProcessed(e5PaymentID, _ string) error {
+	args := m.Called(e5PaymentID)
+	return args.Error(0)
+}
+
+type mockDeadLetters struct {
+	mock.Mock
+}
+
+func (m *mockDeadLetters) SaveDeadLetter(message *deadletter.Message, _ string) error {
+	args := m.Called(message)
+	return args.Error(0)
+}
+
+func (m *mockDeadLetters) GetDeadLetters(status deadletter.Status, limit int, _ string) ([]deadletter.Message, error) {
+	args := m.Called(status, limit)
+	return args.Get(0).([]deadletter.Message), args.Error(1)
+}
+
+func (m *mockDeadLetters) GetDeadLetter(id, _ string) (*deadletter.Message, error) {
+	args := m.Called(id)
+	return args.Get(0).(*deadletter.Message), args.Error(1)
+}
+
+func (m *mockDeadLetters) MarkDeadLetterReplayed(id, _ string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *mockPenaltyFinancePayment) ProcessFinancialPenaltyPayment(_ context.Context, penaltyPayment models.PenaltyPaymentsProcessing,
 	e5PaymentID string, cfg *config.Config, isRetry bool) error {
 	args := m.Called(penaltyPayment, e5PaymentID, cfg, isRetry)
 	return args.Error(0)
@@ -56,13 +98,77 @@ func TestUnitHandleMessage_Success(t *testing.T) {
 		message := getConsumerMessage(avroSchema, penaltyPayment)
 		mockFinancePayment := new(mockPenaltyFinancePayment)
 		mockFinancePayment.On("ProcessFinancialPenaltyPayment", penaltyPayment, e5PaymentID, cfg, false).Return(nil)
+		mockLedger := new(mockProcessedMessages)
+		mockLedger.On("ClaimMessage", e5PaymentID).Return(true, nil)
+		mockLedger.On("MarkMessageProcessed", e5PaymentID).Return(nil)
 
 		// When
-		err := handleMessage(avroSchema, message, mockFinancePayment, cfg, getTestResilienceHandler(t, avroSchema), false)
+		err := handleMessage(context.Background(), avroSchema, message, mockFinancePayment, mockLedger, getTestDeadLetterQueue(t, nil), cfg, getTestResilienceHandler(t, avroSchema), false)
 
 		// Then
 		So(err, ShouldBeNil)
 		mockFinancePayment.AssertExpectations(t)
+		mockLedger.AssertExpectations(t)
+	})
+}
+
+func TestUnitHandleMessage_AlreadyProcessed(t *testing.T) {
+	Convey("Handle message penalty payments processing skips a message that has already been processed", t, func() {
+		// Given
+		avroSchema := getTestAvroSchema()
+		message := getConsumerMessage(avroSchema, penaltyPayment)
+		mockFinancePayment := new(mockPenaltyFinancePayment)
+		mockLedger := new(mockProcessedMessages)
+		mockLedger.On("ClaimMessage", e5PaymentID).Return(false, nil)
+		duplicates := duplicateMessages.Value()
+
+		// When
+		err := handleMessage(context.Background(), avroSchema, message, mockFinancePayment, mockLedger, getTestDeadLetterQueue(t, nil), cfg, getTestResilienceHandler(t, avroSchema), true)
+
+		// Then
+		So(err, ShouldBeNil)
+		So(duplicateMessages.Value(), ShouldEqual, duplicates+1)
+		mockFinancePayment.AssertNotCalled(t, "ProcessFinancialPenaltyPayment", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		mockLedger.AssertNotCalled(t, "MarkMessageProcessed", mock.Anything)
+	})
+}
+
+func TestUnitHandleMessage_ClaimMessageFails(t *testing.T) {
+	Convey("Handle message penalty payments processing cannot check the processed messages", t, func() {
+		// Given
+		avroSchema := getTestAvroSchema()
+		message := getConsumerMessage(avroSchema, penaltyPayment)
+		mockFinancePayment := new(mockPenaltyFinancePayment)
+		mockLedger := new(mockProcessedMessages)
+		mockLedger.On("ClaimMessage", e5PaymentID).Return(false, errors.New("server selection timeout"))
+
+		// When
+		err := handleMessage(context.Background(), avroSchema, message, mockFinancePayment, mockLedger, getTestDeadLetterQueue(t, nil), cfg, getTestResilienceHandler(t, avroSchema), false)
+
+		// Then
+		So(err, ShouldBeNil)
+		mockFinancePayment.AssertNotCalled(t, "ProcessFinancialPenaltyPayment", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestUnitHandleMessage_MarkMessageProcessedFails(t *testing.T) {
+	Convey("Handle message penalty payments processing cannot mark the message as processed", t, func() {
+		// Given
+		avroSchema := getTestAvroSchema()
+		message := getConsumerMessage(avroSchema, penaltyPayment)
+		mockFinancePayment := new(mockPenaltyFinancePayment)
+		mockFinancePayment.On("ProcessFinancialPenaltyPayment", penaltyPayment, e5PaymentID, cfg, false).Return(nil)
+		mockLedger := new(mockProcessedMessages)
+		mockLedger.On("ClaimMessage", e5PaymentID).Return(true, nil)
+		mockLedger.On("MarkMessageProcessed", e5PaymentID).Return(errors.New("server selection timeout"))
+
+		// When
+		err := handleMessage(context.Background(), avroSchema, message, mockFinancePayment, mockLedger, getTestDeadLetterQueue(t, nil), cfg, getTestResilienceHandler(t, avroSchema), false)
+
+		// Then
+		So(err, ShouldBeNil)
+		mockFinancePayment.AssertExpectations(t)
+		mockLedger.AssertExpectations(t)
 	})
 }
 
@@ -73,13 +179,44 @@ func TestUnitHandleMessage_UnmarshalFails(t *testing.T) {
 		avroSchema := &avro.Schema{Definition: kafkaSchema}
 		message := getConsumerMessage(avroSchema, penaltyPayment)
 		mockFinancePayment := new(mockPenaltyFinancePayment)
+		mockLedger := new(mockProcessedMessages)
+		mockDeadLetterDao := new(mockDeadLetters)
+		mockDeadLetterDao.On("SaveDeadLetter", mock.MatchedBy(func(m *deadletter.Message) bool {
+			return m.Reason == deadletter.Undecodable &&
+				m.Error == "error parsing the penalty-payments-processing avro encoded data: [End of file reached]" &&
+				bytes.Equal(m.Value, message.Value)
+		})).Return(nil)
+		deadLetters := getTestDeadLetterQueue(t, mockDeadLetterDao)
+		deadLetters.producer.(*mocks.SyncProducer).ExpectSendMessageAndSucceed()
 
 		// When
-		err := handleMessage(avroSchema, message, mockFinancePayment, cfg, getTestResilienceHandler(t, avroSchema), false)
+		err := handleMessage(context.Background(), avroSchema, message, mockFinancePayment, mockLedger, deadLetters, cfg, getTestResilienceHandler(t, avroSchema), false)
 
 		// Then
-		So(err, ShouldBeError, errors.New("error parsing the penalty-payments-processing avro encoded data: [End of file reached]"))
+		So(err, ShouldBeNil)
 		mockFinancePayment.AssertNotCalled(t, "ProcessFinancialPenaltyPayment", penaltyPayment, e5PaymentID, cfg)
+		mockLedger.AssertNotCalled(t, "ClaimMessage", mock.Anything)
+		mockDeadLetterDao.AssertExpectations(t)
+	})
+}
+
+func TestUnitHandleMessage_UnmarshalFailsAndDeadLetterFails(t *testing.T) {
+	Convey("Handle message penalty payments processing Unmarshal fails and the dead-letter topic is unavailable", t, func() {
+		// Given
+		kafkaSchema := `{"type":"record","name":"PenaltyPaymentsProcessing","fields":[{"name":"email","type":"string"},{"name":"payable_ref","type":"string"}]}`
+		avroSchema := &avro.Schema{Definition: kafkaSchema}
+		message := getConsumerMessage(avroSchema, penaltyPayment)
+		mockDeadLetterDao := new(mockDeadLetters)
+		deadLetters := getTestDeadLetterQueue(t, mockDeadLetterDao)
+		deadLetters.producer.(*mocks.SyncProducer).ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+
+		// When
+		err := handleMessage(context.Background(), avroSchema, message, new(mockPenaltyFinancePayment), new(mockProcessedMessages),
+			deadLetters, cfg, getTestResilienceHandler(t, avroSchema), false)
+
+		// Then
+		So(err, ShouldNotBeNil)
+		mockDeadLetterDao.AssertNotCalled(t, "SaveDeadLetter", mock.Anything)
 	})
 }
 
@@ -91,16 +228,131 @@ func TestUnitHandleMessage_ProcessFinancialPenaltyPaymentFails(t *testing.T) {
 		mockFinancePayment := new(mockPenaltyFinancePayment)
 		mockFinancePayment.On("ProcessFinancialPenaltyPayment", penaltyPayment, e5PaymentID, cfg, false).
 			Return(errors.New("failed to create payment in E5"))
+		mockLedger := new(mockProcessedMessages)
+		mockLedger.On("ClaimMessage", e5PaymentID).Return(true, nil)
+
+		// When
+		err := handleMessage(context.Background(), avroSchema, message, mockFinancePayment, mockLedger, getTestDeadLetterQueue(t, nil), cfg, getTestResilienceHandler(t, avroSchema), false)
+
+		// Then
+		So(err, ShouldBeNil)
+		mockFinancePayment.AssertExpectations(t)
+		mockLedger.AssertNotCalled(t, "MarkMessageProcessed", mock.Anything)
+	})
+}
+
+func TestUnitHandleMessage_RetriesExhausted(t *testing.T) {
+	Convey("Handle message penalty payments processing fails for the last time from the retry topic", t, func() {
+		// Given
+		retryCfg := &config.Config{ConsumerRetryMaxAttempts: 3}
+		lastAttempt := penaltyPayment
+		lastAttempt.Attempt = 3
+		avroSchema := getTestAvroSchema()
+		message := getConsumerMessage(avroSchema, lastAttempt)
+		mockFinancePayment := new(mockPenaltyFinancePayment)
+		mockFinancePayment.On("ProcessFinancialPenaltyPayment", lastAttempt, e5PaymentID, retryCfg, true).
+			Return(errors.New("failed to create payment in E5"))
+		mockLedger := new(mockProcessedMessages)
+		mockLedger.On("ClaimMessage", e5PaymentID).Return(true, nil)
+		mockDeadLetterDao := new(mockDeadLetters)
+		mockDeadLetterDao.On("SaveDeadLetter", mock.MatchedBy(func(m *deadletter.Message) bool {
+			return m.Reason == deadletter.RetriesExhausted && m.Status == deadletter.Quarantined
+		})).Return(nil)
+		deadLetters := getTestDeadLetterQueue(t, mockDeadLetterDao)
+		deadLetters.producer.(*mocks.SyncProducer).ExpectSendMessageAndSucceed()
 
 		// When
-		err := handleMessage(avroSchema, message, mockFinancePayment, cfg, getTestResilienceHandler(t, avroSchema), false)
+		err := handleMessage(context.Background(), avroSchema, message, mockFinancePayment, mockLedger, deadLetters, retryCfg,
+			getTestResilienceHandler(t, avroSchema), true)
 
 		// Then
 		So(err, ShouldBeNil)
 		mockFinancePayment.AssertExpectations(t)
+		mockDeadLetterDao.AssertExpectations(t)
 	})
 }
 
+func TestUnitHandleMessage_PayableResourceNotFound(t *testing.T) {
+	Convey("Handle message penalty payments processing for a payable resource that does not exist", t, func() {
+		// Given
+		avroSchema := getTestAvroSchema()
+		message := getConsumerMessage(avroSchema, penaltyPayment)
+		mockFinancePayment := new(mockPenaltyFinancePayment)
+		mockFinancePayment.On("ProcessFinancialPenaltyPayment", penaltyPayment, e5PaymentID, cfg, false).
+			Return(api.ErrPayableResourceNotFound)
+		mockLedger := new(mockProcessedMessages)
+		mockLedger.On("ClaimMessage", e5PaymentID).Return(true, nil)
+		mockDeadLetterDao := new(mockDeadLetters)
+		mockDeadLetterDao.On("SaveDeadLetter", mock.MatchedBy(func(m *deadletter.Message) bool {
+			return m.Reason == deadletter.PayableResourceNotFound && m.Status == deadletter.Quarantined
+		})).Return(nil)
+		deadLetters := getTestDeadLetterQueue(t, mockDeadLetterDao)
+		deadLetters.producer.(*mocks.SyncProducer).ExpectSendMessageAndSucceed()
+
+		// When
+		err := handleMessage(context.Background(), avroSchema, message, mockFinancePayment, mockLedger, deadLetters, cfg,
+			nil, false)
+
+		// Then it is dead-lettered without being put on the retry topic
+		So(err, ShouldBeNil)
+		mockFinancePayment.AssertExpectations(t)
+		mockDeadLetterDao.AssertExpectations(t)
+	})
+}
+
+func TestUnitHandleMessageUntilHandled(t *testing.T) {
+	defer func(interval, maxInterval time.Duration) {
+		handleMessageRetryInterval, handleMessageMaxRetryInterval = interval, maxInterval
+	}(handleMessageRetryInterval, handleMessageMaxRetryInterval)
+	handleMessageRetryInterval, handleMessageMaxRetryInterval = time.Millisecond, 2*time.Millisecond
+
+	Convey("Given a message that cannot be dead-lettered the first times it is handled", t, func() {
+		attempts := 0
+		handle := func() error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("error publishing penalty payment message to dead-letter topic")
+			}
+			return nil
+		}
+
+		handled := handleMessageUntilHandled(context.Background(), handle)
+
+		Convey("Then it is handled again until it is dead-lettered, so that its offset can be marked", func() {
+			So(handled, ShouldBeTrue)
+			So(attempts, ShouldEqual, 3)
+		})
+	})
+
+	Convey("Given a message that cannot be dead-lettered when the consumer is stopped", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		attempts := 0
+		handle := func() error {
+			attempts++
+			cancel()
+			return errors.New("error publishing penalty payment message to dead-letter topic")
+		}
+
+		handled := handleMessageUntilHandled(ctx, handle)
+
+		Convey("Then it is not handled, so that its offset is not marked and it is redelivered", func() {
+			So(handled, ShouldBeFalse)
+			So(attempts, ShouldEqual, 1)
+		})
+	})
+}
+
+func getTestDeadLetterQueue(t *testing.T, deadLetterDao *mockDeadLetters) *deadLetterQueue {
+	if deadLetterDao == nil {
+		deadLetterDao = new(mockDeadLetters)
+	}
+	return &deadLetterQueue{
+		topic:    "penalty-payments-processing-dead-letter",
+		producer: mocks.NewSyncProducer(t, nil),
+		dao:      deadLetterDao,
+	}
+}
+
 func getTestAvroSchema() *avro.Schema {
 	kafkaSchema := `{
     "namespace": "uk.gov.companieshouse.financialpenalties",
@@ -0,0 +1,52 @@
+package consumer
+
+import (
+	"expvar"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/deadletter"
+)
+
+// deadLetteredMessages counts the penalty payment messages that were published to the dead-letter topic
+var deadLetteredMessages = expvar.NewInt("penalty_payments_dead_lettered_messages")
+
+// deadLetterQueue publishes penalty payment messages that cannot be processed to the dead-letter topic with their
+// original bytes and headers, and quarantines them so that they can be inspected and replayed through the admin
+// endpoints once the cause has been fixed
+type deadLetterQueue struct {
+	topic    string
+	producer sarama.SyncProducer
+	dao      dao.DeadLetterDaoService
+}
+
+// deadLetter publishes and quarantines the message. An error is returned if either fails, so that the offset is not
+// marked and the consumer handles the message again until it has been dead-lettered.
+func (q *deadLetterQueue) deadLetter(message *sarama.ConsumerMessage, reason deadletter.Reason, err error) error {
+	deadLetter := deadletter.NewMessage(message, reason, err)
+	logContext := log.Data{
+		"dead_letter_id":    deadLetter.ID,
+		"dead_letter_topic": q.topic,
+		"reason":            reason,
+		"error":             deadLetter.Error,
+	}
+
+	if q.producer == nil {
+		return fmt.Errorf("no producer for dead-letter topic [%s]", q.topic)
+	}
+
+	if _, _, sendErr := q.producer.SendMessage(deadLetter.DeadLetterProducerMessage(q.topic)); sendErr != nil {
+		return fmt.Errorf("error publishing penalty payment message to dead-letter topic: [%v]", sendErr)
+	}
+
+	if saveErr := q.dao.SaveDeadLetter(deadLetter, ""); saveErr != nil {
+		return fmt.Errorf("error quarantining dead-lettered penalty payment message: [%v]", saveErr)
+	}
+
+	deadLetteredMessages.Add(1)
+	log.Info("Penalty payment message published to dead-letter topic", logContext)
+
+	return nil
+}
@@ -0,0 +1,161 @@
+// Package expiry expires the payable resources that have not been paid within the lifetime of their penalty type, so
+// that a penalty is not paid with a payable resource created before its amount changed.
+package expiry
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/utils"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/transformers"
+)
+
+// batchSize is how many pending payable resources are read at a time, oldest first
+const batchSize = 500
+
+// expiredResources counts the payable resources the sweeper has expired
+var expiredResources = expvar.NewInt("payable_resources_expired")
+
+// Sweeper expires the pending payable resources that have outlived the lifetime of their penalty type. A resource
+// that had a payment session recorded within its lifetime is left for the recovery job, as the payment may yet be
+// found to have been paid, and is expired once that session is older than the lifetime too. A resource that had a
+// payment session started without its ID being given is never expired, as the recovery job cannot check it.
+type Sweeper struct {
+	prDao           dao.PayableResourceDaoService
+	lifetimes       map[string]time.Duration
+	defaultLifetime time.Duration
+	interval        time.Duration
+	now             func() time.Time
+}
+
+// NewSweeper will construct a sweeper that expires resources after the lifetime of their penalty type in the penalty
+// details, or after the configured lifetime if their penalty type does not set one
+func NewSweeper(prDao dao.PayableResourceDaoService, penaltyDetailsMap *config.PenaltyDetailsMap, cfg *config.Config) *Sweeper {
+	defaultLifetime := cfg.GetPayableResourceLifetime()
+	lifetimes := map[string]time.Duration{}
+	for penaltyRefType, penaltyDetails := range penaltyDetailsMap.Details {
+		lifetimes[penaltyRefType] = penaltyDetails.GetPayableResourceLifetime(defaultLifetime)
+	}
+
+	return &Sweeper{
+		prDao:           prDao,
+		lifetimes:       lifetimes,
+		defaultLifetime: defaultLifetime,
+		interval:        cfg.GetExpirySweepInterval(),
+		now:             time.Now,
+	}
+}
+
+// Run expires the resources that have outlived their lifetime straight away and then every interval until the
+// context is done
+func (s *Sweeper) Run(ctx context.Context) {
+	log.Info("expiry sweeper started", log.Data{"interval": s.interval.String(), "lifetimes": s.lifetimes})
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.ExpireStale(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Info("expiry sweeper stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireStale expires the pending resources that have outlived their lifetime and returns how many were expired
+func (s *Sweeper) ExpireStale(ctx context.Context) int {
+	now := s.now()
+	createdBefore := now.Add(-s.shortestLifetime())
+
+	expired := 0
+	var createdAfter time.Time
+	for {
+		resources, err := s.prDao.GetExpirablePayableResources(createdAfter, createdBefore, batchSize, "")
+		if err != nil {
+			log.Error(fmt.Errorf("error getting pending payable resources: [%v]", err))
+			return expired
+		}
+
+		expiredInBatch := 0
+		for i := range resources {
+			if ctx.Err() != nil {
+				return expired + expiredInBatch
+			}
+			if s.expire(&resources[i], now) {
+				expiredInBatch++
+			}
+		}
+		expired += expiredInBatch
+
+		// resources that have not outlived their lifetime stay pending, so the next batch is read from after the last
+		// resource in this one rather than from the start. One created in the same millisecond as the last is left
+		// for the next sweep.
+		if len(resources) < batchSize || resources[len(resources)-1].Data.CreatedAt == nil {
+			return expired
+		}
+		createdAfter = *resources[len(resources)-1].Data.CreatedAt
+	}
+}
+
+// expire marks the resource as expired if it has outlived the lifetime of its penalty type
+func (s *Sweeper) expire(resource *models.PayableResourceDao, now time.Time) bool {
+	lifetime := s.lifetime(resource)
+	if resource.Data.CreatedAt == nil || now.Before(resource.Data.CreatedAt.Add(lifetime)) {
+		return false
+	}
+
+	logContext := log.Data{
+		"customer_code": resource.CustomerCode,
+		"payable_ref":   resource.PayableRef,
+		"created_at":    resource.Data.CreatedAt,
+		"lifetime":      lifetime.String(),
+	}
+
+	expired, err := s.prDao.ExpirePayableResource(resource.CustomerCode, resource.PayableRef, now, now.Add(-lifetime), "")
+	if err != nil {
+		log.Error(fmt.Errorf("error expiring payable resource: [%v]", err), logContext)
+		return false
+	}
+	if !expired {
+		log.Debug("payable resource not expired as it has been paid or has a recent payment session", logContext)
+		return false
+	}
+
+	expiredResources.Add(1)
+	log.Info("payable resource expired", logContext)
+	return true
+}
+
+// lifetime returns the lifetime of the penalty type of the resource
+func (s *Sweeper) lifetime(resource *models.PayableResourceDao) time.Duration {
+	penaltyRefType, err := utils.GetPenaltyRefTypeFromTransaction(transformers.PayableResourceDBToRequest(resource).Transactions)
+	if err != nil {
+		return s.defaultLifetime
+	}
+	if lifetime, ok := s.lifetimes[penaltyRefType]; ok {
+		return lifetime
+	}
+	return s.defaultLifetime
+}
+
+// shortestLifetime returns the shortest lifetime of any resource, so that every resource that may have outlived its
+// lifetime is read
+func (s *Sweeper) shortestLifetime() time.Duration {
+	shortest := s.defaultLifetime
+	for _, lifetime := range s.lifetimes {
+		if lifetime < shortest {
+			shortest = lifetime
+		}
+	}
+	return shortest
+}
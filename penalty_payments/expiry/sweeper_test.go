@@ -0,0 +1,163 @@
+package expiry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/companieshouse/penalty-payment-api/testutils"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var now = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestSweeper(prDao *mocks.MockPayableResourceDaoService) *Sweeper {
+	return &Sweeper{
+		prDao: prDao,
+		lifetimes: map[string]time.Duration{
+			testutils.LateFilingPenaltyRefType: 24 * time.Hour,
+			testutils.SanctionsPenaltyRefType:  72 * time.Hour,
+		},
+		defaultLifetime: 48 * time.Hour,
+		interval:        time.Minute,
+		now:             func() time.Time { return now },
+	}
+}
+
+func pendingResource(payableRef, penaltyRef string, age time.Duration) models.PayableResourceDao {
+	createdAt := now.Add(-age)
+	return models.PayableResourceDao{
+		CustomerCode: "12345678",
+		PayableRef:   payableRef,
+		Data: models.PayableResourceDataDao{
+			CreatedAt:    &createdAt,
+			Transactions: map[string]models.TransactionDao{penaltyRef: {Amount: 150}},
+		},
+	}
+}
+
+func TestUnitExpireStale(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given payable resources have been pending for longer than the shortest lifetime", t, func() {
+		mockPrDao := mocks.NewMockPayableResourceDaoService(mockCtrl)
+		s := newTestSweeper(mockPrDao)
+
+		createdBefore := now.Add(-24 * time.Hour)
+
+		Convey("When a resource has outlived the lifetime of its penalty type then it is expired", func() {
+			mockPrDao.EXPECT().GetExpirablePayableResources(time.Time{}, createdBefore, batchSize, "").Return([]models.PayableResourceDao{
+				pendingResource("XP1", "A1234567", 25*time.Hour),
+				pendingResource("XP2", "P1234567", 25*time.Hour),
+				pendingResource("XP3", "P1234568", 73*time.Hour),
+			}, nil)
+			mockPrDao.EXPECT().ExpirePayableResource("12345678", "XP1", now, now.Add(-24*time.Hour), "").Return(true, nil)
+			mockPrDao.EXPECT().ExpirePayableResource("12345678", "XP3", now, now.Add(-72*time.Hour), "").Return(true, nil)
+
+			So(s.ExpireStale(context.Background()), ShouldEqual, 2)
+		})
+
+		Convey("When a resource has an unknown penalty type then the default lifetime is used", func() {
+			mockPrDao.EXPECT().GetExpirablePayableResources(time.Time{}, createdBefore, batchSize, "").Return([]models.PayableResourceDao{
+				pendingResource("XP1", "U1234567", 47*time.Hour),
+				pendingResource("XP2", "Z1234567", 49*time.Hour),
+			}, nil)
+			mockPrDao.EXPECT().ExpirePayableResource("12345678", "XP2", now, now.Add(-48*time.Hour), "").Return(true, nil)
+
+			So(s.ExpireStale(context.Background()), ShouldEqual, 1)
+		})
+
+		Convey("When a resource has been paid or has a recent payment session then it is not counted", func() {
+			mockPrDao.EXPECT().GetExpirablePayableResources(time.Time{}, createdBefore, batchSize, "").Return([]models.PayableResourceDao{
+				pendingResource("XP1", "A1234567", 25*time.Hour),
+			}, nil)
+			mockPrDao.EXPECT().ExpirePayableResource("12345678", "XP1", now, now.Add(-24*time.Hour), "").Return(false, nil)
+
+			So(s.ExpireStale(context.Background()), ShouldEqual, 0)
+		})
+
+		Convey("When a resource cannot be expired then the others still are", func() {
+			mockPrDao.EXPECT().GetExpirablePayableResources(time.Time{}, createdBefore, batchSize, "").Return([]models.PayableResourceDao{
+				pendingResource("XP1", "A1234567", 25*time.Hour),
+				pendingResource("XP2", "A1234568", 25*time.Hour),
+			}, nil)
+			mockPrDao.EXPECT().ExpirePayableResource("12345678", "XP1", now, now.Add(-24*time.Hour), "").Return(false, errors.New("server selection timeout"))
+			mockPrDao.EXPECT().ExpirePayableResource("12345678", "XP2", now, now.Add(-24*time.Hour), "").Return(true, nil)
+
+			So(s.ExpireStale(context.Background()), ShouldEqual, 1)
+		})
+
+		Convey("When a full batch is expired then the next batch is read", func() {
+			batch := make([]models.PayableResourceDao, batchSize)
+			for i := range batch {
+				batch[i] = pendingResource("XP1", "A1234567", 25*time.Hour)
+			}
+			gomock.InOrder(
+				mockPrDao.EXPECT().GetExpirablePayableResources(time.Time{}, createdBefore, batchSize, "").Return(batch, nil),
+				mockPrDao.EXPECT().GetExpirablePayableResources(*batch[batchSize-1].Data.CreatedAt, createdBefore, batchSize, "").
+					Return([]models.PayableResourceDao{}, nil),
+			)
+			mockPrDao.EXPECT().ExpirePayableResource("12345678", "XP1", now, now.Add(-24*time.Hour), "").Return(true, nil).Times(batchSize)
+
+			So(s.ExpireStale(context.Background()), ShouldEqual, batchSize)
+		})
+
+		Convey("When a full batch has not outlived its lifetime then the resources after it are still expired", func() {
+			batch := make([]models.PayableResourceDao, batchSize)
+			for i := range batch {
+				batch[i] = pendingResource("XP1", "P1234567", 49*time.Hour-time.Duration(i)*time.Second)
+			}
+			lastCreatedAt := *batch[batchSize-1].Data.CreatedAt
+			gomock.InOrder(
+				mockPrDao.EXPECT().GetExpirablePayableResources(time.Time{}, createdBefore, batchSize, "").Return(batch, nil),
+				mockPrDao.EXPECT().GetExpirablePayableResources(lastCreatedAt, createdBefore, batchSize, "").
+					Return([]models.PayableResourceDao{pendingResource("XP2", "A1234567", 25*time.Hour)}, nil),
+			)
+			mockPrDao.EXPECT().ExpirePayableResource("12345678", "XP2", now, now.Add(-24*time.Hour), "").Return(true, nil)
+
+			So(s.ExpireStale(context.Background()), ShouldEqual, 1)
+		})
+
+		Convey("When the context is done then no more resources are expired", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			mockPrDao.EXPECT().GetExpirablePayableResources(time.Time{}, createdBefore, batchSize, "").Return([]models.PayableResourceDao{
+				pendingResource("XP1", "A1234567", 25*time.Hour),
+			}, nil)
+
+			So(s.ExpireStale(ctx), ShouldEqual, 0)
+		})
+
+		Convey("When the pending resources cannot be read then nothing is expired", func() {
+			mockPrDao.EXPECT().GetExpirablePayableResources(time.Time{}, createdBefore, batchSize, "").
+				Return(nil, errors.New("server selection timeout"))
+
+			So(s.ExpireStale(context.Background()), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestUnitNewSweeper(t *testing.T) {
+	Convey("The lifetime of each penalty type defaults to the configured lifetime", t, func() {
+		penaltyDetailsMap := &config.PenaltyDetailsMap{Details: map[string]config.PenaltyDetails{
+			testutils.LateFilingPenaltyRefType: {PayableResourceLifetime: "12h"},
+			testutils.SanctionsPenaltyRefType:  {},
+		}}
+
+		s := NewSweeper(nil, penaltyDetailsMap, &config.Config{PayableResourceLifetime: "36h", ExpirySweepInterval: "1m"})
+
+		So(s.lifetimes, ShouldResemble, map[string]time.Duration{
+			testutils.LateFilingPenaltyRefType: 12 * time.Hour,
+			testutils.SanctionsPenaltyRefType:  36 * time.Hour,
+		})
+		So(s.defaultLifetime, ShouldEqual, 36*time.Hour)
+		So(s.interval, ShouldEqual, time.Minute)
+		So(s.shortestLifetime(), ShouldEqual, 12*time.Hour)
+	})
+}
@@ -0,0 +1,306 @@
+// Package recovery marks as paid the payable resources that were paid but are still pending because the payments API
+// never called back to mark them as paid, or because marking them as paid failed when it did.
+package recovery
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api-core/constants"
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api-core/validators"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/service"
+	"github.com/companieshouse/penalty-payment-api/penalty_payments/transformers"
+)
+
+// batchSize is how many pending payable resources are read at a time, oldest first
+const batchSize = 500
+
+// recoveredResources counts the payable resources the recovery job has marked as paid
+var recoveredResources = expvar.NewInt("recovery_payable_resources_recovered")
+
+// Result is what the recovery job found, or did, for a payable resource
+type Result string
+
+const (
+	// Recovered resources had a paid payment session and have been marked as paid
+	Recovered Result = "recovered"
+	// WouldRecover resources have a paid payment session and would have been marked as paid if it were not a dry run
+	WouldRecover Result = "would-recover"
+	// NotPaid resources have payment sessions but none of them has been paid
+	NotPaid Result = "not-paid"
+	// NoPaymentSession resources have no payment session recorded or given, so cannot be checked
+	NoPaymentSession Result = "no-payment-session"
+	// Failed resources could not be checked or marked as paid, and are checked again on the next run
+	Failed Result = "failed"
+)
+
+// ResourceReport is what the recovery job found, or did, for a payable resource
+type ResourceReport struct {
+	CustomerCode  string     `json:"customer_code"`
+	PayableRef    string     `json:"payable_ref"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+	PaymentID     string     `json:"payment_id,omitempty"`
+	PaymentStatus string     `json:"payment_status,omitempty"`
+	Result        Result     `json:"result"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// Report is what a run of the recovery job found, or did, for each payable resource that was still pending
+type Report struct {
+	DryRun        bool             `json:"dry_run"`
+	CreatedBefore time.Time        `json:"created_before"`
+	Checked       int              `json:"checked"`
+	Recovered     int              `json:"recovered"`
+	Resources     []ResourceReport `json:"resources"`
+}
+
+// Options change how a run of the recovery job behaves
+type Options struct {
+	// DryRun reports the resources that would be marked as paid without marking them
+	DryRun bool
+	// MinAge is how long a resource must have been pending for, defaulting to the job's minimum age
+	MinAge time.Duration
+	// PaymentSessions are the IDs of payment sessions to check by payable ref, in addition to those recorded on the
+	// resources, for payments the service never heard about
+	PaymentSessions map[string][]string
+}
+
+// MarkAsPaid marks the resource as paid with a paid payment, recording it, emailing about it and marking it as paid in
+// the finance system exactly as if the payments API had called back with it
+type MarkAsPaid func(req *http.Request, resource *models.PayableResource, payment *validators.PaymentInformation) error
+
+// Job finds the payable resources that have been pending for longer than a minimum age, looks up their payment
+// sessions in the payments API and marks those that were paid as paid.
+type Job struct {
+	prDao                 dao.PayableResourceDaoService
+	markAsPaid            MarkAsPaid
+	getPaymentInformation func(id string, req *http.Request) (*validators.PaymentInformation, error)
+	minAge                time.Duration
+	now                   func() time.Time
+}
+
+// NewJob will construct a recovery job that marks resources as paid with markAsPaid
+func NewJob(prDao dao.PayableResourceDaoService, markAsPaid MarkAsPaid, minAge time.Duration) *Job {
+	return &Job{
+		prDao:                 prDao,
+		markAsPaid:            markAsPaid,
+		getPaymentInformation: service.GetPaymentInformation,
+		minAge:                minAge,
+		now:                   time.Now,
+	}
+}
+
+// Schedule runs the job straight away and then every interval until the context is done. The payments API is called
+// with the API key, which must have elevated privileges.
+func (j *Job) Schedule(ctx context.Context, interval time.Duration, apiKey string) {
+	log.Info("recovery job scheduled", log.Data{"interval": interval.String(), "min_age": j.minAge.String()})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		j.runScheduled(ctx, apiKey)
+
+		select {
+		case <-ctx.Done():
+			log.Info("recovery job stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runScheduled runs the job with the API key. The SDK takes the credentials it calls the payments API with from a
+// request, so the request only carries the API key and is never served by a handler.
+func (j *Job) runScheduled(ctx context.Context, apiKey string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		log.Error(fmt.Errorf("error creating scheduled recovery request: [%v]", err))
+		return
+	}
+	req.Header.Set("Authorization", apiKey)
+
+	report, err := j.Run(req, Options{})
+	if err != nil {
+		log.Error(fmt.Errorf("error running scheduled recovery job: [%v]", err))
+		return
+	}
+
+	failures, unchecked := 0, 0
+	for _, resource := range report.Resources {
+		switch resource.Result {
+		case Failed:
+			failures++
+		case NoPaymentSession:
+			unchecked++
+		}
+	}
+	if failures > 0 {
+		log.Error(fmt.Errorf("scheduled recovery job could not check or mark as paid [%d] payable resources", failures),
+			log.Data{"checked": report.Checked, "recovered": report.Recovered})
+	}
+	// the payments API only gives a payment session when it calls back, so these are the resources whose callback may
+	// have been missed. They are not expired, and stay pending until they are recovered with their payment sessions.
+	if unchecked > 0 {
+		log.Error(fmt.Errorf("scheduled recovery job could not check [%d] payable resources as no payment session is known for them", unchecked),
+			log.Data{"checked": report.Checked, "recovered": report.Recovered})
+	}
+}
+
+// Run checks the pending payable resources and returns a report of what it found. The payments API is called with the
+// credentials of the request, which must have elevated privileges.
+func (j *Job) Run(req *http.Request, opts Options) (*Report, error) {
+	requestId := log.Context(req)
+
+	minAge := opts.MinAge
+	if minAge <= 0 {
+		minAge = j.minAge
+	}
+	createdBefore := j.now().Add(-minAge).UTC()
+
+	log.InfoC(requestId, "recovery job started", log.Data{"created_before": createdBefore, "dry_run": opts.DryRun})
+
+	report := &Report{
+		DryRun:        opts.DryRun,
+		CreatedBefore: createdBefore,
+		Resources:     []ResourceReport{},
+	}
+
+	var createdAfter time.Time
+	for {
+		resources, err := j.prDao.GetStalePendingPayableResources(createdAfter, createdBefore, batchSize, requestId)
+		if err != nil {
+			if report.Checked > 0 {
+				// the resources already checked are reported, and the rest are checked on the next run
+				log.ErrorC(requestId, fmt.Errorf("error getting pending payable resources: [%v]", err))
+				break
+			}
+			return nil, fmt.Errorf("error getting pending payable resources: [%v]", err)
+		}
+
+		for i := range resources {
+			// the rest are checked on the next run
+			if req.Context().Err() != nil {
+				break
+			}
+
+			resourceReport := j.recover(req, &resources[i], opts)
+			report.Checked++
+			if resourceReport.Result == Recovered {
+				report.Recovered++
+			}
+			report.Resources = append(report.Resources, resourceReport)
+		}
+
+		// resources that are not paid stay pending, so the next batch is read from after the last resource in this
+		// one rather than from the start. One created in the same millisecond as the last is left for the next run.
+		if req.Context().Err() != nil || len(resources) < batchSize || resources[len(resources)-1].Data.CreatedAt == nil {
+			break
+		}
+		createdAfter = *resources[len(resources)-1].Data.CreatedAt
+	}
+
+	log.InfoC(requestId, "recovery job completed", log.Data{
+		"created_before": createdBefore,
+		"dry_run":        opts.DryRun,
+		"checked":        report.Checked,
+		"recovered":      report.Recovered,
+	})
+	return report, nil
+}
+
+// recover looks for a paid payment session for the resource and marks the resource as paid with it
+func (j *Job) recover(req *http.Request, resource *models.PayableResourceDao, opts Options) ResourceReport {
+	requestId := log.Context(req)
+	report := ResourceReport{
+		CustomerCode: resource.CustomerCode,
+		PayableRef:   resource.PayableRef,
+		CreatedAt:    resource.Data.CreatedAt,
+	}
+	logContext := log.Data{"customer_code": resource.CustomerCode, "payable_ref": resource.PayableRef}
+
+	recorded, err := j.prDao.GetPaymentSessions(resource.CustomerCode, resource.PayableRef, requestId)
+	if err != nil {
+		return failed(requestId, report, fmt.Errorf("error getting payment sessions: [%v]", err), logContext)
+	}
+	paymentIDs := uniquePaymentIDs(recorded, opts.PaymentSessions[resource.PayableRef])
+	if len(paymentIDs) == 0 {
+		report.Result = NoPaymentSession
+		log.InfoC(requestId, "pending payable resource has no payment session to check", logContext)
+		return report
+	}
+
+	payment, err := j.findPaidPayment(req, paymentIDs, &report)
+	if err != nil {
+		return failed(requestId, report, err, logContext)
+	}
+	if payment == nil {
+		report.Result = NotPaid
+		log.InfoC(requestId, "pending payable resource has not been paid", logContext, log.Data{"payment_ids": paymentIDs})
+		return report
+	}
+
+	logContext["payment_id"] = payment.PaymentID
+	if opts.DryRun {
+		report.Result = WouldRecover
+		log.InfoC(requestId, "pending payable resource has been paid, not marked as paid in a dry run", logContext)
+		return report
+	}
+
+	if err = j.markAsPaid(req, transformers.PayableResourceDBToRequest(resource), payment); err != nil {
+		return failed(requestId, report, err, logContext)
+	}
+
+	recoveredResources.Add(1)
+	report.Result = Recovered
+	log.InfoC(requestId, "pending payable resource has been paid and is now marked as paid", logContext)
+	return report
+}
+
+// findPaidPayment returns the first of the payment sessions that has been paid, or nil if none of them has. It only
+// returns an error if none has been paid and one of them could not be looked up, as that one may have been paid.
+func (j *Job) findPaidPayment(req *http.Request, paymentIDs []string, report *ResourceReport) (*validators.PaymentInformation, error) {
+	var lookupErr error
+	for _, paymentID := range paymentIDs {
+		payment, err := j.getPaymentInformation(paymentID, req)
+		if err != nil {
+			lookupErr = fmt.Errorf("error getting payment session [%s]: [%v]", paymentID, err)
+			continue
+		}
+
+		report.PaymentID = paymentID
+		report.PaymentStatus = payment.Status
+		if payment.Status == constants.Paid.String() {
+			return payment, nil
+		}
+	}
+
+	return nil, lookupErr
+}
+
+func failed(requestId string, report ResourceReport, err error, logContext log.Data) ResourceReport {
+	log.ErrorC(requestId, err, logContext)
+	report.Result = Failed
+	report.Error = err.Error()
+	return report
+}
+
+// uniquePaymentIDs returns the recorded payment session IDs followed by the given ones, each only once
+func uniquePaymentIDs(recorded, given []string) []string {
+	seen := map[string]bool{}
+	var paymentIDs []string
+	for _, paymentID := range append(append([]string{}, recorded...), given...) {
+		if paymentID == "" || seen[paymentID] {
+			continue
+		}
+		seen[paymentID] = true
+		paymentIDs = append(paymentIDs, paymentID)
+	}
+	return paymentIDs
+}
@@ -0,0 +1,294 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api-core/models"
+	"github.com/companieshouse/penalty-payment-api-core/validators"
+	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var now = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// markAsPaidStub records the resources and payments the job marks as paid and returns the error
+type markAsPaidStub struct {
+	err      error
+	payments []string
+	payables []*models.PayableResource
+}
+
+func (s *markAsPaidStub) markAsPaid(_ *http.Request, resource *models.PayableResource, payment *validators.PaymentInformation) error {
+	s.payments = append(s.payments, payment.PaymentID)
+	s.payables = append(s.payables, resource)
+	return s.err
+}
+
+func newTestJob(prDao *mocks.MockPayableResourceDaoService, markAsPaid *markAsPaidStub,
+	payments map[string]*validators.PaymentInformation) *Job {
+	return &Job{
+		prDao:      prDao,
+		markAsPaid: markAsPaid.markAsPaid,
+		getPaymentInformation: func(id string, _ *http.Request) (*validators.PaymentInformation, error) {
+			payment, ok := payments[id]
+			if !ok {
+				return nil, errors.New("payment not found")
+			}
+			return payment, nil
+		},
+		minAge: time.Hour,
+		now:    func() time.Time { return now },
+	}
+}
+
+func pendingResource(payableRef string) models.PayableResourceDao {
+	createdAt := now.Add(-2 * time.Hour)
+	return models.PayableResourceDao{
+		CustomerCode: "12345678",
+		PayableRef:   payableRef,
+		Data: models.PayableResourceDataDao{
+			CreatedAt: &createdAt,
+			Links: models.PayableResourceLinksDao{
+				Payment: "/company/12345678/penalties/payable/" + payableRef + "/payment",
+			},
+			Transactions: map[string]models.TransactionDao{"A1234567": {Amount: 150}},
+		},
+	}
+}
+
+func TestUnitRun(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given payable resources have been pending for longer than the minimum age", t, func() {
+		mockPrDao := mocks.NewMockPayableResourceDaoService(mockCtrl)
+		markAsPaid := &markAsPaidStub{}
+		payments := map[string]*validators.PaymentInformation{
+			"P-paid":      {PaymentID: "P-paid", Status: "paid"},
+			"P-failed":    {PaymentID: "P-failed", Status: "failed"},
+			"P-cancelled": {PaymentID: "P-cancelled", Status: "cancelled"},
+		}
+		job := newTestJob(mockPrDao, markAsPaid, payments)
+		req := httptest.NewRequest(http.MethodPost, "/penalty-payment-api/admin/recovery", nil)
+
+		createdBefore := now.Add(-time.Hour)
+
+		Convey("When a resource has a paid payment session then it is marked as paid with it", func() {
+			mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").
+				Return([]models.PayableResourceDao{pendingResource("XP1")}, nil)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP1", "").Return([]string{"P-failed", "P-paid"}, nil)
+
+			report, err := job.Run(req, Options{})
+
+			So(err, ShouldBeNil)
+			So(report.CreatedBefore, ShouldEqual, createdBefore)
+			So(report.Checked, ShouldEqual, 1)
+			So(report.Recovered, ShouldEqual, 1)
+			So(report.Resources, ShouldResemble, []ResourceReport{{
+				CustomerCode:  "12345678",
+				PayableRef:    "XP1",
+				CreatedAt:     pendingResource("XP1").Data.CreatedAt,
+				PaymentID:     "P-paid",
+				PaymentStatus: "paid",
+				Result:        Recovered,
+			}})
+			So(markAsPaid.payments, ShouldResemble, []string{"P-paid"})
+			So(markAsPaid.payables[0].PayableRef, ShouldEqual, "XP1")
+		})
+
+		Convey("When it is a dry run then the paid resource is reported but not marked as paid", func() {
+			mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").
+				Return([]models.PayableResourceDao{pendingResource("XP1")}, nil)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP1", "").Return([]string{"P-paid"}, nil)
+
+			report, err := job.Run(req, Options{DryRun: true})
+
+			So(err, ShouldBeNil)
+			So(report.DryRun, ShouldBeTrue)
+			So(report.Recovered, ShouldEqual, 0)
+			So(report.Resources[0].Result, ShouldEqual, WouldRecover)
+			So(markAsPaid.payments, ShouldBeEmpty)
+		})
+
+		Convey("When the minimum age is given then it is used instead of the default", func() {
+			mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, now.Add(-24*time.Hour), batchSize, "").
+				Return([]models.PayableResourceDao{}, nil)
+
+			report, err := job.Run(req, Options{MinAge: 24 * time.Hour})
+
+			So(err, ShouldBeNil)
+			So(report.Checked, ShouldEqual, 0)
+			So(report.Resources, ShouldBeEmpty)
+		})
+
+		Convey("When a payment session is given for a resource then it is checked too", func() {
+			mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").
+				Return([]models.PayableResourceDao{pendingResource("XP1")}, nil)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP1", "").Return(nil, nil)
+
+			report, err := job.Run(req, Options{PaymentSessions: map[string][]string{"XP1": {"P-paid"}}})
+
+			So(err, ShouldBeNil)
+			So(report.Resources[0].Result, ShouldEqual, Recovered)
+			So(markAsPaid.payments, ShouldResemble, []string{"P-paid"})
+		})
+
+		Convey("When resources have no paid payment session then they are reported and left pending", func() {
+			mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").
+				Return([]models.PayableResourceDao{pendingResource("XP1"), pendingResource("XP2")}, nil)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP1", "").Return([]string{"P-cancelled"}, nil)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP2", "").Return(nil, nil)
+
+			report, err := job.Run(req, Options{})
+
+			So(err, ShouldBeNil)
+			So(report.Checked, ShouldEqual, 2)
+			So(report.Recovered, ShouldEqual, 0)
+			So(report.Resources[0].Result, ShouldEqual, NotPaid)
+			So(report.Resources[0].PaymentStatus, ShouldEqual, "cancelled")
+			So(report.Resources[1].Result, ShouldEqual, NoPaymentSession)
+			So(markAsPaid.payments, ShouldBeEmpty)
+		})
+
+		Convey("When a payment session cannot be looked up then the resource is reported as failed", func() {
+			mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").
+				Return([]models.PayableResourceDao{pendingResource("XP1")}, nil)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP1", "").Return([]string{"P-unknown"}, nil)
+
+			report, err := job.Run(req, Options{})
+
+			So(err, ShouldBeNil)
+			So(report.Resources[0].Result, ShouldEqual, Failed)
+			So(report.Resources[0].Error, ShouldContainSubstring, "P-unknown")
+		})
+
+		Convey("When marking the resource as paid fails then the resource is reported as failed", func() {
+			markAsPaid.err = errors.New("the payable resource could not be fully marked as paid")
+			mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").
+				Return([]models.PayableResourceDao{pendingResource("XP1")}, nil)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP1", "").Return([]string{"P-paid"}, nil)
+
+			report, err := job.Run(req, Options{})
+
+			So(err, ShouldBeNil)
+			So(report.Recovered, ShouldEqual, 0)
+			So(report.Resources[0].Result, ShouldEqual, Failed)
+			So(report.Resources[0].Error, ShouldContainSubstring, "could not be fully marked as paid")
+		})
+
+		Convey("When the payment sessions cannot be read then the resource is reported as failed", func() {
+			mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").
+				Return([]models.PayableResourceDao{pendingResource("XP1")}, nil)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP1", "").Return(nil, errors.New("server selection timeout"))
+
+			report, err := job.Run(req, Options{})
+
+			So(err, ShouldBeNil)
+			So(report.Resources[0].Result, ShouldEqual, Failed)
+		})
+
+		Convey("When a full batch is left pending then the resources after it are still checked", func() {
+			batch := make([]models.PayableResourceDao, batchSize)
+			for i := range batch {
+				batch[i] = pendingResource("XP1")
+				createdAt := now.Add(-3*time.Hour + time.Duration(i)*time.Second)
+				batch[i].Data.CreatedAt = &createdAt
+			}
+			lastCreatedAt := *batch[batchSize-1].Data.CreatedAt
+			gomock.InOrder(
+				mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").Return(batch, nil),
+				mockPrDao.EXPECT().GetStalePendingPayableResources(lastCreatedAt, createdBefore, batchSize, "").
+					Return([]models.PayableResourceDao{pendingResource("XP2")}, nil),
+			)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP1", "").Return(nil, nil).Times(batchSize)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP2", "").Return([]string{"P-paid"}, nil)
+
+			report, err := job.Run(req, Options{})
+
+			So(err, ShouldBeNil)
+			So(report.Checked, ShouldEqual, batchSize+1)
+			So(report.Recovered, ShouldEqual, 1)
+			So(markAsPaid.payables[0].PayableRef, ShouldEqual, "XP2")
+		})
+
+		Convey("When a later batch cannot be read then the resources already checked are reported", func() {
+			batch := make([]models.PayableResourceDao, batchSize)
+			for i := range batch {
+				batch[i] = pendingResource("XP1")
+			}
+			gomock.InOrder(
+				mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").Return(batch, nil),
+				mockPrDao.EXPECT().GetStalePendingPayableResources(*batch[batchSize-1].Data.CreatedAt, createdBefore, batchSize, "").
+					Return(nil, errors.New("server selection timeout")),
+			)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP1", "").Return(nil, nil).Times(batchSize)
+
+			report, err := job.Run(req, Options{})
+
+			So(err, ShouldBeNil)
+			So(report.Checked, ShouldEqual, batchSize)
+		})
+
+		Convey("When the pending resources cannot be read then an error is returned", func() {
+			mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").
+				Return(nil, errors.New("server selection timeout"))
+
+			report, err := job.Run(req, Options{})
+
+			So(report, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUnitSchedule(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given the recovery job is scheduled", t, func() {
+		mockPrDao := mocks.NewMockPayableResourceDaoService(mockCtrl)
+		markAsPaid := &markAsPaidStub{}
+		job := newTestJob(mockPrDao, markAsPaid, nil)
+		createdBefore := now.Add(-time.Hour)
+
+		Convey("When it runs then the payments API is called with the API key", func() {
+			var paymentReq *http.Request
+			job.getPaymentInformation = func(id string, req *http.Request) (*validators.PaymentInformation, error) {
+				paymentReq = req
+				return &validators.PaymentInformation{PaymentID: id, Status: "paid"}, nil
+			}
+			mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").
+				Return([]models.PayableResourceDao{pendingResource("XP1")}, nil)
+			mockPrDao.EXPECT().GetPaymentSessions("12345678", "XP1", "").Return([]string{"P-paid"}, nil)
+
+			job.runScheduled(context.Background(), "api-key")
+
+			So(paymentReq, ShouldNotBeNil)
+			So(paymentReq.Header.Get("Authorization"), ShouldEqual, "api-key")
+			So(paymentReq.Header.Get("ERIC-Identity"), ShouldBeEmpty)
+			So(markAsPaid.payments, ShouldResemble, []string{"P-paid"})
+		})
+
+		Convey("When the context is done then it stops after the run it started with", func() {
+			mockPrDao.EXPECT().GetStalePendingPayableResources(time.Time{}, createdBefore, batchSize, "").
+				Return(nil, nil)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			job.Schedule(ctx, time.Minute, "api-key")
+		})
+	})
+}
+
+func TestUnitUniquePaymentIDs(t *testing.T) {
+	Convey("Payment session IDs are checked once each, recorded ones first", t, func() {
+		So(uniquePaymentIDs([]string{"P1", "P2"}, []string{"P2", "", "P3"}), ShouldResemble, []string{"P1", "P2", "P3"})
+		So(uniquePaymentIDs(nil, nil), ShouldBeEmpty)
+	})
+}
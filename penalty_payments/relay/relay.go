@@ -0,0 +1,182 @@
+// Package relay publishes the Kafka messages waiting in the outbox, so that a payment recorded while Kafka is
+// unavailable still results in the confirmation email and the penalty being marked as paid in E5.
+package relay
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/companieshouse/chs.go/kafka/producer"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/kafka"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
+	"github.com/companieshouse/penalty-payment-api/config"
+)
+
+const (
+	// batchSize is how many messages are read from the outbox at a time
+	batchSize = 100
+	// claimLease is how long a message is kept from other instances of the service while it is being published. If the
+	// instance publishing it stops before marking it as published, another publishes it once the lease has passed.
+	claimLease = time.Minute
+	// minRetryDelay and maxRetryDelay bound the delay before a message that failed to publish is tried again, which
+	// doubles with each failed attempt
+	minRetryDelay = 5 * time.Second
+	maxRetryDelay = 5 * time.Minute
+)
+
+var (
+	publishedMessages = expvar.NewInt("outbox_messages_published")
+	publishFailures   = expvar.NewInt("outbox_publish_failures")
+)
+
+// Relay publishes the pending messages in the outbox to Kafka, retrying those that fail until they are published.
+// Messages are published at least once, so a message can be published again if the service stops after Kafka
+// acknowledged it but before it was marked as published.
+type Relay struct {
+	outboxDao     dao.OutboxDaoService
+	brokerAddrs   map[outbox.Kind][]string
+	getProducer   func(brokerAddrs []string) (*producer.Producer, error)
+	reportFailure func(brokerAddrs []string, err error)
+	interval      time.Duration
+	now           func() time.Time
+}
+
+// NewRelay will construct a relay that publishes emails to the Kafka brokers and penalty payments processing messages
+// to the Kafka3 brokers, using the shared producers
+func NewRelay(outboxDao dao.OutboxDaoService, producers *kafka.ProducerManager, cfg *config.Config) *Relay {
+	return &Relay{
+		outboxDao: outboxDao,
+		brokerAddrs: map[outbox.Kind][]string{
+			outbox.EmailSend:                 cfg.BrokerAddr,
+			outbox.PenaltyPaymentsProcessing: cfg.Kafka3BrokerAddr,
+		},
+		getProducer:   producers.Producer,
+		reportFailure: producers.ReportFailure,
+		interval:      cfg.GetOutboxRelayInterval(),
+		now:           time.Now,
+	}
+}
+
+// Run publishes the pending messages straight away and then every interval until the context is done. A message that
+// is being published when the context is done is finished first.
+func (r *Relay) Run(ctx context.Context) {
+	log.Info("outbox relay started", log.Data{"interval": r.interval.String()})
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.RelayPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Info("outbox relay stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayPending publishes the messages in the outbox that are due and returns how many were published
+func (r *Relay) RelayPending(ctx context.Context) int {
+	published := 0
+	for {
+		messages, err := r.outboxDao.GetPendingOutboxMessages(r.now(), batchSize, "")
+		if err != nil {
+			log.Error(fmt.Errorf("error getting pending outbox messages: [%v]", err))
+			return published
+		}
+
+		for i := range messages {
+			if ctx.Err() != nil {
+				return published
+			}
+			if r.publish(&messages[i]) {
+				published++
+			}
+		}
+
+		// messages that failed are not due again until after their retry delay, so a full batch means there may be more
+		if len(messages) < batchSize {
+			return published
+		}
+	}
+}
+
+// publish claims the message and sends it to its topic, recording when to try again if it cannot be sent
+func (r *Relay) publish(message *outbox.Message) bool {
+	logContext := log.Data{
+		"outbox_id":     message.ID,
+		"kind":          message.Kind,
+		"topic":         message.Topic,
+		"customer_code": message.CustomerCode,
+		"payable_ref":   message.PayableRef,
+		"attempts":      message.Attempts,
+	}
+
+	now := r.now()
+	claimed, err := r.outboxDao.ClaimOutboxMessage(message.ID, now, now.Add(claimLease), "")
+	if err != nil {
+		log.Error(fmt.Errorf("error claiming outbox message: [%v]", err), logContext)
+		return false
+	}
+	if !claimed {
+		log.Debug("outbox message already claimed or published", logContext)
+		return false
+	}
+
+	err = r.send(message)
+	if err != nil {
+		publishFailures.Add(1)
+		nextAttemptAt := now.Add(retryDelay(message.Attempts + 1))
+		log.Error(fmt.Errorf("error publishing outbox message: [%v]", err), logContext, log.Data{"next_attempt_at": nextAttemptAt})
+		if err = r.outboxDao.MarkOutboxMessageFailed(message.ID, err, nextAttemptAt, ""); err != nil {
+			log.Error(fmt.Errorf("error recording failure to publish outbox message: [%v]", err), logContext)
+		}
+		return false
+	}
+
+	publishedMessages.Add(1)
+	log.Info("outbox message published", logContext)
+	if err = r.outboxDao.MarkOutboxMessagePublished(message.ID, ""); err != nil {
+		// the message is published again once its claim lapses
+		log.Error(fmt.Errorf("error marking outbox message as published: [%v]", err), logContext)
+	}
+	return true
+}
+
+func (r *Relay) send(message *outbox.Message) error {
+	brokerAddrs, ok := r.brokerAddrs[message.Kind]
+	if !ok || len(brokerAddrs) == 0 {
+		return fmt.Errorf("no brokers configured for outbox message kind [%s]", message.Kind)
+	}
+
+	kafkaProducer, err := r.getProducer(brokerAddrs)
+	if err != nil {
+		return fmt.Errorf("error getting kafka producer: [%v]", err)
+	}
+
+	_, _, err = kafkaProducer.Send(&producer.Message{Value: message.Value, Topic: message.Topic})
+	if err != nil {
+		r.reportFailure(brokerAddrs, err)
+		return err
+	}
+
+	return nil
+}
+
+// retryDelay returns the delay before a message is tried again after the attempt failed, doubling for each attempt
+func retryDelay(attempt int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,115 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	saramamocks "github.com/Shopify/sarama/mocks"
+	"github.com/companieshouse/chs.go/kafka/producer"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
+	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var now = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestRelay(t *testing.T, outboxDao *mocks.MockOutboxDaoService, syncProducer sarama.SyncProducer) (*Relay, *[]error) {
+	var reported []error
+	return &Relay{
+		outboxDao: outboxDao,
+		brokerAddrs: map[outbox.Kind][]string{
+			outbox.EmailSend:                 {"kafka:9092"},
+			outbox.PenaltyPaymentsProcessing: {"kafka3:9092"},
+		},
+		getProducer: func(brokerAddrs []string) (*producer.Producer, error) {
+			return &producer.Producer{SyncProducer: syncProducer}, nil
+		},
+		reportFailure: func(brokerAddrs []string, err error) {
+			reported = append(reported, err)
+		},
+		interval: time.Second,
+		now:      func() time.Time { return now },
+	}, &reported
+}
+
+func TestUnitRelayPending(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	Convey("Given messages are waiting in the outbox", t, func() {
+		mockOutboxDao := mocks.NewMockOutboxDaoService(mockCtrl)
+		syncProducer := saramamocks.NewSyncProducer(t, nil)
+		r, reported := newTestRelay(t, mockOutboxDao, syncProducer)
+
+		email := *outbox.NewMessage(outbox.EmailSend, "12345678", "XP123", "email-send", []byte{0x01})
+		processing := *outbox.NewMessage(outbox.PenaltyPaymentsProcessing, "12345678", "XP123", "penalty-payments-processing", []byte{0x02})
+
+		Convey("When Kafka is available then each message is published and marked as published", func() {
+			mockOutboxDao.EXPECT().GetPendingOutboxMessages(now, batchSize, "").Return([]outbox.Message{email, processing}, nil)
+			mockOutboxDao.EXPECT().ClaimOutboxMessage(email.ID, now, now.Add(claimLease), "").Return(true, nil)
+			mockOutboxDao.EXPECT().ClaimOutboxMessage(processing.ID, now, now.Add(claimLease), "").Return(true, nil)
+			syncProducer.ExpectSendMessageAndSucceed()
+			syncProducer.ExpectSendMessageAndSucceed()
+			mockOutboxDao.EXPECT().MarkOutboxMessagePublished(email.ID, "").Return(nil)
+			mockOutboxDao.EXPECT().MarkOutboxMessagePublished(processing.ID, "").Return(nil)
+
+			So(r.RelayPending(context.Background()), ShouldEqual, 2)
+		})
+
+		Convey("When a message has been claimed by another instance then it is not published", func() {
+			mockOutboxDao.EXPECT().GetPendingOutboxMessages(now, batchSize, "").Return([]outbox.Message{email}, nil)
+			mockOutboxDao.EXPECT().ClaimOutboxMessage(email.ID, now, now.Add(claimLease), "").Return(false, nil)
+
+			So(r.RelayPending(context.Background()), ShouldEqual, 0)
+		})
+
+		Convey("When Kafka is unavailable then the message is retried later and the producer failure is reported", func() {
+			email.Attempts = 2
+			mockOutboxDao.EXPECT().GetPendingOutboxMessages(now, batchSize, "").Return([]outbox.Message{email}, nil)
+			mockOutboxDao.EXPECT().ClaimOutboxMessage(email.ID, now, now.Add(claimLease), "").Return(true, nil)
+			syncProducer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+			mockOutboxDao.EXPECT().MarkOutboxMessageFailed(email.ID, sarama.ErrOutOfBrokers, now.Add(20*time.Second), "").Return(nil)
+
+			So(r.RelayPending(context.Background()), ShouldEqual, 0)
+			So(*reported, ShouldResemble, []error{sarama.ErrOutOfBrokers})
+		})
+
+		Convey("When the producer cannot connect then the message is retried later", func() {
+			r.getProducer = func(brokerAddrs []string) (*producer.Producer, error) {
+				return nil, sarama.ErrOutOfBrokers
+			}
+			mockOutboxDao.EXPECT().GetPendingOutboxMessages(now, batchSize, "").Return([]outbox.Message{processing}, nil)
+			mockOutboxDao.EXPECT().ClaimOutboxMessage(processing.ID, now, now.Add(claimLease), "").Return(true, nil)
+			mockOutboxDao.EXPECT().MarkOutboxMessageFailed(processing.ID, gomock.Any(), now.Add(minRetryDelay), "").Return(nil)
+
+			So(r.RelayPending(context.Background()), ShouldEqual, 0)
+		})
+
+		Convey("When the outbox cannot be read then nothing is published", func() {
+			mockOutboxDao.EXPECT().GetPendingOutboxMessages(now, batchSize, "").Return(nil, errors.New("server selection timeout"))
+
+			So(r.RelayPending(context.Background()), ShouldEqual, 0)
+		})
+
+		Convey("When the context is done then the remaining messages are left for later", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			mockOutboxDao.EXPECT().GetPendingOutboxMessages(now, batchSize, "").Return([]outbox.Message{email}, nil)
+
+			So(r.RelayPending(ctx), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestUnitRetryDelay(t *testing.T) {
+	Convey("The retry delay doubles with each attempt up to the maximum", t, func() {
+		So(retryDelay(1), ShouldEqual, minRetryDelay)
+		So(retryDelay(2), ShouldEqual, 2*minRetryDelay)
+		So(retryDelay(3), ShouldEqual, 4*minRetryDelay)
+		So(retryDelay(100), ShouldEqual, maxRetryDelay)
+	})
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/deadletter"
+	"github.com/companieshouse/penalty-payment-api/common/services"
+)
+
+// ReplayDeadLetter publishes a dead-lettered message to the penalty payments processing topic again with its
+// original bytes and headers, and marks it as replayed. A message that still cannot be processed is dead-lettered
+// again under the offset it was replayed at.
+func ReplayDeadLetter(dlDaoSvc dao.DeadLetterDaoService, id, requestId string) (*deadletter.Message, services.ResponseType, error) {
+	cfg, err := getConfig()
+	if err != nil {
+		return nil, services.Error, fmt.Errorf("error getting config for dead-letter replay: [%v]", err)
+	}
+
+	message, err := dlDaoSvc.GetDeadLetter(id, requestId)
+	if err != nil {
+		return nil, services.Error, fmt.Errorf("error getting dead-lettered message from db: [%v]", err)
+	}
+	if message == nil {
+		return nil, services.NotFound, nil
+	}
+
+	topic := cfg.PenaltyPaymentsProcessingTopic
+	logContext := log.Data{
+		"dead_letter_id": id,
+		"reason":         message.Reason,
+		"topic":          topic,
+		"replay_count":   message.ReplayCount,
+	}
+
+	log.InfoC(requestId, "getting dead-letter replay kafka producer", logContext)
+	kafkaProducer, err := getProducer(cfg.Kafka3BrokerAddr)
+	if err != nil {
+		return nil, services.Error, fmt.Errorf("error creating dead-letter replay kafka producer: [%v]", err)
+	}
+
+	partition, offset, err := kafkaProducer.SyncProducer.SendMessage(message.ReplayProducerMessage(topic))
+	if err != nil {
+		reportProducerError(cfg.Kafka3BrokerAddr, err)
+		err = fmt.Errorf("failed to replay dead-lettered message: [%v]", err)
+		log.ErrorC(requestId, err, logContext)
+		return nil, services.Error, err
+	}
+	log.InfoC(requestId, "successfully replayed dead-lettered message", logContext, log.Data{
+		"kafka_partition": partition,
+		"kafka_offset":    offset,
+	})
+
+	// the message has been published, so a failure to record it is only logged rather than encouraging a second replay
+	if err = dlDaoSvc.MarkDeadLetterReplayed(id, requestId); err != nil {
+		log.ErrorC(requestId, fmt.Errorf("error marking dead-lettered message as replayed: [%v]", err), logContext)
+	}
+
+	return message, services.Success, nil
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	saramamocks "github.com/Shopify/sarama/mocks"
+	"github.com/companieshouse/chs.go/kafka/producer"
+	"github.com/companieshouse/penalty-payment-api/common/deadletter"
+	"github.com/companieshouse/penalty-payment-api/common/services"
+	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUnitReplayDeadLetter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	originalGetConfig, originalGetProducer := getConfig, getProducer
+	defer func() {
+		getConfig, getProducer = originalGetConfig, originalGetProducer
+	}()
+
+	const id = "penalty-payments-processing-0-42"
+	message := &deadletter.Message{ID: id, Value: []byte{0x01}, Reason: deadletter.Undecodable}
+
+	Convey("Given a dead-lettered message is replayed", t, func() {
+		getConfig = func() (*config.Config, error) {
+			return &config.Config{PenaltyPaymentsProcessingTopic: "penalty-payments-processing"}, nil
+		}
+		syncProducer := saramamocks.NewSyncProducer(t, nil)
+		getProducer = func(brokerAddrs []string) (*producer.Producer, error) {
+			return &producer.Producer{SyncProducer: syncProducer}, nil
+		}
+		mockDlDaoSvc := mocks.NewMockDeadLetterDaoService(ctrl)
+
+		Convey("When it is published then it is marked as replayed", func() {
+			mockDlDaoSvc.EXPECT().GetDeadLetter(id, "").Return(message, nil)
+			syncProducer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(val []byte) error {
+				if string(val) != string(message.Value) {
+					return errors.New("replayed message does not have the original bytes")
+				}
+				return nil
+			})
+			mockDlDaoSvc.EXPECT().MarkDeadLetterReplayed(id, "").Return(nil)
+
+			replayed, responseType, err := ReplayDeadLetter(mockDlDaoSvc, id, "")
+
+			So(err, ShouldBeNil)
+			So(responseType, ShouldEqual, services.Success)
+			So(replayed.ID, ShouldEqual, id)
+		})
+
+		Convey("When it cannot be found then not found is returned", func() {
+			mockDlDaoSvc.EXPECT().GetDeadLetter(id, "").Return(nil, nil)
+
+			_, responseType, err := ReplayDeadLetter(mockDlDaoSvc, id, "")
+
+			So(err, ShouldBeNil)
+			So(responseType, ShouldEqual, services.NotFound)
+		})
+
+		Convey("When it cannot be published then it is not marked as replayed", func() {
+			mockDlDaoSvc.EXPECT().GetDeadLetter(id, "").Return(message, nil)
+			syncProducer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+
+			_, responseType, err := ReplayDeadLetter(mockDlDaoSvc, id, "")
+
+			So(err, ShouldNotBeNil)
+			So(responseType, ShouldEqual, services.Error)
+		})
+
+		Convey("When it cannot be read then an error is returned", func() {
+			mockDlDaoSvc.EXPECT().GetDeadLetter(id, "").Return(nil, errors.New("server selection timeout"))
+
+			_, responseType, err := ReplayDeadLetter(mockDlDaoSvc, id, "")
+
+			So(err, ShouldNotBeNil)
+			So(responseType, ShouldEqual, services.Error)
+		})
+	})
+}
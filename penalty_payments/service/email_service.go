@@ -13,42 +13,52 @@ import (
 	"github.com/companieshouse/filing-notification-sender/util"
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api/common/dao"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 )
 
-// SendEmailKafkaMessage sends a kafka message to the email-sender to send an email
-func SendEmailKafkaMessage(payableResource models.PayableResource, req *http.Request, penaltyDetailsMap *config.PenaltyDetailsMap,
-	allowedTransactionsMap *models.AllowedTransactionMap, apDaoSvc dao.AccountPenaltiesDaoService) error {
+// emailPenalty describes one of the penalties paid in the confirmation email
+type emailPenalty struct {
+	PenaltyRef        string `json:"penalty_ref"`
+	MadeUpDate        string `json:"made_up_date"`
+	Amount            string `json:"amount"`
+	FilingDescription string `json:"filing_description"`
+}
+
+// emailData is the data of the confirmation email. The fields of models.DataField describe the first penalty, as they
+// did when only one penalty could be paid at a time, except the amount which is the total paid. Every penalty paid is
+// listed in penalties.
+type emailData struct {
+	models.DataField
+	Penalties []emailPenalty `json:"penalties"`
+}
+
+// EmailOutboxMessage prepares the kafka message that asks the email-sender to send the payment confirmation email,
+// ready to be put in the outbox with the payment
+func EmailOutboxMessage(payableResource models.PayableResource, req *http.Request, penaltyDetailsMap *config.PenaltyDetailsMap,
+	allowedTransactionsMap *models.AllowedTransactionMap, apDaoSvc dao.AccountPenaltiesDaoService,
+	payableStatusProvider types.PayableStatusProvider) (*outbox.Message, error) {
 	cfg, err := getConfig()
 	requestId := log.Context(req)
 	if err != nil {
 		err = fmt.Errorf("error getting config for kafka message production: [%v]", err)
-		return err
+		return nil, err
 	}
 
-	brokerAddrs := cfg.BrokerAddr
 	topic := cfg.EmailSendTopic
 
 	logContext := log.Data{
 		"customer_code": payableResource.CustomerCode,
 		"payable_ref":   payableResource.PayableRef,
-		"broker_addrs":  brokerAddrs,
 		"topic":         topic,
 	}
 
-	log.InfoC(requestId, "getting email send kafka producer", logContext)
-	kafkaProducer, err := getProducer(brokerAddrs)
-	if err != nil {
-		err = fmt.Errorf("error creating email send kafka producer: [%v]", err)
-		return err
-	}
-
 	log.DebugC(requestId, "getting email send avro schema", logContext)
 	emailSendSchema, err := getSchema(cfg.SchemaRegistryURL, topic)
 	if err != nil {
 		err = fmt.Errorf("error getting email send schema from schema registry: [%v]", err)
-		return err
+		return nil, err
 	}
 	producerSchema := &avro.Schema{
 		Definition: emailSendSchema,
@@ -57,37 +67,24 @@ func SendEmailKafkaMessage(payableResource models.PayableResource, req *http.Req
 
 	log.InfoC(requestId, "preparing email send message", logContext)
 	message, err := prepareEmailKafkaMessage(
-		*producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, apDaoSvc, topic)
+		*producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, apDaoSvc, payableStatusProvider, topic)
 	if err != nil {
 		err = fmt.Errorf("error preparing email send kafka message with schema: [%v]", err)
-		return err
+		return nil, err
 	}
 
 	log.DebugC(requestId, "email send message prepared successfully", log.Data{
-		"message.Value":     message.Value,
-		"message.Topic":     message.Topic,
-		"message.Partition": message.Partition,
-		"message.Key":       message.Key,
-	})
-
-	partition, offset, err := kafkaProducer.Send(message)
-	if err != nil {
-		err = fmt.Errorf("failed to send email send message: [%v]", err)
-		log.ErrorC(requestId, err, logContext)
-		return err
-	}
-	log.InfoC(requestId, "successfully published email send message", logContext, log.Data{
-		"kafka_topic":     topic,
-		"kafka_partition": partition,
-		"kafka_offset":    offset,
+		"message.Value": message.Value,
+		"message.Topic": message.Topic,
 	})
 
-	return nil
+	return outbox.NewMessage(outbox.EmailSend, payableResource.CustomerCode, payableResource.PayableRef, topic, message.Value), nil
 }
 
 // prepareEmailKafkaMessage generates the kafka message that is to be sent
 func prepareEmailKafkaMessage(emailSendSchema avro.Schema, payableResource models.PayableResource, req *http.Request, penaltyDetailsMap *config.PenaltyDetailsMap,
-	allowedTransactionsMap *models.AllowedTransactionMap, apDaoSvc dao.AccountPenaltiesDaoService, topic string) (*producer.Message, error) {
+	allowedTransactionsMap *models.AllowedTransactionMap, apDaoSvc dao.AccountPenaltiesDaoService,
+	payableStatusProvider types.PayableStatusProvider, topic string) (*producer.Message, error) {
 	cfg, err := getConfig()
 	if err != nil {
 		err = fmt.Errorf("error getting config: [%v]", err)
@@ -116,41 +113,61 @@ func prepareEmailKafkaMessage(emailSendSchema avro.Schema, payableResource model
 		return nil, err
 	}
 
-	transaction := payableResource.Transactions[0]
-	params := types.PayablePenaltyParams{
-		PenaltyRefType:             penaltyRefType,
-		CustomerCode:               payableResource.CustomerCode,
-		CompanyCode:                companyCode,
-		Transaction:                transaction,
-		PenaltyDetailsMap:          penaltyDetailsMap,
-		AllowedTransactionsMap:     allowedTransactionsMap,
-		AccountPenaltiesDaoService: apDaoSvc,
-		RequestId:                  "",
-	}
-	payablePenalty, err := getPayablePenalty(params)
-	if err != nil {
-		err = fmt.Errorf("error getting transaction for penalty: [%v]", err)
-		return nil, err
-	}
-
-	// Convert madeUpDate to readable format for email
-	madeUpDate, err := time.Parse("2006-01-02", payablePenalty.MadeUpDate)
-	if err != nil {
-		err = fmt.Errorf("error parsing made up date: [%v]", err)
-		return nil, err
-	}
-
-	dataFieldMessage := models.DataField{
-		PayableResource:   payableResource,
-		PenaltyRef:        payableResource.Transactions[0].PenaltyRef,
-		MadeUpDate:        madeUpDate.Format("2 January 2006"),
-		TransactionDate:   time.Now().Format("2 January 2006"),
-		Amount:            fmt.Sprintf("%g", payablePenalty.Amount),
-		CompanyName:       companyName,
-		FilingDescription: payablePenalty.Reason,
-		To:                payableResource.CreatedBy.Email,
-		Subject:           fmt.Sprintf("Confirmation of your Companies House penalty payment"),
-		CHSURL:            cfg.CHSURL,
+	// every penalty and cost is described in the email, with the details from E5. The penalties are listed before
+	// their costs, so the first is a penalty
+	var penalties []emailPenalty
+	var payablePenalties []models.TransactionItem
+	for _, transaction := range payableResource.Transactions {
+		params := types.PayablePenaltyParams{
+			Context:                    req.Context(),
+			PenaltyRefType:             penaltyRefType,
+			CustomerCode:               payableResource.CustomerCode,
+			CompanyCode:                companyCode,
+			Transaction:                transaction,
+			Transactions:               payableResource.Transactions,
+			PenaltyDetailsMap:          penaltyDetailsMap,
+			AllowedTransactionsMap:     allowedTransactionsMap,
+			AccountPenaltiesDaoService: apDaoSvc,
+			PayableStatusProvider:      payableStatusProvider,
+			RequestId:                  "",
+		}
+		payablePenalty, err := getPayablePenalty(params)
+		if err != nil {
+			err = fmt.Errorf("error getting transaction for penalty: [%v]", err)
+			return nil, err
+		}
+
+		// Convert madeUpDate to readable format for email
+		madeUpDate, err := time.Parse("2006-01-02", payablePenalty.MadeUpDate)
+		if err != nil {
+			err = fmt.Errorf("error parsing made up date: [%v]", err)
+			return nil, err
+		}
+
+		penalties = append(penalties, emailPenalty{
+			PenaltyRef:        transaction.PenaltyRef,
+			MadeUpDate:        madeUpDate.Format("2 January 2006"),
+			Amount:            fmt.Sprintf("%g", payablePenalty.Amount),
+			FilingDescription: payablePenalty.Reason,
+		})
+		payablePenalties = append(payablePenalties, *payablePenalty)
+	}
+
+	firstPenalty := penalties[0]
+	dataFieldMessage := emailData{
+		DataField: models.DataField{
+			PayableResource:   payableResource,
+			PenaltyRef:        firstPenalty.PenaltyRef,
+			MadeUpDate:        firstPenalty.MadeUpDate,
+			TransactionDate:   time.Now().Format("2 January 2006"),
+			Amount:            fmt.Sprintf("%g", getTotalAmountFromTransactions(payablePenalties)),
+			CompanyName:       companyName,
+			FilingDescription: firstPenalty.FilingDescription,
+			To:                payableResource.CreatedBy.Email,
+			Subject:           fmt.Sprintf("Confirmation of your Companies House penalty payment"),
+			CHSURL:            cfg.CHSURL,
+		},
+		Penalties: penalties,
 	}
 
 	requestId := log.Context(req)
@@ -7,23 +7,22 @@ import (
 
 	"github.com/companieshouse/chs.go/avro"
 	"github.com/companieshouse/chs.go/avro/schema"
-	"github.com/companieshouse/chs.go/kafka/producer"
 	"github.com/companieshouse/penalty-payment-api-core/models"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 	"github.com/companieshouse/penalty-payment-api/mocks"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 var req = &http.Request{}
 
-func TestUnitSendEmailKafkaMessage(t *testing.T) {
+func TestUnitEmailOutboxMessage(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	Convey("Given the SendEmailKafkaMessage is called", t, func() {
+	Convey("Given the EmailOutboxMessage is called", t, func() {
 		Convey("When config is called with invalid config", func() {
 			errMsg := "config is invalid"
 			mockedConfigGet := func() (*config.Config, error) {
@@ -33,60 +32,54 @@ func TestUnitSendEmailKafkaMessage(t *testing.T) {
 			getConfig = mockedConfigGet
 
 			Convey("Then an error should be returned", func() {
-				err := SendEmailKafkaMessage(payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil)
+				_, err := EmailOutboxMessage(payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, nil)
 
 				So(err, ShouldResemble, errors.New("error getting config for kafka message production: ["+errMsg+"]"))
 			})
 		})
-		Convey("When config is called with valid config and invalid broker address", func() {
+		Convey("When config is called with valid config but invalid schema", func() {
 			mockedConfigGet := func() (*config.Config, error) {
-				return &config.Config{}, nil
+				return &config.Config{
+					EmailSendTopic: "email-send-unknown",
+				}, nil
 			}
 
 			getConfig = mockedConfigGet
 
 			Convey("Then an error should be returned", func() {
-				err := SendEmailKafkaMessage(payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil)
+				_, err := EmailOutboxMessage(payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, nil)
 
-				So(err, ShouldResemble, errors.New("error creating email send kafka producer: [kafka: invalid configuration (You must provide at least one broker address)]"))
+				So(err, ShouldResemble, errors.New("error getting email send schema from schema registry: [Get \"/subjects/email-send-unknown/versions/latest\": unsupported protocol scheme \"\"]"))
 			})
 		})
-		Convey("When config is called with valid config and valid broker config but invalid schema", func() {
+		Convey("When the schema registry is unavailable for a schema embedded in the binary", func() {
 			mockedConfigGet := func() (*config.Config, error) {
 				return &config.Config{
 					EmailSendTopic: "email-send",
 				}, nil
 			}
-			mockedGetProducer := func(brokerAddrs []string) (*producer.Producer, error) {
-				return &producer.Producer{}, nil
-			}
 
 			getConfig = mockedConfigGet
-			getProducer = mockedGetProducer
 
-			Convey("Then an error should be returned", func() {
-				err := SendEmailKafkaMessage(payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil)
+			Convey("Then the embedded schema is used to prepare the message", func() {
+				_, err := EmailOutboxMessage(payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, nil)
 
-				So(err, ShouldResemble, errors.New("error getting email send schema from schema registry: [Get \"/subjects/email-send/versions/latest\": unsupported protocol scheme \"\"]"))
+				So(err.Error(), ShouldStartWith, "error preparing email send kafka message with schema:")
 			})
 		})
-		Convey("When config is called with valid config and valid broker config and valid schema", func() {
+		Convey("When config is called with valid config and valid schema", func() {
 			mockedConfigGet := func() (*config.Config, error) {
 				return &config.Config{}, nil
 			}
-			mockedGetProducer := func(brokerAddrs []string) (*producer.Producer, error) {
-				return &producer.Producer{}, nil
-			}
 			mockedGetSchema := func(url, schemaName string) (string, error) {
 				return "schema", nil
 			}
 
 			getConfig = mockedConfigGet
-			getProducer = mockedGetProducer
 			getSchema = mockedGetSchema
 
 			Convey("Then an error should be returned", func() {
-				err := SendEmailKafkaMessage(payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil)
+				_, err := EmailOutboxMessage(payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, nil)
 
 				So(err.Error(), ShouldStartWith, "error preparing email send kafka message with schema: [error getting company name: [")
 			})
@@ -119,18 +112,18 @@ func TestUnitPrepareEmailKafkaMessage(t *testing.T) {
 		}{
 			{
 				name:           "Late Filing",
-				companyCode:    utils.LateFilingPenaltyCompanyCode,
-				penaltyRefType: utils.LateFilingPenaltyRefType,
+				companyCode:    testutils.LateFilingPenaltyCompanyCode,
+				penaltyRefType: testutils.LateFilingPenaltyRefType,
 			},
 			{
 				name:           "Sanctions",
-				companyCode:    utils.SanctionsCompanyCode,
-				penaltyRefType: utils.SanctionsPenaltyRefType,
+				companyCode:    testutils.SanctionsCompanyCode,
+				penaltyRefType: testutils.SanctionsPenaltyRefType,
 			},
 			{
 				name:           "Sanctions ROE",
-				companyCode:    utils.SanctionsCompanyCode,
-				penaltyRefType: utils.SanctionsRoePenaltyRefType,
+				companyCode:    testutils.SanctionsCompanyCode,
+				penaltyRefType: testutils.SanctionsRoePenaltyRefType,
 			},
 		}
 
@@ -149,7 +142,7 @@ func TestUnitPrepareEmailKafkaMessage(t *testing.T) {
 
 					Convey("Then an error should be returned", func() {
 						_, err := prepareEmailKafkaMessage(
-							producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, topic)
+							producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, nil, topic)
 
 						So(err, ShouldResemble, errors.New("error getting config: ["+errMsg+"]"))
 					})
@@ -166,7 +159,7 @@ func TestUnitPrepareEmailKafkaMessage(t *testing.T) {
 
 			Convey("Then an error should be returned", func() {
 				_, err := prepareEmailKafkaMessage(
-					producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, topic)
+					producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, nil, topic)
 
 				So(err.Error(), ShouldStartWith, "error getting company name: [")
 			})
@@ -189,7 +182,7 @@ func TestUnitPrepareEmailKafkaMessage(t *testing.T) {
 
 			Convey("Then an error should be returned", func() {
 				_, err := prepareEmailKafkaMessage(
-					producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, topic)
+					producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, nil, topic)
 
 				So(err.Error(), ShouldEqual, "error getting company code")
 			})
@@ -212,7 +205,7 @@ func TestUnitPrepareEmailKafkaMessage(t *testing.T) {
 
 			Convey("Then an error should be returned", func() {
 				_, err := prepareEmailKafkaMessage(
-					producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, topic)
+					producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, nil, topic)
 
 				So(err, ShouldResemble, errors.New("error getting penalty ref type"))
 			})
@@ -227,7 +220,7 @@ func TestUnitPrepareEmailKafkaMessage(t *testing.T) {
 
 			getConfig = mockedConfigGet
 			getCompanyName = mockedGetCompanyName
-			setGetPenaltyRefTypeFromTransactionMock(utils.LateFilingPenaltyRefType)
+			setGetPenaltyRefTypeFromTransactionMock(testutils.LateFilingPenaltyRefType)
 
 			mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(ctrl)
 
@@ -253,7 +246,7 @@ func TestUnitPrepareEmailKafkaMessage(t *testing.T) {
 
 			getConfig = mockedConfigGet
 			getCompanyName = mockedGetCompanyName
-			setGetPenaltyRefTypeFromTransactionMock(utils.LateFilingPenaltyRefType)
+			setGetPenaltyRefTypeFromTransactionMock(testutils.LateFilingPenaltyRefType)
 
 			mockApDaoSvc := mocks.NewMockAccountPenaltiesDaoService(ctrl)
 
@@ -284,7 +277,7 @@ func TestUnitPrepareEmailKafkaMessage(t *testing.T) {
 
 			Convey("Then an error should be returned", func() {
 				_, err := prepareEmailKafkaMessage(
-					producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, topic)
+					producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, nil, topic)
 
 				So(err, ShouldResemble, errors.New("error parsing made up date: [parsing time \"\" as \"2006-01-02\": cannot parse \"\" as \"2006\"]"))
 			})
@@ -309,10 +302,45 @@ func TestUnitPrepareEmailKafkaMessage(t *testing.T) {
 			getPayablePenalty = mockedGetPayablePenalty
 
 			Convey("Then an error should be returned", func() {
-				_, err := prepareEmailKafkaMessage(producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, topic)
+				_, err := prepareEmailKafkaMessage(producerSchema, payableResource, req, penaltyDetailsMap, allowedTransactionsMap, nil, nil, topic)
 
 				So(err, ShouldResemble, errors.New("error marshalling email send message: [Unknown type name: ]"))
 			})
 		})
+		Convey("When the payable resource is for several penalties", func() {
+			mockedConfigGet := func() (*config.Config, error) {
+				return &config.Config{}, nil
+			}
+			mockedGetCompanyName := func(companyNumber string, req *http.Request) (string, error) {
+				return "Brewery", nil
+			}
+			var requested []string
+			var paidTogether []models.TransactionItem
+			mockedGetPayablePenalty := func(params types.PayablePenaltyParams) (*models.TransactionItem, error) {
+				requested = append(requested, params.Transaction.PenaltyRef)
+				paidTogether = params.Transactions
+				if params.Transaction.PenaltyRef == "A0000002" {
+					return &models.TransactionItem{PenaltyRef: "A0000002"}, nil
+				}
+				return &models.TransactionItem{PenaltyRef: params.Transaction.PenaltyRef, MadeUpDate: "2006-01-02"}, nil
+			}
+
+			getConfig = mockedConfigGet
+			getCompanyName = mockedGetCompanyName
+			getPayablePenalty = mockedGetPayablePenalty
+
+			severalPenalties := models.PayableResource{
+				CustomerCode: customerCode,
+				Transactions: []models.TransactionItem{{PenaltyRef: "A0000001"}, {PenaltyRef: "A0000002"}},
+			}
+
+			Convey("Then the details of every penalty are used", func() {
+				_, err := prepareEmailKafkaMessage(producerSchema, severalPenalties, req, penaltyDetailsMap, allowedTransactionsMap, nil, nil, topic)
+
+				So(requested, ShouldResemble, []string{"A0000001", "A0000002"})
+				So(paidTogether, ShouldResemble, severalPenalties.Transactions)
+				So(err, ShouldResemble, errors.New("error parsing made up date: [parsing time \"\" as \"2006-01-02\": cannot parse \"\" as \"2006\"]"))
+			})
+		})
 	})
 }
@@ -8,8 +8,8 @@ import (
 	"time"
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -19,7 +19,7 @@ func TestUnitGetPaymentDetailsFromPayableResource(t *testing.T) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	penaltyDetails := penaltyDetailsMap.Details[utils.LateFilingPenaltyRefType]
+	penaltyDetails := penaltyDetailsMap.Details[testutils.LateFilingPenaltyRefType]
 
 	Convey("Get payment details no transactions - invalid data", t, func() {
 
@@ -76,8 +76,8 @@ func TestUnitGetPaymentDetailsFromPayableResource(t *testing.T) {
 				descriptionIdentifier: "late-filing-penalty",
 				resourceKind:          "late-filing-penalty#late-filing-penalty",
 				productType:           "late-filing-penalty",
-				companyCode:           utils.LateFilingPenaltyCompanyCode,
-				penaltyRefType:        utils.LateFilingPenaltyRefType,
+				companyCode:           testutils.LateFilingPenaltyCompanyCode,
+				penaltyRefType:        testutils.LateFilingPenaltyRefType,
 			},
 			{
 				description:           "Sanctions Penalty Payment",
@@ -86,8 +86,8 @@ func TestUnitGetPaymentDetailsFromPayableResource(t *testing.T) {
 				descriptionIdentifier: "penalty-sanctions",
 				resourceKind:          "penalty#sanctions",
 				productType:           "penalty-sanctions",
-				companyCode:           utils.SanctionsCompanyCode,
-				penaltyRefType:        utils.SanctionsPenaltyRefType,
+				companyCode:           testutils.SanctionsCompanyCode,
+				penaltyRefType:        testutils.SanctionsPenaltyRefType,
 			},
 			{
 				description:           "Overseas Entity Penalty Payment",
@@ -96,8 +96,8 @@ func TestUnitGetPaymentDetailsFromPayableResource(t *testing.T) {
 				descriptionIdentifier: "penalty-sanctions",
 				resourceKind:          "penalty#sanctions",
 				productType:           "penalty-sanctions",
-				companyCode:           utils.SanctionsCompanyCode,
-				penaltyRefType:        utils.SanctionsRoePenaltyRefType,
+				companyCode:           testutils.SanctionsCompanyCode,
+				penaltyRefType:        testutils.SanctionsRoePenaltyRefType,
 			},
 		}
 		for _, tc := range testCases {
@@ -183,8 +183,8 @@ func TestUnitGetPaymentDetailsFromPayableResource(t *testing.T) {
 				descriptionIdentifier: "late-filing-penalty",
 				resourceKind:          "late-filing-penalty#late-filing-penalty",
 				productType:           "late-filing-penalty",
-				companyCode:           utils.LateFilingPenaltyCompanyCode,
-				penaltyRefType:        utils.LateFilingPenaltyRefType,
+				companyCode:           testutils.LateFilingPenaltyCompanyCode,
+				penaltyRefType:        testutils.LateFilingPenaltyRefType,
 			},
 			{
 				description:           "Sanctions Penalty Payment",
@@ -193,8 +193,8 @@ func TestUnitGetPaymentDetailsFromPayableResource(t *testing.T) {
 				descriptionIdentifier: "penalty-sanctions",
 				resourceKind:          "penalty#sanctions",
 				productType:           "penalty-sanctions",
-				companyCode:           utils.SanctionsCompanyCode,
-				penaltyRefType:        utils.SanctionsPenaltyRefType,
+				companyCode:           testutils.SanctionsCompanyCode,
+				penaltyRefType:        testutils.SanctionsPenaltyRefType,
 			},
 			{
 				description:           "Overseas Entity Penalty Payment",
@@ -203,8 +203,8 @@ func TestUnitGetPaymentDetailsFromPayableResource(t *testing.T) {
 				descriptionIdentifier: "penalty-sanctions",
 				resourceKind:          "penalty#sanctions",
 				productType:           "penalty-sanctions",
-				companyCode:           utils.SanctionsCompanyCode,
-				penaltyRefType:        utils.SanctionsRoePenaltyRefType,
+				companyCode:           testutils.SanctionsCompanyCode,
+				penaltyRefType:        testutils.SanctionsRoePenaltyRefType,
 			},
 		}
 		for _, tc := range testCases {
@@ -2,6 +2,7 @@ package service
 
 import (
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/companieshouse/chs.go/avro"
@@ -9,36 +10,30 @@ import (
 	"github.com/companieshouse/chs.go/log"
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api-core/validators"
+	"github.com/companieshouse/penalty-payment-api/common/outbox"
 )
 
-func PaymentProcessingKafkaMessage(payableResource models.PayableResource, payment *validators.PaymentInformation, requestId string) error {
+// PaymentProcessingOutboxMessage prepares the kafka message that asks the penalty payments consumer to mark the
+// penalty as paid in E5, ready to be put in the outbox with the payment
+func PaymentProcessingOutboxMessage(payableResource models.PayableResource, payment *validators.PaymentInformation, requestId string) (*outbox.Message, error) {
 	cfg, err := getConfig()
 	if err != nil {
 		err = fmt.Errorf("error getting config for penalty payments processing kafka message production: [%v]", err)
-		return err
+		return nil, err
 	}
 
-	brokerAddrs := cfg.Kafka3BrokerAddr
 	topic := cfg.PenaltyPaymentsProcessingTopic
 
 	logContext := log.Data{
 		"customer_code": payableResource.CustomerCode,
 		"payable_ref":   payableResource.PayableRef,
-		"broker_addrs":  brokerAddrs,
 		"topic":         topic,
 	}
 
-	log.InfoC(requestId, "getting penalty payments processing kafka producer", logContext)
-	kafkaProducer, err := getProducer(brokerAddrs)
-	if err != nil {
-		err = fmt.Errorf("error creating penalty payments processing kafka producer: [%v]", err)
-		return err
-	}
-
 	penaltyPaymentsProcessingSchema, err := getSchema(cfg.SchemaRegistryURL, topic)
 	if err != nil {
 		err = fmt.Errorf("error getting penalty payments processing schema from schema registry: [%v]", err)
-		return err
+		return nil, err
 	}
 	producerSchema := &avro.Schema{
 		Definition: penaltyPaymentsProcessingSchema,
@@ -48,28 +43,15 @@ func PaymentProcessingKafkaMessage(payableResource models.PayableResource, payme
 	message, err := preparePaymentProcessingKafkaMessage(*producerSchema, payableResource, payment, topic, requestId)
 	if err != nil {
 		err = fmt.Errorf("error preparing penalty payments processing kafka message with schema: [%v]", err)
-		return err
+		return nil, err
 	}
 	log.DebugC(requestId, "penalty payment processing message prepared successfully", logContext, log.Data{
-		"message.Value":     message.Value,
-		"message.Topic":     message.Topic,
-		"message.Partition": message.Partition,
-		"message.Key":       message.Key,
+		"message.Value": message.Value,
+		"message.Topic": message.Topic,
 	})
 
-	partition, offset, err := kafkaProducer.Send(message)
-	if err != nil {
-		err = fmt.Errorf("failed to send penalty payments processing message: [%v]", err)
-		log.Error(err, logContext)
-		return err
-	}
-	log.InfoC(requestId, "successfully published penalty payments processing message", logContext, log.Data{
-		"kafka_topic":     topic,
-		"kafka_partition": partition,
-		"kafka_offset":    offset,
-	})
-
-	return nil
+	return outbox.NewMessage(outbox.PenaltyPaymentsProcessing, payableResource.CustomerCode, payableResource.PayableRef,
+		topic, message.Value), nil
 }
 
 // preparePaymentProcessingKafkaMessage generates the kafka message that is to be sent
@@ -109,16 +91,15 @@ func constructMessage(payableResource models.PayableResource, companyCode string
 	transactionPayments := transformToTransactionPayments(payableResource)
 
 	penaltyPaymentProcessing := models.PenaltyPaymentsProcessing{
-		Attempt:           1,
-		CreatedAt:         time.Now().UTC().Format(time.RFC3339),
-		CompanyCode:       companyCode,
-		CustomerCode:      payableResource.CustomerCode,
-		PaymentID:         payment.PaymentID,
-		ExternalPaymentID: payment.ExternalPaymentID,
-		PaymentReference:  payment.Reference,
-		PaymentAmount:     payment.Amount,
-		// set to the only transaction in the array until multiple payments functionality comes
-		TotalValue:          transactionPayments[0].Value,
+		Attempt:             1,
+		CreatedAt:           time.Now().UTC().Format(time.RFC3339),
+		CompanyCode:         companyCode,
+		CustomerCode:        payableResource.CustomerCode,
+		PaymentID:           payment.PaymentID,
+		ExternalPaymentID:   payment.ExternalPaymentID,
+		PaymentReference:    payment.Reference,
+		PaymentAmount:       payment.Amount,
+		TotalValue:          getTotalAmountFromTransactions(payableResource.Transactions),
 		TransactionPayments: transactionPayments,
 		CardType:            payment.CardType,
 		Email:               payment.CreatedBy,
@@ -138,3 +119,12 @@ func transformToTransactionPayments(payableResource models.PayableResource) []mo
 	}
 	return transactionPayments
 }
+
+// getTotalAmountFromTransactions adds up the amounts of all the transactions, rounded to the nearest penny
+func getTotalAmountFromTransactions(transactions []models.TransactionItem) float64 {
+	var total float64
+	for _, transaction := range transactions {
+		total += transaction.Amount
+	}
+	return math.Round(total*100) / 100
+}
@@ -8,22 +8,21 @@ import (
 
 	"github.com/companieshouse/chs.go/avro"
 	"github.com/companieshouse/chs.go/avro/schema"
-	"github.com/companieshouse/chs.go/kafka/producer"
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api-core/validators"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 var paymentInfo = validators.PaymentInformation{}
 
-func TestUnitPaymentProcessingKafkaMessage(t *testing.T) {
+func TestUnitPaymentProcessingOutboxMessage(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	Convey("Given the PaymentProcessingKafkaMessage is called", t, func() {
+	Convey("Given the PaymentProcessingOutboxMessage is called", t, func() {
 		Convey("When config is called with invalid config", func() {
 			errMsg := "config is invalid"
 			mockedConfigGet := func() (*config.Config, error) {
@@ -33,66 +32,41 @@ func TestUnitPaymentProcessingKafkaMessage(t *testing.T) {
 			getConfig = mockedConfigGet
 
 			Convey("Then an error should be returned", func() {
-				err := PaymentProcessingKafkaMessage(payableResource, &paymentInfo, "")
+				_, err := PaymentProcessingOutboxMessage(payableResource, &paymentInfo, "")
 
 				So(err, ShouldResemble, errors.New("error getting config for penalty payments processing kafka message production: ["+errMsg+"]"))
 			})
 		})
-		Convey("When config is called with valid config and invalid broker address", func() {
+		Convey("When config is called with valid config but invalid schema", func() {
 			mockedConfigGet := func() (*config.Config, error) {
 				return &config.Config{}, nil
 			}
-			mockedGetProducer := func(brokerAddrs []string) (*producer.Producer, error) {
-				return nil, errors.New("kafka: invalid configuration (You must provide at least one broker address)")
-			}
-
-			getConfig = mockedConfigGet
-			getProducer = mockedGetProducer
-
-			Convey("Then an error should be returned", func() {
-				err := PaymentProcessingKafkaMessage(payableResource, &paymentInfo, "")
-
-				So(err, ShouldResemble, errors.New("error creating penalty payments processing kafka producer: [kafka: invalid configuration (You must provide at least one broker address)]"))
-			})
-		})
-		Convey("When config is called with valid config and valid broker config but invalid schema", func() {
-			mockedConfigGet := func() (*config.Config, error) {
-				return &config.Config{}, nil
-			}
-			mockedGetProducer := func(brokerAddrs []string) (*producer.Producer, error) {
-				return &producer.Producer{}, nil
-			}
 			mockedGetSchema := func(url, schemaName string) (string, error) {
 				return "", errors.New("get \"/subjects/penalty-payments-processing/versions/latest\": unsupported protocol scheme \"\"")
 			}
 
 			getConfig = mockedConfigGet
-			getProducer = mockedGetProducer
 			getSchema = mockedGetSchema
 
 			Convey("Then an error should be returned", func() {
-				err := PaymentProcessingKafkaMessage(payableResource, &paymentInfo, "")
+				_, err := PaymentProcessingOutboxMessage(payableResource, &paymentInfo, "")
 
 				So(err, ShouldResemble, errors.New("error getting penalty payments processing schema from schema registry: [get \"/subjects/penalty-payments-processing/versions/latest\": unsupported protocol scheme \"\"]"))
 			})
 		})
-		Convey("When config is called with valid config and valid broker config and valid schema", func() {
+		Convey("When config is called with valid config and valid schema", func() {
 			mockedConfigGet := func() (*config.Config, error) {
 				return &config.Config{}, nil
 			}
-			mockedGetProducer := func(brokerAddrs []string) (*producer.Producer, error) {
-				return &producer.Producer{}, nil
-			}
 			mockedGetSchema := func(url, schemaName string) (string, error) {
 				return "schema", nil
 			}
 
 			getConfig = mockedConfigGet
-			getProducer = mockedGetProducer
 			getSchema = mockedGetSchema
 
 			Convey("Then an error should be returned", func() {
-				err := PaymentProcessingKafkaMessage(payableResource, &paymentInfo, "")
+				_, err := PaymentProcessingOutboxMessage(payableResource, &paymentInfo, "")
 
 				So(err.Error(), ShouldStartWith, "error preparing penalty payments processing kafka message with schema:")
 			})
@@ -159,7 +133,7 @@ func TestUnitPreparePaymentProcessingKafkaMessage(t *testing.T) {
 			})
 		})
 		Convey("When config is called with no transaction items", func() {
-			setGetPenaltyRefTypeFromTransactionMock(utils.LateFilingPenaltyRefType)
+			setGetPenaltyRefTypeFromTransactionMock(testutils.LateFilingPenaltyRefType)
 
 			Convey("Then an error should be returned", func() {
 				payableResourceNoItems := models.PayableResource{
@@ -175,3 +149,46 @@ func TestUnitPreparePaymentProcessingKafkaMessage(t *testing.T) {
 		})
 	})
 }
+
+func TestUnitConstructMessage(t *testing.T) {
+	Convey("Given a payable resource for several penalties", t, func() {
+		resource := models.PayableResource{
+			CustomerCode: "12345678",
+			PayableRef:   "XP1",
+			Transactions: []models.TransactionItem{
+				{PenaltyRef: "A0000001", Amount: 150},
+				{PenaltyRef: "A0000002", Amount: 375.5},
+			},
+		}
+		payment := &validators.PaymentInformation{PaymentID: "P123", Reference: "P123", Amount: "525.50"}
+
+		Convey("Then every penalty is paid and the total value is their sum", func() {
+			message := constructMessage(resource, testutils.LateFilingPenaltyCompanyCode, payment)
+
+			So(message.TotalValue, ShouldEqual, 525.5)
+			So(message.TransactionPayments, ShouldResemble, []models.TransactionPayment{
+				{TransactionReference: "A0000001", Value: 150},
+				{TransactionReference: "A0000002", Value: 375.5},
+			})
+			So(message.CompanyCode, ShouldEqual, testutils.LateFilingPenaltyCompanyCode)
+			So(message.PayableRef, ShouldEqual, "XP1")
+		})
+	})
+}
+
+func TestUnitGetTotalAmountFromTransactions(t *testing.T) {
+	Convey("Get total amount from transactions", t, func() {
+		Convey("The amounts of all the transactions are added up", func() {
+			total := getTotalAmountFromTransactions([]models.TransactionItem{
+				{PenaltyRef: "A1000007", Amount: 150},
+				{PenaltyRef: "A1000008", Amount: 0.1},
+				{PenaltyRef: "A1000009", Amount: 0.2},
+			})
+			So(total, ShouldEqual, 150.3)
+		})
+
+		Convey("The total of no transactions is zero", func() {
+			So(getTotalAmountFromTransactions(nil), ShouldEqual, 0)
+		})
+	})
+}
@@ -1,8 +1,7 @@
 package service
 
 import (
-	"github.com/companieshouse/chs.go/avro/schema"
-	"github.com/companieshouse/chs.go/kafka/producer"
+	"github.com/companieshouse/penalty-payment-api/common/kafka"
 	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
@@ -11,12 +10,11 @@ import (
 var (
 	getConfig = config.Get
 
-	getProducer = func(brokerAddrs []string) (*producer.Producer, error) {
-		return producer.New(&producer.Config{Acks: &producer.WaitForAll, BrokerAddrs: brokerAddrs})
-	}
-	getSchema = func(url, schemaName string) (string, error) {
-		return schema.Get(url, schemaName)
-	}
+	// the producers and schemas are shared, so they are not created or fetched again for every message
+	producers           = kafka.GetProducerManager()
+	getProducer         = producers.Producer
+	getSchema           = producers.Schema
+	reportProducerError = producers.ReportFailure
 
 	getCompanyName                   = GetCompanyName
 	getCompanyCodeFromTransaction    = utils.GetCompanyCodeFromTransaction
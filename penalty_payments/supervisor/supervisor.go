@@ -7,15 +7,21 @@ import (
 
 	"github.com/companieshouse/chs.go/kafka/resilience"
 	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
 	"github.com/companieshouse/penalty-payment-api/penalty_payments/consumer"
 )
 
-var consumerFunc = consumer.Consume
+var (
+	consumerFunc = consumer.Consume
+	restartDelay = time.Duration(1) * time.Second
+)
 
-// SuperviseConsumer runs a consumer in a loop, restarting it if it exits unexpectedly
-func SuperviseConsumer(ctx context.Context, name string, cfg *config.Config, penaltyFinancePayment *api.PenaltyFinancePayment, retry *resilience.ServiceRetry) {
+// SuperviseConsumer runs a consumer in a loop, restarting it if it exits unexpectedly. It returns once the context is
+// cancelled and the consumer has stopped, so callers can wait for it to finish the message it is processing.
+func SuperviseConsumer(ctx context.Context, name string, cfg *config.Config, penaltyFinancePayment *api.PenaltyFinancePayment,
+	processedMessages dao.ProcessedMessageDaoService, deadLetters dao.DeadLetterDaoService, retry *resilience.ServiceRetry) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -29,11 +35,14 @@ func SuperviseConsumer(ctx context.Context, name string, cfg *config.Config, pen
 						log.Error(fmt.Errorf("panic recovered in supervise consumer %s: %v", name, r))
 					}
 				}()
-				consumerFunc(cfg, penaltyFinancePayment, retry)
+				consumerFunc(ctx, cfg, penaltyFinancePayment, processedMessages, deadLetters, retry)
 			}()
 
 			log.Info(fmt.Sprintf("supervise consumer %s exited; restarting after delay", name))
-			time.Sleep(time.Duration(1) * time.Second)
+			select {
+			case <-ctx.Done():
+			case <-time.After(restartDelay):
+			}
 		}
 	}
 }
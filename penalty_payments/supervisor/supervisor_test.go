@@ -6,11 +6,13 @@ import (
 	"time"
 
 	"github.com/companieshouse/chs.go/kafka/resilience"
+	"github.com/companieshouse/penalty-payment-api/common/dao"
 	"github.com/companieshouse/penalty-payment-api/config"
 	"github.com/companieshouse/penalty-payment-api/issuer_gateway/api"
 )
 
-var mockConsumerFunc = func(cfg *config.Config, penaltyFinancePayment api.FinancePayment, retry *resilience.ServiceRetry) {
+var mockConsumerFunc = func(ctx context.Context, cfg *config.Config, penaltyFinancePayment api.FinancePayment,
+	processedMessages dao.ProcessedMessageDaoService, deadLetters dao.DeadLetterDaoService, retry *resilience.ServiceRetry) {
 	panic("simulated panic")
 }
 
@@ -32,7 +34,7 @@ func TestUnitSuperviseConsumer_PanicRecoveryAndShutdown(t *testing.T) {
 	done := make(chan struct{})
 
 	go func() {
-		SuperviseConsumer(ctx, "test-consumer", cfg, penaltyFinancePayment, retry)
+		SuperviseConsumer(ctx, "test-consumer", cfg, penaltyFinancePayment, nil, nil, retry)
 		close(done)
 	}()
 
@@ -47,3 +49,36 @@ func TestUnitSuperviseConsumer_PanicRecoveryAndShutdown(t *testing.T) {
 		t.Fatal("SuperviseConsumer did not exit after context cancellation")
 	}
 }
+
+func TestUnitSuperviseConsumer_ShutdownDuringRestartDelay(t *testing.T) {
+	original, originalDelay := consumerFunc, restartDelay
+	defer func() {
+		consumerFunc, restartDelay = original, originalDelay
+	}()
+	restartDelay = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{}, 1)
+	consumerFunc = func(ctx context.Context, cfg *config.Config, penaltyFinancePayment api.FinancePayment,
+		processedMessages dao.ProcessedMessageDaoService, deadLetters dao.DeadLetterDaoService, retry *resilience.ServiceRetry) {
+		started <- struct{}{}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		SuperviseConsumer(ctx, "test-consumer", &config.Config{}, &api.PenaltyFinancePayment{}, nil, nil, nil)
+		close(done)
+	}()
+
+	<-started
+	cancel()
+
+	select {
+	case <-done:
+		// Success
+	case <-time.After(1 * time.Second):
+		t.Fatal("SuperviseConsumer did not exit while waiting to restart the consumer")
+	}
+}
@@ -2,6 +2,7 @@ package transformers
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/companieshouse/chs.go/log"
@@ -9,6 +10,7 @@ import (
 	"github.com/companieshouse/penalty-payment-api-core/models"
 	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/issuer_gateway/types"
 )
 
 var etagGenerator = utils.GenerateEtag
@@ -38,8 +40,11 @@ func PayableResourceRequestToDB(req *models.PayableRequest, requestId string) *m
 	paymentLinkFormat := "%s/payment"
 	paymentLink := fmt.Sprintf(paymentLinkFormat, self)
 
+	// the journey is resumed from the first penalty of the resource, in the same order as the transactions are read back
+	transactions := append([]models.TransactionItem(nil), req.Transactions...)
+	SortTransactions(transactions)
 	resumeJourneyLinkFormat := "/pay-penalty/company/%s/penalty/%s/view-penalties"
-	resumeJourneyLink := fmt.Sprintf(resumeJourneyLinkFormat, req.CustomerCode, req.Transactions[0].PenaltyRef) // Assumes there is only one transaction
+	resumeJourneyLink := fmt.Sprintf(resumeJourneyLinkFormat, req.CustomerCode, transactions[0].PenaltyRef)
 
 	createdAt := time.Now().Truncate(time.Millisecond)
 	dao := &models.PayableResourceDao{
@@ -68,6 +73,19 @@ func PayableResourceRequestToDB(req *models.PayableRequest, requestId string) *m
 	return dao
 }
 
+// SortTransactions orders the penalties before the costs paid together with them, each by penalty reference, so that
+// the first transaction is always a penalty and the penalty type can be taken from it
+func SortTransactions(transactions []models.TransactionItem) {
+	sort.SliceStable(transactions, func(i, j int) bool {
+		iIsCost := transactions[i].Type == types.Other.String()
+		jIsCost := transactions[j].Type == types.Other.String()
+		if iIsCost != jIsCost {
+			return jIsCost
+		}
+		return transactions[i].PenaltyRef < transactions[j].PenaltyRef
+	})
+}
+
 // PayableResourceDaoToCreatedResponse will transform a payable resource dao that has successfully been created into
 // a http response entity
 func PayableResourceDaoToCreatedResponse(model *models.PayableResourceDao) *models.CreatedPayableResource {
@@ -79,7 +97,8 @@ func PayableResourceDaoToCreatedResponse(model *models.PayableResourceDao) *mode
 	}
 }
 
-// PayableResourceDBToRequest will take the Dao version of a payable resource and convert to a request version
+// PayableResourceDBToRequest will take the Dao version of a payable resource and convert to a request version. The
+// transactions are ordered by SortTransactions, so that the first transaction is the same every time it is read.
 func PayableResourceDBToRequest(payableDao *models.PayableResourceDao) *models.PayableResource {
 	var transactions []models.TransactionItem
 	for key, val := range payableDao.Data.Transactions {
@@ -92,6 +111,7 @@ func PayableResourceDBToRequest(payableDao *models.PayableResourceDao) *models.P
 		}
 		transactions = append(transactions, tx)
 	}
+	SortTransactions(transactions)
 
 	payable := models.PayableResource{
 		CustomerCode: payableDao.CustomerCode,
@@ -10,8 +10,8 @@ import (
 	"time"
 
 	"github.com/companieshouse/penalty-payment-api-core/models"
-	"github.com/companieshouse/penalty-payment-api/common/utils"
 	"github.com/companieshouse/penalty-payment-api/config"
+	"github.com/companieshouse/penalty-payment-api/testutils"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -59,6 +59,38 @@ func TestUnitPayableResourceRequestToDB(t *testing.T) {
 		So(dao.Data.Links.Self, ShouldContainSubstring, expected)
 		So(dao.Data.Links.ResumeJourney, ShouldEqual, "/pay-penalty/company/00006400/penalty/123/view-penalties")
 	})
+
+	Convey("several penalties are stored and the journey is resumed from the first", t, func() {
+		req := &models.PayableRequest{
+			CustomerCode: "00006400",
+			Transactions: []models.TransactionItem{
+				{PenaltyRef: "A0000002", Amount: 250},
+				{PenaltyRef: "A0000001", Amount: 150},
+			},
+		}
+		dao := PayableResourceRequestToDB(req, "")
+
+		So(dao.Data.Transactions, ShouldHaveLength, 2)
+		So(dao.Data.Transactions["A0000001"].Amount, ShouldEqual, 150)
+		So(dao.Data.Transactions["A0000002"].Amount, ShouldEqual, 250)
+		So(dao.Data.Links.ResumeJourney, ShouldEqual, "/pay-penalty/company/00006400/penalty/A0000001/view-penalties")
+	})
+
+	Convey("a penalty is stored with its costs and the journey is resumed from the penalty", t, func() {
+		req := &models.PayableRequest{
+			CustomerCode: "00006400",
+			Transactions: []models.TransactionItem{
+				{PenaltyRef: "00482776", Amount: 80, Type: "other"},
+				{PenaltyRef: "A0000001", Amount: 150, Type: "penalty"},
+			},
+		}
+		dao := PayableResourceRequestToDB(req, "")
+
+		So(dao.Data.Transactions, ShouldHaveLength, 2)
+		So(dao.Data.Transactions["00482776"].Type, ShouldEqual, "other")
+		So(dao.Data.Links.ResumeJourney, ShouldEqual, "/pay-penalty/company/00006400/penalty/A0000001/view-penalties")
+		So(req.Transactions[0].PenaltyRef, ShouldEqual, "00482776")
+	})
 }
 
 func TestUnitPayableResourceDaoToCreatedResponse(t *testing.T) {
@@ -141,6 +173,46 @@ func TestUnitPayableResourceDBToPayableResource(t *testing.T) {
 		So(response.Transactions[0].Type, ShouldEqual, dao.Data.Transactions["123"].Type)
 		So(response.Transactions[0].MadeUpDate, ShouldEqual, dao.Data.Transactions["123"].MadeUpDate)
 	})
+
+	Convey("several transactions are ordered by penalty reference", t, func() {
+		dao := &models.PayableResourceDao{
+			Data: models.PayableResourceDataDao{
+				Transactions: map[string]models.TransactionDao{
+					"A0000003": {Amount: 350},
+					"A0000001": {Amount: 150},
+					"A0000002": {Amount: 250},
+				},
+			},
+		}
+
+		response := PayableResourceDBToRequest(dao)
+
+		So(response.Transactions, ShouldResemble, []models.TransactionItem{
+			{PenaltyRef: "A0000001", Amount: 150},
+			{PenaltyRef: "A0000002", Amount: 250},
+			{PenaltyRef: "A0000003", Amount: 350},
+		})
+	})
+
+	Convey("penalties are ordered before the costs paid together with them", t, func() {
+		dao := &models.PayableResourceDao{
+			Data: models.PayableResourceDataDao{
+				Transactions: map[string]models.TransactionDao{
+					"CF1":      {Amount: 105, Type: "other"},
+					"A0000001": {Amount: 3000, Type: "penalty"},
+					"00482776": {Amount: 80, Type: "other"},
+				},
+			},
+		}
+
+		response := PayableResourceDBToRequest(dao)
+
+		So(response.Transactions, ShouldResemble, []models.TransactionItem{
+			{PenaltyRef: "A0000001", Amount: 3000, Type: "penalty"},
+			{PenaltyRef: "00482776", Amount: 80, Type: "other"},
+			{PenaltyRef: "CF1", Amount: 105, Type: "other"},
+		})
+	})
 }
 
 func TestUnitPayableResourceToPaymentDetails(t *testing.T) {
@@ -162,8 +234,8 @@ func TestUnitPayableResourceToPaymentDetails(t *testing.T) {
 				descriptionIdentifier: "late-filing-penalty",
 				resourceKind:          "late-filing-penalty#late-filing-penalty",
 				productType:           "late-filing-penalty",
-				companyCode:           utils.LateFilingPenaltyCompanyCode,
-				penaltyRefType:        utils.LateFilingPenaltyRefType,
+				companyCode:           testutils.LateFilingPenaltyCompanyCode,
+				penaltyRefType:        testutils.LateFilingPenaltyRefType,
 			},
 			{
 				description:           "Sanctions Penalty Payment",
@@ -172,8 +244,8 @@ func TestUnitPayableResourceToPaymentDetails(t *testing.T) {
 				descriptionIdentifier: "penalty-sanctions",
 				resourceKind:          "penalty#sanctions",
 				productType:           "penalty-sanctions",
-				companyCode:           utils.SanctionsCompanyCode,
-				penaltyRefType:        utils.SanctionsPenaltyRefType,
+				companyCode:           testutils.SanctionsCompanyCode,
+				penaltyRefType:        testutils.SanctionsPenaltyRefType,
 			},
 			{
 				description:           "Overseas Entity Penalty Payment",
@@ -182,8 +254,8 @@ func TestUnitPayableResourceToPaymentDetails(t *testing.T) {
 				descriptionIdentifier: "penalty-sanctions",
 				resourceKind:          "penalty#sanctions",
 				productType:           "penalty-sanctions",
-				companyCode:           utils.SanctionsCompanyCode,
-				penaltyRefType:        utils.SanctionsRoePenaltyRefType,
+				companyCode:           testutils.SanctionsCompanyCode,
+				penaltyRefType:        testutils.SanctionsRoePenaltyRefType,
 			},
 		}
 		for _, tc := range testCases {
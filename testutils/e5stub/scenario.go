@@ -0,0 +1,201 @@
+// Package e5stub is an in-memory stand in for the E5 AR Transactions and Payments API described in
+// spec/e5ArTransactions-1.1-swagger.yaml. It serves transactions from scenario files, keeps account lock state per
+// customer and can inject error responses or latency per endpoint.
+package e5stub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The endpoints that faults can be injected into
+const (
+	TransactionsEndpoint        = "transactions"
+	CompanyTransactionsEndpoint = "company-transactions"
+	CreateEndpoint              = "create"
+	AuthoriseEndpoint           = "authorise"
+	ConfirmEndpoint             = "confirm"
+	RejectEndpoint              = "reject"
+	TimeoutEndpoint             = "timeout"
+)
+
+var endpoints = map[string]bool{
+	TransactionsEndpoint:        true,
+	CompanyTransactionsEndpoint: true,
+	CreateEndpoint:              true,
+	AuthoriseEndpoint:           true,
+	ConfirmEndpoint:             true,
+	RejectEndpoint:              true,
+	TimeoutEndpoint:             true,
+}
+
+const defaultPageSize = 20
+
+// Scenario is the set of customer accounts and faults served by the stub
+type Scenario struct {
+	Name      string     `yaml:"name"      json:"name"`
+	PageSize  int        `yaml:"page_size" json:"page_size"`
+	Customers []Customer `yaml:"customers" json:"customers"`
+	Faults    []Fault    `yaml:"faults"    json:"faults"`
+}
+
+// Customer is a customer account and the AR transactions on it
+type Customer struct {
+	CompanyCode  string        `yaml:"company_code"  json:"company_code"`
+	CustomerCode string        `yaml:"customer_code" json:"customer_code"`
+	Transactions []Transaction `yaml:"transactions"  json:"transactions"`
+}
+
+// Transaction is an AR transaction on a customer account
+type Transaction struct {
+	LedgerCode           string  `yaml:"ledger_code"           json:"ledger_code"`
+	TransactionReference string  `yaml:"transaction_reference" json:"transaction_reference"`
+	TransactionDate      string  `yaml:"transaction_date"      json:"transaction_date"`
+	MadeUpDate           string  `yaml:"made_up_date"          json:"made_up_date"`
+	Amount               float64 `yaml:"amount"                json:"amount"`
+	OutstandingAmount    float64 `yaml:"outstanding_amount"    json:"outstanding_amount"`
+	IsPaid               bool    `yaml:"is_paid"               json:"is_paid"`
+	TransactionType      string  `yaml:"transaction_type"      json:"transaction_type"`
+	TransactionSubType   string  `yaml:"transaction_sub_type"  json:"transaction_sub_type"`
+	TypeDescription      string  `yaml:"type_description"      json:"type_description"`
+	DueDate              string  `yaml:"due_date"              json:"due_date"`
+	AccountStatus        string  `yaml:"account_status"        json:"account_status"`
+	DunningStatus        string  `yaml:"dunning_status"        json:"dunning_status"`
+}
+
+// Fault is an error response or latency injected into requests to an endpoint. Status is one of 400, 404 or 500, or
+// zero to only add latency. Times limits the fault to that many requests, zero applies it to every request.
+type Fault struct {
+	Endpoint     string `yaml:"endpoint"      json:"endpoint"`
+	CustomerCode string `yaml:"customer_code" json:"customer_code,omitempty"`
+	Status       int    `yaml:"status"        json:"status,omitempty"`
+	MessageCode  string `yaml:"message_code"  json:"message_code,omitempty"`
+	Message      string `yaml:"message"       json:"message,omitempty"`
+	Latency      string `yaml:"latency"       json:"latency,omitempty"`
+	Times        int    `yaml:"times"         json:"times,omitempty"`
+
+	latency time.Duration
+}
+
+// Validate checks the fault can be injected and parses its latency
+func (f *Fault) Validate() error {
+	if !endpoints[f.Endpoint] {
+		return fmt.Errorf("unknown endpoint [%s]", f.Endpoint)
+	}
+	switch f.Status {
+	case 0, 400, 404, 500:
+	default:
+		return fmt.Errorf("unsupported status [%d] for endpoint [%s]", f.Status, f.Endpoint)
+	}
+	if f.Times < 0 {
+		return fmt.Errorf("times must not be negative for endpoint [%s]", f.Endpoint)
+	}
+	if f.Latency != "" {
+		latency, err := time.ParseDuration(f.Latency)
+		if err != nil {
+			return fmt.Errorf("invalid latency [%s] for endpoint [%s]: %v", f.Latency, f.Endpoint, err)
+		}
+		f.latency = latency
+	}
+	if f.Status == 0 && f.latency == 0 {
+		return fmt.Errorf("fault for endpoint [%s] must have a status or latency", f.Endpoint)
+	}
+	return nil
+}
+
+// Validate checks the customers are unique and the faults can be injected
+func (s *Scenario) Validate() error {
+	if s.PageSize <= 0 {
+		s.PageSize = defaultPageSize
+	}
+
+	seen := map[string]bool{}
+	for _, customer := range s.Customers {
+		if customer.CompanyCode == "" || customer.CustomerCode == "" {
+			return fmt.Errorf("scenario [%s]: customers must have a company code and customer code", s.Name)
+		}
+		key := accountKey(customer.CompanyCode, customer.CustomerCode)
+		if seen[key] {
+			return fmt.Errorf("scenario [%s]: duplicate customer [%s]", s.Name, key)
+		}
+		seen[key] = true
+	}
+
+	for i := range s.Faults {
+		if err := s.Faults[i].Validate(); err != nil {
+			return fmt.Errorf("scenario [%s]: %v", s.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// ParseScenario reads a scenario from YAML
+func ParseScenario(data []byte) (*Scenario, error) {
+	var scenario Scenario
+	if err := yaml.UnmarshalStrict(data, &scenario); err != nil {
+		return nil, err
+	}
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+	return &scenario, nil
+}
+
+// LoadScenarios reads a scenario file, or every .yml and .yaml file in a directory merged into a single scenario in
+// file name order
+func LoadScenarios(path string) (*Scenario, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if !entry.IsDir() && (ext == ".yml" || ext == ".yaml") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+		sort.Strings(files)
+	}
+
+	merged := &Scenario{Name: filepath.Base(path)}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		scenario, err := ParseScenario(data)
+		if err != nil {
+			return nil, fmt.Errorf("error reading scenario file [%s]: %v", file, err)
+		}
+		if merged.PageSize == 0 || scenario.PageSize < merged.PageSize {
+			merged.PageSize = scenario.PageSize
+		}
+		merged.Customers = append(merged.Customers, scenario.Customers...)
+		merged.Faults = append(merged.Faults, scenario.Faults...)
+	}
+
+	if err := merged.Validate(); err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
+
+func accountKey(companyCode, customerCode string) string {
+	return companyCode + "/" + customerCode
+}
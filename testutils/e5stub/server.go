@@ -0,0 +1,621 @@
+package e5stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/companieshouse/chs.go/log"
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+const dateLayout = "2006-01-02"
+
+// The states of a payment session
+const (
+	PaymentCreated    = "created"
+	PaymentAuthorised = "authorised"
+	PaymentConfirmed  = "confirmed"
+	PaymentRejected   = "rejected"
+	PaymentTimedOut   = "timed-out"
+)
+
+// Payment is a payment session created in the stub. The customer account is locked while the payment is created or
+// authorised.
+type Payment struct {
+	PaymentID    string                         `json:"payment_id"`
+	CompanyCode  string                         `json:"company_code"`
+	CustomerCode string                         `json:"customer_code"`
+	PaymentValue float64                        `json:"payment_value"`
+	Transactions []*e5.CreatePaymentTransaction `json:"transactions"`
+	Status       string                         `json:"status"`
+}
+
+// State is a snapshot of the account locks and payments held by the stub
+type State struct {
+	Scenario string              `json:"scenario"`
+	Locks    map[string]string   `json:"locks"`
+	Payments map[string]*Payment `json:"payments"`
+	Faults   []Fault             `json:"faults"`
+}
+
+// Server serves the E5 AR Transactions and Payments API from a scenario
+type Server struct {
+	router *mux.Router
+
+	mtx       sync.Mutex
+	scenario  *Scenario
+	customers map[string]*Customer
+	locks     map[string]string
+	payments  map[string]*Payment
+	faults    []*Fault
+}
+
+// NewServer will construct a stub E5 server serving the scenario
+func NewServer(scenario *Scenario) (*Server, error) {
+	s := &Server{}
+	if err := s.Load(scenario); err != nil {
+		return nil, err
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/arTransactions", s.handleCompanyTransactions).Methods(http.MethodGet)
+	r.HandleFunc("/arTransactions/{customer_code}", s.handleTransactions).Methods(http.MethodGet)
+	r.HandleFunc("/arTransactions/payment", s.handleCreate).Methods(http.MethodPost)
+	r.HandleFunc("/arTransactions/payment/authorise", s.handleAuthorise).Methods(http.MethodPost)
+	r.HandleFunc("/arTransactions/payment/{action:confirm|reject|timeout}", s.handlePaymentAction).Methods(http.MethodPost)
+
+	// control endpoints for tests and local development
+	r.HandleFunc("/__stub/state", s.handleGetState).Methods(http.MethodGet)
+	r.HandleFunc("/__stub/scenario", s.handlePutScenario).Methods(http.MethodPut)
+	r.HandleFunc("/__stub/faults", s.handlePostFault).Methods(http.MethodPost)
+	r.HandleFunc("/__stub/faults", s.handleDeleteFaults).Methods(http.MethodDelete)
+	s.router = r
+
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Load replaces the scenario served by the stub and clears all account locks and payments
+func (s *Server) Load(scenario *Scenario) error {
+	if err := scenario.Validate(); err != nil {
+		return err
+	}
+
+	customers := make(map[string]*Customer, len(scenario.Customers))
+	for i := range scenario.Customers {
+		customer := scenario.Customers[i]
+		customer.Transactions = append([]Transaction(nil), customer.Transactions...)
+		customers[accountKey(customer.CompanyCode, customer.CustomerCode)] = &customer
+	}
+	faults := make([]*Fault, 0, len(scenario.Faults))
+	for i := range scenario.Faults {
+		fault := scenario.Faults[i]
+		faults = append(faults, &fault)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.scenario = scenario
+	s.customers = customers
+	s.locks = map[string]string{}
+	s.payments = map[string]*Payment{}
+	s.faults = faults
+
+	log.Info("E5 stub loaded scenario", log.Data{"scenario": scenario.Name, "customers": len(customers), "faults": len(faults)})
+	return nil
+}
+
+// AddFault injects a fault into the requests to an endpoint. Faults are matched in the order they are added.
+func (s *Server) AddFault(fault Fault) error {
+	if err := fault.Validate(); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.faults = append(s.faults, &fault)
+	return nil
+}
+
+// ClearFaults removes every injected fault
+func (s *Server) ClearFaults() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.faults = nil
+}
+
+// State returns a snapshot of the account locks, payments and remaining faults
+func (s *Server) State() State {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	state := State{
+		Scenario: s.scenario.Name,
+		Locks:    make(map[string]string, len(s.locks)),
+		Payments: make(map[string]*Payment, len(s.payments)),
+		Faults:   make([]Fault, 0, len(s.faults)),
+	}
+	for k, v := range s.locks {
+		state.Locks[k] = v
+	}
+	for k, v := range s.payments {
+		payment := *v
+		state.Payments[k] = &payment
+	}
+	for _, f := range s.faults {
+		state.Faults = append(state.Faults, *f)
+	}
+	return state
+}
+
+func (s *Server) handleTransactions(w http.ResponseWriter, r *http.Request) {
+	customerCode := mux.Vars(r)["customer_code"]
+	if s.injectFault(w, r, TransactionsEndpoint, customerCode) {
+		return
+	}
+	s.writeTransactions(w, r, customerCode)
+}
+
+func (s *Server) handleCompanyTransactions(w http.ResponseWriter, r *http.Request) {
+	if s.injectFault(w, r, CompanyTransactionsEndpoint, "") {
+		return
+	}
+	s.writeTransactions(w, r, "")
+}
+
+// writeTransactions writes a page of the transactions of the customer, or of every customer in the company code when
+// no customer code is given
+func (s *Server) writeTransactions(w http.ResponseWriter, r *http.Request, customerCode string) {
+	query := r.URL.Query()
+	companyCode := query.Get("companyCode")
+	transactionType := query.Get("transactionType")
+	transactionSubType := query.Get("transactionSubType")
+	ledgerCode := query.Get("ledgerCode")
+
+	var subErrors []subError
+	if companyCode == "" {
+		subErrors = append(subErrors, subError{Field: "companyCode", Message: "must not be null"})
+	}
+	if transactionSubType != "" && transactionType == "" {
+		subErrors = append(subErrors, subError{Field: "transactionType", Message: "must not be null when transactionSubType is provided"})
+	}
+	from, fromErr := parseDate(query.Get("fromDate"))
+	if fromErr != nil {
+		subErrors = append(subErrors, subError{Field: "fromDate", RejectedValue: query.Get("fromDate"), Message: "must be a date"})
+	}
+	to, toErr := parseDate(query.Get("toDate"))
+	if toErr != nil {
+		subErrors = append(subErrors, subError{Field: "toDate", RejectedValue: query.Get("toDate"), Message: "must be a date"})
+	}
+	pageNumber, err := strconv.Atoi(query.Get("pageNumber"))
+	if query.Get("pageNumber") == "" {
+		pageNumber = 0
+	} else if err != nil || pageNumber < 0 {
+		subErrors = append(subErrors, subError{Field: "pageNumber", RejectedValue: query.Get("pageNumber"), Message: "must be a positive number"})
+	}
+	if len(subErrors) > 0 {
+		writeError(w, http.StatusBadRequest, "", "Constraint Validation error", subErrors)
+		return
+	}
+
+	s.mtx.Lock()
+	pageSize := s.scenario.PageSize
+	var matched []e5.Transaction
+	companyFound := false
+	for _, customer := range s.sortedCustomers() {
+		if customer.CompanyCode != companyCode {
+			continue
+		}
+		companyFound = true
+		if customerCode != "" && customer.CustomerCode != customerCode {
+			continue
+		}
+		for _, t := range customer.Transactions {
+			if ledgerCode != "" && t.LedgerCode != ledgerCode {
+				continue
+			}
+			if transactionType != "" && t.TransactionType != transactionType {
+				continue
+			}
+			if transactionSubType != "" && t.TransactionSubType != transactionSubType {
+				continue
+			}
+			if !inDateRange(t.TransactionDate, from, to) {
+				continue
+			}
+			matched = append(matched, toE5Transaction(customer, t))
+		}
+	}
+	s.mtx.Unlock()
+
+	if !companyFound {
+		writeError(w, http.StatusNotFound, "", "Company code not found", nil)
+		return
+	}
+
+	totalPages := int(math.Ceil(float64(len(matched)) / float64(pageSize)))
+	start := pageNumber * pageSize
+	end := start + pageSize
+	if start > len(matched) {
+		start = len(matched)
+	}
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	writeJSON(w, http.StatusOK, e5.GetTransactionsResponse{
+		Page: e5.Page{
+			Size:          end - start,
+			TotalElements: len(matched),
+			TotalPages:    totalPages,
+			Number:        pageNumber,
+		},
+		Transactions: append([]e5.Transaction{}, matched[start:end]...),
+	})
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var input e5.CreatePaymentInput
+	if !decodeBody(w, r, &input) {
+		return
+	}
+	if s.injectFault(w, r, CreateEndpoint, input.CustomerCode) {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	key := accountKey(input.CompanyCode, input.CustomerCode)
+	customer, ok := s.customers[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, "", "Customer account not found", nil)
+		return
+	}
+	if _, exists := s.payments[input.PaymentID]; exists {
+		writeError(w, http.StatusBadRequest, "", "Payment ID already used",
+			[]subError{{Field: "paymentId", RejectedValue: input.PaymentID, Message: "must be unique"}})
+		return
+	}
+	if lockedBy, locked := s.locks[key]; locked {
+		writeError(w, http.StatusBadRequest, "", "Customer account is locked by payment "+lockedBy, nil)
+		return
+	}
+
+	var subErrors []subError
+	total := 0.0
+	for _, pt := range input.Transactions {
+		t := findTransaction(customer, pt.TransactionReference)
+		switch {
+		case t == nil:
+			subErrors = append(subErrors, subError{Field: "transactionReference", RejectedValue: pt.TransactionReference, Message: "transaction not found"})
+		case t.IsPaid || pt.Value > t.OutstandingAmount:
+			subErrors = append(subErrors, subError{Field: "allocationValue", RejectedValue: fmt.Sprintf("%g", pt.Value), Message: "exceeds outstanding amount"})
+		}
+		total += pt.Value
+	}
+	if len(subErrors) == 0 && math.Abs(total-input.TotalValue) > 0.001 {
+		subErrors = append(subErrors, subError{Field: "paymentValue", RejectedValue: fmt.Sprintf("%g", input.TotalValue), Message: "does not match the allocation values"})
+	}
+	if len(subErrors) > 0 {
+		writeError(w, http.StatusBadRequest, "", "Constraint Validation error", subErrors)
+		return
+	}
+
+	s.payments[input.PaymentID] = &Payment{
+		PaymentID:    input.PaymentID,
+		CompanyCode:  input.CompanyCode,
+		CustomerCode: input.CustomerCode,
+		PaymentValue: input.TotalValue,
+		Transactions: input.Transactions,
+		Status:       PaymentCreated,
+	}
+	s.locks[key] = input.PaymentID
+
+	log.Info("E5 stub created payment", log.Data{"payment_id": input.PaymentID, "customer_code": input.CustomerCode})
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleAuthorise(w http.ResponseWriter, r *http.Request) {
+	var input e5.AuthorisePaymentInput
+	if !decodeBody(w, r, &input) {
+		return
+	}
+	if s.injectFault(w, r, AuthoriseEndpoint, s.paymentCustomerCode(input.PaymentID)) {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	payment, ok := s.payments[input.PaymentID]
+	if !ok || payment.CompanyCode != input.CompanyCode {
+		writeError(w, http.StatusNotFound, "", "Payment not found", nil)
+		return
+	}
+	if payment.Status != PaymentCreated {
+		writeError(w, http.StatusBadRequest, "", "Payment is "+payment.Status, nil)
+		return
+	}
+
+	payment.Status = PaymentAuthorised
+	log.Info("E5 stub authorised payment", log.Data{"payment_id": input.PaymentID})
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handlePaymentAction(w http.ResponseWriter, r *http.Request) {
+	action := mux.Vars(r)["action"]
+
+	var input e5.PaymentActionInput
+	if !decodeBody(w, r, &input) {
+		return
+	}
+	if s.injectFault(w, r, action, s.paymentCustomerCode(input.PaymentID)) {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	payment, ok := s.payments[input.PaymentID]
+	if !ok || payment.CompanyCode != input.CompanyCode {
+		writeError(w, http.StatusNotFound, "", "Payment not found", nil)
+		return
+	}
+	if payment.Status != PaymentCreated && payment.Status != PaymentAuthorised {
+		writeError(w, http.StatusBadRequest, "", "Payment is "+payment.Status, nil)
+		return
+	}
+
+	switch action {
+	case ConfirmEndpoint:
+		if payment.Status != PaymentAuthorised {
+			writeError(w, http.StatusBadRequest, "", "Payment has not been authorised", nil)
+			return
+		}
+		customer := s.customers[accountKey(payment.CompanyCode, payment.CustomerCode)]
+		for _, pt := range payment.Transactions {
+			if t := findTransaction(customer, pt.TransactionReference); t != nil {
+				t.OutstandingAmount = math.Max(0, t.OutstandingAmount-pt.Value)
+				t.IsPaid = t.OutstandingAmount == 0
+			}
+		}
+		payment.Status = PaymentConfirmed
+	case RejectEndpoint:
+		payment.Status = PaymentRejected
+	case TimeoutEndpoint:
+		payment.Status = PaymentTimedOut
+	}
+
+	delete(s.locks, accountKey(payment.CompanyCode, payment.CustomerCode))
+	log.Info("E5 stub "+action+" payment", log.Data{"payment_id": input.PaymentID, "status": payment.Status})
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleGetState(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.State())
+}
+
+func (s *Server) handlePutScenario(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scenario, err := ParseScenario(body)
+	if err == nil {
+		err = s.Load(scenario)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handlePostFault(w http.ResponseWriter, r *http.Request) {
+	var fault Fault
+	if err := json.NewDecoder(r.Body).Decode(&fault); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.AddFault(fault); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDeleteFaults(w http.ResponseWriter, _ *http.Request) {
+	s.ClearFaults()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// injectFault applies the first fault matching the endpoint and customer. It returns true if an error response has
+// been written.
+func (s *Server) injectFault(w http.ResponseWriter, r *http.Request, endpoint, customerCode string) bool {
+	fault := s.takeFault(endpoint, customerCode)
+	if fault == nil {
+		return false
+	}
+
+	if fault.latency > 0 {
+		select {
+		case <-time.After(fault.latency):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+	if fault.Status == 0 {
+		return false
+	}
+
+	message := fault.Message
+	if message == "" {
+		message = "Injected fault"
+	}
+	log.Info("E5 stub injecting fault", log.Data{"endpoint": endpoint, "customer_code": customerCode, "status": fault.Status})
+	writeError(w, fault.Status, fault.MessageCode, message, nil)
+	return true
+}
+
+func (s *Server) takeFault(endpoint, customerCode string) *Fault {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for i, f := range s.faults {
+		if f.Endpoint != endpoint || (f.CustomerCode != "" && f.CustomerCode != customerCode) {
+			continue
+		}
+		fault := *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return &fault
+	}
+	return nil
+}
+
+func (s *Server) paymentCustomerCode(paymentID string) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if payment, ok := s.payments[paymentID]; ok {
+		return payment.CustomerCode
+	}
+	return ""
+}
+
+// sortedCustomers returns the customers in scenario order so that pages are stable
+func (s *Server) sortedCustomers() []*Customer {
+	customers := make([]*Customer, 0, len(s.scenario.Customers))
+	for _, c := range s.scenario.Customers {
+		customers = append(customers, s.customers[accountKey(c.CompanyCode, c.CustomerCode)])
+	}
+	return customers
+}
+
+func findTransaction(customer *Customer, reference string) *Transaction {
+	for i := range customer.Transactions {
+		if customer.Transactions[i].TransactionReference == reference {
+			return &customer.Transactions[i]
+		}
+	}
+	return nil
+}
+
+func toE5Transaction(customer *Customer, t Transaction) e5.Transaction {
+	return e5.Transaction{
+		CompanyCode:          customer.CompanyCode,
+		LedgerCode:           t.LedgerCode,
+		CustomerCode:         customer.CustomerCode,
+		TransactionReference: t.TransactionReference,
+		TransactionDate:      t.TransactionDate,
+		MadeUpDate:           t.MadeUpDate,
+		Amount:               t.Amount,
+		OutstandingAmount:    t.OutstandingAmount,
+		IsPaid:               t.IsPaid,
+		TransactionType:      t.TransactionType,
+		TransactionSubType:   t.TransactionSubType,
+		TypeDescription:      t.TypeDescription,
+		DueDate:              t.DueDate,
+		AccountStatus:        t.AccountStatus,
+		DunningStatus:        t.DunningStatus,
+	}
+}
+
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(dateLayout, value)
+}
+
+func inDateRange(date string, from, to time.Time) bool {
+	d, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return true
+	}
+	return (from.IsZero() || !d.Before(from)) && (to.IsZero() || !d.After(to))
+}
+
+// decodeBody reads the JSON request body and validates it the same way as the E5 client. It returns false if an
+// error response has been written.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "", "Malformed JSON request", nil)
+		return false
+	}
+
+	err := validator.New().Struct(v)
+	if validationErrs, ok := err.(validator.ValidationErrors); ok {
+		subErrors := make([]subError, 0, len(validationErrs))
+		for _, fieldErr := range validationErrs {
+			subErrors = append(subErrors, subError{
+				Object:        fieldErr.StructNamespace(),
+				Field:         fieldErr.Field(),
+				RejectedValue: fmt.Sprintf("%v", fieldErr.Value()),
+				Message:       "failed on the " + fieldErr.Tag() + " rule",
+			})
+		}
+		writeError(w, http.StatusBadRequest, "", "Constraint Validation error", subErrors)
+		return false
+	}
+
+	return true
+}
+
+type subError struct {
+	Object        string `json:"object"`
+	Field         string `json:"field"`
+	RejectedValue string `json:"rejectedValue"`
+	Message       string `json:"message"`
+}
+
+type errorResponse struct {
+	Code         int        `json:"httpStatusCode"`
+	Status       string     `json:"status"`
+	Timestamp    string     `json:"timestamp"`
+	MessageCode  *string    `json:"messageCode"`
+	Message      string     `json:"message"`
+	DebugMessage *string    `json:"debugMessage"`
+	SubErrors    []subError `json:"subErrors,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, messageCode, message string, subErrors []subError) {
+	resp := errorResponse{
+		Code:      status,
+		Status:    http.StatusText(status),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Message:   message,
+		SubErrors: subErrors,
+	}
+	if messageCode != "" {
+		resp.MessageCode = &messageCode
+	}
+	writeJSON(w, status, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error(fmt.Errorf("E5 stub error writing response: %v", err))
+	}
+}
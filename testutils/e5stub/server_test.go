@@ -0,0 +1,283 @@
+package e5stub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/companieshouse/penalty-payment-api/common/e5"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testScenario = `
+name: test
+page_size: 2
+customers:
+  - company_code: LP
+    customer_code: "10000024"
+    transactions:
+      - ledger_code: EW
+        transaction_reference: A1000001
+        transaction_date: "2025-01-10"
+        made_up_date: "2024-03-31"
+        amount: 150
+        outstanding_amount: 150
+        transaction_type: "1"
+        transaction_sub_type: EJ
+        type_description: Penalty Ltd Wel & Eng <=1m LFP
+        due_date: "2025-02-10"
+      - ledger_code: EW
+        transaction_reference: A1000002
+        transaction_date: "2025-02-10"
+        made_up_date: "2024-04-30"
+        amount: 375
+        outstanding_amount: 375
+        transaction_type: "1"
+        transaction_sub_type: EJ
+        type_description: Penalty Ltd Wel & Eng <=1m LFP
+        due_date: "2025-03-10"
+      - ledger_code: EW
+        transaction_reference: A1000003
+        transaction_date: "2025-03-10"
+        made_up_date: "2024-05-31"
+        amount: 750
+        outstanding_amount: 750
+        transaction_type: "1"
+        transaction_sub_type: EJ
+        type_description: Penalty Ltd Wel & Eng <=1m LFP
+        due_date: "2025-04-10"
+  - company_code: LP
+    customer_code: "10000025"
+    transactions: []
+`
+
+func newTestClient(t *testing.T) (*Server, e5.ClientInterface, func()) {
+	scenario, err := ParseScenario([]byte(testScenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub, err := NewServer(scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(stub)
+	return stub, e5.NewClient("stub", server.URL, nil), server.Close
+}
+
+func TestUnitLoadScenarios(t *testing.T) {
+	Convey("Given a directory of scenario files", t, func() {
+		dir := t.TempDir()
+		So(os.WriteFile(filepath.Join(dir, "a.yml"), []byte(testScenario), 0o600), ShouldBeNil)
+		So(os.WriteFile(filepath.Join(dir, "b.yaml"), []byte(`
+customers:
+  - company_code: C1
+    customer_code: "OE000001"
+faults:
+  - endpoint: create
+    status: 500
+`), 0o600), ShouldBeNil)
+		So(os.WriteFile(filepath.Join(dir, "README.md"), []byte("ignored"), 0o600), ShouldBeNil)
+
+		Convey("Then the scenarios are merged", func() {
+			scenario, err := LoadScenarios(dir)
+
+			So(err, ShouldBeNil)
+			So(scenario.PageSize, ShouldEqual, 2)
+			So(scenario.Customers, ShouldHaveLength, 3)
+			So(scenario.Faults, ShouldHaveLength, 1)
+		})
+
+		Convey("Then a customer defined twice is rejected", func() {
+			So(os.WriteFile(filepath.Join(dir, "c.yml"), []byte(testScenario), 0o600), ShouldBeNil)
+
+			_, err := LoadScenarios(dir)
+
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given invalid faults", t, func() {
+		_, err := ParseScenario([]byte("faults:\n  - endpoint: unknown\n    status: 500\n"))
+		So(err, ShouldNotBeNil)
+
+		_, err = ParseScenario([]byte("faults:\n  - endpoint: create\n    status: 503\n"))
+		So(err, ShouldNotBeNil)
+
+		_, err = ParseScenario([]byte("faults:\n  - endpoint: create\n    latency: soon\n"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestUnitStubTransactions(t *testing.T) {
+	Convey("Given the stub is serving a scenario", t, func() {
+		_, client, closeServer := newTestClient(t)
+		defer closeServer()
+		ctx := context.Background()
+
+		Convey("Then every page of a customer's transactions is returned", func() {
+			resp, err := client.GetTransactions(ctx, &e5.GetTransactionsInput{CompanyCode: "LP", CustomerCode: "10000024"}, "")
+
+			So(err, ShouldBeNil)
+			So(resp.Transactions, ShouldHaveLength, 3)
+			So(resp.Page.TotalPages, ShouldEqual, 2)
+			So(resp.Transactions[0].CustomerCode, ShouldEqual, "10000024")
+		})
+
+		Convey("Then transactions are filtered by date", func() {
+			resp, err := client.GetTransactions(ctx, &e5.GetTransactionsInput{
+				CompanyCode: "LP", CustomerCode: "10000024", FromDate: "2025-02-01", ToDate: "2025-02-28",
+			}, "")
+
+			So(err, ShouldBeNil)
+			So(resp.Transactions, ShouldHaveLength, 1)
+			So(resp.Transactions[0].TransactionReference, ShouldEqual, "A1000002")
+		})
+
+		Convey("Then an unknown company code is not found", func() {
+			_, err := client.GetTransactions(ctx, &e5.GetTransactionsInput{CompanyCode: "XX", CustomerCode: "10000024"}, "")
+
+			So(errors.Is(err, e5.ErrE5NotFound), ShouldBeTrue)
+		})
+
+		Convey("Then the transactions of every customer in the company code are returned", func() {
+			var transactions []e5.Transaction
+			err := client.GetCompanyTransactions(ctx, &e5.GetCompanyTransactionsInput{CompanyCode: "LP", FromDate: "2025-01-01"},
+				func(page *e5.GetTransactionsResponse) error {
+					transactions = append(transactions, page.Transactions...)
+					return nil
+				}, "")
+
+			So(err, ShouldBeNil)
+			So(transactions, ShouldHaveLength, 3)
+		})
+	})
+}
+
+func TestUnitStubPayments(t *testing.T) {
+	Convey("Given the stub is serving a scenario", t, func() {
+		stub, client, closeServer := newTestClient(t)
+		defer closeServer()
+		ctx := context.Background()
+
+		create := &e5.CreatePaymentInput{
+			CompanyCode:  "LP",
+			CustomerCode: "10000024",
+			PaymentID:    "XP1",
+			TotalValue:   150,
+			Transactions: []*e5.CreatePaymentTransaction{{TransactionReference: "A1000001", Value: 150}},
+		}
+		authorise := &e5.AuthorisePaymentInput{CompanyCode: "LP", PaymentID: "XP1", Email: "test@example.com"}
+		action := &e5.PaymentActionInput{CompanyCode: "LP", PaymentID: "XP1"}
+
+		Convey("When a payment is created, authorised and confirmed", func() {
+			So(client.CreatePayment(ctx, create, ""), ShouldBeNil)
+			So(stub.State().Locks, ShouldContainKey, "LP/10000024")
+			So(client.AuthorisePayment(ctx, authorise, ""), ShouldBeNil)
+			So(client.ConfirmPayment(ctx, action, ""), ShouldBeNil)
+
+			Convey("Then the transaction is paid and the account unlocked", func() {
+				state := stub.State()
+				So(state.Locks, ShouldBeEmpty)
+				So(state.Payments["XP1"].Status, ShouldEqual, PaymentConfirmed)
+
+				resp, err := client.GetTransactions(ctx, &e5.GetTransactionsInput{CompanyCode: "LP", CustomerCode: "10000024"}, "")
+				So(err, ShouldBeNil)
+				So(resp.Transactions[0].IsPaid, ShouldBeTrue)
+				So(resp.Transactions[0].OutstandingAmount, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a second payment is created against a locked account", func() {
+			So(client.CreatePayment(ctx, create, ""), ShouldBeNil)
+			second := *create
+			second.PaymentID = "XP2"
+
+			Convey("Then it is rejected until the first payment times out", func() {
+				So(errors.Is(client.CreatePayment(ctx, &second, ""), e5.ErrE5BadRequest), ShouldBeTrue)
+
+				So(client.TimeoutPayment(ctx, action, ""), ShouldBeNil)
+				So(client.CreatePayment(ctx, &second, ""), ShouldBeNil)
+			})
+		})
+
+		Convey("Then a payment cannot be confirmed before it is authorised", func() {
+			So(client.CreatePayment(ctx, create, ""), ShouldBeNil)
+			So(errors.Is(client.ConfirmPayment(ctx, action, ""), e5.ErrE5BadRequest), ShouldBeTrue)
+		})
+
+		Convey("Then an unknown payment is not found", func() {
+			So(errors.Is(client.RejectPayment(ctx, action, ""), e5.ErrE5NotFound), ShouldBeTrue)
+		})
+
+		Convey("Then an allocation over the outstanding amount is rejected", func() {
+			create.TotalValue = 200
+			create.Transactions[0].Value = 200
+			So(errors.Is(client.CreatePayment(ctx, create, ""), e5.ErrE5BadRequest), ShouldBeTrue)
+		})
+	})
+}
+
+func TestUnitStubFaults(t *testing.T) {
+	Convey("Given the stub is serving a scenario", t, func() {
+		stub, client, closeServer := newTestClient(t)
+		defer closeServer()
+		ctx := context.Background()
+		input := &e5.GetTransactionsInput{CompanyCode: "LP", CustomerCode: "10000024"}
+
+		Convey("When a fault is injected a limited number of times", func() {
+			So(stub.AddFault(Fault{Endpoint: TransactionsEndpoint, Status: http.StatusInternalServerError, Times: 1}), ShouldBeNil)
+
+			Convey("Then only that many requests fail", func() {
+				_, err := client.GetTransactions(ctx, input, "")
+				So(errors.Is(err, e5.ErrE5InternalServer), ShouldBeTrue)
+
+				_, err = client.GetTransactions(ctx, input, "")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When a fault is injected for another customer", func() {
+			So(stub.AddFault(Fault{Endpoint: TransactionsEndpoint, CustomerCode: "10000025", Status: http.StatusNotFound}), ShouldBeNil)
+
+			Convey("Then requests for this customer succeed", func() {
+				_, err := client.GetTransactions(ctx, input, "")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When latency is injected", func() {
+			So(stub.AddFault(Fault{Endpoint: TransactionsEndpoint, Latency: "1s"}), ShouldBeNil)
+
+			Convey("Then the client gives up when its context expires", func() {
+				ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+				defer cancel()
+
+				_, err := client.GetTransactions(ctx, input, "")
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When faults are added and cleared through the control endpoints", func() {
+			server := httptest.NewServer(stub)
+			defer server.Close()
+
+			resp, err := http.Post(server.URL+"/__stub/faults", "application/json",
+				strings.NewReader(`{"endpoint":"create","status":400,"message_code":"LOCKED"}`))
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+			So(stub.State().Faults, ShouldHaveLength, 1)
+
+			req, _ := http.NewRequest(http.MethodDelete, server.URL+"/__stub/faults", nil)
+			resp, err = http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+			So(stub.State().Faults, ShouldBeEmpty)
+		})
+	})
+}
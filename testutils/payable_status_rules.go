@@ -0,0 +1,15 @@
+//coverage:ignore file
+
+package testutils
+
+import (
+	"path/filepath"
+	"runtime"
+)
+
+// PayableStatusRulesFile is the path of the payable status rules deployed with the API, which tests evaluate the
+// payable status of transactions with wherever they are run from
+func PayableStatusRulesFile() string {
+	_, fileName, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(fileName), "..", "assets", "payable_status_rules.yml")
+}
@@ -0,0 +1,17 @@
+//coverage:ignore file
+
+package testutils
+
+// The penalty types in assets/penalty_details.yml that tests refer to by name. The API looks every penalty type up
+// through the penalty type registry, so these are not needed to onboard a new penalty type.
+const (
+	LateFilingPenaltyCompanyCode = "LP"
+	SanctionsCompanyCode         = "C1"
+	LateFilingPenaltyRefType     = "LATE_FILING"
+	SanctionsPenaltyRefType      = "SANCTIONS"
+	SanctionsRoePenaltyRefType   = "SANCTIONS_ROE"
+
+	LateFilingPenaltyReferencePrefix   = "A"
+	SanctionsPenaltyReferencePrefix    = "P"
+	SanctionsRoePenaltyReferencePrefix = "U"
+)